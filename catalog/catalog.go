@@ -0,0 +1,261 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultPath is the catalog file used when GOLAP_CATALOG is not set
+const DefaultPath = ".golap_catalog.json"
+
+// Catalog stores named objects (tables and views) that queries can
+// reference by name. It is persisted as a JSON file so definitions
+// survive between runs.
+type Catalog struct {
+	Tables map[string]Table `json:"tables"`
+	Views  map[string]View  `json:"views"`
+
+	path string
+}
+
+// Table maps a logical name to a data file
+// With a primary key the table is merge-on-read: rows sharing a key are
+// collapsed at scan time, keeping the one with the highest sequence value.
+type Table struct {
+	Name           string   `json:"name"`
+	Path           string   `json:"path"`                      // Relative paths resolve against the catalog's directory
+	Columns        []Column `json:"columns,omitempty"`         // Optional declared types, overriding inference
+	Delimiter      string   `json:"delimiter,omitempty"`       // CSV field separator; detected when empty
+	NoHeader       bool     `json:"no_header,omitempty"`       // First line is data; Columns (in order) name the fields
+	Encoding       string   `json:"encoding,omitempty"`        // Text encoding: latin1, windows-1252; utf-8 when empty
+	NullValues     []string `json:"null_values,omitempty"`     // Field values read as NULL, e.g. NA
+	Strict         bool     `json:"strict,omitempty"`          // Fail on fields that don't parse as their column's type
+	SampleRows     int      `json:"sample_rows,omitempty"`     // Rows sampled to infer column types; the default when 0
+	PrimaryKey     []string `json:"primary_key,omitempty"`     // Optional key columns for merge-on-read
+	SequenceColumn string   `json:"sequence_column,omitempty"` // Orders versions of a key; required with PrimaryKey
+}
+
+// Column declares the type (or, for headerless files, the name) of one
+// column of a registered table
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"` // Int, Float, or String; inferred when empty
+}
+
+// View is a named query that expands to its definition at plan time
+type View struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// Path returns the catalog file location: $GOLAP_CATALOG or DefaultPath
+func Path() string {
+	if p := os.Getenv("GOLAP_CATALOG"); p != "" {
+		return p
+	}
+	return DefaultPath
+}
+
+// Load reads the catalog at path; a missing file yields an empty catalog
+func Load(path string) (*Catalog, error) {
+	c := &Catalog{
+		Tables: make(map[string]Table),
+		Views:  make(map[string]View),
+		path:   path,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+	if c.Tables == nil {
+		c.Tables = make(map[string]Table)
+	}
+	if c.Views == nil {
+		c.Views = make(map[string]View)
+	}
+
+	return c, nil
+}
+
+// Save writes the catalog back to its file (temp file, then rename)
+func (c *Catalog) Save() error {
+	// Keep SQL readable in the file (no \u003e escapes for < and >)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return fmt.Errorf("failed to marshal catalog: %w", err)
+	}
+	data := buf.Bytes()
+
+	tempFile, err := os.CreateTemp(filepath.Dir(c.path), ".golap_catalog.*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp catalog file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // No-op after a successful rename
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to save catalog: %w", err)
+	}
+	return nil
+}
+
+// Table looks up a registered table by name (case-insensitive)
+func (c *Catalog) Table(name string) (Table, bool) {
+	t, ok := c.Tables[normalizeName(name)]
+	return t, ok
+}
+
+// RegisterTable adds a table; replace allows overwriting an existing entry
+// Names must not collide with views, since both are resolved in FROM
+func (c *Catalog) RegisterTable(table Table, replace bool) error {
+	key := normalizeName(table.Name)
+	if key == "" {
+		return fmt.Errorf("table name required")
+	}
+	if table.Path == "" {
+		return fmt.Errorf("table path required")
+	}
+	if len(table.PrimaryKey) > 0 && table.SequenceColumn == "" {
+		return fmt.Errorf("table %s: a sequence column is required with a primary key", table.Name)
+	}
+	if _, exists := c.Views[key]; exists {
+		return fmt.Errorf("a view with this name already exists: %s", table.Name)
+	}
+	if _, exists := c.Tables[key]; exists && !replace {
+		return fmt.Errorf("table already exists: %s", table.Name)
+	}
+	c.Tables[key] = table
+	return nil
+}
+
+// DropTable removes a table registration (the data file is untouched)
+func (c *Catalog) DropTable(name string, ifExists bool) error {
+	key := normalizeName(name)
+	if _, exists := c.Tables[key]; !exists {
+		if ifExists {
+			return nil
+		}
+		return fmt.Errorf("table not found: %s", name)
+	}
+	delete(c.Tables, key)
+	return nil
+}
+
+// ResolvePath returns a table's file path, interpreting relative paths
+// against the directory that holds the catalog file
+func (c *Catalog) ResolvePath(table Table) string {
+	if filepath.IsAbs(table.Path) || isURL(table.Path) {
+		return table.Path
+	}
+	return filepath.Join(filepath.Dir(c.path), table.Path)
+}
+
+// RelativePath converts a path given relative to the working directory into
+// the form stored in the catalog: relative to the catalog's directory
+func (c *Catalog) RelativePath(path string) (string, error) {
+	if filepath.IsAbs(path) || isURL(path) {
+		return path, nil
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	catalogDir, err := filepath.Abs(filepath.Dir(c.path))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(catalogDir, absPath)
+	if err != nil {
+		return absPath, nil // Different volume; fall back to absolute
+	}
+	return rel, nil
+}
+
+// isURL reports whether a path is a URL (s3://bucket/key) rather than a
+// file system path
+func isURL(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// TableNames returns registered table names in sorted order
+func (c *Catalog) TableNames() []string {
+	names := make([]string, 0, len(c.Tables))
+	for key := range c.Tables {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ViewNames returns view names in sorted order
+func (c *Catalog) ViewNames() []string {
+	names := make([]string, 0, len(c.Views))
+	for key := range c.Views {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// View looks up a view by name (case-insensitive)
+func (c *Catalog) View(name string) (View, bool) {
+	v, ok := c.Views[normalizeName(name)]
+	return v, ok
+}
+
+// CreateView adds a view; replace allows overwriting an existing definition
+func (c *Catalog) CreateView(name, query string, replace bool) error {
+	key := normalizeName(name)
+	if key == "" {
+		return fmt.Errorf("view name required")
+	}
+	if _, exists := c.Tables[key]; exists {
+		return fmt.Errorf("a table with this name already exists: %s", name)
+	}
+	if _, exists := c.Views[key]; exists && !replace {
+		return fmt.Errorf("view already exists: %s", name)
+	}
+	c.Views[key] = View{Name: name, Query: query}
+	return nil
+}
+
+// DropView removes a view; ifExists suppresses the error for missing views
+func (c *Catalog) DropView(name string, ifExists bool) error {
+	key := normalizeName(name)
+	if _, exists := c.Views[key]; !exists {
+		if ifExists {
+			return nil
+		}
+		return fmt.Errorf("view not found: %s", name)
+	}
+	delete(c.Views, key)
+	return nil
+}
+
+// normalizeName makes catalog lookups case-insensitive like SQL identifiers
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
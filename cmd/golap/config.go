@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// localConfigFile is the project's config file, in the working directory
+const localConfigFile = ".golap.toml"
+
+// configFiles returns the config files to load, in order: the user's,
+// then the project's, whose settings win
+func configFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".golap", "config.toml"))
+	}
+	return append(files, localConfigFile)
+}
+
+// applyConfig sets the global flags the command line didn't from the
+// config files, returning the flags the command line set. A key is a
+// flag's name, e.g. temp-dir = "/data/tmp" or sort-memory = "64MB";
+// catalog = "path" sets $GOLAP_CATALOG unless it is set already. Missing
+// files are skipped.
+func applyConfig(files []string) (setOnCommandLine map[string]bool, err error) {
+	setOnCommandLine = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	settings := make(map[string]configSetting)
+	var order []string
+	for _, path := range files {
+		values, err := readConfig(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, setting := range values {
+			if _, ok := settings[setting.key]; !ok {
+				order = append(order, setting.key)
+			}
+			settings[setting.key] = setting
+		}
+	}
+
+	for _, key := range order {
+		setting := settings[key]
+		if key == "catalog" {
+			if os.Getenv("GOLAP_CATALOG") == "" {
+				os.Setenv("GOLAP_CATALOG", setting.value)
+			}
+			continue
+		}
+		if flag.Lookup(key) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q (settings are the global flags' names, and catalog)", setting.path, key)
+		}
+		if setOnCommandLine[key] {
+			continue
+		}
+		if err := flag.Set(key, setting.value); err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %v", setting.path, key, err)
+		}
+	}
+	return setOnCommandLine, nil
+}
+
+// configSetting is a top-level key = value of a config file
+type configSetting struct {
+	key, value string
+	path       string
+}
+
+// readConfig reads a TOML config file's top-level keys, in file order.
+// Values are strings, numbers, booleans or arrays of those, given to the
+// flags as text (arrays joined with commas, as -null-values takes them).
+// Tables ([section]) aren't supported.
+func readConfig(path string) ([]configSetting, error) {
+	var values map[string]interface{}
+	meta, err := toml.DecodeFile(path, &values)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%s:%d: %s", path, parseErr.Position.Line, parseErr.Message)
+		}
+		return nil, err
+	}
+
+	var settings []configSetting
+	for _, key := range meta.Keys() {
+		if len(key) != 1 {
+			continue // Inside a table, rejected below
+		}
+		value, err := configValue(values[key[0]])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, key[0], err)
+		}
+		settings = append(settings, configSetting{key: key[0], value: value, path: path})
+	}
+	return settings, nil
+}
+
+// configValue converts a TOML value into the text its flag takes
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return "", fmt.Errorf("nested arrays are not supported")
+			}
+			text, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}, []map[string]interface{}:
+		return "", fmt.Errorf("tables are not supported; set the flags at the top level")
+	}
+	return "", fmt.Errorf("unsupported value %v (use a string)", v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/internal/sqlcompare"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// difftestSource matches the sources a query reads: a quoted path, a
+// name, or a call like read_csv(...)
+var difftestSource = regexp.MustCompile("(?i)\\bFROM\\s+(`[^`]+`|\"[^\"]+\"|[A-Za-z_][\\w.]*(?:\\s*\\([^()]*(?:\\{[^{}]*\\}[^()]*)*\\))?)")
+
+// runDifftest runs a SELECT through golap and through an embedded,
+// in-memory SQLite database, with every source it reads loaded into SQLite
+// as golap reads it, and prints how the results differ. It returns whether
+// they do. Rows are compared in order only when the query has an ORDER BY,
+// and numbers with a relative tolerance of 1e-9.
+func runDifftest(query string, opts engine.Options) (bool, error) {
+	if fields := strings.Fields(query); len(fields) == 0 || !strings.EqualFold(fields[0], "SELECT") {
+		return false, fmt.Errorf("only SELECT queries can be compared")
+	}
+	db, err := sqlcompare.Open()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	// Load each source into a table of its own, as golap reads it, so the
+	// two databases query the same values
+	other := query
+	tables := map[string]string{}
+	for _, m := range difftestSource.FindAllStringSubmatch(query, -1) {
+		ref := m[1]
+		if _, ok := tables[ref]; ok {
+			continue
+		}
+		table := fmt.Sprintf("t%d", len(tables)+1)
+		tables[ref] = table
+		if err := loadDifftestSource(db, ref, table, opts); err != nil {
+			return false, err
+		}
+		other = regexp.MustCompile(`(?i)(\bFROM\s+)`+regexp.QuoteMeta(ref)).ReplaceAllString(other, "${1}"+table)
+	}
+	if len(tables) == 0 {
+		return false, fmt.Errorf("the query reads no sources")
+	}
+	// golap quotes identifiers in backticks; use the standard quotes
+	other = regexp.MustCompile("`([^`]*)`").ReplaceAllStringFunc(other, func(ident string) string {
+		return sqlcompare.QuoteIdent(ident[1 : len(ident)-1])
+	})
+
+	golapOpts, cancel := withTimeout(opts)
+	defer cancel()
+	got, golapErr := sqlcompare.Golap(query, golapOpts)
+	want, sqliteErr := sqlcompare.Query(db, other)
+
+	fmt.Printf("golap:  %s\nsqlite: %s\n\n", query, other)
+	switch {
+	case golapErr != nil && sqliteErr != nil:
+		fmt.Printf("Both fail\n  golap: %v\n  sqlite: %v\n", golapErr, sqliteErr)
+		return false, nil
+	case golapErr != nil:
+		fmt.Printf("DIFFERENT: golap fails: %v\nsqlite returns %d rows\n", golapErr, len(want))
+		return true, nil
+	case sqliteErr != nil:
+		fmt.Printf("DIFFERENT: sqlite fails: %v\ngolap returns %d rows\n", sqliteErr, len(got))
+		return true, nil
+	}
+
+	ordered := regexp.MustCompile(`(?i)\bORDER\s+BY\b`).MatchString(query)
+	diffs := sqlcompare.Diff(got, want, ordered)
+	if len(diffs) == 0 {
+		fmt.Printf("Same %d rows\n", len(got))
+		return false, nil
+	}
+	order := "sorted, as the query has no ORDER BY"
+	if ordered {
+		order = "in order"
+	}
+	fmt.Printf("DIFFERENT: golap returns %d rows, sqlite %d; compared %s:\n", len(got), len(want), order)
+	const maxDiffs = 20
+	for _, diff := range diffs[:min(len(diffs), maxDiffs)] {
+		fmt.Println("  " + diff)
+	}
+	if len(diffs) > maxDiffs {
+		fmt.Printf("  ... and %d more\n", len(diffs)-maxDiffs)
+	}
+	return true, nil
+}
+
+// loadDifftestSource creates a table in db with a source's columns, typed
+// as golap infers them, holding its rows
+func loadDifftestSource(db *sql.DB, ref, table string, opts engine.Options) error {
+	op, err := engine.ParseAndPlanWithOptions("SELECT * FROM "+ref, opts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ref, err)
+	}
+	defer op.Close()
+	var row *types.Row
+	err = sqlcompare.Load(db, table, op.Schema(), func() ([]interface{}, error) {
+		types.ReleaseRow(row) // Load is done with the previous row
+		row, err = op.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+		}
+		if row == nil {
+			return nil, nil
+		}
+		return row.Values, nil
+	})
+	types.ReleaseRow(row)
+	return err
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// outputFormats are the values of -format
+var outputFormats = []string{"table", "csv", "tsv", "json", "jsonl", "markdown", "vertical"}
+
+// outputFormat is set by -format
+var outputFormat = "table"
+
+// parseOutputFormat checks a -format value
+func parseOutputFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	for _, f := range outputFormats {
+		if format == f {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q (expected %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// readableFormat reports whether a format is for people rather than
+// programs; only those are followed by a row count
+func readableFormat(format string) bool {
+	return format == "table" || format == "markdown" || format == "vertical"
+}
+
+// resultWriter prints a query's rows in an output format
+type resultWriter interface {
+	// write prints a row
+	write(values []interface{}) error
+	// finish ends the output (the closing bracket of a JSON array, ...)
+	// and flushes it
+	finish() error
+}
+
+// newResultWriter returns a writer printing rows of schema in format,
+// having printed whatever comes before the first row
+func newResultWriter(w io.Writer, format string, schema types.Schema) (resultWriter, error) {
+	switch format {
+	case "csv", "tsv":
+		out := &delimitedWriter{csv: csv.NewWriter(w), fields: make([]string, len(schema.Columns))}
+		if format == "tsv" {
+			out.csv.Comma = '\t'
+		}
+		return out, out.csv.Write(schema.Columns)
+	case "json", "jsonl":
+		out := &jsonWriter{w: bufio.NewWriter(w), array: format == "json"}
+		for _, col := range schema.Columns {
+			name, _ := json.Marshal(col)
+			out.columns = append(out.columns, string(name)+":")
+		}
+		if out.array {
+			out.w.WriteString("[")
+		}
+		return out, nil
+	case "markdown":
+		out := &markdownWriter{w: bufio.NewWriter(w), cells: make([]string, len(schema.Columns))}
+		for i, col := range schema.Columns {
+			out.cells[i] = markdownCell(col)
+		}
+		out.line()
+		for i := range out.cells {
+			out.cells[i] = "---"
+		}
+		return out, out.line()
+	case "vertical":
+		out := &verticalWriter{w: bufio.NewWriter(w), columns: schema.Columns}
+		for _, col := range schema.Columns {
+			out.width = max(out.width, len(col))
+		}
+		return out, nil
+	default:
+		return newTableWriter(w, schema), nil
+	}
+}
+
+// delimitedWriter prints CSV or TSV with a header row, quoting fields that
+// hold the delimiter, quotes or line breaks; NULL is an empty field, as
+// COPY writes it
+type delimitedWriter struct {
+	csv    *csv.Writer
+	fields []string
+}
+
+func (out *delimitedWriter) write(values []interface{}) error {
+	for i, v := range values {
+		out.fields[i] = formatField(v)
+	}
+	return out.csv.Write(out.fields)
+}
+
+func (out *delimitedWriter) finish() error {
+	out.csv.Flush()
+	return out.csv.Error()
+}
+
+// jsonWriter prints an object per row keyed by column name, as a JSON
+// array or as JSON lines
+type jsonWriter struct {
+	w       *bufio.Writer
+	array   bool
+	columns []string // Each column's quoted name and ':'
+	rows    int
+}
+
+func (out *jsonWriter) write(values []interface{}) error {
+	if out.array {
+		if out.rows > 0 {
+			out.w.WriteByte(',')
+		}
+		out.w.WriteByte('\n')
+	}
+	out.rows++
+	out.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			out.w.WriteByte(',')
+		}
+		out.w.WriteString(out.columns[i])
+		if err := writeJSONValue(out.w, v); err != nil {
+			return err
+		}
+	}
+	out.w.WriteByte('}')
+	if !out.array {
+		out.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (out *jsonWriter) finish() error {
+	if out.array {
+		if out.rows > 0 {
+			out.w.WriteByte('\n')
+		}
+		out.w.WriteString("]\n")
+	}
+	return out.w.Flush()
+}
+
+// markdownWriter prints a GitHub-flavored Markdown table
+type markdownWriter struct {
+	w     *bufio.Writer
+	cells []string
+}
+
+func (out *markdownWriter) write(values []interface{}) error {
+	for i, v := range values {
+		out.cells[i] = markdownCell(displayValue(v))
+	}
+	return out.line()
+}
+
+// line prints the cells as a table line
+func (out *markdownWriter) line() error {
+	_, err := fmt.Fprintf(out.w, "| %s |\n", strings.Join(out.cells, " | "))
+	return err
+}
+
+func (out *markdownWriter) finish() error {
+	return out.w.Flush()
+}
+
+// verticalWriter prints each row as a block of "column: value" lines,
+// for rows too wide to read across
+type verticalWriter struct {
+	w       *bufio.Writer
+	columns []string
+	width   int // Of the longest column name
+	rows    int
+}
+
+func (out *verticalWriter) write(values []interface{}) error {
+	out.rows++
+	fmt.Fprintf(out.w, "*************************** %d. row ***************************\n", out.rows)
+	for i, v := range values {
+		fmt.Fprintf(out.w, "%*s: %s\n", out.width, out.columns[i], displayValue(v))
+	}
+	return nil
+}
+
+func (out *verticalWriter) finish() error {
+	return out.w.Flush()
+}
+
+// nullText is set by -null; nil prints NULL as NULL, except as an empty
+// CSV or TSV field
+var nullText *string
+
+// numbers is how every format prints numbers, set by -float-precision,
+// -thousands, -sci-above and -sci-below
+var numbers = numberFormat{precision: -1, sciAbove: 1e21, sciBelow: 1e-7}
+
+// numberFormat says how to print numbers
+type numberFormat struct {
+	precision int     // Digits after the point of floats; -1 = as few as read back the same
+	thousands string  // Separates groups of three integer digits; "" = none
+	sciAbove  float64 // Floats at least this large in magnitude use e notation; 0 = never
+	sciBelow  float64 // Non-zero floats smaller in magnitude than this use e notation; 0 = never
+}
+
+func (nf numberFormat) formatInt(v int64) string {
+	return nf.group(strconv.FormatInt(v, 10))
+}
+
+func (nf numberFormat) formatFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if abs := math.Abs(v); abs != 0 && (nf.sciAbove > 0 && abs >= nf.sciAbove || nf.sciBelow > 0 && abs < nf.sciBelow) {
+		return strconv.FormatFloat(v, 'e', nf.precision, 64)
+	}
+	return nf.group(strconv.FormatFloat(v, 'f', nf.precision, 64))
+}
+
+// group inserts the thousands separator into a number's integer digits
+func (nf numberFormat) group(s string) string {
+	sign, digits := "", s
+	if strings.HasPrefix(s, "-") {
+		sign, digits = "-", s[1:]
+	}
+	end := strings.IndexByte(digits, '.')
+	if end < 0 {
+		end = len(digits)
+	}
+	if nf.thousands == "" || end <= 3 {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	for i := 0; i < end; i++ {
+		if i > 0 && (end-i)%3 == 0 {
+			b.WriteString(nf.thousands)
+		}
+		b.WriteByte(digits[i])
+	}
+	b.WriteString(digits[end:])
+	return b.String()
+}
+
+// displayValue formats a value for people: NULL as NULL (or -null)
+func displayValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		if nullText != nil {
+			return *nullText
+		}
+		return "NULL"
+	case int64:
+		return numbers.formatInt(v)
+	case float64:
+		return numbers.formatFloat(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// displayCell is displayValue with tabs and line breaks escaped, so a
+// value can't break the table's columns or rows
+func displayCell(v interface{}) string {
+	s := displayValue(v)
+	if strings.ContainsAny(s, "\t\n\r") {
+		s = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+	}
+	return s
+}
+
+// markdownCell escapes a value for a Markdown table cell
+func markdownCell(s string) string {
+	if strings.ContainsAny(s, "|\n\r") {
+		s = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>").Replace(s)
+	}
+	return s
+}
+
+// formatField formats a value as a CSV or TSV field; NULL is an empty
+// field (as COPY writes it) unless -null says otherwise
+func formatField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		if nullText != nil {
+			return *nullText
+		}
+		return ""
+	case string:
+		return v
+	case int64:
+		return numbers.formatInt(v)
+	case float64:
+		return numbers.formatFloat(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeJSONValue encodes a value as JSON; NaN and infinities, which JSON
+// can't represent, become null like NULL does. Numbers follow numbers
+// but for the thousands separator, which would make them text.
+func writeJSONValue(w *bufio.Writer, v interface{}) error {
+	jsonNumbers := numbers
+	jsonNumbers.thousands = ""
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case int64:
+		w.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			w.WriteString("null")
+		} else {
+			w.WriteString(jsonNumbers.formatFloat(v))
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.Write(data)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// logger records what golap decides and works around (see
+// engine.Options.Logger), set up from -log-level and -log-format
+var logger = slog.New(slog.DiscardHandler)
+
+// newLogger returns a logger writing records at level or above to w, as
+// key=value text or JSON lines
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q (use debug, info, warn or error)", level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: minLevel}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q (use text or json)", format)
+	}
+}
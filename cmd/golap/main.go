@@ -0,0 +1,1331 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/server"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+func main() {
+	// Parse flags
+	sortMemory := flag.String("sort-memory", "", "Memory for rows ORDER BY holds before spilling a sorted run, e.g. 64MB (default: 1MB)")
+	sortChunkSize := flag.Int("sort-chunk-size", 0, "Deprecated: use -sort-memory. Rows per sorted run instead of a byte budget")
+	scriptFile := flag.String("f", "", "Execute the semicolon-separated statements in a SQL file")
+	readBufferSize := flag.Int("read-buffer-size", operators.DefaultReadBufferSize, "Read buffer size in bytes for each CSV scan")
+	mmapFiles := flag.Bool("mmap", false, "Read local data files through a memory mapping instead of read calls")
+	scanWorkers := flag.Int("scan-workers", runtime.GOMAXPROCS(0), "Goroutines parsing a large local CSV file in parallel (1 = sequential)")
+	tempQuota := flag.String("temp-quota", "", "Max temp space one query may use for spilling, e.g. 500MB (default: unlimited)")
+	tempDir := flag.String("temp-dir", "", "Directory for spill files (default: $"+operators.TempDirEnv+", else the system temp directory)")
+	spillCompression := flag.String("spill-compression", "", "Compress spill files: none (default), lz4 or snappy")
+	columnNames := flag.String("column-names", "", "How column references match names: exact (default), case-insensitive, or normalized (also ignoring surrounding whitespace, with spaces, _ and - alike)")
+	relaxedColumns := flag.Bool("relaxed-columns", false, "Same as -column-names=normalized")
+	collation := flag.String("collation", "", "How text compares in WHERE, ORDER BY, GROUP BY, DISTINCT, UNION, MIN and MAX: binary (default), nocase, or a language tag such as en or de")
+	noHeader := flag.Bool("no-header", false, "Treat the first line of CSV files as data; columns are named col0..colN")
+	delimiter := flag.String("delimiter", "", "CSV field separator, e.g. tab, '|' or ';' (default: detect from the header)")
+	distinctMemoryRows := flag.Int("distinct-memory-rows", operators.DefaultDistinctMemoryRows, "Distinct rows DISTINCT/UNION keep in memory before spilling")
+	aggregateMemory := flag.String("aggregate-memory", "", "Memory GROUP BY groups may take before spilling, e.g. 1GB (default: 256MB)")
+	approxDistinct := flag.Bool("approx-distinct", false, "Use a fixed-memory Bloom filter for DISTINCT/UNION (may drop a few distinct rows)")
+	sortGroups := flag.Bool("sort-groups", false, "Return GROUP BY results sorted by the GROUP BY columns")
+	sampleRows := flag.Int("sample-rows", operators.DefaultSampleRows, "Rows read from each file to infer column types")
+	pageSize := flag.Int("page-size", 0, "Return at most N rows of a plain filter/project query, then a token for the next page")
+	pageToken := flag.String("page-token", "", "Continue a paged query from the token printed by the previous page")
+	nullValues := flag.String("null-values", "", "Comma-separated CSV values read as NULL in any column, e.g. NA,\\N (an empty item means empty text fields)")
+	strict := flag.Bool("strict", false, "Fail on CSV values that don't parse as their column's type instead of reading 0")
+	raggedRows := flag.String("ragged-rows", "", "CSV rows with the wrong number of fields: error (default), skip or pad (missing fields NULL, extra ones dropped)")
+	schema := flag.String("schema", "", "Column types overriding inference, e.g. id:INT,zip:VARCHAR")
+	encoding := flag.String("encoding", "", "Text encoding of CSV files: utf-8 (default), latin1 or windows-1252")
+	httpCache := flag.String("http-cache", "", "Directory to cache http(s):// downloads in, revalidated on each query (default: no cache)")
+	statsFile := flag.String("stats", "", "Append each query's per-operator stats (rows, time, spill, peak memory) to FILE as JSON lines")
+	timeout := flag.Duration("timeout", 0, "Stop each query that runs longer than this, e.g. 30s or 5m (default: no limit)")
+	staleZoneMaps := flag.String("stale-zone-maps", "", "What to do with a zone map made before its file changed: warn (default) or ignore, either way not using it, or regenerate")
+	format := flag.String("format", "table", "Result format: "+strings.Join(outputFormats, ", "))
+	box := flag.Bool("box", false, "Draw the table format's borders with box-drawing characters")
+	maxWidth := flag.Int("max-width", tableMaxWidth, "Widest a table column gets; longer values are cut with … (0 = no limit)")
+	nullFlag := flag.String("null", "", "How results show NULL (default: NULL, or an empty CSV/TSV field)")
+	floatPrecision := flag.Int("float-precision", -1, "Digits after the decimal point of floats (default: as many as needed)")
+	thousands := flag.String("thousands", "", "Separator between groups of thousands in numbers, e.g. ',' or _ (default: none)")
+	sciAbove := flag.Float64("sci-above", numbers.sciAbove, "Print floats at least this large in magnitude in scientific notation (0 = never)")
+	sciBelow := flag.Float64("sci-below", numbers.sciBelow, "Print non-zero floats smaller than this in magnitude in scientific notation (0 = never)")
+	outputFile := flag.String("output", "", "Write results to FILE instead of stdout, in the format its extension implies (.csv, .json, ...; .gz compresses)")
+	timing := flag.Bool("timing", false, "Print each query's planning and execution time, rows/s and bytes scanned to stderr")
+	verbose := flag.Bool("v", false, "Print each query's plan, pruning decisions and per-operator stats (rows, time, spill) to stderr")
+	logLevel := flag.String("log-level", "warn", "Log records at this level or above to stderr: debug (pruning and spill decisions), info (each query's outcome), warn or error (default warn, or debug with -v)")
+	logFormat := flag.String("log-format", "text", "Log records as text (key=value) or json")
+	allowRoots := flag.String("allow-root", "", "Comma-separated directories (or URL prefixes like s3://bucket/dir/) queries may read and write files under; others fail (default: anywhere)")
+	allowSchemes := flag.String("allow-scheme", "", "Comma-separated URL schemes and connectors queries may read from anywhere, e.g. s3,https,postgres (with -allow-root; default: all if -allow-root isn't set)")
+	verifyPruning := flag.Bool("verify-pruning", false, "Debug: run each SELECT with and without zone map/partition pruning and fail if the results differ")
+	flag.Parse()
+	commandLine, err := applyConfig(configFiles())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: config: %v\n", err)
+		os.Exit(1)
+	}
+
+	level := *logLevel
+	if *verbose && !flagSet(flag.CommandLine, "log-level") {
+		level = "debug"
+	}
+	logger, err = newLogger(os.Stderr, level, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := engine.DefaultOptions()
+	opts.Logger = logger
+	if *sortMemory != "" {
+		memory, err := parseByteSize(*sortMemory)
+		if err == nil && memory == 0 {
+			err = fmt.Errorf("must be more than 0 bytes")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -sort-memory: %v\n", err)
+			os.Exit(1)
+		}
+		opts.SortMemoryBytes = memory
+	}
+	if *sortChunkSize > 0 {
+		logger.Warn("-sort-chunk-size is deprecated and counts rows; use -sort-memory (e.g. -sort-memory=64MB)")
+		opts.SortChunkSize = *sortChunkSize
+	}
+	if *relaxedColumns {
+		opts.ColumnNames = types.NormalizedColumnNames
+	}
+	if *columnNames != "" {
+		mode, err := types.ParseColumnNameMode(*columnNames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -column-names: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ColumnNames = mode
+	}
+	if *collation != "" {
+		c, err := operators.ParseCollation(*collation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -collation: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Collation = c
+	}
+	opts.ReadBufferSize = *readBufferSize
+	opts.ScanWorkers = *scanWorkers
+	opts.MmapFiles = *mmapFiles
+	opts.NoHeader = *noHeader
+	opts.DistinctMemoryRows = *distinctMemoryRows
+	if *aggregateMemory != "" {
+		memory, err := parseByteSize(*aggregateMemory)
+		if err == nil && memory == 0 {
+			err = fmt.Errorf("must be more than 0 bytes")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -aggregate-memory: %v\n", err)
+			os.Exit(1)
+		}
+		opts.AggregateMemoryBytes = memory
+	}
+	opts.SampleRows = *sampleRows
+	opts.StrictParse = *strict
+	if *raggedRows != "" {
+		policy, err := operators.ParseRaggedRowPolicy(*raggedRows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -ragged-rows: %v\n", err)
+			os.Exit(1)
+		}
+		opts.RaggedRows = policy
+	}
+	if *nullValues != "" {
+		opts.NullValues = strings.Split(*nullValues, ",")
+	}
+	opts.ApproxDistinct = *approxDistinct
+	opts.SortGroups = *sortGroups
+	if *encoding != "" {
+		if _, err := operators.ParseEncoding(*encoding); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -encoding: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Encoding = *encoding
+	}
+	if *delimiter != "" {
+		delim, err := operators.ParseDelimiter(*delimiter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -delimiter: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Delimiter = delim
+	}
+	if *schema != "" {
+		_, columnTypes, err := engine.ParseColumnTypes(*schema)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -schema: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ColumnTypes = columnTypes
+	}
+	if *tempQuota != "" {
+		quota, err := parseByteSize(*tempQuota)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -temp-quota: %v\n", err)
+			os.Exit(1)
+		}
+		opts.TempSpaceQuota = quota
+	}
+	if *tempDir != "" {
+		if info, err := os.Stat(*tempDir); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: invalid -temp-dir: %s is not a directory\n", *tempDir)
+			os.Exit(1)
+		}
+		opts.TempDir = *tempDir
+	}
+	if *spillCompression != "" {
+		compression, err := operators.ParseSpillCompression(*spillCompression)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -spill-compression: %v\n", err)
+			os.Exit(1)
+		}
+		opts.SpillCompression = compression
+	}
+
+	if *staleZoneMaps != "" {
+		policy, err := engine.ParseStaleZoneMapPolicy(*staleZoneMaps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -stale-zone-maps: %v\n", err)
+			os.Exit(1)
+		}
+		opts.StaleZoneMaps = policy
+	}
+
+	if *allowRoots != "" {
+		for _, root := range strings.Split(*allowRoots, ",") {
+			root = strings.TrimSpace(root)
+			if !strings.Contains(root, "://") {
+				// Against the working directory now, not serve -dir's
+				if root, err = filepath.Abs(root); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: invalid -allow-root: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			opts.AllowedRoots = append(opts.AllowedRoots, root)
+		}
+	}
+	if *allowSchemes != "" {
+		for _, scheme := range strings.Split(*allowSchemes, ",") {
+			opts.AllowedSchemes = append(opts.AllowedSchemes, strings.TrimSpace(scheme))
+		}
+	}
+
+	if *timeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: invalid -timeout: must not be negative")
+		os.Exit(1)
+	}
+	queryTimeout = *timeout
+	opts.Context = interruptContext()
+
+	verifyPruningMode = *verifyPruning
+	timingMode = *timing
+	verboseMode = *verbose
+	outputFormat, err = parseOutputFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -format: %v\n", err)
+		os.Exit(1)
+	}
+	tableBox = *box
+	if *maxWidth < 0 {
+		fmt.Fprintln(os.Stderr, "Error: invalid -max-width: must not be negative")
+		os.Exit(1)
+	}
+	tableMaxWidth = *maxWidth
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "null" {
+			nullText = nullFlag
+		}
+	})
+	if *floatPrecision < -1 {
+		fmt.Fprintln(os.Stderr, "Error: invalid -float-precision: must be -1 or more")
+		os.Exit(1)
+	}
+	if *sciAbove < 0 || *sciBelow < 0 {
+		fmt.Fprintln(os.Stderr, "Error: invalid -sci-above/-sci-below: must not be negative")
+		os.Exit(1)
+	}
+	numbers = numberFormat{precision: *floatPrecision, thousands: *thousands, sciAbove: *sciAbove, sciBelow: *sciBelow}
+	if *outputFile != "" {
+		if !commandLine["format"] { // The extension beats a config file's format
+			if outputFormat, err = outputFileFormat(*outputFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -output: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		outputPath = *outputFile
+	}
+	statsPath = *statsFile
+	storage.SetHTTPCacheDir(*httpCache)
+
+	args := flag.Args()
+
+	if *scriptFile != "" {
+		script, err := os.ReadFile(*scriptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read script: %v\n", err)
+			os.Exit(1)
+		}
+		runScript(string(script), opts)
+		return
+	}
+
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	command := args[0]
+
+	switch command {
+	case "query", "q":
+		if len(args) < 2 {
+			fmt.Println("Error: SQL query required")
+			fmt.Println("Usage: golap query \"SELECT * FROM data.csv\"")
+			os.Exit(1)
+		}
+		query := args[1]
+		if *pageSize > 0 {
+			runPage(query, opts, *pageSize, *pageToken)
+		} else {
+			runScript(query, opts)
+		}
+
+	case "zonemap", "zm":
+		if len(args) < 2 {
+			fmt.Println("Error: CSV file path required")
+			fmt.Println("Usage: golap zonemap data.csv | data/ | 'data/2024-*.csv'")
+			os.Exit(1)
+		}
+		csvPath := args[1]
+		if info, err := os.Stat(csvPath); (err == nil && info.IsDir()) || (err != nil && strings.ContainsAny(csvPath, "*?[")) {
+			generateZoneMaps(csvPath, *scanWorkers)
+			break
+		}
+		generateZoneMap(csvPath)
+
+	case "convert":
+		convertFlags := flag.NewFlagSet("convert", flag.ExitOnError)
+		columns := convertFlags.String("select", "*", "Columns or expressions to keep, as in SELECT")
+		where := convertFlags.String("where", "", "Keep only the rows matching this condition, as in WHERE")
+		convertArgs := parseInterleaved(convertFlags, args[1:])
+		if len(convertArgs) != 2 {
+			fmt.Println("Error: source and target paths required")
+			fmt.Println("Usage: golap convert data.csv data.parquet [-select 'a, b'] [-where 'a > 0']")
+			os.Exit(1)
+		}
+		if strings.ContainsAny(convertArgs[0]+convertArgs[1], "`'") {
+			fmt.Fprintln(os.Stderr, "Error: paths containing quotes or backticks are not supported")
+			os.Exit(1)
+		}
+		query := "SELECT " + *columns + " FROM `" + convertArgs[0] + "`"
+		if *where != "" {
+			query += " WHERE " + *where
+		}
+		runScript("COPY ("+query+") TO '"+convertArgs[1]+"'", opts)
+
+	case "head", "sample":
+		peekFlags := flag.NewFlagSet(command, flag.ExitOnError)
+		n := peekFlags.Int("n", 10, "Rows to print")
+		seed := peekFlags.Int64("seed", 0, "Random seed, for the same sample again (default: a new one each run)")
+		peekArgs := parseInterleaved(peekFlags, args[1:])
+		if len(peekArgs) != 1 {
+			fmt.Println("Error: one file, table or URL required")
+			if command == "head" {
+				fmt.Println("Usage: golap head data.csv [-n 10]")
+			} else {
+				fmt.Println("Usage: golap sample data.csv [-n 10] [-seed 42]")
+			}
+			os.Exit(1)
+		}
+		if *n < 0 {
+			fmt.Fprintln(os.Stderr, "Error: invalid -n: must not be negative")
+			os.Exit(1)
+		}
+		if strings.ContainsAny(peekArgs[0], "`") {
+			fmt.Fprintln(os.Stderr, "Error: paths containing backticks are not supported")
+			os.Exit(1)
+		}
+		query := "SELECT * FROM `" + peekArgs[0] + "`"
+		if command == "head" {
+			runScript(query+" LIMIT "+strconv.Itoa(*n), opts)
+			break
+		}
+		sampleSeed := *seed
+		if !flagSet(peekFlags, "seed") {
+			sampleSeed = time.Now().UnixNano()
+		}
+		runSample(query, *n, sampleSeed, opts)
+
+	case "watch":
+		watchFlags := flag.NewFlagSet("watch", flag.ExitOnError)
+		interval := watchFlags.Duration("interval", time.Second, "How often to check remote files for changes; local files are watched for events")
+		watchArgs := parseInterleaved(watchFlags, args[1:])
+		if len(watchArgs) != 1 {
+			fmt.Println("Error: SQL query required")
+			fmt.Println("Usage: golap watch \"SELECT COUNT(*) FROM `app.log.csv`\" [-interval 1s]")
+			os.Exit(1)
+		}
+		if *interval <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: invalid -interval: must be positive")
+			os.Exit(1)
+		}
+		runWatch(watchArgs[0], *interval, opts)
+
+	case "tpch":
+		if len(args) < 2 || (args[1] != "gen" && args[1] != "run") {
+			fmt.Println("Error: gen or run required")
+			fmt.Println("Usage: golap tpch gen [-scale 1] [-dir tpch]")
+			fmt.Println("       golap tpch run [-dir tpch] [-runs 3] [-query q1,q6]")
+			os.Exit(1)
+		}
+		tpchFlags := flag.NewFlagSet("tpch "+args[1], flag.ExitOnError)
+		tpchDir := tpchFlags.String("dir", "tpch", "Directory of the generated files")
+		var err error
+		if args[1] == "gen" {
+			scale := tpchFlags.Float64("scale", 1, "Scale factor: 1 is 1.5M orders and ~6M line items (~1GB); 0.01 for a quick run")
+			seed := tpchFlags.Int64("seed", 1, "Random seed; the same scale and seed give the same files")
+			tpchFlags.Parse(args[2:])
+			err = runTPCHGen(*tpchDir, *scale, *seed)
+		} else {
+			runs := tpchFlags.Int("runs", 3, "Times to run each query; the best and median time are kept")
+			queries := tpchFlags.String("query", "", "Comma-separated queries to run (default: all of "+strings.Join(tpchQueryNames(), ", ")+")")
+			history := tpchFlags.String("history", "", "JSON lines file the results are appended to and compared with (default: DIR/"+tpchHistoryFile+")")
+			baseline := tpchFlags.Int("baseline", 5, "Compare with the median best time of this many earlier runs")
+			maxSlowdown := tpchFlags.Float64("max-slowdown", 0.2, "Fail if a query is this fraction slower than its baseline")
+			tpchFlags.Parse(args[2:])
+			if *runs < 1 || *baseline < 1 {
+				fmt.Fprintln(os.Stderr, "Error: -runs and -baseline must be at least 1")
+				os.Exit(1)
+			}
+			var only []string
+			if *queries != "" {
+				only = strings.Split(strings.ToLower(*queries), ",")
+			}
+			err = runTPCHRun(*tpchDir, only, *runs, *baseline, *maxSlowdown, *history, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "difftest":
+		diffFlags := flag.NewFlagSet("difftest", flag.ExitOnError)
+		diffArgs := parseInterleaved(diffFlags, args[1:])
+		if len(diffArgs) != 1 {
+			fmt.Println("Error: one query required")
+			fmt.Println("Usage: golap difftest \"SELECT COUNT(age) FROM `people.csv`\"")
+			os.Exit(1)
+		}
+		different, err := runDifftest(diffArgs[0], opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if different {
+			os.Exit(1)
+		}
+
+	case "validate":
+		validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+		maxNullFraction := validateFlags.Float64("max-null-fraction", 1, "Fail if any column has a larger fraction of NULLs")
+		validateArgs := parseInterleaved(validateFlags, args[1:])
+		if len(validateArgs) != 1 {
+			fmt.Println("Error: one CSV file required")
+			fmt.Println("Usage: golap validate data.csv [-max-null-fraction 0.1]")
+			os.Exit(1)
+		}
+		runValidate(validateArgs[0], *maxNullFraction, opts)
+
+	case "materialize":
+		if len(args) < 3 {
+			fmt.Println("Error: query and rollup path required")
+			fmt.Println("Usage: golap materialize \"SELECT category, SUM(amount) FROM `sales.csv` GROUP BY category\" rollup.golap")
+			os.Exit(1)
+		}
+		materialize(args[1], args[2], opts)
+
+	case "index":
+		if len(args) < 2 {
+			fmt.Println("Error: table name required")
+			fmt.Println("Usage: golap index TABLE")
+			os.Exit(1)
+		}
+		updateZoneIndex(args[1], opts)
+
+	case "serve":
+		serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+		addr := serveFlags.String("addr", ":8080", "Address to listen on")
+		dir := serveFlags.String("dir", "", "Data directory relative paths in queries (and the catalog) resolve against (default: the working directory)")
+		maxRows := serveFlags.Int64("max-rows", 0, "Most rows one query returns; the rest are cut off (default: no limit)")
+		maxQuerySize := serveFlags.String("max-query-size", "", "Largest request body, e.g. 64KB (default: 1MB)")
+		allowWrites := serveFlags.Bool("allow-writes", false, "Also run statements that write files or the catalog (COPY, CREATE, DROP, ANALYZE)")
+		jobTTL := serveFlags.Duration("job-ttl", server.DefaultJobTTL, "How long a finished job's results are kept")
+		maxConcurrent := serveFlags.Int("max-concurrent", 0, "Most queries and jobs running at once; the rest wait their turn (default: no limit)")
+		maxQueued := serveFlags.Int("max-queued", 0, "Most queries and jobs waiting to run; past it requests get 503 (default: no limit)")
+		queueTimeout := serveFlags.Duration("queue-timeout", 0, "Longest a query or job waits to run before failing with 503 (default: as long as the request lasts)")
+		apiKeys := serveFlags.String("api-keys", "", "Require an API key (X-API-Key or bearer token) from FILE, one \"PRINCIPAL KEY\" per line")
+		basicAuth := serveFlags.String("basic-auth", "", "Require HTTP basic auth with the users in FILE, one \"USER:PASSWORD\" (or a bcrypt or argon2id hash of it) per line")
+		tlsCert := serveFlags.String("tls-cert", "", "Serve HTTPS with this certificate file (PEM, with -tls-key); reloaded when it changes")
+		tlsKey := serveFlags.String("tls-key", "", "Private key file of -tls-cert (PEM)")
+		auditLog := serveFlags.String("audit-log", "", "Append a JSON line per statement (time, caller, SQL, duration, rows, bytes scanned, outcome) to FILE")
+		queryMemory := serveFlags.String("query-memory", "", "Memory each sort and GROUP BY of a query holds before spilling, e.g. 64MB (default: -sort-memory and -aggregate-memory)")
+		serveFlags.Parse(args[1:])
+		cfg := server.Config{
+			Options:              opts,
+			MaxRows:              *maxRows,
+			Timeout:              queryTimeout,
+			AllowWrites:          *allowWrites,
+			JobTTL:               *jobTTL,
+			MaxConcurrentQueries: *maxConcurrent,
+			MaxQueuedQueries:     *maxQueued,
+			QueueTimeout:         *queueTimeout,
+		}
+		if *queryMemory != "" {
+			memory, err := parseByteSize(*queryMemory)
+			if err == nil && memory == 0 {
+				err = fmt.Errorf("must be more than 0 bytes")
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -query-memory: %v\n", err)
+				os.Exit(1)
+			}
+			cfg.QueryMemory = memory
+		}
+		if *maxQuerySize != "" {
+			size, err := parseByteSize(*maxQuerySize)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -max-query-size: %v\n", err)
+				os.Exit(1)
+			}
+			cfg.MaxQueryBytes = size
+		}
+		cfg.Hooks, err = serveAuth(*apiKeys, *basicAuth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *auditLog != "" {
+			if cfg.Audit, err = server.OpenAuditLog(*auditLog); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -audit-log: %v\n", err)
+				os.Exit(1)
+			}
+			defer cfg.Audit.Close()
+		}
+		var certs *certReloader
+		if *tlsCert != "" || *tlsKey != "" {
+			if *tlsCert == "" || *tlsKey == "" {
+				fmt.Fprintln(os.Stderr, "Error: -tls-cert and -tls-key go together")
+				os.Exit(1)
+			}
+			if certs, err = newCertReloader(*tlsCert, *tlsKey); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -tls-cert/-tls-key: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		serve(opts.Context, *addr, *dir, cfg, certs)
+
+	case "attach":
+		attachFlags := flag.NewFlagSet("attach", flag.ExitOnError)
+		primaryKey := attachFlags.String("primary-key", "", "Comma-separated key columns; keeps the latest row per key")
+		sequence := attachFlags.String("sequence", "", "Column whose highest value is the latest row (with -primary-key)")
+		attachDelimiter := attachFlags.String("delimiter", "", "CSV field separator for this table (default: detect)")
+		attachNoHeader := attachFlags.Bool("no-header", false, "The file has no header line")
+		attachColumns := attachFlags.String("columns", "", "Comma-separated column names for a headerless file")
+		attachSchema := attachFlags.String("schema", "", "Column types for this table, e.g. id:INT,zip:VARCHAR")
+		attachEncoding := attachFlags.String("encoding", "", "Text encoding: utf-8 (default), latin1 or windows-1252")
+		attachNullValues := attachFlags.String("null-values", "", "Comma-separated values read as NULL, e.g. NA,\\N")
+		attachStrict := attachFlags.Bool("strict", false, "Fail queries on values that don't parse as their column's type")
+		attachSampleRows := attachFlags.Int("sample-rows", 0, "Rows sampled to infer column types (default: the -sample-rows of each query)")
+		attachFlags.Parse(args[1:])
+		attachArgs := attachFlags.Args()
+		if len(attachArgs) < 2 {
+			fmt.Println("Error: table name and file path required")
+			fmt.Println("Usage: golap attach [-primary-key id -sequence version] sales ./data/sales.csv")
+			os.Exit(1)
+		}
+		attachOpts := engine.AttachOptions{
+			SequenceColumn: *sequence,
+			Delimiter:      *attachDelimiter,
+			NoHeader:       *attachNoHeader,
+			PrimaryKey:     splitColumnList(*primaryKey),
+			Columns:        splitColumnList(*attachColumns),
+			Encoding:       *attachEncoding,
+			Strict:         *attachStrict,
+			SampleRows:     *attachSampleRows,
+		}
+		if *attachNullValues != "" {
+			attachOpts.NullValues = strings.Split(*attachNullValues, ",")
+		}
+		if *attachSchema != "" {
+			_, columnTypes, err := engine.ParseColumnTypes(*attachSchema)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid -schema: %v\n", err)
+				os.Exit(1)
+			}
+			attachOpts.ColumnTypes = columnTypes
+		}
+		if err := engine.AttachTableWithOptions(attachArgs[0], attachArgs[1], attachOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Attached %s -> %s\n", attachArgs[0], attachArgs[1])
+
+	case "detach":
+		if len(args) < 2 {
+			fmt.Println("Error: table name required")
+			fmt.Println("Usage: golap detach sales")
+			os.Exit(1)
+		}
+		if err := engine.DetachTable(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Detached %s\n", args[1])
+
+	case "analyze":
+		if len(args) < 2 {
+			fmt.Println("Error: CSV file path or table name required")
+			fmt.Println("Usage: golap analyze data.csv ['(country, city)' ...]")
+			os.Exit(1)
+		}
+		runScript("ANALYZE `"+args[1]+"` "+strings.Join(args[2:], ", "), opts)
+
+	case "describe", "desc":
+		if len(args) < 2 {
+			fmt.Println("Error: CSV file path or view name required")
+			fmt.Println("Usage: golap describe data.csv")
+			os.Exit(1)
+		}
+		runScript("DESCRIBE `"+args[1]+"`", opts)
+
+	case "help", "-h", "--help":
+		printUsage()
+
+	default:
+		// Assume it's a direct SQL query
+		query := strings.Join(args, " ")
+		if *pageSize > 0 {
+			runPage(query, opts, *pageSize, *pageToken)
+		} else {
+			runScript(query, opts)
+		}
+	}
+}
+
+func printUsage() {
+	fmt.Println(`GOLAP - Go Serverless OLAP Engine
+
+Usage:
+  golap query "SQL_QUERY"     Execute a SQL query
+  golap zonemap FILE.csv      Generate zone map metadata for a CSV file
+  golap zonemap DIR|GLOB      ...for each CSV file in it, in parallel, plus a
+                              manifest of them all (DIR/.zonemaps.json)
+  golap materialize SQL OUT.golap
+                              Store a GROUP BY over a file as a rollup that
+                              answers the same aggregates until the file changes
+  golap describe FILE.csv     Show columns, inferred types and zone map stats
+  golap head FILE [-n 10]     Print the first rows of a file, table or URL
+  golap sample FILE [-n 10] [-seed N]
+                              Print a random sample of rows, in file order
+  golap watch "SQL" [-interval 1s]
+                              Run a SELECT again whenever the files it reads
+                              change, until Ctrl-C
+  golap validate FILE.csv [-max-null-fraction F]
+                              Report ragged rows, unparseable values, duplicate
+                              headers, encoding problems and NULL fractions;
+                              exits 2 if any are found, 1 if it can't read FILE
+  golap difftest "SQL"        Run a SELECT through golap and an embedded SQLite,
+                              loading the files it reads as golap reads them,
+                              and show rows that differ; exits 1 if any do
+  golap tpch gen [-scale 1]   Generate TPC-H lineitem and orders CSVs in -dir
+  golap tpch run              Time the TPC-H queries golap supports over them,
+                              appending to a history and failing on a slowdown
+                              past -max-slowdown or changed results
+  golap analyze FILE [PAIRS]  Collect column statistics (histograms, most common
+                              values, NULL fractions, widths) for the optimizer
+  golap attach NAME PATH      Register a file under a table name in the catalog
+                              -primary-key COLS -sequence COL: merge-on-read,
+                              keeping only the latest row per key;
+                              -delimiter C: field separator for this table;
+                              -no-header [-columns a,b,c]: headerless file;
+                              -schema, -encoding, -null-values, -strict,
+                              -sample-rows: parsing options for every query
+  golap detach NAME           Remove a table from the catalog
+  golap convert SRC DST [-select COLS] [-where COND]
+                              Rewrite a file or table in another format, picked
+                              by DST's extension: .csv, .golap, .parquet or
+                              .jsonl (.gz compresses CSV and JSON Lines),
+                              keeping only some columns or rows
+  golap index NAME            Build or update a multi-file table's zone index
+                              (only new or changed files are scanned)
+  golap serve [-addr :8080]   Answer POST /query requests over HTTP, streaming
+                              rows as JSON lines or CSV; -dir DIR: resolve
+                              paths against DIR; -max-rows N: cap each
+                              result; -timeout (before serve) limits each query;
+                              -allow-writes: also run COPY, CREATE, ...;
+                              -max-concurrent N, -max-queued N,
+                              -queue-timeout D: admission control;
+                              -query-memory SIZE: cap sort/GROUP BY memory;
+                              -api-keys FILE, -basic-auth FILE: require
+                              credentials; -tls-cert/-tls-key: serve HTTPS;
+                              -audit-log FILE: record every statement;
+                              POST /jobs runs a query in the background
+  golap "SQL_QUERY"           Execute a SQL query (shorthand)
+  golap -f FILE.sql           Execute each statement in a SQL file
+
+Examples:
+  golap query "SELECT * FROM data.csv LIMIT 10"
+  golap "SELECT id, name FROM users.csv WHERE age > 25 ORDER BY age LIMIT 10"
+  golap "SELECT COUNT(*), SUM(amount) FROM sales.csv"
+  golap "SELECT category, SUM(amount) FROM sales.csv GROUP BY category"
+  golap zonemap large_dataset.csv
+  golap "SELECT COUNT(*) FROM a.csv; SELECT COUNT(*) FROM b.csv"
+
+Supported SQL Features:
+  - SELECT columns or * (all columns)
+  - FROM "file.csv" (relative or absolute path)
+  - FROM "data/2024-*.csv" / "logs/" (glob or directory, read as one table)
+  - FROM "s3://bucket/key.csv" (credentials and region from AWS_* variables),
+    "gs://bucket/object.csv" (GOOGLE_* variables) or "az://container/blob.csv"
+    (AZURE_STORAGE_* variables)
+  - FROM "https://example.com/data.csv" (streamed; see -http-cache)
+  - FROM "events/" with key=value subdirectories (e.g. date=2024-01-01/):
+    partition columns, and WHERE date = '...' skips the other directories
+  - FROM read_csv('data.txt', delim=>'|', header=>'false', columns=>'a,b')
+    to set the delimiter, header and column names for one file
+  - FROM "logs.jsonl" / "logs.ndjson" (JSON Lines; nested fields as user.id)
+  - FROM "data.arrow" / "data.feather" / "data.arrows" (Arrow IPC; nested columns skipped)
+  - FROM postgres('postgres://user@host/db', 'schema.table') or
+    mysql('user:pass@tcp(host:3306)/db', 'db.table') (streamed from the database)
+  - WHERE with =, <, >, <=, >=, !=, IS [NOT] NULL, AND, OR and NOT
+  - HAVING on GROUP BY columns and aggregates
+  - ORDER BY column [ASC|DESC]
+  - LIMIT n
+  - SELECT DISTINCT, and UNION [ALL] of SELECTs (ORDER BY/LIMIT apply to
+    the whole union)
+  - GROUP BY column
+  - COPY (SELECT ...) TO 'out.csv' and CREATE TABLE out.csv AS SELECT ...
+    (an out.golap target is written in golap's columnar format, out.parquet
+    as Parquet and out.jsonl as JSON Lines)
+  - FROM "data.golap" (columnar; reads only the referenced columns)
+  - EXPLAIN query (operator tree, row estimates, predicted temp space)
+  - EXPLAIN (ANALYZE, FORMAT JSON|DOT) query (plan for tools or Graphviz)
+  - SHOW TABLES, SHOW SCHEMAS (catalog tables and views, with their columns)
+  - DESCRIBE name / SHOW COLUMNS FROM name (file or view)
+  - ANALYZE name [(a, b), ...] (value statistics for EXPLAIN row estimates,
+    with joint statistics for correlated column pairs)
+  - CREATE [OR REPLACE] VIEW name AS SELECT ..., DROP VIEW [IF EXISTS] name
+  - Aggregates: COUNT, SUM, MIN, MAX, AVG over columns or expressions
+    (+, -, *, /, %, CASE WHEN), e.g. SUM(price * qty)
+  - APPROX_TOP_K(value, k): k most frequent values (JSON array), computed
+    with a bounded-memory sketch in one pass
+  - Expressions in SELECT and WHERE, e.g. SELECT id, price * qty AS total
+  - HASH(a, ...) stable hash and BUCKET(a, n) = HASH(a) mod n, for
+    partitioning and sampling, e.g. WHERE BUCKET(user_id, 4) = 0
+  - HASH_SHA256(a [, salt]), MASK_EMAIL(email) and
+    GENERALIZE_DATE(date [, 'day'|'week'|'month'|'quarter'|'year']) for
+    anonymized extracts, e.g. COPY (SELECT MASK_EMAIL(email) ...) TO 'out.csv'
+  - LATEST_BY(value, ordering): value from the row with the greatest ordering,
+    e.g. SELECT id, LATEST_BY(status, ts) FROM events.csv GROUP BY id
+
+Flags:
+  -sort-memory=SIZE     Memory for rows ORDER BY holds before spilling a
+                        sorted run, measured by encoded row size, e.g. 64MB
+                        (default: 1MB)
+  -sort-chunk-size=N    Deprecated: rows per sorted run instead of a byte
+                        budget; overrides -sort-memory
+                        Larger values use more memory but sort faster
+  -f FILE               Execute semicolon-separated statements from FILE
+  -read-buffer-size=N   Read buffer size in bytes per CSV scan (default: 262144)
+                        Larger buffers mean fewer reads on big sequential files
+  -scan-workers=N       Goroutines parsing a large local CSV file in parallel
+                        (default: number of CPUs; 1 = sequential)
+  -mmap                 Read local data files through a memory mapping
+                        instead of read calls
+  -temp-quota=SIZE      Max temp space per query for spilling (e.g. 500MB, 2GB);
+                        the query fails with an error instead of filling the disk
+  -temp-dir=DIR         Directory for sort, DISTINCT and GROUP BY spill files (default:
+                        $GOLAP_TEMP_DIR, else $TMPDIR or /tmp)
+  -spill-compression=C  Compress spill files: none (default), lz4 or snappy,
+                        which trade a little CPU for less temp space and disk I/O
+  -column-names=MODE    How column references match names: exact (default),
+                        case-insensitive, or normalized, which also ignores
+                        surrounding whitespace and treats spaces, _ and -
+                        alike; exact matches still win, and a reference
+                        matching several columns fails as ambiguous
+  -relaxed-columns      Same as -column-names=normalized
+  -collation=NAME       How text compares in WHERE, ORDER BY, GROUP BY,
+                        DISTINCT, UNION, MIN and MAX: binary (default, byte
+                        by byte), nocase, or a language tag such as en, de
+                        or sv for its rules
+  -no-header            CSV files have no header line; columns are named
+                        col0..colN (or use read_csv(..., columns=>'a,b'))
+  -delimiter=C          CSV field separator: a character or tab, pipe,
+                        semicolon (default: detect from the header line)
+  -distinct-memory-rows=N
+                        Distinct rows DISTINCT/UNION keep in memory before
+                        spilling to temp files (default: 100000)
+  -aggregate-memory=SIZE
+                        Memory GROUP BY's groups may take, by estimate, before
+                        rows of new groups spill to temp files (default: 256MB)
+  -approx-distinct      DISTINCT/UNION use a fixed 8MB Bloom filter instead:
+                        no spill, but a few distinct rows may be dropped
+  -sort-groups          Return GROUP BY results sorted by the GROUP BY
+                        columns instead of in the order groups were first seen
+  -sample-rows=N        Rows read from each file to infer column types; a
+                        type widens Int -> Float -> String to fit them all
+                        (default: 100)
+  -page-size=N          Print at most N rows of a plain filter/project query
+                        over a CSV file, then a token for the next page
+  -page-token=TOKEN     Continue from the previous page; the scan seeks to
+                        where that page stopped instead of starting over
+  -null-values=LIST     Comma-separated CSV values read as NULL in any column,
+                        e.g. NA,NULL,\N; start with a comma to make empty
+                        text fields NULL too (empty numbers always are)
+  -strict               Fail with the line and column of a value that doesn't
+                        parse as its column's type (default: read it as 0)
+  -ragged-rows=POLICY   CSV rows with more or fewer fields than the header:
+                        error (default) fails the query, skip leaves them
+                        out, pad reads missing fields as NULL and drops
+                        extra ones; a warning counts the rows at the end
+  -schema=COL:TYPE,...  Column types overriding inference for every file,
+                        e.g. -schema zip:VARCHAR,amount:FLOAT
+  -encoding=NAME        Text encoding of CSV files: utf-8 (default), latin1
+                        (iso-8859-1) or windows-1252 (cp1252)
+  -http-cache=DIR       Keep http(s):// downloads in DIR; later queries
+                        revalidate them (If-Modified-Since) instead of
+                        downloading again
+  -stats=FILE           Append each query's per-operator stats (rows in and
+                        out, time, temp space, peak memory) to FILE as a
+                        JSON line, e.g. to track benchmarks across versions
+  -timing               Print each query's planning and execution time, rows
+                        scanned per second and bytes read to stderr
+  -v                    Print each query's plan, pruning decisions and
+                        per-operator rows, times and spill to stderr
+  -timeout=DURATION     Stop a query that runs longer than DURATION, e.g. 30s
+                        or 5m; like Ctrl-C, it removes the query's temp files
+  -format=FORMAT        Print results as table (default), csv, tsv, json,
+                        jsonl, markdown or vertical (a block per row)
+  -null=TEXT            Show NULL as TEXT (default: NULL; empty in CSV/TSV)
+  -float-precision=N    Print floats with N digits after the decimal point
+  -thousands=SEP        Group the digits of numbers by thousands with SEP
+  -sci-above=X, -sci-below=X
+                        Print floats of magnitude >= X (default 1e21) or
+                        < X (default 1e-7) in scientific notation; 0 = never
+  -box                  Draw table borders with box-drawing characters
+  -max-width=N          Cut table cells wider than N characters (default 40,
+                        0 = no limit)
+  -output=FILE          Write results to FILE instead of stdout, in the format
+                        its extension implies (.csv, .tsv, .json, .jsonl,
+                        .md, .txt) unless -format is set; .gz compresses
+  -verify-pruning       Debug: run each SELECT with and without zone map and
+                        partition pruning; print the full scan's rows and
+                        fail if the two results differ
+
+Notes:
+  - CSV files must have a header row
+  - Column types are auto-inferred (Int, Float, String) from the first 100
+    rows (see -sample-rows); override them with -schema, a data.schema.json sidecar
+    ({"columns": {"zip": "VARCHAR"}}), a catalog table, or
+    read_csv(..., columns=>{id:'INT', zip:'VARCHAR'})
+  - Empty numeric fields are NULL; comparisons with NULL are UNKNOWN (never match)
+  - Large datasets are sorted using external merge sort (disk-based)
+  - Views and registered tables are stored in .golap_catalog.json (or $GOLAP_CATALOG);
+    FROM names resolve to a view, then a registered table, then a file path
+  - Multiple statements separated by ; run in order; execution stops at the first error
+  - Defaults for the flags above come from ~/.golap/config.toml, then
+    ./.golap.toml (key = value, e.g. temp-dir = "/data/tmp"); flags win`)
+}
+
+// runScript executes each semicolon-separated statement in order,
+// printing a result block per statement
+func runScript(script string, opts engine.Options) {
+	statements, err := engine.SplitStatements(script)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(statements) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no statements to execute")
+		os.Exit(1)
+	}
+
+	for i, stmt := range statements {
+		if i > 0 && outputPath == "" {
+			fmt.Println()
+		}
+		if err := runQuery(stmt, opts); err != nil {
+			discardOutput()
+			if len(statements) > 1 {
+				fmt.Fprintf(os.Stderr, "Error in statement %d: %v\n", i+1, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(1)
+		}
+	}
+	finishOutput()
+}
+
+// verifyPruningMode is set by -verify-pruning
+var verifyPruningMode bool
+
+// statsPath is set by -stats
+var statsPath string
+
+// timingMode is set by -timing
+var timingMode bool
+
+// verboseMode is set by -v
+var verboseMode bool
+
+// queryTimeout is set by -timeout; 0 means no limit
+var queryTimeout time.Duration
+
+// interruptContext returns a context canceled by the first Ctrl-C (or
+// SIGTERM): the running query stops and its Close removes its temp files.
+// A second Ctrl-C kills the process as usual.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel(errors.New("interrupted"))
+	}()
+	return ctx
+}
+
+// withTimeout returns opts with the query's context bounded by -timeout
+func withTimeout(opts engine.Options) (engine.Options, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return opts, func() {}
+	}
+	ctx, cancel := context.WithTimeoutCause(opts.Context, queryTimeout,
+		fmt.Errorf("query timed out after %s", queryTimeout))
+	opts.Context = ctx
+	return opts, cancel
+}
+
+func runQuery(query string, opts engine.Options) error {
+	opts, cancel := withTimeout(opts)
+	defer cancel()
+	var op types.Operator
+	var mismatch *engine.PruningMismatch
+	var err error
+	planStart := time.Now()
+	if verifyPruningMode {
+		op, mismatch, err = engine.VerifyPruning(query, opts)
+	} else {
+		op, err = engine.ParseAndPlanWithOptions(query, opts)
+	}
+	if err != nil {
+		return err
+	}
+	planTime := time.Since(planStart)
+	defer op.Close()
+	if verboseMode {
+		fmt.Fprintln(os.Stderr, "Plan:")
+		for _, line := range operators.ExplainOperator(op).Lines() {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+	}
+	if statsPath != "" || verboseMode {
+		operators.EnableTiming(op)
+	}
+
+	execStart := time.Now()
+	rowCount, err := printRows(op)
+	if err != nil {
+		return err
+	}
+	execTime := time.Since(execStart)
+	if timingMode {
+		printTiming(op, planTime, execTime, rowCount)
+	}
+	if verboseMode {
+		printExecution(op)
+	}
+	if logger.Enabled(context.Background(), slog.LevelInfo) {
+		stats := operators.CollectStats(op)
+		logger.Info("query finished", "sql", query, "rows", rowCount, "plan_time", planTime, "exec_time", execTime,
+			"bytes_read", stats.TotalBytesRead(), "spilled_bytes", stats.TotalSpillBytes())
+	}
+	if outputPath == "" && readableFormat(outputFormat) {
+		fmt.Printf("\n(%d rows)\n", rowCount)
+	}
+	warnRaggedRows(op)
+	if statsPath != "" {
+		if err := appendStats(query, op); err != nil {
+			return err
+		}
+	}
+	if mismatch != nil {
+		// The rows printed are the full scan's; the metadata is wrong
+		return mismatch
+	}
+	return nil
+}
+
+// warnRaggedRows reports the rows with the wrong number of fields that a
+// query's scans skipped or padded (-ragged-rows), once it has run
+func warnRaggedRows(op types.Operator) {
+	if ragged := operators.CollectStats(op).TotalRaggedRows(); ragged > 0 {
+		logger.Warn("skipped or padded rows with the wrong number of fields; golap validate lists them", "rows", ragged)
+	}
+}
+
+// runSample prints a random sample of n rows of a query's result, in the
+// order the query returns them
+func runSample(query string, n int, seed int64, opts engine.Options) {
+	opts, cancel := withTimeout(opts)
+	defer cancel()
+	op, err := engine.ParseAndPlanWithOptions(query, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	sample := operators.NewSampleOp(op, n, seed)
+	defer sample.Close()
+
+	rowCount, err := printRows(sample)
+	if err != nil {
+		discardOutput()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	finishOutput()
+	if outputPath == "" && readableFormat(outputFormat) {
+		fmt.Printf("\n(%d rows)\n", rowCount)
+	}
+	warnRaggedRows(sample)
+}
+
+// parseInterleaved parses a subcommand's flags wherever they appear among
+// its arguments (golap head data.csv -n 20), returning the other arguments
+func parseInterleaved(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// flagSet reports whether a flag was given
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// printTiming prints how long a query took to plan and run, and how much
+// it read, to stderr
+func printTiming(op types.Operator, planTime, execTime time.Duration, rows int) {
+	stats := operators.CollectStats(op)
+	var scanned int64
+	var scannedRows func(s operators.OperatorStats)
+	scannedRows = func(s operators.OperatorStats) {
+		if len(s.Children) == 0 && s.BytesRead > 0 {
+			scanned += s.RowsOut
+		}
+		for _, child := range s.Children {
+			scannedRows(child)
+		}
+	}
+	scannedRows(stats)
+	perSecond := 0.0
+	if execTime > 0 {
+		perSecond = float64(scanned) / execTime.Seconds()
+	}
+	fmt.Fprintf(os.Stderr, "Time: %s planning (parsing included), %s execution; %d rows returned; %d rows scanned (%.0f rows/s), %s read\n",
+		planTime.Round(time.Microsecond), execTime.Round(time.Microsecond), rows, scanned, perSecond, operators.FormatBytes(stats.TotalBytesRead()))
+}
+
+// printExecution prints what each operator of a query did, and how much
+// it spilled, to stderr
+func printExecution(op types.Operator) {
+	stats := operators.CollectStats(op)
+	fmt.Fprintln(os.Stderr, "Executed:")
+	for _, line := range stats.Lines() {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+	if spilled := stats.TotalSpillBytes(); spilled > 0 {
+		fmt.Fprintf(os.Stderr, "Spilled %s to temp files\n", operators.FormatBytes(spilled))
+	} else {
+		fmt.Fprintln(os.Stderr, "Nothing spilled to temp files")
+	}
+}
+
+// appendStats appends a query's operator stats to the -stats file as one
+// JSON line: {"query": ..., "stats": {"operator": ..., "children": [...]}}
+func appendStats(query string, op types.Operator) error {
+	line, err := json.Marshal(struct {
+		Query string                  `json:"query"`
+		Stats operators.OperatorStats `json:"stats"`
+	}{query, operators.CollectStats(op)})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(statsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open -stats file: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write -stats file: %w", err)
+	}
+	return file.Close()
+}
+
+// runPage prints one page of a query, followed by the token for the next
+// page if there is one
+func runPage(query string, opts engine.Options, pageSize int, token string) {
+	opts, cancel := withTimeout(opts)
+	defer cancel()
+	page, err := engine.PlanPage(query, opts, token, pageSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer page.Close()
+
+	rowCount, err := printRows(page)
+	if err == nil {
+		token, err = page.NextToken()
+	}
+	if err != nil {
+		discardOutput()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	finishOutput()
+
+	if outputPath == "" && !readableFormat(outputFormat) {
+		// Keep stdout parseable
+		if token != "" {
+			fmt.Fprintf(os.Stderr, "Next page: -page-token=%s\n", token)
+		}
+		return
+	}
+	if outputPath == "" {
+		fmt.Printf("\n(%d rows)\n", rowCount)
+	}
+	if token != "" {
+		fmt.Printf("Next page: -page-token=%s\n", token)
+	}
+}
+
+// printRows prints every row of an operator to stdout, or the -output
+// file, in the -format, returning the row count
+func printRows(op types.Operator) (int, error) {
+	w, err := resultOutput()
+	if err != nil {
+		return 0, err
+	}
+	out, err := newResultWriter(w, outputFormat, op.Schema())
+	if err != nil {
+		return 0, err
+	}
+	rowCount := 0
+	for {
+		row, err := op.Next()
+		if err != nil {
+			out.finish() // Print the rows before the error
+			return rowCount, fmt.Errorf("error reading row: %w", err)
+		}
+		if row == nil {
+			break
+		}
+		err = out.write(row.Values)
+		types.ReleaseRow(row)
+		if err != nil {
+			return rowCount, err
+		}
+		rowCount++
+	}
+	if output != nil {
+		output.rows += int64(rowCount)
+	}
+	return rowCount, out.finish()
+}
+
+func generateZoneMap(csvPath string) {
+	fmt.Printf("Generating zone map for: %s\n", csvPath)
+
+	zm, extended, err := metadata.UpdateZoneMap(csvPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := metadata.SaveZoneMap(zm); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving zone map: %v\n", err)
+		os.Exit(1)
+	}
+
+	if extended {
+		fmt.Println("Zone map extended over the rows appended since it was generated")
+	} else {
+		fmt.Println("Zone map generated successfully!")
+	}
+	zm.PrintSummary()
+	fmt.Printf("Saved to: %s\n", metadata.ZoneMapPath(csvPath))
+}
+
+// generateZoneMaps maps every CSV file of a directory or glob
+func generateZoneMaps(path string, workers int) {
+	fmt.Printf("Generating zone maps for: %s\n", path)
+
+	update, err := engine.GenerateZoneMaps(path, workers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Mapped %d files (%d rows)", update.Files, update.Rows)
+	if update.Skipped > 0 {
+		fmt.Printf(", skipped %d that aren't CSV", update.Skipped)
+	}
+	fmt.Println()
+	fmt.Printf("Saved manifest to: %s\n", update.Manifest)
+}
+
+// materialize writes a rollup of a GROUP BY query
+func materialize(query, target string, opts engine.Options) {
+	result, err := engine.Materialize(query, target, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Materialized %d groups of %s\n", result.Rows, result.Source)
+	fmt.Printf("Saved to: %s (registered in %s)\n", result.Path, metadata.RollupsPath(result.Source))
+}
+
+// serve answers queries over HTTP until interrupted, then waits for the
+// queries running to finish and stops the jobs
+func serve(ctx context.Context, addr, dir string, cfg server.Config, certs *certReloader) {
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -dir: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	handler := server.New(cfg)
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	if !isLoopback(listener.Addr()) {
+		if cfg.Hooks.Authenticate == nil {
+			logger.Warn("serving beyond localhost without authentication; use -api-keys or -basic-auth", "addr", listener.Addr().String())
+		}
+		if certs == nil {
+			logger.Warn("serving beyond localhost without TLS; use -tls-cert and -tls-key", "addr", listener.Addr().String())
+		}
+		if len(cfg.Options.AllowedRoots) == 0 && len(cfg.Options.AllowedSchemes) == 0 {
+			logger.Warn("serving beyond localhost with queries reading any file; use -allow-root", "addr", listener.Addr().String())
+		}
+	}
+	scheme := "http"
+	if certs != nil {
+		scheme = "https"
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		listener = tls.NewListener(listener, srv.TLSConfig)
+	}
+	fmt.Printf("Serving queries at %s://%s/query\n", scheme, listener.Addr())
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := handler.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// updateZoneIndex builds or refreshes a multi-file table's zone index
+func updateZoneIndex(table string, opts engine.Options) {
+	update, err := engine.UpdateZoneIndex(table, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Indexed %d files (%d scanned, %d reused, %d removed)\n",
+		update.Files, update.Scanned, update.Files-update.Scanned, update.Removed)
+	fmt.Printf("Saved to: %s\n", update.Path)
+}
+
+// parseByteSize parses sizes like "512", "64KB", "500MB" or "2GB"
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 500MB, got %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// splitColumnList parses a comma-separated list of column names
+func splitColumnList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var columns []string
+	for _, col := range strings.Split(list, ",") {
+		columns = append(columns, strings.TrimSpace(col))
+	}
+	return columns
+}
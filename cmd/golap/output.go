@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+)
+
+// outputFileFormats maps -output extensions to the format they imply
+var outputFileFormats = map[string]string{
+	".csv":      "csv",
+	".tsv":      "tsv",
+	".json":     "json",
+	".jsonl":    "jsonl",
+	".ndjson":   "jsonl",
+	".md":       "markdown",
+	".markdown": "markdown",
+	".txt":      "table",
+}
+
+// outputFileFormat returns the format an -output path implies by its
+// extension, ignoring a trailing .gz
+func outputFileFormat(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz")))
+	if format, ok := outputFileFormats[ext]; ok {
+		return format, nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s from its extension; set -format", path)
+}
+
+// outputPath is set by -output; "" prints results to stdout
+var outputPath string
+
+// output is the -output file, created by the first result printed
+var output *resultFile
+
+// resultFile writes results to an -output file. Like COPY, it writes
+// through an operators.AtomicFile, renamed into place only once every
+// statement has succeeded, so a failed or interrupted run leaves any
+// previous file as it was. Targets ending in .gz are gzip-compressed.
+type resultFile struct {
+	*operators.AtomicFile
+	rows int64
+}
+
+// resultOutput returns where results are printed: stdout, or the -output
+// file, which it creates the first time
+func resultOutput() (io.Writer, error) {
+	if outputPath == "" {
+		return os.Stdout, nil
+	}
+	if output == nil {
+		file, err := operators.CreateAtomicFile(outputPath, strings.HasSuffix(strings.ToLower(outputPath), ".gz"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		output = &resultFile{AtomicFile: file}
+	}
+	return output, nil
+}
+
+// finishOutput moves the -output file, if any, into place once the
+// statements have run, exiting on failure
+func finishOutput() {
+	if output == nil {
+		return
+	}
+	if err := output.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d rows to %s\n", output.rows, output.Path())
+	output = nil
+}
+
+// discardOutput removes the unfinished -output file, if any, before
+// golap exits with an error
+func discardOutput() {
+	if output != nil {
+		output.Discard()
+		output = nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aryamaansaha/golap/server"
+)
+
+// serveAuth returns the hooks authenticating golap serve's requests: API
+// keys from one file, basic auth users from another, or both. Neither
+// file lets every request in.
+func serveAuth(apiKeysFile, basicAuthFile string) (server.Hooks, error) {
+	var hooks server.Hooks
+	var authns []server.AuthnFunc
+	var challenges []string
+	if basicAuthFile != "" {
+		users, err := readCredentials(basicAuthFile, ":", "USER:PASSWORD")
+		if err != nil {
+			return hooks, fmt.Errorf("invalid -basic-auth: %w", err)
+		}
+		basic, err := server.BasicAuth(users)
+		if err != nil {
+			return hooks, fmt.Errorf("invalid -basic-auth: %w", err)
+		}
+		authns = append(authns, basic)
+		challenges = append(challenges, `Basic realm="golap"`)
+	}
+	if apiKeysFile != "" {
+		keys, err := readCredentials(apiKeysFile, " ", "PRINCIPAL KEY")
+		if err != nil {
+			return hooks, fmt.Errorf("invalid -api-keys: %w", err)
+		}
+		authns = append(authns, server.APIKeys(keys))
+		challenges = append(challenges, "Bearer")
+	}
+	switch len(authns) {
+	case 0:
+	case 1:
+		hooks.Authenticate = authns[0]
+	default:
+		hooks.Authenticate = server.FirstOf(authns...)
+	}
+	hooks.Challenge = strings.Join(challenges, ", ")
+	return hooks, nil
+}
+
+// readCredentials reads a credentials file: one "name<sep>secret" pair per
+// line, as format shows (blank lines and # comments skipped), returned as
+// name -> secret for basic auth users and secret -> name for API keys
+func readCredentials(path, sep, format string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(line, sep)
+		name, secret = strings.TrimSpace(name), strings.TrimSpace(secret)
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("%s line %d: expected %s", path, lineNo, format)
+		}
+		if sep == ":" {
+			credentials[name] = secret
+		} else {
+			credentials[secret] = name // An API key identifies its principal
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("%s has no credentials", path)
+	}
+	return credentials, nil
+}
+
+// certReloader serves a certificate and key pair from files, loading them
+// again when either changes, so a certificate renewed by certbot or another
+// ACME client is picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest of the two files' when cert was loaded
+	checked time.Time
+}
+
+// newCertReloader loads a certificate and key pair, failing if they don't
+// load now
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, checking the files at
+// most once a minute. A pair that no longer loads keeps the last one.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && time.Since(r.checked) < time.Minute {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	var modTime time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			if r.cert != nil {
+				return r.cert, nil
+			}
+			return nil, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			logger.Warn("cannot reload TLS certificate, keeping the old one", "cert", r.certFile, "error", err)
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// isLoopback reports whether a listener only accepts local connections
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// tableSampleRows is how many rows the table format holds to size its
+// columns before printing; later rows are cut to those widths
+const tableSampleRows = 1000
+
+// tableBox is set by -box
+var tableBox bool
+
+// tableMaxWidth is set by -max-width; 0 never cuts cells
+var tableMaxWidth = 40
+
+// tableWriter prints an aligned table: columns as wide as their widest
+// value among the first tableSampleRows rows (and, if there are others, at
+// most tableMaxWidth), numbers right-aligned, NULL as NULL. Cells wider than their column are
+// cut with "…".
+type tableWriter struct {
+	w       *bufio.Writer
+	header  []string
+	numeric []bool
+	widths  []int
+	sample  [][]string // Rows held until the widths are known
+	started bool
+}
+
+func newTableWriter(w io.Writer, schema types.Schema) *tableWriter {
+	out := &tableWriter{w: bufio.NewWriter(w), header: make([]string, len(schema.Columns)), numeric: make([]bool, len(schema.Columns))}
+	for i, col := range schema.Columns {
+		out.header[i] = displayCell(col)
+		out.numeric[i] = i < len(schema.Types) && (schema.Types[i] == types.Int || schema.Types[i] == types.Float)
+	}
+	return out
+}
+
+func (out *tableWriter) write(values []interface{}) error {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = displayCell(v)
+	}
+	if !out.started {
+		out.sample = append(out.sample, cells)
+		if len(out.sample) < tableSampleRows {
+			return nil
+		}
+		return out.start()
+	}
+	return out.line(cells, false)
+}
+
+func (out *tableWriter) finish() error {
+	if !out.started {
+		if err := out.start(); err != nil {
+			return err
+		}
+	}
+	if tableBox {
+		out.rule("└", "┴", "┘")
+	}
+	return out.w.Flush()
+}
+
+// start sizes the columns from the header and the rows held, and prints
+// them
+func (out *tableWriter) start() error {
+	out.started = true
+	out.widths = make([]int, len(out.header))
+	for i, name := range out.header {
+		out.widths[i] = utf8.RuneCountInString(name)
+	}
+	for _, cells := range out.sample {
+		for i, cell := range cells {
+			out.widths[i] = max(out.widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	if tableMaxWidth > 0 && len(out.widths) > 1 { // A lone column (EXPLAIN's plan) has nothing to make room for
+		for i := range out.widths {
+			out.widths[i] = min(out.widths[i], tableMaxWidth)
+		}
+	}
+
+	if tableBox {
+		out.rule("┌", "┬", "┐")
+		out.line(out.header, true)
+		out.rule("├", "┼", "┤")
+	} else {
+		out.line(out.header, true)
+		out.rule("", "+", "")
+	}
+	for _, cells := range out.sample {
+		if err := out.line(cells, false); err != nil {
+			return err
+		}
+	}
+	out.sample = nil
+	return nil
+}
+
+// line prints a row of cells, padded or cut to the column widths
+func (out *tableWriter) line(cells []string, header bool) error {
+	var b strings.Builder
+	left, sep, right := " ", " | ", ""
+	if tableBox {
+		left, sep, right = "│ ", " │ ", " │"
+	}
+	b.WriteString(left)
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		cell = fitCell(cell, out.widths[i])
+		pad := strings.Repeat(" ", out.widths[i]-utf8.RuneCountInString(cell))
+		if out.numeric[i] && !header {
+			b.WriteString(pad)
+			b.WriteString(cell)
+		} else {
+			b.WriteString(cell)
+			b.WriteString(pad)
+		}
+	}
+	line := b.String() + right
+	if !tableBox {
+		line = strings.TrimRight(line, " ")
+	}
+	_, err := out.w.WriteString(line + "\n")
+	return err
+}
+
+// rule prints a horizontal line across the columns
+func (out *tableWriter) rule(left, cross, right string) {
+	dash := "-"
+	if tableBox {
+		dash = "─"
+	}
+	parts := make([]string, len(out.widths))
+	for i, width := range out.widths {
+		parts[i] = strings.Repeat(dash, width+2)
+	}
+	out.w.WriteString(left + strings.Join(parts, cross) + right + "\n")
+}
+
+// fitCell cuts a cell to width runes, ending it with "…" if cut
+func fitCell(cell string, width int) string {
+	if utf8.RuneCountInString(cell) <= width {
+		return cell
+	}
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(cell)
+	return string(runes[:width-1]) + "…"
+}
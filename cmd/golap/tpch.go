@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// TPC-H generation follows the specification's value ranges and
+// distributions (dbgen's), not its exact output: keys are dense, comments
+// are drawn from a short word list, and the text pools are abridged, so
+// results are comparable between golap runs, not with published ones.
+const (
+	tpchOrdersPerScale = 1_500_000
+	tpchCustPerScale   = 150_000
+	tpchPartsPerScale  = 200_000
+	tpchSuppsPerScale  = 10_000
+	tpchMetaFile       = "tpch.json"
+	tpchHistoryFile    = "history.jsonl"
+)
+
+var (
+	tpchStartDate   = time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC)
+	tpchEndDate     = time.Date(1998, 12, 31, 0, 0, 0, 0, time.UTC)
+	tpchCurrentDate = time.Date(1995, 6, 17, 0, 0, 0, 0, time.UTC) // Splits shipped from open lines
+
+	tpchPriorities   = []string{"1-URGENT", "2-HIGH", "3-MEDIUM", "4-NOT SPECIFIED", "5-LOW"}
+	tpchInstructions = []string{"DELIVER IN PERSON", "COLLECT COD", "NONE", "TAKE BACK RETURN"}
+	tpchShipModes    = []string{"REG AIR", "AIR", "RAIL", "SHIP", "TRUCK", "MAIL", "FOB"}
+	tpchWords        = strings.Fields("furiously carefully quickly slyly blithely express regular final special pending " +
+		"ironic even bold silent unusual idle packages deposits requests accounts instructions theodolites " +
+		"foxes pinto beans asymptotes dependencies platelets ideas courts excuses sleep nag haggle wake cajole")
+)
+
+// tpchQuery is a TPC-H query golap runs, written against the generated
+// files; {dir} stands for their directory
+type tpchQuery struct {
+	name string
+	note string // How it departs from the specification, if it does
+	sql  string
+}
+
+// tpchQueries are the TPC-H queries golap supports: the single-table ones.
+// The rest join tables, which golap can't yet.
+var tpchQueries = []tpchQuery{
+	{name: "q1", sql: "SELECT l_returnflag, l_linestatus, SUM(l_quantity) AS sum_qty, SUM(l_extendedprice) AS sum_base_price, " +
+		"SUM(l_extendedprice * (1 - l_discount)) AS sum_disc_price, SUM(l_extendedprice * (1 - l_discount) * (1 + l_tax)) AS sum_charge, " +
+		"AVG(l_quantity) AS avg_qty, AVG(l_extendedprice) AS avg_price, AVG(l_discount) AS avg_disc, COUNT(*) AS count_order " +
+		"FROM `{dir}/lineitem.csv` WHERE l_shipdate <= '1998-09-02' " +
+		"GROUP BY l_returnflag, l_linestatus ORDER BY l_returnflag, l_linestatus"},
+	{name: "q4", note: "without its EXISTS over lineitem", sql: "SELECT o_orderpriority, COUNT(*) AS order_count " +
+		"FROM `{dir}/orders.csv` WHERE o_orderdate >= '1993-07-01' AND o_orderdate < '1993-10-01' " +
+		"GROUP BY o_orderpriority ORDER BY o_orderpriority"},
+	{name: "q6", sql: "SELECT SUM(l_extendedprice * l_discount) AS revenue FROM `{dir}/lineitem.csv` " +
+		"WHERE l_shipdate >= '1994-01-01' AND l_shipdate < '1995-01-01' " +
+		"AND l_discount >= 0.05 AND l_discount <= 0.07 AND l_quantity < 24"},
+}
+
+// tpchMeta describes a generated data set, written next to it by gen and
+// read by run to label its results
+type tpchMeta struct {
+	Scale        float64 `json:"scale"`
+	Seed         int64   `json:"seed"`
+	Orders       int64   `json:"orders"`
+	LineitemRows int64   `json:"lineitem_rows"`
+}
+
+// tpchResult is one line of the benchmark history: one query's timings in
+// one tpch run
+type tpchResult struct {
+	Time      time.Time `json:"time"`
+	Query     string    `json:"query"`
+	Scale     float64   `json:"scale"`
+	Seed      int64     `json:"seed"`
+	Runs      int       `json:"runs"`
+	BestMs    float64   `json:"best_ms"`
+	MedianMs  float64   `json:"median_ms"`
+	Rows      int64     `json:"rows"`
+	BytesRead int64     `json:"bytes_read"`
+	Result    string    `json:"result"` // Hash of the rows, floats to 10 significant digits
+	Commit    string    `json:"commit,omitempty"`
+	GoVersion string    `json:"go_version"`
+}
+
+// runTPCHGen writes lineitem.csv and orders.csv for a scale factor (1 is
+// 1.5 million orders and about 6 million line items, some 1GB of CSV) to
+// dir, the same for the same scale and seed
+func runTPCHGen(dir string, scale float64, seed int64) error {
+	if scale <= 0 {
+		return fmt.Errorf("invalid -scale: must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	orders, err := os.Create(filepath.Join(dir, "orders.csv"))
+	if err != nil {
+		return err
+	}
+	defer orders.Close()
+	lineitem, err := os.Create(filepath.Join(dir, "lineitem.csv"))
+	if err != nil {
+		return err
+	}
+	defer lineitem.Close()
+	ow, lw := bufio.NewWriterSize(orders, 1<<20), bufio.NewWriterSize(lineitem, 1<<20)
+	ow.WriteString("o_orderkey,o_custkey,o_orderstatus,o_totalprice,o_orderdate,o_orderpriority,o_clerk,o_shippriority,o_comment\n")
+	lw.WriteString("l_orderkey,l_partkey,l_suppkey,l_linenumber,l_quantity,l_extendedprice,l_discount,l_tax," +
+		"l_returnflag,l_linestatus,l_shipdate,l_commitdate,l_receiptdate,l_shipinstruct,l_shipmode,l_comment\n")
+
+	rng := rand.New(rand.NewSource(seed))
+	scaled := func(n int) int64 { return max(int64(float64(n)*scale), 1) }
+	meta := tpchMeta{Scale: scale, Seed: seed, Orders: scaled(tpchOrdersPerScale)}
+	customers, parts, suppliers, clerks := scaled(tpchCustPerScale), scaled(tpchPartsPerScale), scaled(tpchSuppsPerScale), scaled(1000)
+	orderDays := int(tpchEndDate.Sub(tpchStartDate).Hours()/24) - 151
+	started := time.Now()
+
+	var line []byte
+	for orderKey := int64(1); orderKey <= meta.Orders; orderKey++ {
+		orderDate := tpchStartDate.AddDate(0, 0, rng.Intn(orderDays+1))
+		custKey := 1 + rng.Int63n(customers)
+		if custKey%3 == 0 && customers > 2 {
+			custKey-- // A third of the customers place no orders
+		}
+		lines := 1 + rng.Intn(7)
+		var total float64
+		shipped := 0
+		for lineNumber := 1; lineNumber <= lines; lineNumber++ {
+			partKey := 1 + rng.Int63n(parts)
+			suppKey := 1 + (partKey+int64(rng.Intn(4))*(suppliers/4+(partKey-1)/suppliers))%suppliers
+			quantity := 1 + rng.Intn(50)
+			retailPrice := float64(90000+(partKey/10)%20001+100*(partKey%1000)) / 100
+			price := float64(quantity) * retailPrice
+			discount := float64(rng.Intn(11)) / 100
+			tax := float64(rng.Intn(9)) / 100
+			shipDate := orderDate.AddDate(0, 0, 1+rng.Intn(121))
+			commitDate := orderDate.AddDate(0, 0, 30+rng.Intn(61))
+			receiptDate := shipDate.AddDate(0, 0, 1+rng.Intn(30))
+			returnFlag := "N"
+			if !receiptDate.After(tpchCurrentDate) {
+				returnFlag = []string{"R", "A"}[rng.Intn(2)]
+			}
+			lineStatus := "O"
+			if !shipDate.After(tpchCurrentDate) {
+				lineStatus = "F"
+				shipped++
+			}
+			total += price * (1 + tax) * (1 - discount)
+
+			line = line[:0]
+			line = strconv.AppendInt(line, orderKey, 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, partKey, 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, suppKey, 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(lineNumber), 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(quantity), 10)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, price, 'f', 2, 64)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, discount, 'f', 2, 64)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, tax, 'f', 2, 64)
+			line = append(line, ',')
+			line = append(line, returnFlag...)
+			line = append(line, ',')
+			line = append(line, lineStatus...)
+			line = append(line, ',')
+			line = shipDate.AppendFormat(line, time.DateOnly)
+			line = append(line, ',')
+			line = commitDate.AppendFormat(line, time.DateOnly)
+			line = append(line, ',')
+			line = receiptDate.AppendFormat(line, time.DateOnly)
+			line = append(line, ',')
+			line = append(line, tpchInstructions[rng.Intn(len(tpchInstructions))]...)
+			line = append(line, ',')
+			line = append(line, tpchShipModes[rng.Intn(len(tpchShipModes))]...)
+			line = append(line, ',')
+			line = appendTPCHComment(line, rng, 2+rng.Intn(5))
+			line = append(line, '\n')
+			lw.Write(line)
+			meta.LineitemRows++
+		}
+
+		status := "P"
+		switch shipped {
+		case lines:
+			status = "F"
+		case 0:
+			status = "O"
+		}
+		line = line[:0]
+		line = strconv.AppendInt(line, orderKey, 10)
+		line = append(line, ',')
+		line = strconv.AppendInt(line, custKey, 10)
+		line = append(line, ',')
+		line = append(line, status...)
+		line = append(line, ',')
+		line = strconv.AppendFloat(line, total, 'f', 2, 64)
+		line = append(line, ',')
+		line = orderDate.AppendFormat(line, time.DateOnly)
+		line = append(line, ',')
+		line = append(line, tpchPriorities[rng.Intn(len(tpchPriorities))]...)
+		line = append(line, ",Clerk#"...)
+		line = fmt.Appendf(line, "%09d", 1+rng.Int63n(clerks))
+		line = append(line, ",0,"...)
+		line = appendTPCHComment(line, rng, 3+rng.Intn(8))
+		line = append(line, '\n')
+		ow.Write(line)
+	}
+	if err := ow.Flush(); err != nil {
+		return err
+	}
+	if err := lw.Flush(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, tpchMetaFile), append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d orders and %d line items (scale %g) to %s in %s\n",
+		meta.Orders, meta.LineitemRows, scale, dir, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// appendTPCHComment appends words from the comment word list
+func appendTPCHComment(line []byte, rng *rand.Rand, words int) []byte {
+	for i := 0; i < words; i++ {
+		if i > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, tpchWords[rng.Intn(len(tpchWords))]...)
+	}
+	return line
+}
+
+// runTPCHRun times each supported query over the data set in dir (the
+// best and median of runs), appends the results to the history file and
+// compares them with the last baseline runs at the same scale and seed:
+// it fails if a query got more than maxSlowdown slower than their median
+// best time, or returned different rows
+func runTPCHRun(dir string, only []string, runs, baseline int, maxSlowdown float64, historyPath string, opts engine.Options) error {
+	data, err := os.ReadFile(filepath.Join(dir, tpchMetaFile))
+	if err != nil {
+		return fmt.Errorf("no TPC-H data in %s (run golap tpch gen first): %w", dir, err)
+	}
+	var meta tpchMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("invalid %s: %w", tpchMetaFile, err)
+	}
+	if historyPath == "" {
+		historyPath = filepath.Join(dir, tpchHistoryFile)
+	}
+	history, err := readTPCHHistory(historyPath)
+	if err != nil {
+		return err
+	}
+
+	commit := ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				commit = setting.Value
+			}
+		}
+	}
+
+	var results []tpchResult
+	var failures []string
+	fmt.Printf("TPC-H scale %g (seed %d), best of %d runs\n\n", meta.Scale, meta.Seed, runs)
+	fmt.Printf("%-6s %12s %12s %6s %12s  %s\n", "query", "best", "median", "rows", "read", "vs baseline")
+	for _, query := range tpchQueries {
+		if len(only) > 0 && !slices.Contains(only, query.name) {
+			continue
+		}
+		sql := strings.ReplaceAll(query.sql, "{dir}", filepath.ToSlash(dir))
+		result := tpchResult{Time: time.Now().UTC(), Query: query.name, Scale: meta.Scale, Seed: meta.Seed, Runs: runs,
+			Commit: commit, GoVersion: runtime.Version()}
+		var times []float64
+		for i := 0; i < runs; i++ {
+			elapsed, rows, bytesRead, hash, err := timeTPCHQuery(sql, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", query.name, err)
+			}
+			times = append(times, float64(elapsed.Microseconds())/1000)
+			result.Rows, result.BytesRead, result.Result = rows, bytesRead, hash
+		}
+		slices.Sort(times)
+		result.BestMs, result.MedianMs = times[0], times[len(times)/2]
+
+		verdict := "(no baseline)"
+		var previous []tpchResult
+		for _, old := range history {
+			if old.Query == query.name && old.Scale == meta.Scale && old.Seed == meta.Seed {
+				previous = append(previous, old)
+			}
+		}
+		if len(previous) > baseline {
+			previous = previous[len(previous)-baseline:]
+		}
+		if len(previous) > 0 {
+			best := make([]float64, len(previous))
+			for i, old := range previous {
+				best[i] = old.BestMs
+			}
+			slices.Sort(best)
+			median := best[len(best)/2]
+			change := result.BestMs/median - 1
+			verdict = fmt.Sprintf("%+.1f%% (median of %d)", 100*change, len(previous))
+			if change > maxSlowdown {
+				verdict += "  REGRESSION"
+				failures = append(failures, fmt.Sprintf("%s is %.1f%% slower than its baseline", query.name, 100*change))
+			}
+			if last := previous[len(previous)-1]; last.Result != result.Result {
+				verdict += "  RESULT CHANGED"
+				failures = append(failures, fmt.Sprintf("%s returned different rows than on %s", query.name, last.Time.Format(time.DateTime)))
+			}
+		}
+		if query.note != "" {
+			verdict += "  [" + query.note + "]"
+		}
+		fmt.Printf("%-6s %12s %12s %6d %12s  %s\n", query.name, formatMs(result.BestMs), formatMs(result.MedianMs),
+			result.Rows, operators.FormatBytes(result.BytesRead), verdict)
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no queries match; golap runs %s", strings.Join(tpchQueryNames(), ", "))
+	}
+
+	if err := appendTPCHHistory(historyPath, results); err != nil {
+		return err
+	}
+	fmt.Printf("\nAppended to %s\n", historyPath)
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// timeTPCHQuery runs a query to the end, returning how long it took, its
+// rows, the bytes its scans read and a hash of its rows
+func timeTPCHQuery(sql string, opts engine.Options) (elapsed time.Duration, rows, bytesRead int64, hash string, err error) {
+	opts, cancel := withTimeout(opts)
+	defer cancel()
+	start := time.Now()
+	op, err := engine.ParseAndPlanWithOptions(sql, opts)
+	if err != nil {
+		return 0, 0, 0, "", err
+	}
+	defer op.Close()
+	digest := fnv.New64a()
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return 0, 0, 0, "", err
+		}
+		if row == nil {
+			break
+		}
+		for _, v := range row.Values {
+			if f, ok := v.(float64); ok {
+				// Parallel scans may sum in another order
+				v = strconv.FormatFloat(f, 'g', 10, 64)
+			}
+			fmt.Fprintf(digest, "%T:%v\x00", v, v)
+		}
+		types.ReleaseRow(row)
+		rows++
+	}
+	elapsed = time.Since(start)
+	return elapsed, rows, operators.CollectStats(op).TotalBytesRead(), strconv.FormatUint(digest.Sum64(), 16), nil
+}
+
+// readTPCHHistory reads the results of earlier runs, oldest first; a
+// missing file has none
+func readTPCHHistory(path string) ([]tpchResult, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var history []tpchResult
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var result tpchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNo, err)
+		}
+		history = append(history, result)
+	}
+	return history, scanner.Err()
+}
+
+// appendTPCHHistory appends a run's results to the history file
+func appendTPCHHistory(path string, results []tpchResult) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	for _, result := range results {
+		line, err := json.Marshal(result)
+		if err == nil {
+			_, err = file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+	}
+	return file.Close()
+}
+
+// tpchQueryNames returns the names of the queries tpch run knows
+func tpchQueryNames() []string {
+	names := make([]string, len(tpchQueries))
+	for i, query := range tpchQueries {
+		names[i] = query.name
+	}
+	return names
+}
+
+// formatMs formats milliseconds as a duration
+func formatMs(ms float64) string {
+	return time.Duration(ms * float64(time.Millisecond)).Round(10 * time.Microsecond).String()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Exit codes of golap validate, for pipelines to gate on; a clean file
+// exits with 0
+const (
+	validateExitError    = 1 // The file couldn't be read
+	validateExitProblems = 2
+)
+
+// runValidate checks a CSV file's quality and prints a report, as text or
+// (with -format json) JSON, then exits with validateExitProblems if it
+// found problems: ragged or malformed rows, values that don't parse as
+// their column's type, duplicate or empty column names, encoding problems,
+// or a column more NULL than maxNullFraction
+func runValidate(path string, maxNullFraction float64, opts engine.Options) {
+	opts, cancel := withTimeout(opts)
+	defer cancel()
+	report, err := engine.ValidateFile(path, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(validateExitError)
+	}
+	problems := validateProblems(report, maxNullFraction)
+
+	if outputFormat == "json" || outputFormat == "jsonl" {
+		data, err := json.MarshalIndent(struct {
+			*operators.CSVReport
+			Problems []validateProblem `json:"problems"`
+			Valid    bool              `json:"valid"`
+		}{report, problems, len(problems) == 0}, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(validateExitError)
+		}
+		fmt.Println(string(data))
+	} else {
+		printValidateReport(report, problems)
+	}
+	if len(problems) > 0 {
+		os.Exit(validateExitProblems)
+	}
+}
+
+// validateProblem is a kind of problem found in a file, with examples
+type validateProblem struct {
+	Summary  string                 `json:"summary"`
+	Examples []operators.CSVProblem `json:"examples,omitempty"`
+}
+
+// validateProblems lists what's wrong with a file, one kind of problem at
+// a time
+func validateProblems(report *operators.CSVReport, maxNullFraction float64) []validateProblem {
+	var problems []validateProblem
+	add := func(summary string, examples []operators.CSVProblem) {
+		problems = append(problems, validateProblem{summary, examples})
+	}
+
+	if report.ByteOrderMark {
+		add("the header starts with a byte order mark, which becomes part of the first column's name", nil)
+	}
+	if len(report.DuplicateHeaders) > 0 {
+		add("duplicate column names: "+strings.Join(report.DuplicateHeaders, ", "), nil)
+	}
+	if len(report.EmptyHeaders) > 0 {
+		positions := make([]string, len(report.EmptyHeaders))
+		for i, pos := range report.EmptyHeaders {
+			positions[i] = strconv.Itoa(pos)
+		}
+		add("empty column names at positions "+strings.Join(positions, ", "), nil)
+	}
+	if report.RaggedRows > 0 {
+		add(fmt.Sprintf("%s with the wrong number of fields", plural(report.RaggedRows, "row")), report.RaggedExamples)
+	}
+	if report.MalformedRows > 0 {
+		add(fmt.Sprintf("%s with broken quoting", plural(report.MalformedRows, "row")), report.MalformedExamples)
+	}
+	if report.InvalidUTF8Rows > 0 {
+		add(fmt.Sprintf("%s not valid UTF-8 (set -encoding if the file isn't UTF-8)", plural(report.InvalidUTF8Rows, "row")), report.InvalidUTF8)
+	}
+	for _, col := range report.Columns {
+		if col.Invalid > 0 {
+			add(fmt.Sprintf("column %s: %s not parseable as %s", col.Name, plural(col.Invalid, "value"), col.Type), col.InvalidExamples)
+		}
+		if col.NullFraction > maxNullFraction {
+			add(fmt.Sprintf("column %s: %.1f%% NULL, more than -max-null-fraction %g", col.Name, 100*col.NullFraction, maxNullFraction), nil)
+		}
+	}
+	return problems
+}
+
+// printValidateReport prints a report as text: the columns as a table,
+// then the problems
+func printValidateReport(report *operators.CSVReport, problems []validateProblem) {
+	fmt.Printf("%s: %s, %s (delimiter %q, %s)\n\n", report.Path, plural(report.Rows, "row"),
+		plural(int64(len(report.Columns)), "column"), report.Delimiter, report.Encoding)
+
+	schema := types.Schema{
+		Columns: []string{"column", "type", "nulls", "null_fraction", "invalid"},
+		Types:   []types.DataType{types.String, types.String, types.Int, types.Float, types.Int},
+	}
+	table := newTableWriter(os.Stdout, schema)
+	for _, col := range report.Columns {
+		typeName := col.Type
+		if col.Declared {
+			typeName += " (declared)"
+		}
+		table.write([]interface{}{col.Name, typeName, col.Nulls, strconv.FormatFloat(col.NullFraction, 'f', 3, 64), col.Invalid})
+	}
+	table.finish()
+
+	if len(problems) == 0 {
+		fmt.Println("\nNo problems found")
+		return
+	}
+	fmt.Printf("\n%s found:\n", plural(int64(len(problems)), "problem"))
+	for _, problem := range problems {
+		fmt.Println("- " + problem.Summary)
+		for _, example := range problem.Examples {
+			fmt.Printf("    line %d: %s\n", example.Line, example.Detail)
+		}
+	}
+}
+
+// plural formats a count with a noun, adding s unless it's 1
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long a watch waits after a file event for more, so a
+// file written in several steps triggers one run
+const watchSettle = 100 * time.Millisecond
+
+// runWatch runs a SELECT, then runs it again whenever a file it reads
+// changes, until Ctrl-C. Local files are watched with fsnotify, through
+// their directories, so files replaced by a rename or new in a glob or
+// directory are seen too. Remote objects have no change events, so they
+// are polled every interval (their size and modification time, as zone
+// maps check theirs). On a terminal the screen is cleared before each
+// run. A run that fails, say on a file caught mid-write, is reported and
+// the watch goes on.
+func runWatch(query string, interval time.Duration, opts engine.Options) {
+	sources, err := engine.Sources(query, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(sources) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: the query reads no files to watch")
+		os.Exit(1)
+	}
+	clearScreen := false
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		clearScreen = true
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to watch files: %v\n", err)
+		os.Exit(1)
+	}
+	defer watcher.Close()
+	watchDirs(watcher, sources)
+
+	// Remote sources are polled; without any, the ticker never fires
+	how := "for changes"
+	var poll <-chan time.Time
+	if slices.ContainsFunc(sources, storage.IsRemote) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+		how = fmt.Sprintf("for changes (remote files every %s)", interval)
+	}
+
+	state := engine.SourcesState(sources)
+	for {
+		if clearScreen {
+			fmt.Print("\033[H\033[2J")
+		}
+		fmt.Printf("Watching %s %s; ran at %s\n\n", strings.Join(sources, ", "), how, time.Now().Format("15:04:05"))
+		if err := runQuery(query, opts); err != nil {
+			discardOutput()
+			if opts.Context.Err() != nil {
+				return // Interrupted
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else {
+			finishOutput()
+		}
+
+		for changed := false; !changed; {
+			select {
+			case <-opts.Context.Done():
+				return
+			case <-poll:
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !settle(watcher, opts) {
+					return
+				}
+				// New directories (partitions) may have appeared
+				watchDirs(watcher, sources)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(os.Stderr, "Error: watching files: %v\n", err)
+			}
+			// Events for other files in the same directories change nothing
+			if current := engine.SourcesState(sources); current != state {
+				state, changed = current, true
+			}
+		}
+	}
+}
+
+// watchDirs adds the directories sources' local files are in to the
+// watcher; ones already watched are kept
+func watchDirs(watcher *fsnotify.Watcher, sources []string) {
+	watched := watcher.WatchList()
+	for _, dir := range engine.LocalDirs(sources) {
+		if slices.Contains(watched, dir) {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to watch %s: %v\n", dir, err)
+		}
+	}
+}
+
+// settle drains events until none arrive for watchSettle; false if the
+// watch was interrupted or closed
+func settle(watcher *fsnotify.Watcher, opts engine.Options) bool {
+	timer := time.NewTimer(watchSettle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-opts.Context.Done():
+			return false
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return false
+			}
+			timer.Reset(watchSettle)
+		case <-timer.C:
+			return true
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/internal/sqlcompare"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Correctness oracle: loads a CSV into an embedded, in-memory SQLite
+// database, runs a corpus of randomly generated
+// queries through both golap and SQLite, and reports every query whose
+// results differ. Without a CSV argument it generates one.
+func main() {
+	rows := flag.Int("rows", 2000, "Rows in the generated CSV")
+	queries := flag.Int("queries", 300, "Number of queries to generate")
+	seed := flag.Int64("seed", 1, "Random seed for the data and queries")
+	key := flag.String("key", "", "Unique, non-NULL column to ORDER BY for LIMIT queries (generated data: id)")
+	verbose := flag.Bool("v", false, "Print every query as it runs")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sqlite_oracle [flags] [file.csv]")
+		fmt.Fprintln(os.Stderr, "Example: sqlite_oracle -queries 1000 -seed 7")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	rng := rand.New(rand.NewSource(*seed))
+	csvPath := flag.Arg(0)
+	if csvPath == "" {
+		path, err := generateCSV(rng, *rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.Remove(path)
+		csvPath = path
+		if *key == "" {
+			*key = "id"
+		}
+	}
+
+	failures, err := run(csvPath, *key, *queries, rng, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d queries, %d mismatches\n", *queries, failures)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// generateCSV writes a table with ints, floats and strings, including
+// NULLs (empty numeric fields) and empty strings
+func generateCSV(rng *rand.Rand, rows int) (string, error) {
+	file, err := os.CreateTemp("", "golap-oracle-*.csv")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	words := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"}
+	groups := []string{"north", "south", "east", "west", "central"}
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, "id,grp,n,m,x,s")
+	for i := 0; i < rows; i++ {
+		n, m, x := "", "", ""
+		if rng.Intn(10) > 0 {
+			n = strconv.Itoa(rng.Intn(201) - 100)
+		}
+		if rng.Intn(20) > 0 {
+			m = strconv.Itoa(rng.Intn(10))
+		}
+		if rng.Intn(8) > 0 {
+			x = fmt.Sprintf("%.3f", rng.Float64()*2000-1000)
+		}
+		s := ""
+		if rng.Intn(15) > 0 {
+			s = words[rng.Intn(len(words))] + strconv.Itoa(rng.Intn(50))
+		}
+		fmt.Fprintf(w, "%d,%s,%s,%s,%s,%s\n", i, groups[rng.Intn(len(groups))], n, m, x, s)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// table describes the CSV as golap sees it, with sampled values for literals
+type table struct {
+	path    string
+	schema  types.Schema
+	samples [][]interface{} // Non-NULL values per column
+	key     string
+}
+
+// run cross-checks the generated queries and returns how many failed
+func run(csvPath, key string, count int, rng *rand.Rand, verbose bool) (int, error) {
+	t, err := loadTable(csvPath, key)
+	if err != nil {
+		return 0, err
+	}
+
+	gen := &generator{rng: rng, table: t}
+	sqls := make([]string, count)
+	for i := range sqls {
+		sqls[i] = gen.query()
+	}
+
+	db, err := loadSQLite(t)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	failures := 0
+	for _, sql := range sqls {
+		golapSQL := strings.ReplaceAll(sql, "{table}", "`"+t.path+"`")
+		if verbose {
+			fmt.Println(golapSQL)
+		}
+		want, wantErr := sqlcompare.Query(db, strings.ReplaceAll(sql, "{table}", "t"))
+		got, err := sqlcompare.Golap(golapSQL, engine.DefaultOptions())
+		switch {
+		case err != nil && wantErr != nil:
+			continue // Both reject it
+		case err != nil:
+			fmt.Printf("MISMATCH %s\n  golap error: %v\n\n", golapSQL, err)
+		case wantErr != nil:
+			fmt.Printf("MISMATCH %s\n  sqlite error: %v\n\n", golapSQL, wantErr)
+		default:
+			ordered := strings.Contains(sql, "ORDER BY")
+			if diffs := sqlcompare.Diff(got, want, ordered); len(diffs) > 0 {
+				fmt.Printf("MISMATCH %s\n  %s\n\n", golapSQL, diffs[0])
+			} else {
+				continue
+			}
+		}
+		failures++
+	}
+	return failures, nil
+}
+
+// loadTable reads the file's schema and samples its values through golap
+func loadTable(path, key string) (*table, error) {
+	op, err := engine.ParseAndPlanWithOptions("SELECT * FROM `"+path+"`", engine.DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+
+	t := &table{path: path, schema: op.Schema(), key: key}
+	t.samples = make([][]interface{}, len(t.schema.Columns))
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		for i, v := range row.Values {
+			if v == nil || len(t.samples[i]) >= 200 {
+				continue
+			}
+			if s, ok := v.(string); ok && strings.ContainsAny(s, "\\'\n") {
+				continue // Escaping differs between the dialects
+			}
+			t.samples[i] = append(t.samples[i], v)
+		}
+	}
+	if key != "" && columnIndex(t.schema, key) < 0 {
+		return nil, fmt.Errorf("key column %s not found", key)
+	}
+	return t, nil
+}
+
+func columnIndex(schema types.Schema, name string) int {
+	for i, c := range schema.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadSQLite loads the CSV into table t of an in-memory SQLite database,
+// with golap's column types. The file is read on its own rather than
+// through golap, as SQLite's CSV import would, except that empty numeric
+// fields are NULL as golap reads them.
+func loadSQLite(t *table) (*sql.DB, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(bufio.NewReader(file))
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+
+	db, err := sqlcompare.Open()
+	if err != nil {
+		return nil, err
+	}
+	err = sqlcompare.Load(db, "t", t.schema, func() ([]interface{}, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(record))
+		for i, field := range record {
+			if field != "" || t.schema.Types[i] == types.String {
+				values[i] = field
+			}
+		}
+		return values, nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("loading into sqlite: %w", err)
+	}
+	return db, nil
+}
+
+// generator builds random queries over the table; {table} stands for the
+// table name, which differs between golap (the file) and SQLite (t)
+type generator struct {
+	rng   *rand.Rand
+	table *table
+}
+
+func (g *generator) query() string {
+	switch g.rng.Intn(7) {
+	case 0, 1:
+		return fmt.Sprintf("SELECT %s FROM {table} WHERE %s", g.columns(), g.predicate(2))
+	case 2:
+		if g.table.key != "" {
+			dir := ""
+			if g.rng.Intn(2) == 0 {
+				dir = " DESC"
+			}
+			return fmt.Sprintf("SELECT %s FROM {table} WHERE %s ORDER BY %s%s LIMIT %d",
+				g.columns(), g.predicate(2), ident(g.table.key), dir, 1+g.rng.Intn(20))
+		}
+		return fmt.Sprintf("SELECT * FROM {table} WHERE %s", g.predicate(2))
+	case 3:
+		num := g.column(types.Int, types.Float)
+		any := g.column()
+		return fmt.Sprintf("SELECT COUNT(*), COUNT(%s), SUM(%s), MIN(%s), MAX(%s), AVG(%s) FROM {table} WHERE %s",
+			any, num, any, any, num, g.predicate(2))
+	case 4:
+		group := g.column()
+		having := ""
+		if g.rng.Intn(3) == 0 {
+			having = fmt.Sprintf(" HAVING COUNT(*) > %d", g.rng.Intn(50))
+		}
+		return fmt.Sprintf("SELECT %s, COUNT(*), SUM(%s), MAX(%s) FROM {table} WHERE %s GROUP BY %s%s",
+			group, g.column(types.Int, types.Float), g.column(), g.predicate(1), group, having)
+	case 5:
+		return fmt.Sprintf("SELECT DISTINCT %s FROM {table} WHERE %s", g.column(), g.predicate(2))
+	default:
+		a, b := g.column(types.Int, types.Float), g.column(types.Int, types.Float)
+		op := []string{"+", "-", "*"}[g.rng.Intn(3)]
+		return fmt.Sprintf("SELECT %s %s %s, SUM(CASE WHEN %s THEN 1 ELSE 0 END) FROM {table} GROUP BY %s %s %s",
+			a, op, b, g.predicate(1), a, op, b)
+	}
+}
+
+// columns picks one to three columns, or *
+func (g *generator) columns() string {
+	if g.rng.Intn(4) == 0 {
+		return "*"
+	}
+	n := 1 + g.rng.Intn(3)
+	cols := make([]string, n)
+	for i := range cols {
+		cols[i] = g.column()
+	}
+	return strings.Join(cols, ", ")
+}
+
+// column picks a column of one of the given types (any type if none)
+func (g *generator) column(want ...types.DataType) string {
+	return ident(g.table.schema.Columns[g.columnIndex(want...)])
+}
+
+func (g *generator) columnIndex(want ...types.DataType) int {
+	var candidates []int
+	for i, dt := range g.table.schema.Types {
+		if len(want) == 0 {
+			candidates = append(candidates, i)
+			continue
+		}
+		for _, w := range want {
+			if dt == w {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return g.rng.Intn(len(g.table.schema.Columns))
+	}
+	return candidates[g.rng.Intn(len(candidates))]
+}
+
+func ident(name string) string {
+	return "`" + name + "`"
+}
+
+// predicate builds a WHERE condition up to depth levels of AND/OR/NOT
+func (g *generator) predicate(depth int) string {
+	if depth > 0 && g.rng.Intn(2) == 0 {
+		switch g.rng.Intn(3) {
+		case 0:
+			return fmt.Sprintf("(%s AND %s)", g.predicate(depth-1), g.predicate(depth-1))
+		case 1:
+			return fmt.Sprintf("(%s OR %s)", g.predicate(depth-1), g.predicate(depth-1))
+		default:
+			return fmt.Sprintf("NOT (%s)", g.predicate(depth-1))
+		}
+	}
+
+	i := g.columnIndex()
+	name := ident(g.table.schema.Columns[i])
+	op := []string{"=", "<", ">", "<=", ">=", "!="}[g.rng.Intn(6)]
+	switch g.rng.Intn(8) {
+	case 0:
+		if g.rng.Intn(2) == 0 {
+			return name + " IS NULL"
+		}
+		return name + " IS NOT NULL"
+	case 1:
+		// Column against column of the same type
+		other := g.column(g.table.schema.Types[i])
+		return fmt.Sprintf("%s %s %s", name, op, other)
+	default:
+		return fmt.Sprintf("%s %s %s", name, op, g.literal(i))
+	}
+}
+
+// literal returns a value of the column's type, usually one from the data
+func (g *generator) literal(column int) string {
+	samples := g.table.samples[column]
+	var v interface{}
+	if len(samples) > 0 {
+		v = samples[g.rng.Intn(len(samples))]
+	}
+	switch g.table.schema.Types[column] {
+	case types.Int:
+		n, _ := v.(int64)
+		if g.rng.Intn(4) == 0 {
+			n += int64(g.rng.Intn(5) - 2)
+		}
+		return strconv.FormatInt(n, 10)
+	case types.Float:
+		f, _ := v.(float64)
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		s, _ := v.(string)
+		return "'" + s + "'"
+	}
+}
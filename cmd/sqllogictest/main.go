@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// sqllogictest runner: executes .slt files of statements and queries with
+// their expected results against the engine, and reports every record
+// whose outcome differs. The format is sqllogictest's:
+//
+//	statement ok
+//	CREATE VIEW adults AS SELECT * FROM `data/people.csv` WHERE age >= 18
+//
+//	statement error no such column
+//	SELECT missing FROM `data/people.csv`
+//
+//	query IT rowsort
+//	SELECT id, name FROM adults
+//	----
+//	1	alice
+//	3	carol
+//
+// Each record is a statement or query, its SQL on the following lines up
+// to a blank line. "statement error" may give a substring the error must
+// contain. A query's column types are I (integer), R (real, printed with
+// three decimals) and T (text); its sort mode nosort (the default),
+// rowsort or valuesort. Expected values follow "----", one row per line
+// with tab-separated values, or one value per line; NULL is NULL and an
+// empty string (empty). Results with more than -hash-threshold values
+// may be written "N values hashing to MD5" instead, the MD5 of every value
+// followed by a newline. "skipif golap" and "onlyif DB" (for another DB)
+// skip the next record, "halt" stops the file, and lines starting with #
+// are comments.
+//
+// Each file runs in its own directory, so relative paths name fixtures
+// next to it, with an empty catalog of its own for views and tables.
+func main() {
+	verbose := flag.Bool("v", false, "Print every record as it runs")
+	hashThreshold := flag.Int("hash-threshold", 0, "Hash results of more than this many values when printing them (0 = never)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sqllogictest [flags] FILE.slt|DIR ...")
+		fmt.Fprintln(os.Stderr, "Example: sqllogictest testdata/slt")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var files []string
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(path, ".slt") {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	var records, skipped, failed int
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(wd, file)
+		}
+		r := &runner{file: file, verbose: *verbose, hashThreshold: *hashThreshold}
+		if err := r.run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			failed++
+		}
+		records += r.records
+		skipped += r.skipped
+		failed += r.failed
+	}
+	fmt.Printf("%d files, %d records: %d passed, %d failed, %d skipped\n", len(files), records, records-failed-skipped, failed, skipped)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// record is a statement or query of an .slt file
+type record struct {
+	line      int // Of its first line, for reports
+	query     bool
+	wantError bool
+	errorText string   // Substring the error must contain
+	types     string   // Query column types, e.g. ITR
+	sortMode  string   // nosort, rowsort or valuesort
+	sql       string   // The statement
+	expected  []string // Expected values, in order
+	hash      string   // "N values hashing to MD5" instead of the values
+}
+
+// runner runs one .slt file
+type runner struct {
+	file          string
+	verbose       bool
+	hashThreshold int
+
+	records, skipped, failed int
+}
+
+// run parses and runs the file in its directory, with a catalog of its own
+func (r *runner) run() error {
+	f, err := os.Open(r.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	catalogDir, err := os.MkdirTemp("", "golap-slt-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(catalogDir)
+	os.Setenv("GOLAP_CATALOG", filepath.Join(catalogDir, "catalog.json"))
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(r.file)); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		lineNo++
+		return strings.TrimRight(scanner.Text(), "\r"), true
+	}
+	skip := false
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "halt":
+			return scanner.Err()
+		case "hash-threshold":
+			continue // The runner's own -hash-threshold is for printing only
+		case "skipif", "onlyif":
+			if len(fields) < 2 {
+				return fmt.Errorf("line %d: %s needs a database name", lineNo, fields[0])
+			}
+			skip = skip || (fields[0] == "skipif") == (fields[1] == "golap")
+			continue
+		case "statement", "query":
+		default:
+			return fmt.Errorf("line %d: unknown record %q", lineNo, fields[0])
+		}
+
+		rec := record{line: lineNo, query: fields[0] == "query", sortMode: "nosort"}
+		if rec.query {
+			if len(fields) < 2 {
+				return fmt.Errorf("line %d: query needs its column types", lineNo)
+			}
+			rec.types = fields[1]
+			if strings.Trim(rec.types, "ITR") != "" {
+				return fmt.Errorf("line %d: invalid column types %q (use I, T and R)", lineNo, rec.types)
+			}
+			if len(fields) > 2 {
+				rec.sortMode = fields[2]
+				if !slices.Contains([]string{"nosort", "rowsort", "valuesort"}, rec.sortMode) {
+					return fmt.Errorf("line %d: invalid sort mode %q", lineNo, rec.sortMode)
+				}
+			}
+		} else {
+			if len(fields) < 2 || (fields[1] != "ok" && fields[1] != "error") {
+				return fmt.Errorf("line %d: expected statement ok or statement error", lineNo)
+			}
+			rec.wantError = fields[1] == "error"
+			rec.errorText = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), strings.Join(fields[:2], " ")))
+		}
+
+		var sql []string
+		inResults := false
+		for {
+			line, ok := next()
+			if !ok || strings.TrimSpace(line) == "" {
+				break
+			}
+			switch {
+			case rec.query && line == "----":
+				inResults = true
+			case !inResults:
+				sql = append(sql, line)
+			case strings.Contains(line, " values hashing to "):
+				rec.hash = strings.TrimSpace(line)
+			case strings.Contains(line, "\t"):
+				rec.expected = append(rec.expected, strings.Split(line, "\t")...)
+			default:
+				rec.expected = append(rec.expected, line)
+			}
+		}
+		rec.sql = strings.Join(sql, "\n")
+		if rec.sql == "" {
+			return fmt.Errorf("line %d: record has no SQL", rec.line)
+		}
+
+		r.records++
+		if skip {
+			r.skipped++
+			skip = false
+			continue
+		}
+		if r.verbose {
+			fmt.Printf("%s:%d: %s\n", r.file, rec.line, strings.ReplaceAll(rec.sql, "\n", " "))
+		}
+		if problem := r.check(rec); problem != "" {
+			r.failed++
+			fmt.Printf("FAIL %s:%d\n%s\n%s\n\n", r.file, rec.line, rec.sql, problem)
+		}
+	}
+	return scanner.Err()
+}
+
+// check runs a record, describing how its outcome differs from the
+// expected one ("" if it doesn't)
+func (r *runner) check(rec record) string {
+	values, columns, err := execute(rec.sql, rec.types)
+	if rec.wantError {
+		switch {
+		case err == nil:
+			return "expected an error, got none"
+		case !strings.Contains(err.Error(), rec.errorText):
+			return fmt.Sprintf("expected an error containing %q, got: %v", rec.errorText, err)
+		}
+		return ""
+	}
+	if err != nil {
+		return fmt.Sprintf("unexpected error: %v", err)
+	}
+	if !rec.query {
+		return ""
+	}
+	if columns != len(rec.types) {
+		return fmt.Sprintf("expected %d columns (%s), got %d", len(rec.types), rec.types, columns)
+	}
+
+	switch rec.sortMode {
+	case "rowsort":
+		rows := make([][]string, 0, len(values)/max(columns, 1))
+		for i := 0; i+columns <= len(values); i += columns {
+			rows = append(rows, values[i:i+columns])
+		}
+		slices.SortFunc(rows, func(a, b []string) int { return slices.Compare(a, b) })
+		values = slices.Concat(rows...)
+	case "valuesort":
+		slices.Sort(values)
+	}
+
+	if rec.hash != "" {
+		if got := hashValues(values); got != rec.hash {
+			return fmt.Sprintf("expected %s\ngot      %s", rec.hash, got)
+		}
+		return ""
+	}
+	if slices.Equal(values, rec.expected) {
+		return ""
+	}
+	return fmt.Sprintf("expected:\n%s\ngot:\n%s", formatRows(rec.expected, columns, 0), formatRows(values, columns, r.hashThreshold))
+}
+
+// execute runs a statement to the end, returning its values as
+// sqllogictest prints them, row by row, and its column count
+func execute(sql, columnTypes string) (values []string, columns int, err error) {
+	op, err := engine.ParseAndPlanWithOptions(sql, engine.DefaultOptions())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer op.Close()
+	columns = len(op.Schema().Columns)
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if row == nil {
+			break
+		}
+		for i, v := range row.Values {
+			columnType := byte('T')
+			if i < len(columnTypes) {
+				columnType = columnTypes[i]
+			}
+			values = append(values, formatValue(v, columnType))
+		}
+		types.ReleaseRow(row)
+	}
+	return values, columns, nil
+}
+
+// formatValue prints a value as its column type says: I as an integer
+// (a fractional float keeps three decimals, so it doesn't pass as one), R
+// with three decimals, T as text
+func formatValue(v interface{}, columnType byte) string {
+	if v == nil {
+		return "NULL"
+	}
+	switch columnType {
+	case 'I':
+		switch n := v.(type) {
+		case int64:
+			return strconv.FormatInt(n, 10)
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+				return strconv.FormatInt(int64(n), 10)
+			}
+			return strconv.FormatFloat(n, 'f', 3, 64)
+		}
+	case 'R':
+		switch n := v.(type) {
+		case int64:
+			return strconv.FormatFloat(float64(n), 'f', 3, 64)
+		case float64:
+			return strconv.FormatFloat(n, 'f', 3, 64)
+		}
+	}
+	s := fmt.Sprint(v)
+	if s == "" {
+		return "(empty)"
+	}
+	return s
+}
+
+// hashValues returns the "N values hashing to MD5" form of a result
+func hashValues(values []string) string {
+	digest := md5.New()
+	for _, v := range values {
+		digest.Write([]byte(v))
+		digest.Write([]byte("\n"))
+	}
+	return fmt.Sprintf("%d values hashing to %s", len(values), hex.EncodeToString(digest.Sum(nil)))
+}
+
+// formatRows prints values as an .slt file would expect them, one row per
+// line; more than hashThreshold of them as their hash
+func formatRows(values []string, columns, hashThreshold int) string {
+	if hashThreshold > 0 && len(values) > hashThreshold {
+		return hashValues(values)
+	}
+	if columns == 0 {
+		columns = 1
+	}
+	var lines []string
+	for i := 0; i < len(values); i += columns {
+		lines = append(lines, strings.Join(values[i:min(i+columns, len(values))], "\t"))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+var (
+	// ANALYZE name [(a, b), (c, d) ...]
+	analyzePattern    = regexp.MustCompile(`(?is)^\s*ANALYZE\s+(` + "`[^`]+`" + `|[^\s(]+)\s*((?:\(\s*[^,()]+,\s*[^,()]+\)\s*,?\s*)*)$`)
+	columnPairPattern = regexp.MustCompile(`\(\s*([^,()]+?)\s*,\s*([^,()]+?)\s*\)`)
+)
+
+// analyzeStatement is a parsed ANALYZE
+type analyzeStatement struct {
+	name  string
+	pairs [][2]string // Column pairs to collect joint statistics for
+}
+
+// parseAnalyzeStatement recognizes ANALYZE name, optionally followed by
+// column pairs whose values are correlated: ANALYZE sales (country, city)
+func parseAnalyzeStatement(sql string) (analyzeStatement, bool) {
+	m := analyzePattern.FindStringSubmatch(sql)
+	if m == nil {
+		return analyzeStatement{}, false
+	}
+	stmt := analyzeStatement{name: unquoteIdentifier(m[1])}
+	for _, pair := range columnPairPattern.FindAllStringSubmatch(m[2], -1) {
+		stmt.pairs = append(stmt.pairs, [2]string{unquoteIdentifier(pair[1]), unquoteIdentifier(pair[2])})
+	}
+	return stmt, true
+}
+
+// analyze scans a file or table once and saves its statistics (distinct
+// counts, NULL fractions, average widths, most common values, histograms
+// of numeric columns, plus joint statistics for the requested column
+// pairs) next to the data, where the planner uses them to estimate WHERE
+// selectivity
+func (p *planner) analyze(stmt analyzeStatement) (types.Operator, error) {
+	source, filePath, err := p.openSource(stmt.name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	if filePath == "" {
+		return nil, fmt.Errorf("ANALYZE needs a single file or table, not a view or multi-file source: %s", stmt.name)
+	}
+
+	schema := source.Schema()
+	pairs := make([][2]int, len(stmt.pairs))
+	for i, pair := range stmt.pairs {
+		for j, col := range pair {
+			idx, err := p.resolveColumn(schema, col)
+			if err != nil {
+				return nil, err
+			}
+			if idx < 0 {
+				return nil, fmt.Errorf("column not found in schema: %s", col)
+			}
+			pairs[i][j] = idx
+		}
+		if pairs[i][0] == pairs[i][1] {
+			return nil, fmt.Errorf("a column pair needs two different columns: %s", pair[0])
+		}
+	}
+
+	collector := metadata.NewStatsCollector(filePath, schema.Columns, pairs)
+	for {
+		row, err := source.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		collector.Add(row.Values)
+	}
+
+	stats := collector.Finish()
+	if err := metadata.SaveStats(stats); err != nil {
+		return nil, err
+	}
+
+	histograms := 0
+	for _, column := range stats.Columns {
+		if len(column.Histogram) > 0 {
+			histograms++
+		}
+	}
+	return operators.NewStatusOp(fmt.Sprintf("Analyzed %s: %d rows, %d columns (%d with histograms), %d column pairs",
+		stmt.name, stats.RowCount, len(stats.Columns), histograms, len(stats.Pairs))), nil
+}
+
+// splitConjuncts flattens a WHERE clause into its top-level AND terms,
+// matching how buildPredicates produces one predicate per term
+func splitConjuncts(expr sqlparser.Expr) []sqlparser.Expr {
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(splitConjuncts(e.Left), splitConjuncts(e.Right)...)
+	case *sqlparser.ParenExpr:
+		return splitConjuncts(e.Expr)
+	default:
+		return []sqlparser.Expr{expr}
+	}
+}
+
+// estimateSelectivities returns the expected fraction of rows each
+// conjunct keeps, given the rows kept by the ones before it (-1 = unknown,
+// which is every term when there are no statistics)
+// Range terms on numeric columns use their histograms. Equality terms use
+// per-column statistics, except that when two of them
+// cover an analyzed column pair, the second is estimated from the joint
+// statistics (P(b|a) = P(a,b) / P(a)) rather than assumed independent.
+func (p *planner) estimateSelectivities(conjuncts []sqlparser.Expr, stats *metadata.TableStats, schema types.Schema) []float64 {
+	selectivities := make([]float64, len(conjuncts))
+	for i := range selectivities {
+		selectivities[i] = -1
+	}
+	if stats == nil {
+		return selectivities
+	}
+
+	type equality struct {
+		column string
+		value  interface{}
+	}
+	equalities := make(map[int]equality)
+	for i, conjunct := range conjuncts {
+		cmp, ok := conjunct.(*sqlparser.ComparisonExpr)
+		if !ok || cmp.Operator != sqlparser.EqualStr || !isLiteral(cmp.Right) {
+			continue
+		}
+		colName, err := extractColumnName(cmp.Left)
+		if err != nil {
+			continue
+		}
+		idx := p.columnIndex(schema, colName)
+		value, err := extractValue(cmp.Right)
+		if idx < 0 || err != nil || value == nil {
+			continue
+		}
+		equalities[i] = equality{column: schema.Columns[idx], value: value}
+	}
+
+	// Correlated pairs first, so each term is conditioned at most once
+	for i := range conjuncts {
+		a, ok := equalities[i]
+		if !ok || selectivities[i] >= 0 {
+			continue
+		}
+		for j := i + 1; j < len(conjuncts); j++ {
+			b, ok := equalities[j]
+			if !ok || selectivities[j] >= 0 {
+				continue
+			}
+			joint, ok := stats.PairSelectivity(a.column, a.value, b.column, b.value)
+			if !ok {
+				continue
+			}
+			selA, ok := stats.EqualitySelectivity(a.column, a.value)
+			if !ok {
+				continue
+			}
+			selectivities[i] = selA
+			selectivities[j] = 0
+			if selA > 0 {
+				selectivities[j] = joint / selA
+				if selectivities[j] > 1 {
+					selectivities[j] = 1
+				}
+			}
+			break
+		}
+	}
+
+	for i, eq := range equalities {
+		if selectivities[i] >= 0 {
+			continue
+		}
+		if sel, ok := stats.EqualitySelectivity(eq.column, eq.value); ok {
+			selectivities[i] = sel
+		}
+	}
+
+	// Range terms use the column's histogram
+	for i, conjunct := range conjuncts {
+		if selectivities[i] >= 0 {
+			continue
+		}
+		if sel, ok := p.rangeSelectivity(conjunct, stats, schema); ok {
+			selectivities[i] = sel
+		}
+	}
+	return selectivities
+}
+
+// rangeSelectivity estimates the fraction of rows a comparison of a
+// column with a numeric literal (amount > 100) keeps
+func (p *planner) rangeSelectivity(conjunct sqlparser.Expr, stats *metadata.TableStats, schema types.Schema) (float64, bool) {
+	cmp, ok := conjunct.(*sqlparser.ComparisonExpr)
+	if !ok || !isLiteral(cmp.Right) {
+		return 0, false
+	}
+	switch cmp.Operator {
+	case sqlparser.LessThanStr, sqlparser.LessEqualStr, sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+	default:
+		return 0, false
+	}
+	colName, err := extractColumnName(cmp.Left)
+	if err != nil {
+		return 0, false
+	}
+	idx := p.columnIndex(schema, colName)
+	value, err := extractValue(cmp.Right)
+	if idx < 0 || err != nil {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case int64:
+		return stats.RangeSelectivity(schema.Columns[idx], cmp.Operator, float64(v))
+	case float64:
+		return stats.RangeSelectivity(schema.Columns[idx], cmp.Operator, v)
+	default:
+		return 0, false
+	}
+}
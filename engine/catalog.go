@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/aryamaansaha/golap/catalog"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+var (
+	showTablesPattern  = regexp.MustCompile(`(?is)^\s*SHOW\s+TABLES\s*$`)
+	showSchemasPattern = regexp.MustCompile(`(?is)^\s*SHOW\s+SCHEMAS\s*$`)
+)
+
+// AttachOptions configures how a table is registered
+type AttachOptions struct {
+	Replace        bool     // Overwrite an existing registration
+	Delimiter      string   // CSV field separator; detected when empty
+	NoHeader       bool     // The file has no header line
+	Columns        []string // Column names for a headerless file (col0..colN if empty)
+	PrimaryKey     []string // Key columns for merge-on-read (latest row per key wins)
+	SequenceColumn string   // Column whose highest value marks the latest row
+
+	// Scan options stored with the table, so every query reads it the same
+	// way without repeating flags
+	Encoding    string                    // Text encoding (utf-8, latin1, windows-1252)
+	NullValues  []string                  // Field values read as NULL
+	Strict      bool                      // Fail on fields that don't parse as their column's type
+	SampleRows  int                       // Rows sampled to infer column types (0 = default)
+	ColumnTypes map[string]types.DataType // Declared column types, overriding inference
+}
+
+// AttachTable registers a data file, glob or directory under a logical
+// name in the catalog. path is relative to the working directory.
+func AttachTable(name, path string, replace bool) error {
+	return AttachTableWithOptions(name, path, AttachOptions{Replace: replace})
+}
+
+// AttachTableWithOptions registers a table with format, parsing and merge
+// settings. The options are checked by reading the start of the (first)
+// file with them: column names, key columns and typed columns must exist,
+// and with Strict the sampled rows must parse as their types.
+func AttachTableWithOptions(name, path string, opts AttachOptions) error {
+	matches, err := expandDataPath(path)
+	if err != nil {
+		return err
+	}
+	if _, err := storage.Stat(matches[0]); err != nil {
+		return fmt.Errorf("no files match: %s", path)
+	}
+
+	if _, err := operators.ParseDelimiter(opts.Delimiter); err != nil {
+		return err
+	}
+	encoding, err := operators.ParseEncoding(opts.Encoding)
+	if err != nil {
+		return err
+	}
+	if opts.SampleRows < 0 {
+		return fmt.Errorf("sample rows must not be negative")
+	}
+	if len(opts.Columns) > 0 && !opts.NoHeader {
+		return fmt.Errorf("column names can only be given for a file without a header")
+	}
+	if err := checkTableFile(matches[0], opts); err != nil {
+		return err
+	}
+
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return err
+	}
+	stored, err := cat.RelativePath(path)
+	if err != nil {
+		return err
+	}
+	table := catalog.Table{
+		Name:           name,
+		Path:           stored,
+		PrimaryKey:     opts.PrimaryKey,
+		SequenceColumn: opts.SequenceColumn,
+		Delimiter:      opts.Delimiter,
+		NoHeader:       opts.NoHeader,
+		NullValues:     opts.NullValues,
+		Strict:         opts.Strict,
+		SampleRows:     opts.SampleRows,
+	}
+	if encoding != operators.EncodingUTF8 {
+		table.Encoding = encoding
+	}
+	for _, col := range opts.Columns {
+		column := catalog.Column{Name: col}
+		if dt, ok := opts.ColumnTypes[col]; ok {
+			column.Type = dt.String()
+		}
+		table.Columns = append(table.Columns, column)
+	}
+	var typed []string
+	for col := range opts.ColumnTypes {
+		if !slices.Contains(opts.Columns, col) {
+			typed = append(typed, col)
+		}
+	}
+	sort.Strings(typed)
+	for _, col := range typed {
+		table.Columns = append(table.Columns, catalog.Column{Name: col, Type: opts.ColumnTypes[col].String()})
+	}
+	if err := cat.RegisterTable(table, opts.Replace); err != nil {
+		return err
+	}
+	return cat.Save()
+}
+
+// checkTableFile verifies that the file can be read with a table's options:
+// the sampled rows parse, and the key, sequence and typed columns exist
+func checkTableFile(path string, opts AttachOptions) error {
+	delimiter, _ := operators.ParseDelimiter(opts.Delimiter) // Checked by the caller
+	scanOpts := operators.ScanOptions{
+		Delimiter:   delimiter,
+		NoHeader:    opts.NoHeader,
+		ColumnNames: opts.Columns,
+		ColumnTypes: opts.ColumnTypes,
+		NullValues:  opts.NullValues,
+		Strict:      opts.Strict,
+		SampleRows:  opts.SampleRows,
+		Encoding:    opts.Encoding,
+	}
+	scan, err := operators.NewFileScan(path, scanOpts)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer scan.Close()
+	schema := scan.Schema()
+
+	sampleRows := opts.SampleRows
+	if sampleRows <= 0 {
+		sampleRows = operators.DefaultSampleRows
+	}
+	for i := 0; i < sampleRows; i++ {
+		row, err := scan.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+	}
+
+	columns := append(append([]string{}, opts.PrimaryKey...), opts.SequenceColumn)
+	for col := range opts.ColumnTypes {
+		columns = append(columns, col)
+	}
+	for _, col := range columns {
+		if col == "" {
+			continue // RegisterTable reports the missing sequence column
+		}
+		if schema.ColumnIndex(col) < 0 {
+			return fmt.Errorf("column not found in %s: %s", path, col)
+		}
+	}
+	return nil
+}
+
+// mergeOnRead collapses a merge-on-read table to the latest row per key:
+// sort by key, newest sequence first, then keep the first row of each key
+func (p *planner) mergeOnRead(source types.Operator, table catalog.Table) (types.Operator, error) {
+	schema := source.Schema()
+	keys := make([]operators.SortKey, 0, len(table.PrimaryKey)+1)
+	keyIndices := make([]int, 0, len(table.PrimaryKey))
+	for _, col := range table.PrimaryKey {
+		idx, err := p.resolveColumn(schema, col)
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("table %s: primary key column not found: %s", table.Name, col)
+		}
+		keys = append(keys, operators.SortKey{ColumnIndex: idx})
+		keyIndices = append(keyIndices, idx)
+	}
+	seqIdx, err := p.resolveColumn(schema, table.SequenceColumn)
+	if err != nil {
+		return nil, err
+	}
+	if seqIdx < 0 {
+		return nil, fmt.Errorf("table %s: sequence column not found: %s", table.Name, table.SequenceColumn)
+	}
+	keys = append(keys, operators.SortKey{ColumnIndex: seqIdx, Desc: true})
+
+	sorted := operators.NewMultiKeySortOp(source, keys, p.sortOptions())
+	return operators.NewDedupOp(sorted, keyIndices), nil
+}
+
+// DetachTable removes a table from the catalog (the data is untouched)
+func DetachTable(name string) error {
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return err
+	}
+	if err := cat.DropTable(name, false); err != nil {
+		return err
+	}
+	return cat.Save()
+}
+
+// showTables lists registered tables and views
+func (p *planner) showTables() (types.Operator, error) {
+	cat, err := p.loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*types.Row
+	for _, key := range cat.TableNames() {
+		table := cat.Tables[key]
+		rows = append(rows, &types.Row{Values: []interface{}{table.Name, "table", table.Path}})
+	}
+	for _, key := range cat.ViewNames() {
+		view := cat.Views[key]
+		rows = append(rows, &types.Row{Values: []interface{}{view.Name, "view", view.Query}})
+	}
+
+	schema := types.Schema{
+		Columns: []string{"name", "kind", "definition"},
+		Types:   []types.DataType{types.String, types.String, types.String},
+	}
+	return operators.NewValuesOp(schema, rows), nil
+}
+
+// showSchemas lists the columns and types of every table and view
+// Sources that fail to open (e.g. a missing file) are reported, not fatal
+func (p *planner) showSchemas() (types.Operator, error) {
+	cat, err := p.loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	names := append(cat.TableNames(), cat.ViewNames()...)
+	var rows []*types.Row
+	for _, name := range names {
+		source, _, err := p.openSource(name, 0)
+		if err != nil {
+			rows = append(rows, &types.Row{Values: []interface{}{name, nil, "error: " + err.Error()}})
+			continue
+		}
+		schema := source.Schema()
+		source.Close()
+		for i, col := range schema.Columns {
+			rows = append(rows, &types.Row{Values: []interface{}{name, col, schema.Types[i].String()}})
+		}
+	}
+
+	schema := types.Schema{
+		Columns: []string{"table", "column", "type"},
+		Types:   []types.DataType{types.String, types.String, types.String},
+	}
+	return operators.NewValuesOp(schema, rows), nil
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"regexp"
+
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DESCRIBE isn't understood by sqlparser either; match it up front
+var describePattern = regexp.MustCompile(`(?is)^\s*(?:DESCRIBE|DESC|SHOW\s+COLUMNS\s+(?:FROM|IN))\s+(.+?)\s*$`)
+
+// parseDescribeStatement recognizes DESCRIBE name, DESC name and
+// SHOW COLUMNS FROM name, returning the table (file or view) name
+func parseDescribeStatement(sql string) (string, bool) {
+	if m := describePattern.FindStringSubmatch(sql); m != nil {
+		return unquoteIdentifier(m[1]), true
+	}
+	return "", false
+}
+
+// describe lists the columns of a file or view with their types and how
+// each was chosen (declared, or inferred from a sample of rows), plus
+// min/max from the zone map and the distinct count, NULL fraction and
+// average width from ANALYZE statistics when they exist. Only the header
+// and the sampled rows are read, not the whole file.
+func (p *planner) describe(name string) (types.Operator, error) {
+	source, filePath, err := p.openSource(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	schema := source.Schema()
+	sourcer, _ := source.(operators.TypeSourcer) // Views and merged tables don't say
+	source.Close()
+
+	zm, _ := p.loadZoneMap(filePath) // Stats are optional
+	var stats *metadata.TableStats
+	if filePath != "" {
+		stats, _ = metadata.LoadStats(filePath)
+	}
+
+	rows := make([]*types.Row, len(schema.Columns))
+	for i, col := range schema.Columns {
+		var min, max interface{}
+		if zm != nil {
+			if v, ok := zm.MinValues[col]; ok {
+				min = v
+			}
+			if v, ok := zm.MaxValues[col]; ok {
+				max = v
+			}
+		}
+		var typeSource interface{}
+		if sourcer != nil {
+			typeSource = sourcer.TypeSource(i)
+		}
+		var distinct, nullFraction, avgWidth interface{}
+		if stats != nil {
+			if column, ok := stats.Columns[col]; ok {
+				distinct, nullFraction, avgWidth = column.Distinct, column.NullFraction, column.AvgWidth
+			}
+		}
+		rows[i] = &types.Row{Values: []interface{}{col, schema.Types[i].String(), typeSource, min, max, distinct, nullFraction, avgWidth}}
+	}
+
+	outputSchema := types.Schema{
+		Columns: []string{"column", "type", "type_source", "min", "max", "distinct", "null_fraction", "avg_width"},
+		Types:   []types.DataType{types.String, types.String, types.String, types.Int, types.Int, types.Int, types.Float, types.Float},
+	}
+	return operators.NewValuesOp(outputSchema, rows), nil
+}
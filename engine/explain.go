@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+var (
+	explainPattern        = regexp.MustCompile(`(?is)^\s*EXPLAIN\s+(.+)$`)
+	explainAnalyzePattern = regexp.MustCompile(`(?is)^\s*ANALYZE\s+(.+)$`)
+	explainOptionsPattern = regexp.MustCompile(`(?is)^\s*\(\s*((?:ANALYZE|FORMAT)\b[^)]*)\)\s*(.+)$`)
+)
+
+// Plan formats of EXPLAIN (FORMAT ...)
+const (
+	explainText = "TEXT" // Indented operator tree (the default)
+	explainJSON = "JSON" // The plan as a JSON document
+	explainDot  = "DOT"  // A Graphviz digraph
+)
+
+// explainOptions are what EXPLAIN [ANALYZE] or EXPLAIN (option, ...) asks for
+type explainOptions struct {
+	analyze bool
+	format  string
+}
+
+// parseExplainOptions splits EXPLAIN's options from the query explained:
+// ANALYZE, or a list in parentheses of ANALYZE and FORMAT TEXT|JSON|DOT
+func parseExplainOptions(query string) (explainOptions, string, error) {
+	opts := explainOptions{format: explainText}
+	if m := explainOptionsPattern.FindStringSubmatch(query); m != nil {
+		for _, option := range strings.Split(m[1], ",") {
+			fields := strings.Fields(strings.ToUpper(option))
+			switch {
+			case len(fields) == 1 && fields[0] == "ANALYZE":
+				opts.analyze = true
+			case len(fields) == 2 && fields[0] == "FORMAT":
+				switch fields[1] {
+				case explainText, explainJSON, explainDot:
+					opts.format = fields[1]
+				default:
+					return opts, "", fmt.Errorf("unknown EXPLAIN format %s: use TEXT, JSON or DOT", fields[1])
+				}
+			default:
+				return opts, "", fmt.Errorf("unknown EXPLAIN option: %s", strings.TrimSpace(option))
+			}
+		}
+		return opts, m[2], nil
+	}
+	if m := explainAnalyzePattern.FindStringSubmatch(query); m != nil {
+		if _, ok := parseAnalyzeStatement(query); !ok { // Not EXPLAIN of an ANALYZE statement
+			opts.analyze = true
+			query = m[1]
+		}
+	}
+	return opts, query, nil
+}
+
+// parseExplainStatement recognizes EXPLAIN <query>
+func parseExplainStatement(sql string) (string, bool) {
+	if m := explainPattern.FindStringSubmatch(sql); m != nil {
+		return m[1], true
+	}
+	return "", false
+}
+
+// explain plans a query without running it and returns the operator tree,
+// one line per operator, followed by the predicted temp space use. For
+// EXPLAIN ANALYZE <query>, the query is also run: each operator's line
+// adds what it actually did (see operators.OperatorStats), and what the
+// whole query cost follows. FORMAT JSON and FORMAT DOT return the same
+// as a JSON document or a Graphviz digraph, one row per line.
+func (p *planner) explain(query string, viewDepth int) (types.Operator, error) {
+	opts, query, err := parseExplainOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// View DDL takes effect at plan time, so it can't be explained safely
+	if _, ok := parseViewStatement(query); ok {
+		return nil, fmt.Errorf("EXPLAIN supports queries, not view DDL")
+	}
+	if _, ok := parseAnalyzeStatement(query); ok {
+		return nil, fmt.Errorf("EXPLAIN supports queries, not ANALYZE")
+	}
+
+	op, err := p.planQuery(query, viewDepth)
+	if err != nil {
+		return nil, err
+	}
+	plan := operators.ExplainOperator(op)
+	var run *executionStats
+	var actual operators.OperatorStats
+	if opts.analyze {
+		operators.EnableTiming(op)
+		run, err = runForStats(op)
+		actual = operators.CollectStats(op)
+	}
+	op.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	switch opts.format {
+	case explainJSON:
+		lines, err = p.explainJSON(plan, run, actual)
+		if err != nil {
+			return nil, err
+		}
+	case explainDot:
+		lines = plan.Dot()
+		if run != nil {
+			lines = actual.Dot()
+		}
+	default:
+		lines = p.explainText(plan, run, actual)
+	}
+
+	rows := make([]*types.Row, len(lines))
+	for i, line := range lines {
+		rows[i] = &types.Row{Values: []interface{}{line}}
+	}
+	schema := types.Schema{
+		Columns: []string{"plan"},
+		Types:   []types.DataType{types.String},
+	}
+	return operators.NewValuesOp(schema, rows), nil
+}
+
+// explainText renders a plan as indented lines, followed by the predicted
+// temp space and, if the query ran, what it cost
+func (p *planner) explainText(plan operators.PlanNode, run *executionStats, actual operators.OperatorStats) []string {
+	lines := plan.Lines()
+	if run != nil {
+		lines = actual.Lines()
+	}
+	spill := plan.TotalSpillBytes()
+	quota := p.tempQuota.Limit()
+	switch {
+	case spill < 0:
+		lines = append(lines, "Predicted temp space: unknown")
+	case spill == 0:
+		lines = append(lines, "Predicted temp space: none")
+	case p.opts.SpillCompression != operators.SpillUncompressed:
+		lines = append(lines, fmt.Sprintf("Predicted temp space: ~%s before %s compression", operators.FormatBytes(spill), p.opts.SpillCompression))
+	default:
+		lines = append(lines, fmt.Sprintf("Predicted temp space: ~%s", operators.FormatBytes(spill)))
+	}
+	if quota > 0 {
+		line := fmt.Sprintf("Temp space quota: %s", operators.FormatBytes(quota))
+		if spill > quota {
+			line += " (likely to be exceeded)"
+		}
+		lines = append(lines, line)
+	}
+	if run != nil {
+		lines = append(lines,
+			fmt.Sprintf("Actual rows: %d", run.Rows),
+			fmt.Sprintf("Execution time: %s", run.Time.Round(time.Microsecond)),
+			fmt.Sprintf("Allocations: %d (%s), %d GC cycles", run.Allocations, operators.FormatBytes(run.AllocatedBytes), run.GCCycles),
+			fmt.Sprintf("Pooled rows: %d used, %d reused", run.PooledRows, run.ReusedRows),
+			fmt.Sprintf("Temp space written: %s", operators.FormatBytes(actual.TotalSpillBytes())),
+		)
+	}
+	return lines
+}
+
+// explainDocument is EXPLAIN (FORMAT JSON)'s output
+type explainDocument struct {
+	Plan               operators.PlanNode       `json:"plan"`
+	PredictedTempBytes int64                    `json:"predicted_temp_bytes"` // -1 = unknown
+	TempQuotaBytes     int64                    `json:"temp_quota_bytes,omitempty"`
+	Actual             *operators.OperatorStats `json:"actual,omitempty"` // EXPLAIN ANALYZE only
+	Execution          *executionStats          `json:"execution,omitempty"`
+}
+
+// explainJSON renders a plan, and what running it cost if it ran, as an
+// indented JSON document
+func (p *planner) explainJSON(plan operators.PlanNode, run *executionStats, actual operators.OperatorStats) ([]string, error) {
+	doc := explainDocument{
+		Plan:               plan,
+		PredictedTempBytes: plan.TotalSpillBytes(),
+		TempQuotaBytes:     p.tempQuota.Limit(),
+		Execution:          run,
+	}
+	if run != nil {
+		doc.Actual = &actual
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+// executionStats is what running a query cost as a whole
+type executionStats struct {
+	Rows           int64         `json:"rows"`
+	Time           time.Duration `json:"time_ns"`
+	Allocations    uint64        `json:"allocations"`
+	AllocatedBytes int64         `json:"allocated_bytes"`
+	GCCycles       uint32        `json:"gc_cycles"`
+	PooledRows     int64         `json:"pooled_rows"` // Rows taken from the row pool
+	ReusedRows     int64         `json:"reused_rows"` // Of those, rows that weren't newly allocated
+}
+
+// runForStats runs a planned query to the end, discarding its rows, and
+// measures what it cost: rows returned, time, the memory allocated, and
+// how many rows came from the row pool rather than being allocated
+func runForStats(op types.Operator) (*executionStats, error) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	poolGets, poolAllocated := types.RowPoolStats()
+	start := time.Now()
+
+	var rows int64
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		types.ReleaseRow(row)
+		rows++
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	gets, allocated := types.RowPoolStats()
+	gets, allocated = gets-poolGets, allocated-poolAllocated
+	return &executionStats{
+		Rows:           rows,
+		Time:           elapsed,
+		Allocations:    after.Mallocs - before.Mallocs,
+		AllocatedBytes: int64(after.TotalAlloc - before.TotalAlloc),
+		GCCycles:       after.NumGC - before.NumGC,
+		PooledRows:     gets,
+		ReusedRows:     gets - allocated,
+	}, nil
+}
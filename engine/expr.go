@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// buildValueExpr converts a scalar SQL expression (columns, literals,
+// arithmetic, CASE, scalar functions) into a per-row evaluator
+func (p *planner) buildValueExpr(expr sqlparser.Expr, schema types.Schema) (operators.ValueExpr, error) {
+	switch e := expr.(type) {
+	case *sqlparser.ColName:
+		colName, err := extractColumnName(e)
+		if err != nil {
+			return nil, err
+		}
+		colIdx, err := p.resolveColumn(schema, colName)
+		if err != nil {
+			return nil, err
+		}
+		if colIdx < 0 {
+			return nil, fmt.Errorf("column not found in schema: %s", colName)
+		}
+		return operators.ColumnExpr(colIdx), nil
+
+	case *sqlparser.SQLVal, *sqlparser.NullVal:
+		value, err := extractValue(e)
+		if err != nil {
+			return nil, err
+		}
+		return operators.LiteralExpr(value), nil
+
+	case *sqlparser.ParenExpr:
+		return p.buildValueExpr(e.Expr, schema)
+
+	case *sqlparser.UnaryExpr:
+		inner, err := p.buildValueExpr(e.Expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case sqlparser.UMinusStr:
+			return operators.NegateExpr(inner), nil
+		case sqlparser.UPlusStr:
+			return inner, nil
+		default:
+			return nil, fmt.Errorf("unsupported unary operator: %s", e.Operator)
+		}
+
+	case *sqlparser.BinaryExpr:
+		op, err := mapArithmeticOp(e.Operator)
+		if err != nil {
+			return nil, err
+		}
+		left, err := p.buildValueExpr(e.Left, schema)
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.buildValueExpr(e.Right, schema)
+		if err != nil {
+			return nil, err
+		}
+		return operators.ArithmeticExpr(op, left, right), nil
+
+	case *sqlparser.CaseExpr:
+		return p.buildCaseExpr(e, schema)
+
+	case *sqlparser.FuncExpr:
+		if isScalarFunction(e) {
+			return p.buildScalarFunc(e, schema)
+		}
+		// Over an aggregate's output (HAVING, the SELECT list), an
+		// aggregate is its output column
+		if colIdx := p.columnIndex(schema, aggregateColumnName(e)); colIdx >= 0 {
+			return operators.ColumnExpr(colIdx), nil
+		}
+		return nil, fmt.Errorf("aggregate not allowed here: %s", sqlparser.String(e))
+
+	default:
+		return nil, fmt.Errorf("unsupported expression type: %T", expr)
+	}
+}
+
+// exprType infers the result type of a scalar expression for output schemas
+// Arithmetic on Ints stays Int except division; anything unknown is String
+func (p *planner) exprType(expr sqlparser.Expr, schema types.Schema) types.DataType {
+	switch e := expr.(type) {
+	case *sqlparser.ColName:
+		colName, err := extractColumnName(e)
+		if err != nil {
+			return types.String
+		}
+		if colIdx := p.columnIndex(schema, colName); colIdx >= 0 {
+			return schema.Types[colIdx]
+		}
+		return types.String
+
+	case *sqlparser.SQLVal:
+		switch e.Type {
+		case sqlparser.IntVal:
+			return types.Int
+		case sqlparser.FloatVal:
+			return types.Float
+		default:
+			return types.String
+		}
+
+	case *sqlparser.ParenExpr:
+		return p.exprType(e.Expr, schema)
+
+	case *sqlparser.UnaryExpr:
+		return p.exprType(e.Expr, schema)
+
+	case *sqlparser.BinaryExpr:
+		left := p.exprType(e.Left, schema)
+		right := p.exprType(e.Right, schema)
+		if left == types.Int && right == types.Int && e.Operator != sqlparser.DivStr {
+			return types.Int
+		}
+		return types.Float
+
+	case *sqlparser.CaseExpr:
+		// The widest of the results: Int, then Float, then String
+		results := []sqlparser.Expr{e.Else}
+		for _, when := range e.Whens {
+			results = append(results, when.Val)
+		}
+		resultType, typed := types.Int, false
+		for _, result := range results {
+			if _, isNull := result.(*sqlparser.NullVal); result == nil || isNull {
+				continue
+			}
+			resultType, typed = max(resultType, p.exprType(result, schema)), true
+		}
+		if !typed {
+			return types.String
+		}
+		return resultType
+
+	case *sqlparser.FuncExpr:
+		if isScalarFunction(e) {
+			return scalarFunctionType(e)
+		}
+		// In HAVING, an aggregate is its output column
+		if colIdx := p.columnIndex(schema, aggregateColumnName(e)); colIdx >= 0 {
+			return schema.Types[colIdx]
+		}
+		return types.Float
+
+	default:
+		return types.String
+	}
+}
+
+// buildCaseExpr handles both searched (CASE WHEN cond THEN ...) and simple
+// (CASE x WHEN value THEN ...) forms
+func (p *planner) buildCaseExpr(expr *sqlparser.CaseExpr, schema types.Schema) (operators.ValueExpr, error) {
+	var operand operators.ValueExpr
+	if expr.Expr != nil {
+		var err error
+		operand, err = p.buildValueExpr(expr.Expr, schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	whens := make([]operators.CaseWhen, len(expr.Whens))
+	for i, when := range expr.Whens {
+		var cond operators.Predicate
+		if operand != nil {
+			value, err := p.buildValueExpr(when.Cond, schema)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparableExprs(expr.Expr, when.Cond, schema, expr); err != nil {
+				return nil, err
+			}
+			cond = operators.BuildCollatedExprComparisonPredicate(operand, types.Eq, value, p.opts.Collation)
+		} else {
+			predicates, err := p.buildPredicates(when.Cond, schema)
+			if err != nil {
+				return nil, err
+			}
+			cond = operators.AndPredicate(predicates...)
+		}
+
+		result, err := p.buildValueExpr(when.Val, schema)
+		if err != nil {
+			return nil, err
+		}
+		whens[i] = operators.CaseWhen{Condition: cond, Result: result}
+	}
+
+	var elseExpr operators.ValueExpr
+	if expr.Else != nil {
+		var err error
+		elseExpr, err = p.buildValueExpr(expr.Else, schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return operators.CaseExpr(whens, elseExpr), nil
+}
+
+// mapArithmeticOp converts a SQL arithmetic operator to an ArithmeticOp
+func mapArithmeticOp(operator string) (types.ArithmeticOp, error) {
+	switch operator {
+	case sqlparser.PlusStr:
+		return types.Add, nil
+	case sqlparser.MinusStr:
+		return types.Sub, nil
+	case sqlparser.MultStr:
+		return types.Mul, nil
+	case sqlparser.DivStr:
+		return types.Div, nil
+	case sqlparser.ModStr:
+		return types.Mod, nil
+	default:
+		return 0, fmt.Errorf("unsupported arithmetic operator: %s", operator)
+	}
+}
@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// dataFileExtensions are the files a directory in FROM contributes
+// (optionally .gz-compressed); sidecars like .zonemap.json are skipped
+var dataFileExtensions = []string{".csv", ".tsv", ".psv", ".txt", ".jsonl", ".ndjson", ".arrow", ".feather", ".arrows", ".golap"}
+
+// expandDataPath returns the files a FROM path names, sorted: the file
+// itself, every match of a glob (data/2024-*.csv), or every data file
+// inside a directory (data/), including its Hive-style key=value
+// partition directories (data/date=2024-01-01/)
+func expandDataPath(path string) ([]string, error) {
+	if storage.IsRemote(path) {
+		if err := storage.CheckScheme(path); err != nil {
+			return nil, err
+		}
+		web := strings.HasPrefix(strings.ToLower(path), "http://") || strings.HasPrefix(strings.ToLower(path), "https://")
+		if !web && (strings.ContainsAny(path, "*?[") || strings.HasSuffix(path, "/")) {
+			return nil, fmt.Errorf("globs and directories are not supported for remote paths: %s", path)
+		}
+		return []string{path}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && !info.IsDir() {
+		return []string{path}, nil // Even if the name has glob characters
+	}
+
+	if err == nil {
+		files, err := listDataFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no data files in directory: %s", path)
+		}
+		return files, nil
+	}
+
+	if !strings.ContainsAny(path, "*?[") {
+		return []string{path}, nil // Let the scan report the missing file
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern: %w", err)
+	}
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			files = append(files, match)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match: %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// listDataFiles returns the data files in a directory and, recursively, in
+// its partition directories, in name order
+func listDataFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && isPartitionDir(name):
+			nested, err := listDataFiles(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nested...)
+		case !entry.IsDir() && isDataFile(name):
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil // ReadDir sorts by name
+}
+
+// isPartitionDir reports whether a directory name is a key=value partition
+func isPartitionDir(name string) bool {
+	key, _, ok := strings.Cut(name, "=")
+	return ok && key != "" && !strings.HasPrefix(name, ".")
+}
+
+// isDataFile reports whether a directory entry looks like a data file
+func isDataFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(name, ".gz")))
+	for _, dataExt := range dataFileExtensions {
+		if ext == dataExt {
+			return true
+		}
+	}
+	return false
+}
+
+// prunePartitions drops the files of a partitioned scan that a WHERE clause
+// rules out. Each AND term that reads only partition columns is constant
+// within a file, so evaluating it on the file's partition values decides it
+// for all the file's rows. Other terms are left to the filters.
+func (p *planner) prunePartitions(scan *operators.MultiFileScan, where sqlparser.Expr, schema types.Schema) {
+	partition := make(map[int]bool)
+	for _, col := range scan.PartitionColumns() {
+		partition[col] = true
+	}
+	if len(partition) == 0 {
+		return
+	}
+
+	var keep []operators.Predicate
+	for _, conjunct := range splitConjuncts(where) {
+		onlyPartitions, columns := true, 0
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if col, ok := node.(*sqlparser.ColName); ok {
+				columns++
+				idx := p.columnIndex(schema, strings.Trim(col.Name.String(), "`\""))
+				onlyPartitions = onlyPartitions && partition[idx]
+			}
+			return onlyPartitions, nil
+		}, conjunct)
+		if !onlyPartitions || columns == 0 {
+			continue
+		}
+		predicates, err := p.buildPredicates(conjunct, schema)
+		if err != nil {
+			continue // Reported when the filter itself is built
+		}
+		keep = append(keep, predicates...)
+	}
+	if len(keep) > 0 {
+		scan.PrunePartitions(operators.AndPredicate(keep...))
+	}
+}
+
+// referencedColumns marks the columns of schema that nodes (expressions,
+// SELECT lists) refer to. ok is false when every column may be needed: for
+// SELECT *, or a name that isn't one of the columns (such as an alias).
+func (p *planner) referencedColumns(nodes []sqlparser.SQLNode, schema types.Schema) (needed []bool, ok bool) {
+	needed = make([]bool, len(schema.Columns))
+	ok = true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.FuncExpr:
+			if len(n.Exprs) == 1 {
+				if _, star := n.Exprs[0].(*sqlparser.StarExpr); star {
+					return false, nil // COUNT(*) reads no column
+				}
+			}
+		case *sqlparser.StarExpr:
+			ok = false
+		case *sqlparser.ColName:
+			idx := p.columnIndex(schema, strings.Trim(n.Name.String(), "`\""))
+			if idx < 0 {
+				ok = false
+			} else {
+				needed[idx] = true
+			}
+		}
+		return ok, nil
+	}, nodes...)
+	return needed, ok
+}
+
+// singleColumn returns the index of the one column an expression reads,
+// if it reads exactly one
+func (p *planner) singleColumn(expr sqlparser.Expr, schema types.Schema) (int, bool) {
+	columns, ok := p.columnsRead(expr, schema)
+	if !ok || len(columns) != 1 {
+		return -1, false
+	}
+	return columns[0], true
+}
+
+// columnsRead returns the indices of the columns an expression reads. ok
+// is false if it names something that isn't one of the columns.
+func (p *planner) columnsRead(expr sqlparser.Expr, schema types.Schema) (columns []int, ok bool) {
+	ok = true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if col, isCol := node.(*sqlparser.ColName); isCol {
+			idx := p.columnIndex(schema, strings.Trim(col.Name.String(), "`\""))
+			if idx < 0 {
+				ok = false
+			} else if !slices.Contains(columns, idx) {
+				columns = append(columns, idx)
+			}
+		}
+		return ok, nil
+	}, expr)
+	return columns, ok
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// isScalarFunction reports whether a function call is a per-row scalar
+// function (built in or registered) rather than an aggregate
+func isScalarFunction(fn *sqlparser.FuncExpr) bool {
+	switch strings.ToUpper(fn.Name.String()) {
+	case "HASH", "BUCKET", "HASH_SHA256", "MASK_EMAIL", "GENERALIZE_DATE":
+		return true
+	default:
+		return lookupUDF(fn.Name.String()) != nil
+	}
+}
+
+// scalarFunctionType returns the result type of a scalar function
+func scalarFunctionType(fn *sqlparser.FuncExpr) types.DataType {
+	switch strings.ToUpper(fn.Name.String()) {
+	case "HASH", "BUCKET":
+		return types.Int
+	default:
+		if udf := lookupUDF(fn.Name.String()); udf != nil {
+			return udf.result
+		}
+		return types.String
+	}
+}
+
+// buildScalarFunc builds a scalar function call:
+//
+//	HASH(a [, b ...])             stable non-negative hash of the values
+//	BUCKET(a, n)                  HASH(a) mod n, for partitioning and sampling
+//	HASH_SHA256(a [, salt])       hex SHA-256 of the value, for pseudonymizing
+//	MASK_EMAIL(a)                 j***@example.com
+//	GENERALIZE_DATE(a [, level])  the date truncated to day/week/month/quarter/year
+//
+// or a function registered with RegisterScalar
+func (p *planner) buildScalarFunc(fn *sqlparser.FuncExpr, schema types.Schema) (operators.ValueExpr, error) {
+	funcName := strings.ToUpper(fn.Name.String())
+
+	args := make([]operators.ValueExpr, len(fn.Exprs))
+	for i, arg := range fn.Exprs {
+		aliased, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, fmt.Errorf("%s does not accept *", funcName)
+		}
+		expr, err := p.buildValueExpr(aliased.Expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = expr
+	}
+
+	switch funcName {
+	case "HASH":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("HASH requires at least one argument")
+		}
+		return operators.HashExpr(args...), nil
+
+	case "BUCKET":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("BUCKET requires two arguments: BUCKET(value, n)")
+		}
+		n, err := extractValue(fn.Exprs[1].(*sqlparser.AliasedExpr).Expr)
+		buckets, ok := n.(int64)
+		if err != nil || !ok || buckets <= 0 {
+			return nil, fmt.Errorf("BUCKET count must be a positive integer")
+		}
+		return operators.BucketExpr(args[0], buckets), nil
+
+	case "HASH_SHA256":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("HASH_SHA256 requires one or two arguments: HASH_SHA256(value [, salt])")
+		}
+		var salt operators.ValueExpr
+		if len(args) == 2 {
+			salt = args[1]
+		}
+		return operators.SHA256Expr(args[0], salt), nil
+
+	case "MASK_EMAIL":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("MASK_EMAIL requires one argument")
+		}
+		return operators.MaskEmailExpr(args[0]), nil
+
+	case "GENERALIZE_DATE":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("GENERALIZE_DATE requires one or two arguments: GENERALIZE_DATE(date [, 'month'])")
+		}
+		granularity := "month"
+		if len(args) == 2 {
+			level, err := extractValue(fn.Exprs[1].(*sqlparser.AliasedExpr).Expr)
+			name, ok := level.(string)
+			if err != nil || !ok || !isDateGranularity(strings.ToLower(name)) {
+				return nil, fmt.Errorf("GENERALIZE_DATE level must be one of '%s'", strings.Join(operators.DateGranularities, "', '"))
+			}
+			granularity = strings.ToLower(name)
+		}
+		return operators.GeneralizeDateExpr(args[0], granularity), nil
+
+	default:
+		udf := lookupUDF(funcName)
+		if udf == nil {
+			return nil, fmt.Errorf("unsupported function: %s", funcName)
+		}
+		argTypes := make([]*types.DataType, len(fn.Exprs))
+		for i, arg := range fn.Exprs {
+			// A NULL literal fits any parameter
+			if _, null := arg.(*sqlparser.AliasedExpr).Expr.(*sqlparser.NullVal); !null {
+				argType := p.exprType(arg.(*sqlparser.AliasedExpr).Expr, schema)
+				argTypes[i] = &argType
+			}
+		}
+		var errs *operators.ExprErrors
+		if udf.hasErr {
+			if p.exprErrors == nil {
+				p.exprErrors = &operators.ExprErrors{}
+			}
+			errs = p.exprErrors
+		}
+		return udf.build(args, argTypes, errs)
+	}
+}
+
+// isDateGranularity reports whether name is a GENERALIZE_DATE level
+func isDateGranularity(name string) bool {
+	for _, level := range operators.DateGranularities {
+		if name == level {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,903 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// A SELECT is planned in three steps: buildStatement turns the parsed
+// query into a logical plan, a tree of what to compute in which order;
+// optimize rewrites it with the rules in rules.go; and lower turns it into
+// operators. Logical nodes keep their expressions as parsed SQL, resolved
+// against their input's schema only when lowered, so rules can move and
+// rewrite them freely. Sources are opened while building, since names
+// resolve against the columns a file turns out to have.
+
+// logicalNode is one step of a logical plan
+type logicalNode interface {
+	logical()
+}
+
+// logicalScan reads a FROM source: a file, table or view, already opened.
+// conditions are WHERE terms applied at the source: pushed into the scan
+// when it can evaluate them, as filters right above it otherwise, and
+// used to skip files, partitions and row groups.
+type logicalScan struct {
+	name       string // As written in FROM
+	op         types.Operator
+	filePath   string // Backing data file, "" if none (see openSource)
+	conditions []sqlparser.Expr
+	needed     []bool // Columns read, set by pruneColumns; nil = all
+}
+
+// logicalFilter keeps the rows for which every conjunct is true
+type logicalFilter struct {
+	input     logicalNode
+	conjuncts []sqlparser.Expr
+	clause    string // WHERE or HAVING, for errors
+}
+
+// logicalAggregate computes the aggregates a query uses, per group if
+// groupBy is set: its rows are the GROUP BY columns, then the aggregates,
+// each named by aggregateColumnName. Aggregates are resolved when built,
+// since whether a query aggregates decides the shape of the plan.
+type logicalAggregate struct {
+	input       logicalNode
+	inputSchema types.Schema
+	groupBy     []int
+	aggregates  []operators.AggregateExpr
+	exprs       sqlparser.SelectExprs // The aggregate calls as written, for pruneColumns
+	groupByExpr sqlparser.GroupBy
+}
+
+// logicalProject computes the SELECT list
+type logicalProject struct {
+	input logicalNode
+	exprs sqlparser.SelectExprs
+}
+
+// logicalDistinct removes duplicate rows
+type logicalDistinct struct {
+	input logicalNode
+}
+
+// logicalSort orders rows by its keys, later keys breaking ties
+type logicalSort struct {
+	input logicalNode
+	keys  []sortKey
+}
+
+// sortKey is an ORDER BY key: the column expr names, or when expr is nil,
+// the input's column at position
+type sortKey struct {
+	expr     sqlparser.Expr
+	position int
+	desc     bool
+}
+
+// logicalGroupOrder sorts GROUP BY results by their first columns, the
+// GROUP BY columns (see Options.SortGroups)
+type logicalGroupOrder struct {
+	input   logicalNode
+	columns int
+}
+
+// logicalLimit returns at most count rows
+type logicalLimit struct {
+	input logicalNode
+	count int
+}
+
+// logicalValues returns rows computed while planning
+type logicalValues struct {
+	op *operators.ValuesOp
+}
+
+// logicalRename returns columns of its input's rows, in that order, under
+// the names of schema (the input's own names and types if it has none)
+type logicalRename struct {
+	input   logicalNode
+	columns []int
+	schema  types.Schema
+}
+
+// logicalUnion returns the left rows, then the right ones (UNION ALL)
+type logicalUnion struct {
+	left, right logicalNode
+}
+
+func (*logicalScan) logical()       {}
+func (*logicalFilter) logical()     {}
+func (*logicalAggregate) logical()  {}
+func (*logicalProject) logical()    {}
+func (*logicalDistinct) logical()   {}
+func (*logicalSort) logical()       {}
+func (*logicalGroupOrder) logical() {}
+func (*logicalLimit) logical()      {}
+func (*logicalUnion) logical()      {}
+func (*logicalValues) logical()     {}
+func (*logicalRename) logical()     {}
+
+// inputsOf returns where a node keeps its inputs, so rules can replace them
+func inputsOf(node logicalNode) []*logicalNode {
+	switch n := node.(type) {
+	case *logicalFilter:
+		return []*logicalNode{&n.input}
+	case *logicalAggregate:
+		return []*logicalNode{&n.input}
+	case *logicalProject:
+		return []*logicalNode{&n.input}
+	case *logicalDistinct:
+		return []*logicalNode{&n.input}
+	case *logicalSort:
+		return []*logicalNode{&n.input}
+	case *logicalGroupOrder:
+		return []*logicalNode{&n.input}
+	case *logicalLimit:
+		return []*logicalNode{&n.input}
+	case *logicalRename:
+		return []*logicalNode{&n.input}
+	case *logicalUnion:
+		return []*logicalNode{&n.left, &n.right}
+	default:
+		return nil
+	}
+}
+
+// closeSources closes the sources a plan opened, for when it won't be run
+func closeSources(node logicalNode) {
+	if scan, ok := node.(*logicalScan); ok {
+		scan.op.Close()
+	}
+	for _, input := range inputsOf(node) {
+		closeSources(*input)
+	}
+}
+
+// buildStatement builds the logical plan of a SELECT, a UNION of them, or
+// either in parentheses
+func (p *planner) buildStatement(stmt sqlparser.Statement, viewDepth int) (logicalNode, error) {
+	switch s := stmt.(type) {
+	case *sqlparser.Select:
+		return p.buildSelect(s, viewDepth)
+	case *sqlparser.Union:
+		return p.buildUnion(s, viewDepth)
+	case *sqlparser.ParenSelect:
+		return p.buildStatement(s.Select, viewDepth)
+	default:
+		return nil, fmt.Errorf("only SELECT statements are supported")
+	}
+}
+
+// buildUnion plans UNION ALL as a concatenation of both sides, and UNION as
+// that followed by DISTINCT. ORDER BY and LIMIT apply to the combined rows.
+func (p *planner) buildUnion(union *sqlparser.Union, viewDepth int) (logicalNode, error) {
+	left, err := p.buildStatement(union.Left, viewDepth)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.buildStatement(union.Right, viewDepth)
+	if err != nil {
+		closeSources(left)
+		return nil, err
+	}
+
+	var node logicalNode = &logicalUnion{left: left, right: right}
+	if union.Type != sqlparser.UnionAllStr {
+		node = &logicalDistinct{input: node}
+	}
+	node, err = buildOrderByLimit(node, union.OrderBy, union.Limit)
+	if err != nil {
+		closeSources(node)
+		return nil, err
+	}
+	return node, nil
+}
+
+// buildSelect plans a single SELECT, in the order SQL defines:
+// Scan -> Filter (WHERE) -> Aggregate -> Filter (HAVING) ->
+// [Project -> Distinct] -> Sort -> Project -> Limit
+func (p *planner) buildSelect(selectStmt *sqlparser.Select, viewDepth int) (logicalNode, error) {
+	if len(selectStmt.From) != 1 {
+		return nil, fmt.Errorf("exactly one table (CSV file) required in FROM clause")
+	}
+	tableName, err := extractTableName(selectStmt.From[0])
+	if err != nil {
+		return nil, err
+	}
+	op, filePath, err := p.openSource(tableName, viewDepth)
+	if err != nil {
+		return nil, err
+	}
+	var node logicalNode = &logicalScan{name: tableName, op: op, filePath: filePath}
+	schema := op.Schema()
+
+	if selectStmt.Where != nil {
+		node = &logicalFilter{input: node, conjuncts: splitConjuncts(selectStmt.Where.Expr), clause: "WHERE"}
+	}
+
+	var selected logicalNode
+	if calls := aggregateCalls(selectStmt); len(calls) > 0 || len(selectStmt.GroupBy) > 0 {
+		selected, err = p.buildAggregateSelect(node, schema, selectStmt, calls)
+	} else {
+		selected, err = p.buildRowSelect(node, schema, selectStmt)
+	}
+	if err != nil {
+		closeSources(node)
+		return nil, err
+	}
+	node, err = buildOrderByLimit(selected, nil, selectStmt.Limit)
+	if err != nil {
+		closeSources(node)
+		return nil, err
+	}
+	return node, nil
+}
+
+// buildRowSelect plans the SELECT list, DISTINCT and ORDER BY of a query
+// without aggregates. DISTINCT compares the selected columns, so project
+// before it (ORDER BY can then only use selected columns, as in standard
+// SQL); otherwise ORDER BY may use any column, so the projection comes
+// after the sort.
+func (p *planner) buildRowSelect(node logicalNode, schema types.Schema, selectStmt *sqlparser.Select) (logicalNode, error) {
+	if selectStmt.Having != nil {
+		return nil, fmt.Errorf("HAVING requires an aggregate query")
+	}
+	items, err := p.selectItems(selectStmt.SelectExprs, schema)
+	if err != nil {
+		return nil, err
+	}
+	project := len(items) > 0
+	distinct := selectStmt.Distinct != ""
+	if distinct {
+		if project {
+			node = &logicalProject{input: node, exprs: selectStmt.SelectExprs}
+		}
+		node = &logicalDistinct{input: node}
+	}
+	node, _ = buildOrderByLimit(node, selectStmt.OrderBy, nil)
+	if !distinct && project {
+		node = &logicalProject{input: node, exprs: selectStmt.SelectExprs}
+	}
+	return node, nil
+}
+
+// buildAggregateSelect plans a query with aggregates or GROUP BY. The
+// aggregate computes every aggregate the SELECT list, HAVING and ORDER BY
+// call, once each; HAVING filters its rows, and the SELECT list is
+// projected over them (unless it is just the aggregate's output), where an
+// aggregate reads as its column. Columns outside aggregates must be GROUP
+// BY columns. ORDER BY keys are SELECT list columns, by name or as
+// written, or else expressions over the groups, projected as hidden
+// columns after the SELECT list and dropped after the sort.
+func (p *planner) buildAggregateSelect(node logicalNode, schema types.Schema, selectStmt *sqlparser.Select, calls []*sqlparser.FuncExpr) (logicalNode, error) {
+	node, schema, err := p.projectGroupByExprs(node, schema, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	aggregate := &logicalAggregate{
+		input:       node,
+		inputSchema: schema,
+		groupByExpr: selectStmt.GroupBy,
+	}
+	for _, expr := range selectStmt.GroupBy {
+		colName := strings.Trim(sqlparser.String(expr), "`\"")
+		colIdx, err := p.resolveColumn(schema, colName)
+		if err != nil {
+			return nil, err
+		}
+		if colIdx < 0 {
+			return nil, fmt.Errorf("GROUP BY column not found: %s", colName)
+		}
+		aggregate.groupBy = append(aggregate.groupBy, colIdx)
+	}
+	for _, call := range calls {
+		agg, err := p.parseAggregateFunc(call, schema, "")
+		if err != nil {
+			return nil, err
+		}
+		aggregate.aggregates = append(aggregate.aggregates, agg)
+		aggregate.exprs = append(aggregate.exprs, &sqlparser.AliasedExpr{Expr: call})
+	}
+	for _, expr := range selectStmt.SelectExprs {
+		if _, star := expr.(*sqlparser.StarExpr); star {
+			return nil, fmt.Errorf("SELECT * cannot be used with GROUP BY or aggregates")
+		}
+		if err := p.checkGrouped(expr, schema, aggregate.groupBy); err != nil {
+			return nil, err
+		}
+	}
+
+	node = aggregate
+	if selectStmt.Having != nil {
+		having := p.resolveAliases(selectStmt.Having.Expr, selectStmt.SelectExprs, schema)
+		if err := p.checkGrouped(having, schema, aggregate.groupBy); err != nil {
+			return nil, err
+		}
+		node = &logicalFilter{input: node, conjuncts: splitConjuncts(having), clause: "HAVING"}
+	}
+	if p.opts.SortGroups && len(aggregate.groupBy) > 0 {
+		node = &logicalGroupOrder{input: node, columns: len(aggregate.groupBy)}
+	}
+
+	distinct := selectStmt.Distinct != ""
+	names := p.outputNames(selectStmt.SelectExprs, schema)
+	var keys []sortKey
+	var hidden sqlparser.SelectExprs
+	for _, order := range selectStmt.OrderBy {
+		position, err := p.orderPosition(order.Expr, selectStmt.SelectExprs, names)
+		if err != nil {
+			return nil, err
+		}
+		if position < 0 {
+			if distinct {
+				return nil, fmt.Errorf("ORDER BY %s must be in the SELECT list with DISTINCT", sqlparser.String(order.Expr))
+			}
+			expr := p.resolveAliases(order.Expr, selectStmt.SelectExprs, schema)
+			if err := p.checkGrouped(expr, schema, aggregate.groupBy); err != nil {
+				return nil, err
+			}
+			position = len(names) + len(hidden)
+			hidden = append(hidden, &sqlparser.AliasedExpr{Expr: expr})
+		}
+		keys = append(keys, sortKey{position: position, desc: order.Direction == sqlparser.DescScr})
+	}
+
+	if len(hidden) > 0 || !p.isAggregateOutput(selectStmt.SelectExprs, aggregate) {
+		exprs := append(slices.Clip(selectStmt.SelectExprs), hidden...)
+		node = &logicalProject{input: node, exprs: exprs}
+	}
+	if distinct {
+		node = &logicalDistinct{input: node}
+	}
+	if len(keys) > 0 {
+		node = &logicalSort{input: node, keys: keys}
+	}
+	if len(hidden) > 0 {
+		columns := make([]int, len(names))
+		for i := range columns {
+			columns[i] = i
+		}
+		node = &logicalRename{input: node, columns: columns}
+	}
+	return node, nil
+}
+
+// projectGroupByExprs computes a query's GROUP BY expressions, other than
+// plain columns, below its aggregate: as columns after the input's, named
+// as written. The query then groups by those columns, and its SELECT list,
+// HAVING and ORDER BY read them wherever they repeat an expression, so
+// SELECT a + b ... GROUP BY a + b selects the groups' values. Returns the
+// aggregate's input and its schema.
+func (p *planner) projectGroupByExprs(node logicalNode, schema types.Schema, selectStmt *sqlparser.Select) (logicalNode, types.Schema, error) {
+	var computed []*sqlparser.AliasedExpr
+	grouped := make(map[string]*sqlparser.ColName)
+	for i, expr := range selectStmt.GroupBy {
+		switch expr.(type) {
+		case *sqlparser.ColName, *sqlparser.SQLVal:
+			continue // Columns, and positions (which aren't supported)
+		}
+		if _, err := p.buildValueExpr(expr, schema); err != nil {
+			return node, schema, fmt.Errorf("GROUP BY %s: %w", sqlparser.String(expr), err)
+		}
+		name := sqlparser.String(expr)
+		if _, ok := grouped[name]; !ok {
+			grouped[name] = &sqlparser.ColName{Name: sqlparser.NewColIdent(name)}
+			computed = append(computed, &sqlparser.AliasedExpr{Expr: expr, As: sqlparser.NewColIdent(name)})
+		}
+		selectStmt.GroupBy[i] = grouped[name]
+	}
+	if len(computed) == 0 {
+		return node, schema, nil
+	}
+
+	exprs := make(sqlparser.SelectExprs, 0, len(schema.Columns)+len(computed))
+	for _, column := range schema.Columns {
+		exprs = append(exprs, &sqlparser.AliasedExpr{Expr: &sqlparser.ColName{Name: sqlparser.NewColIdent(column)}})
+	}
+	output := types.Schema{Columns: slices.Clone(schema.Columns), Types: slices.Clone(schema.Types)}
+	for _, expr := range computed {
+		exprs = append(exprs, expr)
+		output.Columns = append(output.Columns, expr.As.String())
+		output.Types = append(output.Types, p.exprType(expr.Expr, schema))
+	}
+
+	for _, expr := range selectStmt.SelectExprs {
+		if aliased, ok := expr.(*sqlparser.AliasedExpr); ok {
+			aliased.Expr = readGrouped(aliased.Expr, grouped)
+		}
+	}
+	if selectStmt.Having != nil {
+		selectStmt.Having.Expr = readGrouped(selectStmt.Having.Expr, grouped)
+	}
+	for _, order := range selectStmt.OrderBy {
+		order.Expr = readGrouped(order.Expr, grouped)
+	}
+	return &logicalProject{input: node, exprs: exprs}, output, nil
+}
+
+// readGrouped replaces the parts of expr outside aggregates that are
+// written as a GROUP BY expression with its column
+func readGrouped(expr sqlparser.Expr, grouped map[string]*sqlparser.ColName) sqlparser.Expr {
+	var found []sqlparser.Expr
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if fn, ok := node.(*sqlparser.FuncExpr); ok && !isScalarFunction(fn) {
+			return false, nil
+		}
+		if e, ok := node.(sqlparser.Expr); ok {
+			if _, ok := grouped[sqlparser.String(e)]; ok {
+				found = append(found, e)
+				return false, nil
+			}
+		}
+		return true, nil
+	}, expr)
+	for _, e := range found {
+		expr = sqlparser.ReplaceExpr(expr, e, grouped[sqlparser.String(e)])
+	}
+	return expr
+}
+
+// aggregateCalls returns the aggregates a SELECT calls in its list, HAVING
+// and ORDER BY, each once (as aggregateColumnName names them), in that
+// order
+func aggregateCalls(selectStmt *sqlparser.Select) []*sqlparser.FuncExpr {
+	var calls []*sqlparser.FuncExpr
+	seen := make(map[string]bool)
+	visit := func(node sqlparser.SQLNode) (bool, error) {
+		fn, ok := node.(*sqlparser.FuncExpr)
+		if !ok || isScalarFunction(fn) {
+			return true, nil
+		}
+		if name := aggregateColumnName(fn); !seen[name] {
+			seen[name] = true
+			calls = append(calls, fn)
+		}
+		return false, nil
+	}
+	sqlparser.Walk(visit, selectStmt.SelectExprs)
+	if selectStmt.Having != nil {
+		sqlparser.Walk(visit, selectStmt.Having.Expr)
+	}
+	sqlparser.Walk(visit, selectStmt.OrderBy)
+	return calls
+}
+
+// checkGrouped checks that every column node reads outside an aggregate
+// is a GROUP BY column, since it has one value per group only then
+func (p *planner) checkGrouped(node sqlparser.SQLNode, schema types.Schema, groupBy []int) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.FuncExpr:
+			return isScalarFunction(n), nil
+		case *sqlparser.ColName:
+			colName := strings.Trim(n.Name.String(), "`\"")
+			colIdx, err := p.resolveColumn(schema, colName)
+			if err != nil {
+				return false, err
+			}
+			if colIdx < 0 {
+				return false, fmt.Errorf("column not found in schema: %s", colName)
+			}
+			if !slices.Contains(groupBy, colIdx) {
+				return false, fmt.Errorf("column %s must appear in GROUP BY or be used in an aggregate function", colName)
+			}
+		}
+		return true, nil
+	}, node)
+}
+
+// resolveAliases replaces the columns expr reads by a SELECT list alias, as
+// in HAVING total > 100, with the expressions they name, unless an input
+// column has the name
+func (p *planner) resolveAliases(expr sqlparser.Expr, exprs sqlparser.SelectExprs, schema types.Schema) sqlparser.Expr {
+	aliases := make(map[string]sqlparser.Expr)
+	for _, item := range exprs {
+		if aliased, ok := item.(*sqlparser.AliasedExpr); ok && !aliased.As.IsEmpty() {
+			aliases[strings.Trim(aliased.As.String(), "`\"")] = aliased.Expr
+		}
+	}
+	var refs []*sqlparser.ColName
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.FuncExpr:
+			return isScalarFunction(n), nil
+		case *sqlparser.ColName:
+			colName := strings.Trim(n.Name.String(), "`\"")
+			if _, ok := aliases[colName]; ok && p.columnIndex(schema, colName) < 0 {
+				refs = append(refs, n)
+			}
+		}
+		return true, nil
+	}, expr)
+	for _, ref := range refs {
+		expr = sqlparser.ReplaceExpr(expr, ref, aliases[strings.Trim(ref.Name.String(), "`\"")])
+	}
+	return expr
+}
+
+// outputNames returns the names of the SELECT list's columns, as
+// selectItems names them
+func (p *planner) outputNames(exprs sqlparser.SelectExprs, schema types.Schema) []string {
+	names := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			names = append(names, sqlparser.String(expr))
+			continue
+		}
+		if !aliased.As.IsEmpty() {
+			names = append(names, strings.Trim(aliased.As.String(), "`\""))
+			continue
+		}
+		if col, ok := aliased.Expr.(*sqlparser.ColName); ok {
+			if colIdx := p.columnIndex(schema, strings.Trim(col.Name.String(), "`\"")); colIdx >= 0 {
+				names = append(names, schema.Columns[colIdx])
+				continue
+			}
+		}
+		names = append(names, selectedAs(aliased.Expr))
+	}
+	return names
+}
+
+// orderPosition returns the position in the SELECT list of an ORDER BY
+// key: the column it names, or the item written as it is; -1 if neither
+func (p *planner) orderPosition(expr sqlparser.Expr, exprs sqlparser.SelectExprs, names []string) (int, error) {
+	if col, ok := expr.(*sqlparser.ColName); ok {
+		colIdx, err := p.resolveColumn(types.Schema{Columns: names}, strings.Trim(col.Name.String(), "`\""))
+		if err != nil || colIdx >= 0 {
+			return colIdx, err
+		}
+	}
+	written := selectedAs(expr)
+	for i, item := range exprs {
+		if aliased, ok := item.(*sqlparser.AliasedExpr); ok && selectedAs(aliased.Expr) == written {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// selectedAs returns the name a SELECT list expression has without an
+// alias
+func selectedAs(expr sqlparser.Expr) string {
+	if fn, ok := expr.(*sqlparser.FuncExpr); ok && !isScalarFunction(fn) {
+		return aggregateColumnName(fn)
+	}
+	return sqlparser.String(expr)
+}
+
+// isAggregateOutput reports whether a SELECT list is just its aggregate's
+// output, needing no projection: the GROUP BY columns, then the
+// aggregates, in order and unaliased
+func (p *planner) isAggregateOutput(exprs sqlparser.SelectExprs, aggregate *logicalAggregate) bool {
+	if len(exprs) != len(aggregate.groupBy)+len(aggregate.aggregates) {
+		return false
+	}
+	for i, expr := range exprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok || !aliased.As.IsEmpty() {
+			return false
+		}
+		if i < len(aggregate.groupBy) {
+			col, ok := aliased.Expr.(*sqlparser.ColName)
+			if !ok || p.columnIndex(aggregate.inputSchema, strings.Trim(col.Name.String(), "`\"")) != aggregate.groupBy[i] {
+				return false
+			}
+			continue
+		}
+		fn, ok := aliased.Expr.(*sqlparser.FuncExpr)
+		if !ok || isScalarFunction(fn) || aggregateColumnName(fn) != aggregate.aggregates[i-len(aggregate.groupBy)].Alias {
+			return false
+		}
+	}
+	return true
+}
+
+// buildOrderByLimit adds a sort by the ORDER BY columns and a LIMIT, if
+// there are any
+func buildOrderByLimit(node logicalNode, orderBy sqlparser.OrderBy, limit *sqlparser.Limit) (logicalNode, error) {
+	if len(orderBy) > 0 {
+		sort := &logicalSort{input: node}
+		for _, order := range orderBy {
+			sort.keys = append(sort.keys, sortKey{expr: order.Expr, desc: order.Direction == sqlparser.DescScr})
+		}
+		node = sort
+	}
+	if limit != nil {
+		count, err := parseLimit(limit)
+		if err != nil {
+			return node, err
+		}
+		node = &logicalLimit{input: node, count: count}
+	}
+	return node, nil
+}
+
+// lower builds the operators of a logical plan. On error, the plan's
+// sources are closed.
+func (p *planner) lower(node logicalNode) (types.Operator, error) {
+	if scan, ok := node.(*logicalScan); ok {
+		return p.lowerScan(scan)
+	}
+	if union, ok := node.(*logicalUnion); ok {
+		return p.lowerUnion(union)
+	}
+	if values, ok := node.(*logicalValues); ok {
+		return values.op, nil
+	}
+
+	input, err := p.lower(*inputsOf(node)[0])
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.lowerOnto(node, input)
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+	return op, nil
+}
+
+// lowerUnion concatenates the operators of both sides
+func (p *planner) lowerUnion(union *logicalUnion) (types.Operator, error) {
+	left, err := p.lower(union.left)
+	if err != nil {
+		closeSources(union.right)
+		return nil, err
+	}
+	right, err := p.lower(union.right)
+	if err != nil {
+		left.Close()
+		return nil, err
+	}
+	op, err := operators.NewUnionOp(left, right)
+	if err != nil {
+		left.Close()
+		right.Close()
+		return nil, err
+	}
+	return op, nil
+}
+
+// lowerOnto builds the operator of a node with one input over its
+// input's operator
+func (p *planner) lowerOnto(node logicalNode, input types.Operator) (types.Operator, error) {
+	schema := input.Schema()
+	switch n := node.(type) {
+	case *logicalFilter:
+		op := input
+		for _, conjunct := range n.conjuncts {
+			predicates, err := p.buildPredicates(conjunct, schema)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build %s predicates: %w", n.clause, err)
+			}
+			for _, pred := range predicates {
+				op = operators.NewFilterOp(op, pred)
+			}
+		}
+		return op, nil
+
+	case *logicalAggregate:
+		if len(n.groupBy) == 0 {
+			return operators.NewScalarAggregateOp(input, n.aggregates), nil
+		}
+		if operators.GroupsAdjacent(input, n.groupBy) && !p.collatesText(schema, n.groupBy) {
+			// Input sorted on the GROUP BY columns: one group at a time
+			return operators.NewStreamAggregateOp(input, n.groupBy, n.aggregates), nil
+		}
+		return operators.NewHashAggregateOpWithOptions(input, n.groupBy, n.aggregates, operators.HashAggregateOptions{
+			MemoryBytes: p.opts.AggregateMemoryBytes,
+			TempQuota:   p.tempQuota,
+			Spill:       p.spillOptions(),
+			Parallel:    true,
+			Collation:   p.opts.Collation,
+		}), nil
+
+	case *logicalProject:
+		items, err := p.selectItems(n.exprs, schema)
+		if err != nil {
+			return nil, err
+		}
+		return buildProjection(input, items), nil
+
+	case *logicalDistinct:
+		return p.distinct(input), nil
+
+	case *logicalSort:
+		keys := make([]operators.SortKey, len(n.keys))
+		for i, key := range n.keys {
+			keys[i] = operators.SortKey{ColumnIndex: key.position, Desc: key.desc}
+			if key.expr == nil {
+				continue
+			}
+			colName := strings.Trim(sqlparser.String(key.expr), "`\"")
+			colIdx, err := p.resolveColumn(schema, colName)
+			if err != nil {
+				return nil, err
+			}
+			if colIdx < 0 {
+				return nil, fmt.Errorf("ORDER BY column not found: %s", colName)
+			}
+			keys[i].ColumnIndex = colIdx
+		}
+		opts := p.sortOptions()
+		opts.Collation = p.opts.Collation
+		if len(keys) == 1 {
+			return operators.NewSortOpWithOptions(input, keys[0].ColumnIndex, keys[0].Desc, opts), nil
+		}
+		return operators.NewMultiKeySortOp(input, keys, opts), nil
+
+	case *logicalGroupOrder:
+		keys := make([]operators.SortKey, n.columns)
+		for i := range keys {
+			keys[i] = operators.SortKey{ColumnIndex: i}
+		}
+		opts := p.sortOptions()
+		opts.Collation = p.opts.Collation
+		return operators.NewMultiKeySortOp(input, keys, opts), nil
+
+	case *logicalLimit:
+		return operators.NewLimitOp(input, n.count), nil
+
+	case *logicalRename:
+		if n.schema.Columns == nil {
+			return operators.NewProjectOp(input, n.columns), nil
+		}
+		exprs := make([]operators.ValueExpr, len(n.columns))
+		for i, column := range n.columns {
+			exprs[i] = operators.ColumnExpr(column)
+		}
+		return operators.NewExprProjectOp(input, exprs, n.schema), nil
+
+	default:
+		return nil, fmt.Errorf("cannot plan %T", node)
+	}
+}
+
+// lowerScan builds a source's scan with its conditions: files, partitions
+// and row groups they rule out are skipped, single-column terms go into
+// the scan when it can evaluate them, and the rest become filters
+func (p *planner) lowerScan(s *logicalScan) (types.Operator, error) {
+	op, schema := s.op, s.op.Schema()
+
+	// A .golap file reads only the columns the query references
+	if scan, ok := op.(*operators.GolapScan); ok && s.needed != nil {
+		scan.ProjectColumns(s.needed)
+	}
+	if len(s.conditions) == 0 {
+		return op, nil
+	}
+
+	where := joinConjuncts(s.conditions)
+	if !p.opts.DisablePruning {
+		// Skip the file entirely if its zone map proves nothing can match
+		if zm, ok := p.loadZoneMap(s.filePath); ok {
+			expr := buildPruningExpr(where)
+			if zm.CanPrunePredicateTree(expr) {
+				op = operators.NewEmptyOp(op)
+				p.trace("zone map rules out every row, so the file isn't read", "file", s.filePath)
+			} else if scan, ok := op.(*operators.CSVScan); ok && zm.BlocksFit(scan.FileSize()) {
+				// ...or else seek past the blocks of it that can't
+				scan.PruneBlocks(fileBlocks(zm), func(minValues, maxValues map[string]int64) bool {
+					block := metadata.ZoneMap{MinValues: minValues, MaxValues: maxValues}
+					return block.CanPrunePredicateTree(expr)
+				})
+				pruned, blocks := scan.PrunedBlocks()
+				p.trace("zone map skips blocks", "file", s.filePath, "skipped", pruned, "blocks", blocks)
+			} else {
+				p.trace("zone map rules out no rows", "file", s.filePath)
+			}
+		} else if _, ok := op.(*operators.CSVScan); ok {
+			p.trace("no zone map, so every row is read (golap zonemap makes one)", "file", s.filePath)
+		}
+
+		// Skip the partitions (key=value directories) no row of which can match
+		if scan, ok := op.(*operators.MultiFileScan); ok {
+			files := len(scan.Paths())
+			p.prunePartitions(scan, where, schema)
+			p.traceFiles(s.name, "partition values", files, len(scan.Paths()))
+			// ...and the files a table's zone index or the files' zone
+			// maps rule out
+			files = len(scan.Paths())
+			p.pruneWithZoneIndex(scan, s.name, where)
+			p.traceFiles(s.name, "zone index", files, len(scan.Paths()))
+			files = len(scan.Paths())
+			p.pruneWithZoneMaps(scan, where)
+			p.traceFiles(s.name, "zone maps", files, len(scan.Paths()))
+		}
+
+		// Skip the row groups of a .golap file whose min/max rule it out
+		if scan, ok := op.(*operators.GolapScan); ok {
+			expr := buildPruningExpr(where)
+			scan.PruneRowGroups(func(minValues, maxValues map[string]int64) bool {
+				zm := metadata.ZoneMap{MinValues: minValues, MaxValues: maxValues}
+				return zm.CanPrunePredicateTree(expr)
+			})
+			pruned, groups := scan.PrunedRowGroups()
+			p.trace("row group min/max skip row groups", "file", s.filePath, "skipped", pruned, "row_groups", groups)
+		}
+	}
+
+	// One filter per AND term, with selectivities from ANALYZE when
+	// present, else from the distinct counts of a zone map
+	var stats *metadata.TableStats
+	if s.filePath != "" {
+		stats, _ = metadata.LoadStats(s.filePath) // Stats are optional
+		if zm, ok := p.loadZoneMap(s.filePath); stats == nil && ok {
+			stats = zm.Stats()
+		}
+	}
+	selectivities := p.estimateSelectivities(s.conditions, stats, schema)
+
+	// A scan that can apply single-column conjuncts itself rejects rows
+	// before converting their other columns
+	pusher, canPush := op.(operators.PredicatePusher)
+	for i, conjunct := range s.conditions {
+		predicates, err := p.buildPredicates(conjunct, schema)
+		if err != nil {
+			op.Close()
+			return nil, fmt.Errorf("failed to build WHERE predicates: %w", err)
+		}
+		if column, ok := p.singleColumn(conjunct, schema); canPush && ok {
+			description := sqlparser.String(conjunct)
+			if _, isOr := conjunct.(*sqlparser.OrExpr); isOr {
+				description = "(" + description + ")"
+			}
+			pusher.PushPredicates([]operators.ScanPredicate{{
+				Column:      column,
+				Predicate:   operators.AndPredicate(predicates...),
+				Description: description,
+				Selectivity: selectivities[i],
+			}})
+			continue
+		}
+		columns, known := p.columnsRead(conjunct, schema)
+		for _, pred := range predicates {
+			filter := operators.NewFilterOpWithSelectivity(op, pred, selectivities[i])
+			if known {
+				filter.SetColumns(columns)
+			}
+			op = filter
+		}
+	}
+	return op, nil
+}
+
+// traceFiles reports the files of a multi-file scan one way of pruning
+// ruled out, if any
+func (p *planner) traceFiles(name, by string, before, after int) {
+	if after < before {
+		p.trace("files pruned", "table", name, "by", by, "skipped", before-after, "files", before)
+	}
+}
+
+// fileBlocks returns a zone map's blocks for CSVScan.PruneBlocks
+func fileBlocks(zm *metadata.ZoneMap) []operators.FileBlock {
+	blocks := make([]operators.FileBlock, len(zm.Blocks))
+	for i, block := range zm.Blocks {
+		blocks[i] = operators.FileBlock{
+			Offset:    block.Offset,
+			Line:      block.Line,
+			MinValues: block.MinValues,
+			MaxValues: block.MaxValues,
+		}
+	}
+	return blocks
+}
+
+// joinConjuncts ANDs terms back into one expression
+func joinConjuncts(conjuncts []sqlparser.Expr) sqlparser.Expr {
+	expr := conjuncts[0]
+	for _, conjunct := range conjuncts[1:] {
+		expr = &sqlparser.AndExpr{Left: expr, Right: conjunct}
+	}
+	return expr
+}
@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+
+	"github.com/aryamaansaha/golap/catalog"
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Options controls how queries are planned
+type Options struct {
+	// SortMemoryBytes is how many bytes of rows ORDER BY (and merge-on-read
+	// tables) hold in memory per sorted run before spilling, measured as
+	// the rows' encoded size (0 uses operators.DefaultSortMemoryBytes)
+	SortMemoryBytes int64
+
+	// SortChunkSize, if set, counts sorted runs in rows instead and takes
+	// precedence over SortMemoryBytes
+	//
+	// Deprecated: use SortMemoryBytes.
+	SortChunkSize int
+
+	// ColumnNames is how column references match column names: exactly
+	// (the default), ignoring case, or normalized, which also ignores
+	// surrounding whitespace and treats runs of spaces, _ and - alike
+	// (order_id -> " Order ID"). An exact match always takes precedence;
+	// a reference matching several columns otherwise fails as ambiguous.
+	ColumnNames types.ColumnNameMode
+
+	// Collation is how text compares in WHERE, ORDER BY, GROUP BY,
+	// DISTINCT, UNION, MIN and MAX: byte by byte (the zero value), ignoring
+	// case, or by a language's rules (see operators.ParseCollation)
+	Collation operators.Collation
+
+	// RelaxedColumnNames matches column names normalized when ColumnNames
+	// is exact
+	//
+	// Deprecated: use ColumnNames.
+	RelaxedColumnNames bool
+
+	// ReadBufferSize is the read buffer size in bytes for each CSV scan
+	// (0 uses operators.DefaultReadBufferSize)
+	ReadBufferSize int
+
+	// ScanWorkers is how many goroutines parse a large local CSV file in
+	// parallel, each taking a segment of operators.ParallelSegmentBytes;
+	// 0 or 1 parses on the query's goroutine. Rows come out in file order
+	// either way.
+	ScanWorkers int
+
+	// MmapFiles reads local CSV, JSON Lines and Arrow files through a
+	// read-only memory mapping instead of read calls; parallel CSV
+	// workers then parse slices of the mapping without copying them.
+	// Files that can't be mapped are read as usual.
+	MmapFiles bool
+
+	// TempSpaceQuota caps the bytes of temp files (sort, DISTINCT and
+	// GROUP BY spill) a single query may write; 0 means unlimited
+	TempSpaceQuota int64
+
+	// TempDir is the directory sorts, DISTINCT and GROUP BY spill to; "" uses
+	// $GOLAP_TEMP_DIR, else the system temp directory
+	TempDir string
+
+	// SpillCompression compresses spill files, for small or slow temp
+	// disks; the quota counts the compressed bytes
+	SpillCompression operators.SpillCompression
+
+	// Delimiter is the CSV field separator for files and tables that don't
+	// set their own; 0 detects it from each file's header line
+	Delimiter rune
+
+	// NoHeader treats the first line of every CSV file as data; columns
+	// are named col0..colN unless a table or read_csv names them
+	NoHeader bool
+
+	// ColumnTypes declares column types by name for every file scanned,
+	// overriding inference, .schema.json sidecars and catalog tables.
+	// Columns a file doesn't have are ignored.
+	ColumnTypes map[string]types.DataType
+
+	// SampleRows is how many rows each scan reads to infer column types
+	// (0 uses the scan's default)
+	SampleRows int
+
+	// NullValues are CSV field values read as NULL in any column, e.g. NA
+	// or \N; list "" to read empty text fields as NULL too (empty numeric
+	// fields always are)
+	NullValues []string
+
+	// StrictParse fails a query on a CSV field that doesn't parse as its
+	// column's type, naming the line and column, instead of reading 0
+	StrictParse bool
+
+	// RaggedRows is what CSV scans do with a record whose number of fields
+	// differs from the header's: fail the query (the default), skip it or
+	// pad it with NULLs; read_csv's ragged_rows option overrides it. The
+	// rows skipped or padded are counted in OperatorStats.RaggedRows.
+	RaggedRows operators.RaggedRowPolicy
+
+	// Encoding is the text encoding of CSV files and tables that don't set
+	// their own: utf-8 (the default), latin1 or windows-1252
+	Encoding string
+
+	// DistinctMemoryRows is how many distinct rows DISTINCT and UNION keep
+	// in memory before spilling to temp files
+	DistinctMemoryRows int
+
+	// AggregateMemoryBytes is how much memory, by its estimate, GROUP BY
+	// lets its groups take before spilling the rows of new groups to temp
+	// files (0 uses operators.DefaultAggregateMemoryBytes)
+	AggregateMemoryBytes int64
+
+	// SortGroups returns GROUP BY results sorted by the GROUP BY columns
+	// (before any ORDER BY, which then breaks its ties by them) instead of
+	// in the order their groups were first seen, spilled groups last
+	SortGroups bool
+
+	// ApproxDistinct makes DISTINCT and UNION use a fixed-size Bloom filter
+	// instead: bounded memory and no spill, but a few distinct rows may be
+	// dropped as false duplicates
+	ApproxDistinct bool
+
+	// DisablePruning reads every file and partition a query names, ignoring
+	// zone maps, zone indexes, partition values and rollups; results are
+	// the same, only slower. VerifyPruning uses it as the reference plan.
+	DisablePruning bool
+
+	// StaleZoneMaps is what to do with a zone map made before its file
+	// last changed: warn and not use it (the default), not use it, or
+	// regenerate it
+	StaleZoneMaps StaleZoneMapPolicy
+
+	// Logger, if set, receives structured records of what planning and
+	// execution decide: at Warn, problems planning works around rather
+	// than fails on, such as a stale zone map; at Debug, the files,
+	// partitions, blocks and row groups zone maps and partition values
+	// rule out (or the zone map a filtered file lacks), and each temp
+	// file a sort, DISTINCT or GROUP BY spills to. nil discards them.
+	Logger *slog.Logger
+
+	// Authorize, if set, is asked before the query reads each FROM source:
+	// name is the name as written (view, table or path) and path the file,
+	// glob or directory it resolves to ("" for a view). Views are checked
+	// both by name and for every source their definition reads. A non-nil
+	// error stops planning and is returned as is.
+	Authorize func(name, path string) error
+
+	// AllowedRoots, if set, are the only directories queries may read
+	// files from (FROM paths, globs, directories and catalog tables) or
+	// write them to (COPY ... TO, CREATE TABLE ... AS), checked at plan
+	// time after symlinks are followed; relative roots resolve against the
+	// working directory when the query is planned. URL prefixes such as
+	// s3://bucket/tenant/ allow the objects under them.
+	AllowedRoots []string
+
+	// AllowedSchemes, if set, are the URL schemes (s3, https, ...) and
+	// database connectors (postgres, mysql) queries may read from
+	// anywhere. Setting either this or AllowedRoots sandboxes queries: a
+	// path neither allows fails with ErrPathNotAllowed.
+	AllowedSchemes []string
+
+	// Tables are registered for the query on top of the catalog file's,
+	// replacing its tables of the same name, without saving them. Relative
+	// paths resolve against the working directory.
+	Tables []catalog.Table
+
+	// Context, if set, stops the query once canceled: scans and passes
+	// over temp files return context.Cause, so the caller's Close removes
+	// the temp files. Nil never cancels.
+	Context context.Context
+}
+
+// DefaultOptions returns the options used by ParseAndPlan
+func DefaultOptions() Options {
+	return Options{
+		SortMemoryBytes:      operators.DefaultSortMemoryBytes,
+		ReadBufferSize:       operators.DefaultReadBufferSize,
+		DistinctMemoryRows:   operators.DefaultDistinctMemoryRows,
+		AggregateMemoryBytes: operators.DefaultAggregateMemoryBytes,
+		ScanWorkers:          runtime.GOMAXPROCS(0),
+	}
+}
+
+// planner carries options and per-query state through the recursive
+// planning functions
+type planner struct {
+	opts       Options
+	tempQuota  *operators.TempSpaceQuota // Shared by all spilling operators
+	tableFuncs map[string]tableFunction  // read_csv(...) calls by placeholder name
+
+	// Paging (PlanPage): the scan starts at pageOffset, and openSource
+	// records the scan and its file so the next page's token can be made
+	paging     bool
+	pageOffset int64
+	pageScan   *operators.CSVScan
+	pagePath   string
+
+	zoneMaps  map[string]*metadata.ZoneMap         // loadZoneMap's, by file; nil = none or stale
+	manifests map[string]*metadata.ZoneMapManifest // loadManifest's, by directory
+
+	sources []string // Paths openSource resolved FROM names to, for Sources
+
+	exprErrors *operators.ExprErrors // Errors of UDF calls that can fail; nil if the query makes none
+}
+
+// loadCatalog loads the catalog file with Options.Tables registered on it
+func (p *planner) loadCatalog() (*catalog.Catalog, error) {
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range p.opts.Tables {
+		if !filepath.IsAbs(table.Path) && !storage.IsRemote(table.Path) {
+			if table.Path, err = filepath.Abs(table.Path); err != nil {
+				return nil, err
+			}
+		}
+		if err := cat.RegisterTable(table, true); err != nil {
+			return nil, err
+		}
+	}
+	return cat, nil
+}
+
+// columnIndex resolves a column name against a schema under the
+// ColumnNames mode. Returns -1 if not found or ambiguous.
+func (p *planner) columnIndex(schema types.Schema, name string) int {
+	idx, err := p.resolveColumn(schema, name)
+	if err != nil {
+		return -1
+	}
+	return idx
+}
+
+// resolveColumn resolves a column name against a schema under the
+// ColumnNames mode: -1 if not found, an error if ambiguous
+func (p *planner) resolveColumn(schema types.Schema, name string) (int, error) {
+	mode := p.opts.ColumnNames
+	if p.opts.RelaxedColumnNames && mode == types.ExactColumnNames {
+		mode = types.NormalizedColumnNames
+	}
+	return schema.ResolveColumn(name, mode)
+}
+
+// collatesText reports whether any of the columns is text compared by a
+// collation other than binary, whose equal values may differ in bytes
+func (p *planner) collatesText(schema types.Schema, columns []int) bool {
+	if p.opts.Collation.IsBinary() {
+		return false
+	}
+	for _, col := range columns {
+		if col >= 0 && col < len(schema.Types) && schema.Types[col] == types.String {
+			return true
+		}
+	}
+	return false
+}
+
+// sortOptions returns the memory and spill settings for a sort
+func (p *planner) sortOptions() operators.SortOptions {
+	opts := operators.SortOptions{MemoryBytes: p.opts.SortMemoryBytes, TempQuota: p.tempQuota, Spill: p.spillOptions()}
+	if p.opts.SortChunkSize > 0 {
+		opts.MemoryBytes, opts.ChunkSize = 0, p.opts.SortChunkSize
+	}
+	return opts
+}
+
+// spillOptions returns where and how spilling operators write temp files
+func (p *planner) spillOptions() operators.SpillOptions {
+	return operators.SpillOptions{Dir: p.opts.TempDir, Compression: p.opts.SpillCompression, Context: p.opts.Context, Logger: p.opts.Logger}
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// pageToken is the decoded form of a continuation token: where the next
+// page starts, plus enough about the file and query to reject a token
+// that no longer applies
+type pageToken struct {
+	Offset  int64  `json:"offset"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	Query   uint64 `json:"query"` // Hash of the query text
+}
+
+// Page is one page of rows from a query that only filters and projects a
+// single CSV file. It is an operator; once its rows are read, NextToken
+// says where the following page starts.
+type Page struct {
+	op       types.Operator
+	scan     *operators.CSVScan
+	token    pageToken // Fingerprint for the next token; Offset is filled in later
+	pageSize int
+	rows     int
+}
+
+// PlanPage plans up to pageSize rows of a plain filter/project query over a
+// CSV file or catalog table, starting where token says ("" = the start).
+// Each page reads on from the byte offset the previous one stopped at, so
+// paging through a huge file never re-scans the rows already returned.
+func PlanPage(sql string, opts Options, token string, pageSize int) (*Page, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	queryHash, _ := operators.HashValues(sql)
+
+	var start pageToken
+	if token != "" {
+		var err error
+		if start, err = decodePageToken(token); err != nil {
+			return nil, err
+		}
+		if start.Query != queryHash {
+			return nil, fmt.Errorf("page token belongs to a different query")
+		}
+	}
+
+	p := &planner{
+		opts:       opts,
+		tempQuota:  operators.NewTempSpaceQuota(opts.TempSpaceQuota),
+		paging:     true,
+		pageOffset: start.Offset,
+	}
+	rewritten, err := p.rewriteTableFunctions(sql)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := sqlparser.Parse(rewritten)
+	if err != nil {
+		return nil, fmt.Errorf("SQL parse error: %w", err)
+	}
+	selectStmt, ok := stmt.(*sqlparser.Select)
+	if !ok || !isPlainScan(selectStmt) {
+		return nil, fmt.Errorf("paging supports only SELECT ... FROM one file [WHERE ...], without aggregates, DISTINCT, ORDER BY or LIMIT")
+	}
+
+	op, err := p.planSelectStatement(selectStmt, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := storage.Stat(p.pagePath)
+	if err != nil {
+		op.Close()
+		return nil, err
+	}
+	current := pageToken{
+		Path:    p.pagePath,
+		Size:    info.Size,
+		ModTime: info.ModTime.UnixNano(),
+		Query:   queryHash,
+	}
+	if token != "" && (start.Path != current.Path || start.Size != current.Size || start.ModTime != current.ModTime) {
+		op.Close()
+		return nil, fmt.Errorf("%s changed since the page token was issued; start again without a token", p.pagePath)
+	}
+
+	return &Page{
+		op:       operators.NewLimitOp(op, pageSize),
+		scan:     p.pageScan,
+		token:    current,
+		pageSize: pageSize,
+	}, nil
+}
+
+// isPlainScan reports whether a SELECT only filters and projects, so the
+// scan's byte offset alone says where its output left off
+func isPlainScan(stmt *sqlparser.Select) bool {
+	if stmt.Distinct != "" || len(stmt.GroupBy) > 0 || stmt.Having != nil ||
+		len(stmt.OrderBy) > 0 || stmt.Limit != nil {
+		return false
+	}
+	plain := true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if fn, ok := node.(*sqlparser.FuncExpr); ok && !isScalarFunction(fn) {
+			plain = false
+		}
+		return plain, nil
+	}, stmt.SelectExprs)
+	return plain
+}
+
+// Next returns the next row of the page
+func (pg *Page) Next() (*types.Row, error) {
+	row, err := pg.op.Next()
+	if row != nil {
+		pg.rows++
+	}
+	return row, err
+}
+
+// Close releases the page's resources
+func (pg *Page) Close() error {
+	return pg.op.Close()
+}
+
+// Schema returns the schema of the page's rows
+func (pg *Page) Schema() types.Schema {
+	return pg.op.Schema()
+}
+
+// Explain describes the page's plan
+func (pg *Page) Explain() operators.PlanNode {
+	return operators.ExplainOperator(pg.op)
+}
+
+// NextToken returns the continuation token for the following page, or ""
+// if this page reached the end of the file. Call it after reading the
+// page's rows.
+func (pg *Page) NextToken() (string, error) {
+	offset := pg.scan.Offset()
+	if pg.rows < pg.pageSize || offset >= pg.token.Size {
+		return "", nil
+	}
+	next := pg.token
+	next.Offset = offset
+	data, err := json.Marshal(next)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken parses a token made by NextToken
+func decodePageToken(token string) (pageToken, error) {
+	var decoded pageToken
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil {
+		return pageToken{}, fmt.Errorf("invalid page token")
+	}
+	return decoded, nil
+}
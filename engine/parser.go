@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/aryamaansaha/golap/metadata"
 	"github.com/aryamaansaha/golap/operators"
 	"github.com/aryamaansaha/golap/types"
 	"github.com/xwb1989/sqlparser"
@@ -12,107 +13,115 @@ import (
 
 // ParseAndPlan parses a SQL query and builds an operator tree
 // Query Format: SELECT ... FROM "file.csv" WHERE ... ORDER BY ... LIMIT ...
+// Also accepts COPY (SELECT ...) TO 'out.csv' and CREATE TABLE out.csv AS SELECT ...
 // sortChunkSize controls memory usage for ORDER BY (number of rows per chunk)
 func ParseAndPlan(sql string, sortChunkSize int) (types.Operator, error) {
-	stmt, err := sqlparser.Parse(sql)
-	if err != nil {
-		return nil, fmt.Errorf("SQL parse error: %w", err)
-	}
+	opts := DefaultOptions()
+	opts.SortChunkSize = sortChunkSize
+	return ParseAndPlanWithOptions(sql, opts)
+}
 
-	selectStmt, ok := stmt.(*sqlparser.Select)
-	if !ok {
-		return nil, fmt.Errorf("only SELECT statements are supported")
+// ParseAndPlanWithOptions parses a SQL query and builds an operator tree
+// using the given planning options
+func ParseAndPlanWithOptions(sql string, opts Options) (types.Operator, error) {
+	p := &planner{
+		opts:      opts,
+		tempQuota: operators.NewTempSpaceQuota(opts.TempSpaceQuota),
 	}
+	return p.planQuery(sql, 0)
+}
 
-	// Extract table name (file path)
-	if len(selectStmt.From) != 1 {
-		return nil, fmt.Errorf("exactly one table (CSV file) required in FROM clause")
+// planQuery plans a statement; viewDepth counts how many views are being
+// expanded around it, to catch recursive view definitions
+func (p *planner) planQuery(sql string, viewDepth int) (types.Operator, error) {
+	// EXPLAIN <query>
+	if query, ok := parseExplainStatement(sql); ok {
+		return p.explain(query, viewDepth)
 	}
 
-	tableName, err := extractTableName(selectStmt.From[0])
-	if err != nil {
-		return nil, err
+	// SHOW TABLES / SHOW SCHEMAS
+	if showTablesPattern.MatchString(sql) {
+		return p.showTables()
+	}
+	if showSchemasPattern.MatchString(sql) {
+		return p.showSchemas()
 	}
 
-	// Build operator chain from inside out:
-	// Scan -> Filter -> Aggregate -> Sort -> Limit -> Project
-
-	// 1. Start with CSV Scan
-	scan, err := operators.NewCSVScan(tableName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create CSV scan: %w", err)
+	// DESCRIBE / SHOW COLUMNS
+	if name, ok := parseDescribeStatement(sql); ok {
+		return p.describe(name)
 	}
 
-	var op types.Operator = scan
-	schema := scan.Schema()
+	// ANALYZE name [(a, b) ...]
+	if analyzeStmt, ok := parseAnalyzeStatement(sql); ok {
+		return p.analyze(analyzeStmt)
+	}
 
-	// 2. Apply WHERE filters
-	if selectStmt.Where != nil {
-		predicates, err := buildPredicates(selectStmt.Where.Expr, schema)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build WHERE predicates: %w", err)
-		}
-		for _, pred := range predicates {
-			op = operators.NewFilterOp(op, pred)
-		}
+	// CREATE VIEW / DROP VIEW
+	if viewStmt, ok := parseViewStatement(sql); ok {
+		return p.executeViewStatement(viewStmt)
 	}
 
-	// 3. Check for aggregates and GROUP BY
-	aggregates, selectColumns, hasAggregates := parseSelectExprs(selectStmt.SelectExprs, schema)
+	// COPY (SELECT ...) TO 'file' / CREATE TABLE file AS SELECT ...
+	if writeStmt, ok := parseWriteStatement(sql); ok {
+		return p.planWriteStatement(writeStmt, viewDepth)
+	}
 
-	if hasAggregates {
-		// Build aggregate operator
-		if len(selectStmt.GroupBy) > 0 {
-			// Hash aggregate with GROUP BY
-			groupByIndices := make([]int, len(selectStmt.GroupBy))
-			for i, expr := range selectStmt.GroupBy {
-				colName := sqlparser.String(expr)
-				colName = strings.Trim(colName, "`\"")
-				groupByIndices[i] = schema.ColumnIndex(colName)
-			}
-			op = operators.NewHashAggregateOp(op, groupByIndices, aggregates)
-		} else {
-			// Scalar aggregate (no GROUP BY)
-			op = operators.NewScalarAggregateOp(op, aggregates)
-		}
-		// Update schema after aggregation
-		schema = op.Schema()
+	// read_csv('file', delim=>'|') isn't valid to sqlparser either
+	sql, err := p.rewriteTableFunctions(sql)
+	if err != nil {
+		return nil, err
 	}
 
-	// 4. Apply ORDER BY
-	if len(selectStmt.OrderBy) > 0 {
-		// MVP: single column ORDER BY only
-		orderExpr := selectStmt.OrderBy[0]
-		colName := sqlparser.String(orderExpr.Expr)
-		colName = strings.Trim(colName, "`\"")
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("SQL parse error: %w", err)
+	}
 
-		// Find column index in current schema
-		colIdx := schema.ColumnIndex(colName)
-		if colIdx < 0 {
-			return nil, fmt.Errorf("ORDER BY column not found: %s", colName)
-		}
+	return p.planSelectStatement(stmt, viewDepth)
+}
 
-		desc := orderExpr.Direction == sqlparser.DescScr
-		op = operators.NewSortOpWithChunkSize(op, colIdx, desc, sortChunkSize)
+// ReadOnly reports whether a statement only reads: a SELECT or UNION, or
+// SHOW, DESCRIBE, or EXPLAIN of one. Other statements write files or the
+// catalog (COPY, CREATE, DROP, ANALYZE). Sidecars like zone maps may still
+// be refreshed under the stale zone map policy.
+func ReadOnly(sql string) bool {
+	if query, ok := parseExplainStatement(sql); ok {
+		_, query, err := parseExplainOptions(query)
+		return err == nil && ReadOnly(query)
 	}
-
-	// 5. Apply LIMIT
-	if selectStmt.Limit != nil {
-		limitVal, err := parseLimit(selectStmt.Limit)
-		if err != nil {
-			return nil, err
-		}
-		op = operators.NewLimitOp(op, limitVal)
+	if _, ok := parseDescribeStatement(sql); ok {
+		return true
 	}
+	return showTablesPattern.MatchString(sql) || showSchemasPattern.MatchString(sql) || selectPattern.MatchString(sql)
+}
 
-	// 6. Apply projection (SELECT columns) - last step
-	if !hasAggregates && len(selectColumns) > 0 {
-		// Only project if we have specific columns (not SELECT *)
-		// After aggregation, the schema is already correct
-		op = operators.NewProjectOp(op, selectColumns)
+// planSelectStatement plans a parsed SELECT, a UNION of them, or either in
+// parentheses: builds its logical plan, optimizes it, and lowers it to
+// operators (see logical.go). If it calls a UDF that can fail, the root
+// checks for the UDF's error.
+func (p *planner) planSelectStatement(stmt sqlparser.Statement, viewDepth int) (types.Operator, error) {
+	node, err := p.buildStatement(stmt, viewDepth)
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.lower(p.optimize(node))
+	if err != nil || p.exprErrors == nil {
+		return op, err
 	}
+	return operators.NewExprErrorCheck(op, p.exprErrors), nil
+}
 
-	return op, nil
+// distinct removes duplicate rows, exactly (spilling past the memory
+// limit) or approximately, as the options ask
+func (p *planner) distinct(op types.Operator) types.Operator {
+	return operators.NewDistinctOpWithOptions(op, operators.DistinctOptions{
+		MemoryRows:  p.opts.DistinctMemoryRows,
+		TempQuota:   p.tempQuota,
+		Spill:       p.spillOptions(),
+		Approximate: p.opts.ApproxDistinct,
+		Collation:   p.opts.Collation,
+	})
 }
 
 // extractTableName gets the file path from the FROM clause
@@ -135,40 +144,102 @@ func extractTableName(tableExpr sqlparser.TableExpr) (string, error) {
 
 // buildPredicates converts WHERE expression to filter predicates
 // Returns multiple predicates for implicit AND chaining
-func buildPredicates(expr sqlparser.Expr, schema types.Schema) ([]operators.Predicate, error) {
+func (p *planner) buildPredicates(expr sqlparser.Expr, schema types.Schema) ([]operators.Predicate, error) {
 	switch e := expr.(type) {
 	case *sqlparser.AndExpr:
 		// Recursively handle AND
-		left, err := buildPredicates(e.Left, schema)
+		left, err := p.buildPredicates(e.Left, schema)
 		if err != nil {
 			return nil, err
 		}
-		right, err := buildPredicates(e.Right, schema)
+		right, err := p.buildPredicates(e.Right, schema)
 		if err != nil {
 			return nil, err
 		}
 		return append(left, right...), nil
 
+	case *sqlparser.OrExpr:
+		// Each side may itself be an AND chain; collapse before combining
+		left, err := p.buildPredicates(e.Left, schema)
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.buildPredicates(e.Right, schema)
+		if err != nil {
+			return nil, err
+		}
+		pred := operators.OrPredicate(operators.AndPredicate(left...), operators.AndPredicate(right...))
+		return []operators.Predicate{pred}, nil
+
+	case *sqlparser.NotExpr:
+		inner, err := p.buildPredicates(e.Expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		pred := operators.NotPredicate(operators.AndPredicate(inner...))
+		return []operators.Predicate{pred}, nil
+
+	case *sqlparser.IsExpr:
+		return p.buildNullCheckPredicate(e, schema)
+
 	case *sqlparser.ComparisonExpr:
-		return buildComparisonPredicate(e, schema)
+		return p.buildComparisonPredicate(e, schema)
 
 	case *sqlparser.ParenExpr:
-		return buildPredicates(e.Expr, schema)
+		return p.buildPredicates(e.Expr, schema)
 
 	default:
 		return nil, fmt.Errorf("unsupported WHERE expression type: %T", expr)
 	}
 }
 
+// buildNullCheckPredicate builds an IS NULL / IS NOT NULL predicate
+func (p *planner) buildNullCheckPredicate(expr *sqlparser.IsExpr, schema types.Schema) ([]operators.Predicate, error) {
+	var isNull bool
+	switch expr.Operator {
+	case sqlparser.IsNullStr:
+		isNull = true
+	case sqlparser.IsNotNullStr:
+		isNull = false
+	default:
+		return nil, fmt.Errorf("unsupported IS operator: %s", expr.Operator)
+	}
+
+	colName, err := extractColumnName(expr.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	colIdx, err := p.resolveColumn(schema, colName)
+	if err != nil {
+		return nil, err
+	}
+	if colIdx < 0 {
+		return nil, fmt.Errorf("column not found in schema: %s", colName)
+	}
+
+	pred := operators.BuildNullCheckPredicate(colIdx, isNull)
+	return []operators.Predicate{pred}, nil
+}
+
 // buildComparisonPredicate builds a single comparison predicate
-func buildComparisonPredicate(expr *sqlparser.ComparisonExpr, schema types.Schema) ([]operators.Predicate, error) {
+func (p *planner) buildComparisonPredicate(expr *sqlparser.ComparisonExpr, schema types.Schema) ([]operators.Predicate, error) {
+	// Anything but column-vs-literal (e.g. BUCKET(id, 10) = 3) is evaluated
+	// as two expressions per row
+	if !isColumnReference(expr.Left) || !isLiteral(expr.Right) {
+		return p.buildExprComparison(expr, schema)
+	}
+
 	// Get column name from left side
 	colName, err := extractColumnName(expr.Left)
 	if err != nil {
 		return nil, err
 	}
 
-	colIdx := schema.ColumnIndex(colName)
+	colIdx, err := p.resolveColumn(schema, colName)
+	if err != nil {
+		return nil, err
+	}
 	if colIdx < 0 {
 		return nil, fmt.Errorf("column not found in schema: %s", colName)
 	}
@@ -180,40 +251,188 @@ func buildComparisonPredicate(expr *sqlparser.ComparisonExpr, schema types.Schem
 	}
 
 	// Map operator
-	var comp types.Comparator
-	switch expr.Operator {
-	case "=":
-		comp = types.Eq
-	case "<":
-		comp = types.Lt
-	case ">":
-		comp = types.Gt
-	case "<=":
-		comp = types.Lte
-	case ">=":
-		comp = types.Gte
-	case "!=", "<>":
-		comp = types.Neq
-	default:
-		return nil, fmt.Errorf("unsupported comparison operator: %s", expr.Operator)
+	comp, err := mapComparator(expr.Operator)
+	if err != nil {
+		return nil, err
+	}
+	if colIdx < len(schema.Types) && value != nil {
+		if err := checkComparable(schema.Types[colIdx], valueType(value), expr); err != nil {
+			return nil, err
+		}
 	}
 
 	comparison := operators.Comparison{
 		ColumnIndex: colIdx,
 		Comparator:  comp,
 		Value:       value,
+		Collation:   p.opts.Collation,
 	}
 
 	pred := operators.BuildComparisonPredicate(comparison)
+	if colIdx < len(schema.Types) {
+		// Specialized for the column's type, comparator and literal
+		pred = operators.CompileComparisonPredicate(comparison, schema.Types[colIdx])
+	}
 	return []operators.Predicate{pred}, nil
 }
 
+// buildExprComparison compares two scalar expressions
+func (p *planner) buildExprComparison(expr *sqlparser.ComparisonExpr, schema types.Schema) ([]operators.Predicate, error) {
+	comp, err := mapComparator(expr.Operator)
+	if err != nil {
+		return nil, err
+	}
+	left, err := p.buildValueExpr(expr.Left, schema)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.buildValueExpr(expr.Right, schema)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkComparableExprs(expr.Left, expr.Right, schema, expr); err != nil {
+		return nil, err
+	}
+	pred := operators.BuildCollatedExprComparisonPredicate(left, comp, right, p.opts.Collation)
+	return []operators.Predicate{pred}, nil
+}
+
+// checkComparableExprs is checkComparable for two expressions' types;
+// NULL compares with anything
+func (p *planner) checkComparableExprs(left, right sqlparser.Expr, schema types.Schema, expr sqlparser.Expr) error {
+	if isNullLiteral(left) || isNullLiteral(right) {
+		return nil
+	}
+	return checkComparable(p.exprType(left, schema), p.exprType(right, schema), expr)
+}
+
+// checkComparable fails a comparison of a number with text, which golap
+// doesn't guess an order for: Int and Float compare as numbers, and String
+// only with String
+func checkComparable(left, right types.DataType, expr sqlparser.Expr) error {
+	if (left == types.String) != (right == types.String) {
+		return fmt.Errorf("cannot compare %s with %s: %s", left, right, sqlparser.String(expr))
+	}
+	return nil
+}
+
+// valueType returns the type of a literal's value
+func valueType(value interface{}) types.DataType {
+	switch value.(type) {
+	case int64:
+		return types.Int
+	case float64:
+		return types.Float
+	default:
+		return types.String
+	}
+}
+
+// isColumnReference reports whether expr names a column (or, in HAVING, an
+// aggregate's output column)
+func isColumnReference(expr sqlparser.Expr) bool {
+	switch e := expr.(type) {
+	case *sqlparser.ColName:
+		return true
+	case *sqlparser.FuncExpr:
+		return !isScalarFunction(e)
+	default:
+		return false
+	}
+}
+
+// isNullLiteral reports whether expr is NULL, parenthesized or not
+func isNullLiteral(expr sqlparser.Expr) bool {
+	for {
+		switch e := expr.(type) {
+		case *sqlparser.NullVal:
+			return true
+		case *sqlparser.ParenExpr:
+			expr = e.Expr
+		default:
+			return false
+		}
+	}
+}
+
+// isLiteral reports whether expr is a constant value
+func isLiteral(expr sqlparser.Expr) bool {
+	switch expr.(type) {
+	case *sqlparser.SQLVal, *sqlparser.NullVal:
+		return true
+	default:
+		return false
+	}
+}
+
+// buildPruningExpr converts a WHERE expression to a zone map predicate tree
+// Anything that isn't a simple column-vs-literal comparison becomes unknown
+func buildPruningExpr(expr sqlparser.Expr) metadata.PredicateExpr {
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		return metadata.AndPredicate{Left: buildPruningExpr(e.Left), Right: buildPruningExpr(e.Right)}
+
+	case *sqlparser.OrExpr:
+		return metadata.OrPredicate{Left: buildPruningExpr(e.Left), Right: buildPruningExpr(e.Right)}
+
+	case *sqlparser.NotExpr:
+		return metadata.NotPredicate{Expr: buildPruningExpr(e.Expr)}
+
+	case *sqlparser.ParenExpr:
+		return buildPruningExpr(e.Expr)
+
+	case *sqlparser.ComparisonExpr:
+		colName, err := extractColumnName(e.Left)
+		if err != nil {
+			return metadata.UnknownPredicate{}
+		}
+		value, err := extractValue(e.Right)
+		if err != nil {
+			return metadata.UnknownPredicate{}
+		}
+		comp, err := mapComparator(e.Operator)
+		if err != nil {
+			return metadata.UnknownPredicate{}
+		}
+		return metadata.ComparisonPredicate{Column: colName, Comparator: comp, Value: value}
+
+	default:
+		return metadata.UnknownPredicate{}
+	}
+}
+
+// mapComparator converts a SQL comparison operator to a Comparator
+func mapComparator(operator string) (types.Comparator, error) {
+	switch operator {
+	case "=":
+		return types.Eq, nil
+	case "<":
+		return types.Lt, nil
+	case ">":
+		return types.Gt, nil
+	case "<=":
+		return types.Lte, nil
+	case ">=":
+		return types.Gte, nil
+	case "!=", "<>":
+		return types.Neq, nil
+	default:
+		return 0, fmt.Errorf("unsupported comparison operator: %s", operator)
+	}
+}
+
 // extractColumnName gets column name from an expression
 func extractColumnName(expr sqlparser.Expr) (string, error) {
 	switch e := expr.(type) {
 	case *sqlparser.ColName:
 		name := e.Name.String()
 		return strings.Trim(name, "`\""), nil
+	case *sqlparser.FuncExpr:
+		if isScalarFunction(e) {
+			return "", fmt.Errorf("expected column name, got function: %s", sqlparser.String(e))
+		}
+		// Aggregates in HAVING refer to the aggregate's default output column
+		return aggregateColumnName(e), nil
 	default:
 		return "", fmt.Errorf("expected column name, got: %T", expr)
 	}
@@ -241,17 +460,26 @@ func extractValue(expr sqlparser.Expr) (interface{}, error) {
 		default:
 			return string(e.Val), nil
 		}
+	case *sqlparser.NullVal:
+		return nil, nil
 	default:
 		return nil, fmt.Errorf("unsupported value type: %T", expr)
 	}
 }
 
-// parseSelectExprs analyzes SELECT expressions for aggregates and columns
-// Returns: aggregate expressions, column indices for projection, whether aggregates exist
-func parseSelectExprs(exprs sqlparser.SelectExprs, schema types.Schema) ([]operators.AggregateExpr, []int, bool) {
-	var aggregates []operators.AggregateExpr
-	var columns []int
-	hasAggregates := false
+// selectItem is one non-aggregate output column of the SELECT list
+type selectItem struct {
+	column   int                 // Input column index, or -1 when computed
+	expr     operators.ValueExpr // Computed value (nil for plain columns)
+	name     string
+	dataType types.DataType
+}
+
+// selectItems resolves the SELECT list against schema, for projecting it:
+// columns, expressions of them, and over an aggregate's output, the
+// aggregates as their columns. Returns nil for SELECT *.
+func (p *planner) selectItems(exprs sqlparser.SelectExprs, schema types.Schema) ([]selectItem, error) {
+	var items []selectItem
 	isSelectStar := false
 
 	for _, expr := range exprs {
@@ -260,40 +488,112 @@ func parseSelectExprs(exprs sqlparser.SelectExprs, schema types.Schema) ([]opera
 			isSelectStar = true
 
 		case *sqlparser.AliasedExpr:
-			alias := e.As.String()
-			alias = strings.Trim(alias, "`\"")
+			alias := strings.Trim(e.As.String(), "`\"")
 
+			// A column as it is: an input column, or an aggregate's output
+			var colName string
 			switch inner := e.Expr.(type) {
+			case *sqlparser.ColName:
+				colName = strings.Trim(inner.Name.String(), "`\"")
 			case *sqlparser.FuncExpr:
-				// Aggregate function
-				hasAggregates = true
-				agg, err := parseAggregateFunc(inner, schema, alias)
-				if err == nil {
-					aggregates = append(aggregates, agg)
+				if !isScalarFunction(inner) {
+					colName = aggregateColumnName(inner)
+					if p.columnIndex(schema, colName) < 0 {
+						return nil, fmt.Errorf("aggregate not allowed here: %s", sqlparser.String(inner))
+					}
 				}
-
-			case *sqlparser.ColName:
-				// Regular column
-				colName := inner.Name.String()
-				colName = strings.Trim(colName, "`\"")
-				colIdx := schema.ColumnIndex(colName)
-				if colIdx >= 0 {
-					columns = append(columns, colIdx)
+			}
+			if colName == "" {
+				// Computed column, e.g. price * qty
+				item, err := p.computedSelectItem(e.Expr, schema, alias)
+				if err != nil {
+					return nil, err
 				}
+				items = append(items, item)
+				continue
+			}
+
+			colIdx, err := p.resolveColumn(schema, colName)
+			if err != nil {
+				return nil, err
+			}
+			if colIdx < 0 {
+				return nil, fmt.Errorf("column not found in schema: %s", colName)
 			}
+			if alias == "" {
+				alias = schema.Columns[colIdx]
+			}
+			items = append(items, selectItem{
+				column:   colIdx,
+				name:     alias,
+				dataType: schema.Types[colIdx],
+			})
+
+		default:
+			return nil, fmt.Errorf("unsupported SELECT expression: %s", sqlparser.String(expr))
 		}
 	}
 
 	// SELECT * means no projection needed
 	if isSelectStar {
-		columns = nil
+		items = nil
 	}
+	return items, nil
+}
 
-	return aggregates, columns, hasAggregates
+// computedSelectItem builds a SELECT list entry evaluated per row
+func (p *planner) computedSelectItem(expr sqlparser.Expr, schema types.Schema, alias string) (selectItem, error) {
+	valueExpr, err := p.buildValueExpr(expr, schema)
+	if err != nil {
+		return selectItem{}, err
+	}
+	if alias == "" {
+		alias = sqlparser.String(expr)
+	}
+	return selectItem{
+		column:   -1,
+		expr:     valueExpr,
+		name:     alias,
+		dataType: p.exprType(expr, schema),
+	}, nil
 }
 
+// buildProjection projects the SELECT list, by column index when every item
+// is a plain column under its own name and by expression otherwise
+func buildProjection(input types.Operator, items []selectItem) types.Operator {
+	indices := make([]int, len(items))
+	computed := false
+	for i, item := range items {
+		indices[i] = item.column
+		if item.expr != nil || item.name != input.Schema().Columns[item.column] {
+			computed = true
+		}
+	}
+	if !computed {
+		return operators.NewProjectOp(input, indices)
+	}
+
+	exprs := make([]operators.ValueExpr, len(items))
+	schema := types.Schema{
+		Columns: make([]string, len(items)),
+		Types:   make([]types.DataType, len(items)),
+	}
+	for i, item := range items {
+		exprs[i] = item.expr
+		if exprs[i] == nil {
+			exprs[i] = operators.ColumnExpr(item.column)
+		}
+		schema.Columns[i] = item.name
+		schema.Types[i] = item.dataType
+	}
+	return operators.NewExprProjectOp(input, exprs, schema)
+}
+
+// maxTopK bounds APPROX_TOP_K's k, and with it the sketch's memory
+const maxTopK = 10000
+
 // parseAggregateFunc parses an aggregate function call
-func parseAggregateFunc(fn *sqlparser.FuncExpr, schema types.Schema, alias string) (operators.AggregateExpr, error) {
+func (p *planner) parseAggregateFunc(fn *sqlparser.FuncExpr, schema types.Schema, alias string) (operators.AggregateExpr, error) {
 	funcName := strings.ToUpper(fn.Name.String())
 
 	var aggType types.AggregateType
@@ -308,40 +608,145 @@ func parseAggregateFunc(fn *sqlparser.FuncExpr, schema types.Schema, alias strin
 		aggType = types.Max
 	case "AVG":
 		aggType = types.Avg
+	case "LATEST_BY":
+		aggType = types.LatestBy
+	case "APPROX_TOP_K":
+		aggType = types.ApproxTopK
 	default:
 		return operators.AggregateExpr{}, fmt.Errorf("unsupported aggregate function: %s", funcName)
 	}
 
-	// Get column index (or -1 for COUNT(*))
+	if fn.Distinct {
+		return operators.AggregateExpr{}, fmt.Errorf("%s(DISTINCT ...) is not supported: %s", funcName, sqlparser.String(fn))
+	}
+	if aggType != types.LatestBy && aggType != types.ApproxTopK && len(fn.Exprs) != 1 {
+		return operators.AggregateExpr{}, fmt.Errorf("%s takes one argument: %s", funcName, sqlparser.String(fn))
+	}
+
+	// Get column index (or -1 for COUNT(*)), or an input expression
 	colIdx := -1
+	var inputExpr operators.ValueExpr
+	var inputType types.DataType
 	if len(fn.Exprs) > 0 {
 		switch arg := fn.Exprs[0].(type) {
 		case *sqlparser.StarExpr:
+			if aggType != types.Count {
+				return operators.AggregateExpr{}, fmt.Errorf("only COUNT takes *: %s", sqlparser.String(fn))
+			}
 			colIdx = -1 // COUNT(*)
 		case *sqlparser.AliasedExpr:
 			if colName, ok := arg.Expr.(*sqlparser.ColName); ok {
 				name := strings.Trim(colName.Name.String(), "`\"")
-				colIdx = schema.ColumnIndex(name)
+				var err error
+				colIdx, err = p.resolveColumn(schema, name)
+				if err != nil {
+					return operators.AggregateExpr{}, err
+				}
+				if colIdx < 0 {
+					return operators.AggregateExpr{}, fmt.Errorf("column not found in schema: %s", name)
+				}
+			} else {
+				// Expression input, e.g. SUM(price * qty)
+				expr, err := p.buildValueExpr(arg.Expr, schema)
+				if err != nil {
+					return operators.AggregateExpr{}, err
+				}
+				inputExpr, inputType = expr, p.exprType(arg.Expr, schema)
 			}
+		default:
+			return operators.AggregateExpr{}, fmt.Errorf("unsupported %s argument: %s", funcName, sqlparser.String(arg))
+		}
+	}
+
+	// SUM and AVG add their input up, which text can't be
+	if aggType == types.Sum || aggType == types.Avg {
+		argType := inputType
+		if colIdx >= 0 {
+			argType = schema.Types[colIdx]
+		}
+		if argType == types.String {
+			return operators.AggregateExpr{}, fmt.Errorf("%s needs a numeric argument, but %s is text", funcName, strings.Trim(sqlparser.String(fn.Exprs[0]), "`\""))
+		}
+	}
+
+	// LATEST_BY(value, ordering) takes a second argument to order rows by
+	var orderBy operators.ValueExpr
+	if aggType == types.LatestBy {
+		if len(fn.Exprs) != 2 {
+			return operators.AggregateExpr{}, fmt.Errorf("LATEST_BY requires two arguments: LATEST_BY(value, ordering)")
 		}
+		arg, ok := fn.Exprs[1].(*sqlparser.AliasedExpr)
+		if !ok {
+			return operators.AggregateExpr{}, fmt.Errorf("LATEST_BY ordering must be a column or expression")
+		}
+		expr, err := p.buildValueExpr(arg.Expr, schema)
+		if err != nil {
+			return operators.AggregateExpr{}, err
+		}
+		orderBy = expr
+	}
+
+	// APPROX_TOP_K(value, k) takes the number of values to return
+	var k int
+	if aggType == types.ApproxTopK {
+		if len(fn.Exprs) != 2 {
+			return operators.AggregateExpr{}, fmt.Errorf("APPROX_TOP_K requires two arguments: APPROX_TOP_K(value, k)")
+		}
+		var value interface{}
+		arg, ok := fn.Exprs[1].(*sqlparser.AliasedExpr)
+		if ok {
+			value, _ = extractValue(arg.Expr)
+		}
+		n, ok := value.(int64)
+		if !ok || n <= 0 || n > maxTopK {
+			return operators.AggregateExpr{}, fmt.Errorf("APPROX_TOP_K k must be an integer from 1 to %d", maxTopK)
+		}
+		k = int(n)
 	}
 
 	// Default alias if not provided
 	if alias == "" {
-		if colIdx >= 0 && colIdx < len(schema.Columns) {
-			alias = fmt.Sprintf("%s(%s)", funcName, schema.Columns[colIdx])
-		} else {
-			alias = fmt.Sprintf("%s(*)", funcName)
-		}
+		alias = aggregateColumnName(fn)
+	}
+
+	var collation operators.Collation
+	if aggType == types.Min || aggType == types.Max {
+		collation = p.opts.Collation
 	}
 
 	return operators.AggregateExpr{
 		Type:        aggType,
 		ColumnIndex: colIdx,
+		Expr:        inputExpr,
+		ExprType:    inputType,
+		OrderBy:     orderBy,
+		K:           k,
+		Collation:   collation,
 		Alias:       alias,
 	}, nil
 }
 
+// aggregateColumnName returns the default output column name of an
+// aggregate call, matching the alias parseAggregateFunc assigns
+func aggregateColumnName(fn *sqlparser.FuncExpr) string {
+	funcName := strings.ToUpper(fn.Name.String())
+	args := []string{"*"}
+	if len(fn.Exprs) > 0 {
+		args = args[:0]
+		for _, expr := range fn.Exprs {
+			aliased, ok := expr.(*sqlparser.AliasedExpr)
+			if !ok {
+				args = append(args, "*")
+			} else if colName, ok := aliased.Expr.(*sqlparser.ColName); ok {
+				args = append(args, strings.Trim(colName.Name.String(), "`\""))
+			} else {
+				args = append(args, sqlparser.String(aliased.Expr))
+			}
+		}
+	}
+	return fmt.Sprintf("%s(%s)", funcName, strings.Join(args, ", "))
+}
+
 // parseLimit extracts the limit value
 func parseLimit(limit *sqlparser.Limit) (int, error) {
 	if limit.Rowcount == nil {
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aryamaansaha/golap/federation"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// TableFunc produces the rows of a table function registered with
+// RegisterTableFunction, e.g. from an API or memory. The operator's rows
+// must have the values its Schema declares: int64, float64, string or
+// nil. The query closes it.
+type TableFunc func(call TableCall) (types.Operator, error)
+
+// TableCall is one use of a table function in a query
+type TableCall struct {
+	Context context.Context   // The query's (Options.Context); nil if none
+	Name    string            // As registered
+	Args    []string          // Positional arguments, unquoted; numbers as written
+	Options map[string]string // name=>'value' arguments, by lower-case name
+}
+
+var (
+	tableFuncsMu     sync.RWMutex
+	tableFuncs       = map[string]*registeredTableFunc{} // By lower-case name
+	tableFuncPattern *regexp.Regexp                      // Matches calls of them; nil = none registered
+
+	// A table function argument: 'text' or a number, optionally name=>
+	tableFuncArg        = `(?:\w+\s*=>\s*)?(?:'(?:[^']|'')*'|-?[0-9][0-9.eE+-]*)`
+	tableFuncArgPattern = regexp.MustCompile(`(?:(\w+)\s*=>\s*)?(?:'((?:[^']|'')*)'|(-?[0-9][0-9.eE+-]*))`)
+)
+
+// registeredTableFunc is a function registered with RegisterTableFunction
+type registeredTableFunc struct {
+	name string
+	fn   TableFunc
+}
+
+// RegisterTableFunction makes fn queryable by name (ignoring case), either
+// bare (FROM name) or called with arguments, which may be quoted strings,
+// numbers or name=>'value' options:
+//
+//	FROM name('first', 2, limit=>'100')
+//
+// A bare name resolves after views and catalog tables of the same name
+// and before files. Registering a name again replaces the function;
+// read_csv and the database connectors' names can't be used.
+func RegisterTableFunction(name string, fn TableFunc) error {
+	if !udfNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid table function name %q", name)
+	}
+	key := strings.ToLower(name)
+	if key == "read_csv" {
+		return fmt.Errorf("%s is a built-in table function", name)
+	}
+	if _, err := federation.Lookup(key); err == nil {
+		return fmt.Errorf("%s is a database connector", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("%s: nil table function", name)
+	}
+	tableFuncsMu.Lock()
+	defer tableFuncsMu.Unlock()
+	tableFuncs[key] = &registeredTableFunc{name: name, fn: fn}
+	compileTableFuncPattern()
+	return nil
+}
+
+// UnregisterTableFunction removes a function registered with
+// RegisterTableFunction
+func UnregisterTableFunction(name string) {
+	tableFuncsMu.Lock()
+	defer tableFuncsMu.Unlock()
+	delete(tableFuncs, strings.ToLower(name))
+	compileTableFuncPattern()
+}
+
+// TableFunctions returns the names of the registered table functions,
+// sorted
+func TableFunctions() []string {
+	tableFuncsMu.RLock()
+	defer tableFuncsMu.RUnlock()
+	names := make([]string, 0, len(tableFuncs))
+	for _, fn := range tableFuncs {
+		names = append(names, fn.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileTableFuncPattern rebuilds the pattern matching calls of the
+// registered table functions; tableFuncsMu must be held
+func compileTableFuncPattern() {
+	if len(tableFuncs) == 0 {
+		tableFuncPattern = nil
+		return
+	}
+	names := make([]string, 0, len(tableFuncs))
+	for key := range tableFuncs {
+		names = append(names, regexp.QuoteMeta(key))
+	}
+	sort.Strings(names)
+	tableFuncPattern = regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)\s*\(\s*((?:` +
+		tableFuncArg + `)(?:\s*,\s*` + tableFuncArg + `)*)?\s*\)`)
+}
+
+// lookupTableFunc returns the table function registered under a name, or
+// nil
+func lookupTableFunc(name string) *registeredTableFunc {
+	tableFuncsMu.RLock()
+	defer tableFuncsMu.RUnlock()
+	return tableFuncs[strings.ToLower(name)]
+}
+
+// rewriteTableFuncCalls replaces each call of a registered table function
+// with a placeholder table name, remembering its arguments
+func (p *planner) rewriteTableFuncCalls(sql string) string {
+	tableFuncsMu.RLock()
+	pattern := tableFuncPattern
+	tableFuncsMu.RUnlock()
+	if pattern == nil {
+		return sql
+	}
+	return pattern.ReplaceAllStringFunc(sql, func(call string) string {
+		m := pattern.FindStringSubmatch(call)
+		fn := tableFunction{provider: strings.ToLower(m[1]), options: map[string]string{}}
+		for _, arg := range tableFuncArgPattern.FindAllStringSubmatch(m[2], -1) {
+			value := arg[3]
+			if value == "" {
+				value = strings.ReplaceAll(arg[2], "''", "'")
+			}
+			if arg[1] != "" {
+				fn.options[strings.ToLower(arg[1])] = value
+			} else {
+				fn.args = append(fn.args, value)
+			}
+		}
+		if p.tableFuncs == nil {
+			p.tableFuncs = make(map[string]tableFunction)
+		}
+		name := fmt.Sprintf("%s#%d", fn.provider, len(p.tableFuncs))
+		p.tableFuncs[name] = fn
+		return "`" + name + "`"
+	})
+}
+
+// openTableFunc runs a table function for a FROM name: a rewritten call,
+// or a bare registered name. ok is false if the name is neither.
+func (p *planner) openTableFunc(name string) (op types.Operator, ok bool, err error) {
+	call := TableCall{Context: p.opts.Context, Options: map[string]string{}}
+	registered := lookupTableFunc(name)
+	if fn, isCall := p.tableFuncs[name]; isCall && fn.provider != "" {
+		registered = lookupTableFunc(fn.provider)
+		if registered == nil {
+			return nil, true, fmt.Errorf("unknown table function: %s", fn.provider)
+		}
+		call.Args, call.Options = fn.args, fn.options
+	} else if registered == nil {
+		return nil, false, nil
+	}
+	call.Name = registered.name
+
+	if p.paging {
+		return nil, true, fmt.Errorf("paging reads a file or table directly, not table function %s", registered.name)
+	}
+	if err := p.authorize(registered.name, ""); err != nil {
+		return nil, true, err
+	}
+	op, err = registered.fn(call)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", registered.name, err)
+	}
+	if op == nil {
+		return nil, true, fmt.Errorf("%s: table function returned no operator", registered.name)
+	}
+	return op, true, nil
+}
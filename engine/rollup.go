@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Materialized summarizes what Materialize wrote
+type Materialized struct {
+	Path   string // The rollup file
+	Source string // The data file it aggregates
+	Rows   int64  // Groups written
+}
+
+// Materialize computes a GROUP BY over a whole data file into a .golap
+// rollup and registers it beside the data file, so aggregate queries
+// with the same GROUP BY columns and aggregates are answered from the
+// rollup (see answerFromRollup) until the file changes. The query must
+// be a plain SELECT ... GROUP BY over one file: COUNT, SUM, MIN, MAX and
+// AVG of columns, no WHERE, HAVING, ORDER BY or LIMIT.
+func Materialize(query, target string, opts Options) (*Materialized, error) {
+	if !operators.IsGolapPath(target) {
+		return nil, fmt.Errorf("a rollup is written to a .golap file, not %s", target)
+	}
+	p := &planner{opts: opts, tempQuota: operators.NewTempSpaceQuota(opts.TempSpaceQuota)}
+	p.opts.DisablePruning = true // Computed from the data, not from other metadata
+
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SQL: %w", err)
+	}
+	node, err := p.buildStatement(stmt, 0)
+	if err != nil {
+		return nil, err
+	}
+	aggregate, scan, err := rollupShape(node)
+	if err != nil {
+		closeSources(node)
+		return nil, err
+	}
+
+	info, err := storage.Stat(scan.filePath)
+	if err != nil {
+		closeSources(node)
+		return nil, err
+	}
+	rollup := metadata.Rollup{
+		Query:         query,
+		SourceSize:    info.Size,
+		SourceModTime: info.ModTime.UnixNano(),
+		ColumnTypes:   make(map[string]string),
+	}
+	if rollup.Path, err = filepath.Abs(target); err != nil {
+		closeSources(node)
+		return nil, err
+	}
+	schema := aggregate.inputSchema
+	for _, idx := range aggregate.groupBy {
+		rollup.GroupBy = append(rollup.GroupBy, schema.Columns[idx])
+		rollup.ColumnTypes[schema.Columns[idx]] = schema.Types[idx].String()
+	}
+	for _, agg := range aggregate.aggregates {
+		column := "*"
+		if agg.ColumnIndex >= 0 {
+			column = schema.Columns[agg.ColumnIndex]
+			rollup.ColumnTypes[column] = schema.Types[agg.ColumnIndex].String()
+		}
+		rollup.Aggregates = append(rollup.Aggregates, metadata.RollupAggregate{Function: agg.Type.String(), Column: column})
+	}
+
+	// The aggregate's own output: GROUP BY columns, then the aggregates
+	op, err := p.lower(aggregate)
+	if err != nil {
+		return nil, err
+	}
+	writer := operators.NewGolapWriteOp(op, target)
+	defer writer.Close()
+	row, err := writer.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := metadata.SaveRollup(scan.filePath, rollup); err != nil {
+		return nil, err
+	}
+	return &Materialized{Path: target, Source: scan.filePath, Rows: row.Values[1].(int64)}, nil
+}
+
+// rollupShape returns the aggregate and the scan of a plan Materialize
+// can store: an aggregate, under at most a projection, over a whole file
+func rollupShape(node logicalNode) (*logicalAggregate, *logicalScan, error) {
+	if project, ok := node.(*logicalProject); ok {
+		node = project.input
+	}
+	aggregate, ok := node.(*logicalAggregate)
+	if !ok || len(aggregate.groupBy) == 0 {
+		return nil, nil, fmt.Errorf("a rollup needs SELECT ... GROUP BY without HAVING, DISTINCT, ORDER BY or LIMIT")
+	}
+	scan, ok := aggregate.input.(*logicalScan)
+	if !ok || scan.filePath == "" {
+		return nil, nil, fmt.Errorf("a rollup aggregates a whole file: no WHERE, views or multi-file sources")
+	}
+	for _, idx := range aggregate.groupBy {
+		if idx < 0 {
+			return nil, nil, fmt.Errorf("a rollup groups by columns of the file")
+		}
+	}
+	for _, agg := range aggregate.aggregates {
+		if !rollupFunction(agg) {
+			return nil, nil, fmt.Errorf("a rollup holds COUNT, SUM, MIN, MAX and AVG of columns, not %s", agg.Alias)
+		}
+	}
+	return aggregate, scan, nil
+}
+
+// rollupFunction reports whether a rollup can hold an aggregate
+func rollupFunction(agg operators.AggregateExpr) bool {
+	switch agg.Type {
+	case types.Count, types.Sum, types.Min, types.Max, types.Avg:
+		return agg.Expr == nil
+	default:
+		return false
+	}
+}
+
+// answerFromRollup reads a GROUP BY over a whole file from a rollup of the
+// file (see Materialize) with the same GROUP BY columns and every
+// aggregate it needs, as long as the file is unchanged since. WHERE terms
+// on GROUP BY columns keep or drop whole groups, so they filter the
+// rollup's rows instead.
+func answerFromRollup(p *planner, node logicalNode) (logicalNode, bool) {
+	aggregate, ok := node.(*logicalAggregate)
+	if !ok || len(aggregate.groupBy) == 0 || p.opts.DisablePruning {
+		return node, false
+	}
+	scan, ok := aggregate.input.(*logicalScan)
+	if !ok || scan.filePath == "" || p.collatesText(scan.op.Schema(), aggregate.groupBy) {
+		return node, false // Its groups are of equal bytes
+	}
+	for _, agg := range aggregate.aggregates {
+		if (agg.Type == types.Min || agg.Type == types.Max) && p.collatesText(scan.op.Schema(), []int{agg.ColumnIndex}) {
+			return node, false // As are its MIN and MAX
+		}
+	}
+	for _, condition := range scan.conditions {
+		if !p.onGroupColumns(condition, aggregate) {
+			return node, false
+		}
+	}
+	rollups, err := metadata.LoadRollups(scan.filePath)
+	if err != nil || len(rollups) == 0 {
+		return node, false
+	}
+	info, err := storage.Stat(scan.filePath)
+	if err != nil {
+		return node, false
+	}
+	for _, rollup := range rollups {
+		if !rollup.Fresh(info) {
+			continue
+		}
+		columns, ok := rollupColumns(rollup, aggregate)
+		if !ok {
+			continue
+		}
+		if p.authorize(rollup.Path, rollup.Path) != nil || p.opts.checkPath(rollup.Path) != nil {
+			continue
+		}
+		op, err := operators.NewFileScan(rollup.Path, operators.ScanOptions{
+			BufferSize: p.opts.ReadBufferSize,
+			Mmap:       p.opts.MmapFiles,
+			Context:    p.opts.Context,
+		})
+		if err != nil {
+			continue // Deleted or unreadable: read the data instead
+		}
+		rollupSchema := op.Schema()
+		if len(rollupSchema.Columns) != len(rollup.GroupBy)+len(rollup.Aggregates) {
+			op.Close()
+			continue
+		}
+
+		// The rollup's columns, as the aggregate would have output them
+		output := operators.NewHashAggregateOp(scan.op, aggregate.groupBy, aggregate.aggregates).Schema()
+		matches := true
+		for i, column := range columns {
+			matches = matches && rollupSchema.Types[column] == output.Types[i]
+		}
+		if !matches {
+			op.Close()
+			continue
+		}
+		scan.op.Close()
+		source := &logicalScan{name: rollup.Path, op: op, filePath: rollup.Path, conditions: scan.conditions}
+		return &logicalRename{input: source, columns: columns, schema: output}, true
+	}
+	return node, false
+}
+
+// rollupColumns returns, for each output column of an aggregate, the
+// column of the rollup holding it; ok is false if the rollup groups by
+// other columns, lacks an aggregate, or read the columns as other types
+func rollupColumns(rollup metadata.Rollup, aggregate *logicalAggregate) ([]int, bool) {
+	schema := aggregate.inputSchema
+	if len(rollup.GroupBy) != len(aggregate.groupBy) {
+		return nil, false
+	}
+	typeMatches := func(idx int) bool {
+		return strings.EqualFold(rollup.ColumnTypes[schema.Columns[idx]], schema.Types[idx].String())
+	}
+
+	var columns []int
+	for _, idx := range aggregate.groupBy {
+		column := -1
+		for i, name := range rollup.GroupBy {
+			if name == schema.Columns[idx] {
+				column = i
+			}
+		}
+		if column < 0 || !typeMatches(idx) {
+			return nil, false
+		}
+		columns = append(columns, column)
+	}
+	for _, agg := range aggregate.aggregates {
+		if !rollupFunction(agg) {
+			return nil, false
+		}
+		name := "*"
+		if agg.ColumnIndex >= 0 {
+			if !typeMatches(agg.ColumnIndex) {
+				return nil, false
+			}
+			name = schema.Columns[agg.ColumnIndex]
+		}
+		column := -1
+		for i, stored := range rollup.Aggregates {
+			if stored.Function == agg.Type.String() && stored.Column == name {
+				column = len(rollup.GroupBy) + i
+			}
+		}
+		if column < 0 {
+			return nil, false
+		}
+		columns = append(columns, column)
+	}
+	return columns, true
+}
@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Rewrite rules for logical plans (see logical.go). A rule looks at one
+// node and returns its replacement and true if it changed anything, or the
+// node and false. optimize applies the rules bottom-up until none applies,
+// then decides which columns scans read.
+
+// logicalRule rewrites a node of a logical plan
+type logicalRule func(p *planner, node logicalNode) (logicalNode, bool)
+
+// logicalRules are applied in this order at each node
+var logicalRules = []logicalRule{
+	foldConstants,
+	pushDownPredicates,
+	pushDownLimits,
+	countFromMetadata,
+	answerFromRollup,
+}
+
+// Rules only move work down the plan, so they stop after a few passes; the
+// bound guards against a pair of rules undoing each other
+const maxOptimizePasses = 10
+
+// optimize rewrites a logical plan with logicalRules, then prunes the
+// columns its scans read
+func (p *planner) optimize(node logicalNode) logicalNode {
+	for range maxOptimizePasses {
+		var changed bool
+		node, changed = p.rewrite(node)
+		if !changed {
+			break
+		}
+	}
+	p.pruneColumns(node, nil, false)
+	return node
+}
+
+// rewrite applies the rules to a node's inputs, then to the node
+func (p *planner) rewrite(node logicalNode) (logicalNode, bool) {
+	changed := false
+	for _, input := range inputsOf(node) {
+		var inputChanged bool
+		*input, inputChanged = p.rewrite(*input)
+		changed = changed || inputChanged
+	}
+	for _, rule := range logicalRules {
+		var ruleChanged bool
+		node, ruleChanged = rule(p, node)
+		changed = changed || ruleChanged
+	}
+	return node, changed
+}
+
+// foldConstants evaluates arithmetic on literals once, at planning, so
+// "amount > 5 * 2" compares with 10: a literal, which zone maps, row group
+// pruning and statistics can use
+func foldConstants(p *planner, node logicalNode) (logicalNode, bool) {
+	changed := false
+	switch n := node.(type) {
+	case *logicalFilter:
+		changed = p.foldAll(n.conjuncts)
+	case *logicalScan:
+		changed = p.foldAll(n.conditions)
+	case *logicalProject:
+		for _, expr := range n.exprs {
+			aliased, ok := expr.(*sqlparser.AliasedExpr)
+			if !ok {
+				continue
+			}
+			name := sqlparser.String(aliased.Expr)
+			folded, foldedAny := p.fold(aliased.Expr)
+			if !foldedAny {
+				continue
+			}
+			// The column keeps the name it was written with
+			if aliased.As.IsEmpty() {
+				aliased.As = sqlparser.NewColIdent(name)
+			}
+			aliased.Expr = folded
+			changed = true
+		}
+	}
+	return node, changed
+}
+
+// foldAll folds the constants in each expression, in place
+func (p *planner) foldAll(exprs []sqlparser.Expr) bool {
+	changed := false
+	for i, expr := range exprs {
+		var folded bool
+		exprs[i], folded = p.fold(expr)
+		changed = changed || folded
+	}
+	return changed
+}
+
+// fold replaces the constant arithmetic in expr with its value. Aggregate
+// arguments are left as written, since they name the aggregate's column.
+func (p *planner) fold(expr sqlparser.Expr) (sqlparser.Expr, bool) {
+	var constants []sqlparser.Expr
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.FuncExpr:
+			return isScalarFunction(n), nil
+		case *sqlparser.SQLVal:
+			return false, nil
+		case sqlparser.Expr:
+			if isConstant(n) {
+				constants = append(constants, n)
+				return false, nil
+			}
+		}
+		return true, nil
+	}, expr)
+
+	changed := false
+	for _, constant := range constants {
+		value, ok := p.evaluateConstant(constant)
+		if ok {
+			expr = sqlparser.ReplaceExpr(expr, constant, value)
+			changed = true
+		}
+	}
+	return expr, changed
+}
+
+// isConstant reports whether expr is arithmetic on numeric literals only
+func isConstant(expr sqlparser.Expr) bool {
+	switch e := expr.(type) {
+	case *sqlparser.SQLVal:
+		return e.Type == sqlparser.IntVal || e.Type == sqlparser.FloatVal
+	case *sqlparser.ParenExpr:
+		return isConstant(e.Expr)
+	case *sqlparser.UnaryExpr:
+		return (e.Operator == sqlparser.UMinusStr || e.Operator == sqlparser.UPlusStr) && isConstant(e.Expr)
+	case *sqlparser.BinaryExpr:
+		if _, err := mapArithmeticOp(e.Operator); err != nil {
+			return false
+		}
+		return isConstant(e.Left) && isConstant(e.Right)
+	default:
+		return false
+	}
+}
+
+// evaluateConstant returns the literal a constant expression evaluates
+// to. ok is false if it has no literal form (division by zero).
+func (p *planner) evaluateConstant(expr sqlparser.Expr) (sqlparser.Expr, bool) {
+	valueExpr, err := p.buildValueExpr(expr, types.Schema{})
+	if err != nil {
+		return nil, false
+	}
+	switch v := valueExpr(&types.Row{}).(type) {
+	case int64:
+		return sqlparser.NewIntVal([]byte(strconv.FormatInt(v, 10))), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		return sqlparser.NewFloatVal([]byte(strconv.FormatFloat(v, 'g', -1, 64))), true
+	default:
+		return nil, false
+	}
+}
+
+// pushDownPredicates moves filters toward the scan: WHERE terms become
+// the scan's conditions, stacked filters merge, and HAVING terms on GROUP
+// BY columns filter rows before they are aggregated rather than groups
+// after
+func pushDownPredicates(p *planner, node logicalNode) (logicalNode, bool) {
+	filter, ok := node.(*logicalFilter)
+	if !ok {
+		return node, false
+	}
+	switch input := filter.input.(type) {
+	case *logicalScan:
+		input.conditions = append(input.conditions, filter.conjuncts...)
+		return input, true
+
+	case *logicalFilter:
+		if input.clause != filter.clause {
+			return node, false
+		}
+		input.conjuncts = append(input.conjuncts, filter.conjuncts...)
+		return input, true
+
+	case *logicalAggregate:
+		var kept, pushed []sqlparser.Expr
+		for _, conjunct := range filter.conjuncts {
+			if p.onGroupColumns(conjunct, input) {
+				pushed = append(pushed, conjunct)
+			} else {
+				kept = append(kept, conjunct)
+			}
+		}
+		if len(pushed) == 0 {
+			return node, false
+		}
+		input.input = &logicalFilter{input: input.input, conjuncts: pushed, clause: "WHERE"}
+		if len(kept) == 0 {
+			return input, true
+		}
+		filter.conjuncts = kept
+		return filter, true
+	}
+	return node, false
+}
+
+// onGroupColumns reports whether a HAVING term reads only GROUP BY columns
+// of an aggregate, by names no aggregate's output shadows, so it means the
+// same of the aggregate's input rows
+func (p *planner) onGroupColumns(expr sqlparser.Expr, aggregate *logicalAggregate) bool {
+	ok := len(aggregate.groupBy) > 0
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.FuncExpr:
+			if !isScalarFunction(n) {
+				ok = false
+			}
+		case *sqlparser.ColName:
+			name := strings.Trim(n.Name.String(), "`\"")
+			idx := p.columnIndex(aggregate.inputSchema, name)
+			if idx < 0 || !slices.Contains(aggregate.groupBy, idx) {
+				ok = false
+			}
+			for _, agg := range aggregate.aggregates {
+				if strings.EqualFold(agg.Alias, name) {
+					ok = false
+				}
+			}
+		case *sqlparser.Subquery:
+			ok = false
+		}
+		return ok, nil
+	}, expr)
+	return ok
+}
+
+// pushDownLimits moves a LIMIT below the projection, so rows past it are
+// never computed, and copies it into each side of a UNION ALL, so neither
+// side reads more rows than the result can use
+func pushDownLimits(p *planner, node logicalNode) (logicalNode, bool) {
+	limit, ok := node.(*logicalLimit)
+	if !ok {
+		return node, false
+	}
+	switch input := limit.input.(type) {
+	case *logicalProject:
+		limit.input = input.input
+		input.input = limit
+		return input, true
+
+	case *logicalLimit:
+		input.count = min(input.count, limit.count)
+		return input, true
+
+	case *logicalUnion:
+		changed := false
+		for _, side := range []*logicalNode{&input.left, &input.right} {
+			if rows := maxRows(*side); rows < 0 || rows > limit.count {
+				*side = &logicalLimit{input: *side, count: limit.count}
+				changed = true
+			}
+		}
+		return node, changed
+	}
+	return node, false
+}
+
+// countFromMetadata answers COUNT(*) and COUNT(col) of a whole CSV file
+// from its zone map, which counts its rows and empty fields, instead of
+// reading it. The zone map must be of the file as it is now (same size),
+// and the scan must read the file as the zone map did.
+func countFromMetadata(p *planner, node logicalNode) (logicalNode, bool) {
+	aggregate, ok := node.(*logicalAggregate)
+	if !ok || len(aggregate.groupBy) > 0 || p.opts.DisablePruning {
+		return node, false
+	}
+	scan, ok := aggregate.input.(*logicalScan)
+	if !ok || len(scan.conditions) > 0 || scan.filePath == "" {
+		return node, false
+	}
+	csvScan, ok := scan.op.(*operators.CSVScan)
+	if !ok || !csvScan.ReadsAsPlainCSV() {
+		return node, false
+	}
+	zm, ok := p.loadZoneMap(scan.filePath)
+	if !ok || zm.NullCounts == nil || zm.FileSize <= 0 || zm.FileSize != csvScan.FileSize() {
+		return node, false
+	}
+
+	schema := csvScan.Schema()
+	values := make([]interface{}, len(aggregate.aggregates))
+	for i, agg := range aggregate.aggregates {
+		if agg.Type != types.Count || agg.Expr != nil {
+			return node, false
+		}
+		values[i] = zm.RowCount
+		if agg.ColumnIndex < 0 || schema.Types[agg.ColumnIndex] == types.String {
+			continue // Empty strings are values, not NULLs
+		}
+		// COUNT(col) counts the column's non-NULL values
+		nulls, ok := zm.NullCounts[schema.Columns[agg.ColumnIndex]]
+		if !ok {
+			return node, false
+		}
+		values[i] = zm.RowCount - nulls
+	}
+
+	outputSchema := operators.NewScalarAggregateOp(csvScan, aggregate.aggregates).Schema()
+	csvScan.Close()
+	rows := []*types.Row{{Values: values}}
+	return &logicalValues{op: operators.NewValuesOpWithDetails(outputSchema, rows, "counts from zone map")}, true
+}
+
+// maxRows returns the most rows a plan can return, or -1 if unbounded
+func maxRows(node logicalNode) int {
+	switch n := node.(type) {
+	case *logicalLimit:
+		return n.count
+	case *logicalProject:
+		return maxRows(n.input)
+	case *logicalUnion:
+		left, right := maxRows(n.left), maxRows(n.right)
+		if left < 0 || right < 0 {
+			return -1
+		}
+		return left + right
+	default:
+		return -1
+	}
+}
+
+// pruneColumns makes the .golap scans of a plan read only the columns the
+// nodes above them reference. refs are those nodes' expressions; bounded
+// is set once a projection or aggregate above limits the output to them.
+func (p *planner) pruneColumns(node logicalNode, refs []sqlparser.SQLNode, bounded bool) {
+	switch n := node.(type) {
+	case *logicalScan:
+		if _, ok := n.op.(*operators.GolapScan); !ok || !bounded {
+			return
+		}
+		for _, condition := range n.conditions {
+			refs = append(refs, condition)
+		}
+		if needed, ok := p.referencedColumns(refs, n.op.Schema()); ok {
+			n.needed = needed
+		}
+		return
+
+	case *logicalUnion:
+		// Each side is a SELECT of its own
+		p.pruneColumns(n.left, nil, false)
+		p.pruneColumns(n.right, nil, false)
+		return
+
+	case *logicalRename:
+		// Its columns are referenced by position, not by name
+		p.pruneColumns(n.input, nil, false)
+		return
+
+	case *logicalFilter:
+		for _, conjunct := range n.conjuncts {
+			refs = append(refs, conjunct)
+		}
+	case *logicalAggregate:
+		refs = append(refs, n.exprs, n.groupByExpr)
+		bounded = true
+	case *logicalProject:
+		refs = append(refs, n.exprs)
+		bounded = true
+	case *logicalSort:
+		for _, key := range n.keys {
+			if key.expr != nil {
+				refs = append(refs, key.expr)
+			}
+		}
+	}
+	for _, input := range inputsOf(node) {
+		p.pruneColumns(*input, refs, bounded)
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrPathNotAllowed is the cause of a query reading or writing a file
+// outside Options.AllowedRoots, or a URL or database whose scheme neither
+// Options.AllowedSchemes nor AllowedRoots allows
+var ErrPathNotAllowed = errors.New("path not allowed")
+
+// sandboxed reports whether the options restrict the paths queries use
+func (opts Options) sandboxed() bool {
+	return len(opts.AllowedRoots) > 0 || len(opts.AllowedSchemes) > 0
+}
+
+// checkPath returns an error wrapping ErrPathNotAllowed if the sandbox
+// doesn't allow a file, glob, directory or URL; a glob is checked by the
+// directory it starts from, and its matches each on their own
+func (opts Options) checkPath(path string) error {
+	if !opts.sandboxed() {
+		return nil
+	}
+	if scheme, _, ok := strings.Cut(path, "://"); ok && !strings.ContainsAny(scheme, `/\`) {
+		if opts.schemeAllowed(scheme) || opts.urlAllowed(path) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+
+	if i := strings.IndexAny(path, "*?["); i >= 0 {
+		path = filepath.Dir(path[:i] + "x") // The directory the pattern starts in
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+	}
+	for _, root := range opts.AllowedRoots {
+		if strings.Contains(root, "://") {
+			continue
+		}
+		resolvedRoot, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(resolvedRoot, resolved); err == nil &&
+			rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrPathNotAllowed, path)
+}
+
+// checkScheme returns an error wrapping ErrPathNotAllowed if the sandbox
+// doesn't allow a database connector, whose name counts as its scheme
+func (opts Options) checkScheme(scheme string) error {
+	if !opts.sandboxed() || opts.schemeAllowed(scheme) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s:// sources", ErrPathNotAllowed, scheme)
+}
+
+// schemeAllowed reports whether AllowedSchemes has a scheme, ignoring case
+func (opts Options) schemeAllowed(scheme string) bool {
+	for _, allowed := range opts.AllowedSchemes {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "://"), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlAllowed reports whether a URL is under a URL prefix in AllowedRoots,
+// e.g. s3://bucket/tenant/; URLs with . or .. segments never are
+func (opts Options) urlAllowed(url string) bool {
+	scheme, rest, _ := strings.Cut(url, "://")
+	if slices.ContainsFunc(strings.Split(rest, "/"), func(s string) bool { return s == "." || s == ".." }) {
+		return false
+	}
+	for _, root := range opts.AllowedRoots {
+		rootScheme, rootRest, ok := strings.Cut(root, "://")
+		if !ok || !strings.EqualFold(rootScheme, scheme) {
+			continue
+		}
+		rootRest = strings.TrimSuffix(rootRest, "/") + "/"
+		if strings.HasPrefix(rest, rootRest) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute path a local path names once symlinks
+// are followed. A path that doesn't exist yet, like a file to be written,
+// resolves through the nearest directory above it that does; one with ..
+// elements then can't be resolved safely and fails.
+func resolvePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		// Not filepath.Join, which would drop .. elements before symlinks
+		// are followed
+		path = wd + string(filepath.Separator) + path
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved, nil
+	}
+	if slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..") {
+		return "", fmt.Errorf("cannot resolve %s", path)
+	}
+	rest := ""
+	for dir := filepath.Clean(path); ; {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Clean(path), nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ParseColumnTypes parses a list of column types such as
+// "id:INT, zip:VARCHAR" (type names may be quoted: zip:'VARCHAR'), as taken
+// by the -schema flag and read_csv's columns=>{...}
+// Returns the column names in order along with their types.
+func ParseColumnTypes(spec string) ([]string, map[string]types.DataType, error) {
+	var names []string
+	columnTypes := make(map[string]types.DataType)
+	for _, entry := range strings.Split(spec, ",") {
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			return nil, nil, fmt.Errorf("expected column:type, got %q", strings.TrimSpace(entry))
+		}
+		name := unquoteIdentifier(entry[:sep])
+		if name == "" {
+			return nil, nil, fmt.Errorf("missing column name in %q", strings.TrimSpace(entry))
+		}
+		if _, dup := columnTypes[name]; dup {
+			return nil, nil, fmt.Errorf("column listed twice: %s", name)
+		}
+		dt, err := types.ParseDataType(unquoteIdentifier(entry[sep+1:]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		names = append(names, name)
+		columnTypes[name] = dt
+	}
+	return names, columnTypes, nil
+}
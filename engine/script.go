@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// SplitStatements splits a script into individual SQL statements on
+// semicolons, ignoring semicolons inside quoted strings and identifiers.
+// Empty statements (e.g. a trailing semicolon) are dropped.
+func SplitStatements(script string) ([]string, error) {
+	pieces, err := sqlparser.SplitStatementToPieces(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split statements: %w", err)
+	}
+
+	statements := make([]string, 0, len(pieces))
+	for _, piece := range pieces {
+		piece = strings.TrimSpace(piece)
+		if piece != "" {
+			statements = append(statements, piece)
+		}
+	}
+	return statements, nil
+}
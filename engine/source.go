@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aryamaansaha/golap/federation"
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// openSource returns the input operator for a FROM name, resolved in order:
+// a view (planned from its definition), a registered catalog table, a
+// registered table function (see RegisterTableFunction), or a file path
+// (possibly given through read_csv). A table or file path may be
+// a glob or directory naming several files (or a file under key=value
+// partition directories), read as one by MultiFileScan.
+// filePath is the data file backing the source ("" for views and
+// multi-file sources), used for file-level metadata like zone maps.
+// Column types are inferred unless declared; later declarations win: a
+// .schema.json sidecar, the catalog table, the -schema option, then
+// read_csv's columns=>{...}.
+func (p *planner) openSource(name string, viewDepth int) (op types.Operator, filePath string, err error) {
+	cat, err := p.loadCatalog()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if view, ok := cat.View(name); ok {
+		if p.paging {
+			return nil, "", fmt.Errorf("paging reads a file or table directly, not a view: %s", name)
+		}
+		if err := p.authorize(name, ""); err != nil {
+			return nil, "", err
+		}
+		if viewDepth >= maxViewDepth {
+			return nil, "", fmt.Errorf("%w: %s", errRecursiveView, name)
+		}
+		op, err := p.planQuery(view.Query, viewDepth+1)
+		if errors.Is(err, errRecursiveView) {
+			return nil, "", err // Don't wrap once per nesting level
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to expand view %s: %w", name, err)
+		}
+		return op, "", nil
+	}
+
+	if _, isTable := cat.Table(name); !isTable {
+		if op, ok, err := p.openTableFunc(name); ok {
+			return op, "", err
+		}
+	}
+
+	scanOpts := operators.ScanOptions{
+		BufferSize: p.opts.ReadBufferSize,
+		Delimiter:  p.opts.Delimiter,
+		NoHeader:   p.opts.NoHeader,
+		SampleRows: p.opts.SampleRows,
+		NullValues: p.opts.NullValues,
+		Strict:     p.opts.StrictParse,
+		RaggedRows: p.opts.RaggedRows,
+		Encoding:   p.opts.Encoding,
+		Workers:    p.opts.ScanWorkers,
+		Mmap:       p.opts.MmapFiles,
+		Context:    p.opts.Context,
+	}
+	if p.paging {
+		scanOpts.StartOffset = p.pageOffset
+		scanOpts.Workers = 0 // Page tokens need each row's offset
+	}
+
+	if fn, ok := p.tableFuncs[name]; ok && fn.connector != "" {
+		op, err := p.openRemote(fn, scanOpts)
+		return op, "", err
+	}
+
+	filePath = name
+	if fn, ok := p.tableFuncs[name]; ok {
+		filePath = fn.path
+		if fn.delimiter != 0 {
+			scanOpts.Delimiter = fn.delimiter
+		}
+		if fn.noHeader != nil {
+			scanOpts.NoHeader = *fn.noHeader
+		}
+		if fn.raggedRows != nil {
+			scanOpts.RaggedRows = *fn.raggedRows
+		}
+		scanOpts.ColumnNames = fn.columns
+	}
+
+	table, isTable := cat.Table(name)
+	if isTable {
+		if table.Delimiter != "" {
+			scanOpts.Delimiter, err = operators.ParseDelimiter(table.Delimiter)
+			if err != nil {
+				return nil, "", fmt.Errorf("table %s: %w", name, err)
+			}
+		}
+		filePath = cat.ResolvePath(table)
+		// The table's own parsing options win over the query-wide ones
+		if table.Encoding != "" {
+			scanOpts.Encoding = table.Encoding
+		}
+		if len(table.NullValues) > 0 {
+			scanOpts.NullValues = table.NullValues
+		}
+		if table.SampleRows > 0 {
+			scanOpts.SampleRows = table.SampleRows
+		}
+		scanOpts.Strict = scanOpts.Strict || table.Strict
+		scanOpts.NoHeader = table.NoHeader
+		if table.NoHeader {
+			// Declared columns name the fields in order
+			for _, col := range table.Columns {
+				scanOpts.ColumnNames = append(scanOpts.ColumnNames, col.Name)
+			}
+		}
+	}
+
+	if err := p.authorize(name, filePath); err != nil {
+		return nil, "", err
+	}
+	if err := p.opts.checkPath(filePath); err != nil {
+		return nil, "", err
+	}
+	p.sources = append(p.sources, filePath)
+	filePaths, err := expandDataPath(filePath)
+	if err != nil {
+		if isTable {
+			return nil, "", fmt.Errorf("table %s: %w", name, err)
+		}
+		return nil, "", err
+	}
+	if filePath != filePaths[0] || len(filePaths) > 1 {
+		// Matches may be symlinks leading out of the allowed roots
+		for _, path := range filePaths {
+			if err := p.opts.checkPath(path); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	// A partitioned file still needs MultiFileScan for its partition columns
+	single := len(filePaths) == 1 && len(operators.PartitionValues(filePaths[0])) == 0
+	if single {
+		filePath = filePaths[0]
+		scanOpts.ColumnTypes, err = metadata.LoadSchema(filePath)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		filePath = "" // File-level metadata (zone maps, stats) is per file
+	}
+	declare := func(col string, dt types.DataType) {
+		if scanOpts.ColumnTypes == nil {
+			scanOpts.ColumnTypes = make(map[string]types.DataType)
+		}
+		scanOpts.ColumnTypes[col] = dt
+	}
+	if isTable {
+		for _, col := range table.Columns {
+			if col.Type == "" {
+				continue // Name only; the type is inferred
+			}
+			dt, err := types.ParseDataType(col.Type)
+			if err != nil {
+				return nil, "", fmt.Errorf("table %s, column %s: %w", name, col.Name, err)
+			}
+			declare(col.Name, dt)
+		}
+	}
+	for col, dt := range p.opts.ColumnTypes {
+		declare(col, dt)
+	}
+	if fn, ok := p.tableFuncs[name]; ok {
+		for col, dt := range fn.types {
+			declare(col, dt)
+		}
+	}
+
+	var scan types.Operator
+	if single {
+		scan, err = operators.NewFileScan(filePath, scanOpts)
+	} else {
+		scan, err = operators.NewMultiFileScan(filePaths, scanOpts)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create scan: %w", err)
+	}
+	if p.paging {
+		csvScan, ok := scan.(*operators.CSVScan)
+		encoding, _ := operators.ParseEncoding(scanOpts.Encoding)
+		if !ok || strings.HasSuffix(filePath, ".gz") || encoding != operators.EncodingUTF8 || (isTable && len(table.PrimaryKey) > 0) {
+			scan.Close()
+			return nil, "", fmt.Errorf("paging needs an uncompressed UTF-8 CSV file without a primary key: %s", name)
+		}
+		p.pageScan, p.pagePath = csvScan, filePath
+	}
+	if isTable && len(table.PrimaryKey) > 0 {
+		merged, err := p.mergeOnRead(scan, table)
+		if err != nil {
+			scan.Close()
+			return nil, "", err
+		}
+		return merged, filePath, nil
+	}
+	return scan, filePath, nil
+}
+
+// openRemote starts reading a table from a database connector call like
+// postgres('dsn', 'schema.table'); the Authorize hook sees the table name
+// and the DSN without its password
+func (p *planner) openRemote(fn tableFunction, scanOpts operators.ScanOptions) (types.Operator, error) {
+	if p.paging {
+		return nil, fmt.Errorf("paging reads a file or table directly, not a %s table", fn.connector)
+	}
+	connector, err := federation.Lookup(fn.connector)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.opts.checkScheme(fn.connector); err != nil {
+		return nil, err
+	}
+	if err := p.authorize(fn.table, connector.Redact(fn.dsn)); err != nil {
+		return nil, err
+	}
+	scanOpts.ColumnTypes = p.opts.ColumnTypes
+	scan, err := operators.NewRemoteScan(fn.connector, fn.dsn, fn.table, scanOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scan: %w", err)
+	}
+	return scan, nil
+}
+
+// authorize asks the Authorize hook, if any, whether a source may be read
+func (p *planner) authorize(name, path string) error {
+	if p.opts.Authorize == nil {
+		return nil
+	}
+	return p.opts.Authorize(name, path)
+}
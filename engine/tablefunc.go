@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aryamaansaha/golap/federation"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+var (
+	// read_csv('path' [, name=>'value' | name=>{...} ...]); quotes inside
+	// strings are doubled
+	readCSVPattern    = regexp.MustCompile(`(?i)\bread_csv\s*\(\s*'((?:[^']|'')*)'((?:\s*,\s*\w+\s*=>\s*(?:'(?:[^']|'')*'|\{[^{}]*\}))*)\s*\)`)
+	readCSVArgPattern = regexp.MustCompile(`(\w+)\s*=>\s*(?:'((?:[^']|'')*)'|\{([^{}]*)\})`)
+)
+
+// tableFunction is a parsed read_csv, database connector or registered
+// table function call standing in for a table name
+type tableFunction struct {
+	path       string
+	connector  string // postgres, mysql, ...: read table from dsn instead of path
+	dsn        string
+	table      string
+	provider   string                     // A RegisterTableFunction name: call it instead
+	args       []string                   // The provider's positional arguments
+	options    map[string]string          // The provider's name=>'value' arguments
+	delimiter  rune                       // 0 = use the default
+	noHeader   *bool                      // header=>'false'; nil = use the default
+	raggedRows *operators.RaggedRowPolicy // ragged_rows=>'skip'; nil = use the default
+	columns    []string                   // columns=>'a,b,c' or columns=>{a:'INT', ...}
+	types      map[string]types.DataType  // columns=>{a:'INT', ...}
+}
+
+// rewriteTableFunctions replaces each read_csv(...) call (and database
+// connector or registered table function call) with a quoted placeholder
+// table name that sqlparser accepts, remembering the call's arguments so
+// openSource can resolve the placeholder
+func (p *planner) rewriteTableFunctions(sql string) (string, error) {
+	var rewriteErr error
+	rewritten := readCSVPattern.ReplaceAllStringFunc(sql, func(call string) string {
+		m := readCSVPattern.FindStringSubmatch(call)
+		fn := tableFunction{path: strings.ReplaceAll(m[1], "''", "'")}
+
+		for _, arg := range readCSVArgPattern.FindAllStringSubmatch(m[2], -1) {
+			value := strings.ReplaceAll(arg[2], "''", "'")
+			if strings.HasSuffix(arg[0], "}") {
+				if strings.ToLower(arg[1]) != "columns" {
+					if rewriteErr == nil {
+						rewriteErr = fmt.Errorf("read_csv: %s takes a quoted value", arg[1])
+					}
+					continue
+				}
+				// Names and types, in column order
+				names, columnTypes, err := ParseColumnTypes(arg[3])
+				if err != nil && rewriteErr == nil {
+					rewriteErr = fmt.Errorf("read_csv: %w", err)
+				}
+				fn.columns, fn.types = names, columnTypes
+				continue
+			}
+			switch strings.ToLower(arg[1]) {
+			case "delim", "delimiter", "sep":
+				delimiter, err := operators.ParseDelimiter(value)
+				if err != nil && rewriteErr == nil {
+					rewriteErr = fmt.Errorf("read_csv: %w", err)
+				}
+				fn.delimiter = delimiter
+			case "header":
+				switch strings.ToLower(value) {
+				case "true", "false":
+					noHeader := strings.ToLower(value) == "false"
+					fn.noHeader = &noHeader
+				default:
+					if rewriteErr == nil {
+						rewriteErr = fmt.Errorf("read_csv: header must be 'true' or 'false'")
+					}
+				}
+			case "ragged_rows":
+				policy, err := operators.ParseRaggedRowPolicy(value)
+				if err != nil && rewriteErr == nil {
+					rewriteErr = fmt.Errorf("read_csv: %w", err)
+				}
+				fn.raggedRows = &policy
+			case "columns":
+				for _, col := range strings.Split(value, ",") {
+					fn.columns = append(fn.columns, strings.TrimSpace(col))
+				}
+			default:
+				if rewriteErr == nil {
+					rewriteErr = fmt.Errorf("read_csv: unknown option: %s", arg[1])
+				}
+			}
+		}
+
+		if p.tableFuncs == nil {
+			p.tableFuncs = make(map[string]tableFunction)
+		}
+		name := fmt.Sprintf("read_csv#%d", len(p.tableFuncs))
+		p.tableFuncs[name] = fn
+		return "`" + name + "`"
+	})
+	if rewriteErr != nil {
+		return rewritten, rewriteErr
+	}
+	return p.rewriteTableFuncCalls(p.rewriteConnectorCalls(rewritten)), nil
+}
+
+// rewriteConnectorCalls replaces each postgres('dsn', 'schema.table') (or
+// other registered connector) call with a placeholder table name
+func (p *planner) rewriteConnectorCalls(sql string) string {
+	names := federation.Names()
+	for i, name := range names {
+		names[i] = regexp.QuoteMeta(name)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)\s*\(\s*'((?:[^']|'')*)'\s*,\s*'((?:[^']|'')*)'\s*\)`)
+	return pattern.ReplaceAllStringFunc(sql, func(call string) string {
+		m := pattern.FindStringSubmatch(call)
+		fn := tableFunction{
+			connector: strings.ToLower(m[1]),
+			dsn:       strings.ReplaceAll(m[2], "''", "'"),
+			table:     strings.ReplaceAll(m[3], "''", "'"),
+		}
+		if p.tableFuncs == nil {
+			p.tableFuncs = make(map[string]tableFunction)
+		}
+		name := fmt.Sprintf("%s#%d", fn.connector, len(p.tableFuncs))
+		p.tableFuncs[name] = fn
+		return "`" + name + "`"
+	})
+}
@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// scalarUDF is a Go function registered with RegisterScalar
+type scalarUDF struct {
+	name     string // As registered, for errors
+	fn       reflect.Value
+	params   []reflect.Type // The variadic parameter's element type last
+	variadic bool
+	result   types.DataType
+	hasErr   bool // Returns (value, error)
+}
+
+var (
+	udfsMu sync.RWMutex
+	udfs   = map[string]*scalarUDF{} // By upper-case name
+
+	udfNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// builtinFunctions can't be replaced by a UDF
+	builtinFunctions = []string{
+		"HASH", "BUCKET", "HASH_SHA256", "MASK_EMAIL", "GENERALIZE_DATE",
+		"COUNT", "SUM", "MIN", "MAX", "AVG", "LATEST_BY", "APPROX_TOP_K",
+	}
+
+	stringType  = reflect.TypeOf("")
+	int64Type   = reflect.TypeOf(int64(0))
+	intType     = reflect.TypeOf(0)
+	float64Type = reflect.TypeOf(float64(0))
+	anyType     = reflect.TypeOf((*interface{})(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterScalar makes a Go function callable in SQL by name (ignoring
+// case), e.g.
+//
+//	engine.RegisterScalar("domain_of", func(email string) string { ... })
+//
+// Parameters may be string, int64, int, float64 or interface{}, and the
+// last may be variadic. The function returns a string, int64, int or
+// float64, optionally followed by an error. Calls are checked at plan
+// time: the number of arguments, and that each argument's type fits its
+// parameter (Int fits float64, any type fits interface{}). A NULL
+// argument makes the call NULL without running the function, unless its
+// parameter is interface{}, which gets nil. A returned error fails the
+// query, prefixed with the function's name.
+// Registering a name again replaces the function; the built-in functions
+// and aggregates can't be replaced. The function may be called from many
+// goroutines at once.
+func RegisterScalar(name string, fn interface{}) error {
+	if !udfNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	key := strings.ToUpper(name)
+	for _, builtin := range builtinFunctions {
+		if key == builtin {
+			return fmt.Errorf("%s is a built-in function", key)
+		}
+	}
+
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return fmt.Errorf("%s: %T is not a function", name, fn)
+	}
+	fnType := value.Type()
+	udf := &scalarUDF{name: name, fn: value, variadic: fnType.IsVariadic()}
+	for i := 0; i < fnType.NumIn(); i++ {
+		param := fnType.In(i)
+		if udf.variadic && i == fnType.NumIn()-1 {
+			param = param.Elem()
+		}
+		switch param {
+		case stringType, int64Type, intType, float64Type, anyType:
+		default:
+			return fmt.Errorf("%s: unsupported parameter type %s (use string, int64, int, float64 or interface{})", name, param)
+		}
+		udf.params = append(udf.params, param)
+	}
+
+	switch {
+	case fnType.NumOut() == 2 && fnType.Out(1) == errorType:
+		udf.hasErr = true
+	case fnType.NumOut() != 1:
+		return fmt.Errorf("%s: must return one value, optionally followed by an error", name)
+	}
+	switch fnType.Out(0) {
+	case stringType:
+		udf.result = types.String
+	case int64Type, intType:
+		udf.result = types.Int
+	case float64Type:
+		udf.result = types.Float
+	default:
+		return fmt.Errorf("%s: unsupported result type %s (use string, int64, int or float64)", name, fnType.Out(0))
+	}
+
+	udfsMu.Lock()
+	defer udfsMu.Unlock()
+	udfs[key] = udf
+	return nil
+}
+
+// UnregisterScalar removes a function registered with RegisterScalar
+func UnregisterScalar(name string) {
+	udfsMu.Lock()
+	defer udfsMu.Unlock()
+	delete(udfs, strings.ToUpper(name))
+}
+
+// ScalarFunctions returns the names of the registered functions, sorted
+func ScalarFunctions() []string {
+	udfsMu.RLock()
+	defer udfsMu.RUnlock()
+	names := make([]string, 0, len(udfs))
+	for _, udf := range udfs {
+		names = append(names, udf.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupUDF returns the function registered under a name, or nil
+func lookupUDF(name string) *scalarUDF {
+	udfsMu.RLock()
+	defer udfsMu.RUnlock()
+	return udfs[strings.ToUpper(name)]
+}
+
+// param returns the type of the i-th argument's parameter
+func (u *scalarUDF) param(i int) reflect.Type {
+	if i >= len(u.params) {
+		return u.params[len(u.params)-1]
+	}
+	return u.params[i]
+}
+
+// signature describes the arguments the function takes, for errors
+func (u *scalarUDF) signature() string {
+	names := make([]string, len(u.params))
+	for i, param := range u.params {
+		names[i] = param.String()
+	}
+	if u.variadic {
+		names[len(names)-1] = "..." + names[len(names)-1]
+	}
+	return fmt.Sprintf("%s(%s)", strings.ToUpper(u.name), strings.Join(names, ", "))
+}
+
+// build checks a call's arguments against the parameters and returns the
+// call as an expression; a nil argument type (NULL) fits any parameter.
+// An error the function returns is recorded in errs, which fails the query.
+func (u *scalarUDF) build(args []operators.ValueExpr, argTypes []*types.DataType, errs *operators.ExprErrors) (operators.ValueExpr, error) {
+	fixed := len(u.params)
+	if u.variadic {
+		fixed--
+	}
+	if len(args) < fixed || (!u.variadic && len(args) > fixed) {
+		want := fmt.Sprint(fixed)
+		if u.variadic {
+			want = fmt.Sprintf("at least %d", fixed)
+		}
+		return nil, fmt.Errorf("%s takes %s arguments, got %d", u.signature(), want, len(args))
+	}
+	for i, argType := range argTypes {
+		if argType != nil && !paramAccepts(u.param(i), *argType) {
+			return nil, fmt.Errorf("%s argument %d is %s, which doesn't fit %s", u.signature(), i+1, *argType, u.param(i))
+		}
+	}
+
+	return func(row *types.Row) interface{} {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			param := u.param(i)
+			v := arg(row)
+			if v == nil {
+				if param != anyType {
+					return nil
+				}
+				in[i] = reflect.Zero(anyType)
+				continue
+			}
+			converted, ok := convertArg(v, param)
+			if !ok {
+				return nil
+			}
+			in[i] = converted
+		}
+		out := u.fn.Call(in)
+		if u.hasErr && !out[1].IsNil() {
+			errs.Set(fmt.Errorf("%s: %w", strings.ToUpper(u.name), out[1].Interface().(error)))
+			return nil
+		}
+		if out[0].Kind() == reflect.Int {
+			return out[0].Int()
+		}
+		return out[0].Interface()
+	}, nil
+}
+
+// paramAccepts reports whether values of a column type fit a parameter
+func paramAccepts(param reflect.Type, argType types.DataType) bool {
+	switch param {
+	case stringType:
+		return argType == types.String
+	case int64Type, intType:
+		return argType == types.Int
+	case float64Type:
+		return argType == types.Int || argType == types.Float
+	default:
+		return true
+	}
+}
+
+// convertArg converts a row value to a parameter's type; false if it
+// can't be, as when a value doesn't match its column's inferred type
+func convertArg(v interface{}, param reflect.Type) (reflect.Value, bool) {
+	switch param {
+	case stringType:
+		s, ok := v.(string)
+		return reflect.ValueOf(s), ok
+	case int64Type:
+		n, ok := v.(int64)
+		return reflect.ValueOf(n), ok
+	case intType:
+		n, ok := v.(int64)
+		return reflect.ValueOf(int(n)), ok
+	case float64Type:
+		switch n := v.(type) {
+		case float64:
+			return reflect.ValueOf(n), true
+		case int64:
+			return reflect.ValueOf(float64(n)), true
+		}
+		return reflect.Value{}, false
+	default:
+		return reflect.ValueOf(&v).Elem(), true
+	}
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ValidateFile checks a CSV file's quality (see operators.ValidateCSV),
+// reading it with the options a query would: the parsing options, then
+// the column types of its .schema.json sidecar and ColumnTypes, if
+// AllowedRoots allow it.
+func ValidateFile(filePath string, opts Options) (*operators.CSVReport, error) {
+	if err := opts.checkPath(filePath); err != nil {
+		return nil, err
+	}
+	columnTypes, err := metadata.LoadSchema(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for col, dt := range opts.ColumnTypes {
+		if columnTypes == nil {
+			columnTypes = make(map[string]types.DataType)
+		}
+		columnTypes[col] = dt
+	}
+	return operators.ValidateCSV(filePath, operators.ScanOptions{
+		BufferSize:  opts.ReadBufferSize,
+		ColumnTypes: columnTypes,
+		Delimiter:   opts.Delimiter,
+		NoHeader:    opts.NoHeader,
+		SampleRows:  opts.SampleRows,
+		NullValues:  opts.NullValues,
+		Encoding:    opts.Encoding,
+		Mmap:        opts.MmapFiles,
+		Context:     opts.Context,
+	})
+}
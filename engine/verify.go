@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// selectPattern matches the read-only statements VerifyPruning runs twice
+var selectPattern = regexp.MustCompile(`(?is)^\s*\(?\s*SELECT\b`)
+
+// PruningMismatch describes where a query's pruned plan returned different
+// rows from the same query reading every file
+type PruningMismatch struct {
+	Row    int    // 1-based position of the first differing row
+	Pruned string // That row from the pruned plan ("" if it ended early)
+	Full   string // That row from the full scan ("" if it ended early)
+}
+
+func (m *PruningMismatch) Error() string {
+	describe := func(row string) string {
+		if row == "" {
+			return "no row"
+		}
+		return row
+	}
+	return fmt.Sprintf("pruning changed the result at row %d: pruned plan gave %s, full scan gave %s",
+		m.Row, describe(m.Pruned), describe(m.Full))
+}
+
+// VerifyPruning runs a SELECT twice, with pruning and with
+// DisablePruning, and compares the rows in order (pruning only skips
+// files without matching rows, so even the order must agree). It returns
+// the full scan's rows, held in memory, and a *PruningMismatch if the two
+// differ. Other statements are planned once, unchecked, so their side
+// effects (COPY, CREATE TABLE) don't happen twice.
+func VerifyPruning(sql string, opts Options) (types.Operator, *PruningMismatch, error) {
+	if !selectPattern.MatchString(sql) {
+		op, err := ParseAndPlanWithOptions(sql, opts)
+		return op, nil, err
+	}
+
+	fullOpts := opts
+	fullOpts.DisablePruning = true
+	full, err := ParseAndPlanWithOptions(sql, fullOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer full.Close()
+	pruned, err := ParseAndPlanWithOptions(sql, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pruned.Close()
+
+	var rows []*types.Row
+	var mismatch *PruningMismatch
+	var fullDone, prunedDone bool
+	for position := 1; ; position++ {
+		var fullRow, prunedRow *types.Row
+		if !fullDone {
+			if fullRow, err = full.Next(); err != nil {
+				return nil, nil, err
+			}
+			fullDone = fullRow == nil
+		}
+		if !prunedDone {
+			if prunedRow, err = pruned.Next(); err != nil {
+				return nil, nil, err
+			}
+			prunedDone = prunedRow == nil
+		}
+		if fullDone && prunedDone {
+			break
+		}
+		if fullRow != nil {
+			rows = append(rows, fullRow)
+		}
+		if mismatch == nil {
+			fullText, prunedText := formatRow(fullRow), formatRow(prunedRow)
+			if fullText != prunedText {
+				mismatch = &PruningMismatch{Row: position, Pruned: prunedText, Full: fullText}
+			}
+		}
+	}
+	return operators.NewValuesOp(full.Schema(), rows), mismatch, nil
+}
+
+// formatRow renders a row for comparison and messages ("" for no row)
+func formatRow(row *types.Row) string {
+	if row == nil {
+		return ""
+	}
+	values := make([]string, len(row.Values))
+	for i, v := range row.Values {
+		if v == nil {
+			values[i] = "NULL"
+		} else {
+			values[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
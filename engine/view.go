@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/aryamaansaha/golap/catalog"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// maxViewDepth bounds view-inside-view expansion (and catches cycles)
+const maxViewDepth = 16
+
+// errRecursiveView is returned when view expansion exceeds maxViewDepth
+var errRecursiveView = errors.New("view nesting too deep (recursive view?)")
+
+// Like COPY, view DDL is recognized before handing SQL to sqlparser
+var (
+	createViewPattern = regexp.MustCompile(`(?is)^\s*CREATE\s+(OR\s+REPLACE\s+)?VIEW\s+(\S+)\s+AS\s+(SELECT\s.+)$`)
+	dropViewPattern   = regexp.MustCompile(`(?is)^\s*DROP\s+VIEW\s+(IF\s+EXISTS\s+)?(\S+)\s*$`)
+)
+
+// viewStatement is a parsed CREATE VIEW or DROP VIEW statement
+type viewStatement struct {
+	name     string
+	query    string // Defining SELECT (CREATE only)
+	drop     bool
+	replace  bool // CREATE OR REPLACE
+	ifExists bool // DROP VIEW IF EXISTS
+}
+
+// parseViewStatement recognizes CREATE [OR REPLACE] VIEW name AS SELECT ...
+// and DROP VIEW [IF EXISTS] name
+func parseViewStatement(sql string) (*viewStatement, bool) {
+	if m := createViewPattern.FindStringSubmatch(sql); m != nil {
+		return &viewStatement{
+			name:    unquoteIdentifier(m[2]),
+			query:   m[3],
+			replace: m[1] != "",
+		}, true
+	}
+	if m := dropViewPattern.FindStringSubmatch(sql); m != nil {
+		return &viewStatement{
+			name:     unquoteIdentifier(m[2]),
+			drop:     true,
+			ifExists: m[1] != "",
+		}, true
+	}
+	return nil, false
+}
+
+// executeViewStatement applies view DDL to the catalog and returns a status row
+func (p *planner) executeViewStatement(stmt *viewStatement) (types.Operator, error) {
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt.drop {
+		if err := cat.DropView(stmt.name, stmt.ifExists); err != nil {
+			return nil, err
+		}
+		if err := cat.Save(); err != nil {
+			return nil, err
+		}
+		return operators.NewStatusOp(fmt.Sprintf("view dropped: %s", stmt.name)), nil
+	}
+
+	previous, hadPrevious := cat.View(stmt.name)
+	if err := cat.CreateView(stmt.name, stmt.query, stmt.replace); err != nil {
+		return nil, err
+	}
+	if err := cat.Save(); err != nil {
+		return nil, err
+	}
+
+	// Validate by planning through the new definition, so a replacement
+	// that introduces a cycle is caught; roll back on failure
+	op, err := p.planQuery("SELECT * FROM `"+stmt.name+"`", 0)
+	if err != nil {
+		if hadPrevious {
+			cat.CreateView(previous.Name, previous.Query, true)
+		} else {
+			cat.DropView(stmt.name, true)
+		}
+		if saveErr := cat.Save(); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, fmt.Errorf("invalid view definition: %w", err)
+	}
+	op.Close()
+
+	return operators.NewStatusOp(fmt.Sprintf("view created: %s", stmt.name)), nil
+}
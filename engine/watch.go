@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// Sources plans a SELECT without running it and returns the data paths
+// its FROM clauses read once catalog tables and views are resolved: files,
+// globs, directories or URLs. Database tables aren't included.
+func Sources(sql string, opts Options) ([]string, error) {
+	if !selectPattern.MatchString(sql) {
+		return nil, fmt.Errorf("only SELECT queries can be watched")
+	}
+	p := &planner{
+		opts:      opts,
+		tempQuota: operators.NewTempSpaceQuota(opts.TempSpaceQuota),
+	}
+	op, err := p.planQuery(sql, 0)
+	if err != nil {
+		return nil, err
+	}
+	op.Close()
+	slices.Sort(p.sources)
+	return slices.Compact(p.sources), nil
+}
+
+// SourcesState describes the files paths name as they are now: each one
+// with its size and modification time, so the state changes when a file
+// is written, appears in a glob or directory, or goes away
+func SourcesState(paths []string) string {
+	var b strings.Builder
+	for _, path := range paths {
+		files, err := expandDataPath(path)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %v\n", path, err)
+			continue
+		}
+		for _, file := range files {
+			info, err := storage.Stat(file)
+			if err != nil {
+				fmt.Fprintf(&b, "%s: %v\n", file, err)
+				continue
+			}
+			fmt.Fprintf(&b, "%s %d %d\n", file, info.Size, info.ModTime.UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// LocalDirs returns the local directories whose changes can change what
+// paths name: each file's directory (so a file replaced by a rename is
+// seen too), each directory read and its partition subdirectories, and
+// the fixed leading directory of each glob, where new matches appear.
+// Remote paths are left out.
+func LocalDirs(paths []string) []string {
+	var dirs []string
+	for _, path := range paths {
+		if storage.IsRemote(path) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			filepath.WalkDir(path, func(dir string, entry fs.DirEntry, err error) error {
+				switch {
+				case err != nil || !entry.IsDir():
+					return nil
+				case dir != path && !isPartitionDir(entry.Name()):
+					return filepath.SkipDir
+				}
+				dirs = append(dirs, filepath.Clean(dir))
+				return nil
+			})
+		} else if i := strings.IndexAny(path, "*?["); i >= 0 && err != nil {
+			dirs = append(dirs, filepath.Dir(path[:i]+"x"))
+		} else {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		files, err := expandDataPath(path)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			dirs = append(dirs, filepath.Dir(file))
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
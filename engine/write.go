@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// sqlparser doesn't understand COPY or CREATE TABLE ... AS, so these are
+// recognized up front and the embedded SELECT is planned on its own
+var (
+	copyPattern = regexp.MustCompile(`(?is)^\s*COPY\s*\((.+)\)\s*TO\s+(.+?)\s*$`)
+	ctasPattern = regexp.MustCompile(`(?is)^\s*CREATE\s+TABLE\s+(.+?)\s+AS\s+(SELECT\s.+)$`)
+)
+
+// writeStatement is a parsed COPY or CREATE TABLE AS statement
+type writeStatement struct {
+	query      string // Embedded SELECT
+	targetPath string // Output file; its extension picks the format
+	overwrite  bool   // COPY overwrites; CREATE TABLE refuses existing files
+}
+
+// parseWriteStatement recognizes COPY (SELECT ...) TO 'file' and
+// CREATE TABLE file AS SELECT ...
+func parseWriteStatement(sql string) (*writeStatement, bool) {
+	if m := copyPattern.FindStringSubmatch(sql); m != nil {
+		return &writeStatement{
+			query:      m[1],
+			targetPath: unquoteIdentifier(m[2]),
+			overwrite:  true,
+		}, true
+	}
+	if m := ctasPattern.FindStringSubmatch(sql); m != nil {
+		return &writeStatement{
+			query:      m[2],
+			targetPath: unquoteIdentifier(m[1]),
+			overwrite:  false,
+		}, true
+	}
+	return nil, false
+}
+
+// planWriteStatement plans the embedded SELECT and wraps it in the writer
+// for the target's format (CSV, .golap, Parquet or JSON Lines)
+func (p *planner) planWriteStatement(stmt *writeStatement, viewDepth int) (types.Operator, error) {
+	if stmt.targetPath == "" {
+		return nil, fmt.Errorf("output file path required")
+	}
+	if err := p.opts.checkPath(stmt.targetPath); err != nil {
+		return nil, err
+	}
+	if !stmt.overwrite {
+		if _, err := os.Stat(stmt.targetPath); err == nil {
+			return nil, fmt.Errorf("table already exists: %s", stmt.targetPath)
+		}
+	}
+
+	op, err := p.planQuery(stmt.query, viewDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	return operators.NewFileWriteOp(op, stmt.targetPath), nil
+}
+
+// unquoteIdentifier strips one layer of ', ", or ` quoting
+func unquoteIdentifier(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 {
+		first, last := name[0], name[len(name)-1]
+		if first == last && (first == '\'' || first == '"' || first == '`') {
+			return name[1 : len(name)-1]
+		}
+	}
+	return name
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/aryamaansaha/golap/catalog"
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ZoneIndexUpdate summarizes what UpdateZoneIndex did
+type ZoneIndexUpdate struct {
+	Path    string // Where the index was written
+	Files   int    // Files in the table
+	Scanned int    // Files new or changed since the last update
+	Removed int    // Entries dropped for files no longer in the table
+}
+
+// UpdateZoneIndex builds or refreshes the zone index of a multi-file
+// catalog table. Only files added or changed since the last update are
+// scanned; entries for files that are gone are dropped. Zone maps are
+// built from the table's scan, so they use its delimiter, encoding and
+// reconciled column types, and cover every Int column.
+func UpdateZoneIndex(table string, opts Options) (*ZoneIndexUpdate, error) {
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cat.Table(table); !ok {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+
+	p := &planner{opts: opts, tempQuota: operators.NewTempSpaceQuota(opts.TempSpaceQuota)}
+	source, _, err := p.openSource(table, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	scan, ok := source.(*operators.MultiFileScan)
+	if !ok {
+		return nil, fmt.Errorf("table %s is not a multi-file table without a primary key; use golap zonemap for a single file", table)
+	}
+
+	update := &ZoneIndexUpdate{Path: metadata.ZoneIndexPath(catalog.Path(), table)}
+	idx, err := metadata.LoadZoneIndex(update.Path)
+	if err != nil {
+		return nil, err
+	}
+	idx.Table = table
+
+	current := make(map[string]bool)
+	for _, path := range scan.Paths() {
+		current[path] = true
+		info, err := storage.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if entry, ok := idx.Files[path]; ok && entry.Fresh(info) {
+			continue
+		}
+		zm, err := scanZoneMap(scan, path)
+		if err != nil {
+			return nil, err
+		}
+		idx.Files[path] = metadata.NewZoneIndexEntry(zm, info)
+		update.Scanned++
+	}
+	for path := range idx.Files {
+		if !current[path] {
+			delete(idx.Files, path)
+			update.Removed++
+		}
+	}
+	update.Files = len(current)
+
+	if err := metadata.SaveZoneIndex(idx, update.Path); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// scanZoneMap reads one file of a multi-file scan and records the min and
+// max of each Int column. NULLs are left out: a comparison with NULL is
+// never true, so they can't make a pruned file match.
+func scanZoneMap(scan *operators.MultiFileScan, path string) (*metadata.ZoneMap, error) {
+	file, err := scan.OpenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer file.Close()
+
+	zm := &metadata.ZoneMap{
+		Filename:  path,
+		MinValues: make(map[string]int64),
+		MaxValues: make(map[string]int64),
+	}
+	schema := file.Schema()
+	for {
+		row, err := file.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if row == nil {
+			return zm, nil
+		}
+		zm.RowCount++
+		for i, v := range row.Values {
+			n, ok := v.(int64)
+			if !ok || schema.Types[i] != types.Int {
+				continue
+			}
+			col := schema.Columns[i]
+			if min, seen := zm.MinValues[col]; !seen || n < min {
+				zm.MinValues[col] = n
+			}
+			if max, seen := zm.MaxValues[col]; !seen || n > max {
+				zm.MaxValues[col] = n
+			}
+		}
+	}
+}
+
+// pruneWithZoneIndex drops the files of a catalog table's multi-file scan
+// whose zone index entry proves no row matches the WHERE clause. Files
+// without a fresh entry (new or changed since the last golap index) are
+// always scanned.
+func (p *planner) pruneWithZoneIndex(scan *operators.MultiFileScan, table string, where sqlparser.Expr) {
+	cat, err := catalog.Load(catalog.Path())
+	if err != nil {
+		return
+	}
+	if _, ok := cat.Table(table); !ok {
+		return
+	}
+	idx, err := metadata.LoadZoneIndex(metadata.ZoneIndexPath(catalog.Path(), table))
+	if err != nil || len(idx.Files) == 0 {
+		return // The index is an optimization; a broken one is ignored
+	}
+	expr := buildPruningExpr(where)
+	scan.PruneFiles(func(path string) bool {
+		zm, ok := idx.Lookup(path)
+		return ok && zm.CanPrunePredicateTree(expr)
+	})
+}
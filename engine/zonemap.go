@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aryamaansaha/golap/metadata"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/xwb1989/sqlparser"
+)
+
+// StaleZoneMapPolicy is what planning does with a zone map sidecar that no
+// longer describes its file (see metadata.ZoneMap.Fresh). A stale map is
+// never used: its min/max and counts could prune rows that now match. A
+// map of a file that has only had rows appended isn't stale, whatever the
+// policy: planning extends it over the new rows and saves it (see
+// metadata.ExtendZoneMap).
+type StaleZoneMapPolicy int
+
+const (
+	StaleZoneMapWarn       StaleZoneMapPolicy = iota // Ignore it and warn (through Options.Logger)
+	StaleZoneMapIgnore                               // Ignore it silently
+	StaleZoneMapRegenerate                           // Scan the file for a new one, save it and use it
+)
+
+func (s StaleZoneMapPolicy) String() string {
+	switch s {
+	case StaleZoneMapIgnore:
+		return "ignore"
+	case StaleZoneMapRegenerate:
+		return "regenerate"
+	default:
+		return "warn"
+	}
+}
+
+// ParseStaleZoneMapPolicy converts a policy name (warn, ignore or
+// regenerate; any case) to a StaleZoneMapPolicy; "" means warn
+func ParseStaleZoneMapPolicy(name string) (StaleZoneMapPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "warn":
+		return StaleZoneMapWarn, nil
+	case "ignore":
+		return StaleZoneMapIgnore, nil
+	case "regenerate":
+		return StaleZoneMapRegenerate, nil
+	default:
+		return 0, fmt.Errorf("unknown stale zone map policy %q (use warn, ignore or regenerate)", name)
+	}
+}
+
+// loadZoneMap returns the zone map of a CSV file if it has one that still
+// describes it, applying the stale zone map policy otherwise. Each file is
+// checked once per query.
+func (p *planner) loadZoneMap(csvPath string) (*metadata.ZoneMap, bool) {
+	if csvPath == "" {
+		return nil, false
+	}
+	if zm, ok := p.zoneMaps[csvPath]; ok {
+		return zm, zm != nil
+	}
+	zm := p.freshZoneMap(csvPath)
+	if p.zoneMaps == nil {
+		p.zoneMaps = make(map[string]*metadata.ZoneMap)
+	}
+	p.zoneMaps[csvPath] = zm
+	return zm, zm != nil
+}
+
+// freshZoneMap is loadZoneMap, uncached
+func (p *planner) freshZoneMap(csvPath string) *metadata.ZoneMap {
+	zm, err := metadata.LoadZoneMap(csvPath)
+	if err != nil {
+		return nil // No zone map (or an unreadable one): nothing to prune with
+	}
+	if path.Base(filepath.ToSlash(zm.Filename)) != path.Base(filepath.ToSlash(csvPath)) {
+		return nil // The sidecar of a namesake (data.csv's, beside data.golap)
+	}
+	fresh, err := zm.Fresh(csvPath)
+	if err != nil {
+		p.warn("cannot check zone map, not using it", "file", csvPath, "error", err)
+		return nil
+	}
+	if fresh {
+		return zm
+	}
+	if extended, err := metadata.ExtendZoneMap(zm, csvPath); err != nil {
+		p.warn("cannot extend zone map over appended rows", "file", csvPath, "error", err)
+	} else if extended != nil {
+		if err := metadata.SaveZoneMap(extended); err != nil {
+			p.warn("extended zone map over appended rows but cannot save it", "file", csvPath, "error", err)
+		}
+		return extended
+	}
+
+	switch p.opts.StaleZoneMaps {
+	case StaleZoneMapIgnore:
+		return nil
+	case StaleZoneMapRegenerate:
+		zm, err := metadata.GenerateZoneMap(csvPath)
+		if err != nil {
+			p.warn("cannot regenerate stale zone map", "file", csvPath, "error", err)
+			return nil
+		}
+		if err := metadata.SaveZoneMap(zm); err != nil {
+			p.warn("regenerated stale zone map but cannot save it", "file", csvPath, "error", err)
+		}
+		return zm
+	default:
+		reason := "the file changed since it was made"
+		if zm.ContentHash == "" {
+			reason = "made by an older golap, which didn't record the file's version"
+		}
+		p.warn("zone map is stale, not using it; run golap zonemap on the file",
+			"zone_map", metadata.ZoneMapPath(csvPath), "file", csvPath, "reason", reason)
+		return nil
+	}
+}
+
+// warn logs a problem planning works around, with slog's key-value args
+func (p *planner) warn(msg string, args ...any) {
+	if p.opts.Logger != nil {
+		p.opts.Logger.Warn(msg, args...)
+	}
+}
+
+// trace logs a decision about what a query reads, at Debug level
+func (p *planner) trace(msg string, args ...any) {
+	if p.opts.Logger != nil {
+		p.opts.Logger.Debug(msg, args...)
+	}
+}
+
+// ZoneMapsUpdate summarizes what GenerateZoneMaps did
+type ZoneMapsUpdate struct {
+	Files    int    // CSV files mapped
+	Skipped  int    // Other data files, which zone maps don't cover
+	Rows     int64  // Rows in the mapped files
+	Manifest string // Where the manifest was written
+}
+
+// GenerateZoneMaps writes a zone map sidecar for every CSV file a
+// directory or glob names, workers files at a time (extending those of
+// files that have only had rows appended, see metadata.UpdateZoneMap), and records them in
+// the manifest of the directory the path is rooted at. Entries the
+// manifest has for other files that still exist are kept, so globs over
+// the same directory add up. Other data files (JSON Lines, Arrow, .gz) are
+// skipped.
+func GenerateZoneMaps(path string, workers int) (*ZoneMapsUpdate, error) {
+	files, err := expandDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	root := zoneMapsRoot(path)
+	update := &ZoneMapsUpdate{Manifest: metadata.ZoneMapManifestPath(root)}
+	var csvFiles []string
+	for _, file := range files {
+		if strings.EqualFold(filepath.Ext(file), ".csv") {
+			csvFiles = append(csvFiles, file)
+		} else {
+			update.Skipped++
+		}
+	}
+	if len(csvFiles) == 0 {
+		return nil, fmt.Errorf("no CSV files in %s", path)
+	}
+
+	zoneMaps := make([]*metadata.ZoneMap, len(csvFiles))
+	errs := make([]error, len(csvFiles))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(min(workers, len(csvFiles)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				zoneMaps[i], _, errs[i] = metadata.UpdateZoneMap(csvFiles[i])
+				if errs[i] == nil {
+					errs[i] = metadata.SaveZoneMap(zoneMaps[i])
+				}
+			}
+		}()
+	}
+	for i := range csvFiles {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", csvFiles[i], err)
+		}
+	}
+
+	manifest, err := metadata.LoadZoneMapManifest(update.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	for name := range manifest.Files {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			delete(manifest.Files, name)
+		}
+	}
+	for i, zm := range zoneMaps {
+		name, err := filepath.Rel(root, csvFiles[i])
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files[filepath.ToSlash(name)] = metadata.NewZoneIndexEntry(zm, storage.Info{Size: zm.FileSize, ModTime: time.Unix(0, zm.ModTime)})
+		update.Files++
+		update.Rows += zm.RowCount
+	}
+	if err := metadata.SaveZoneMapManifest(manifest, update.Manifest); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// zoneMapsRoot returns the directory a directory or glob's manifest is
+// kept in: the directory itself, or the glob's deepest directory without
+// wildcards
+func zoneMapsRoot(path string) string {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path
+	}
+	dir := filepath.Dir(path)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// fileZoneMap returns the zone map of one file of a multi-file scan: its
+// entry in the manifest of a directory above it if that still describes
+// the file, else its sidecar (see loadZoneMap)
+func (p *planner) fileZoneMap(file string) (*metadata.ZoneMap, bool) {
+	if !storage.IsRemote(file) {
+		if abs, err := filepath.Abs(file); err == nil {
+			for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+				manifest := p.loadManifest(dir)
+				name, err := filepath.Rel(dir, abs)
+				if entry, ok := manifest.Files[filepath.ToSlash(name)]; ok && err == nil {
+					if info, err := storage.Stat(file); err == nil && entry.Fresh(info) {
+						return entry.ZoneMap(file), true
+					}
+					break // Changed since: the sidecar decides
+				}
+				if filepath.Dir(dir) == dir {
+					break
+				}
+			}
+		}
+	}
+	return p.loadZoneMap(file)
+}
+
+// loadManifest returns a directory's zone map manifest, empty if it has
+// none or an unreadable one, reading each directory's once per query
+func (p *planner) loadManifest(dir string) *metadata.ZoneMapManifest {
+	if manifest, ok := p.manifests[dir]; ok {
+		return manifest
+	}
+	manifest, err := metadata.LoadZoneMapManifest(metadata.ZoneMapManifestPath(dir))
+	if err != nil {
+		p.warn("cannot read zone map manifest, not using it", "dir", dir, "error", err)
+		manifest = &metadata.ZoneMapManifest{}
+	}
+	if p.manifests == nil {
+		p.manifests = make(map[string]*metadata.ZoneMapManifest)
+	}
+	p.manifests[dir] = manifest
+	return manifest
+}
+
+// pruneWithZoneMaps drops the files of a multi-file scan whose zone map
+// proves no row matches the WHERE clause
+func (p *planner) pruneWithZoneMaps(scan *operators.MultiFileScan, where sqlparser.Expr) {
+	expr := buildPruningExpr(where)
+	scan.PruneFiles(func(path string) bool {
+		zm, ok := p.fileZoneMap(path)
+		return ok && zm.CanPrunePredicateTree(expr)
+	})
+}
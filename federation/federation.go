@@ -0,0 +1,104 @@
+// Package federation streams rows from tables in live databases, so they
+// can be queried alongside files with FROM postgres('dsn', 'schema.table')
+// or mysql('dsn', 'db.table'). The connections go through pgx and
+// go-sql-driver/mysql; only reading a whole table is supported.
+package federation
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Column is a column of a remote table, with the golap type its values
+// are converted to and the database's own type name
+type Column struct {
+	Name   string
+	Type   types.DataType
+	DBType string
+}
+
+// Rows streams the rows of a remote query. Values are int64, float64,
+// string or nil.
+type Rows interface {
+	Columns() []Column
+	Next() ([]interface{}, error) // (nil, nil) after the last row
+	Close() error
+}
+
+// Connector reads tables from one kind of database
+type Connector interface {
+	// Open starts reading every row of table (name or schema.name)
+	Open(dsn, table string) (Rows, error)
+	// Redact returns the DSN without its password, for plans and errors
+	Redact(dsn string) string
+}
+
+var (
+	connectorsMu sync.RWMutex
+	connectors   = map[string]Connector{
+		"postgres": postgresConnector{},
+		"mysql":    mysqlConnector{},
+	}
+)
+
+// Register adds or replaces the connector for a table function name
+func Register(name string, connector Connector) {
+	connectorsMu.Lock()
+	defer connectorsMu.Unlock()
+	connectors[strings.ToLower(name)] = connector
+}
+
+// Lookup returns the connector for a table function name
+func Lookup(name string) (Connector, error) {
+	connectorsMu.RLock()
+	defer connectorsMu.RUnlock()
+	connector, ok := connectors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown database connector: %s", name)
+	}
+	return connector, nil
+}
+
+// Names returns the registered connector names, sorted
+func Names() []string {
+	connectorsMu.RLock()
+	defer connectorsMu.RUnlock()
+	names := make([]string, 0, len(connectors))
+	for name := range connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// quoteTable quotes each part of a table or schema.table name with the
+// database's identifier quoting
+func quoteTable(table string, quote func(string) string) (string, error) {
+	if strings.TrimSpace(table) == "" {
+		return "", fmt.Errorf("no table name given")
+	}
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("table name %q has more than one dot; use schema.table", table)
+	}
+	for i, part := range parts {
+		if part == "" {
+			return "", fmt.Errorf("invalid table name %q", table)
+		}
+		parts[i] = quote(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// redactURLError drops the URL (and so any password) from a parse error
+func redactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package federation
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/types"
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlConnector reads tables through go-sql-driver/mysql (also MariaDB),
+// which handles TLS and the mysql_native_password and caching_sha2_password
+// authentication; a text-protocol SELECT is read row by row as it streams in
+type mysqlConnector struct{}
+
+// myConfig is a parsed MySQL DSN
+type myConfig struct {
+	network  string // tcp or unix
+	address  string // host:port or socket path
+	user     string
+	password string
+	database string
+	tls      string // false, preferred, skip-verify or true
+	timeout  time.Duration
+}
+
+// parseMySQLDSN reads a mysql:// URL or a Go-driver style DSN
+// (user:pass@tcp(host:3306)/db?tls=true). The password defaults to
+// MYSQL_PWD and the user to USER; TLS defaults to preferred: used when
+// the server offers it, without verifying the certificate.
+func parseMySQLDSN(dsn string) (myConfig, error) {
+	cfg := myConfig{
+		network:  "tcp",
+		password: os.Getenv("MYSQL_PWD"),
+		tls:      "preferred",
+		timeout:  10 * time.Second,
+	}
+	var params string
+
+	if strings.HasPrefix(dsn, "mysql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid mysql URL: %w", redactURLError(err))
+		}
+		if u.User != nil {
+			cfg.user = u.User.Username()
+			if password, ok := u.User.Password(); ok {
+				cfg.password = password
+			}
+		}
+		cfg.address = u.Host
+		cfg.database = strings.TrimPrefix(u.Path, "/")
+		params = u.RawQuery
+	} else {
+		// [user[:password]@][net[(addr)]]/dbname[?params]; the password
+		// may contain @, so split at the last one
+		rest := dsn
+		if at := strings.LastIndex(rest, "@"); at >= 0 {
+			userInfo := rest[:at]
+			rest = rest[at+1:]
+			if colon := strings.IndexByte(userInfo, ':'); colon >= 0 {
+				cfg.user, cfg.password = userInfo[:colon], userInfo[colon+1:]
+			} else {
+				cfg.user = userInfo
+			}
+		}
+		slash := strings.LastIndex(rest, "/")
+		if slash < 0 {
+			return cfg, fmt.Errorf("invalid mysql DSN: missing /dbname (user:password@tcp(host:3306)/dbname)")
+		}
+		netAddr := rest[:slash]
+		cfg.database, params, _ = strings.Cut(rest[slash+1:], "?")
+		if netAddr != "" {
+			open := strings.IndexByte(netAddr, '(')
+			if open < 0 || !strings.HasSuffix(netAddr, ")") {
+				cfg.network = netAddr
+			} else {
+				cfg.network, cfg.address = netAddr[:open], netAddr[open+1:len(netAddr)-1]
+			}
+		}
+	}
+
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid mysql DSN parameters: %w", err)
+	}
+	if v := values.Get("tls"); v != "" {
+		switch v {
+		case "false", "preferred", "skip-verify", "true":
+			cfg.tls = v
+		default:
+			return cfg, fmt.Errorf("invalid tls setting: %s (use true, false, skip-verify or preferred)", v)
+		}
+	}
+	if v := values.Get("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid timeout: %s", v)
+		}
+		cfg.timeout = timeout
+	}
+
+	switch cfg.network {
+	case "tcp":
+		if cfg.address == "" {
+			cfg.address = os.Getenv("MYSQL_HOST")
+		}
+		if cfg.address == "" {
+			cfg.address = "localhost"
+		}
+		if _, _, err := net.SplitHostPort(cfg.address); err != nil {
+			port := os.Getenv("MYSQL_TCP_PORT")
+			if port == "" {
+				port = "3306"
+			}
+			cfg.address = net.JoinHostPort(cfg.address, port)
+		}
+	case "unix":
+		if cfg.address == "" {
+			cfg.address = "/tmp/mysql.sock"
+		}
+	default:
+		return cfg, fmt.Errorf("unsupported mysql network: %s", cfg.network)
+	}
+	if cfg.user == "" {
+		cfg.user = os.Getenv("USER")
+	}
+	return cfg, nil
+}
+
+// Redact describes where a DSN connects, without the password
+func (mysqlConnector) Redact(dsn string) string {
+	cfg, err := parseMySQLDSN(dsn)
+	if err != nil {
+		return "mysql://?"
+	}
+	return fmt.Sprintf("mysql://%s@%s/%s", cfg.user, cfg.address, cfg.database)
+}
+
+// Open connects, authenticates and starts SELECT * FROM table
+func (mysqlConnector) Open(dsn, table string) (Rows, error) {
+	cfg, err := parseMySQLDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := quoteTable(table, func(s string) string {
+		return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	driverConfig := mysql.NewConfig()
+	driverConfig.User = cfg.user
+	driverConfig.Passwd = cfg.password
+	driverConfig.Net = cfg.network
+	driverConfig.Addr = cfg.address
+	driverConfig.DBName = cfg.database
+	driverConfig.TLSConfig = cfg.tls
+	driverConfig.Timeout = cfg.timeout
+	driverConfig.Logger = log.New(io.Discard, "", 0) // Errors are returned instead
+	connector, err := mysql.NewConnector(driverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("mysql %s: %w", cfg.address, err)
+	}
+	rows, err := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT * FROM "+quoted, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql table %s: %w", table, err)
+	}
+	r := &myRows{conn: conn, rows: rows}
+	r.describe()
+	return r, nil
+}
+
+// myRows streams the text-protocol rows of a result set
+type myRows struct {
+	conn    driver.Conn
+	rows    driver.Rows
+	columns []Column
+	bit     []bool
+	values  []driver.Value
+	done    bool
+}
+
+// describe reads the columns from the driver's column definitions
+func (r *myRows) describe() {
+	typed, _ := r.rows.(driver.RowsColumnTypeDatabaseTypeName)
+	for i, name := range r.rows.Columns() {
+		column := Column{Name: name, Type: types.String}
+		if typed != nil {
+			column.DBType = strings.ToLower(typed.ColumnTypeDatabaseTypeName(i))
+		}
+		if base, ok := strings.CutPrefix(column.DBType, "unsigned "); ok {
+			column.DBType = base + " unsigned"
+		}
+		switch strings.TrimSuffix(column.DBType, " unsigned") {
+		case "tinyint", "smallint", "mediumint", "int", "bigint", "year", "bit":
+			column.Type = types.Int
+		case "decimal", "float", "double":
+			column.Type = types.Float
+		}
+		r.columns = append(r.columns, column)
+		r.bit = append(r.bit, column.DBType == "bit")
+	}
+	r.values = make([]driver.Value, len(r.columns))
+}
+
+func (r *myRows) Columns() []Column {
+	return r.columns
+}
+
+// Next decodes the next row, or returns (nil, nil) at the end
+func (r *myRows) Next() ([]interface{}, error) {
+	if r.done {
+		return nil, nil
+	}
+	if err := r.rows.Next(r.values); err != nil {
+		r.done = true
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	values := make([]interface{}, len(r.columns))
+	for i, value := range r.values {
+		switch v := value.(type) {
+		case nil:
+			// NULL
+		case int64:
+			values[i] = v
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, fmt.Errorf("column %s: %d is out of range", r.columns[i].Name, v)
+			}
+			values[i] = int64(v)
+		case float32:
+			// Through its shortest decimal form, so 0.1 stays 0.1
+			values[i], _ = strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		case float64:
+			values[i] = v
+		case []byte:
+			decoded, err := r.decode(i, v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = decoded
+		default:
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// decode converts a value the driver leaves as text: BIT values arrive as
+// big-endian bytes and DECIMAL as digits
+func (r *myRows) decode(i int, text []byte) (interface{}, error) {
+	switch {
+	case r.bit[i]:
+		var v int64
+		for _, b := range text {
+			v = v<<8 | int64(b)
+		}
+		return v, nil
+	case r.columns[i].Type == types.Int:
+		v, err := strconv.ParseInt(string(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", r.columns[i].Name, err)
+		}
+		return v, nil
+	case r.columns[i].Type == types.Float:
+		v, err := strconv.ParseFloat(string(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", r.columns[i].Name, err)
+		}
+		return v, nil
+	}
+	return string(text), nil
+}
+
+// Close ends the connection, abandoning any rows not yet read (closing
+// the rows first would read them all). Mid-result the driver can't send
+// COM_QUIT, but the socket is closed all the same.
+func (r *myRows) Close() error {
+	err := r.conn.Close()
+	if !r.done {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/types"
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresConnector reads tables through pgx, which parses the DSN (a
+// postgres:// URL or key=value string, with PG* variables and .pgpass
+// filling in unset parts), negotiates TLS per sslmode and authenticates.
+// The SELECT runs over the simple protocol, so rows arrive as text and are
+// read as they stream in.
+type postgresConnector struct{}
+
+// defaultConnectTimeout bounds connecting when a DSN sets no
+// connect_timeout
+const defaultConnectTimeout = 10 * time.Second
+
+// parsePostgresDSN parses a DSN as libpq would
+func parsePostgresDSN(dsn string) (*pgx.ConnConfig, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err // pgx leaves the password out
+	}
+	if cfg.ConnectTimeout == 0 {
+		cfg.ConnectTimeout = defaultConnectTimeout
+	}
+	cfg.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	return cfg, nil
+}
+
+// postgresAddress returns where a config connects: host:port, or the
+// socket directory
+func postgresAddress(cfg *pgx.ConnConfig) string {
+	if strings.HasPrefix(cfg.Host, "/") {
+		return cfg.Host
+	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+}
+
+// Redact describes where a DSN connects, without the password
+func (postgresConnector) Redact(dsn string) string {
+	cfg, err := parsePostgresDSN(dsn)
+	if err != nil {
+		return "postgres://?"
+	}
+	return fmt.Sprintf("postgres://%s@%s/%s", cfg.User, postgresAddress(cfg), cfg.Database)
+}
+
+// Open connects, authenticates and starts SELECT * FROM table
+func (postgresConnector) Open(dsn, table string) (Rows, error) {
+	cfg, err := parsePostgresDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := quoteTable(table, func(s string) string {
+		return pgx.Identifier{s}.Sanitize()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("postgres %s: %w", postgresAddress(cfg), err)
+	}
+	rows, err := conn.Query(ctx, "SELECT * FROM "+quoted)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("postgres table %s: %w", table, err)
+	}
+	r := &pgRows{conn: conn, rows: rows}
+	r.describe()
+	return r, nil
+}
+
+// pgRows streams the rows of a query
+type pgRows struct {
+	conn    *pgx.Conn
+	rows    pgx.Rows
+	columns []Column
+	oids    []uint32
+}
+
+// Postgres type OIDs with a non-String golap type
+const (
+	pgBool    = 16
+	pgInt8    = 20
+	pgInt2    = 21
+	pgInt4    = 23
+	pgOid     = 26
+	pgFloat4  = 700
+	pgFloat8  = 701
+	pgNumeric = 1700
+)
+
+// pgTypeNames names common type OIDs for DESCRIBE
+var pgTypeNames = map[uint32]string{
+	pgBool: "boolean", pgInt8: "bigint", pgInt2: "smallint", pgInt4: "integer", pgOid: "oid",
+	pgFloat4: "real", pgFloat8: "double precision", pgNumeric: "numeric",
+	17: "bytea", 18: "char", 19: "name", 25: "text", 114: "json", 1042: "character",
+	1043: "character varying", 1082: "date", 1083: "time", 1114: "timestamp",
+	1184: "timestamp with time zone", 1186: "interval", 2950: "uuid", 3802: "jsonb",
+}
+
+// describe reads the columns from the row description
+func (r *pgRows) describe() {
+	for _, field := range r.rows.FieldDescriptions() {
+		column := Column{Name: field.Name, Type: types.String, DBType: pgTypeNames[field.DataTypeOID]}
+		if column.DBType == "" {
+			column.DBType = fmt.Sprintf("oid %d", field.DataTypeOID)
+		}
+		switch field.DataTypeOID {
+		case pgInt2, pgInt4, pgInt8, pgOid:
+			column.Type = types.Int
+		case pgFloat4, pgFloat8, pgNumeric:
+			column.Type = types.Float
+		}
+		r.columns = append(r.columns, column)
+		r.oids = append(r.oids, field.DataTypeOID)
+	}
+}
+
+func (r *pgRows) Columns() []Column {
+	return r.columns
+}
+
+// Next decodes the next row, or returns (nil, nil) once the query completes
+func (r *pgRows) Next() ([]interface{}, error) {
+	if !r.rows.Next() {
+		return nil, r.rows.Err()
+	}
+	raw := r.rows.RawValues()
+	values := make([]interface{}, len(r.columns))
+	for i := range values {
+		if raw[i] == nil {
+			continue // NULL
+		}
+		text := string(raw[i])
+		switch r.columns[i].Type {
+		case types.Int:
+			v, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", r.columns[i].Name, err)
+			}
+			values[i] = v
+		case types.Float:
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", r.columns[i].Name, err)
+			}
+			values[i] = v
+		default:
+			if r.oids[i] == pgBool {
+				text = map[string]string{"t": "true", "f": "false"}[text]
+			}
+			values[i] = text
+		}
+	}
+	return values, nil
+}
+
+// Close ends the connection, abandoning any rows not yet read (closing
+// the rows first would read them all)
+func (r *pgRows) Close() error {
+	return r.conn.Close(context.Background())
+}
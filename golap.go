@@ -0,0 +1,191 @@
+// Package golap embeds the golap query engine in a Go program: SQL over
+// CSV, JSON Lines, Arrow and .golap files, without shelling out to the
+// golap command.
+//
+//	db, err := golap.Open(golap.DefaultOptions())
+//	if err != nil { ... }
+//	if err := db.RegisterTable("sales", "data/sales.csv"); err != nil { ... }
+//	rows, err := db.Query(ctx, "SELECT category, SUM(amount) FROM sales GROUP BY category")
+//	if err != nil { ... }
+//	defer rows.Close()
+//	for rows.Next() {
+//		var category string
+//		var total float64
+//		if err := rows.Scan(&category, &total); err != nil { ... }
+//	}
+//	if err := rows.Err(); err != nil { ... }
+//
+// FROM names resolve as they do for the command: a view or table of the
+// catalog file ($GOLAP_CATALOG, else .golap_catalog.json), then a file
+// path, glob or directory. Tables registered on a DB are added to the
+// catalog's for that DB's queries only, and never saved.
+package golap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aryamaansaha/golap/catalog"
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// Options controls how a DB plans and runs queries; see engine.Options.
+// Query sets the Context of each query; Tables are registered by Open as
+// by Register.
+type Options = engine.Options
+
+// Table declares a table: its file, and optionally column types, parsing
+// options and a primary key; see catalog.Table
+type Table = catalog.Table
+
+// ErrPathNotAllowed is the cause of a query using a path that
+// Options.AllowedRoots and AllowedSchemes don't allow
+var ErrPathNotAllowed = engine.ErrPathNotAllowed
+
+// DefaultOptions returns the options the golap command runs with, before
+// its flags
+func DefaultOptions() Options {
+	return engine.DefaultOptions()
+}
+
+// RegisterScalar makes a Go function callable in SQL by name, in every
+// DB's queries; see engine.RegisterScalar
+//
+//	golap.RegisterScalar("domain_of", func(email string) string {
+//		return email[strings.LastIndex(email, "@")+1:]
+//	})
+func RegisterScalar(name string, fn interface{}) error {
+	return engine.RegisterScalar(name, fn)
+}
+
+// TableFunc produces the rows of a table function; see
+// engine.RegisterTableFunction
+type TableFunc = engine.TableFunc
+
+// TableCall is one use of a table function in a query
+type TableCall = engine.TableCall
+
+// RegisterTableFunction makes a Go row source queryable by name, in every
+// DB's queries, as FROM name or FROM name('arg', option=>'value'); see
+// engine.RegisterTableFunction
+func RegisterTableFunction(name string, fn TableFunc) error {
+	return engine.RegisterTableFunction(name, fn)
+}
+
+// DB runs queries with a fixed set of options and registered tables. It is
+// safe for concurrent use; each query plans and runs on its own.
+type DB struct {
+	opts Options
+
+	mu     sync.RWMutex
+	tables map[string]Table // By lower-case name
+}
+
+// Open returns a DB running queries with opts. It checks the options
+// that would otherwise fail every query.
+func Open(opts Options) (*DB, error) {
+	if opts.Encoding != "" {
+		if _, err := operators.ParseEncoding(opts.Encoding); err != nil {
+			return nil, err
+		}
+	}
+	if opts.TempDir != "" {
+		info, err := os.Stat(opts.TempDir)
+		if err != nil {
+			return nil, fmt.Errorf("temp directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("temp directory %s is not a directory", opts.TempDir)
+		}
+	}
+	db := &DB{opts: opts, tables: make(map[string]Table)}
+	for _, table := range opts.Tables {
+		if err := db.Register(table); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// RegisterTable makes a file, glob or directory queryable by name, as
+// golap attach does, but for this DB only
+func (db *DB) RegisterTable(name, path string) error {
+	return db.Register(Table{Name: name, Path: path})
+}
+
+// Register adds a table declaration to the DB, replacing any registered
+// under the same name (ignoring case). It shadows a catalog table of the
+// same name. A relative path is resolved against the working directory
+// now, so later changes of directory don't move the table.
+func (db *DB) Register(table Table) error {
+	key := strings.ToLower(strings.TrimSpace(table.Name))
+	if key == "" {
+		return fmt.Errorf("table name required")
+	}
+	if table.Path == "" {
+		return fmt.Errorf("table path required")
+	}
+	if len(table.PrimaryKey) > 0 && table.SequenceColumn == "" {
+		return fmt.Errorf("table %s: a sequence column is required with a primary key", table.Name)
+	}
+	if !filepath.IsAbs(table.Path) && !storage.IsRemote(table.Path) {
+		path, err := filepath.Abs(table.Path)
+		if err != nil {
+			return err
+		}
+		table.Path = path
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tables[key] = table
+	return nil
+}
+
+// Unregister removes a table registered on the DB; the catalog's table of
+// the same name, if any, is visible again
+func (db *DB) Unregister(name string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.tables, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// Tables returns the tables registered on the DB, by name
+func (db *DB) Tables() []Table {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	tables := make([]Table, 0, len(db.tables))
+	for _, table := range db.tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// Query plans and starts one SQL statement: a SELECT, or any other
+// statement the golap command accepts (EXPLAIN, DESCRIBE, COPY ...), whose
+// status is then its one row. Canceling ctx stops the query; Rows.Err
+// reports context.Cause. The caller must Close the Rows.
+func (db *DB) Query(ctx context.Context, sql string) (*Rows, error) {
+	statements, err := engine.SplitStatements(sql)
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) != 1 {
+		return nil, fmt.Errorf("expected one statement, got %d", len(statements))
+	}
+	opts := db.opts
+	opts.Context = ctx
+	opts.Tables = db.Tables()
+	op, err := engine.ParseAndPlanWithOptions(statements[0], opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(op), nil
+}
@@ -0,0 +1,251 @@
+// Package sqlcompare checks golap's results against SQLite's, for golap
+// difftest and cmd/sqlite_oracle. SQLite is embedded through
+// mattn/go-sqlite3 and database/sql, in an in-memory database, so no
+// sqlite3 shell is needed (but the build needs cgo). Rows are compared
+// with numbers equal within a relative tolerance of 1e-9.
+package sqlcompare
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/types"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open creates an empty in-memory SQLite database. It is limited to one
+// connection, as each connection to :memory: has a database of its own.
+func Open() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	return db, nil
+}
+
+// Load creates table with schema's columns, typed as golap infers them,
+// and inserts the rows next returns until it returns nil. Values are
+// bound as parameters, so text is converted by the column's affinity as
+// SQLite's own CSV import would.
+func Load(db *sql.DB, table string, schema types.Schema, next func() ([]interface{}, error)) error {
+	columns := make([]string, len(schema.Columns))
+	for i, name := range schema.Columns {
+		sqlType := map[types.DataType]string{types.Int: "INTEGER", types.Float: "REAL"}[schema.Types[i]]
+		if sqlType == "" {
+			sqlType = "TEXT"
+		}
+		columns[i] = QuoteIdent(name) + " " + sqlType
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", QuoteIdent(table), strings.Join(columns, ", "))); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	defer tx.Rollback()
+	params := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", QuoteIdent(table), params))
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	defer insert.Close()
+	for {
+		values, err := next()
+		if err != nil {
+			return err
+		}
+		if values == nil {
+			break
+		}
+		for i, v := range values {
+			if f, ok := v.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+				values[i] = nil // SQLite has no NaN, and reads infinities back as NULL
+			}
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return fmt.Errorf("sqlite: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Query runs a query in SQLite and returns its rows: int64, float64,
+// string or nil values
+func Query(db *sql.DB, query string) ([][]interface{}, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// Golap runs a query through golap and returns its rows
+func Golap(query string, opts engine.Options) ([][]interface{}, error) {
+	op, err := engine.ParseAndPlanWithOptions(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	var rows [][]interface{}
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, slices.Clone(row.Values))
+		types.ReleaseRow(row)
+	}
+}
+
+// Diff compares golap's rows with SQLite's, in order if ordered is set
+// and otherwise sorted, and describes each row that differs
+func Diff(golap, sqlite [][]interface{}, ordered bool) []string {
+	if !ordered {
+		golap, sqlite = sortRows(golap), sortRows(sqlite)
+	}
+	var diffs []string
+	for i := 0; i < len(golap) || i < len(sqlite); i++ {
+		switch {
+		case i >= len(golap):
+			diffs = append(diffs, fmt.Sprintf("row %d: golap has no row, sqlite has %s", i+1, FormatRow(sqlite[i])))
+		case i >= len(sqlite):
+			diffs = append(diffs, fmt.Sprintf("row %d: golap has %s, sqlite has no row", i+1, FormatRow(golap[i])))
+		case !rowsEqual(golap[i], sqlite[i]):
+			diffs = append(diffs, fmt.Sprintf("row %d: golap has %s, sqlite has %s", i+1, FormatRow(golap[i]), FormatRow(sqlite[i])))
+		}
+	}
+	return diffs
+}
+
+// sortRows sorts rows by their values, numbers rounded so rows equal
+// within the tolerance sort alike (-0 as 0: a group holds whichever zero
+// it saw first)
+func sortRows(rows [][]interface{}) [][]interface{} {
+	type keyed struct {
+		key string
+		row []interface{}
+	}
+	sorted := make([]keyed, len(rows))
+	for i, row := range rows {
+		parts := make([]string, len(row))
+		for j, v := range row {
+			if f, ok := number(v); ok {
+				if f == 0 {
+					f = 0
+				}
+				parts[j] = strconv.FormatFloat(f, 'g', 9, 64)
+			} else {
+				parts[j] = fmt.Sprintf("%T%v", v, v)
+			}
+		}
+		sorted[i] = keyed{strings.Join(parts, "\x00"), row}
+	}
+	slices.SortStableFunc(sorted, func(a, b keyed) int { return strings.Compare(a.key, b.key) })
+	result := make([][]interface{}, len(sorted))
+	for i, k := range sorted {
+		result[i] = k.row
+	}
+	return result
+}
+
+func rowsEqual(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == nil || b[i] == nil {
+			if a[i] != nil || b[i] != nil {
+				return false
+			}
+			continue
+		}
+		fa, aNum := number(a[i])
+		fb, bNum := number(b[i])
+		if aNum && bNum {
+			if math.Abs(fa-fb) > 1e-9*math.Max(1, math.Max(math.Abs(fa), math.Abs(fb))) {
+				return false
+			}
+			continue
+		}
+		if aNum != bNum || fmt.Sprint(a[i]) != fmt.Sprint(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// FormatRow prints a row as SQL literals, so NULL, an empty string and
+// the text '1' can be told apart
+func FormatRow(row []interface{}) string {
+	parts := make([]string, len(row))
+	for i, v := range row {
+		parts[i] = literal(v)
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// literal writes a value as a SQL literal
+func literal(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+	}
+}
+
+// QuoteIdent quotes a name as a SQL identifier
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
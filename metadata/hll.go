@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is how many bits of a value's hash pick its register:
+// 2^14 registers (16KB), for a standard error of about 0.8%
+const hllPrecision = 14
+
+// hyperLogLog estimates how many distinct values it has seen, in fixed
+// memory however many there are. Hashing is deterministic, so the same
+// values always give the same estimate.
+type hyperLogLog struct {
+	registers []uint8 // Per register: the most leading zeros seen + 1
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// add counts a value
+func (h *hyperLogLog) add(value string) {
+	x := hashString(value)
+	idx := x >> (64 - hllPrecision)
+	// The sentinel bit caps the rank at 64-hllPrecision+1
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// estimate returns the approximate number of distinct values added
+func (h *hyperLogLog) estimate() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Few values: count the empty registers instead (linear counting)
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// marshal encodes the registers compactly (deflated, then base64), for
+// storing the sketch to add more values to later
+func (h *hyperLogLog) marshal() string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(h.registers)
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// unmarshalHyperLogLog decodes a sketch encoded by marshal
+func unmarshalHyperLogLog(s string) (*hyperLogLog, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad distinct count sketch: %w", err)
+	}
+	h := newHyperLogLog()
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	if _, err := io.ReadFull(r, h.registers); err != nil {
+		return nil, fmt.Errorf("bad distinct count sketch: %w", err)
+	}
+	return h, nil
+}
+
+// hashString is FNV-1a with a final avalanche (MurmurHash3's fmix64), so
+// every bit of the hash depends on every byte of the value
+func hashString(s string) uint64 {
+	x := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		x ^= uint64(s[i])
+		x *= 1099511628211
+	}
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb53fe1a85e63
+	x ^= x >> 33
+	return x
+}
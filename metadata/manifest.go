@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ZoneMapManifestName is the file, in a directory of data files, that
+// summarizes the zone maps of the files in it and its partition
+// directories. Hidden, so the directory's listing skips it.
+const ZoneMapManifestName = ".zonemaps.json"
+
+// ZoneMapManifest summarizes the zone maps of a dataset of many files:
+// each file's row count and min/max with the version of the file it
+// describes, and the totals across them. Planning a query over the
+// dataset reads it instead of a sidecar per file.
+type ZoneMapManifest struct {
+	RowCount  int64                     `json:"row_count"`  // Over all files
+	MinValues map[string]int64          `json:"min_values"` // Over all files mapping the column
+	MaxValues map[string]int64          `json:"max_values"`
+	Files     map[string]ZoneIndexEntry `json:"files"` // Path relative to the manifest's directory -> zone map
+}
+
+// ZoneMapManifestPath returns the path of a directory's manifest
+func ZoneMapManifestPath(dir string) string {
+	return filepath.Join(dir, ZoneMapManifestName)
+}
+
+// LoadZoneMapManifest reads a manifest; a missing one yields an empty one
+func LoadZoneMapManifest(path string) (*ZoneMapManifest, error) {
+	manifest := &ZoneMapManifest{Files: make(map[string]ZoneIndexEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse zone map manifest %s: %w", path, err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]ZoneIndexEntry)
+	}
+	return manifest, nil
+}
+
+// SaveZoneMapManifest recomputes a manifest's totals and writes it,
+// replacing the old one atomically as SaveZoneIndex does
+func SaveZoneMapManifest(manifest *ZoneMapManifest, path string) error {
+	manifest.RowCount = 0
+	manifest.MinValues = make(map[string]int64)
+	manifest.MaxValues = make(map[string]int64)
+	for _, entry := range manifest.Files {
+		manifest.RowCount += entry.RowCount
+		for col, v := range entry.MinValues {
+			observe(manifest.MinValues, manifest.MaxValues, col, v)
+		}
+		for col, v := range entry.MaxValues {
+			observe(manifest.MinValues, manifest.MaxValues, col, v)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal zone map manifest: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write zone map manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"github.com/aryamaansaha/golap/types"
+)
+
+// PredicateExpr is a node in a WHERE predicate tree that a zone map can
+// reason about. Leaves are column comparisons; inner nodes are AND/OR/NOT.
+type PredicateExpr interface {
+	predicateExpr()
+}
+
+// ComparisonPredicate is a leaf comparing a column against a literal
+// Only int64 values can be checked against zone map statistics
+type ComparisonPredicate struct {
+	Column     string
+	Comparator types.Comparator
+	Value      interface{} // int64, float64, or string
+}
+
+// AndPredicate is true when both sides are true
+type AndPredicate struct {
+	Left, Right PredicateExpr
+}
+
+// OrPredicate is true when either side is true
+type OrPredicate struct {
+	Left, Right PredicateExpr
+}
+
+// NotPredicate negates its inner expression
+type NotPredicate struct {
+	Expr PredicateExpr
+}
+
+// UnknownPredicate stands in for any expression the zone map can't reason
+// about (functions, column-to-column comparisons, ...). It never prunes.
+type UnknownPredicate struct{}
+
+func (ComparisonPredicate) predicateExpr() {}
+func (AndPredicate) predicateExpr()        {}
+func (OrPredicate) predicateExpr()         {}
+func (NotPredicate) predicateExpr()        {}
+func (UnknownPredicate) predicateExpr()    {}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// Rollup describes a materialized pre-aggregation of a data file: a .golap
+// file holding the result of a GROUP BY over the whole file, with the
+// GROUP BY columns first and then one column per aggregate. It records
+// the version of the data file it was computed from; once the file
+// changes, the rollup no longer answers queries.
+type Rollup struct {
+	Path          string            `json:"path"`  // The .golap file holding it
+	Query         string            `json:"query"` // As materialized, for reference
+	SourceSize    int64             `json:"source_size"`
+	SourceModTime int64             `json:"source_mod_time"` // Unix nanoseconds
+	GroupBy       []string          `json:"group_by"`        // Data file columns, in the rollup's column order
+	Aggregates    []RollupAggregate `json:"aggregates"`      // In the rollup's column order, after GroupBy
+	ColumnTypes   map[string]string `json:"column_types"`    // Type of each data file column read, as it was scanned
+}
+
+// RollupAggregate is one aggregate column of a rollup
+type RollupAggregate struct {
+	Function string `json:"function"` // COUNT, SUM, MIN, MAX or AVG
+	Column   string `json:"column"`   // Data file column, "*" for COUNT(*)
+}
+
+// RollupsPath returns the path of the sidecar listing a data file's rollups
+func RollupsPath(dataPath string) string {
+	return sidecarPath(dataPath, ".rollups.json")
+}
+
+// LoadRollups returns the rollups registered for a data file, none if it
+// has no sidecar
+func LoadRollups(dataPath string) ([]Rollup, error) {
+	data, err := storage.ReadFile(RollupsPath(dataPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rollups []Rollup
+	if err := json.Unmarshal(data, &rollups); err != nil {
+		return nil, fmt.Errorf("failed to parse rollups: %w", err)
+	}
+	return rollups, nil
+}
+
+// SaveRollup registers a rollup of a data file, replacing any registered
+// with the same path
+func SaveRollup(dataPath string, rollup Rollup) error {
+	rollups, err := LoadRollups(dataPath)
+	if err != nil {
+		return err
+	}
+	kept := []Rollup{rollup}
+	for _, r := range rollups {
+		if r.Path != rollup.Path {
+			kept = append(kept, r)
+		}
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollups: %w", err)
+	}
+	if err := writeSidecar(RollupsPath(dataPath), data); err != nil {
+		return fmt.Errorf("failed to write rollups file: %w", err)
+	}
+	return nil
+}
+
+// Fresh reports whether the data file still has the size and modification
+// time the rollup was computed from
+func (r Rollup) Fresh(info storage.Info) bool {
+	return r.SourceSize == info.Size && r.SourceModTime == info.ModTime.UnixNano()
+}
@@ -0,0 +1,55 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// SchemaFile declares column types for a data file, overriding inference
+// Stored next to the data as a sidecar, e.g. for sales.csv:
+//
+//	sales.schema.json: {"columns": {"id": "INT", "zip": "VARCHAR"}}
+//
+// Columns not listed keep their inferred type.
+type SchemaFile struct {
+	Columns map[string]string `json:"columns"`
+}
+
+// SchemaPath returns the path to the schema JSON file for a data file
+func SchemaPath(dataPath string) string {
+	return sidecarPath(dataPath, ".schema.json")
+}
+
+// LoadSchema loads the declared column types for a data file
+// Returns nil (and no error) if the file has no schema sidecar; a sidecar
+// that can't be parsed is an error rather than silently ignored.
+func LoadSchema(dataPath string) (map[string]types.DataType, error) {
+	path := SchemaPath(dataPath)
+	data, err := storage.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema file: %w", err)
+	}
+
+	var schema SchemaFile
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema file %s: %w", path, err)
+	}
+
+	columnTypes := make(map[string]types.DataType, len(schema.Columns))
+	for col, typeName := range schema.Columns {
+		dt, err := types.ParseDataType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("schema file %s, column %s: %w", path, col, err)
+		}
+		columnTypes[col] = dt
+	}
+	return columnTypes, nil
+}
@@ -0,0 +1,402 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// MaxMostCommon is how many most common values ANALYZE keeps per column
+// (and per column pair)
+const MaxMostCommon = 100
+
+// HistogramBuckets is how many equal-depth buckets ANALYZE divides each
+// numeric column's values into
+const HistogramBuckets = 100
+
+// histogramSample is how many values per numeric column ANALYZE keeps, a
+// uniform sample of them, to draw the histogram from
+const histogramSample = 30000
+
+// maxTrackedValues caps the distinct values counted per column or pair, so
+// ANALYZE memory stays bounded; past it, a column's Distinct is a
+// HyperLogLog estimate and a pair's a lower bound
+const maxTrackedValues = 100000
+
+// TableStats stores value-frequency statistics collected by ANALYZE
+// Used to estimate how selective WHERE clauses are. Column pairs hold joint
+// statistics so correlated columns (e.g. country and city) aren't assumed
+// independent.
+type TableStats struct {
+	Filename string                 `json:"filename"`
+	RowCount int64                  `json:"row_count"`
+	Columns  map[string]ColumnStats `json:"columns"`
+	Pairs    []PairStats            `json:"pairs,omitempty"`
+}
+
+// ColumnStats describes the value distribution of one column
+type ColumnStats struct {
+	Distinct     int64        `json:"distinct"`
+	NullCount    int64        `json:"null_count"`
+	NullFraction float64      `json:"null_fraction"`
+	AvgWidth     float64      `json:"avg_width"` // Mean bytes of the non-NULL values, as text
+	MostCommon   []ValueCount `json:"most_common,omitempty"`
+	// Histogram holds the bounds of equal-depth buckets over a numeric
+	// column's non-NULL values (from a sample of them): about as many
+	// values fall between each pair of neighbouring bounds, so a range
+	// predicate's selectivity is how many buckets it spans
+	Histogram []float64 `json:"histogram,omitempty"`
+}
+
+// ValueCount is a value and how many rows hold it
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PairStats describes the joint distribution of two columns
+type PairStats struct {
+	Columns    [2]string   `json:"columns"`
+	Distinct   int64       `json:"distinct"`
+	MostCommon []PairCount `json:"most_common,omitempty"`
+}
+
+// PairCount is a combination of two values and how many rows hold it
+type PairCount struct {
+	Values [2]string `json:"values"`
+	Count  int64     `json:"count"`
+}
+
+// StatsPath returns the path to the statistics JSON file for a data file
+func StatsPath(dataPath string) string {
+	return sidecarPath(dataPath, ".stats.json")
+}
+
+// SaveStats writes statistics to a JSON sidecar file
+func SaveStats(stats *TableStats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %w", err)
+	}
+	if err := writeSidecar(StatsPath(stats.Filename), data); err != nil {
+		return fmt.Errorf("failed to write statistics file: %w", err)
+	}
+	return nil
+}
+
+// LoadStats loads statistics from a JSON sidecar file
+func LoadStats(dataPath string) (*TableStats, error) {
+	data, err := storage.ReadFile(StatsPath(dataPath))
+	if err != nil {
+		return nil, err // File doesn't exist or can't be read
+	}
+
+	var stats TableStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse statistics: %w", err)
+	}
+	return &stats, nil
+}
+
+// StatsValue renders a value the way statistics store it
+// Whole-number floats render like ints so literals match either column type
+func StatsValue(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// EqualitySelectivity estimates the fraction of rows where column = value
+// Returns ok=false if the column wasn't analyzed
+func (s *TableStats) EqualitySelectivity(column string, value interface{}) (float64, bool) {
+	col, ok := s.Columns[column]
+	if !ok || s.RowCount == 0 {
+		return 0, false
+	}
+	key := StatsValue(value)
+
+	var common int64
+	for _, vc := range col.MostCommon {
+		if vc.Value == key {
+			return float64(vc.Count) / float64(s.RowCount), true
+		}
+		common += vc.Count
+	}
+	return s.remainderSelectivity(common+col.NullCount, col.Distinct-int64(len(col.MostCommon))), true
+}
+
+// RangeSelectivity estimates the fraction of rows where column op value,
+// op being <, <=, > or >=, from the column's histogram, interpolating
+// within the bucket value falls in. NULLs never match.
+// Returns ok=false if the column has no histogram
+func (s *TableStats) RangeSelectivity(column, op string, value float64) (float64, bool) {
+	col, ok := s.Columns[column]
+	if !ok || len(col.Histogram) < 2 || s.RowCount == 0 {
+		return 0, false
+	}
+	bounds := col.Histogram
+	buckets := float64(len(bounds) - 1)
+
+	// Fraction of the non-NULL values below value
+	var below float64
+	switch {
+	case value <= bounds[0]:
+		below = 0
+	case value >= bounds[len(bounds)-1]:
+		below = 1
+	default:
+		b := sort.SearchFloat64s(bounds, value) - 1 // bounds[b] < value <= bounds[b+1]
+		within := 0.0
+		if width := bounds[b+1] - bounds[b]; width > 0 {
+			within = (value - bounds[b]) / width
+		}
+		below = (float64(b) + within) / buckets
+	}
+
+	nonNull := 1 - float64(col.NullCount)/float64(s.RowCount)
+	switch op {
+	case "<", "<=":
+		return below * nonNull, true
+	case ">", ">=":
+		return (1 - below) * nonNull, true
+	default:
+		return 0, false
+	}
+}
+
+// PairSelectivity estimates the fraction of rows where a = valueA and
+// b = valueB, from the pair's joint statistics
+// Returns ok=false if the pair wasn't analyzed together
+func (s *TableStats) PairSelectivity(a string, valueA interface{}, b string, valueB interface{}) (float64, bool) {
+	if s.RowCount == 0 {
+		return 0, false
+	}
+	keyA, keyB := StatsValue(valueA), StatsValue(valueB)
+
+	for _, pair := range s.Pairs {
+		if pair.Columns[0] == b && pair.Columns[1] == a {
+			keyA, keyB = keyB, keyA
+		} else if pair.Columns[0] != a || pair.Columns[1] != b {
+			continue
+		}
+
+		var common int64
+		for _, pc := range pair.MostCommon {
+			if pc.Values[0] == keyA && pc.Values[1] == keyB {
+				return float64(pc.Count) / float64(s.RowCount), true
+			}
+			common += pc.Count
+		}
+		return s.remainderSelectivity(common, pair.Distinct-int64(len(pair.MostCommon))), true
+	}
+	return 0, false
+}
+
+// remainderSelectivity spreads the rows not covered by the most common
+// values evenly over the remaining distinct values
+func (s *TableStats) remainderSelectivity(covered, remainingDistinct int64) float64 {
+	if remainingDistinct <= 0 {
+		return 0 // Every value is in the most-common list, and this isn't one
+	}
+	remaining := s.RowCount - covered
+	if remaining <= 0 {
+		return 0
+	}
+	return float64(remaining) / float64(s.RowCount) / float64(remainingDistinct)
+}
+
+// StatsCollector accumulates statistics over a stream of rows
+type StatsCollector struct {
+	filename string
+	columns  []string
+	pairs    [][2]int // Column indices of each analyzed pair
+	rowCount int64
+	nulls    []int64
+	counts   []map[string]int64 // Per column: value -> rows
+	distinct []*hyperLogLog     // Per column, for when counts is full
+	widths   []int64            // Per column: bytes of its non-NULL values
+	numeric  []bool             // Per column: no non-NULL value so far was text
+	samples  [][]float64        // Per numeric column: reservoir of its values
+	sampled  []int64            // Per numeric column: values offered to the reservoir
+	random   *rand.Rand         // Picks reservoir slots; seeded, so ANALYZE is repeatable
+	pairCnt  []map[[2]string]int64
+}
+
+// NewStatsCollector prepares to collect statistics for the given columns
+// and column pairs (pairs given as indices into columns)
+func NewStatsCollector(filename string, columns []string, pairs [][2]int) *StatsCollector {
+	c := &StatsCollector{
+		filename: filename,
+		columns:  columns,
+		pairs:    pairs,
+		nulls:    make([]int64, len(columns)),
+		counts:   make([]map[string]int64, len(columns)),
+		distinct: make([]*hyperLogLog, len(columns)),
+		widths:   make([]int64, len(columns)),
+		numeric:  make([]bool, len(columns)),
+		samples:  make([][]float64, len(columns)),
+		sampled:  make([]int64, len(columns)),
+		random:   rand.New(rand.NewSource(1)),
+		pairCnt:  make([]map[[2]string]int64, len(pairs)),
+	}
+	for i := range c.counts {
+		c.counts[i] = make(map[string]int64)
+		c.distinct[i] = newHyperLogLog()
+		c.numeric[i] = true
+	}
+	for i := range c.pairCnt {
+		c.pairCnt[i] = make(map[[2]string]int64)
+	}
+	return c
+}
+
+// Add counts one row
+func (c *StatsCollector) Add(values []interface{}) {
+	c.rowCount++
+	keys := make([]string, len(c.columns))
+	for i := range c.columns {
+		if i >= len(values) || values[i] == nil {
+			c.nulls[i]++
+			continue
+		}
+		keys[i] = StatsValue(values[i])
+		c.distinct[i].add(keys[i])
+		c.widths[i] += int64(len(keys[i]))
+		c.sample(i, values[i])
+		counts := c.counts[i]
+		if _, ok := counts[keys[i]]; ok || len(counts) < maxTrackedValues {
+			counts[keys[i]]++
+		}
+	}
+
+	for i, pair := range c.pairs {
+		a, b := pair[0], pair[1]
+		if a >= len(values) || b >= len(values) || values[a] == nil || values[b] == nil {
+			continue // Equality never matches NULL, so NULL pairs don't matter
+		}
+		key := [2]string{keys[a], keys[b]}
+		counts := c.pairCnt[i]
+		if _, ok := counts[key]; ok || len(counts) < maxTrackedValues {
+			counts[key]++
+		}
+	}
+}
+
+// sample offers a value to a column's reservoir (Algorithm R), or stops
+// sampling the column at its first text value
+func (c *StatsCollector) sample(i int, value interface{}) {
+	if !c.numeric[i] {
+		return
+	}
+	var v float64
+	switch n := value.(type) {
+	case int64:
+		v = float64(n)
+	case float64:
+		v = n
+	default:
+		c.numeric[i], c.samples[i] = false, nil
+		return
+	}
+	c.sampled[i]++
+	if len(c.samples[i]) < histogramSample {
+		c.samples[i] = append(c.samples[i], v)
+	} else if j := c.random.Int63n(c.sampled[i]); j < histogramSample {
+		c.samples[i][j] = v
+	}
+}
+
+// histogram returns the bucket bounds of a column's sample, nil if it
+// has fewer than two values
+func (c *StatsCollector) histogram(i int) []float64 {
+	sample := c.samples[i]
+	if len(sample) < 2 {
+		return nil
+	}
+	sort.Float64s(sample)
+	buckets := min(HistogramBuckets, len(sample)-1)
+	bounds := make([]float64, buckets+1)
+	for b := range bounds {
+		bounds[b] = sample[b*(len(sample)-1)/buckets]
+	}
+	return bounds
+}
+
+// Finish returns the collected statistics
+func (c *StatsCollector) Finish() *TableStats {
+	stats := &TableStats{
+		Filename: c.filename,
+		RowCount: c.rowCount,
+		Columns:  make(map[string]ColumnStats, len(c.columns)),
+	}
+
+	for i, name := range c.columns {
+		common := make([]ValueCount, 0, len(c.counts[i]))
+		for value, count := range c.counts[i] {
+			common = append(common, ValueCount{Value: value, Count: count})
+		}
+		sort.Slice(common, func(x, y int) bool {
+			if common[x].Count != common[y].Count {
+				return common[x].Count > common[y].Count
+			}
+			return common[x].Value < common[y].Value
+		})
+		if len(common) > MaxMostCommon {
+			common = common[:MaxMostCommon]
+		}
+		distinct := int64(len(c.counts[i]))
+		if distinct >= maxTrackedValues {
+			distinct = max(distinct, c.distinct[i].estimate())
+		}
+		column := ColumnStats{
+			Distinct:   distinct,
+			NullCount:  c.nulls[i],
+			MostCommon: common,
+			Histogram:  c.histogram(i),
+		}
+		if c.rowCount > 0 {
+			column.NullFraction = float64(c.nulls[i]) / float64(c.rowCount)
+		}
+		if nonNull := c.rowCount - c.nulls[i]; nonNull > 0 {
+			column.AvgWidth = float64(c.widths[i]) / float64(nonNull)
+		}
+		stats.Columns[name] = column
+	}
+
+	for i, pair := range c.pairs {
+		common := make([]PairCount, 0, len(c.pairCnt[i]))
+		for values, count := range c.pairCnt[i] {
+			common = append(common, PairCount{Values: values, Count: count})
+		}
+		sort.Slice(common, func(x, y int) bool {
+			if common[x].Count != common[y].Count {
+				return common[x].Count > common[y].Count
+			}
+			if common[x].Values[0] != common[y].Values[0] {
+				return common[x].Values[0] < common[y].Values[0]
+			}
+			return common[x].Values[1] < common[y].Values[1]
+		})
+		if len(common) > MaxMostCommon {
+			common = common[:MaxMostCommon]
+		}
+		stats.Pairs = append(stats.Pairs, PairStats{
+			Columns:    [2]string{c.columns[pair[0]], c.columns[pair[1]]},
+			Distinct:   int64(len(c.pairCnt[i])),
+			MostCommon: common,
+		})
+	}
+
+	return stats
+}
@@ -0,0 +1,216 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// zoneMapTailBytes is how much of the end of a mapped file ExtendZoneMap
+// compares to tell rows appended to it from a rewrite
+const zoneMapTailBytes = 4096
+
+// fileTail follows a file's bytes as they're read: how many there are,
+// how many lines they end, and the last zoneMapTailBytes of them
+type fileTail struct {
+	size  int64
+	lines int64
+	last  []byte
+}
+
+func (t *fileTail) Write(p []byte) (int, error) {
+	t.size += int64(len(p))
+	t.lines += int64(bytes.Count(p, []byte{'\n'}))
+	if len(p) >= zoneMapTailBytes {
+		t.last = append(t.last[:0], p[len(p)-zoneMapTailBytes:]...)
+	} else {
+		t.last = append(t.last, p...)
+		if extra := len(t.last) - zoneMapTailBytes; extra > 0 {
+			t.last = t.last[:copy(t.last, t.last[extra:])]
+		}
+	}
+	return len(p), nil
+}
+
+// recordVersion records which version of the file a zone map describes,
+// from the hash and tail of all of its bytes
+func (zm *ZoneMap) recordVersion(h hash.Hash, tail *fileTail) error {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to save hash state: %w", err)
+	}
+	tailHash := sha256.Sum256(tail.last)
+	zm.FileSize = tail.size
+	zm.ContentHash = hex.EncodeToString(h.Sum(nil))
+	zm.HashState = base64.StdEncoding.EncodeToString(state)
+	zm.TailHash = hex.EncodeToString(tailHash[:])
+	zm.Lines = tail.lines
+	return nil
+}
+
+// ExtendZoneMap returns the zone map of a CSV file that has only had rows
+// appended since zm was made, reading just those rows: zm's counts,
+// min/max, blocks and content hash carried on over them, as GenerateZoneMap
+// would have made them. It returns nil if the file changed in any other
+// way (or not at all), or if zm is from a version that didn't record what
+// extending needs; GenerateZoneMap maps such a file anew. zm is unchanged.
+//
+// An append is told from a rewrite by the file's header and the last
+// zoneMapTailBytes it had when mapped, which must be as they were, ending
+// a line.
+func ExtendZoneMap(zm *ZoneMap, csvPath string) (*ZoneMap, error) {
+	info, err := storage.Stat(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	if zm.FileSize <= 0 || info.Size <= zm.FileSize || zm.RowCount == 0 ||
+		zm.HashState == "" || zm.TailHash == "" || len(zm.Sketches) == 0 {
+		return nil, nil
+	}
+	file, err := storage.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV: %w", err)
+	}
+	defer file.Close()
+
+	headerReader := csv.NewReader(file)
+	header, err := headerReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if len(header) != len(zm.Sketches) {
+		return nil, nil
+	}
+	for _, colName := range header {
+		if _, ok := zm.Sketches[colName]; !ok {
+			return nil, nil
+		}
+	}
+	firstRow := headerReader.InputOffset()
+
+	start := max(zm.FileSize-zoneMapTailBytes, 0)
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek in CSV: %w", err)
+	}
+	last := make([]byte, zm.FileSize-start)
+	if _, err := io.ReadFull(file, last); err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if tailHash := sha256.Sum256(last); hex.EncodeToString(tailHash[:]) != zm.TailHash || last[len(last)-1] != '\n' {
+		return nil, nil
+	}
+
+	ext, err := zm.clone()
+	if err != nil {
+		return nil, err
+	}
+	ext.Filename = csvPath
+	ext.ModTime = info.ModTime.UnixNano()
+	state, err := base64.StdEncoding.DecodeString(zm.HashState)
+	if err != nil {
+		return nil, fmt.Errorf("bad hash state: %w", err)
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("bad hash state: %w", err)
+	}
+	mapper := &zoneMapper{
+		zm:          ext,
+		header:      header,
+		distinct:    make([]*hyperLogLog, len(header)),
+		isIntColumn: make(map[string]bool, len(ext.MinValues)),
+	}
+	for i, colName := range header {
+		if mapper.distinct[i], err = unmarshalHyperLogLog(zm.Sketches[colName]); err != nil {
+			return nil, err
+		}
+	}
+	for colName := range ext.MinValues {
+		mapper.isIntColumn[colName] = true
+	}
+	if len(ext.Blocks) == 0 {
+		// A file of one block keeps no blocks: that one is the whole file,
+		// starting after the header
+		line := 2
+		for _, colName := range header {
+			line += strings.Count(colName, "\n")
+		}
+		ext.Blocks = []ZoneBlock{{
+			Offset:    firstRow,
+			Line:      line,
+			RowCount:  ext.RowCount,
+			MinValues: maps.Clone(ext.MinValues),
+			MaxValues: maps.Clone(ext.MaxValues),
+		}}
+	}
+	mapper.block = &ext.Blocks[len(ext.Blocks)-1]
+
+	// The reader starts where the mapped bytes end, on line Lines+1
+	tail := &fileTail{size: zm.FileSize, lines: zm.Lines, last: last}
+	reader := csv.NewReader(io.TeeReader(file, io.MultiWriter(h, tail)))
+	reader.FieldsPerRecord = len(header)
+	for {
+		offset := reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV row: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		mapper.add(record, zm.FileSize+offset, int(zm.Lines)+line)
+	}
+
+	mapper.finish()
+	if _, err := io.Copy(io.MultiWriter(h, tail), file); err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if err := ext.recordVersion(h, tail); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// UpdateZoneMap maps a CSV file: by extending its zone map over the rows
+// appended since it was made if that's all that changed (see
+// ExtendZoneMap), else by generating it anew. extended tells which.
+func UpdateZoneMap(csvPath string) (zm *ZoneMap, extended bool, err error) {
+	if old, err := LoadZoneMap(csvPath); err == nil && path.Base(filepath.ToSlash(old.Filename)) == path.Base(filepath.ToSlash(csvPath)) {
+		zm, err := ExtendZoneMap(old, csvPath)
+		if err != nil {
+			return nil, false, err
+		}
+		if zm != nil {
+			return zm, true, nil
+		}
+	}
+	zm, err = GenerateZoneMap(csvPath)
+	return zm, false, err
+}
+
+// clone returns a deep copy of the zone map
+func (zm *ZoneMap) clone() (*ZoneMap, error) {
+	data, err := json.Marshal(zm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy zone map: %w", err)
+	}
+	var c ZoneMap
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to copy zone map: %w", err)
+	}
+	return &c, nil
+}
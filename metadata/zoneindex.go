@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aryamaansaha/golap/storage"
+)
+
+// ZoneIndex holds the zone maps of every file of a multi-file table in one
+// file, so planning a query over thousands of files reads one index
+// instead of a sidecar per file. Each entry records the size and
+// modification time it was built from; an entry for a file that has since
+// changed is stale and never used for pruning.
+type ZoneIndex struct {
+	Table string                    `json:"table"`
+	Files map[string]ZoneIndexEntry `json:"files"` // Data file path -> zone map
+}
+
+// ZoneIndexEntry is one file's zone map and the file version it describes
+type ZoneIndexEntry struct {
+	Size      int64            `json:"size"`
+	ModTime   int64            `json:"mod_time"` // Unix nanoseconds
+	RowCount  int64            `json:"row_count"`
+	MinValues map[string]int64 `json:"min_values"`
+	MaxValues map[string]int64 `json:"max_values"`
+}
+
+// ZoneIndexPath returns where a catalog table's zone index is stored: in
+// a .golap_index directory beside the catalog file
+func ZoneIndexPath(catalogPath, table string) string {
+	return filepath.Join(filepath.Dir(catalogPath), ".golap_index", strings.ToLower(table)+".json")
+}
+
+// LoadZoneIndex reads a zone index; a missing one yields an empty index
+func LoadZoneIndex(path string) (*ZoneIndex, error) {
+	idx := &ZoneIndex{Files: make(map[string]ZoneIndexEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse zone index %s: %w", path, err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]ZoneIndexEntry)
+	}
+	return idx, nil
+}
+
+// SaveZoneIndex writes a zone index, replacing the old one atomically so a
+// query planned concurrently never reads a partial file
+func SaveZoneIndex(idx *ZoneIndex, path string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal zone index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write zone index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// NewZoneIndexEntry records a file's zone map with the version it was built from
+func NewZoneIndexEntry(zm *ZoneMap, info storage.Info) ZoneIndexEntry {
+	return ZoneIndexEntry{
+		Size:      info.Size,
+		ModTime:   info.ModTime.UnixNano(),
+		RowCount:  zm.RowCount,
+		MinValues: zm.MinValues,
+		MaxValues: zm.MaxValues,
+	}
+}
+
+// Fresh reports whether the entry still describes a file with this size
+// and modification time
+func (e ZoneIndexEntry) Fresh(info storage.Info) bool {
+	return e.Size == info.Size && e.ModTime == info.ModTime.UnixNano()
+}
+
+// ZoneMap returns the entry as the zone map of a file
+func (e ZoneIndexEntry) ZoneMap(path string) *ZoneMap {
+	return &ZoneMap{Filename: path, RowCount: e.RowCount, MinValues: e.MinValues, MaxValues: e.MaxValues}
+}
+
+// Lookup returns a file's zone map if the index has a fresh entry for it
+func (idx *ZoneIndex) Lookup(path string) (*ZoneMap, bool) {
+	entry, ok := idx.Files[path]
+	if !ok {
+		return nil, false
+	}
+	info, err := storage.Stat(path)
+	if err != nil || !entry.Fresh(info) {
+		return nil, false
+	}
+	return entry.ZoneMap(path), true
+}
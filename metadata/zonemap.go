@@ -1,44 +1,176 @@
 package metadata
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/aryamaansaha/golap/storage"
 	"github.com/aryamaansaha/golap/types"
 )
 
 // ZoneMap stores min/max statistics for integer columns in a CSV file
 // This enables partition pruning: skipping files that can't contain matching rows
+// Its per-column empty-field and distinct counts let the planner estimate
+// selectivity without ANALYZE, and answer COUNT(*) / COUNT(col) without a
+// scan.
 type ZoneMap struct {
-	Filename  string           `json:"filename"`
+	Filename       string           `json:"filename"`
+	RowCount       int64            `json:"row_count"`
+	MinValues      map[string]int64 `json:"min_values"`                // Column name -> min value
+	MaxValues      map[string]int64 `json:"max_values"`                // Column name -> max value
+	FileSize       int64            `json:"file_size,omitempty"`       // Bytes in the file when mapped (0 = unknown)
+	ModTime        int64            `json:"mod_time,omitempty"`        // File's modification time when mapped, Unix nanoseconds
+	ContentHash    string           `json:"content_hash,omitempty"`    // SHA-256 of the file's bytes when mapped, hex
+	Blocks         []ZoneBlock      `json:"blocks,omitempty"`          // In file order; only for files of more than one block
+	NullCounts     map[string]int64 `json:"null_counts,omitempty"`     // Column name -> empty fields (NULL unless a String column)
+	DistinctCounts map[string]int64 `json:"distinct_counts,omitempty"` // Column name -> distinct non-empty values (HyperLogLog estimate)
+
+	// What ExtendZoneMap needs to map rows appended since without reading
+	// the rows mapped already
+	Lines     int64             `json:"lines,omitempty"`      // Newlines in the file when mapped
+	TailHash  string            `json:"tail_hash,omitempty"`  // SHA-256 of the file's last zoneMapTailBytes when mapped, hex
+	HashState string            `json:"hash_state,omitempty"` // ContentHash's SHA-256 state, to hash appended bytes on
+	Sketches  map[string]string `json:"sketches,omitempty"`   // Column name -> the HyperLogLog behind DistinctCounts
+}
+
+// ZoneMapBlockRows is how many rows each block of a zone map covers
+const ZoneMapBlockRows = 64 * 1024
+
+// ZoneBlock stores the min/max of one block of consecutive rows, so a
+// scan of a file that can't be pruned as a whole can still seek past the
+// blocks that can. A block runs to the next one's offset (the last, to
+// the end of the file).
+type ZoneBlock struct {
+	Offset    int64            `json:"offset"` // Byte offset of the block's first row
+	Line      int              `json:"line"`   // Line number of the block's first row
 	RowCount  int64            `json:"row_count"`
-	MinValues map[string]int64 `json:"min_values"` // Column name -> min value
-	MaxValues map[string]int64 `json:"max_values"` // Column name -> max value
+	MinValues map[string]int64 `json:"min_values"`
+	MaxValues map[string]int64 `json:"max_values"`
+}
+
+// Block returns block i as a zone map of its own, for pruning it
+func (zm *ZoneMap) Block(i int) *ZoneMap {
+	block := zm.Blocks[i]
+	return &ZoneMap{
+		Filename:  zm.Filename,
+		RowCount:  block.RowCount,
+		MinValues: block.MinValues,
+		MaxValues: block.MaxValues,
+	}
+}
+
+// Fresh reports whether the zone map still describes the file at csvPath
+// as it is now: the size and modification time it recorded match, or only the time
+// differs (the file was touched or copied) and its content hash still
+// matches, which reads the whole file. Maps that recorded neither (made by
+// older versions) can't be checked and are never fresh.
+func (zm *ZoneMap) Fresh(csvPath string) (bool, error) {
+	info, err := storage.Stat(csvPath)
+	if err != nil {
+		return false, err
+	}
+	if zm.FileSize <= 0 || zm.ContentHash == "" || zm.FileSize != info.Size {
+		return false, nil
+	}
+	if zm.ModTime == info.ModTime.UnixNano() {
+		return true, nil
+	}
+	file, err := storage.Open(csvPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == zm.ContentHash, nil
+}
+
+// BlocksFit reports whether the blocks' offsets still fall on rows of a
+// file of this size: the file hasn't been rewritten since it was mapped
+func (zm *ZoneMap) BlocksFit(fileSize int64) bool {
+	return len(zm.Blocks) > 0 && zm.FileSize > 0 && zm.FileSize == fileSize
+}
+
+// Stats returns the zone map's counts as statistics without most common
+// values, for estimating selectivity when a file hasn't been analyzed;
+// nil if it has no distinct counts (mapped by an older version)
+func (zm *ZoneMap) Stats() *TableStats {
+	if len(zm.DistinctCounts) == 0 || zm.RowCount == 0 {
+		return nil
+	}
+	stats := &TableStats{
+		Filename: zm.Filename,
+		RowCount: zm.RowCount,
+		Columns:  make(map[string]ColumnStats, len(zm.DistinctCounts)),
+	}
+	for col, distinct := range zm.DistinctCounts {
+		stats.Columns[col] = ColumnStats{
+			Distinct:     distinct,
+			NullCount:    zm.NullCounts[col],
+			NullFraction: float64(zm.NullCounts[col]) / float64(zm.RowCount),
+		}
+	}
+	return stats
 }
 
 // ZoneMapPath returns the path to the zone map JSON file for a CSV
 func ZoneMapPath(csvPath string) string {
-	dir := filepath.Dir(csvPath)
-	base := filepath.Base(csvPath)
+	return sidecarPath(csvPath, ".zonemap.json")
+}
+
+// sidecarPath returns the path of a metadata file stored next to a data
+// file: the data file's name with its extension replaced by suffix. For a
+// remote object (s3://bucket/data.csv) it is an object beside it.
+func sidecarPath(dataPath, suffix string) string {
+	if storage.IsRemote(dataPath) {
+		return strings.TrimSuffix(dataPath, path.Ext(dataPath)) + suffix
+	}
+	dir := filepath.Dir(dataPath)
+	base := filepath.Base(dataPath)
 	ext := filepath.Ext(base)
 	name := base[:len(base)-len(ext)]
-	return filepath.Join(dir, name+".zonemap.json")
+	return filepath.Join(dir, name+suffix)
 }
 
-// GenerateZoneMap scans a CSV file and generates zone map statistics
+// writeSidecar writes a metadata file; only local data files can have
+// sidecars written (remote ones can have them uploaded beside them)
+func writeSidecar(sidecar string, data []byte) error {
+	if storage.IsRemote(sidecar) {
+		return fmt.Errorf("cannot write %s: upload it next to the remote file instead", sidecar)
+	}
+	return os.WriteFile(sidecar, data, 0644)
+}
+
+// GenerateZoneMap scans a CSV file and generates zone map statistics, for
+// the whole file and per block of ZoneMapBlockRows rows
 func GenerateZoneMap(csvPath string) (*ZoneMap, error) {
-	file, err := os.Open(csvPath)
+	info, err := storage.Stat(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV: %w", err)
+	}
+	file, err := storage.Open(csvPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CSV: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	// Hash the bytes as the reader takes them, to tell later whether a
+	// touched file has changed
+	hash := sha256.New()
+	tail := &fileTail{}
+	reader := csv.NewReader(io.TeeReader(file, io.MultiWriter(hash, tail)))
 
 	// Read header
 	header, err := reader.Read()
@@ -46,88 +178,147 @@ func GenerateZoneMap(csvPath string) (*ZoneMap, error) {
 		return nil, fmt.Errorf("failed to read CSV header: %w", err)
 	}
 
-	// Initialize min/max tracking
-	minValues := make(map[string]int64)
-	maxValues := make(map[string]int64)
-	initialized := make(map[string]bool)
-	isIntColumn := make(map[string]bool)
-
-	// First pass: determine which columns are integers
-	firstRow, err := reader.Read()
-	if err == io.EOF {
-		// Empty file
-		return &ZoneMap{
-			Filename:  csvPath,
-			RowCount:  0,
-			MinValues: minValues,
-			MaxValues: maxValues,
-		}, nil
+	zm := &ZoneMap{
+		Filename:       csvPath,
+		MinValues:      make(map[string]int64),
+		MaxValues:      make(map[string]int64),
+		ModTime:        info.ModTime.UnixNano(),
+		NullCounts:     make(map[string]int64, len(header)),
+		DistinctCounts: make(map[string]int64, len(header)),
 	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to read first data row: %w", err)
+	mapper := &zoneMapper{
+		zm:          zm,
+		header:      header,
+		distinct:    make([]*hyperLogLog, len(header)),
+		isIntColumn: make(map[string]bool),
 	}
-
-	// Check which columns are integers based on first row
-	for i, val := range firstRow {
-		if i < len(header) {
-			if v, err := strconv.ParseInt(val, 10, 64); err == nil {
-				isIntColumn[header[i]] = true
-				minValues[header[i]] = v
-				maxValues[header[i]] = v
-				initialized[header[i]] = true
-			}
-		}
+	for i, colName := range header {
+		zm.NullCounts[colName] = 0
+		mapper.distinct[i] = newHyperLogLog()
 	}
 
-	rowCount := int64(1)
-
-	// Continue scanning remaining rows
 	for {
+		offset := reader.InputOffset()
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			if zm.RowCount == 0 {
+				return nil, fmt.Errorf("failed to read first data row: %w", err)
+			}
 			return nil, fmt.Errorf("error reading CSV row: %w", err)
 		}
+		line, _ := reader.FieldPos(0)
+		mapper.add(record, offset, line)
+	}
+
+	mapper.finish()
+	if _, err := io.Copy(io.MultiWriter(hash, tail), file); err != nil { // Whatever the reader left unread
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if err := zm.recordVersion(hash, tail); err != nil {
+		return nil, err
+	}
+	return zm, nil
+}
 
-		rowCount++
+// zoneMapper maps rows into a zone map, for GenerateZoneMap and
+// ExtendZoneMap
+type zoneMapper struct {
+	zm       *ZoneMap
+	header   []string
+	distinct []*hyperLogLog // Per column, behind DistinctCounts
+	// Columns every value of which has been an integer so far; the first
+	// row decides which columns are candidates
+	isIntColumn map[string]bool
+	block       *ZoneBlock // The last block
+}
 
+// add maps a row that starts at byte offset on line
+func (m *zoneMapper) add(record []string, offset int64, line int) {
+	zm := m.zm
+	if zm.RowCount == 0 {
 		for i, val := range record {
-			if i >= len(header) {
-				continue
+			if i < len(m.header) {
+				if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+					m.isIntColumn[m.header[i]] = true
+				}
 			}
-			colName := header[i]
+		}
+	}
+	if zm.RowCount%ZoneMapBlockRows == 0 {
+		zm.Blocks = append(zm.Blocks, ZoneBlock{
+			Offset:    offset,
+			Line:      line,
+			MinValues: make(map[string]int64),
+			MaxValues: make(map[string]int64),
+		})
+		m.block = &zm.Blocks[len(zm.Blocks)-1]
+	}
+	zm.RowCount++
+	m.block.RowCount++
 
-			// Only track columns that were initially identified as integers
-			if !isIntColumn[colName] {
-				continue
-			}
+	for i, val := range record {
+		if i >= len(m.header) {
+			continue
+		}
+		colName := m.header[i]
+		if val == "" {
+			zm.NullCounts[colName]++
+		} else {
+			m.distinct[i].add(val)
+		}
 
-			v, err := strconv.ParseInt(val, 10, 64)
-			if err != nil {
-				// This value isn't an integer; mark column as non-integer
-				delete(isIntColumn, colName)
-				delete(minValues, colName)
-				delete(maxValues, colName)
-				continue
-			}
+		// Only track columns that were initially identified as integers
+		if !m.isIntColumn[colName] {
+			continue
+		}
 
-			if v < minValues[colName] {
-				minValues[colName] = v
-			}
-			if v > maxValues[colName] {
-				maxValues[colName] = v
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			// This value isn't an integer; mark column as non-integer
+			delete(m.isIntColumn, colName)
+			continue
+		}
+		observe(zm.MinValues, zm.MaxValues, colName, v)
+		observe(m.block.MinValues, m.block.MaxValues, colName, v)
+	}
+}
+
+// finish completes the zone map once every row has been added
+func (m *zoneMapper) finish() {
+	zm := m.zm
+	// Drop the columns a later value showed not to be integers, from the
+	// blocks before it too
+	for colName := range zm.MinValues {
+		if !m.isIntColumn[colName] {
+			delete(zm.MinValues, colName)
+			delete(zm.MaxValues, colName)
+			for i := range zm.Blocks {
+				delete(zm.Blocks[i].MinValues, colName)
+				delete(zm.Blocks[i].MaxValues, colName)
 			}
 		}
 	}
+	zm.Sketches = make(map[string]string, len(m.header))
+	for i, colName := range m.header {
+		zm.DistinctCounts[colName] = m.distinct[i].estimate()
+		zm.Sketches[colName] = m.distinct[i].marshal()
+	}
+	if len(zm.Blocks) < 2 {
+		zm.Blocks = nil // The file's own min/max say as much
+	}
+}
 
-	return &ZoneMap{
-		Filename:  csvPath,
-		RowCount:  rowCount,
-		MinValues: minValues,
-		MaxValues: maxValues,
-	}, nil
+// observe widens a column's min/max to include v
+func observe(minValues, maxValues map[string]int64, colName string, v int64) {
+	if lo, ok := minValues[colName]; !ok || v < lo {
+		minValues[colName] = v
+	}
+	if hi, ok := maxValues[colName]; !ok || v > hi {
+		maxValues[colName] = v
+	}
 }
 
 // SaveZoneMap writes the zone map to a JSON sidecar file
@@ -139,7 +330,7 @@ func SaveZoneMap(zm *ZoneMap) error {
 		return fmt.Errorf("failed to marshal zone map: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := writeSidecar(path, data); err != nil {
 		return fmt.Errorf("failed to write zone map file: %w", err)
 	}
 
@@ -150,7 +341,7 @@ func SaveZoneMap(zm *ZoneMap) error {
 func LoadZoneMap(csvPath string) (*ZoneMap, error) {
 	path := ZoneMapPath(csvPath)
 
-	data, err := os.ReadFile(path)
+	data, err := storage.ReadFile(path)
 	if err != nil {
 		return nil, err // File doesn't exist or can't be read
 	}
@@ -204,12 +395,70 @@ func (zm *ZoneMap) CanPrune(columnName string, comp types.Comparator, value int6
 	}
 }
 
+// CanPrunePredicateTree checks if a zone map allows pruning based on a whole
+// predicate tree. Returns true if no row in the file can satisfy expr.
+func (zm *ZoneMap) CanPrunePredicateTree(expr PredicateExpr) bool {
+	return zm.canPruneExpr(expr, false)
+}
+
+// canPruneExpr evaluates expr (or NOT expr when negated is set), pushing
+// negations down to the leaves with De Morgan's laws
+func (zm *ZoneMap) canPruneExpr(expr PredicateExpr, negated bool) bool {
+	switch e := expr.(type) {
+	case ComparisonPredicate:
+		value, ok := e.Value.(int64)
+		if !ok {
+			return false // Zone maps only track integer columns
+		}
+		comp := e.Comparator
+		if negated {
+			comp = comp.Negate()
+		}
+		return zm.CanPrune(e.Column, comp, value)
+
+	case AndPredicate:
+		if negated {
+			// NOT (a AND b) = NOT a OR NOT b: both sides must be prunable
+			return zm.canPruneExpr(e.Left, true) && zm.canPruneExpr(e.Right, true)
+		}
+		// a AND b: either side being unsatisfiable is enough
+		return zm.canPruneExpr(e.Left, false) || zm.canPruneExpr(e.Right, false)
+
+	case OrPredicate:
+		if negated {
+			// NOT (a OR b) = NOT a AND NOT b
+			return zm.canPruneExpr(e.Left, true) || zm.canPruneExpr(e.Right, true)
+		}
+		return zm.canPruneExpr(e.Left, false) && zm.canPruneExpr(e.Right, false)
+
+	case NotPredicate:
+		return zm.canPruneExpr(e.Expr, !negated)
+
+	default:
+		return false
+	}
+}
+
 // PrintSummary prints a human-readable summary of the zone map
 func (zm *ZoneMap) PrintSummary() {
 	fmt.Printf("Zone Map for: %s\n", zm.Filename)
 	fmt.Printf("Row Count: %d\n", zm.RowCount)
+	if len(zm.Blocks) > 0 {
+		fmt.Printf("Blocks: %d of up to %d rows\n", len(zm.Blocks), ZoneMapBlockRows)
+	}
 	fmt.Println("Integer Column Statistics:")
 	for col := range zm.MinValues {
 		fmt.Printf("  %s: [%d, %d]\n", col, zm.MinValues[col], zm.MaxValues[col])
 	}
+	if len(zm.DistinctCounts) > 0 {
+		fmt.Println("Column Counts:")
+		columns := make([]string, 0, len(zm.DistinctCounts))
+		for col := range zm.DistinctCounts {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+		for _, col := range columns {
+			fmt.Printf("  %s: ~%d distinct, %d empty\n", col, zm.DistinctCounts[col], zm.NullCounts[col])
+		}
+	}
 }
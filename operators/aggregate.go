@@ -1,8 +1,13 @@
 package operators
 
 import (
+	"cmp"
 	"fmt"
-	"math"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/aryamaansaha/golap/types"
 )
@@ -10,22 +15,315 @@ import (
 // AggregateExpr represents a single aggregation expression
 type AggregateExpr struct {
 	Type        types.AggregateType
-	ColumnIndex int    // Column to aggregate (-1 for COUNT(*))
-	Alias       string // Output column name
+	ColumnIndex int            // Column to aggregate (-1 for COUNT(*))
+	Expr        ValueExpr      // Optional input expression; overrides ColumnIndex
+	ExprType    types.DataType // Type of Expr's values, for the output type
+	OrderBy     ValueExpr      // LATEST_BY only: the row with the greatest value wins
+	K           int            // APPROX_TOP_K only: number of values to return
+	Collation   Collation      // MIN/MAX only: how text compares (the zero value: byte by byte)
+	Alias       string         // Output column name
+}
+
+// isCountStar reports whether this is COUNT(*), which needs no input value
+func (a AggregateExpr) isCountStar() bool {
+	return a.Type == types.Count && a.ColumnIndex < 0 && a.Expr == nil
+}
+
+// inputValue returns the value this aggregate consumes from a row
+func (a AggregateExpr) inputValue(row *types.Row) (interface{}, bool) {
+	if a.Expr != nil {
+		return a.Expr(row), true
+	}
+	if a.ColumnIndex < 0 || a.ColumnIndex >= len(row.Values) {
+		return nil, false
+	}
+	return row.Values[a.ColumnIndex], true
+}
+
+// inputType returns the type of the values this aggregate consumes: its
+// column's, or ExprType
+func (a AggregateExpr) inputType(input types.Schema) types.DataType {
+	if a.Expr != nil {
+		return a.ExprType
+	}
+	if a.ColumnIndex >= 0 && a.ColumnIndex < len(input.Types) {
+		return input.Types[a.ColumnIndex]
+	}
+	return types.Float
+}
+
+// outputType returns the type of this aggregate's result column
+// COUNT is Int, MIN, MAX and LATEST_BY keep their input's type, SUM is
+// Int over Int and Float otherwise, APPROX_TOP_K is a JSON array
+// (String), AVG is Float
+func (a AggregateExpr) outputType(input types.Schema) types.DataType {
+	switch a.Type {
+	case types.Count:
+		return types.Int
+	case types.ApproxTopK:
+		return types.String
+	case types.Min, types.Max, types.LatestBy:
+		return a.inputType(input)
+	case types.Sum:
+		if a.inputType(input) == types.Int {
+			return types.Int
+		}
+		return types.Float
+	default:
+		return types.Float
+	}
 }
 
 // aggregateState holds the running state for one aggregate computation
 type aggregateState struct {
-	count   int64
-	sum     float64
-	min     float64
-	max     float64
-	hasData bool
+	count    int64 // Every row for COUNT(*), non-NULL values for COUNT(col) and MIN/MAX, numbers for SUM/AVG
+	sum      float64
+	intSum   int64       // SUM of the Int values, exactly
+	overflow bool        // intSum overflowed int64
+	min      interface{} // MIN: least value so far (nil = none)
+	max      interface{} // MAX: greatest value so far (nil = none)
+	hasData  bool
+
+	latest      interface{} // LATEST_BY: value of the winning row so far
+	latestOrder interface{} // LATEST_BY: ordering value of that row
+
+	topK *spaceSaving // APPROX_TOP_K: created on first non-NULL value
+}
+
+// addNumber adds a value to a SUM/AVG state; non-numeric values
+// (including NULL) are skipped
+func addNumber(state *aggregateState, val interface{}) {
+	switch v := val.(type) {
+	case int64:
+		addInt(state, v)
+	case int:
+		addInt(state, int64(v))
+	case float64:
+		addFloat(state, v)
+	}
+}
+
+// addInt adds an integer to a SUM/AVG state
+func addInt(state *aggregateState, v int64) {
+	state.count++
+	state.hasData = true
+	state.sum += float64(v)
+	sum := state.intSum + v
+	if (v > 0 && sum < state.intSum) || (v < 0 && sum > state.intSum) {
+		state.overflow = true
+	}
+	state.intSum = sum
+}
+
+// addFloat adds a float to a SUM/AVG state
+func addFloat(state *aggregateState, v float64) {
+	state.count++
+	state.hasData = true
+	state.sum += v
+}
+
+// beats reports whether a value comparing cmp to the current extreme
+// replaces it: a greater one for MAX, a lesser one for MIN
+func beats(cmp int, max bool) bool {
+	if max {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// extreme returns the field a MIN (or MAX) state keeps its value in
+func (s *aggregateState) extreme(max bool) *interface{} {
+	if max {
+		return &s.max
+	}
+	return &s.min
+}
+
+// updateExtreme adds a non-NULL value to a MIN/MAX state, comparing text
+// by collation; text is copied, as a value read from a batch is a view of
+// its buffer
+func updateExtreme(state *aggregateState, max bool, val interface{}, collation Collation) {
+	state.count++
+	state.hasData = true
+	extreme := state.extreme(max)
+	if *extreme != nil && !beats(compareCollated(val, *extreme, collation), max) {
+		return
+	}
+	if text, ok := val.(string); ok {
+		val = strings.Clone(text)
+	}
+	*extreme = val
+}
+
+// readsColumn reports whether the aggregate is COUNT(*) or a plain
+// COUNT/SUM/MIN/MAX/AVG of a column of a width-column input, so it can
+// be updated straight from a batch's column vector
+func (a AggregateExpr) readsColumn(width int) bool {
+	switch a.Type {
+	case types.Count, types.Sum, types.Min, types.Max, types.Avg:
+		return a.isCountStar() || (a.Expr == nil && a.ColumnIndex >= 0 && a.ColumnIndex < width)
+	default:
+		return false
+	}
+}
+
+// updateColumn updates a state from a batch's rows for an aggregate
+// that readsColumn, as updateState does row by row
+func updateColumn(state *aggregateState, agg AggregateExpr, batch *types.RowBatch) {
+	rows := batch.Rows()
+	if agg.isCountStar() {
+		state.count += int64(rows)
+		if rows > 0 {
+			state.hasData = true
+		}
+		return
+	}
+	column := batch.Columns[agg.ColumnIndex]
+	for k := range rows {
+		updateAt(state, agg, column, batch.Position(k))
+	}
+}
+
+// updateAt adds the value at position i of a column to a state, as
+// updateValue does, reading typed vectors without boxing
+func updateAt(state *aggregateState, agg AggregateExpr, column types.Vector, i int) {
+	switch agg.Type {
+	case types.Count:
+		if !nullAt(column, i) {
+			state.count++
+			state.hasData = true
+		}
+	case types.Min, types.Max:
+		updateExtremeAt(state, agg.Type == types.Max, column, i, agg.Collation)
+	default:
+		switch v := column.(type) {
+		case *types.Int64Vector:
+			if !v.Nulls[i] {
+				addInt(state, v.Values[i])
+			}
+		case *types.Float64Vector:
+			if !v.Nulls[i] {
+				addFloat(state, v.Values[i])
+			}
+		case *types.StringVector:
+		default:
+			addNumber(state, column.Value(i))
+		}
+	}
+}
+
+// updateExtremeAt adds the value at position i of a column to a MIN/MAX
+// state, boxing (or copying) it only when it's the new extreme
+func updateExtremeAt(state *aggregateState, max bool, column types.Vector, i int, collation Collation) {
+	if nullAt(column, i) {
+		return
+	}
+	extreme := state.extreme(max)
+	switch v := column.(type) {
+	case *types.Int64Vector:
+		if current, ok := (*extreme).(int64); ok {
+			state.count++
+			if beats(cmp.Compare(v.Values[i], current), max) {
+				*extreme = v.Values[i]
+			}
+			return
+		}
+	case *types.Float64Vector:
+		if current, ok := (*extreme).(float64); ok {
+			state.count++
+			if beats(cmp.Compare(v.Values[i], current), max) {
+				*extreme = v.Values[i]
+			}
+			return
+		}
+	case *types.StringVector:
+		if current, ok := (*extreme).(string); ok && collation.IsBinary() {
+			state.count++
+			if (max && string(v.Bytes(i)) > current) || (!max && string(v.Bytes(i)) < current) {
+				*extreme = string(v.Bytes(i))
+			}
+			return
+		}
+	}
+	updateExtreme(state, max, column.Value(i), collation)
+}
+
+// nullAt reports whether the value at position i of a column is NULL
+func nullAt(column types.Vector, i int) bool {
+	switch v := column.(type) {
+	case *types.Int64Vector:
+		return v.Nulls[i]
+	case *types.Float64Vector:
+		return v.Nulls[i]
+	case *types.StringVector:
+		return v.Nulls[i]
+	default:
+		return column.Value(i) == nil
+	}
+}
+
+// updateValue adds a row's input value to a COUNT/SUM/MIN/MAX/AVG state:
+// COUNT(col) counts non-NULL values and MIN/MAX compare them, of any
+// type; SUM and AVG add numbers and skip everything else, NULL included
+func updateValue(state *aggregateState, agg AggregateExpr, val interface{}) {
+	switch {
+	case val == nil:
+	case agg.Type == types.Count:
+		state.count++
+		state.hasData = true
+	case agg.Type == types.Min || agg.Type == types.Max:
+		updateExtreme(state, agg.Type == types.Max, val, agg.Collation)
+	default:
+		addNumber(state, val)
+	}
+}
+
+// batchAggregates splits aggregates into those updated from batch
+// columns directly and those needing each row (expressions, LATEST_BY,
+// APPROX_TOP_K)
+func batchAggregates(aggregates []AggregateExpr, width int) (direct []bool, needRow bool) {
+	direct = make([]bool, len(aggregates))
+	for i, agg := range aggregates {
+		direct[i] = agg.readsColumn(width)
+		needRow = needRow || !direct[i]
+	}
+	return direct, needRow
+}
+
+// updateTopK counts a value in the group's sketch; NULLs are skipped
+func updateTopK(state *aggregateState, agg AggregateExpr, row *types.Row) {
+	val, ok := agg.inputValue(row)
+	if !ok || val == nil {
+		return
+	}
+	if state.topK == nil {
+		state.topK = newSpaceSaving(agg.K)
+	}
+	state.topK.add(val)
+}
+
+// updateLatest keeps the value from the row with the greatest ordering
+// Rows with a NULL ordering are ignored; on ties the later row wins, so
+// for append-only data the most recently written event is kept.
+func updateLatest(state *aggregateState, agg AggregateExpr, row *types.Row) {
+	order := agg.OrderBy(row)
+	if order == nil {
+		return
+	}
+	if state.hasData && compareValues(order, state.latestOrder) < 0 {
+		return
+	}
+	val, _ := agg.inputValue(row)
+	state.latest = val
+	state.latestOrder = order
+	state.hasData = true
 }
 
 // ScalarAggregateOp performs scalar aggregation (no GROUP BY)
 // Returns a single row with aggregated values
 type ScalarAggregateOp struct {
+	execStats
+
 	input        types.Operator
 	aggregates   []AggregateExpr
 	outputSchema types.Schema
@@ -44,12 +342,7 @@ func NewScalarAggregateOp(input types.Operator, aggregates []AggregateExpr) *Sca
 		} else {
 			columns[i] = fmt.Sprintf("%s_%d", agg.Type.String(), i)
 		}
-		// COUNT returns Int, others return Float for precision
-		if agg.Type == types.Count {
-			colTypes[i] = types.Int
-		} else {
-			colTypes[i] = types.Float
-		}
+		colTypes[i] = agg.outputType(input.Schema())
 	}
 
 	return &ScalarAggregateOp{
@@ -65,37 +358,60 @@ func NewScalarAggregateOp(input types.Operator, aggregates []AggregateExpr) *Sca
 
 // Next computes and returns the aggregate result (single row)
 func (s *ScalarAggregateOp) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *ScalarAggregateOp) next() (*types.Row, error) {
 	if s.computed {
 		return nil, nil // Already returned the single result
 	}
 
 	// Initialize state for each aggregate
 	states := make([]aggregateState, len(s.aggregates))
-	for i := range states {
-		states[i].min = math.MaxFloat64
-		states[i].max = -math.MaxFloat64
-	}
 
-	// Stream through all input and update running state
+	// Stream through all input a batch at a time and update running state
+	batches := newBatchReader(s.input)
+	direct, needRow := batchAggregates(s.aggregates, len(s.input.Schema().Columns))
+	var row types.Row
 	for {
-		row, err := s.input.Next()
+		batch, err := batches.next()
 		if err != nil {
 			return nil, err
 		}
-		if row == nil {
+		if batch == nil {
 			break
 		}
 
-		// Update each aggregate's state
 		for i, agg := range s.aggregates {
-			s.updateState(&states[i], agg, row)
+			if direct[i] {
+				updateColumn(&states[i], agg, batch)
+			}
+		}
+		if !needRow {
+			continue
+		}
+		for k := range batch.Rows() {
+			batch.Row(batch.Position(k), &row)
+			for i, agg := range s.aggregates {
+				if !direct[i] {
+					updateState(&states[i], agg, &row)
+				}
+			}
 		}
 	}
 
 	// Compute final results
 	values := make([]interface{}, len(s.aggregates))
 	for i, agg := range s.aggregates {
-		values[i] = s.finalizeState(&states[i], agg)
+		value, err := finalizeState(&states[i], agg, s.outputSchema.Types[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
 	}
 
 	s.computed = true
@@ -103,88 +419,94 @@ func (s *ScalarAggregateOp) Next() (*types.Row, error) {
 	return s.resultRow, nil
 }
 
-func (s *ScalarAggregateOp) updateState(state *aggregateState, agg AggregateExpr, row *types.Row) {
-	state.count++
-
-	// For COUNT(*), we don't need the column value
-	if agg.Type == types.Count && agg.ColumnIndex < 0 {
-		state.hasData = true
-		return
-	}
-
-	// Get column value
-	if agg.ColumnIndex < 0 || agg.ColumnIndex >= len(row.Values) {
-		return
-	}
-
-	val := row.Values[agg.ColumnIndex]
-	numVal, ok := toNumericValue(val)
-	if !ok {
-		return
-	}
-
-	state.hasData = true
-	state.sum += numVal
-
-	if numVal < state.min {
-		state.min = numVal
-	}
-	if numVal > state.max {
-		state.max = numVal
-	}
-}
-
-func (s *ScalarAggregateOp) finalizeState(state *aggregateState, agg AggregateExpr) interface{} {
-	switch agg.Type {
-	case types.Count:
-		return state.count
-	case types.Sum:
-		if !state.hasData {
-			return float64(0)
-		}
-		return state.sum
-	case types.Min:
-		if !state.hasData {
-			return nil
-		}
-		return state.min
-	case types.Max:
-		if !state.hasData {
-			return nil
-		}
-		return state.max
-	case types.Avg:
-		if state.count == 0 {
-			return nil
-		}
-		return state.sum / float64(state.count)
-	default:
-		return nil
-	}
-}
-
 // Close releases resources
 func (s *ScalarAggregateOp) Close() error {
 	return s.input.Close()
 }
 
+// Inputs returns the aggregated input
+func (s *ScalarAggregateOp) Inputs() []types.Operator {
+	return []types.Operator{s.input}
+}
+
 // Schema returns the output schema
 func (s *ScalarAggregateOp) Schema() types.Schema {
 	return s.outputSchema
 }
 
-// HashAggregateOp performs aggregation with GROUP BY
+// DefaultAggregateMemoryBytes is how much memory a HashAggregateOp's
+// groups may take, by its estimate, before it spills new groups to disk
+const DefaultAggregateMemoryBytes = 256 << 20
+
+// Rows of spilled groups are split into 2^aggregatePartitionBits files per
+// pass, each pass using the next bits of the group key's hash
+const (
+	aggregatePartitionBits = 4
+	aggregatePartitions    = 1 << aggregatePartitionBits
+	maxAggregateLevel      = 64/aggregatePartitionBits - 1
+)
+
+// Estimated memory of a group besides its key and states: the groupState,
+// its key values, the map entry and its keys entry
+const (
+	groupOverheadBytes  = 128
+	groupKeyValueBytes  = 16
+	aggregateStateBytes = 80 // An APPROX_TOP_K sketch grows beyond this
+)
+
+// HashAggregateOptions tunes a HashAggregateOp
+type HashAggregateOptions struct {
+	MemoryBytes int64           // Estimated group memory before spilling (0 = DefaultAggregateMemoryBytes)
+	TempQuota   *TempSpaceQuota // Optional per-query limit on spill bytes
+	Spill       SpillOptions    // Where partitions are written, and whether compressed
+	Parallel    bool            // Have a parallel CSVScan input aggregate its segments (see CSVScan.AggregateInParallel)
+	Collation   Collation       // Which texts are one group (the zero value: equal bytes)
+}
+
+// HashAggregateOp performs aggregation with GROUP BY, keeping a hash table
+// of groups in memory.
+//
+// Once the groups take MemoryBytes (grace partitioning), rows of the groups
+// already in the table still update them, but rows of new groups are
+// hash-partitioned by group key into temp files. After the input ends the
+// table's groups are returned, then each partition gets its own pass with
+// a fresh table (partitioning again if it's still too big). A group's rows
+// all land in one partition, so each group is aggregated in one pass;
+// spilled groups just come out after the others.
+//
+// With Parallel, a CSVScan input that can aggregates each segment in its
+// worker, and the operator merges the segments' groups instead of reading
+// rows. It then spills the states of new groups rather than rows.
 type HashAggregateOp struct {
+	execStats
+
 	input          types.Operator
 	groupByIndices []int // Columns to group by
 	aggregates     []AggregateExpr
 	outputSchema   types.Schema
+	memoryBytes    int64
+	tempQuota      *TempSpaceQuota
+	spillOpts      SpillOptions
+	collation      Collation
+	partial        *CSVScan // Input aggregating its segments, if Parallel and it can
 
 	// State
 	computed bool
 	groups   map[string]*groupState
 	keys     []string // Preserve insertion order
 	keyIndex int
+
+	// Spilling
+	groupBytes int64             // Estimated memory of the groups
+	level      int               // Pass depth: 0 reads the input, deeper passes read partitions
+	partitions []*spillPartition // This pass's spill files, by partition number
+	pending    []*spillPartition // Spilled partitions still to aggregate
+	current    *spillPartition   // Partition being read by this pass (nil = input)
+	tempFiles  []string
+	builder    batchBuilder // Batches of a partition's rows
+	spillRow   types.Row
+	spillValue []interface{}
+	record     []string
 }
 
 type groupState struct {
@@ -192,10 +514,42 @@ type groupState struct {
 	states    []aggregateState
 }
 
-// NewHashAggregateOp creates a hash aggregate operator with GROUP BY
+// NewHashAggregateOp creates a hash aggregate operator with GROUP BY and
+// default memory settings
 func NewHashAggregateOp(input types.Operator, groupByIndices []int, aggregates []AggregateExpr) *HashAggregateOp {
-	inputSchema := input.Schema()
+	return NewHashAggregateOpWithOptions(input, groupByIndices, aggregates, HashAggregateOptions{})
+}
+
+// NewHashAggregateOpWithOptions creates a hash aggregate with custom
+// memory/spill settings
+func NewHashAggregateOpWithOptions(input types.Operator, groupByIndices []int, aggregates []AggregateExpr, opts HashAggregateOptions) *HashAggregateOp {
+	memoryBytes := opts.MemoryBytes
+	if memoryBytes <= 0 {
+		memoryBytes = DefaultAggregateMemoryBytes
+	}
+	var partial *CSVScan
+	if scan, ok := input.(*CSVScan); ok && opts.Parallel && scan.AggregateInParallel(groupByIndices, aggregates, opts.Collation) {
+		partial = scan
+	}
+	return &HashAggregateOp{
+		input:          input,
+		groupByIndices: groupByIndices,
+		aggregates:     aggregates,
+		outputSchema:   groupedSchema(input.Schema(), groupByIndices, aggregates),
+		memoryBytes:    memoryBytes,
+		tempQuota:      opts.TempQuota,
+		spillOpts:      opts.Spill,
+		collation:      opts.Collation,
+		partial:        partial,
+		computed:       false,
+		groups:         make(map[string]*groupState),
+		keys:           []string{},
+	}
+}
 
+// groupedSchema returns the output schema of a GROUP BY: the GROUP BY
+// columns, then the aggregates
+func groupedSchema(inputSchema types.Schema, groupByIndices []int, aggregates []AggregateExpr) types.Schema {
 	// Build output schema: GROUP BY columns + aggregate columns
 	numCols := len(groupByIndices) + len(aggregates)
 	columns := make([]string, numCols)
@@ -217,24 +571,12 @@ func NewHashAggregateOp(input types.Operator, groupByIndices []int, aggregates [
 		} else {
 			columns[offset+i] = fmt.Sprintf("%s_%d", agg.Type.String(), i)
 		}
-		if agg.Type == types.Count {
-			colTypes[offset+i] = types.Int
-		} else {
-			colTypes[offset+i] = types.Float
-		}
+		colTypes[offset+i] = agg.outputType(inputSchema)
 	}
 
-	return &HashAggregateOp{
-		input:          input,
-		groupByIndices: groupByIndices,
-		aggregates:     aggregates,
-		outputSchema: types.Schema{
-			Columns: columns,
-			Types:   colTypes,
-		},
-		computed: false,
-		groups:   make(map[string]*groupState),
-		keys:     []string{},
+	return types.Schema{
+		Columns: columns,
+		Types:   colTypes,
 	}
 }
 
@@ -248,124 +590,468 @@ func NewHashAggregateOpByNames(input types.Operator, groupByNames []string, aggr
 	return NewHashAggregateOp(input, indices, aggregates)
 }
 
-// computeGroups processes all input and builds group states
+// computeGroups processes all of this pass's rows (the input's, or a
+// partition's), a batch at a time, and builds group states
 func (h *HashAggregateOp) computeGroups() error {
+	if h.partial != nil {
+		return h.mergeGroups()
+	}
+	next := newBatchReader(h.input).next
+	if h.current != nil {
+		next = h.nextPartitionBatch
+	}
+	direct, needRow := batchAggregates(h.aggregates, len(h.input.Schema().Columns))
+	var row types.Row
+	var key []byte
 	for {
-		row, err := h.input.Next()
+		batch, err := next()
 		if err != nil {
 			return err
 		}
-		if row == nil {
+		if batch == nil {
 			break
 		}
 
-		// Build group key
-		key := h.buildGroupKey(row)
-		group, exists := h.groups[key]
-
-		if !exists {
-			// Create new group
-			keyValues := make([]interface{}, len(h.groupByIndices))
-			for i, idx := range h.groupByIndices {
-				if idx >= 0 && idx < len(row.Values) {
-					keyValues[i] = row.Values[idx]
+		for k := range batch.Rows() {
+			i := batch.Position(k)
+			key = appendGroupKey(key[:0], h.groupByIndices, batch, i, h.collation)
+			group, exists := h.groups[string(key)]
+			if !exists {
+				// The deepest pass has no hash bits left to split on, so it
+				// keeps every group in memory
+				if h.groupBytes >= h.memoryBytes && h.level < maxAggregateLevel {
+					if err := h.spill(key, batch, i); err != nil {
+						return err
+					}
+					continue
 				}
+				group = h.newGroup(string(key), batch, i)
+				h.groupBytes += h.estimateGroupBytes(len(key))
+				h.holdMemory(h.groupBytes)
 			}
-			states := make([]aggregateState, len(h.aggregates))
-			for i := range states {
-				states[i].min = math.MaxFloat64
-				states[i].max = -math.MaxFloat64
+
+			// Update aggregate states for this group
+			if needRow {
+				batch.Row(i, &row)
 			}
-			group = &groupState{
-				keyValues: keyValues,
-				states:    states,
+			group.update(h.aggregates, direct, batch, i, &row)
+		}
+	}
+
+	return nil
+}
+
+// newGroup adds the group of row i of a batch under key
+func (h *HashAggregateOp) newGroup(key string, batch *types.RowBatch, i int) *groupState {
+	group := newGroupState(h.groupByIndices, len(h.aggregates), batch, i)
+	h.groups[key] = group
+	h.keys = append(h.keys, key)
+	return group
+}
+
+// newGroupState returns the empty state of the group of row i of a batch
+func newGroupState(groupByIndices []int, numAggregates int, batch *types.RowBatch, i int) *groupState {
+	keyValues := make([]interface{}, len(groupByIndices))
+	for j, idx := range groupByIndices {
+		if idx < 0 || idx >= len(batch.Columns) {
+			continue
+		}
+		if v, ok := batch.Columns[idx].(*types.StringVector); ok && !v.Nulls[i] {
+			keyValues[j] = string(v.Bytes(i)) // Not a view of the batch's text, which it would keep alive
+			continue
+		}
+		keyValues[j] = batch.Columns[idx].Value(i)
+	}
+	return &groupState{
+		keyValues: keyValues,
+		states:    make([]aggregateState, numAggregates),
+	}
+}
+
+// update adds row i of a batch to the group's aggregates: straight from
+// the batch's columns where direct (see batchAggregates), else from row,
+// which the caller fills with the batch row when any aggregate needs it
+func (g *groupState) update(aggregates []AggregateExpr, direct []bool, batch *types.RowBatch, i int, row *types.Row) {
+	for a, agg := range aggregates {
+		if !direct[a] {
+			updateState(&g.states[a], agg, row)
+			continue
+		}
+		if agg.isCountStar() {
+			g.states[a].count++
+			g.states[a].hasData = true
+		} else {
+			updateAt(&g.states[a], agg, batch.Columns[agg.ColumnIndex], i)
+		}
+	}
+}
+
+// result returns the group's output row values: its key values, then the
+// aggregates' results, of the output schema's types
+func (g *groupState) result(aggregates []AggregateExpr, output types.Schema) ([]interface{}, error) {
+	values := make([]interface{}, len(g.keyValues)+len(aggregates))
+	copy(values, g.keyValues)
+	offset := len(g.keyValues)
+	for i, agg := range aggregates {
+		value, err := finalizeState(&g.states[i], agg, output.Types[offset+i])
+		if err != nil {
+			return nil, err
+		}
+		values[offset+i] = value
+	}
+	return values, nil
+}
+
+// mergeGroups merges all of this pass's partial groups: the tables of the
+// scan's segments, or the states spilled to a partition
+func (h *HashAggregateOp) mergeGroups() error {
+	if h.current != nil {
+		for {
+			record, err := h.current.reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read from temp file: %w", err)
+			}
+			var key string
+			var group *groupState
+			key, group, h.spillValue, err = parseGroupRecord(record, h.spillValue, len(h.groupByIndices), len(h.aggregates), h.collation)
+			if err != nil {
+				return err
+			}
+			if err := h.mergeGroup(key, group); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		table, err := h.partial.nextPartial()
+		if err != nil {
+			return err
+		}
+		if table == nil {
+			return nil
+		}
+		for _, key := range table.keys {
+			if err := h.mergeGroup(key, table.groups[key]); err != nil {
+				return err
 			}
-			h.groups[key] = group
-			h.keys = append(h.keys, key)
 		}
+	}
+}
 
-		// Update aggregate states for this group
+// mergeGroup adds a partial group to its group in memory. A new group is
+// kept, or its state spilled once the groups take the memory limit.
+func (h *HashAggregateOp) mergeGroup(key string, partial *groupState) error {
+	if group, exists := h.groups[key]; exists {
 		for i, agg := range h.aggregates {
-			h.updateState(&group.states[i], agg, row)
+			group.states[i].merge(&partial.states[i], agg)
+		}
+		return nil
+	}
+	if h.groupBytes >= h.memoryBytes && h.level < maxAggregateLevel {
+		part, err := h.partition([]byte(key))
+		if err != nil {
+			return err
+		}
+		h.record, h.spillValue = appendGroupRecord(h.record[:0], h.spillValue, partial)
+		if err := part.writer.Write(h.record); err != nil {
+			return fmt.Errorf("failed to write to temp file: %w", err)
 		}
+		return nil
 	}
+	h.groups[key] = partial
+	h.keys = append(h.keys, key)
+	h.groupBytes += h.estimateGroupBytes(len(key))
+	h.holdMemory(h.groupBytes)
+	return nil
+}
 
+// estimateGroupBytes returns the memory a new group with a key of keyLen
+// bytes is estimated to take: the key (shared by the map and keys), key
+// values that hold about as much again, states and overhead
+func (h *HashAggregateOp) estimateGroupBytes(keyLen int) int64 {
+	return int64(2*keyLen) + int64(len(h.groupByIndices))*groupKeyValueBytes +
+		int64(len(h.aggregates))*aggregateStateBytes + groupOverheadBytes
+}
+
+// spill writes row i of a batch to this pass's partition for its group key
+func (h *HashAggregateOp) spill(key []byte, batch *types.RowBatch, i int) error {
+	part, err := h.partition(key)
+	if err != nil {
+		return err
+	}
+	batch.Row(i, &h.spillRow)
+	h.record = appendSpillRecord(h.record[:0], h.spillRow.Values)
+	if err := part.writer.Write(h.record); err != nil {
+		return fmt.Errorf("failed to write to temp file: %w", err)
+	}
 	return nil
 }
 
-func (h *HashAggregateOp) buildGroupKey(row *types.Row) string {
-	key := ""
-	for i, idx := range h.groupByIndices {
-		if i > 0 {
-			key += "\x00" // Null separator
+// partition returns this pass's partition for a group key, creating its
+// file the first time
+func (h *HashAggregateOp) partition(key []byte) (*spillPartition, error) {
+	if h.partitions == nil {
+		h.partitions = make([]*spillPartition, aggregatePartitions)
+	}
+	n := (hashGroupKey(key) >> (h.level * aggregatePartitionBits)) % aggregatePartitions
+
+	part := h.partitions[n]
+	if part == nil {
+		var err error
+		part, err = h.spillOpts.newPartition("golap_aggregate_*.csv", h.level+1, h.tempQuota, &h.spilled)
+		if err != nil {
+			return nil, err
 		}
-		if idx >= 0 && idx < len(row.Values) {
-			key += fmt.Sprintf("%v", row.Values[idx])
+		// Track the file right away so Close removes it even if writing fails
+		h.tempFiles = append(h.tempFiles, part.path)
+		h.partitions[n] = part
+	}
+	return part, nil
+}
+
+// hashGroupKey hashes a group key for partitioning
+func hashGroupKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return mix64(h.Sum64())
+}
+
+// appendSpillRecord appends a spilled row's values as CSV fields, each
+// tagged with its type (as HashValues tags them) so it reads back exactly,
+// NULLs and all, whatever the column's schema type. NULL has a tag of its
+// own, so no field is ever empty: a record of one empty field would be a
+// blank line, which the CSV reader skips.
+func appendSpillRecord(record []string, values []interface{}) []string {
+	for _, val := range values {
+		switch v := val.(type) {
+		case nil:
+			record = append(record, string(hashTagNull))
+		case int64:
+			record = append(record, string(hashTagInt)+strconv.FormatInt(v, 10))
+		case float64:
+			record = append(record, string(hashTagFloat)+strconv.FormatFloat(v, 'g', -1, 64))
+		case string:
+			record = append(record, string(hashTagString)+v)
+		default:
+			record = append(record, string(hashTagString)+fmt.Sprintf("%v", v))
 		}
 	}
+	return record
+}
+
+// parseSpillRecord appends the values of a record written by
+// appendSpillRecord
+func parseSpillRecord(values []interface{}, record []string) ([]interface{}, error) {
+	for _, field := range record {
+		if field == "" {
+			return nil, fmt.Errorf("corrupt temp file: empty value")
+		}
+		text := field[1:]
+		switch field[0] {
+		case hashTagNull:
+			values = append(values, nil)
+		case hashTagInt:
+			v, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt temp file: %w", err)
+			}
+			values = append(values, v)
+		case hashTagFloat:
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt temp file: %w", err)
+			}
+			values = append(values, v)
+		case hashTagString:
+			values = append(values, text)
+		default:
+			return nil, fmt.Errorf("corrupt temp file: bad value %q", field)
+		}
+	}
+	return values, nil
+}
+
+// nextPartitionBatch returns the next batch of the partition this pass is
+// aggregating, or nil after the last
+func (h *HashAggregateOp) nextPartitionBatch() (*types.RowBatch, error) {
+	h.builder.reset(h.input.Schema())
+	for !h.builder.full() {
+		record, err := h.current.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from temp file: %w", err)
+		}
+		h.spillValue, err = parseSpillRecord(h.spillValue[:0], record)
+		if err != nil {
+			return nil, err
+		}
+		h.builder.add(h.spillValue)
+	}
+	return h.builder.finish(), nil
+}
+
+// nextPass ends the current pass, queueing its spill files, and starts the
+// next pending partition with an empty table; returns false once none are
+// left
+func (h *HashAggregateOp) nextPass() (bool, error) {
+	if h.current != nil {
+		h.current.file.Close()
+		os.Remove(h.current.path)
+		h.current = nil
+	}
+
+	for _, part := range h.partitions {
+		if part == nil {
+			continue
+		}
+		if err := part.finish(); err != nil {
+			return false, err
+		}
+		h.pending = append(h.pending, part)
+	}
+	h.partitions = nil
+
+	if len(h.pending) == 0 {
+		return false, nil
+	}
+	next := h.pending[len(h.pending)-1]
+	h.pending = h.pending[:len(h.pending)-1]
+	if err := next.open(h.spillOpts); err != nil {
+		return false, err
+	}
+	h.current = next
+	h.level = next.level
+	h.groups = make(map[string]*groupState)
+	h.keys = h.keys[:0]
+	h.keyIndex = 0
+	h.groupBytes = 0
+	return true, nil
+}
+
+// appendGroupKey appends the group key of row i of a batch: the typed
+// encodings of its GROUP BY values (see appendTypedValue), so the int 1
+// and the text 1 or NULL and empty text are different groups, while 1 and
+// 1.0 are the same, as is text the collation finds equal
+func appendGroupKey(key []byte, groupByIndices []int, batch *types.RowBatch, i int, collation Collation) []byte {
+	for _, idx := range groupByIndices {
+		if idx < 0 || idx >= len(batch.Columns) {
+			key = append(key, hashTagNull)
+			continue
+		}
+		switch v := batch.Columns[idx].(type) {
+		case *types.Int64Vector:
+			if !v.Nulls[i] {
+				key = appendTypedInt(key, v.Values[i])
+				continue
+			}
+		case *types.Float64Vector:
+			if !v.Nulls[i] {
+				key = appendTypedFloat(key, v.Values[i])
+				continue
+			}
+		case *types.StringVector:
+			if !v.Nulls[i] && collation.IsBinary() {
+				key = appendTypedString(key, v.Bytes(i))
+				continue
+			}
+		}
+		key = appendGroupKeyValue(key, batch.Columns[idx].Value(i), collation)
+	}
 	return key
 }
 
-func (h *HashAggregateOp) updateState(state *aggregateState, agg AggregateExpr, row *types.Row) {
-	state.count++
+// appendGroupKeyValue appends one value of a group key, as appendGroupKey
+func appendGroupKeyValue(key []byte, v interface{}, collation Collation) []byte {
+	if s, ok := v.(string); ok {
+		return appendCollatedString(key, s, collation)
+	}
+	return appendTypedValue(key, v)
+}
 
-	if agg.Type == types.Count && agg.ColumnIndex < 0 {
-		state.hasData = true
+// updateState adds a row to a GROUP BY aggregate's state
+func updateState(state *aggregateState, agg AggregateExpr, row *types.Row) {
+	if agg.Type == types.LatestBy {
+		updateLatest(state, agg, row)
 		return
 	}
-
-	if agg.ColumnIndex < 0 || agg.ColumnIndex >= len(row.Values) {
+	if agg.Type == types.ApproxTopK {
+		updateTopK(state, agg, row)
 		return
 	}
 
-	val := row.Values[agg.ColumnIndex]
-	numVal, ok := toNumericValue(val)
-	if !ok {
+	if agg.isCountStar() {
+		state.count++
+		state.hasData = true
 		return
 	}
 
-	state.hasData = true
-	state.sum += numVal
-
-	if numVal < state.min {
-		state.min = numVal
-	}
-	if numVal > state.max {
-		state.max = numVal
+	val, ok := agg.inputValue(row)
+	if !ok {
+		return
 	}
+	updateValue(state, agg, val)
 }
 
-func (h *HashAggregateOp) finalizeState(state *aggregateState, agg AggregateExpr) interface{} {
+// finalizeState returns an aggregate's result, of its output column's
+// type; an Int SUM past the range of int64 fails rather than wrap
+func finalizeState(state *aggregateState, agg AggregateExpr, output types.DataType) (interface{}, error) {
 	switch agg.Type {
 	case types.Count:
-		return state.count
+		return state.count, nil
 	case types.Sum:
 		if !state.hasData {
-			return float64(0)
+			return nil, nil // Of no rows, or only NULLs
 		}
-		return state.sum
-	case types.Min:
-		if !state.hasData {
-			return nil
+		if output != types.Int {
+			return state.sum, nil
 		}
-		return state.min
-	case types.Max:
-		if !state.hasData {
-			return nil
+		if state.overflow {
+			return nil, fmt.Errorf("%s is out of range for Int", agg.Alias)
 		}
-		return state.max
+		return state.intSum, nil
+	case types.Min:
+		return conform(state.min, output), nil
+	case types.Max:
+		return conform(state.max, output), nil
 	case types.Avg:
 		if state.count == 0 {
-			return nil
+			return nil, nil
 		}
-		return state.sum / float64(state.count)
+		return state.sum / float64(state.count), nil
+	case types.LatestBy:
+		return state.latest, nil
+	case types.ApproxTopK:
+		return topKResult(state.topK, agg.K), nil
 	default:
-		return nil
+		return nil, nil
+	}
+}
+
+// conform returns an Int value of a Float column as a float, so an
+// expression's result is of the type its schema says
+func conform(val interface{}, output types.DataType) interface{} {
+	if n, ok := val.(int64); ok && output == types.Float {
+		return float64(n)
 	}
+	return val
 }
 
 // Next returns the next group's result
 func (h *HashAggregateOp) Next() (*types.Row, error) {
+	start := h.startCall()
+	row, err := h.next()
+	h.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (h *HashAggregateOp) next() (*types.Row, error) {
 	if !h.computed {
 		if err := h.computeGroups(); err != nil {
 			return nil, err
@@ -373,35 +1059,47 @@ func (h *HashAggregateOp) Next() (*types.Row, error) {
 		h.computed = true
 	}
 
-	if h.keyIndex >= len(h.keys) {
-		return nil, nil
+	for h.keyIndex >= len(h.keys) {
+		more, err := h.nextPass()
+		if err != nil || !more {
+			return nil, err
+		}
+		if err := h.computeGroups(); err != nil {
+			return nil, err
+		}
 	}
 
 	key := h.keys[h.keyIndex]
 	h.keyIndex++
 
-	group := h.groups[key]
-
 	// Build output row: group key values + aggregated values
-	values := make([]interface{}, len(h.groupByIndices)+len(h.aggregates))
-
-	// Copy group key values
-	for i, v := range group.keyValues {
-		values[i] = v
+	values, err := h.groups[key].result(h.aggregates, h.outputSchema)
+	if err != nil {
+		return nil, err
 	}
+	return &types.Row{Values: values}, nil
+}
 
-	// Compute aggregate results
-	offset := len(h.groupByIndices)
-	for i, agg := range h.aggregates {
-		values[offset+i] = h.finalizeState(&group.states[i], agg)
+// Close releases resources and deletes temp files
+func (h *HashAggregateOp) Close() error {
+	if err := h.input.Close(); err != nil {
+		return err
 	}
 
-	return &types.Row{Values: values}, nil
+	for _, part := range append(h.partitions, h.current) {
+		if part != nil && part.file != nil {
+			part.file.Close()
+		}
+	}
+	for _, path := range h.tempFiles {
+		os.Remove(path)
+	}
+	return nil
 }
 
-// Close releases resources
-func (h *HashAggregateOp) Close() error {
-	return h.input.Close()
+// Inputs returns the grouped input
+func (h *HashAggregateOp) Inputs() []types.Operator {
+	return []types.Operator{h.input}
 }
 
 // Schema returns the output schema
@@ -422,3 +1120,50 @@ func toNumericValue(val interface{}) (float64, bool) {
 		return 0, false
 	}
 }
+
+// aggregateRowBytes is a rough per-value width for aggregate output rows
+const aggregateRowBytes = 16
+
+// Explain describes the scalar aggregate (always one output row)
+func (s *ScalarAggregateOp) Explain() PlanNode {
+	child := ExplainOperator(s.input)
+	return PlanNode{
+		Operator:          "ScalarAggregate",
+		Details:           strings.Join(s.outputSchema.Columns, ", "),
+		EstimatedRows:     1,
+		EstimatedRowBytes: int64(len(s.outputSchema.Columns)) * aggregateRowBytes,
+		Children:          []PlanNode{child},
+	}
+}
+
+// Explain describes the hash aggregate and predicts the spill: at worst
+// every input row is a group, and the rows of groups beyond those that fit
+// in memory are written once by the first pass
+func (h *HashAggregateOp) Explain() PlanNode {
+	child := ExplainOperator(h.input)
+
+	spill := int64(-1)
+	fit := h.memoryBytes / h.estimateGroupBytes(len(h.groupByIndices)*aggregateRowBytes)
+	if child.EstimatedRows >= 0 && child.EstimatedRows <= fit {
+		spill = 0
+	} else if child.EstimatedRows >= 0 && child.EstimatedRowBytes >= 0 {
+		spill = (child.EstimatedRows - fit) * child.EstimatedRowBytes
+	}
+
+	details := fmt.Sprintf("%s, memory=%s", strings.Join(h.outputSchema.Columns, ", "), FormatBytes(h.memoryBytes))
+	if h.partial != nil {
+		details += ", partial per worker"
+	}
+	if !h.collation.IsBinary() {
+		details += ", collation=" + h.collation.String()
+	}
+
+	return PlanNode{
+		Operator:          "HashAggregate",
+		Details:           details,
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: int64(len(h.outputSchema.Columns)) * aggregateRowBytes,
+		SpillBytes:        spill,
+		Children:          []PlanNode{child},
+	}
+}
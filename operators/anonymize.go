@@ -0,0 +1,126 @@
+package operators
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// dateLayouts are the date and timestamp formats GENERALIZE_DATE accepts
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006/01/02",
+}
+
+// DateGranularities are the GENERALIZE_DATE levels, finest first
+var DateGranularities = []string{"day", "week", "month", "quarter", "year"}
+
+// valueText returns a value as it would be written to CSV
+func valueText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// SHA256Expr evaluates HASH_SHA256(arg [, salt]): the hex SHA-256 of the
+// value's text, prefixed by the salt if given. NULL stays NULL.
+func SHA256Expr(arg, salt ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		v := arg(row)
+		if v == nil {
+			return nil
+		}
+		h := sha256.New()
+		if salt != nil {
+			if s := salt(row); s != nil {
+				h.Write([]byte(valueText(s)))
+			}
+		}
+		h.Write([]byte(valueText(v)))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// MaskEmailExpr evaluates MASK_EMAIL(arg): the first character of the
+// local part followed by *** and the domain (jane.doe@example.com becomes
+// j***@example.com). A value without @ keeps only its first character.
+func MaskEmailExpr(arg ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		v := arg(row)
+		if v == nil {
+			return nil
+		}
+		s := valueText(v)
+		if s == "" {
+			return s
+		}
+		at := strings.LastIndex(s, "@")
+		local, domain := s, ""
+		if at >= 0 {
+			local, domain = s[:at], s[at:]
+		}
+		first, size := utf8.DecodeRuneInString(local)
+		if size == 0 {
+			return "***" + domain
+		}
+		return string(first) + "***" + domain
+	}
+}
+
+// GeneralizeDateExpr evaluates GENERALIZE_DATE(arg, granularity): the date
+// truncated to day (2024-03-15), ISO week (2024-W11), month (2024-03),
+// quarter (2024-Q1) or year (2024). Values that don't parse as a date
+// become NULL rather than passing through unmasked.
+func GeneralizeDateExpr(arg ValueExpr, granularity string) ValueExpr {
+	return func(row *types.Row) interface{} {
+		v := arg(row)
+		if v == nil {
+			return nil
+		}
+		t, ok := parseDate(valueText(v))
+		if !ok {
+			return nil
+		}
+		switch granularity {
+		case "day":
+			return t.Format("2006-01-02")
+		case "week":
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%04d-W%02d", year, week)
+		case "month":
+			return t.Format("2006-01")
+		case "quarter":
+			return fmt.Sprintf("%04d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+		default:
+			return t.Format("2006")
+		}
+	}
+}
+
+// parseDate parses a date or timestamp in one of dateLayouts
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
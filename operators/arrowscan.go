@@ -0,0 +1,1080 @@
+package operators
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Arrow type ids (the Type union in Arrow's Schema.fbs)
+const (
+	arrowNull            = 1
+	arrowInt             = 2
+	arrowFloatingPoint   = 3
+	arrowBinary          = 4
+	arrowUtf8            = 5
+	arrowBool            = 6
+	arrowDecimal         = 7
+	arrowDate            = 8
+	arrowTime            = 9
+	arrowTimestamp       = 10
+	arrowInterval        = 11
+	arrowList            = 12
+	arrowStruct          = 13
+	arrowUnion           = 14
+	arrowFixedSizeBinary = 15
+	arrowFixedSizeList   = 16
+	arrowMap             = 17
+	arrowDuration        = 18
+	arrowLargeBinary     = 19
+	arrowLargeUtf8       = 20
+	arrowLargeList       = 21
+	arrowRunEndEncoded   = 22
+)
+
+// Arrow IPC message header types
+const (
+	arrowSchemaMessage      = 1
+	arrowDictionaryMessage  = 2
+	arrowRecordBatchMessage = 3
+)
+
+// arrowMagic starts (and ends) an Arrow IPC file, i.e. a Feather v2 file
+const arrowMagic = "ARROW1"
+
+// arrowField is a column of an Arrow schema
+type arrowField struct {
+	name      string
+	kind      uint8
+	bitWidth  int   // Int, Time, Decimal
+	signed    bool  // Int
+	precision int16 // FloatingPoint: 0 half, 1 single, 2 double
+	unit      int16 // Date, Time, Timestamp, Duration, Interval
+	scale     int   // Decimal
+	byteWidth int   // FixedSizeBinary
+	timezone  string
+	unionMode int16 // 0 sparse, 1 dense
+
+	dictionary  bool // Values are indexes into a dictionary batch
+	dictID      int64
+	indexWidth  int
+	indexSigned bool
+
+	children []*arrowField
+}
+
+// ArrowScan streams rows from an Arrow IPC file (.arrow, Feather v2
+// .feather) or stream (.arrows). Record batches are already columnar and
+// typed, so values are read straight from the batch buffers without
+// parsing text. Integers and durations become Int, floating point and
+// decimals Float, everything else (strings, booleans, dates, timestamps)
+// String; nested columns (lists, structs, maps, unions) are left out.
+// Dictionary-encoded columns read as their values. Compressed batches
+// (LZ4 frames or ZSTD) are decompressed buffer by buffer. A uint64 column
+// holding a value past int64's range is an error rather than wrapping.
+type ArrowScan struct {
+	execStats
+
+	file       storage.File
+	gzipReader *gzip.Reader // Non-nil for .gz files
+	counter    *countingReader
+	input      io.Reader
+	filePath   string
+	fileSize   int64 // -1 if unknown
+	fileFormat bool  // Starts with the ARROW1 magic (else a bare stream)
+	strict     bool
+
+	fields   []*arrowField // Top-level schema fields, including skipped ones
+	output   []int         // Field index of each output column
+	natural  []types.DataType
+	schema   types.Schema
+	declared []bool
+
+	dictionaries map[int64][]interface{}
+	zstd         *zstd.Decoder  // Created for the first ZSTD-compressed batch
+	batch        []*arrowColumn // Columns of the current batch, by field
+	batchLen     int
+	row          int   // Next row in the batch
+	rows         int64 // Rows returned so far, for error messages
+	rowBytes     int64 // Body bytes per row in the first batch
+	done         bool
+	cancel       cancelCheck
+}
+
+// NewArrowScan creates an Arrow IPC scanner
+func NewArrowScan(filePath string) (*ArrowScan, error) {
+	return NewArrowScanWithOptions(filePath, ScanOptions{})
+}
+
+// NewArrowScanWithOptions creates an Arrow IPC scanner; declared column
+// types convert the file's values, other text options don't apply
+func NewArrowScanWithOptions(filePath string, opts ScanOptions) (*ArrowScan, error) {
+	file, counter, gzipReader, input, err := openScanInput(filePath, opts.BufferSize, "", opts.Mmap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Arrow file: %w", err)
+	}
+	s := &ArrowScan{
+		file:         file,
+		gzipReader:   gzipReader,
+		counter:      counter,
+		filePath:     filePath,
+		fileSize:     file.Size(),
+		strict:       opts.Strict,
+		dictionaries: make(map[int64][]interface{}),
+		cancel:       cancelCheck{ctx: opts.Context},
+	}
+	if err := s.open(input, opts); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return s, nil
+}
+
+// open reads the schema and the first record batch
+func (s *ArrowScan) open(input *bufio.Reader, opts ScanOptions) error {
+	s.input = input
+	if magic, err := input.Peek(8); err == nil {
+		switch {
+		case string(magic[:6]) == arrowMagic:
+			s.fileFormat = true
+			input.Discard(8) // Magic and padding
+		case string(magic[:4]) == "FEA1":
+			return fmt.Errorf("Feather v1 files are not supported; rewrite it as Feather v2 (Arrow IPC)")
+		}
+	}
+
+	kind, header, _, err := s.readMessage()
+	if err == io.EOF {
+		return fmt.Errorf("not an Arrow IPC file: no schema")
+	}
+	if err != nil {
+		return err
+	}
+	if kind != arrowSchemaMessage {
+		return fmt.Errorf("not an Arrow IPC file: first message isn't a schema")
+	}
+	if header.int16(0, 0) != 0 {
+		return fmt.Errorf("big-endian Arrow files are not supported")
+	}
+	fieldTables, err := header.tables(1)
+	if err != nil {
+		return err
+	}
+	for _, ft := range fieldTables {
+		field, err := parseArrowField(ft)
+		if err != nil {
+			return err
+		}
+		s.fields = append(s.fields, field)
+	}
+
+	for i, field := range s.fields {
+		dt, ok := field.dataType()
+		if !ok {
+			continue // Nested columns aren't supported
+		}
+		s.natural = append(s.natural, dt)
+		declared, isDeclared := opts.ColumnTypes[field.name]
+		if isDeclared {
+			dt = declared
+		}
+		s.output = append(s.output, i)
+		s.declared = append(s.declared, isDeclared)
+		s.schema.Columns = append(s.schema.Columns, field.name)
+		s.schema.Types = append(s.schema.Types, dt)
+	}
+
+	// Load the first batch to know the row width for estimates
+	if err := s.nextBatch(); err != nil {
+		return err
+	}
+	if s.batchLen > 0 {
+		s.rowBytes = s.counter.count / int64(s.batchLen)
+	}
+	return nil
+}
+
+// readMessage reads the next IPC message: its header type, header table
+// and body. It returns io.EOF at the end-of-stream marker or end of input.
+func (s *ArrowScan) readMessage() (kind uint8, header fbTable, body []byte, err error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(s.input, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, fbTable{}, nil, err
+	}
+	length := int32(binary.LittleEndian.Uint32(prefix[:]))
+	if length == -1 {
+		// Continuation marker, then the metadata length
+		if _, err := io.ReadFull(s.input, prefix[:]); err != nil {
+			return 0, fbTable{}, nil, fmt.Errorf("truncated Arrow message: %w", err)
+		}
+		length = int32(binary.LittleEndian.Uint32(prefix[:]))
+	} else if s.fileFormat {
+		// Files always use the marker; anything else is the footer
+		return 0, fbTable{}, nil, io.EOF
+	}
+	if length == 0 {
+		return 0, fbTable{}, nil, io.EOF
+	}
+	if length < 0 {
+		return 0, fbTable{}, nil, errCorruptFlatBuffer
+	}
+
+	if s.gzipReader == nil && s.fileSize >= 0 && int64(length) > s.fileSize {
+		return 0, fbTable{}, nil, errCorruptFlatBuffer
+	}
+	metadata := make([]byte, length)
+	if _, err := io.ReadFull(s.input, metadata); err != nil {
+		return 0, fbTable{}, nil, fmt.Errorf("truncated Arrow message: %w", err)
+	}
+	message, err := fbRoot(metadata)
+	if err != nil {
+		return 0, fbTable{}, nil, err
+	}
+	bodyLength := message.int64(3, 0)
+	if bodyLength < 0 || (s.gzipReader == nil && s.fileSize >= 0 && bodyLength > s.fileSize) {
+		return 0, fbTable{}, nil, errCorruptFlatBuffer
+	}
+	body = make([]byte, bodyLength)
+	if _, err := io.ReadFull(s.input, body); err != nil {
+		return 0, fbTable{}, nil, fmt.Errorf("truncated Arrow message body: %w", err)
+	}
+	header, ok := message.table(2)
+	if !ok {
+		return 0, fbTable{}, nil, errCorruptFlatBuffer
+	}
+	return message.uint8(1, 0), header, body, nil
+}
+
+// parseArrowField reads a Field table and its children
+func parseArrowField(t fbTable) (*arrowField, error) {
+	f := &arrowField{name: t.string(0), kind: t.uint8(2, 0)}
+	typ, _ := t.table(3)
+	if typ.buf != nil {
+		switch f.kind {
+		case arrowInt:
+			f.bitWidth = int(typ.int32(0, 0))
+			f.signed = typ.uint8(1, 0) != 0
+		case arrowFloatingPoint:
+			f.precision = typ.int16(0, 0)
+		case arrowDecimal:
+			f.scale = int(typ.int32(1, 0))
+			f.bitWidth = int(typ.int32(2, 128))
+		case arrowDate:
+			f.unit = typ.int16(0, 1)
+		case arrowTime:
+			f.unit = typ.int16(0, 1)
+			f.bitWidth = int(typ.int32(1, 32))
+		case arrowTimestamp:
+			f.unit = typ.int16(0, 0)
+			f.timezone = typ.string(1)
+		case arrowDuration:
+			f.unit = typ.int16(0, 1)
+		case arrowInterval:
+			f.unit = typ.int16(0, 0)
+		case arrowFixedSizeBinary:
+			f.byteWidth = int(typ.int32(0, 0))
+		case arrowUnion:
+			f.unionMode = typ.int16(0, 0)
+		}
+	} else if f.kind == arrowDate || f.kind == arrowTime || f.kind == arrowDuration {
+		f.unit = 1 // Millisecond, the schema default
+	}
+	if f.kind == arrowDecimal && f.bitWidth == 0 {
+		f.bitWidth = 128
+	}
+
+	if dict, ok := t.table(4); ok {
+		f.dictionary = true
+		f.dictID = dict.int64(0, 0)
+		f.indexWidth, f.indexSigned = 32, true
+		if index, ok := dict.table(1); ok {
+			f.indexWidth = int(index.int32(0, 32))
+			f.indexSigned = index.uint8(1, 0) != 0
+		}
+	}
+
+	if !f.valid() {
+		return nil, fmt.Errorf("column %s: invalid Arrow type parameters", f.name)
+	}
+
+	children, err := t.tables(5)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range children {
+		child, err := parseArrowField(ct)
+		if err != nil {
+			return nil, err
+		}
+		f.children = append(f.children, child)
+	}
+	return f, nil
+}
+
+// valid checks the widths and units the reader relies on
+func (f *arrowField) valid() bool {
+	oneOf := func(v int, allowed ...int) bool {
+		for _, a := range allowed {
+			if v == a {
+				return true
+			}
+		}
+		return false
+	}
+	if f.dictionary && !oneOf(f.indexWidth, 8, 16, 32, 64) {
+		return false
+	}
+	switch f.kind {
+	case arrowInt:
+		return oneOf(f.bitWidth, 8, 16, 32, 64)
+	case arrowFloatingPoint:
+		return f.precision >= 0 && f.precision <= 2
+	case arrowDecimal:
+		return oneOf(f.bitWidth, 32, 64, 128, 256)
+	case arrowDate:
+		return f.unit == 0 || f.unit == 1
+	case arrowTime:
+		return oneOf(f.bitWidth, 32, 64) && f.unit >= 0 && f.unit <= 3
+	case arrowTimestamp, arrowDuration:
+		return f.unit >= 0 && f.unit <= 3
+	case arrowFixedSizeBinary:
+		return f.byteWidth > 0
+	}
+	return true
+}
+
+// dataType maps the field's (value) type to a golap type; ok is false
+// for nested and other unsupported types
+func (f *arrowField) dataType() (types.DataType, bool) {
+	switch f.kind {
+	case arrowInt, arrowDuration:
+		return types.Int, true
+	case arrowFloatingPoint, arrowDecimal:
+		return types.Float, true
+	case arrowNull, arrowBinary, arrowUtf8, arrowBool, arrowDate, arrowTime,
+		arrowTimestamp, arrowFixedSizeBinary, arrowLargeBinary, arrowLargeUtf8:
+		return types.String, true
+	default:
+		return types.String, false
+	}
+}
+
+// typeName describes the field's Arrow type, e.g. int32 or timestamp[us, UTC]
+func (f *arrowField) typeName() string {
+	var name string
+	units := []string{"s", "ms", "us", "ns"}
+	unit := func() string {
+		if int(f.unit) < len(units) {
+			return units[f.unit]
+		}
+		return "?"
+	}
+	switch f.kind {
+	case arrowNull:
+		name = "null"
+	case arrowInt:
+		name = fmt.Sprintf("int%d", f.bitWidth)
+		if !f.signed {
+			name = "u" + name
+		}
+	case arrowFloatingPoint:
+		name = [...]string{"float16", "float32", "float64"}[min(int(f.precision), 2)]
+	case arrowBinary:
+		name = "binary"
+	case arrowUtf8:
+		name = "utf8"
+	case arrowLargeBinary:
+		name = "large_binary"
+	case arrowLargeUtf8:
+		name = "large_utf8"
+	case arrowBool:
+		name = "bool"
+	case arrowDecimal:
+		name = fmt.Sprintf("decimal%d(scale %d)", f.bitWidth, f.scale)
+	case arrowDate:
+		name = "date32"
+		if f.unit == 1 {
+			name = "date64"
+		}
+	case arrowTime:
+		name = fmt.Sprintf("time%d[%s]", f.bitWidth, unit())
+	case arrowTimestamp:
+		name = "timestamp[" + unit()
+		if f.timezone != "" {
+			name += ", " + f.timezone
+		}
+		name += "]"
+	case arrowDuration:
+		name = "duration[" + unit() + "]"
+	case arrowFixedSizeBinary:
+		name = fmt.Sprintf("fixed_size_binary[%d]", f.byteWidth)
+	default:
+		name = fmt.Sprintf("type %d", f.kind)
+	}
+	if f.dictionary {
+		name = "dictionary<" + name + ">"
+	}
+	return name
+}
+
+// bufferCount returns how many buffers the field itself (not its
+// children) has in a record batch
+func (f *arrowField) bufferCount() (int, error) {
+	if f.dictionary {
+		return 2, nil // Validity and indexes
+	}
+	switch f.kind {
+	case arrowNull, arrowRunEndEncoded:
+		return 0, nil
+	case arrowBinary, arrowUtf8, arrowLargeBinary, arrowLargeUtf8:
+		return 3, nil
+	case arrowStruct, arrowFixedSizeList:
+		return 1, nil
+	case arrowList, arrowLargeList, arrowMap:
+		return 2, nil
+	case arrowUnion:
+		if f.unionMode == 1 {
+			return 2, nil // Type ids and offsets
+		}
+		return 1, nil
+	case arrowInt, arrowFloatingPoint, arrowBool, arrowDecimal, arrowDate, arrowTime,
+		arrowTimestamp, arrowDuration, arrowInterval, arrowFixedSizeBinary:
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("column %s: unsupported Arrow type %d", f.name, f.kind)
+	}
+}
+
+// valueWidth returns the byte width of a fixed-width value, 0 otherwise
+func (f *arrowField) valueWidth() int {
+	if f.dictionary {
+		return f.indexWidth / 8
+	}
+	switch f.kind {
+	case arrowInt, arrowDecimal, arrowTime:
+		return f.bitWidth / 8
+	case arrowFloatingPoint:
+		return [...]int{2, 4, 8}[min(int(f.precision), 2)]
+	case arrowDate:
+		if f.unit == 0 {
+			return 4
+		}
+		return 8
+	case arrowTimestamp, arrowDuration:
+		return 8
+	case arrowFixedSizeBinary:
+		return f.byteWidth
+	}
+	return 0
+}
+
+// arrowColumn is one column of a record batch, read in place
+type arrowColumn struct {
+	field    *arrowField
+	length   int
+	validity []byte   // nil if there are no nulls
+	buffers  [][]byte // The field's buffers after validity
+	dict     []interface{}
+}
+
+// batchCursor walks a record batch's field nodes and buffers, which are
+// listed depth-first over the schema
+type batchCursor struct {
+	nodes   []byte
+	buffers []byte
+	body    []byte
+	decode  func(dst, src []byte) ([]byte, error) // Decompresses buffers; nil if uncompressed
+}
+
+// take reads the next field's node and buffers, and its children's
+func (c *batchCursor) take(f *arrowField) (*arrowColumn, error) {
+	if len(c.nodes) < 16 {
+		return nil, errCorruptFlatBuffer
+	}
+	length := int64(binary.LittleEndian.Uint64(c.nodes))
+	nullCount := int64(binary.LittleEndian.Uint64(c.nodes[8:]))
+	c.nodes = c.nodes[16:]
+
+	count, err := f.bufferCount()
+	if err != nil {
+		return nil, err
+	}
+	buffers := make([][]byte, count)
+	for i := range buffers {
+		if len(c.buffers) < 16 {
+			return nil, errCorruptFlatBuffer
+		}
+		offset := int64(binary.LittleEndian.Uint64(c.buffers))
+		size := int64(binary.LittleEndian.Uint64(c.buffers[8:]))
+		c.buffers = c.buffers[16:]
+		if offset < 0 || size < 0 || offset+size > int64(len(c.body)) {
+			return nil, errCorruptFlatBuffer
+		}
+		buffers[i] = c.body[offset : offset+size]
+		if c.decode != nil {
+			if buffers[i], err = c.decompress(buffers[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !f.dictionary {
+		for _, child := range f.children {
+			if _, err := c.take(child); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, ok := f.dataType(); !ok {
+		return nil, nil // Skipped column
+	}
+	if length < 0 || length > math.MaxInt32 || nullCount < 0 {
+		return nil, errCorruptFlatBuffer
+	}
+	col := &arrowColumn{field: f, length: int(length)}
+	if len(buffers) > 0 {
+		if nullCount > 0 {
+			col.validity = buffers[0]
+			if col.length > len(col.validity)*8 {
+				return nil, errCorruptFlatBuffer
+			}
+		}
+		col.buffers = buffers[1:]
+	}
+	if err := col.check(); err != nil {
+		return nil, err
+	}
+	return col, nil
+}
+
+// decompress decodes a buffer of a compressed batch: its uncompressed
+// length, or -1 if it was stored uncompressed, then the data. An empty
+// buffer is left empty.
+func (c *batchCursor) decompress(buf []byte) ([]byte, error) {
+	if len(buf) == 0 {
+		return buf, nil
+	}
+	if len(buf) < 8 {
+		return nil, errCorruptFlatBuffer
+	}
+	length := int64(binary.LittleEndian.Uint64(buf))
+	if length == -1 {
+		return buf[8:], nil
+	}
+	if length < 0 || length > math.MaxInt32 {
+		return nil, errCorruptFlatBuffer
+	}
+	// Capacity for a generous ratio only, so a corrupt length can't make
+	// a huge allocation up front
+	out, err := c.decode(make([]byte, 0, min(length, int64(len(buf))*64)), buf[8:])
+	if err != nil {
+		return nil, fmt.Errorf("decompressing an Arrow buffer: %w", err)
+	}
+	if int64(len(out)) != length {
+		return nil, errCorruptFlatBuffer
+	}
+	return out, nil
+}
+
+// check verifies the buffers are big enough for the column's length, so
+// reading values can't go out of bounds
+func (c *arrowColumn) check() error {
+	f := c.field
+	if f.kind == arrowNull && !f.dictionary {
+		return nil
+	}
+	if f.kind == arrowBool && !f.dictionary {
+		if c.length > len(c.buffers[0])*8 {
+			return errCorruptFlatBuffer
+		}
+		return nil
+	}
+	if width := f.valueWidth(); width > 0 {
+		if c.length > len(c.buffers[0])/width {
+			return errCorruptFlatBuffer
+		}
+		if f.kind == arrowInt && !f.signed && width == 8 && !f.dictionary {
+			return c.checkUint64()
+		}
+		return nil
+	}
+	// Variable-width: offsets, then data
+	width := 4
+	if f.kind == arrowLargeBinary || f.kind == arrowLargeUtf8 {
+		width = 8
+	}
+	offsets := c.buffers[0]
+	if c.length == 0 {
+		return nil
+	}
+	if c.length >= len(offsets)/width {
+		return errCorruptFlatBuffer
+	}
+	for i := 0; i <= c.length; i++ {
+		off := readInt(offsets[i*width:], width, true)
+		if off < 0 || off > int64(len(c.buffers[1])) {
+			return errCorruptFlatBuffer
+		}
+	}
+	return nil
+}
+
+// checkUint64 rejects a uint64 column holding a value past int64's range,
+// which an Int can't represent
+func (c *arrowColumn) checkUint64() error {
+	for i := 0; i < c.length; i++ {
+		if c.validity != nil && c.validity[i>>3]&(1<<(i&7)) == 0 {
+			continue
+		}
+		if v := binary.LittleEndian.Uint64(c.buffers[0][i*8:]); v > math.MaxInt64 {
+			return fmt.Errorf("column %s: uint64 value %d is out of the Int range", c.field.name, v)
+		}
+	}
+	return nil
+}
+
+// readInt reads a little-endian integer of the given byte width. An
+// unsigned 8-byte value past int64's range would wrap negative; checkUint64
+// rejects value columns holding one, and a dictionary index that wraps is
+// out of range.
+func readInt(b []byte, width int, signed bool) int64 {
+	switch width {
+	case 1:
+		if signed {
+			return int64(int8(b[0]))
+		}
+		return int64(b[0])
+	case 2:
+		v := binary.LittleEndian.Uint16(b)
+		if signed {
+			return int64(int16(v))
+		}
+		return int64(v)
+	case 4:
+		v := binary.LittleEndian.Uint32(b)
+		if signed {
+			return int64(int32(v))
+		}
+		return int64(v)
+	default:
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+}
+
+// value returns row i of the column as a golap value
+func (c *arrowColumn) value(i int) interface{} {
+	f := c.field
+	if c.validity != nil && c.validity[i>>3]&(1<<(i&7)) == 0 {
+		return nil
+	}
+	if f.dictionary {
+		idx := readInt(c.buffers[0][i*f.indexWidth/8:], f.indexWidth/8, f.indexSigned)
+		if idx < 0 || idx >= int64(len(c.dict)) {
+			return nil
+		}
+		return c.dict[idx]
+	}
+
+	width := f.valueWidth()
+	switch f.kind {
+	case arrowNull:
+		return nil
+	case arrowInt, arrowDuration:
+		return readInt(c.buffers[0][i*width:], width, f.signed || f.kind == arrowDuration)
+	case arrowFloatingPoint:
+		b := c.buffers[0][i*width:]
+		switch width {
+		case 2:
+			return float16ToFloat64(binary.LittleEndian.Uint16(b))
+		case 4:
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		default:
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	case arrowBool:
+		if c.buffers[0][i>>3]&(1<<(i&7)) != 0 {
+			return "true"
+		}
+		return "false"
+	case arrowDecimal:
+		return decimalValue(c.buffers[0][i*width:(i+1)*width], f.scale)
+	case arrowDate:
+		if f.unit == 0 {
+			days := readInt(c.buffers[0][i*4:], 4, true)
+			return time.Unix(days*86400, 0).UTC().Format("2006-01-02")
+		}
+		return time.UnixMilli(readInt(c.buffers[0][i*8:], 8, true)).UTC().Format("2006-01-02")
+	case arrowTime:
+		v := readInt(c.buffers[0][i*width:], width, true)
+		return time.Unix(0, v*unitNanos(f.unit)).UTC().Format("15:04:05.999999999")
+	case arrowTimestamp:
+		v := readInt(c.buffers[0][i*8:], 8, true)
+		return formatTimestamp(v, f.unit, f.timezone)
+	case arrowFixedSizeBinary:
+		return string(c.buffers[0][i*width : (i+1)*width])
+	default:
+		// Binary and UTF-8, with 32- or 64-bit offsets
+		offsetWidth := 4
+		if f.kind == arrowLargeBinary || f.kind == arrowLargeUtf8 {
+			offsetWidth = 8
+		}
+		start := readInt(c.buffers[0][i*offsetWidth:], offsetWidth, true)
+		end := readInt(c.buffers[0][(i+1)*offsetWidth:], offsetWidth, true)
+		if end < start {
+			return nil
+		}
+		return string(c.buffers[1][start:end])
+	}
+}
+
+// unitNanos returns the nanoseconds in a time unit (s, ms, us, ns)
+func unitNanos(unit int16) int64 {
+	switch unit {
+	case 0:
+		return int64(time.Second)
+	case 1:
+		return int64(time.Millisecond)
+	case 2:
+		return int64(time.Microsecond)
+	default:
+		return 1
+	}
+}
+
+// formatTimestamp renders a timestamp: naive ones as 2006-01-02 15:04:05,
+// zoned ones in RFC 3339 in their zone
+func formatTimestamp(v int64, unit int16, timezone string) string {
+	var t time.Time
+	switch unit {
+	case 0:
+		t = time.Unix(v, 0)
+	case 1:
+		t = time.UnixMilli(v)
+	case 2:
+		t = time.UnixMicro(v)
+	default:
+		t = time.Unix(0, v)
+	}
+	if timezone == "" {
+		return t.UTC().Format("2006-01-02 15:04:05.999999999")
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		// A fixed offset like +05:30
+		if offset, perr := time.Parse("-07:00", timezone); perr == nil {
+			_, seconds := offset.Zone()
+			loc = time.FixedZone(timezone, seconds)
+		} else {
+			loc = time.UTC
+		}
+	}
+	return t.In(loc).Format(time.RFC3339Nano)
+}
+
+// decimalValue converts a little-endian two's complement decimal to float
+func decimalValue(b []byte, scale int) float64 {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	n := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	f := new(big.Float).SetInt(n)
+	if scale != 0 {
+		pow := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(scale))), nil))
+		if scale > 0 {
+			f.Quo(f, pow)
+		} else {
+			f.Mul(f, pow)
+		}
+	}
+	v, _ := f.Float64()
+	return v
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// float16ToFloat64 converts an IEEE 754 half-precision value
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * frac / 1024 * math.Pow(2, -14)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	default:
+		return sign * (1 + frac/1024) * math.Pow(2, float64(exp-15))
+	}
+}
+
+// nextBatch reads messages until the next record batch, applying any
+// dictionary batches on the way; it sets done at the end of the stream
+func (s *ArrowScan) nextBatch() error {
+	for {
+		kind, header, body, err := s.readMessage()
+		if err == io.EOF {
+			s.done = true
+			s.batch, s.batchLen, s.row = nil, 0, 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case arrowDictionaryMessage:
+			if err := s.loadDictionary(header, body); err != nil {
+				return err
+			}
+		case arrowRecordBatchMessage:
+			columns, length, err := s.decodeBatch(header, body, s.fields)
+			if err != nil {
+				return err
+			}
+			for _, col := range columns {
+				if col != nil && col.field.dictionary {
+					col.dict = s.dictionaries[col.field.dictID]
+				}
+			}
+			s.batch, s.batchLen, s.row = columns, length, 0
+			return nil
+		default:
+			return fmt.Errorf("unexpected Arrow message type %d", kind)
+		}
+	}
+}
+
+// decodeBatch maps a RecordBatch's buffers onto the given fields
+func (s *ArrowScan) decodeBatch(batch fbTable, body []byte, fields []*arrowField) ([]*arrowColumn, int, error) {
+	length := batch.int64(0, 0)
+	nodes, _, err := batch.structs(1, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	buffers, _, err := batch.structs(2, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor := &batchCursor{nodes: nodes, buffers: buffers, body: body}
+	if compression, compressed := batch.table(3); compressed {
+		if cursor.decode, err = s.bufferDecoder(compression); err != nil {
+			return nil, 0, err
+		}
+	}
+	columns := make([]*arrowColumn, len(fields))
+	for i, field := range fields {
+		if columns[i], err = cursor.take(field); err != nil {
+			return nil, 0, err
+		}
+		if columns[i] != nil && int64(columns[i].length) < length {
+			return nil, 0, errCorruptFlatBuffer
+		}
+	}
+	return columns, int(length), nil
+}
+
+// bufferDecoder returns the function decompressing the buffers of a batch
+// compressed as its BodyCompression table says: each buffer with LZ4
+// frames or ZSTD
+func (s *ArrowScan) bufferDecoder(compression fbTable) (func(dst, src []byte) ([]byte, error), error) {
+	if method := compression.uint8(1, 0); method != 0 {
+		return nil, fmt.Errorf("unsupported Arrow body compression method %d", method)
+	}
+	switch codec := compression.uint8(0, 0); codec {
+	case 0:
+		return lz4DecompressFrames, nil
+	case 1:
+		if s.zstd == nil {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			s.zstd = decoder
+		}
+		return func(dst, src []byte) ([]byte, error) { return s.zstd.DecodeAll(src, dst) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported Arrow compression codec %d", codec)
+	}
+}
+
+// loadDictionary decodes a dictionary batch into the values its indexes
+// refer to; a delta batch extends the existing dictionary
+func (s *ArrowScan) loadDictionary(header fbTable, body []byte) error {
+	id := header.int64(0, 0)
+	var field *arrowField
+	for _, f := range s.fields {
+		if f.dictionary && f.dictID == id {
+			field = f
+		}
+	}
+	if field == nil {
+		return nil // Belongs to a skipped nested column
+	}
+	data, ok := header.table(1)
+	if !ok {
+		return errCorruptFlatBuffer
+	}
+	valueField := *field
+	valueField.dictionary = false
+	columns, length, err := s.decodeBatch(data, body, []*arrowField{&valueField})
+	if err != nil {
+		return fmt.Errorf("dictionary for column %s: %w", field.name, err)
+	}
+	values := make([]interface{}, length)
+	if columns[0] != nil {
+		for i := range values {
+			values[i] = columns[0].value(i)
+		}
+	}
+	if header.uint8(2, 0) != 0 {
+		values = append(s.dictionaries[id], values...)
+	}
+	s.dictionaries[id] = values
+	return nil
+}
+
+// Next returns the next row, moving on to the next batch when one ends
+// Returns (nil, nil) when the file is exhausted
+func (s *ArrowScan) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *ArrowScan) next() (*types.Row, error) {
+	if err := s.cancel.check(); err != nil {
+		return nil, err
+	}
+	for s.row >= s.batchLen {
+		if s.done {
+			return nil, nil
+		}
+		if err := s.nextBatch(); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.filePath, err)
+		}
+	}
+
+	values := make([]interface{}, len(s.output))
+	for j, fieldIndex := range s.output {
+		v := s.batch[fieldIndex].value(s.row)
+		if s.schema.Types[j] != s.natural[j] && v != nil {
+			converted, ok := parseField(valueText(v), s.schema.Types[j])
+			if !ok && s.strict {
+				return nil, fmt.Errorf("%s row %d, column %d (%s): cannot parse %q as %s",
+					s.filePath, s.rows+1, j+1, s.schema.Columns[j], valueText(v), s.schema.Types[j])
+			}
+			v = converted
+		}
+		values[j] = v
+	}
+	s.row++
+	s.rows++
+	return &types.Row{Values: values}, nil
+}
+
+// Close releases resources held by this operator
+func (s *ArrowScan) Close() error {
+	if s.zstd != nil {
+		s.zstd.Close()
+		s.zstd = nil
+	}
+	if s.gzipReader != nil {
+		s.gzipReader.Close()
+	}
+	if s.file != nil {
+		err := s.file.Close()
+		s.file = nil
+		return err
+	}
+	return nil
+}
+
+// Inputs returns nil: the scan reads a file
+func (s *ArrowScan) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the schema of rows produced by this operator
+func (s *ArrowScan) Schema() types.Schema {
+	return s.schema
+}
+
+// TypeSource says whether a column's type was declared or comes from the
+// file's Arrow schema
+func (s *ArrowScan) TypeSource(column int) string {
+	if column >= 0 && column < len(s.declared) && s.declared[column] {
+		return "declared"
+	}
+	if column >= 0 && column < len(s.output) {
+		return "Arrow " + s.fields[s.output[column]].typeName()
+	}
+	return "Arrow schema"
+}
+
+// BytesRead returns the number of bytes read from the underlying file so far
+func (s *ArrowScan) BytesRead() int64 {
+	return s.counter.count
+}
+
+// Explain describes the scan, estimating the row count from the file size
+// and the first batch's bytes per row (unknown for compressed files)
+func (s *ArrowScan) Explain() PlanNode {
+	rows := int64(-1)
+	if s.done && s.batchLen == 0 {
+		rows = 0
+	} else if s.gzipReader == nil && s.fileSize >= 0 && s.rowBytes > 0 {
+		rows = max(s.fileSize/s.rowBytes, 1)
+	}
+
+	details := s.filePath
+	if s.fileSize >= 0 {
+		details += ", " + FormatBytes(s.fileSize)
+	}
+	return PlanNode{
+		Operator:          "ArrowScan",
+		Details:           details,
+		EstimatedRows:     rows,
+		EstimatedRowBytes: s.rowBytes,
+	}
+}
+
+// isArrowPath reports whether a path names an Arrow IPC file or stream
+func isArrowPath(filePath string) bool {
+	name := strings.TrimSuffix(strings.ToLower(filePath), ".gz")
+	for _, ext := range []string{".arrow", ".feather", ".arrows", ".ipc"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
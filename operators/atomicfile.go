@@ -0,0 +1,112 @@
+package operators
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// AtomicFile is an output file that appears whole or not at all: it is
+// written to a temp file next to its target and renamed over the target
+// by Commit, so readers never observe a partial file and a failed write
+// leaves any previous file as it was. COPY, CREATE TABLE AS and the CLI's
+// -output all write through it.
+type AtomicFile struct {
+	path string
+	temp *os.File
+	buf  *bufio.Writer
+	gz   *gzip.Writer
+}
+
+// CreateAtomicFile starts writing path, gzip-compressed if compress is
+// set. The file gets the mode of the file it replaces, or the usual mode
+// of a new file (0666 less the umask).
+func CreateAtomicFile(path string, compress bool) (*AtomicFile, error) {
+	temp, err := createTempSibling(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	f := &AtomicFile{path: path, temp: temp, buf: bufio.NewWriterSize(temp, 1<<16)}
+	if compress {
+		f.gz = gzip.NewWriter(f.buf)
+	}
+	return f, nil
+}
+
+// createTempSibling creates an empty temp file in path's directory.
+// os.CreateTemp always uses mode 0600, so the file is created here with
+// the target's mode instead, letting the umask apply to new files.
+func createTempSibling(path string) (*os.File, error) {
+	perm, keep := fs.FileMode(0666), false
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		perm, keep = info.Mode().Perm(), true
+	}
+	dir, base := filepath.Dir(path), "."+filepath.Base(path)+"."
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, base+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		temp, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) && try < 10000 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The umask may have cleared bits the replaced file had
+		if keep {
+			if err := temp.Chmod(perm); err != nil {
+				temp.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return temp, nil
+	}
+}
+
+// Path returns the target path
+func (f *AtomicFile) Path() string {
+	return f.path
+}
+
+func (f *AtomicFile) Write(p []byte) (int, error) {
+	if f.gz != nil {
+		return f.gz.Write(p)
+	}
+	return f.buf.Write(p)
+}
+
+// Commit finishes the file and renames it over the target. On failure
+// the temp file is removed.
+func (f *AtomicFile) Commit() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			f.Discard()
+			return fmt.Errorf("failed to finish compressed output: %w", err)
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		f.Discard()
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if err := f.temp.Close(); err != nil {
+		os.Remove(f.temp.Name())
+		return fmt.Errorf("failed to close output: %w", err)
+	}
+	if err := os.Rename(f.temp.Name(), f.path); err != nil {
+		os.Remove(f.temp.Name())
+		return fmt.Errorf("failed to move output into place: %w", err)
+	}
+	return nil
+}
+
+// Discard abandons the file, removing the temp file
+func (f *AtomicFile) Discard() {
+	f.temp.Close()
+	os.Remove(f.temp.Name())
+}
@@ -0,0 +1,190 @@
+package operators
+
+import "github.com/aryamaansaha/golap/types"
+
+// unknownType marks a builder column whose schema gives no type
+const unknownType types.DataType = -1
+
+// batchBuilder fills a reused RowBatch a row at a time. Each column is a
+// typed vector for its schema type; a column given a value of another type
+// (which a scan's schema rules out) becomes an AnyVector for the rest of
+// the batch.
+type batchBuilder struct {
+	batch   types.RowBatch
+	types   []types.DataType
+	vectors []types.Vector // Reused typed vectors, one per column
+}
+
+// reset empties the batch for rows of schema
+func (b *batchBuilder) reset(schema types.Schema) {
+	if !b.matches(schema) {
+		b.types = make([]types.DataType, len(schema.Columns))
+		for j := range b.types {
+			b.types[j] = columnType(schema, j)
+		}
+		b.vectors = make([]types.Vector, len(b.types))
+		b.batch.Columns = make([]types.Vector, len(b.types))
+	}
+	for j, dt := range b.types {
+		switch vector := b.vectors[j].(type) {
+		case *types.Int64Vector:
+			vector.Reset()
+		case *types.Float64Vector:
+			vector.Reset()
+		case *types.StringVector:
+			vector.Reset()
+		case *types.AnyVector:
+			*vector = (*vector)[:0]
+		default:
+			b.vectors[j] = newVector(dt)
+		}
+		b.batch.Columns[j] = b.vectors[j]
+	}
+	b.batch.Length = 0
+	b.batch.Selection = nil
+}
+
+// matches reports whether the builder's columns are schema's
+func (b *batchBuilder) matches(schema types.Schema) bool {
+	if len(b.types) != len(schema.Columns) {
+		return false
+	}
+	for j, dt := range b.types {
+		if dt != columnType(schema, j) {
+			return false
+		}
+	}
+	return true
+}
+
+// columnType returns the type of schema's column j, or unknownType if the
+// schema does not give one (the column is then boxed)
+func columnType(schema types.Schema, j int) types.DataType {
+	if j < len(schema.Types) {
+		return schema.Types[j]
+	}
+	return unknownType
+}
+
+// newVector returns an empty vector for values of type dt
+func newVector(dt types.DataType) types.Vector {
+	switch dt {
+	case types.Int:
+		return &types.Int64Vector{}
+	case types.Float:
+		return &types.Float64Vector{}
+	case types.String:
+		return &types.StringVector{}
+	}
+	return &types.AnyVector{}
+}
+
+// full reports whether the batch has BatchSize rows
+func (b *batchBuilder) full() bool {
+	return b.batch.Length == types.BatchSize
+}
+
+// add appends a row; missing values are NULL and extra ones are dropped
+func (b *batchBuilder) add(values []interface{}) {
+	for j := range b.batch.Columns {
+		var value interface{}
+		if j < len(values) {
+			value = values[j]
+		}
+		b.addValue(j, value)
+	}
+	b.batch.Length++
+}
+
+// addValue appends value to column j. The caller counts the row, after
+// adding to every column.
+func (b *batchBuilder) addValue(j int, value interface{}) {
+	switch vector := b.batch.Columns[j].(type) {
+	case *types.Int64Vector:
+		switch v := value.(type) {
+		case int64:
+			vector.Append(v)
+			return
+		case nil:
+			vector.AppendNull()
+			return
+		}
+	case *types.Float64Vector:
+		switch v := value.(type) {
+		case float64:
+			vector.Append(v)
+			return
+		case nil:
+			vector.AppendNull()
+			return
+		}
+	case *types.StringVector:
+		switch v := value.(type) {
+		case string:
+			vector.AppendString(v)
+			return
+		case nil:
+			vector.AppendNull()
+			return
+		}
+	case *types.AnyVector:
+		vector.Append(value)
+		return
+	}
+	b.box(j).Append(value)
+}
+
+// box turns column j into an AnyVector holding the values so far, for a
+// value its typed vector cannot take. The typed vector is kept for the
+// next batch.
+func (b *batchBuilder) box(j int) *types.AnyVector {
+	column := b.batch.Columns[j]
+	boxed := make(types.AnyVector, column.Len(), types.BatchSize)
+	for i := range boxed {
+		boxed[i] = column.Value(i)
+	}
+	b.batch.Columns[j] = &boxed
+	return &boxed
+}
+
+// finish returns the batch, or nil if no rows were added
+func (b *batchBuilder) finish() *types.RowBatch {
+	if b.batch.Length == 0 {
+		return nil
+	}
+	return &b.batch
+}
+
+// batchReader reads an operator's rows in batches: from its NextBatch if
+// it has one, else by collecting rows from Next
+type batchReader struct {
+	input   types.Operator
+	batcher types.BatchOperator
+	builder batchBuilder
+}
+
+// newBatchReader reads batches from input
+func newBatchReader(input types.Operator) *batchReader {
+	batcher, _ := input.(types.BatchOperator)
+	return &batchReader{input: input, batcher: batcher}
+}
+
+// next returns the next batch, or nil after the last
+func (r *batchReader) next() (*types.RowBatch, error) {
+	if r.batcher != nil {
+		return r.batcher.NextBatch()
+	}
+	r.builder.reset(r.input.Schema())
+	for !r.builder.full() {
+		row, err := r.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		r.builder.add(row.Values)
+		types.ReleaseRow(row)
+	}
+	return r.builder.finish(), nil
+}
@@ -0,0 +1,129 @@
+package operators
+
+import (
+	"fmt"
+	"io"
+)
+
+// FileBlock is a block of consecutive rows of a CSV file, from the byte
+// offset of its first row to the next block's (see metadata.ZoneBlock)
+type FileBlock struct {
+	Offset    int64
+	Line      int // Line number of its first row
+	MinValues map[string]int64
+	MaxValues map[string]int64
+}
+
+// FileSize returns the size of the file in bytes, -1 if unknown
+func (s *CSVScan) FileSize() int64 {
+	return s.fileSize
+}
+
+// ReadsAsPlainCSV reports whether the scan reads the whole file as a plain
+// comma-separated file with a header would be read, as zone maps are
+// generated: its rows are the file's rows and its NULLs the empty fields
+// of its non-String columns, so counts in a zone map of the file hold
+func (s *CSVScan) ReadsAsPlainCSV() bool {
+	if s.gzipReader != nil || s.fileSize < 0 || s.baseOffset > 0 {
+		return false
+	}
+	if encoding, _ := ParseEncoding(s.encoding); encoding != EncodingUTF8 {
+		return false
+	}
+	// A strict scan fails on values a count wouldn't read
+	return s.headerNamed && s.delimiter == ',' && len(s.nullValues) == 0 && !s.strict
+}
+
+// skipRange is a byte range of pruned blocks, starting and ending at rows
+type skipRange struct {
+	start, end int64
+	line       int // Line number of the row at end
+}
+
+// PruneBlocks makes the scan seek past the blocks for which skip returns
+// true instead of reading them. skip gets each block's min and max of its
+// Int columns. The blocks must be those of this file as it is now, in
+// file order. Compressed and non-UTF-8 files, which can't seek, are read
+// whole. Rows already sampled are returned even if their block is pruned.
+func (s *CSVScan) PruneBlocks(blocks []FileBlock, skip func(minValues, maxValues map[string]int64) bool) {
+	if s.gzipReader != nil || s.fileSize < 0 {
+		return
+	}
+	if encoding, _ := ParseEncoding(s.encoding); encoding != EncodingUTF8 {
+		return
+	}
+	s.blocks = len(blocks)
+	for i, block := range blocks {
+		if !skip(block.MinValues, block.MaxValues) {
+			continue
+		}
+		s.prunedBlocks++
+		next := skipRange{start: block.Offset, end: s.fileSize}
+		if i+1 < len(blocks) {
+			next.end, next.line = blocks[i+1].Offset, blocks[i+1].Line
+		}
+		if n := len(s.skips); n > 0 && s.skips[n-1].end == next.start {
+			s.skips[n-1].end, s.skips[n-1].line = next.end, next.line
+			continue
+		}
+		s.skips = append(s.skips, next)
+	}
+}
+
+// PrunedBlocks returns how many of the blocks PruneBlocks was given it
+// skips
+func (s *CSVScan) PrunedBlocks() (pruned, blocks int) {
+	return s.prunedBlocks, s.blocks
+}
+
+// skipBlocks seeks past the pruned blocks the reader has reached
+func (s *CSVScan) skipBlocks() error {
+	offset := s.readerOffset + s.reader.InputOffset()
+	for s.skip < len(s.skips) && s.skips[s.skip].end <= offset {
+		s.skip++
+	}
+	if s.skip == len(s.skips) || offset < s.skips[s.skip].start {
+		return nil
+	}
+	skipped := s.skips[s.skip]
+	s.skip++
+	if _, err := s.file.Seek(skipped.end, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek CSV file: %w", err)
+	}
+	s.input.Reset(s.counter)
+	reader := newCSVReader(s.input, s.delimiter)
+	reader.fieldsPerRecord, reader.ragged = s.reader.fieldsPerRecord, s.reader.ragged
+	reader.line = skipped.line - 1
+	s.raggedRows.Add(s.reader.raggedRows)
+	s.reader, s.readerOffset = reader, skipped.end
+	return nil
+}
+
+// skippedBytes returns how many bytes of the file pruned blocks skip
+func (s *CSVScan) skippedBytes() int64 {
+	var skipped int64
+	for _, r := range s.skips {
+		skipped += max(r.end-max(r.start, s.baseOffset), 0)
+	}
+	return skipped
+}
+
+// keptRanges returns the byte ranges of [start, end) outside pruned
+// blocks, with the line number of the row each starts at (0 for start)
+func (s *CSVScan) keptRanges(start, end int64) []skipRange {
+	var kept []skipRange
+	from, line := start, 0
+	for _, skipped := range s.skips {
+		if skipped.end <= from {
+			continue
+		}
+		if skipped.start > from {
+			kept = append(kept, skipRange{start: from, end: min(skipped.start, end), line: line})
+		}
+		from, line = skipped.end, skipped.line
+	}
+	if from < end {
+		kept = append(kept, skipRange{start: from, end: end, line: line})
+	}
+	return kept
+}
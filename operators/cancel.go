@@ -0,0 +1,54 @@
+package operators
+
+import (
+	"context"
+	"io"
+)
+
+// Rows a scan reads between looks at its context
+const cancelCheckRows = 1024
+
+// cancelCheck stops a scan once its context is canceled. Scans are where
+// every plan spends its time reading, so checking there stops a query
+// whatever sits above them; operators that buffer their input then return
+// the error on their first call to Next.
+type cancelCheck struct {
+	ctx  context.Context // nil never cancels
+	rows int
+}
+
+// check returns the context's error every cancelCheckRows calls once it
+// is done, and nil otherwise
+func (c *cancelCheck) check() error {
+	if c.ctx == nil {
+		return nil
+	}
+	c.rows++
+	if c.rows < cancelCheckRows {
+		return nil
+	}
+	c.rows = 0
+	return c.err()
+}
+
+// err returns why the context was canceled, or nil if it wasn't
+func (c *cancelCheck) err() error {
+	if c.ctx == nil || c.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(c.ctx)
+}
+
+// contextReader fails reads once its context is canceled, so merge and
+// partition passes over temp files stop too
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, context.Cause(r.ctx)
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,126 @@
+package operators
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Collation is how text compares in WHERE, ORDER BY, GROUP BY, DISTINCT,
+// UNION, MIN and MAX: byte by byte (the zero value), ignoring case, or by
+// a language's rules, under which "apple" sorts before "Banana" and
+// accents after the letters they mark. Text that compares equal (Apple
+// and apple, ignoring case) is one GROUP BY group or DISTINCT row. Safe
+// for concurrent use.
+type Collation struct {
+	name      string     // "" for binary
+	caseless  bool       // Ignoring case
+	collators *sync.Pool // Of *localeCollator, for a language
+}
+
+// BinaryCollation compares text byte by byte, as Go strings compare
+var BinaryCollation = Collation{}
+
+// localeCollator is a collator with its key buffer; neither may be shared
+// between goroutines, so a Collation pools them
+type localeCollator struct {
+	collator *collate.Collator
+	buf      collate.Buffer
+}
+
+// ParseCollation converts a collation name to a Collation: binary (or ""),
+// nocase (or case-insensitive, ci), or a BCP 47 language tag such as en,
+// de or sv for that language's rules
+func ParseCollation(name string) (Collation, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "", "binary":
+		return BinaryCollation, nil
+	case "nocase", "case-insensitive", "ci":
+		return Collation{name: "nocase", caseless: true}, nil
+	}
+	tag, err := language.Parse(name)
+	if err != nil {
+		return Collation{}, fmt.Errorf("unknown collation %q (use binary, nocase or a language tag such as en)", name)
+	}
+	if _, _, confidence := language.NewMatcher(collate.Supported()).Match(tag); confidence == language.No {
+		return Collation{}, fmt.Errorf("no collation for language %q", name)
+	}
+	return Collation{name: tag.String(), collators: &sync.Pool{
+		New: func() interface{} { return &localeCollator{collator: collate.New(tag)} },
+	}}, nil
+}
+
+// String returns the collation's name, as ParseCollation reads it
+func (c Collation) String() string {
+	if c.name == "" {
+		return "binary"
+	}
+	return c.name
+}
+
+// IsBinary reports whether text compares byte by byte
+func (c Collation) IsBinary() bool {
+	return c.name == ""
+}
+
+// Compare orders two strings: negative if a sorts before b, 0 if they are
+// equal under the collation
+func (c Collation) Compare(a, b string) int {
+	switch {
+	case c.caseless:
+		return compareCaseless(a, b)
+	case c.collators != nil:
+		lc := c.collators.Get().(*localeCollator)
+		defer c.collators.Put(lc)
+		return lc.collator.CompareString(a, b)
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// AppendKey appends a key for s that is the same for strings Compare
+// finds equal and different otherwise, for hashing and GROUP BY
+func (c Collation) AppendKey(key []byte, s string) []byte {
+	switch {
+	case c.caseless:
+		for _, r := range s {
+			key = utf8.AppendRune(key, foldRune(r))
+		}
+		return key
+	case c.collators != nil:
+		lc := c.collators.Get().(*localeCollator)
+		defer c.collators.Put(lc)
+		lc.buf.Reset()
+		return append(key, lc.collator.KeyFromString(&lc.buf, s)...)
+	default:
+		return append(key, s...)
+	}
+}
+
+// compareCaseless compares strings rune by rune with case folded
+func compareCaseless(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if fa, fb := foldRune(ra), foldRune(rb); fa != fb {
+			if fa < fb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) - len(b)
+}
+
+// foldRune maps a rune to the lower case of its upper case, so runes that
+// differ only in case (k, K and the Kelvin sign K) fold alike
+func foldRune(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
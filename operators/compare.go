@@ -0,0 +1,128 @@
+package operators
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Comparison rules, shared by filters, sorts, merges, DISTINCT and
+// MIN/MAX: ints and floats compare as numbers, exactly (an int is never
+// rounded to a float to compare it with one), and text compares byte-wise.
+// A number and text are incomparable: a filter comparing them at run time
+// is Unknown, as for NULL, and a sort fails (the planner rejects the ones
+// it can see coming). Where a total order is needed all the same (DISTINCT,
+// MIN/MAX) NULL sorts first, then numbers, then text.
+
+// compareValues orders two values by the rules above: negative if a sorts
+// before b, 0 if they are equal
+func compareValues(a, b interface{}) int {
+	if c, ok := compareNulls(a, b); ok {
+		return c
+	}
+	if c, ok := compareNumbers(a, b); ok {
+		return c
+	}
+	as, aText := a.(string)
+	bs, bText := b.(string)
+	switch {
+	case aText && bText:
+		return strings.Compare(as, bs)
+	case aText:
+		return 1
+	case bText:
+		return -1
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// compareCollated is compareValues with text compared by collation
+func compareCollated(a, b interface{}, collation Collation) int {
+	if !collation.IsBinary() {
+		if as, ok := a.(string); ok {
+			if bs, ok := b.(string); ok {
+				return collation.Compare(as, bs)
+			}
+		}
+	}
+	return compareValues(a, b)
+}
+
+// compareNulls orders NULL before any non-NULL value
+// Returns ok=false when neither value is NULL
+func compareNulls(a, b interface{}) (int, bool) {
+	switch {
+	case a == nil && b == nil:
+		return 0, true
+	case a == nil:
+		return -1, true
+	case b == nil:
+		return 1, true
+	default:
+		return 0, false
+	}
+}
+
+// compareNumbers compares two numbers exactly; ok=false unless both are
+// numbers. NaN sorts before every other number, as in cmp.Compare.
+func compareNumbers(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case int64:
+		switch bv := b.(type) {
+		case int64:
+			return cmp.Compare(av, bv), true
+		case float64:
+			return compareIntFloat(av, bv), true
+		}
+	case float64:
+		switch bv := b.(type) {
+		case int64:
+			return -compareIntFloat(bv, av), true
+		case float64:
+			return cmp.Compare(av, bv), true
+		}
+	}
+	return 0, false
+}
+
+// compareIntFloat compares an int with a float without rounding the int
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f) || f < math.MinInt64:
+		return 1
+	case f >= math.MaxInt64: // 2^63, as a float
+		return -1
+	}
+	whole := math.Trunc(f)
+	if c := cmp.Compare(i, int64(whole)); c != 0 {
+		return c
+	}
+	return cmp.Compare(whole, f) // f's fraction decides
+}
+
+// incomparable reports whether two non-NULL values are a number and text
+func incomparable(a, b interface{}) bool {
+	_, aText := a.(string)
+	_, bText := b.(string)
+	return aText != bText && a != nil && b != nil
+}
+
+// incomparableError describes a comparison of a number with text
+func incomparableError(a, b interface{}) error {
+	return fmt.Errorf("cannot compare %s with %s", describeValue(a), describeValue(b))
+}
+
+// describeValue names a value's type along with the value, e.g. String "5"
+func describeValue(v interface{}) string {
+	switch val := v.(type) {
+	case int64:
+		return fmt.Sprintf("Int %d", val)
+	case float64:
+		return fmt.Sprintf("Float %v", val)
+	case string:
+		return fmt.Sprintf("String %q", val)
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
@@ -0,0 +1,105 @@
+package operators
+
+import (
+	"github.com/aryamaansaha/golap/types"
+)
+
+// CompileComparisonPredicate builds a column-vs-literal predicate
+// specialized at plan time for the column's type, the comparator and the
+// literal: the literal is converted once and each row costs one type
+// assertion and one comparison, instead of compare()'s type switches and
+// comparator switch. Rows whose value isn't of the column's type (NULL,
+// or a mismatched value) fall back to the generic comparison, so results
+// are identical to BuildComparisonPredicate.
+func CompileComparisonPredicate(comp Comparison, columnType types.DataType) Predicate {
+	generic := BuildComparisonPredicate(comp)
+	if comp.ColumnIndex < 0 || comp.Value == nil {
+		return generic
+	}
+
+	// Only literals the column's values compare with as they are: an Int
+	// column against a float, say, is compared exactly by the generic one
+	switch literal := comp.Value.(type) {
+	case int64:
+		switch columnType {
+		case types.Int:
+			return compiledComparison(comp.ColumnIndex, comp.Comparator, literal, generic)
+		case types.Float:
+			if f := float64(literal); f > -(1<<53) && f < 1<<53 {
+				return compiledComparison(comp.ColumnIndex, comp.Comparator, f, generic)
+			}
+		}
+	case float64:
+		if columnType == types.Float {
+			return compiledComparison(comp.ColumnIndex, comp.Comparator, literal, generic)
+		}
+	case string:
+		if columnType == types.String && comp.Collation.IsBinary() {
+			return compiledComparison(comp.ColumnIndex, comp.Comparator, literal, generic)
+		}
+	}
+	return generic
+}
+
+// compiledComparison returns a closure comparing column index against a
+// literal with the comparator fixed; values of another type use fallback
+func compiledComparison[T int64 | float64 | string](index int, comp types.Comparator, literal T, fallback Predicate) Predicate {
+	switch comp {
+	case types.Eq:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v == literal)
+				}
+			}
+			return fallback(row)
+		}
+	case types.Neq:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v != literal)
+				}
+			}
+			return fallback(row)
+		}
+	case types.Lt:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v < literal)
+				}
+			}
+			return fallback(row)
+		}
+	case types.Lte:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v <= literal)
+				}
+			}
+			return fallback(row)
+		}
+	case types.Gt:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v > literal)
+				}
+			}
+			return fallback(row)
+		}
+	case types.Gte:
+		return func(row *types.Row) types.Truth {
+			if index < len(row.Values) {
+				if v, ok := row.Values[index].(T); ok {
+					return toTruth(v >= literal)
+				}
+			}
+			return fallback(row)
+		}
+	default:
+		return fallback
+	}
+}
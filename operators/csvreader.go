@@ -0,0 +1,370 @@
+package operators
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// RaggedRowPolicy is what a CSV scan does with a record whose number of
+// fields differs from the header's (or, without a header, the first
+// record's)
+type RaggedRowPolicy int
+
+const (
+	RaggedRowsError RaggedRowPolicy = iota // Fail the scan, naming the line
+	RaggedRowsSkip                         // Leave the record out, counting it
+	RaggedRowsPad                          // Read missing fields as NULL and drop extra ones, counting it
+)
+
+func (p RaggedRowPolicy) String() string {
+	switch p {
+	case RaggedRowsSkip:
+		return "skip"
+	case RaggedRowsPad:
+		return "pad"
+	default:
+		return "error"
+	}
+}
+
+// ParseRaggedRowPolicy converts a policy name (error, skip or pad; any
+// case) to a RaggedRowPolicy; "" means error
+func ParseRaggedRowPolicy(name string) (RaggedRowPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "error":
+		return RaggedRowsError, nil
+	case "skip":
+		return RaggedRowsSkip, nil
+	case "pad":
+		return RaggedRowsPad, nil
+	default:
+		return 0, fmt.Errorf("unknown ragged row policy %q (use error, skip or pad)", name)
+	}
+}
+
+// csvReader splits CSV text into records the way encoding/csv does
+// (RFC 4180 quoting, "" escapes, CRLF or LF line ends, empty lines
+// skipped, the same *csv.ParseError errors) without allocating per record.
+// Fields are byte slices into the reader's buffers: a line without quotes
+// is split in place, and only records with quoted fields are copied to
+// unescape them. A record is valid until the next Read.
+type csvReader struct {
+	input *bufio.Reader // Nil when reading data in memory
+	data  []byte        // In-memory input (never written to, so it may be mmapped)
+	pos   int           // Next byte of data
+
+	comma           []byte          // The delimiter, UTF-8 encoded
+	fieldsPerRecord int             // Fields every record must have; 0 = set from the first, -1 = any
+	ragged          RaggedRowPolicy // For records without fieldsPerRecord fields
+	raggedRows      int64           // Records skipped or padded by ragged
+
+	offset    int64  // Input bytes consumed
+	line      int    // Lines read
+	rawBuffer []byte // A line longer than input's buffer
+	unescaped []byte // Fields of a record with quotes
+	record    csvRecord
+}
+
+// newCSVReader reads records from buffered input
+func newCSVReader(input *bufio.Reader, comma rune) *csvReader {
+	return &csvReader{input: input, comma: utf8.AppendRune(nil, comma)}
+}
+
+// newCSVBytesReader reads records from data, which it never modifies
+func newCSVBytesReader(data []byte, comma rune) *csvReader {
+	return &csvReader{data: data, comma: utf8.AppendRune(nil, comma)}
+}
+
+// InputOffset returns the input offset just past the last record read
+func (r *csvReader) InputOffset() int64 {
+	return r.offset
+}
+
+// Read returns the next record, or io.EOF after the last one. A record
+// with the wrong number of fields is an error, or is skipped or truncated
+// to fieldsPerRecord by the ragged row policy (a short one is returned as
+// it is; the scan reads its missing fields as NULL).
+func (r *csvReader) Read() (*csvRecord, error) {
+	for {
+		record, err := r.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case r.fieldsPerRecord == 0:
+			r.fieldsPerRecord = record.len()
+		case r.fieldsPerRecord < 0 || record.len() == r.fieldsPerRecord:
+		case r.ragged == RaggedRowsSkip:
+			r.raggedRows++
+			continue
+		case r.ragged == RaggedRowsPad:
+			r.raggedRows++
+			record.truncate(r.fieldsPerRecord)
+		default:
+			recLine := record.line(0)
+			return nil, &csv.ParseError{StartLine: recLine, Line: recLine, Column: 1, Err: csv.ErrFieldCount}
+		}
+		return record, nil
+	}
+}
+
+// readRecord returns the next record, whatever its number of fields
+func (r *csvReader) readRecord() (*csvRecord, error) {
+	var line []byte
+	var nl bool
+	for {
+		var err error
+		line, nl, err = r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 0 {
+			break
+		}
+		// Skip empty lines
+	}
+
+	record := &r.record
+	record.reset()
+	recLine := r.line
+	if bytes.IndexByte(line, '"') < 0 {
+		// No quotes: the fields are the line's pieces between delimiters
+		record.buf = line
+		start := 0
+		for {
+			i := r.indexComma(line[start:])
+			if i < 0 {
+				record.add(start, len(line), recLine)
+				break
+			}
+			record.add(start, start+i, recLine)
+			start += i + len(r.comma)
+		}
+	} else if err := r.readQuoted(line, nl, recLine); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// readQuoted parses a record containing quotes, starting with its first
+// line, copying field contents to unescaped. Quoted fields may span lines.
+func (r *csvReader) readQuoted(line []byte, nl bool, recLine int) error {
+	record := &r.record
+	buf := r.unescaped[:0]
+	defer func() {
+		r.unescaped = buf
+		record.buf = buf
+	}()
+
+	lineNum, col := recLine, 1
+	for {
+		if len(line) == 0 || line[0] != '"' {
+			// Unquoted field; a quote inside it is an error
+			field := line
+			i := r.indexComma(line)
+			if i >= 0 {
+				field = line[:i]
+			}
+			if j := bytes.IndexByte(field, '"'); j >= 0 {
+				return &csv.ParseError{StartLine: recLine, Line: r.line, Column: col + j, Err: csv.ErrBareQuote}
+			}
+			start := len(buf)
+			buf = append(buf, field...)
+			record.add(start, len(buf), lineNum)
+			if i < 0 {
+				return nil
+			}
+			line = line[i+len(r.comma):]
+			col += i + len(r.comma)
+			continue
+		}
+
+		// Quoted field: up to a quote followed by a delimiter or line end
+		fieldLine, start := lineNum, len(buf)
+		line = line[1:]
+		col++
+	quoted:
+		for {
+			i := bytes.IndexByte(line, '"')
+			switch {
+			case i >= 0:
+				buf = append(buf, line[:i]...)
+				line = line[i+1:]
+				col += i + 1
+				switch {
+				case len(line) > 0 && line[0] == '"': // Escaped quote
+					buf = append(buf, '"')
+					line = line[1:]
+					col++
+				case bytes.HasPrefix(line, r.comma):
+					line = line[len(r.comma):]
+					col += len(r.comma)
+					record.add(start, len(buf), fieldLine)
+					break quoted
+				case len(line) == 0: // End of the record
+					record.add(start, len(buf), fieldLine)
+					return nil
+				default:
+					return &csv.ParseError{StartLine: recLine, Line: r.line, Column: col - 1, Err: csv.ErrQuote}
+				}
+			case nl:
+				// The field goes on past the end of the line
+				buf = append(buf, line...)
+				buf = append(buf, '\n')
+				col += len(line) + 1
+				next, nextNL, err := r.readLine()
+				if err == io.EOF {
+					return &csv.ParseError{StartLine: recLine, Line: lineNum, Column: col, Err: csv.ErrQuote}
+				}
+				if err != nil {
+					return err
+				}
+				line, nl = next, nextNL
+				lineNum, col = lineNum+1, 1
+			default:
+				// The input ends inside the quotes
+				buf = append(buf, line...)
+				col += len(line)
+				return &csv.ParseError{StartLine: recLine, Line: lineNum, Column: col, Err: csv.ErrQuote}
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its LF or CRLF, and whether it
+// had one (only the last line may not), or io.EOF at the end of the input
+func (r *csvReader) readLine() ([]byte, bool, error) {
+	var line []byte
+	if r.input == nil {
+		if r.pos >= len(r.data) {
+			return nil, false, io.EOF
+		}
+		end := len(r.data)
+		if i := bytes.IndexByte(r.data[r.pos:], '\n'); i >= 0 {
+			end = r.pos + i + 1
+		}
+		line = r.data[r.pos:end]
+		r.pos = end
+	} else {
+		var err error
+		line, err = r.input.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			r.rawBuffer = append(r.rawBuffer[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = r.input.ReadSlice('\n')
+				r.rawBuffer = append(r.rawBuffer, line...)
+			}
+			line = r.rawBuffer
+		}
+		if len(line) == 0 || (err != nil && err != io.EOF) {
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, false, err
+		}
+	}
+
+	r.line++
+	r.offset += int64(len(line))
+	n := len(line)
+	if line[n-1] == '\n' {
+		n--
+		if n > 0 && line[n-1] == '\r' {
+			n--
+		}
+		return line[:n], true, nil
+	}
+	if line[n-1] == '\r' { // Dropped before EOF, as encoding/csv does
+		n--
+	}
+	return line[:n], false, nil
+}
+
+// indexComma returns the offset of the first delimiter in b, or -1
+func (r *csvReader) indexComma(b []byte) int {
+	if len(r.comma) == 1 {
+		return bytes.IndexByte(b, r.comma[0])
+	}
+	return bytes.Index(b, r.comma)
+}
+
+// csvRecord is a record's fields as byte ranges of a buffer
+type csvRecord struct {
+	buf    []byte
+	bounds []int // Start and end of each field in buf
+	lines  []int // Line each field starts on
+	text   string
+	isText bool // text holds buf
+}
+
+// reset empties the record for reuse
+func (r *csvRecord) reset() {
+	r.buf = nil
+	r.bounds = r.bounds[:0]
+	r.lines = r.lines[:0]
+	r.text, r.isText = "", false
+}
+
+// add appends the field buf[start:end], starting on line
+func (r *csvRecord) add(start, end, line int) {
+	r.bounds = append(r.bounds, start, end)
+	r.lines = append(r.lines, line)
+}
+
+// setStrings makes the record hold fields, all on line
+func (r *csvRecord) setStrings(fields []string, line int) {
+	r.reset()
+	var buf []byte
+	for _, field := range fields {
+		start := len(buf)
+		buf = append(buf, field...)
+		r.add(start, len(buf), line)
+	}
+	r.buf = buf
+}
+
+// truncate drops the fields after the first n
+func (r *csvRecord) truncate(n int) {
+	if n < r.len() {
+		r.bounds, r.lines = r.bounds[:2*n], r.lines[:n]
+	}
+}
+
+// len returns the number of fields
+func (r *csvRecord) len() int {
+	return len(r.lines)
+}
+
+// field returns field i's bytes, valid as long as the record
+func (r *csvRecord) field(i int) []byte {
+	return r.buf[r.bounds[2*i]:r.bounds[2*i+1]]
+}
+
+// str returns field i as a string. The first call copies the record's
+// buffer into one string that all its fields then share, so a row's text
+// columns cost one allocation.
+func (r *csvRecord) str(i int) string {
+	if !r.isText {
+		r.text, r.isText = string(r.buf), true
+	}
+	return r.text[r.bounds[2*i]:r.bounds[2*i+1]]
+}
+
+// line returns the line field i starts on
+func (r *csvRecord) line(i int) int {
+	return r.lines[i]
+}
+
+// strings copies the record's fields
+func (r *csvRecord) strings() []string {
+	fields := make([]string, r.len())
+	for i := range fields {
+		fields[i] = r.str(i)
+	}
+	return fields
+}
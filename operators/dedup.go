@@ -0,0 +1,111 @@
+package operators
+
+import (
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DedupOp keeps the first row of each run of rows with equal key values
+// Input must be sorted by the key columns so duplicates are adjacent; sort
+// ties by a sequence column first to choose which duplicate survives.
+// NULL key values compare equal to each other.
+type DedupOp struct {
+	execStats
+
+	input      types.Operator
+	keyIndices []int
+	lastKey    []interface{} // Key values of the last emitted row
+}
+
+// NewDedupOp creates an operator that drops adjacent duplicate keys
+func NewDedupOp(input types.Operator, keyIndices []int) *DedupOp {
+	return &DedupOp{
+		input:      input,
+		keyIndices: keyIndices,
+	}
+}
+
+// Next returns the next row whose key differs from the previous one
+func (d *DedupOp) Next() (*types.Row, error) {
+	start := d.startCall()
+	row, err := d.next()
+	d.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (d *DedupOp) next() (*types.Row, error) {
+	for {
+		row, err := d.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return nil, nil
+		}
+
+		if d.lastKey != nil && d.sameKey(row) {
+			continue
+		}
+
+		// Copy the key; upstream operators may reuse row buffers
+		key := make([]interface{}, len(d.keyIndices))
+		for i, idx := range d.keyIndices {
+			key[i] = row.Values[idx]
+		}
+		d.lastKey = key
+		return row, nil
+	}
+}
+
+// sameKey reports whether row has the same key as the last emitted row
+func (d *DedupOp) sameKey(row *types.Row) bool {
+	for i, idx := range d.keyIndices {
+		if compareValues(d.lastKey[i], row.Values[idx]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Close releases resources
+func (d *DedupOp) Close() error {
+	return d.input.Close()
+}
+
+// SortOrder returns the input's order, which dropping rows keeps
+func (d *DedupOp) SortOrder() []SortKey {
+	return SortOrder(d.input)
+}
+
+// Inputs returns the sorted input
+func (d *DedupOp) Inputs() []types.Operator {
+	return []types.Operator{d.input}
+}
+
+// Schema returns the schema (unchanged from input)
+func (d *DedupOp) Schema() types.Schema {
+	return d.input.Schema()
+}
+
+// Explain describes the key columns
+func (d *DedupOp) Explain() PlanNode {
+	schema := d.input.Schema()
+	keys := make([]string, len(d.keyIndices))
+	for i, idx := range d.keyIndices {
+		keys[i] = "?"
+		if idx >= 0 && idx < len(schema.Columns) {
+			keys[i] = schema.Columns[idx]
+		}
+	}
+
+	child := ExplainOperator(d.input)
+	return PlanNode{
+		Operator:          "Dedup",
+		Details:           "key " + strings.Join(keys, ", "),
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		Children:          []PlanNode{child},
+	}
+}
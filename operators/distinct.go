@@ -0,0 +1,354 @@
+package operators
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DefaultDistinctMemoryRows is how many distinct rows an exact DistinctOp
+// keeps in memory before spilling new ones to disk
+const DefaultDistinctMemoryRows = 100000
+
+// Spilled rows are split into 2^distinctPartitionBits files per pass, each
+// pass using the next bits of the 63-bit row hash
+const (
+	distinctPartitionBits = 4
+	distinctPartitions    = 1 << distinctPartitionBits
+	maxDistinctLevel      = 63/distinctPartitionBits - 1
+)
+
+// The approximate mode's Bloom filter: 2^26 bits (8MB) and 4 probes keep
+// false positives under 1% up to about 7 million distinct rows
+const (
+	approxDistinctBits   = 1 << 26
+	approxDistinctProbes = 4
+)
+
+// DistinctOptions tunes a DistinctOp
+type DistinctOptions struct {
+	MemoryRows  int             // Distinct rows held in memory before spilling (0 = DefaultDistinctMemoryRows)
+	TempQuota   *TempSpaceQuota // Optional per-query limit on spill bytes
+	Spill       SpillOptions    // Where partitions are written, and whether compressed
+	Approximate bool            // Fixed-memory Bloom filter instead; never spills, may drop distinct rows
+	Collation   Collation       // Which texts are duplicates (the zero value: equal bytes)
+}
+
+// DistinctOp removes duplicate rows, comparing every column (NULLs equal
+// each other, and text by the collation, so ignoring case Foo duplicates
+// foo). It streams: a row is returned as soon as it is first seen.
+//
+// Exact mode remembers up to MemoryRows distinct rows in a hash set. Once
+// the set is full, rows it doesn't hold are hash-partitioned into temp files
+// rather than returned, and after the input ends each partition gets its
+// own pass with a fresh set (partitioning again if it's still too big).
+// A spilled row can't equal a row held in memory, so no row is returned
+// twice; spilled rows just come out after the others.
+//
+// Approximate mode keeps only a Bloom filter of row hashes: memory is fixed
+// and nothing spills, but a false positive drops a row that wasn't a
+// duplicate.
+type DistinctOp struct {
+	execStats
+
+	input      types.Operator
+	schema     types.Schema
+	memoryRows int
+	tempQuota  *TempSpaceQuota
+	spillOpts  SpillOptions
+	approx     bool
+	collation  Collation
+
+	// Exact mode
+	seen       map[uint64][][]interface{} // Row hash -> distinct rows with that hash
+	seenRows   int
+	level      int               // Pass depth: 0 reads the input, deeper passes read partitions
+	partitions []*spillPartition // This pass's spill files, by partition number
+	pending    []*spillPartition // Spilled partitions still to deduplicate
+	current    *spillPartition   // Partition being read by this pass (nil = input)
+	tempFiles  []string
+	record     []string // Reused to spill a row
+
+	// Approximate mode
+	bloom []uint64
+}
+
+// NewDistinctOp creates an exact DISTINCT with default memory settings
+func NewDistinctOp(input types.Operator) *DistinctOp {
+	return NewDistinctOpWithOptions(input, DistinctOptions{})
+}
+
+// NewDistinctOpWithOptions creates a DISTINCT with custom memory/spill settings
+func NewDistinctOpWithOptions(input types.Operator, opts DistinctOptions) *DistinctOp {
+	memoryRows := opts.MemoryRows
+	if memoryRows <= 0 {
+		memoryRows = DefaultDistinctMemoryRows
+	}
+	return &DistinctOp{
+		input:      input,
+		schema:     input.Schema(),
+		memoryRows: memoryRows,
+		tempQuota:  opts.TempQuota,
+		spillOpts:  opts.Spill,
+		approx:     opts.Approximate,
+		collation:  opts.Collation,
+		seen:       make(map[uint64][][]interface{}),
+	}
+}
+
+// Next returns the next row not returned before
+func (d *DistinctOp) Next() (*types.Row, error) {
+	start := d.startCall()
+	row, err := d.next()
+	d.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (d *DistinctOp) next() (*types.Row, error) {
+	if d.approx {
+		return d.nextApproximate()
+	}
+
+	for {
+		row, err := d.nextSourceRow()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			more, err := d.nextPass()
+			if err != nil || !more {
+				return nil, err
+			}
+			continue
+		}
+
+		hash := hashCollatedRow(row.Values, d.collation)
+		if d.contains(hash, row.Values) {
+			types.ReleaseRow(row)
+			continue
+		}
+		// The deepest pass has no hash bits left to split on, so it keeps
+		// everything in memory
+		if d.seenRows < d.memoryRows || d.level >= maxDistinctLevel {
+			// Copy the values; upstream operators may reuse row buffers
+			d.seen[hash] = append(d.seen[hash], append([]interface{}(nil), row.Values...))
+			d.seenRows++
+			d.holdMemory(int64(d.seenRows) * int64(groupOverheadBytes+len(row.Values)*groupKeyValueBytes))
+			return row, nil
+		}
+		if err := d.spill(hash, row); err != nil {
+			return nil, err
+		}
+		types.ReleaseRow(row)
+	}
+}
+
+// nextSourceRow reads from the input, or from the partition this pass is
+// deduplicating
+func (d *DistinctOp) nextSourceRow() (*types.Row, error) {
+	if d.current == nil {
+		row, err := d.input.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error reading input for distinct: %w", err)
+		}
+		return row, nil
+	}
+
+	record, err := d.current.reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from temp file: %w", err)
+	}
+	return spilledRow(record)
+}
+
+// contains reports whether an equal row is already in the in-memory set
+func (d *DistinctOp) contains(hash uint64, values []interface{}) bool {
+	for _, seen := range d.seen[hash] {
+		if sameValues(seen, values, d.collation) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValues reports whether two rows hold equal values in every column,
+// text compared by collation
+func sameValues(a, b []interface{}, collation Collation) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if compareCollated(a[i], b[i], collation) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// spill writes a row to this pass's partition for its hash
+func (d *DistinctOp) spill(hash uint64, row *types.Row) error {
+	if d.partitions == nil {
+		d.partitions = make([]*spillPartition, distinctPartitions)
+	}
+	n := (hash >> (d.level * distinctPartitionBits)) % distinctPartitions
+
+	part := d.partitions[n]
+	if part == nil {
+		var err error
+		part, err = d.spillOpts.newPartition("golap_distinct_*.csv", d.level+1, d.tempQuota, &d.spilled)
+		if err != nil {
+			return err
+		}
+		// Track the file right away so Close removes it even if writing fails
+		d.tempFiles = append(d.tempFiles, part.path)
+		d.partitions[n] = part
+	}
+
+	d.record = appendSpillRecord(d.record[:0], row.Values)
+	if err := part.writer.Write(d.record); err != nil {
+		return fmt.Errorf("failed to write to temp file: %w", err)
+	}
+	return nil
+}
+
+// nextPass ends the current pass, queueing its spill files, and starts
+// reading the next pending partition; returns false once none are left
+func (d *DistinctOp) nextPass() (bool, error) {
+	if d.current != nil {
+		d.current.file.Close()
+		os.Remove(d.current.path)
+		d.current = nil
+	}
+
+	for _, part := range d.partitions {
+		if part == nil {
+			continue
+		}
+		if err := part.finish(); err != nil {
+			return false, err
+		}
+		d.pending = append(d.pending, part)
+	}
+	d.partitions = nil
+
+	if len(d.pending) == 0 {
+		return false, nil
+	}
+	next := d.pending[len(d.pending)-1]
+	d.pending = d.pending[:len(d.pending)-1]
+
+	if err := next.open(d.spillOpts); err != nil {
+		return false, err
+	}
+	d.current = next
+	d.level = next.level
+	d.seen = make(map[uint64][][]interface{})
+	d.seenRows = 0
+	return true, nil
+}
+
+// nextApproximate returns the next row whose hash isn't in the Bloom filter
+func (d *DistinctOp) nextApproximate() (*types.Row, error) {
+	if d.bloom == nil {
+		d.bloom = make([]uint64, approxDistinctBits/64)
+		d.holdMemory(approxDistinctBits / 8)
+	}
+	for {
+		row, err := d.input.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error reading input for distinct: %w", err)
+		}
+		if row == nil {
+			return nil, nil
+		}
+		if d.bloomAdd(hashCollatedRow(row.Values, d.collation)) {
+			return row, nil
+		}
+	}
+}
+
+// bloomAdd sets the filter bits for a hash, reporting whether any was
+// unset (i.e. the row is definitely new)
+func (d *DistinctOp) bloomAdd(hash uint64) bool {
+	// Double hashing: probe i is h1 + i*h2
+	h1, h2 := hash, mix64(hash)|1
+	added := false
+	for i := uint64(0); i < approxDistinctProbes; i++ {
+		bit := (h1 + i*h2) % approxDistinctBits
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if d.bloom[word]&mask == 0 {
+			d.bloom[word] |= mask
+			added = true
+		}
+	}
+	return added
+}
+
+// Close releases resources and deletes temp files
+func (d *DistinctOp) Close() error {
+	if err := d.input.Close(); err != nil {
+		return err
+	}
+
+	for _, part := range append(d.partitions, d.current) {
+		if part != nil && part.file != nil {
+			part.file.Close()
+		}
+	}
+	for _, path := range d.tempFiles {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// Inputs returns the deduplicated input
+func (d *DistinctOp) Inputs() []types.Operator {
+	return []types.Operator{d.input}
+}
+
+// Schema returns the schema (unchanged from input)
+func (d *DistinctOp) Schema() types.Schema {
+	return d.schema
+}
+
+// Explain describes the mode and predicts the spill: at most the rows
+// beyond the in-memory limit are written once by the first pass
+func (d *DistinctOp) Explain() PlanNode {
+	child := ExplainOperator(d.input)
+	collation := ""
+	if !d.collation.IsBinary() {
+		collation = ", collation=" + d.collation.String()
+	}
+
+	if d.approx {
+		return PlanNode{
+			Operator:          "Distinct",
+			Details:           fmt.Sprintf("approximate, bloom filter %s%s", FormatBytes(approxDistinctBits/8), collation),
+			EstimatedRows:     child.EstimatedRows,
+			EstimatedRowBytes: child.EstimatedRowBytes,
+			Children:          []PlanNode{child},
+		}
+	}
+
+	spill := int64(-1)
+	if child.EstimatedRows >= 0 && child.EstimatedRows <= int64(d.memoryRows) {
+		spill = 0
+	} else if child.EstimatedRows >= 0 && child.EstimatedRowBytes >= 0 {
+		spill = (child.EstimatedRows - int64(d.memoryRows)) * child.EstimatedRowBytes
+	}
+
+	return PlanNode{
+		Operator:          "Distinct",
+		Details:           fmt.Sprintf("exact, memory=%d rows%s", d.memoryRows, collation),
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		SpillBytes:        spill,
+		Children:          []PlanNode{child},
+	}
+}
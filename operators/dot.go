@@ -0,0 +1,76 @@
+package operators
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dotNode is an operator's box in a Graphviz rendering of a plan
+type dotNode struct {
+	label    []string // Lines: name, details, metrics
+	children []dotNode
+}
+
+// Dot renders the subtree as a Graphviz digraph, one box per operator
+// with an edge to each of its inputs, for `dot -Tsvg`
+func (n PlanNode) Dot() []string {
+	return dotLines(n.dotNode())
+}
+
+func (n PlanNode) dotNode() dotNode {
+	node := dotNode{label: dotLabel(n.Operator, n.Details, n.metrics())}
+	for _, child := range n.Children {
+		node.children = append(node.children, child.dotNode())
+	}
+	return node
+}
+
+// Dot renders the subtree as Dot does a PlanNode, with the actual counts
+func (s OperatorStats) Dot() []string {
+	return dotLines(s.dotNode())
+}
+
+func (s OperatorStats) dotNode() dotNode {
+	node := dotNode{label: dotLabel(s.Operator, s.Details, s.metrics())}
+	for _, child := range s.Children {
+		node.children = append(node.children, child.dotNode())
+	}
+	return node
+}
+
+// dotLabel puts the name, details and metrics on lines of their own
+func dotLabel(operator, details string, metrics []string) []string {
+	label := []string{operator}
+	if details != "" {
+		label = append(label, details)
+	}
+	if len(metrics) > 0 {
+		label = append(label, strings.Join(metrics, " "))
+	}
+	return label
+}
+
+// dotLines renders a tree of boxes, numbered n0, n1, ... in plan order
+func dotLines(root dotNode) []string {
+	lines := []string{
+		"digraph plan {",
+		`  node [shape=box, fontname="monospace"];`,
+	}
+	id := 0
+	var add func(node dotNode) int
+	add = func(node dotNode) int {
+		self := id
+		id++
+		escaped := make([]string, len(node.label))
+		for i, line := range node.label {
+			escaped[i] = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(line)
+		}
+		lines = append(lines, fmt.Sprintf(`  n%d [label="%s"];`, self, strings.Join(escaped, `\n`)))
+		for _, child := range node.children {
+			lines = append(lines, fmt.Sprintf("  n%d -> n%d;", self, add(child)))
+		}
+		return self
+	}
+	add(root)
+	return append(lines, "}")
+}
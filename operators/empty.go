@@ -0,0 +1,49 @@
+package operators
+
+import (
+	"github.com/aryamaansaha/golap/types"
+)
+
+// EmptyOp produces no rows while keeping its input's schema
+// Used when the planner proves (e.g. via zone maps) that no row can match
+type EmptyOp struct {
+	execStats
+
+	input types.Operator
+}
+
+// NewEmptyOp creates an operator that never pulls from its input
+func NewEmptyOp(input types.Operator) *EmptyOp {
+	return &EmptyOp{input: input}
+}
+
+// Next always reports end of input
+func (e *EmptyOp) Next() (*types.Row, error) {
+	return nil, nil
+}
+
+// Close releases resources
+func (e *EmptyOp) Close() error {
+	return e.input.Close()
+}
+
+// Inputs returns the pruned input, which is never read
+func (e *EmptyOp) Inputs() []types.Operator {
+	return []types.Operator{e.input}
+}
+
+// Schema returns the schema (unchanged from input)
+func (e *EmptyOp) Schema() types.Schema {
+	return e.input.Schema()
+}
+
+// Explain describes the pruned input
+func (e *EmptyOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "Empty",
+		Details:           "pruned",
+		EstimatedRows:     0,
+		EstimatedRowBytes: 0,
+		Children:          []PlanNode{ExplainOperator(e.input)},
+	}
+}
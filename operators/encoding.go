@@ -0,0 +1,104 @@
+package operators
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Text encodings a scan can decode, by canonical name
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingLatin1      = "latin1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// windows1252 maps the bytes 0x80-0x9F, where Windows-1252 differs from
+// Latin-1; the five bytes it leaves undefined keep their Latin-1 meaning
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// ParseEncoding returns the canonical name of a text encoding: utf-8 (also
+// "" and utf8), latin1 (iso-8859-1) or windows-1252 (cp1252)
+func ParseEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return EncodingLatin1, nil
+	case "windows-1252", "cp1252":
+		return EncodingWindows1252, nil
+	default:
+		return "", fmt.Errorf("unsupported encoding %q (use utf-8, latin1 or windows-1252)", name)
+	}
+}
+
+// decodeInput wraps r to convert text in the given encoding to UTF-8
+// UTF-8 input is returned as is.
+func decodeInput(r io.Reader, encoding string) (io.Reader, error) {
+	canonical, err := ParseEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	if canonical == EncodingUTF8 {
+		return r, nil
+	}
+	return &singleByteDecoder{reader: r, windows: canonical == EncodingWindows1252}, nil
+}
+
+// singleByteDecoder converts a single-byte encoding (Latin-1 or
+// Windows-1252) to UTF-8 as it is read
+type singleByteDecoder struct {
+	reader  io.Reader
+	windows bool
+	raw     []byte
+	pending []byte // Decoded bytes that didn't fit the caller's buffer
+}
+
+// Read fills p with decoded UTF-8
+func (d *singleByteDecoder) Read(p []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+
+	// Each byte decodes to at most 3 UTF-8 bytes
+	want := len(p) / utf8.UTFMax
+	if want == 0 {
+		want = 1
+	}
+	if cap(d.raw) < want {
+		d.raw = make([]byte, want)
+	}
+	n, err := d.reader.Read(d.raw[:want])
+
+	out := p[:0]
+	var spill []byte
+	for _, b := range d.raw[:n] {
+		r := rune(b)
+		if d.windows && b >= 0x80 && b <= 0x9F {
+			r = windows1252[b-0x80]
+		}
+		if len(out)+utf8.RuneLen(r) <= len(p) {
+			out = utf8.AppendRune(out, r)
+		} else {
+			spill = utf8.AppendRune(spill, r)
+		}
+	}
+	d.pending = spill
+	if len(d.pending) > 0 && err == io.EOF {
+		err = nil // Report EOF once the pending bytes are read
+	}
+	if len(out) == 0 && len(spill) > 0 {
+		m := copy(p, d.pending)
+		d.pending = d.pending[m:]
+		return m, err
+	}
+	return len(out), err
+}
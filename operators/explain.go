@@ -0,0 +1,124 @@
+package operators
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// PlanNode describes one operator in an EXPLAIN plan
+// Estimates are derived without reading the data (a CSV scan's from its
+// file size and sampled row width), so they can be off either way; -1
+// means unknown
+type PlanNode struct {
+	Operator          string     `json:"operator"`
+	Details           string     `json:"details,omitempty"`
+	EstimatedRows     int64      `json:"estimated_rows"`
+	EstimatedRowBytes int64      `json:"estimated_row_bytes"`
+	SpillBytes        int64      `json:"spill_bytes"` // Predicted temp space written by this operator
+	Children          []PlanNode `json:"children,omitempty"`
+}
+
+// Explainer is implemented by operators that can describe themselves
+type Explainer interface {
+	Explain() PlanNode
+}
+
+// ExplainOperator returns the plan node for any operator
+func ExplainOperator(op types.Operator) PlanNode {
+	if e, ok := op.(Explainer); ok {
+		return e.Explain()
+	}
+	return PlanNode{
+		Operator:          fmt.Sprintf("%T", op),
+		EstimatedRows:     -1,
+		EstimatedRowBytes: -1,
+	}
+}
+
+// TotalSpillBytes sums predicted spill over the whole subtree
+// Returns -1 if any spilling operator's prediction is unknown
+func (n PlanNode) TotalSpillBytes() int64 {
+	total := n.SpillBytes
+	if total < 0 {
+		return -1
+	}
+	for _, child := range n.Children {
+		childTotal := child.TotalSpillBytes()
+		if childTotal < 0 {
+			return -1
+		}
+		total += childTotal
+	}
+	return total
+}
+
+// Lines renders the subtree as indented text, one operator per line
+func (n PlanNode) Lines() []string {
+	var lines []string
+	n.appendLines(&lines, 0)
+	return lines
+}
+
+func (n PlanNode) appendLines(lines *[]string, depth int) {
+	var b strings.Builder
+	b.WriteString(strings.Repeat("  ", depth))
+	if depth > 0 {
+		b.WriteString("-> ")
+	}
+	b.WriteString(n.Operator)
+	if n.Details != "" {
+		b.WriteString(" (" + n.Details + ")")
+	}
+	for _, metric := range n.metrics() {
+		b.WriteString(" " + metric)
+	}
+	*lines = append(*lines, b.String())
+
+	for _, child := range n.Children {
+		child.appendLines(lines, depth+1)
+	}
+}
+
+// metrics are the estimates shown after the operator's name and details
+func (n PlanNode) metrics() []string {
+	var metrics []string
+	if n.EstimatedRows >= 0 {
+		metrics = append(metrics, fmt.Sprintf("rows~%d", n.EstimatedRows))
+	}
+	if n.SpillBytes > 0 {
+		metrics = append(metrics, "spill~"+FormatBytes(n.SpillBytes))
+	} else if n.SpillBytes < 0 {
+		metrics = append(metrics, "spill=unknown")
+	}
+	return metrics
+}
+
+// FormatBytes renders a byte count in human-readable units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// minEstimate returns the smaller known estimate (-1 = unknown)
+func minEstimate(a, b int64) int64 {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,135 @@
+package operators
+
+import (
+	"math"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ValueExpr computes a scalar value from a row, e.g. price * qty
+// Returns nil for NULL
+type ValueExpr func(*types.Row) interface{}
+
+// ColumnExpr returns the value of a column
+func ColumnExpr(columnIndex int) ValueExpr {
+	return func(row *types.Row) interface{} {
+		if columnIndex < 0 || columnIndex >= len(row.Values) {
+			return nil
+		}
+		return row.Values[columnIndex]
+	}
+}
+
+// LiteralExpr returns a constant value
+func LiteralExpr(value interface{}) ValueExpr {
+	return func(*types.Row) interface{} {
+		return value
+	}
+}
+
+// ArithmeticExpr applies an arithmetic operator to two expressions
+// Int op Int stays Int (except division, which is always Float);
+// anything involving a Float is Float. NULL or non-numeric operands and
+// division by zero yield NULL.
+func ArithmeticExpr(op types.ArithmeticOp, left, right ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		l := left(row)
+		r := right(row)
+		if l == nil || r == nil {
+			return nil
+		}
+
+		lInt, lIsInt := l.(int64)
+		rInt, rIsInt := r.(int64)
+		if lIsInt && rIsInt && op != types.Div {
+			return arithmeticInt64(op, lInt, rInt)
+		}
+
+		lFloat, ok := toFloat64(l)
+		if !ok {
+			return nil
+		}
+		rFloat, ok := toFloat64(r)
+		if !ok {
+			return nil
+		}
+		return arithmeticFloat64(op, lFloat, rFloat)
+	}
+}
+
+func arithmeticInt64(op types.ArithmeticOp, left, right int64) interface{} {
+	switch op {
+	case types.Add:
+		return left + right
+	case types.Sub:
+		return left - right
+	case types.Mul:
+		return left * right
+	case types.Mod:
+		if right == 0 {
+			return nil
+		}
+		return left % right
+	default:
+		return nil
+	}
+}
+
+func arithmeticFloat64(op types.ArithmeticOp, left, right float64) interface{} {
+	switch op {
+	case types.Add:
+		return left + right
+	case types.Sub:
+		return left - right
+	case types.Mul:
+		return left * right
+	case types.Div:
+		if right == 0 {
+			return nil
+		}
+		return left / right
+	case types.Mod:
+		if right == 0 {
+			return nil
+		}
+		return math.Mod(left, right)
+	default:
+		return nil
+	}
+}
+
+// NegateExpr returns the arithmetic negation of an expression (unary minus)
+func NegateExpr(expr ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		switch v := expr(row).(type) {
+		case int64:
+			return -v
+		case float64:
+			return -v
+		default:
+			return nil
+		}
+	}
+}
+
+// CaseWhen is a single WHEN ... THEN ... branch of a CASE expression
+type CaseWhen struct {
+	Condition Predicate
+	Result    ValueExpr
+}
+
+// CaseExpr evaluates to the result of the first branch whose condition is
+// True, or to elseExpr (NULL if nil) when none match
+func CaseExpr(whens []CaseWhen, elseExpr ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		for _, when := range whens {
+			if when.Condition(row) == types.True {
+				return when.Result(row)
+			}
+		}
+		if elseExpr == nil {
+			return nil
+		}
+		return elseExpr(row)
+	}
+}
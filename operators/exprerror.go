@@ -0,0 +1,109 @@
+package operators
+
+import (
+	"sync/atomic"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ExprErrors holds the first error an expression hit while a query ran.
+// A ValueExpr can't return an error, so one that fails (a UDF returning
+// an error) records it here and returns NULL, and the check at the root
+// of the plan (see NewExprErrorCheck) fails the query. Safe for the
+// workers of a parallel scan.
+type ExprErrors struct {
+	err atomic.Pointer[error]
+}
+
+// Set records err unless an earlier error was recorded
+func (e *ExprErrors) Set(err error) {
+	e.err.CompareAndSwap(nil, &err)
+}
+
+// Err returns the recorded error, or nil
+func (e *ExprErrors) Err() error {
+	if err := e.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// exprErrorCheck fails the query once its expressions have recorded an
+// error. It isn't a step of the plan: EXPLAIN and stats show its input.
+type exprErrorCheck struct {
+	input types.Operator
+	errs  *ExprErrors
+}
+
+// NewExprErrorCheck wraps the root of a plan so the error its expressions
+// record in errs is returned from the next call to Next
+func NewExprErrorCheck(input types.Operator, errs *ExprErrors) types.Operator {
+	return &exprErrorCheck{input: input, errs: errs}
+}
+
+// Next returns the input's next row, or the recorded error
+func (c *exprErrorCheck) Next() (*types.Row, error) {
+	row, err := c.input.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.errs.Err(); err != nil {
+		types.ReleaseRow(row)
+		return nil, err
+	}
+	return row, nil
+}
+
+// Close releases resources
+func (c *exprErrorCheck) Close() error {
+	return c.input.Close()
+}
+
+// Schema returns the schema (unchanged from input)
+func (c *exprErrorCheck) Schema() types.Schema {
+	return c.input.Schema()
+}
+
+// Explain describes the input
+func (c *exprErrorCheck) Explain() PlanNode {
+	return ExplainOperator(c.input)
+}
+
+// Stats returns the input's counters, so CollectStats sees the input
+func (c *exprErrorCheck) Stats() OperatorStats {
+	if reporter, ok := c.input.(StatsReporter); ok {
+		return reporter.Stats()
+	}
+	return OperatorStats{RowsIn: -1, RowsOut: -1}
+}
+
+// Inputs returns the input's inputs
+func (c *exprErrorCheck) Inputs() []types.Operator {
+	if reporter, ok := c.input.(StatsReporter); ok {
+		return reporter.Inputs()
+	}
+	return nil
+}
+
+// SetTiming times the input's Next calls
+func (c *exprErrorCheck) SetTiming(on bool) {
+	if reporter, ok := c.input.(StatsReporter); ok {
+		reporter.SetTiming(on)
+	}
+}
+
+// SortOrder returns the input's sort order
+func (c *exprErrorCheck) SortOrder() []SortKey {
+	return SortOrder(c.input)
+}
+
+// BytesRead returns the bytes the input read, if it is a scan
+func (c *exprErrorCheck) BytesRead() int64 {
+	return bytesRead(c.input)
+}
+
+// RaggedRows returns the records the input skipped or padded, if it is a
+// CSV scan
+func (c *exprErrorCheck) RaggedRows() int64 {
+	return raggedRows(c.input)
+}
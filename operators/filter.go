@@ -2,30 +2,61 @@ package operators
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/aryamaansaha/golap/types"
 )
 
-// Predicate is a function that evaluates whether a row passes the filter
-type Predicate func(*types.Row) bool
+// Predicate evaluates a row under SQL three-valued logic
+// Only rows evaluating to True pass a filter
+type Predicate func(*types.Row) types.Truth
 
 // FilterOp filters rows based on a predicate (WHERE clause)
 type FilterOp struct {
-	input     types.Operator
-	predicate Predicate
+	execStats
+
+	input       types.Operator
+	predicate   Predicate
+	selectivity float64 // Estimated fraction of rows kept; < 0 if unknown
+
+	batches   *batchReader // Input batches, once NextBatch is used
+	selection []int        // Rows of the current batch that pass
+	row       types.Row    // The batch row the predicate is evaluated on
+	columns   []int        // Columns the predicate reads; nil if not known
 }
 
 // NewFilterOp creates a new filter operator
 func NewFilterOp(input types.Operator, predicate Predicate) *FilterOp {
+	return NewFilterOpWithSelectivity(input, predicate, -1)
+}
+
+// NewFilterOpWithSelectivity creates a filter whose expected pass rate is
+// known from statistics, for EXPLAIN row estimates
+func NewFilterOpWithSelectivity(input types.Operator, predicate Predicate, selectivity float64) *FilterOp {
 	return &FilterOp{
-		input:     input,
-		predicate: predicate,
+		input:       input,
+		predicate:   predicate,
+		selectivity: selectivity,
 	}
 }
 
+// SetColumns tells the filter which columns its predicate reads, so
+// NextBatch copies only those out of a batch for each row
+func (f *FilterOp) SetColumns(columns []int) {
+	f.columns = columns
+}
+
 // Next returns the next row that passes the predicate
-// Rows that fail the predicate are skipped
+// Rows evaluating to False or Unknown are skipped
 func (f *FilterOp) Next() (*types.Row, error) {
+	start := f.startCall()
+	row, err := f.next()
+	f.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (f *FilterOp) next() (*types.Row, error) {
 	for {
 		row, err := f.input.Next()
 		if err != nil {
@@ -35,10 +66,50 @@ func (f *FilterOp) Next() (*types.Row, error) {
 			return nil, nil // End of input
 		}
 
-		if f.predicate(row) {
+		if f.predicate(row) == types.True {
 			return row, nil
 		}
-		// Row failed predicate, continue to next
+		types.ReleaseRow(row) // Row failed predicate, continue to next
+	}
+}
+
+// NextBatch returns the next batch of the input with the rows that pass
+// the predicate selected, skipping batches in which none do
+func (f *FilterOp) NextBatch() (*types.RowBatch, error) {
+	start := f.startCall()
+	batch, err := f.nextBatch()
+	f.endCall(start, batchRows(batch))
+	return batch, err
+}
+
+// nextBatch is NextBatch, uncounted
+func (f *FilterOp) nextBatch() (*types.RowBatch, error) {
+	if f.batches == nil {
+		f.batches = newBatchReader(f.input)
+		f.selection = make([]int, 0, types.BatchSize)
+	}
+	for {
+		batch, err := f.batches.next()
+		if err != nil || batch == nil {
+			return nil, err
+		}
+		selection := f.selection[:0]
+		for k := range batch.Rows() {
+			i := batch.Position(k)
+			if f.columns != nil {
+				batch.RowColumns(i, &f.row, f.columns)
+			} else {
+				batch.Row(i, &f.row)
+			}
+			if f.predicate(&f.row) == types.True {
+				selection = append(selection, i)
+			}
+		}
+		f.selection = selection
+		if len(selection) > 0 {
+			batch.Selection = selection
+			return batch, nil
+		}
 	}
 }
 
@@ -47,6 +118,16 @@ func (f *FilterOp) Close() error {
 	return f.input.Close()
 }
 
+// SortOrder returns the input's order, which filtering keeps
+func (f *FilterOp) SortOrder() []SortKey {
+	return SortOrder(f.input)
+}
+
+// Inputs returns the filtered input
+func (f *FilterOp) Inputs() []types.Operator {
+	return []types.Operator{f.input}
+}
+
 // Schema returns the schema (unchanged from input)
 func (f *FilterOp) Schema() types.Schema {
 	return f.input.Schema()
@@ -57,63 +138,93 @@ type Comparison struct {
 	ColumnIndex int
 	Comparator  types.Comparator
 	Value       interface{} // int64, float64, or string
+	Collation   Collation   // How text compares (the zero value: byte by byte)
 }
 
 // BuildComparisonPredicate creates a predicate from a comparison
 func BuildComparisonPredicate(comp Comparison) Predicate {
-	return func(row *types.Row) bool {
+	return func(row *types.Row) types.Truth {
 		if comp.ColumnIndex < 0 || comp.ColumnIndex >= len(row.Values) {
-			return false
+			return types.False
 		}
 
 		rowVal := row.Values[comp.ColumnIndex]
-		return compare(rowVal, comp.Comparator, comp.Value)
+		return compare(rowVal, comp.Comparator, comp.Value, comp.Collation)
 	}
 }
 
-// compare performs the comparison based on the comparator type
-func compare(left interface{}, comp types.Comparator, right interface{}) bool {
-	// Handle integer comparisons
-	if leftInt, ok := left.(int64); ok {
-		rightInt, ok := toInt64(right)
-		if !ok {
-			return false
-		}
-		return compareInt64(leftInt, comp, rightInt)
+// BuildExprComparisonPredicate compares two computed expressions
+// (e.g. the operand and a WHEN value of a simple CASE)
+func BuildExprComparisonPredicate(left ValueExpr, comp types.Comparator, right ValueExpr) Predicate {
+	return BuildCollatedExprComparisonPredicate(left, comp, right, BinaryCollation)
+}
+
+// BuildCollatedExprComparisonPredicate is BuildExprComparisonPredicate
+// with text compared by collation
+func BuildCollatedExprComparisonPredicate(left ValueExpr, comp types.Comparator, right ValueExpr, collation Collation) Predicate {
+	return func(row *types.Row) types.Truth {
+		return compare(left(row), comp, right(row), collation)
 	}
+}
 
-	// Handle float comparisons
-	if leftFloat, ok := left.(float64); ok {
-		rightFloat, ok := toFloat64(right)
-		if !ok {
-			return false
+// BuildNullCheckPredicate creates an IS NULL (or IS NOT NULL) predicate
+// Unlike comparisons, a null check is never Unknown
+func BuildNullCheckPredicate(columnIndex int, isNull bool) Predicate {
+	return func(row *types.Row) types.Truth {
+		if columnIndex < 0 || columnIndex >= len(row.Values) {
+			return types.False
+		}
+		if (row.Values[columnIndex] == nil) == isNull {
+			return types.True
 		}
-		return compareFloat64(leftFloat, comp, rightFloat)
+		return types.False
 	}
+}
 
-	// Handle string comparisons
-	if leftStr, ok := left.(string); ok {
-		rightStr, ok := right.(string)
-		if !ok {
-			rightStr = fmt.Sprintf("%v", right)
+// compare performs the comparison based on the comparator type, by the
+// rules of compareValues with text compared by collation. Any comparison
+// against NULL is Unknown, and so is one of a number with text.
+func compare(left interface{}, comp types.Comparator, right interface{}, collation Collation) types.Truth {
+	if left == nil || right == nil {
+		return types.Unknown
+	}
+
+	switch l := left.(type) {
+	case int64:
+		if r, ok := right.(int64); ok {
+			return toTruth(compareInt64(l, comp, r))
+		}
+	case float64:
+		if r, ok := right.(float64); ok {
+			return toTruth(compareFloat64(l, comp, r))
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			if !collation.IsBinary() {
+				return toTruth(compareInt64(int64(collation.Compare(l, r)), comp, 0))
+			}
+			return toTruth(compareString(l, comp, r))
 		}
-		return compareString(leftStr, comp, rightStr)
+		return types.Unknown
 	}
 
-	return false
+	// An int and a float, compared exactly (NaN compares as a float)
+	lf, lok := toFloat64(left)
+	rf, rok := toFloat64(right)
+	if lok && rok && (math.IsNaN(lf) || math.IsNaN(rf)) {
+		return toTruth(compareFloat64(lf, comp, rf))
+	}
+	if c, ok := compareNumbers(left, right); ok {
+		return toTruth(compareInt64(int64(c), comp, 0))
+	}
+	return types.Unknown
 }
 
-func toInt64(v interface{}) (int64, bool) {
-	switch val := v.(type) {
-	case int64:
-		return val, true
-	case int:
-		return int64(val), true
-	case float64:
-		return int64(val), true
-	default:
-		return 0, false
+func toTruth(b bool) types.Truth {
+	if b {
+		return types.True
 	}
+	return types.False
 }
 
 func toFloat64(v interface{}) (float64, bool) {
@@ -187,13 +298,59 @@ func compareString(left string, comp types.Comparator, right string) bool {
 }
 
 // AndPredicate combines multiple predicates with AND logic
+// Short-circuits on False; otherwise Unknown if any side is Unknown
 func AndPredicate(predicates ...Predicate) Predicate {
-	return func(row *types.Row) bool {
+	return func(row *types.Row) types.Truth {
+		result := types.True
+		for _, p := range predicates {
+			result = result.And(p(row))
+			if result == types.False {
+				return types.False
+			}
+		}
+		return result
+	}
+}
+
+// OrPredicate combines multiple predicates with OR logic
+// Short-circuits on True; otherwise Unknown if any side is Unknown
+func OrPredicate(predicates ...Predicate) Predicate {
+	return func(row *types.Row) types.Truth {
+		result := types.False
 		for _, p := range predicates {
-			if !p(row) {
-				return false
+			result = result.Or(p(row))
+			if result == types.True {
+				return types.True
 			}
 		}
-		return true
+		return result
+	}
+}
+
+// NotPredicate negates a predicate; NOT Unknown stays Unknown
+func NotPredicate(predicate Predicate) Predicate {
+	return func(row *types.Row) types.Truth {
+		return predicate(row).Not()
+	}
+}
+
+// Explain describes the filter; its row estimate is the input's (upper
+// bound), scaled by the selectivity when statistics provide one
+func (f *FilterOp) Explain() PlanNode {
+	child := ExplainOperator(f.input)
+	rows := child.EstimatedRows
+	details := ""
+	if f.selectivity >= 0 {
+		details = fmt.Sprintf("selectivity=%.4g", f.selectivity)
+		if rows > 0 {
+			rows = int64(math.Ceil(float64(rows) * f.selectivity))
+		}
+	}
+	return PlanNode{
+		Operator:          "Filter",
+		Details:           details,
+		EstimatedRows:     rows,
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		Children:          []PlanNode{child},
 	}
 }
@@ -0,0 +1,167 @@
+package operators
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// fbTable is a table in a FlatBuffers buffer, the encoding of Arrow IPC
+// metadata. Only reading is needed: a table starts with an offset to its
+// vtable, which gives each field's position in the table (0 if absent).
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+// errCorruptFlatBuffer reports metadata that points outside its buffer
+var errCorruptFlatBuffer = fmt.Errorf("corrupt Arrow metadata")
+
+// fbRoot returns the root table of a buffer
+func fbRoot(buf []byte) (fbTable, error) {
+	if len(buf) < 4 {
+		return fbTable{}, errCorruptFlatBuffer
+	}
+	t := fbTable{buf: buf, pos: int(binary.LittleEndian.Uint32(buf))}
+	if _, err := t.vtable(); err != nil {
+		return fbTable{}, err
+	}
+	return t, nil
+}
+
+// vtable returns the position of the table's vtable
+func (t fbTable) vtable() (int, error) {
+	if t.pos < 0 || t.pos+4 > len(t.buf) {
+		return 0, errCorruptFlatBuffer
+	}
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if vt < 0 || vt+4 > len(t.buf) {
+		return 0, errCorruptFlatBuffer
+	}
+	return vt, nil
+}
+
+// field returns the position of field i in the buffer, or 0 if it's absent
+func (t fbTable) field(i int) int {
+	vt, err := t.vtable()
+	if err != nil {
+		return 0
+	}
+	size := int(binary.LittleEndian.Uint16(t.buf[vt:]))
+	entry := vt + 4 + 2*i
+	if 4+2*i+2 > size || entry+2 > len(t.buf) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[entry:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+// scalar returns the n bytes of field i, or nil if it's absent
+func (t fbTable) scalar(i, n int) []byte {
+	pos := t.field(i)
+	if pos == 0 || pos+n > len(t.buf) {
+		return nil
+	}
+	return t.buf[pos : pos+n]
+}
+
+func (t fbTable) uint8(i int, def uint8) uint8 {
+	if b := t.scalar(i, 1); b != nil {
+		return b[0]
+	}
+	return def
+}
+
+func (t fbTable) int16(i int, def int16) int16 {
+	if b := t.scalar(i, 2); b != nil {
+		return int16(binary.LittleEndian.Uint16(b))
+	}
+	return def
+}
+
+func (t fbTable) int32(i int, def int32) int32 {
+	if b := t.scalar(i, 4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return def
+}
+
+func (t fbTable) int64(i int, def int64) int64 {
+	if b := t.scalar(i, 8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return def
+}
+
+// indirect follows the offset stored in field i
+func (t fbTable) indirect(i int) (int, bool) {
+	pos := t.field(i)
+	if pos == 0 || pos+4 > len(t.buf) {
+		return 0, false
+	}
+	target := pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	if target+4 > len(t.buf) {
+		return 0, false
+	}
+	return target, true
+}
+
+// table returns the table field i points to
+func (t fbTable) table(i int) (fbTable, bool) {
+	pos, ok := t.indirect(i)
+	if !ok {
+		return fbTable{}, false
+	}
+	sub := fbTable{buf: t.buf, pos: pos}
+	if _, err := sub.vtable(); err != nil {
+		return fbTable{}, false
+	}
+	return sub, true
+}
+
+// vector returns the position of the first element of the vector field i
+// points to, and its length
+func (t fbTable) vector(i int) (start, length int) {
+	pos, ok := t.indirect(i)
+	if !ok {
+		return 0, 0
+	}
+	return pos + 4, int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+// string returns the string field i points to
+func (t fbTable) string(i int) string {
+	start, length := t.vector(i)
+	if start+length > len(t.buf) {
+		return ""
+	}
+	return string(t.buf[start : start+length])
+}
+
+// tables returns the tables of a vector of tables
+func (t fbTable) tables(i int) ([]fbTable, error) {
+	start, length := t.vector(i)
+	if start+4*length > len(t.buf) {
+		return nil, errCorruptFlatBuffer
+	}
+	tables := make([]fbTable, length)
+	for j := range tables {
+		pos := start + 4*j
+		tables[j] = fbTable{buf: t.buf, pos: pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))}
+		if _, err := tables[j].vtable(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// structs returns the raw bytes of a vector of fixed-size structs
+func (t fbTable) structs(i, size int) ([]byte, int, error) {
+	start, length := t.vector(i)
+	if start+size*length > len(t.buf) {
+		return nil, 0, errCorruptFlatBuffer
+	}
+	return t.buf[start : start+size*length], length, nil
+}
@@ -0,0 +1,383 @@
+package operators
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// A .golap file is golap's own columnar format: rows are stored in row
+// groups of up to GolapRowGroupRows rows, each holding one chunk per
+// column, so scanning it decodes typed binary values instead of parsing
+// CSV text. Layout:
+//
+//	magic | row group | row group | ... | footer (JSON) | footer length (uint32 LE) | magic
+//
+// The footer holds the schema and, for every row group, its offset and
+// the encoding, NULL count and min/max of each column chunk. A chunk is
+// a NULL bitmap (only when it has NULLs; bit i set = row i is NULL)
+// followed by the values of its non-NULL rows in one of three encodings:
+//
+//	plain: each value in turn
+//	rle:   runs of (uvarint run length, value)
+//	dict:  uvarint dictionary size, the distinct values, then runs of
+//	       (uvarint run length, uvarint dictionary index)
+//
+// Values are int64 or float64 bits (8 bytes, little endian) or uvarint
+// length-prefixed strings. The writer picks the smallest encoding per
+// chunk.
+const golapMagic = "GOLAP\x00v1"
+
+// golapVersion is the footer version this build reads and writes
+const golapVersion = 1
+
+// GolapRowGroupRows is the number of rows per .golap row group
+const GolapRowGroupRows = 65536
+
+// Column chunk encodings
+const (
+	golapPlain = "plain"
+	golapRLE   = "rle"
+	golapDict  = "dict"
+)
+
+// golapFooter is the metadata at the end of a .golap file
+type golapFooter struct {
+	Version   int             `json:"version"`
+	Rows      int64           `json:"rows"`
+	Columns   []golapColumn   `json:"columns"`
+	RowGroups []golapRowGroup `json:"row_groups"`
+}
+
+// golapColumn is a column of the file's schema
+type golapColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // Int, Float or String
+}
+
+// golapRowGroup locates a row group; its chunks follow each other in
+// column order starting at Offset
+type golapRowGroup struct {
+	Offset int64        `json:"offset"`
+	Length int64        `json:"length"`
+	Rows   int          `json:"rows"`
+	Chunks []golapChunk `json:"chunks"`
+}
+
+// golapChunk describes one column of a row group. Min and Max cover the
+// non-NULL values and are left out when there are none (or a float chunk
+// holds NaN or infinities, which JSON can't represent).
+type golapChunk struct {
+	Length   int64           `json:"length"`
+	Encoding string          `json:"encoding"`
+	Nulls    int             `json:"nulls,omitempty"`
+	Min      json.RawMessage `json:"min,omitempty"`
+	Max      json.RawMessage `json:"max,omitempty"`
+}
+
+var errCorruptGolap = errors.New("corrupt .golap file")
+
+// isGolapPath reports whether a file should be read as a .golap file
+func isGolapPath(filePath string) bool {
+	return strings.HasSuffix(strings.ToLower(filePath), ".golap")
+}
+
+// IsGolapPath reports whether a path names a .golap file, which COPY and
+// CREATE TABLE AS write in the columnar format instead of CSV
+func IsGolapPath(filePath string) bool {
+	return isGolapPath(filePath)
+}
+
+// encodeGolapChunk encodes one column of a row group, choosing the
+// smallest encoding, and returns the bytes with the chunk's footer entry
+func encodeGolapChunk(values []interface{}, dt types.DataType) ([]byte, golapChunk) {
+	var chunk golapChunk
+	var bitmap []byte
+	present := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		if v == nil {
+			if bitmap == nil {
+				bitmap = make([]byte, (len(values)+7)/8)
+			}
+			bitmap[i/8] |= 1 << (i % 8)
+			chunk.Nulls++
+			continue
+		}
+		present = append(present, v)
+	}
+	chunk.Min, chunk.Max = golapMinMax(present, dt)
+
+	// Runs of equal values, and the distinct values in first-seen order
+	var runValues []interface{}
+	var runLengths []int
+	dictIndex := make(map[interface{}]int)
+	var dict []interface{}
+	for _, v := range present {
+		if n := len(runValues); n > 0 && golapEqual(runValues[n-1], v) {
+			runLengths[n-1]++
+		} else {
+			runValues = append(runValues, v)
+			runLengths = append(runLengths, 1)
+		}
+		if _, ok := dictIndex[golapKey(v)]; !ok && len(dict) <= len(present)/2 {
+			dictIndex[golapKey(v)] = len(dict)
+			dict = append(dict, v)
+		}
+	}
+
+	plain := appendGolapValues(nil, present, dt)
+	chunk.Encoding, chunk.Length = golapPlain, int64(len(plain))
+	best := plain
+
+	var rle []byte
+	for i, v := range runValues {
+		rle = binary.AppendUvarint(rle, uint64(runLengths[i]))
+		rle = appendGolapValue(rle, v, dt)
+	}
+	if len(rle) < len(best) {
+		chunk.Encoding, best = golapRLE, rle
+	}
+
+	if len(dict) <= len(present)/2 {
+		encoded := binary.AppendUvarint(nil, uint64(len(dict)))
+		encoded = appendGolapValues(encoded, dict, dt)
+		for i, v := range runValues {
+			encoded = binary.AppendUvarint(encoded, uint64(runLengths[i]))
+			encoded = binary.AppendUvarint(encoded, uint64(dictIndex[golapKey(v)]))
+		}
+		if len(encoded) < len(best) {
+			chunk.Encoding, best = golapDict, encoded
+		}
+	}
+
+	data := append(bitmap, best...)
+	chunk.Length = int64(len(data))
+	return data, chunk
+}
+
+// golapKey returns a map key for a value; floats are keyed by their bits
+// so NaN finds itself and 0 and -0 stay apart
+func golapKey(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		return math.Float64bits(f)
+	}
+	return v
+}
+
+// golapEqual reports whether two non-NULL values of a column encode the same
+func golapEqual(a, b interface{}) bool {
+	return golapKey(a) == golapKey(b)
+}
+
+// golapMinMax returns the JSON min and max of a chunk's non-NULL values
+func golapMinMax(values []interface{}, dt types.DataType) (json.RawMessage, json.RawMessage) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	minValue, maxValue := values[0], values[0]
+	for _, v := range values[1:] {
+		switch dt {
+		case types.Int:
+			minValue, maxValue = min(minValue.(int64), v.(int64)), max(maxValue.(int64), v.(int64))
+		case types.Float:
+			minValue, maxValue = min(minValue.(float64), v.(float64)), max(maxValue.(float64), v.(float64))
+		default:
+			minValue, maxValue = min(minValue.(string), v.(string)), max(maxValue.(string), v.(string))
+		}
+	}
+	minJSON, err := json.Marshal(minValue)
+	if err != nil {
+		return nil, nil // NaN or ±Inf
+	}
+	maxJSON, err := json.Marshal(maxValue)
+	if err != nil {
+		return nil, nil
+	}
+	return minJSON, maxJSON
+}
+
+// appendGolapValues appends values in plain encoding
+func appendGolapValues(buf []byte, values []interface{}, dt types.DataType) []byte {
+	for _, v := range values {
+		buf = appendGolapValue(buf, v, dt)
+	}
+	return buf
+}
+
+// appendGolapValue appends one non-NULL value in plain encoding
+func appendGolapValue(buf []byte, v interface{}, dt types.DataType) []byte {
+	switch dt {
+	case types.Int:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.(int64)))
+	case types.Float:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.(float64)))
+	default:
+		s := v.(string)
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		return append(buf, s...)
+	}
+}
+
+// GolapWriteOp streams its input into a .golap file (COPY ... TO /
+// CREATE TABLE AS with a .golap target), buffering one row group at a
+// time, through a fileWriter (see write.go).
+type GolapWriteOp struct {
+	fileWriter
+}
+
+// NewGolapWriteOp creates a .golap writer operator targeting the given path
+func NewGolapWriteOp(input types.Operator, targetPath string) *GolapWriteOp {
+	return &GolapWriteOp{newFileWriter(input, targetPath, &golapEncoder{}, false)}
+}
+
+// golapEncoder writes a .golap file: the magic, then each row group's
+// column chunks, then the footer
+type golapEncoder struct {
+	out       *offsetWriter
+	schema    types.Schema
+	footer    golapFooter
+	columns   [][]interface{}
+	groupRows int
+}
+
+func (e *golapEncoder) begin(out io.Writer, schema types.Schema) error {
+	e.out, e.schema = &offsetWriter{writer: out}, schema
+	e.footer = golapFooter{Version: golapVersion}
+	for i, col := range schema.Columns {
+		e.footer.Columns = append(e.footer.Columns, golapColumn{Name: col, Type: schema.Types[i].String()})
+	}
+	e.columns = make([][]interface{}, len(schema.Columns))
+	if _, err := io.WriteString(e.out, golapMagic); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func (e *golapEncoder) write(row *types.Row) error {
+	for j, v := range row.Values {
+		if j >= len(e.columns) {
+			break
+		}
+		v, err := golapValue(v, e.schema.Types[j])
+		if err != nil {
+			return fmt.Errorf("row %d, column %s: %w", e.footer.Rows+int64(e.groupRows)+1, e.schema.Columns[j], err)
+		}
+		e.columns[j] = append(e.columns[j], v)
+	}
+	for j := len(row.Values); j < len(e.columns); j++ {
+		e.columns[j] = append(e.columns[j], nil)
+	}
+	e.groupRows++
+	if e.groupRows == GolapRowGroupRows {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (e *golapEncoder) flush() error {
+	group := golapRowGroup{Offset: e.out.offset, Rows: e.groupRows}
+	for j, values := range e.columns {
+		data, chunk := encodeGolapChunk(values, e.schema.Types[j])
+		if _, err := e.out.Write(data); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		group.Chunks = append(group.Chunks, chunk)
+		e.columns[j] = values[:0]
+	}
+	group.Length = e.out.offset - group.Offset
+	e.footer.RowGroups = append(e.footer.RowGroups, group)
+	e.footer.Rows += int64(e.groupRows)
+	e.groupRows = 0
+	return nil
+}
+
+func (e *golapEncoder) end() error {
+	if e.groupRows > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	footerJSON, err := json.Marshal(e.footer)
+	if err != nil {
+		return fmt.Errorf("failed to encode footer: %w", err)
+	}
+	tail := binary.LittleEndian.AppendUint32(footerJSON, uint32(len(footerJSON)))
+	tail = append(tail, golapMagic...)
+	if _, err := e.out.Write(tail); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if err := e.out.flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// golapValue converts a row value to its column's type, as the .golap
+// file stores every value of a column with the same encoding
+func golapValue(v interface{}, dt types.DataType) (interface{}, error) {
+	switch v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if dt == types.Int {
+			return v, nil
+		}
+		if dt == types.Float {
+			return float64(v.(int64)), nil
+		}
+	case float64:
+		if dt == types.Float {
+			return v, nil
+		}
+	case string:
+		if dt == types.String {
+			return v, nil
+		}
+	}
+	converted, ok := parseField(valueText(v), dt)
+	if !ok {
+		return nil, fmt.Errorf("cannot store %q as %s", valueText(v), dt)
+	}
+	return converted, nil
+}
+
+// Explain describes the output file
+func (w *GolapWriteOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "GolapWrite",
+		Details:           w.targetPath,
+		EstimatedRows:     1,
+		EstimatedRowBytes: -1,
+		Children:          []PlanNode{ExplainOperator(w.input)},
+	}
+}
+
+// offsetWriter buffers writes and tracks how many bytes were written, so
+// row groups know their file offsets
+type offsetWriter struct {
+	writer io.Writer
+	buf    []byte
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	o.buf = append(o.buf, p...)
+	o.offset += int64(len(p))
+	if len(o.buf) >= 1<<20 {
+		return len(p), o.flush()
+	}
+	return len(p), nil
+}
+
+func (o *offsetWriter) flush() error {
+	_, err := o.writer.Write(o.buf)
+	o.buf = o.buf[:0]
+	return err
+}
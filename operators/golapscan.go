@@ -0,0 +1,649 @@
+package operators
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// GolapScan streams rows from a .golap columnar file (see golapMagic for
+// the layout). It reads the footer first, then one row group at a time,
+// decoding each column chunk into values; row groups whose min/max rule
+// out the query's WHERE can be skipped without being read (PruneRowGroups).
+type GolapScan struct {
+	execStats
+
+	file      storage.File
+	filePath  string
+	fileSize  int64
+	footer    golapFooter
+	strict    bool
+	bytesRead int64
+
+	schema   types.Schema
+	natural  []types.DataType
+	declared []bool
+
+	groups        []int  // Row groups still to read, in order
+	pruned        int    // Row groups skipped by PruneRowGroups
+	skipped       []bool // Columns not read (ProjectColumns); they read as NULL
+	pushed        scanPredicates
+	buf           []byte
+	columns       [][]interface{} // Values of the current row group, by column
+	scratch       []interface{}   // Row the pushed predicates are evaluated on
+	selection     []int           // Rows of the group that passed them
+	row           int             // Next index into selection
+	firstRow      int64           // File row number of the group's first row, for errors
+	builder       batchBuilder    // NextBatch's batch of converted values
+	values        []interface{}   // Row being added to it
+	view          types.RowBatch  // NextBatch's batch of the group's values
+	viewSelection []int           // Its selection
+	cancel        cancelCheck
+}
+
+// NewGolapScan creates a .golap scanner
+func NewGolapScan(filePath string) (*GolapScan, error) {
+	return NewGolapScanWithOptions(filePath, ScanOptions{})
+}
+
+// NewGolapScanWithOptions creates a .golap scanner; declared column types
+// convert the file's values, other text options don't apply
+func NewGolapScanWithOptions(filePath string, opts ScanOptions) (*GolapScan, error) {
+	file, err := storage.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .golap file: %w", err)
+	}
+	s := &GolapScan{
+		file:     file,
+		filePath: filePath,
+		fileSize: file.Size(),
+		strict:   opts.Strict,
+		cancel:   cancelCheck{ctx: opts.Context},
+	}
+	if err := s.readFooter(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	for _, col := range s.footer.Columns {
+		dt, _ := types.ParseDataType(col.Type) // Checked by readFooter
+		declared, isDeclared := opts.ColumnTypes[col.Name]
+		s.natural = append(s.natural, dt)
+		if isDeclared {
+			dt = declared
+		}
+		s.schema.Columns = append(s.schema.Columns, col.Name)
+		s.schema.Types = append(s.schema.Types, dt)
+		s.declared = append(s.declared, isDeclared)
+	}
+	for i := range s.footer.RowGroups {
+		s.groups = append(s.groups, i)
+	}
+	s.skipped = make([]bool, len(s.footer.Columns))
+	return s, nil
+}
+
+// readFooter reads and checks the footer at the end of the file
+func (s *GolapScan) readFooter() error {
+	tailSize := int64(4 + len(golapMagic))
+	if s.fileSize < 0 {
+		return fmt.Errorf("cannot read a .golap file of unknown size")
+	}
+	if s.fileSize < int64(len(golapMagic))+tailSize {
+		return errCorruptGolap
+	}
+	tail, err := s.readAt(s.fileSize-tailSize, tailSize)
+	if err != nil {
+		return err
+	}
+	if string(tail[4:]) != golapMagic {
+		return fmt.Errorf("not a .golap file")
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	dataEnd := s.fileSize - tailSize - footerSize
+	if dataEnd < int64(len(golapMagic)) {
+		return errCorruptGolap
+	}
+	footerJSON, err := s.readAt(dataEnd, footerSize)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(footerJSON, &s.footer); err != nil {
+		return fmt.Errorf("%w: footer: %v", errCorruptGolap, err)
+	}
+	if s.footer.Version != golapVersion {
+		return fmt.Errorf("unsupported .golap version %d", s.footer.Version)
+	}
+	for _, col := range s.footer.Columns {
+		if _, err := types.ParseDataType(col.Type); err != nil {
+			return fmt.Errorf("%w: column %s: %v", errCorruptGolap, col.Name, err)
+		}
+	}
+	for _, group := range s.footer.RowGroups {
+		var chunkBytes int64
+		for _, chunk := range group.Chunks {
+			if chunk.Length < 0 || chunk.Nulls < 0 || chunk.Nulls > group.Rows {
+				return errCorruptGolap
+			}
+			chunkBytes += chunk.Length
+		}
+		if len(group.Chunks) != len(s.footer.Columns) || group.Rows < 0 || group.Rows > GolapRowGroupRows ||
+			group.Offset < int64(len(golapMagic)) || group.Length != chunkBytes || group.Length > dataEnd-group.Offset {
+			return errCorruptGolap
+		}
+	}
+	return nil
+}
+
+// readAt reads length bytes at offset
+func (s *GolapScan) readAt(offset, length int64) ([]byte, error) {
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if int64(cap(s.buf)) < length {
+		s.buf = make([]byte, length)
+	}
+	buf := s.buf[:length]
+	n, err := io.ReadFull(s.file, buf)
+	s.bytesRead += int64(n)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, errCorruptGolap
+	}
+	return buf, err
+}
+
+// PruneRowGroups skips the row groups for which skip returns true. skip
+// gets the min and max of each Int column whose chunk has no NULLs (so a
+// zone map over them is exact); other columns are left out.
+func (s *GolapScan) PruneRowGroups(skip func(minValues, maxValues map[string]int64) bool) {
+	kept := s.groups[:0]
+	for _, g := range s.groups {
+		minValues, maxValues := make(map[string]int64), make(map[string]int64)
+		for j, chunk := range s.footer.RowGroups[g].Chunks {
+			if s.natural[j] != types.Int || s.schema.Types[j] != types.Int || chunk.Nulls > 0 || chunk.Min == nil {
+				continue
+			}
+			var lo, hi int64
+			if json.Unmarshal(chunk.Min, &lo) == nil && json.Unmarshal(chunk.Max, &hi) == nil {
+				minValues[s.schema.Columns[j]], maxValues[s.schema.Columns[j]] = lo, hi
+			}
+		}
+		if !skip(minValues, maxValues) {
+			kept = append(kept, g)
+		}
+	}
+	s.pruned += len(s.groups) - len(kept)
+	s.groups = kept
+}
+
+// PrunedRowGroups returns how many of the file's row groups PruneRowGroups
+// skips
+func (s *GolapScan) PrunedRowGroups() (pruned, groups int) {
+	return s.pruned, len(s.groups) + s.pruned
+}
+
+// ProjectColumns reads only the columns marked as needed; the others
+// aren't read from the file at all and come back as NULL, so the caller
+// must only skip columns the query doesn't reference
+func (s *GolapScan) ProjectColumns(needed []bool) {
+	for j := range s.skipped {
+		s.skipped[j] = j >= len(needed) || !needed[j]
+	}
+}
+
+// nextGroup reads and decodes the next row group. With pushed
+// predicates, the columns they read are decoded first, and the other
+// columns only if some row of the group passes.
+func (s *GolapScan) nextGroup() error {
+	g := s.groups[0]
+	s.groups = s.groups[1:]
+	group := s.footer.RowGroups[g]
+	if s.columns == nil {
+		s.columns = make([][]interface{}, len(s.footer.Columns))
+		s.scratch = make([]interface{}, len(s.footer.Columns))
+	}
+	s.firstRow = 0
+	for _, earlier := range s.footer.RowGroups[:g] {
+		s.firstRow += int64(earlier.Rows)
+	}
+	s.selection, s.row = s.selection[:0], 0
+
+	if len(s.pushed.predicates) == 0 {
+		if err := s.decodeColumns(g, func(j int) bool { return !s.skipped[j] }); err != nil {
+			return err
+		}
+		for r := 0; r < group.Rows; r++ {
+			s.selection = append(s.selection, r)
+		}
+		return nil
+	}
+
+	// -strict checks every value of a declared type, even in rejected rows
+	check := s.strict && !slices.Equal(s.natural, s.schema.Types)
+	if err := s.decodeColumns(g, func(j int) bool { return s.pushed.reads(j) || (check && !s.skipped[j]) }); err != nil {
+		return err
+	}
+	row := &types.Row{Values: s.scratch}
+	for r := 0; r < group.Rows; r++ {
+		clear(s.scratch)
+		for j := range s.columns {
+			if s.pushed.reads(j) {
+				v, err := s.convert(j, s.columns[j][r], r)
+				if err != nil {
+					return err
+				}
+				s.scratch[j] = v
+			}
+		}
+		passes := true
+		for _, pred := range s.pushed.predicates {
+			if pred.Predicate(row) != types.True {
+				passes = false
+				break
+			}
+		}
+		if passes {
+			s.selection = append(s.selection, r)
+		} else if check {
+			for j := range s.columns {
+				if s.skipped[j] {
+					continue
+				}
+				if _, err := s.convert(j, s.columns[j][r], r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(s.selection) == 0 {
+		return nil // The other columns are never read
+	}
+	return s.decodeColumns(g, func(j int) bool { return !s.skipped[j] && !s.pushed.reads(j) && !check })
+}
+
+// decodeColumns reads and decodes the chunks of row group g that want
+// selects, reading each run of adjacent chunks in one go; the columns
+// skipped by ProjectColumns are set to NULLs
+func (s *GolapScan) decodeColumns(g int, want func(j int) bool) error {
+	group := s.footer.RowGroups[g]
+	offset := group.Offset
+	var data []byte // Chunks read but not yet decoded, starting at offset
+	for j, chunk := range group.Chunks {
+		if !want(j) {
+			if s.skipped[j] && !s.pushed.reads(j) {
+				s.columns[j] = golapNulls(s.columns[j], group.Rows)
+			}
+			offset += chunk.Length
+			data = nil
+			continue
+		}
+		if len(data) == 0 {
+			length := chunk.Length
+			for k := j + 1; k < len(group.Chunks) && want(k); k++ {
+				length += group.Chunks[k].Length
+			}
+			var err error
+			if data, err = s.readAt(offset, length); err != nil {
+				return err
+			}
+		}
+		values, err := decodeGolapChunk(data[:chunk.Length], chunk, group.Rows, s.natural[j], s.columns[j])
+		if err != nil {
+			return fmt.Errorf("row group %d, column %s: %w", g, s.schema.Columns[j], err)
+		}
+		s.columns[j] = values
+		data = data[chunk.Length:]
+		offset += chunk.Length
+	}
+	return nil
+}
+
+// convert returns a value of column j, from row r of the current group,
+// as the column's declared type
+func (s *GolapScan) convert(j int, v interface{}, r int) (interface{}, error) {
+	if s.schema.Types[j] == s.natural[j] || v == nil {
+		return v, nil
+	}
+	converted, ok := parseField(valueText(v), s.schema.Types[j])
+	if !ok && s.strict {
+		return nil, fmt.Errorf("%s row %d, column %d (%s): cannot parse %q as %s",
+			s.filePath, s.firstRow+int64(r)+1, j+1, s.schema.Columns[j], valueText(v), s.schema.Types[j])
+	}
+	return converted, nil
+}
+
+// PushPredicates makes the scan apply single-column predicates itself,
+// before the rest of each row group is decoded
+func (s *GolapScan) PushPredicates(predicates []ScanPredicate) {
+	s.pushed.push(predicates, len(s.footer.Columns))
+}
+
+// golapNulls returns rows NULLs, reusing buf
+func golapNulls(buf []interface{}, rows int) []interface{} {
+	if cap(buf) < rows {
+		buf = make([]interface{}, rows)
+	}
+	buf = buf[:rows]
+	clear(buf)
+	return buf
+}
+
+// decodeGolapChunk decodes a column chunk into rows values, reusing buf
+func decodeGolapChunk(data []byte, chunk golapChunk, rows int, dt types.DataType, buf []interface{}) ([]interface{}, error) {
+	var bitmap []byte
+	if chunk.Nulls > 0 {
+		size := (rows + 7) / 8
+		if len(data) < size {
+			return nil, errCorruptGolap
+		}
+		bitmap, data = data[:size], data[size:]
+	}
+	isNull := func(i int) bool {
+		return bitmap != nil && bitmap[i/8]&(1<<(i%8)) != 0
+	}
+
+	values := buf[:0]
+	if cap(values) < rows {
+		values = make([]interface{}, 0, rows)
+	}
+	values = values[:rows]
+	d := golapDecoder{data: data, dt: dt}
+	switch chunk.Encoding {
+	case golapPlain:
+		for i := range values {
+			if isNull(i) {
+				values[i] = nil
+				continue
+			}
+			values[i] = d.value()
+		}
+
+	case golapRLE, golapDict:
+		var dict []interface{}
+		if chunk.Encoding == golapDict {
+			size := d.uvarint()
+			if size > uint64(rows) {
+				return nil, errCorruptGolap
+			}
+			dict = make([]interface{}, size)
+			for k := range dict {
+				dict[k] = d.value()
+			}
+		}
+		var run uint64
+		var v interface{}
+		for i := range values {
+			if isNull(i) {
+				values[i] = nil
+				continue
+			}
+			if run == 0 {
+				run = d.uvarint()
+				if chunk.Encoding == golapDict {
+					index := d.uvarint()
+					if index >= uint64(len(dict)) {
+						return nil, errCorruptGolap
+					}
+					v = dict[index]
+				} else {
+					v = d.value()
+				}
+				if run == 0 {
+					return nil, errCorruptGolap
+				}
+			}
+			values[i] = v
+			run--
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", chunk.Encoding)
+	}
+	if d.err {
+		return nil, errCorruptGolap
+	}
+	return values, nil
+}
+
+// golapDecoder reads plain-encoded values, noting (rather than
+// returning) running out of data so loops stay simple
+type golapDecoder struct {
+	data []byte
+	dt   types.DataType
+	err  bool
+}
+
+func (d *golapDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = true
+		d.data = nil
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *golapDecoder) value() interface{} {
+	switch d.dt {
+	case types.Int, types.Float:
+		if len(d.data) < 8 {
+			d.err = true
+			return nil
+		}
+		bits := binary.LittleEndian.Uint64(d.data)
+		d.data = d.data[8:]
+		if d.dt == types.Int {
+			return int64(bits)
+		}
+		return math.Float64frombits(bits)
+	default:
+		size := d.uvarint()
+		if size > uint64(len(d.data)) {
+			d.err = true
+			return nil
+		}
+		s := string(d.data[:size])
+		d.data = d.data[size:]
+		return s
+	}
+}
+
+// Next returns the next row from the file
+// Returns (nil, nil) after the last row
+func (s *GolapScan) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *GolapScan) next() (*types.Row, error) {
+	if err := s.cancel.check(); err != nil {
+		return nil, err
+	}
+	for s.row >= len(s.selection) {
+		if len(s.groups) == 0 {
+			return nil, nil
+		}
+		if err := s.nextGroup(); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.filePath, err)
+		}
+	}
+
+	r := s.selection[s.row]
+	row := types.GetRow(len(s.columns))
+	for j, column := range s.columns {
+		v, err := s.convert(j, column[r], r)
+		if err != nil {
+			types.ReleaseRow(row)
+			return nil, err
+		}
+		row.Values[j] = v
+	}
+	s.row++
+	return row, nil
+}
+
+// NextBatch returns the next types.BatchSize rows (fewer at the end of a
+// row group). Columns read as their stored type are views of the decoded
+// row group, uncopied.
+func (s *GolapScan) NextBatch() (*types.RowBatch, error) {
+	start := s.startCall()
+	batch, err := s.nextBatch()
+	s.endCall(start, batchRows(batch))
+	return batch, err
+}
+
+// nextBatch is NextBatch, uncounted
+func (s *GolapScan) nextBatch() (*types.RowBatch, error) {
+	if err := s.cancel.err(); err != nil {
+		return nil, err
+	}
+	for s.row >= len(s.selection) {
+		if len(s.groups) == 0 {
+			return nil, nil
+		}
+		if err := s.nextGroup(); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.filePath, err)
+		}
+	}
+	if !slices.Equal(s.natural, s.schema.Types) {
+		return s.convertBatch()
+	}
+
+	rows := s.selection[s.row:min(s.row+types.BatchSize, len(s.selection))]
+	s.row += len(rows)
+	first, end := rows[0], rows[len(rows)-1]+1
+	if s.view.Columns == nil {
+		s.view.Columns = make([]types.Vector, len(s.columns))
+	}
+	for j, column := range s.columns {
+		s.view.Columns[j] = types.AnyVector(column[first:end])
+	}
+	s.view.Length = end - first
+	s.view.Selection = nil
+	if len(rows) < s.view.Length {
+		// Pushed predicates rejected some of the rows in the range
+		s.viewSelection = s.viewSelection[:0]
+		for _, r := range rows {
+			s.viewSelection = append(s.viewSelection, r-first)
+		}
+		s.view.Selection = s.viewSelection
+	}
+	return &s.view, nil
+}
+
+// convertBatch builds the next batch from the rows' values converted to
+// their declared types
+func (s *GolapScan) convertBatch() (*types.RowBatch, error) {
+	s.builder.reset(s.schema)
+	for !s.builder.full() {
+		if s.row >= len(s.selection) {
+			if len(s.groups) == 0 {
+				break
+			}
+			if err := s.nextGroup(); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.filePath, err)
+			}
+			continue
+		}
+		r := s.selection[s.row]
+		if len(s.values) != len(s.columns) {
+			s.values = make([]interface{}, len(s.columns))
+		}
+		for j, column := range s.columns {
+			v, err := s.convert(j, column[r], r)
+			if err != nil {
+				return nil, err
+			}
+			s.values[j] = v
+		}
+		s.builder.add(s.values)
+		s.row++
+	}
+	return s.builder.finish(), nil
+}
+
+// Close closes the file
+func (s *GolapScan) Close() error {
+	if s.file != nil {
+		err := s.file.Close()
+		s.file = nil
+		return err
+	}
+	return nil
+}
+
+// Inputs returns nil: the scan reads a file
+func (s *GolapScan) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the schema of rows produced by this operator
+func (s *GolapScan) Schema() types.Schema {
+	return s.schema
+}
+
+// TypeSource says whether a column's type was declared or comes from the
+// file's schema
+func (s *GolapScan) TypeSource(column int) string {
+	if column >= 0 && column < len(s.declared) && s.declared[column] {
+		return "declared"
+	}
+	return ".golap schema"
+}
+
+// BytesRead returns the number of bytes read from the file so far
+func (s *GolapScan) BytesRead() int64 {
+	return s.bytesRead
+}
+
+// Explain describes the scan; the footer gives exact row counts for the
+// row groups left after pruning
+func (s *GolapScan) Explain() PlanNode {
+	var rows, bytes int64
+	for _, g := range s.groups {
+		rows += int64(s.footer.RowGroups[g].Rows)
+		for j, chunk := range s.footer.RowGroups[g].Chunks {
+			if !s.skipped[j] {
+				bytes += chunk.Length
+			}
+		}
+	}
+	rows += int64(len(s.selection) - s.row)
+
+	details := s.filePath
+	if s.fileSize >= 0 {
+		details += ", " + FormatBytes(s.fileSize)
+	}
+	if s.pruned > 0 {
+		total := len(s.footer.RowGroups)
+		details += fmt.Sprintf(", %d of %d row groups", total-s.pruned, total)
+	}
+	read := 0
+	for _, skipped := range s.skipped {
+		if !skipped {
+			read++
+		}
+	}
+	if read < len(s.skipped) {
+		details += fmt.Sprintf(", %d of %d columns", read, len(s.skipped))
+	}
+	var rowBytes int64 = -1
+	if rows > 0 {
+		rowBytes = max(bytes/rows, 1)
+	}
+	return s.pushed.explain(PlanNode{
+		Operator:          "GolapScan",
+		Details:           details,
+		EstimatedRows:     rows,
+		EstimatedRowBytes: rowBytes,
+	})
+}
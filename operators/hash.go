@@ -0,0 +1,160 @@
+package operators
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Type tags keep values of different types from colliding (1 vs "1")
+const (
+	hashTagNull   byte = 'n'
+	hashTagInt    byte = 'i'
+	hashTagFloat  byte = 'f'
+	hashTagString byte = 's'
+)
+
+// HashValues returns a stable 63-bit hash of one or more values
+// The hash (FNV-1a over a type-tagged encoding, then mixed) depends only on
+// the values, so it is identical across runs, machines and Go versions.
+// Whole-number floats hash like the equal int: 5 and 5.0 share a bucket.
+// Returns ok=false if any value is NULL.
+func HashValues(values ...interface{}) (uint64, bool) {
+	for _, v := range values {
+		if v == nil {
+			return 0, false
+		}
+	}
+	return hashRow(values), true
+}
+
+// hashRow hashes a whole row like HashValues, except that NULLs hash too
+// (to their own tag), so rows that differ only in NULLs stay apart
+func hashRow(values []interface{}) uint64 {
+	h := fnv.New64a()
+	var buf [32]byte
+	for _, v := range values {
+		h.Write(appendTypedValue(buf[:0], v))
+	}
+	return mix64(h.Sum64()) >> 1 // Fits a non-negative int64
+}
+
+// hashCollatedRow is hashRow with text hashed by its collation key (see
+// appendCollatedString), so text the collation finds equal hashes alike
+func hashCollatedRow(values []interface{}, collation Collation) uint64 {
+	if collation.IsBinary() {
+		return hashRow(values)
+	}
+	h := fnv.New64a()
+	var buf []byte
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			buf = appendCollatedString(buf[:0], s, collation)
+		} else {
+			buf = appendTypedValue(buf[:0], v)
+		}
+		h.Write(buf)
+	}
+	return mix64(h.Sum64()) >> 1
+}
+
+// appendTypedValue appends the type-tagged encoding of a value: its tag,
+// then 8 big-endian bytes of an int or float, or a string's length and
+// bytes. Values that compare equal encode the same (a whole-number float
+// as the equal int) and a sequence of encodings reads back only one way,
+// so the encodings of several values make a composite key as they are
+func appendTypedValue(buf []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case nil:
+		return append(buf, hashTagNull)
+	case int64:
+		return appendTypedInt(buf, val)
+	case float64:
+		return appendTypedFloat(buf, val)
+	case string:
+		return appendTypedString(buf, val)
+	default:
+		return appendTypedString(buf, fmt.Sprintf("%v", val))
+	}
+}
+
+// appendTypedInt appends an int's encoding
+func appendTypedInt(buf []byte, val int64) []byte {
+	buf = append(buf, hashTagInt)
+	return binary.BigEndian.AppendUint64(buf, uint64(val))
+}
+
+// appendTypedFloat appends a float's encoding, a whole number's as the int
+func appendTypedFloat(buf []byte, val float64) []byte {
+	if val == math.Trunc(val) && math.Abs(val) < 1<<63 {
+		return appendTypedInt(buf, int64(val)) // -0 too
+	}
+	if math.IsNaN(val) {
+		val = math.NaN() // One NaN, whatever its payload
+	}
+	buf = append(buf, hashTagFloat)
+	return binary.BigEndian.AppendUint64(buf, math.Float64bits(val))
+}
+
+// appendCollatedString appends a string's encoding with its collation key
+// (see Collation.AppendKey) in place of its bytes
+func appendCollatedString(buf []byte, val string, collation Collation) []byte {
+	if collation.IsBinary() {
+		return appendTypedString(buf, val)
+	}
+	buf = append(buf, hashTagString)
+	start := len(buf)
+	buf = binary.BigEndian.AppendUint64(buf, 0) // The key's length, once known
+	buf = collation.AppendKey(buf, val)
+	binary.BigEndian.PutUint64(buf[start:], uint64(len(buf)-start-8))
+	return buf
+}
+
+// appendTypedString appends a string's encoding
+func appendTypedString[S string | []byte](buf []byte, val S) []byte {
+	buf = append(buf, hashTagString)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(val)))
+	return append(buf, val...)
+}
+
+// mix64 is the MurmurHash3 finalizer; FNV alone barely changes the high
+// bits for inputs differing only in their last byte (e.g. sequential ids)
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// HashExpr evaluates HASH(args...): a non-negative Int, or NULL if any
+// argument is NULL
+func HashExpr(args ...ValueExpr) ValueExpr {
+	return func(row *types.Row) interface{} {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = arg(row)
+		}
+		hash, ok := HashValues(values...)
+		if !ok {
+			return nil
+		}
+		return int64(hash)
+	}
+}
+
+// BucketExpr evaluates BUCKET(arg, n): the value's hash mod n, in [0, n)
+// NULL values have no bucket
+func BucketExpr(arg ValueExpr, buckets int64) ValueExpr {
+	return func(row *types.Row) interface{} {
+		hash, ok := HashValues(arg(row))
+		if !ok {
+			return nil
+		}
+		return int64(hash % uint64(buckets))
+	}
+}
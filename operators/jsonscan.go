@@ -0,0 +1,314 @@
+package operators
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DefaultJSONSampleSize is how many records JSONScan reads to infer a schema
+const DefaultJSONSampleSize = 100
+
+// JSONScan streams rows from a JSON Lines (NDJSON) file: one object per line
+// The schema is inferred from the first records: nested objects are
+// flattened into dotted column names (user.id), arrays are kept as JSON
+// text, and fields first seen after the sample are ignored.
+type JSONScan struct {
+	execStats
+
+	decoder     *json.Decoder
+	file        storage.File
+	gzipReader  *gzip.Reader // Non-nil for .gz files
+	counter     *countingReader
+	filePath    string
+	fileSize    int64 // -1 if unknown
+	rowBytes    int64 // Average decoded bytes per record in the sample
+	schema      types.Schema
+	columnIndex map[string]int
+	declared    []bool                   // Columns whose type was declared rather than inferred
+	sample      []map[string]interface{} // Flattened sampled records, returned first
+	sampleIndex int
+	cancel      cancelCheck
+}
+
+// NewJSONScan creates a JSON Lines scanner with schema inference
+func NewJSONScan(filePath string) (*JSONScan, error) {
+	return NewJSONScanWithOptions(filePath, ScanOptions{})
+}
+
+// NewJSONScanWithOptions creates a JSON Lines scanner with custom I/O sizing
+func NewJSONScanWithOptions(filePath string, opts ScanOptions) (*JSONScan, error) {
+	file, counter, gzipReader, input, err := openScanInput(filePath, opts.BufferSize, opts.Encoding, opts.Mmap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %w", err)
+	}
+
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber() // Keep integers exact
+
+	// Sample records, collecting columns in the order records first have
+	// them; within a record that is sorted key order, as flattenObject
+	// visits keys
+	var sample []map[string]interface{}
+	var columns []string
+	seen := make(map[string]bool)
+	sampleRows := opts.SampleRows
+	if sampleRows <= 0 {
+		sampleRows = DefaultJSONSampleSize
+	}
+	for len(sample) < sampleRows {
+		record, err := decodeRecord(decoder)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read JSON record %d: %w", len(sample)+1, err)
+		}
+		for _, col := range record.keys {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
+			}
+		}
+		sample = append(sample, record.values)
+	}
+
+	// A column's type must fit every sampled value
+	colTypes := make([]types.DataType, len(columns))
+	declared := make([]bool, len(columns))
+	columnIndex := make(map[string]int, len(columns))
+	for i, col := range columns {
+		columnIndex[col] = i
+		colTypes[i] = inferJSONType(sample, col)
+		if dt, ok := opts.ColumnTypes[col]; ok {
+			colTypes[i] = dt // Declared types win over inference
+			declared[i] = true
+		}
+	}
+
+	var rowBytes int64
+	if len(sample) > 0 {
+		rowBytes = decoder.InputOffset() / int64(len(sample))
+	}
+
+	return &JSONScan{
+		decoder:     decoder,
+		file:        file,
+		gzipReader:  gzipReader,
+		counter:     counter,
+		filePath:    filePath,
+		fileSize:    file.Size(),
+		rowBytes:    rowBytes,
+		schema:      types.Schema{Columns: columns, Types: colTypes},
+		columnIndex: columnIndex,
+		declared:    declared,
+		sample:      sample,
+		cancel:      cancelCheck{ctx: opts.Context},
+	}, nil
+}
+
+// isJSONLinesPath reports whether a path names a JSON Lines file
+func isJSONLinesPath(filePath string) bool {
+	name := strings.TrimSuffix(strings.ToLower(filePath), ".gz")
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson")
+}
+
+// flatRecord is one decoded object flattened to dotted paths
+type flatRecord struct {
+	keys   []string // Paths in sorted order
+	values map[string]interface{}
+}
+
+// decodeRecord reads the next object from the stream and flattens it
+func decodeRecord(decoder *json.Decoder) (flatRecord, error) {
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return flatRecord{}, err
+	}
+	record := flatRecord{values: make(map[string]interface{}, len(obj))}
+	flattenObject(obj, "", &record)
+	return record, nil
+}
+
+// flattenObject adds obj's leaves to record under prefix-qualified names
+// Go maps are unordered, so keys are visited in sorted order
+func flattenObject(obj map[string]interface{}, prefix string, record *flatRecord) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := prefix + key
+		switch v := obj[key].(type) {
+		case map[string]interface{}:
+			flattenObject(v, name+".", record)
+		default:
+			record.keys = append(record.keys, name)
+			record.values[name] = v
+		}
+	}
+}
+
+// inferJSONType picks the narrowest type that fits every sampled value
+// Int -> Float -> String; nulls don't count, all-null columns are String
+func inferJSONType(sample []map[string]interface{}, column string) types.DataType {
+	dt := types.String
+	found := false
+	for _, record := range sample {
+		v, ok := record[column]
+		if !ok || v == nil {
+			continue
+		}
+		valType := types.String
+		if n, ok := v.(json.Number); ok {
+			valType = inferType(n.String())
+		}
+		if found {
+			dt = widenType(dt, valType)
+		} else {
+			dt = valType
+			found = true
+		}
+	}
+	return dt
+}
+
+// jsonValue converts a decoded JSON value to the column's type
+// Missing fields, nulls and numbers that don't fit the type are NULL
+func jsonValue(v interface{}, dt types.DataType) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		if dt == types.String {
+			return val.String()
+		}
+		return parseValue(val.String(), dt)
+	case string:
+		if dt == types.String {
+			return val
+		}
+		return nil
+	case bool:
+		if dt == types.String {
+			return strconv.FormatBool(val)
+		}
+		return nil
+	default:
+		// Arrays stay as JSON text
+		if dt != types.String {
+			return nil
+		}
+		encoded, err := json.Marshal(val)
+		if err != nil {
+			return nil
+		}
+		return string(encoded)
+	}
+}
+
+// Next returns the next record as a row
+// Returns (nil, nil) when the file is exhausted
+func (s *JSONScan) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *JSONScan) next() (*types.Row, error) {
+	if err := s.cancel.check(); err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if s.sampleIndex < len(s.sample) {
+		values = s.sample[s.sampleIndex]
+		s.sample[s.sampleIndex] = nil // Let sampled records be collected
+		s.sampleIndex++
+	} else {
+		record, err := decodeRecord(s.decoder)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading JSON record: %w", err)
+		}
+		values = record.values
+	}
+
+	row := make([]interface{}, len(s.schema.Columns))
+	for name, v := range values {
+		if i, ok := s.columnIndex[name]; ok {
+			row[i] = jsonValue(v, s.schema.Types[i])
+		}
+	}
+	return &types.Row{Values: row}, nil
+}
+
+// Close releases resources held by this operator
+func (s *JSONScan) Close() error {
+	if s.gzipReader != nil {
+		s.gzipReader.Close()
+	}
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// Inputs returns nil: the scan reads a file
+func (s *JSONScan) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the schema of rows produced by this operator
+func (s *JSONScan) Schema() types.Schema {
+	return s.schema
+}
+
+// TypeSource describes how a column's type was chosen
+func (s *JSONScan) TypeSource(column int) string {
+	return typeSource(s.declared, column, len(s.sample))
+}
+
+// BytesRead returns the number of bytes read from the underlying file so far
+func (s *JSONScan) BytesRead() int64 {
+	return s.counter.count
+}
+
+// Explain describes the scan, estimating row count from file size and the
+// average sampled record width (unknown for compressed files)
+func (s *JSONScan) Explain() PlanNode {
+	rows := int64(-1)
+	if len(s.sample) == 0 {
+		rows = 0
+	} else if s.gzipReader == nil && s.fileSize >= 0 && s.rowBytes > 0 {
+		rows = s.fileSize / s.rowBytes
+		if rows < 1 {
+			rows = 1
+		}
+	}
+
+	details := s.filePath
+	if s.fileSize >= 0 {
+		details += ", " + FormatBytes(s.fileSize)
+	}
+
+	return PlanNode{
+		Operator:          "JSONScan",
+		Details:           details,
+		EstimatedRows:     rows,
+		EstimatedRowBytes: s.rowBytes,
+	}
+}
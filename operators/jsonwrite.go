@@ -0,0 +1,110 @@
+package operators
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// JSONWriteOp streams its input into a JSON Lines file (COPY ... TO or
+// CREATE TABLE AS with a .jsonl or .ndjson target): one object per row,
+// keyed by column name, which JSONScan reads back. NULL, NaN and the
+// infinities are written as null. It writes through a fileWriter (see
+// write.go); targets ending in .gz are gzip-compressed.
+type JSONWriteOp struct {
+	fileWriter
+}
+
+// NewJSONWriteOp creates a JSON Lines writer operator targeting the given
+// path
+func NewJSONWriteOp(input types.Operator, targetPath string) *JSONWriteOp {
+	return &JSONWriteOp{newFileWriter(input, targetPath, &jsonEncoder{}, strings.HasSuffix(targetPath, ".gz"))}
+}
+
+// jsonEncoder writes JSON Lines, one object per row
+type jsonEncoder struct {
+	writer *bufio.Writer
+	keys   [][]byte // Each column's quoted name and colon, encoded once
+	line   []byte
+}
+
+func (e *jsonEncoder) begin(out io.Writer, schema types.Schema) error {
+	e.writer = bufio.NewWriterSize(out, 1<<16)
+	e.keys = make([][]byte, len(schema.Columns))
+	for i, col := range schema.Columns {
+		name, _ := json.Marshal(col)
+		e.keys[i] = append(name, ':')
+	}
+	return nil
+}
+
+func (e *jsonEncoder) write(row *types.Row) error {
+	line := append(e.line[:0], '{')
+	for i, v := range row.Values {
+		if i >= len(e.keys) {
+			break
+		}
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, e.keys[i]...)
+		line = appendJSONValue(line, v)
+	}
+	line = append(line, '}', '\n')
+	e.line = line
+	if _, err := e.writer.Write(line); err != nil {
+		return fmt.Errorf("failed to write row: %w", err)
+	}
+	return nil
+}
+
+func (e *jsonEncoder) end() error {
+	if err := e.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush output: %w", err)
+	}
+	return nil
+}
+
+// appendJSONValue appends a value as JSON; NaN and infinities, which JSON
+// can't represent, become null
+func appendJSONValue(dst []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, "null"...)
+	case int64:
+		return strconv.AppendInt(dst, v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return append(dst, "null"...)
+		}
+		start := len(dst)
+		dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+		if !strings.ContainsAny(string(dst[start:]), ".e") {
+			dst = append(dst, ".0"...) // Read back as a Float, not an Int
+		}
+		return dst
+	case string:
+		text, _ := json.Marshal(v)
+		return append(dst, text...)
+	default:
+		text, _ := json.Marshal(valueText(v))
+		return append(dst, text...)
+	}
+}
+
+// Explain describes the output file
+func (w *JSONWriteOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "JSONWrite",
+		Details:           w.targetPath,
+		EstimatedRows:     1,
+		EstimatedRowBytes: -1,
+		Children:          []PlanNode{ExplainOperator(w.input)},
+	}
+}
@@ -1,11 +1,15 @@
 package operators
 
 import (
+	"fmt"
+
 	"github.com/aryamaansaha/golap/types"
 )
 
 // LimitOp limits the number of rows returned
 type LimitOp struct {
+	execStats
+
 	input   types.Operator
 	limit   int
 	offset  int // Optional: skip first N rows (for OFFSET clause)
@@ -35,6 +39,14 @@ func NewLimitOffsetOp(input types.Operator, limit, offset int) *LimitOp {
 
 // Next returns the next row, stopping after limit rows
 func (l *LimitOp) Next() (*types.Row, error) {
+	start := l.startCall()
+	row, err := l.next()
+	l.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (l *LimitOp) next() (*types.Row, error) {
 	// Skip rows for OFFSET
 	for l.skipped < l.offset {
 		row, err := l.input.Next()
@@ -44,6 +56,7 @@ func (l *LimitOp) Next() (*types.Row, error) {
 		if row == nil {
 			return nil, nil // Exhausted before reaching offset
 		}
+		types.ReleaseRow(row)
 		l.skipped++
 	}
 
@@ -69,7 +82,33 @@ func (l *LimitOp) Close() error {
 	return l.input.Close()
 }
 
+// SortOrder returns the input's order, which a limit keeps
+func (l *LimitOp) SortOrder() []SortKey {
+	return SortOrder(l.input)
+}
+
+// Inputs returns the limited input
+func (l *LimitOp) Inputs() []types.Operator {
+	return []types.Operator{l.input}
+}
+
 // Schema returns the schema (unchanged from input)
 func (l *LimitOp) Schema() types.Schema {
 	return l.input.Schema()
 }
+
+// Explain describes the limit
+func (l *LimitOp) Explain() PlanNode {
+	child := ExplainOperator(l.input)
+	details := fmt.Sprintf("%d", l.limit)
+	if l.offset > 0 {
+		details += fmt.Sprintf(" offset %d", l.offset)
+	}
+	return PlanNode{
+		Operator:          "Limit",
+		Details:           details,
+		EstimatedRows:     minEstimate(int64(l.limit), child.EstimatedRows),
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		Children:          []PlanNode{child},
+	}
+}
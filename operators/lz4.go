@@ -0,0 +1,393 @@
+package operators
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Spill files can be compressed with LZ4 (see SpillOptions). The stream is
+// a series of frames, each a uvarint of the block's uncompressed length,
+// a uvarint of its compressed length (0 if stored uncompressed, when LZ4
+// wouldn't shrink it) and the block in the LZ4 block format. Blocks are
+// compressed independently, so a reader holds one block at a time.
+const (
+	lz4BlockSize = 64 << 10
+	lz4MinMatch  = 4
+	lz4HashBits  = 14
+	lz4MaxOffset = 65535
+)
+
+// errLZ4Corrupt is returned for a spill file that isn't valid LZ4
+var errLZ4Corrupt = errors.New("corrupt lz4 spill data")
+
+// lz4Writer compresses everything written to it onto an underlying writer.
+// Close writes the last block; it doesn't close the underlying writer.
+type lz4Writer struct {
+	w     io.Writer
+	buf   []byte // Uncompressed data of the block being filled
+	block []byte // buf compressed
+	out   []byte // Frame being written
+	table []int32
+}
+
+// newLZ4Writer compresses onto w
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	return &lz4Writer{
+		w:     w,
+		buf:   make([]byte, 0, lz4BlockSize),
+		table: make([]int32, 1<<lz4HashBits),
+	}
+}
+
+// Write buffers p, compressing each full block
+func (z *lz4Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), lz4BlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:n]...)
+		p, written = p[n:], written+n
+		if len(z.buf) == lz4BlockSize {
+			if err := z.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close writes the buffered data
+func (z *lz4Writer) Close() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	return z.flush()
+}
+
+// flush writes the buffered data as one frame
+func (z *lz4Writer) flush() error {
+	z.block = lz4CompressBlock(z.block[:0], z.buf, z.table)
+	out := binary.AppendUvarint(z.out[:0], uint64(len(z.buf)))
+	if len(z.block) < len(z.buf) {
+		out = binary.AppendUvarint(out, uint64(len(z.block)))
+		out = append(out, z.block...)
+	} else {
+		out = binary.AppendUvarint(out, 0)
+		out = append(out, z.buf...)
+	}
+	z.out, z.buf = out, z.buf[:0]
+	_, err := z.w.Write(out)
+	return err
+}
+
+// lz4Reader decompresses a stream written by lz4Writer
+type lz4Reader struct {
+	r     *bufio.Reader
+	block []byte // Decompressed block
+	pos   int    // Next unread byte of block
+	in    []byte // Compressed block
+}
+
+// newLZ4Reader decompresses r
+func newLZ4Reader(r io.Reader) *lz4Reader {
+	return &lz4Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns decompressed data, reading the next frame when the current
+// block is used up
+func (z *lz4Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.block) {
+		if err := z.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, z.block[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+// next reads and decompresses the next frame, returning io.EOF at the end
+// of the stream
+func (z *lz4Reader) next() error {
+	size, err := binary.ReadUvarint(z.r)
+	if err != nil {
+		return err // io.EOF between frames is the end of the stream
+	}
+	compressed, err := binary.ReadUvarint(z.r)
+	if err != nil || size > lz4BlockSize || compressed > lz4BlockSize {
+		return errLZ4Corrupt
+	}
+	if compressed == 0 {
+		z.block = resizeBytes(z.block, int(size))
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return fmt.Errorf("%w: %v", errLZ4Corrupt, err)
+		}
+	} else {
+		z.in = resizeBytes(z.in, int(compressed))
+		if _, err := io.ReadFull(z.r, z.in); err != nil {
+			return fmt.Errorf("%w: %v", errLZ4Corrupt, err)
+		}
+		if z.block, err = lz4DecompressBlock(z.block[:0], z.in); err != nil {
+			return err
+		}
+		if len(z.block) != int(size) {
+			return errLZ4Corrupt
+		}
+	}
+	z.pos = 0
+	return nil
+}
+
+// resizeBytes returns b resized to n bytes, reusing its array when it's
+// big enough
+func resizeBytes(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// lz4CompressBlock appends src compressed in the LZ4 block format to dst.
+// It finds matches greedily through a hash table of 4-byte sequences,
+// which it clears first, trading ratio for speed as LZ4's fast mode does.
+func lz4CompressBlock(dst, src []byte, table []int32) []byte {
+	clear(table)
+	n := len(src)
+	// The format requires the last match to start 12 bytes before the end
+	// and end 5 bytes before it
+	matchLimit, endLimit := n-12, n-5
+	anchor := 0
+	for i := 0; i < matchLimit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lz4HashBits)
+		candidate := int(table[h]) - 1 // Stored +1 so 0 means empty
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > lz4MaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != seq {
+			i++
+			continue
+		}
+		end := i + lz4MinMatch
+		for end < endLimit && src[end] == src[end-i+candidate] {
+			end++
+		}
+		dst = appendLZ4Sequence(dst, src[anchor:i], i-candidate, end-i)
+		i, anchor = end, end
+	}
+	return appendLZ4Literals(dst, src[anchor:])
+}
+
+// appendLZ4Sequence appends literals followed by a match of length at
+// least lz4MinMatch, offset bytes back
+func appendLZ4Sequence(dst, literals []byte, offset, length int) []byte {
+	length -= lz4MinMatch
+	dst = append(dst, byte(min(len(literals), 15))<<4|byte(min(length, 15)))
+	if len(literals) >= 15 {
+		dst = appendLZ4Length(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if length >= 15 {
+		dst = appendLZ4Length(dst, length-15)
+	}
+	return dst
+}
+
+// appendLZ4Literals appends the final sequence, which has no match
+func appendLZ4Literals(dst, literals []byte) []byte {
+	dst = append(dst, byte(min(len(literals), 15))<<4)
+	if len(literals) >= 15 {
+		dst = appendLZ4Length(dst, len(literals)-15)
+	}
+	return append(dst, literals...)
+}
+
+// appendLZ4Length appends the rest of a length past its token's 15
+func appendLZ4Length(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4DecompressBlock appends the decompressed LZ4 block src to dst
+func lz4DecompressBlock(dst, src []byte) ([]byte, error) {
+	return lz4Decode(dst, src, 0, lz4BlockSize)
+}
+
+// lz4Decode appends the decompressed LZ4 block src to dst. Matches may
+// reach back into the last history bytes of dst (earlier blocks of a
+// linked LZ4 frame), and the block may decompress to at most limit bytes.
+func lz4Decode(dst, src []byte, history, limit int) ([]byte, error) {
+	start := len(dst)
+	base := start - history
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			extra, n, ok := readLZ4Length(src[i:])
+			if !ok {
+				return nil, errLZ4Corrupt
+			}
+			literals, i = literals+extra, i+n
+		}
+		if literals > len(src)-i {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			return dst, nil // The last sequence has no match
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		length := int(token & 15)
+		if length == 15 {
+			extra, n, ok := readLZ4Length(src[i:])
+			if !ok {
+				return nil, errLZ4Corrupt
+			}
+			length, i = length+extra, i+n
+		}
+		length += lz4MinMatch
+		from := len(dst) - offset
+		if offset == 0 || from < base || length > limit-(len(dst)-start) {
+			return nil, errLZ4Corrupt
+		}
+		if offset >= length {
+			dst = append(dst, dst[from:from+length]...)
+			continue
+		}
+		for k := range length { // Overlapping: the match repeats bytes it writes
+			dst = append(dst, dst[from+k])
+		}
+	}
+	return nil, errLZ4Corrupt // A block ends with literals
+}
+
+// readLZ4Length reads the bytes extending a length: their sum and count
+func readLZ4Length(src []byte) (int, int, bool) {
+	total := 0
+	for i, b := range src {
+		total += int(b)
+		if b != 255 {
+			return total, i + 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// The LZ4 frame format, as Arrow compresses record batch buffers with
+const (
+	lz4FrameMagic        = 0x184D2204
+	lz4SkippableMagic    = 0x184D2A50 // Through 0x184D2A5F
+	lz4FrameVersion      = 1 << 6
+	lz4FrameIndependent  = 1 << 5 // Blocks don't reach back into earlier ones
+	lz4FrameBlockSum     = 1 << 4
+	lz4FrameContentSize  = 1 << 3
+	lz4FrameContentSum   = 1 << 2
+	lz4FrameDictID       = 1 << 0
+	lz4FrameUncompressed = 1 << 31 // In a block size: the block is stored as is
+)
+
+// errLZ4FrameCorrupt is returned for data that isn't a valid LZ4 frame
+var errLZ4FrameCorrupt = errors.New("corrupt LZ4 frame")
+
+// lz4DecompressFrames appends the decompressed LZ4 frames in src to dst,
+// skipping skippable frames. Checksums are skipped rather than verified.
+// Frames that need a preset dictionary are not supported.
+func lz4DecompressFrames(dst, src []byte) ([]byte, error) {
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, errLZ4FrameCorrupt
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&^0xF == lz4SkippableMagic {
+			if len(src) < 8 {
+				return nil, errLZ4FrameCorrupt
+			}
+			size := uint64(binary.LittleEndian.Uint32(src[4:]))
+			if size > uint64(len(src)-8) {
+				return nil, errLZ4FrameCorrupt
+			}
+			src = src[8+size:]
+			continue
+		}
+		if magic != lz4FrameMagic || len(src) < 7 {
+			return nil, errLZ4FrameCorrupt
+		}
+
+		// Frame descriptor: flags, block maximum size, the optional content
+		// size and dictionary ID, and a header checksum
+		flags, bd := src[4], src[5]
+		if flags&0xC0 != lz4FrameVersion {
+			return nil, errLZ4FrameCorrupt
+		}
+		if flags&lz4FrameDictID != 0 {
+			return nil, fmt.Errorf("LZ4 frames with a preset dictionary are not supported")
+		}
+		sizeCode := int(bd>>4) & 7
+		if sizeCode < 4 {
+			return nil, errLZ4FrameCorrupt
+		}
+		blockMax := 1 << (8 + 2*sizeCode) // 64KB, 256KB, 1MB or 4MB
+		header := 7
+		if flags&lz4FrameContentSize != 0 {
+			header += 8
+		}
+		if len(src) < header {
+			return nil, errLZ4FrameCorrupt
+		}
+		src = src[header:]
+
+		frameStart := len(dst)
+		for {
+			if len(src) < 4 {
+				return nil, errLZ4FrameCorrupt
+			}
+			size := binary.LittleEndian.Uint32(src)
+			src = src[4:]
+			if size == 0 {
+				break // End mark
+			}
+			stored := size&lz4FrameUncompressed != 0
+			size &^= lz4FrameUncompressed
+			if int(size) > len(src) || int(size) > blockMax {
+				return nil, errLZ4FrameCorrupt
+			}
+			if stored {
+				dst = append(dst, src[:size]...)
+			} else {
+				history := 0
+				if flags&lz4FrameIndependent == 0 {
+					history = min(len(dst)-frameStart, lz4MaxOffset)
+				}
+				var err error
+				if dst, err = lz4Decode(dst, src[:size], history, blockMax); err != nil {
+					return nil, errLZ4FrameCorrupt
+				}
+			}
+			src = src[size:]
+			if flags&lz4FrameBlockSum != 0 {
+				if len(src) < 4 {
+					return nil, errLZ4FrameCorrupt
+				}
+				src = src[4:]
+			}
+		}
+		if flags&lz4FrameContentSum != 0 {
+			if len(src) < 4 {
+				return nil, errLZ4FrameCorrupt
+			}
+			src = src[4:]
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,418 @@
+package operators
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// MultiFileScan reads several data files as one table, one after another
+// (e.g. every file matching data/2024-*.csv). Columns are matched by name:
+// the output has every column of every file in first-seen order, NULL
+// where a file lacks one, and a column inferred with different types in
+// different files widens to fit them all (Int -> Float -> String).
+//
+// Hive-style key=value directories in the file paths (events/date=2024-01-01/
+// part-0.csv) become partition columns after the file columns, holding the
+// value from each file's path. PrunePartitions skips the files whose values
+// a filter rules out without opening them, and PruneFiles those a zone map
+// index rules out.
+type MultiFileScan struct {
+	execStats
+
+	paths       []string
+	opts        ScanOptions // Per-file options, with the reconciled column types declared
+	schema      types.Schema
+	columnIndex map[string]int
+	plans       []PlanNode      // Each file's scan plan, captured while reading its schema
+	declared    []bool          // Column types given by the caller rather than inferred
+	partitions  []int           // Output columns holding partition key values
+	values      [][]interface{} // Each file's value for each partition column
+	files       int             // Files matched, before any pruning
+	totalBytes  int64
+
+	index      int            // Position in paths of the file being read
+	current    types.Operator // Scan of that file; nil between files
+	mapping    []int          // Output column for each of its columns
+	identity   bool           // Its columns are exactly the output columns
+	bytesDone  int64          // Bytes read from files already finished
+	raggedDone int64          // Ragged records their scans skipped or padded
+}
+
+// NewMultiFileScan opens each file once to read its schema (header and
+// type-inference sample), reconciles the schemas, then scans the files in
+// the given order
+func NewMultiFileScan(paths []string, opts ScanOptions) (*MultiFileScan, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files to scan")
+	}
+
+	m := &MultiFileScan{
+		paths:       paths,
+		columnIndex: make(map[string]int),
+		index:       -1,
+	}
+	for _, path := range paths {
+		scan, err := NewFileScan(path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		schema := scan.Schema()
+		m.plans = append(m.plans, ExplainOperator(scan))
+		scan.Close()
+
+		for i, col := range schema.Columns {
+			if idx, ok := m.columnIndex[col]; ok {
+				m.schema.Types[idx] = widenType(m.schema.Types[idx], schema.Types[i])
+				continue
+			}
+			m.columnIndex[col] = len(m.schema.Columns)
+			m.schema.Columns = append(m.schema.Columns, col)
+			m.schema.Types = append(m.schema.Types, schema.Types[i])
+		}
+
+		if info, err := storage.Stat(path); err == nil {
+			m.totalBytes += info.Size
+		}
+	}
+	m.files = len(paths)
+
+	if err := m.addPartitionColumns(opts); err != nil {
+		return nil, err
+	}
+
+	for _, col := range m.schema.Columns {
+		_, ok := opts.ColumnTypes[col]
+		m.declared = append(m.declared, ok)
+	}
+
+	// Read every file with the reconciled types, so values need no conversion
+	m.opts = opts
+	m.opts.ColumnTypes = make(map[string]types.DataType, len(m.schema.Columns))
+	for i, col := range m.schema.Columns {
+		m.opts.ColumnTypes[col] = m.schema.Types[i]
+	}
+	return m, nil
+}
+
+// Next returns the next row, moving on to the next file when one ends
+func (m *MultiFileScan) Next() (*types.Row, error) {
+	start := m.startCall()
+	row, err := m.next()
+	m.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (m *MultiFileScan) next() (*types.Row, error) {
+	for {
+		if m.current == nil {
+			if m.index+1 >= len(m.paths) {
+				return nil, nil
+			}
+			if err := m.openNext(); err != nil {
+				return nil, err
+			}
+		}
+
+		row, err := m.current.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.paths[m.index], err)
+		}
+		if row == nil {
+			m.bytesDone += bytesRead(m.current)
+			m.raggedDone += raggedRows(m.current)
+			m.current.Close()
+			m.current = nil
+			continue
+		}
+
+		if m.identity {
+			return row, nil
+		}
+		values := make([]interface{}, len(m.schema.Columns))
+		for i, v := range row.Values {
+			if i < len(m.mapping) {
+				values[m.mapping[i]] = v
+			}
+		}
+		for i, col := range m.partitions {
+			values[col] = m.values[m.index][i]
+		}
+		return &types.Row{Values: values}, nil
+	}
+}
+
+// openNext opens the next file and maps its columns onto the output
+func (m *MultiFileScan) openNext() error {
+	m.index++
+	path := m.paths[m.index]
+	scan, err := NewFileScan(path, m.opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	columns := scan.Schema().Columns
+	m.mapping = make([]int, len(columns))
+	m.identity = len(columns) == len(m.schema.Columns) && len(m.partitions) == 0
+	for i, col := range columns {
+		idx, ok := m.columnIndex[col]
+		if !ok {
+			scan.Close()
+			return fmt.Errorf("%s changed while being read: new column %s", path, col)
+		}
+		m.mapping[i] = idx
+		m.identity = m.identity && idx == i
+	}
+	m.current = scan
+	return nil
+}
+
+// addPartitionColumns adds a column for each key=value directory key in
+// the paths that isn't already a file column, typed by inference over its
+// values unless declared. A file whose path lacks a key gets NULL.
+func (m *MultiFileScan) addPartitionColumns(opts ScanOptions) error {
+	var keys []string
+	keyIndex := make(map[string]int)
+	raw := make([]map[string]string, len(m.paths))
+	for i, path := range m.paths {
+		raw[i] = make(map[string]string)
+		for _, part := range PartitionValues(path) {
+			if _, isColumn := m.columnIndex[part.Key]; isColumn {
+				continue // The file's own column wins
+			}
+			if _, ok := keyIndex[part.Key]; !ok {
+				keyIndex[part.Key] = len(keys)
+				keys = append(keys, part.Key)
+			}
+			raw[i][part.Key] = part.Value
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	m.values = make([][]interface{}, len(m.paths))
+	for i := range m.values {
+		m.values[i] = make([]interface{}, len(keys))
+	}
+	for k, key := range keys {
+		var sample [][]string
+		for i := range m.paths {
+			sample = append(sample, []string{raw[i][key]})
+		}
+		dt, declared := opts.ColumnTypes[key]
+		if !declared {
+			dt = inferColumnTypes(sample, 1, nil)[0]
+		}
+		for i := range m.paths {
+			val, isSet := raw[i][key]
+			if !isSet || val == "" {
+				continue // NULL
+			}
+			parsed, ok := parseField(val, dt)
+			if !ok {
+				return fmt.Errorf("%s: partition %s=%s is not a valid %s", m.paths[i], key, val, dt)
+			}
+			m.values[i][k] = parsed
+		}
+
+		m.columnIndex[key] = len(m.schema.Columns)
+		m.partitions = append(m.partitions, len(m.schema.Columns))
+		m.schema.Columns = append(m.schema.Columns, key)
+		m.schema.Types = append(m.schema.Types, dt)
+	}
+	return nil
+}
+
+// PartitionValue is one key=value directory of a Hive-style path
+type PartitionValue struct {
+	Key   string
+	Value string // "" for Hive's __HIVE_DEFAULT_PARTITION__ (NULL)
+}
+
+// PartitionValues returns the key=value directories in a file's path, in
+// order from the root. Values are URL-unescaped, as Hive writes them.
+func PartitionValues(path string) []PartitionValue {
+	var parts []PartitionValue
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		key, value, ok := strings.Cut(dir, "=")
+		if !ok || key == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		if value == "__HIVE_DEFAULT_PARTITION__" {
+			value = ""
+		}
+		parts = append(parts, PartitionValue{Key: key, Value: value})
+	}
+	return parts
+}
+
+// PartitionColumns returns the output columns that hold partition values
+func (m *MultiFileScan) PartitionColumns() []int {
+	return m.partitions
+}
+
+// PrunePartitions drops the files for which keep isn't True, evaluated on
+// a row holding only the file's partition values (other columns NULL).
+// keep must only read partition columns. Call it before the first Next.
+func (m *MultiFileScan) PrunePartitions(keep Predicate) {
+	if len(m.partitions) == 0 {
+		return
+	}
+	m.keepFiles(func(i int) bool {
+		row := &types.Row{Values: make([]interface{}, len(m.schema.Columns))}
+		for k, col := range m.partitions {
+			row.Values[col] = m.values[i][k]
+		}
+		return keep(row) == types.True
+	})
+}
+
+// Paths returns the files left to scan, in order
+func (m *MultiFileScan) Paths() []string {
+	return m.paths
+}
+
+// PruneFiles drops the files skip reports no row of can match. Call it
+// before the first Next.
+func (m *MultiFileScan) PruneFiles(skip func(path string) bool) {
+	m.keepFiles(func(i int) bool {
+		return !skip(m.paths[i])
+	})
+}
+
+// keepFiles keeps only the files keep accepts, by position in paths
+func (m *MultiFileScan) keepFiles(keep func(i int) bool) {
+	var paths []string
+	var plans []PlanNode
+	var values [][]interface{}
+	m.totalBytes = 0
+	for i, path := range m.paths {
+		if !keep(i) {
+			continue
+		}
+		paths = append(paths, path)
+		plans = append(plans, m.plans[i])
+		if m.values != nil {
+			values = append(values, m.values[i])
+		}
+		if info, err := storage.Stat(path); err == nil {
+			m.totalBytes += info.Size
+		}
+	}
+	m.paths, m.plans, m.values = paths, plans, values
+}
+
+// OpenFile scans one of the files on its own, with the reconciled column
+// types; its schema has only the file's columns
+func (m *MultiFileScan) OpenFile(path string) (types.Operator, error) {
+	return NewFileScan(path, m.opts)
+}
+
+// bytesRead returns how many bytes a scan has read from disk, if it tracks it
+func bytesRead(op types.Operator) int64 {
+	if counter, ok := op.(interface{ BytesRead() int64 }); ok {
+		return counter.BytesRead()
+	}
+	return 0
+}
+
+// BytesRead returns the number of bytes read from all files so far
+func (m *MultiFileScan) BytesRead() int64 {
+	done := m.bytesDone
+	if m.current != nil {
+		done += bytesRead(m.current)
+	}
+	return done
+}
+
+// raggedRows returns how many records with the wrong number of fields a
+// scan has skipped or padded, if it reads CSV
+func raggedRows(op types.Operator) int64 {
+	if counter, ok := op.(interface{ RaggedRows() int64 }); ok {
+		return counter.RaggedRows()
+	}
+	return 0
+}
+
+// RaggedRows returns how many records with the wrong number of fields the
+// files' scans have skipped or padded so far
+func (m *MultiFileScan) RaggedRows() int64 {
+	done := m.raggedDone
+	if m.current != nil {
+		done += raggedRows(m.current)
+	}
+	return done
+}
+
+// Progress reports the bytes read so far and the total size of the files
+func (m *MultiFileScan) Progress() (done, total int64) {
+	return m.BytesRead(), m.totalBytes
+}
+
+// Close releases the file being read
+func (m *MultiFileScan) Close() error {
+	if m.current != nil {
+		err := m.current.Close()
+		m.current = nil
+		return err
+	}
+	return nil
+}
+
+// Inputs returns nil: the per-file scans are internal
+func (m *MultiFileScan) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the reconciled schema of all files
+func (m *MultiFileScan) Schema() types.Schema {
+	return m.schema
+}
+
+// TypeSource says whether a column's type was declared or reconciled from
+// the types inferred for each file
+func (m *MultiFileScan) TypeSource(column int) string {
+	if column >= 0 && column < len(m.declared) && m.declared[column] {
+		return "declared"
+	}
+	for _, col := range m.partitions {
+		if col == column {
+			return fmt.Sprintf("partition key, inferred across %d files", m.files)
+		}
+	}
+	return fmt.Sprintf("inferred across %d files", m.files)
+}
+
+// Explain sums the files' row estimates (unknown if any file's is)
+func (m *MultiFileScan) Explain() PlanNode {
+	details := fmt.Sprintf("%d files, %s", len(m.paths), FormatBytes(m.totalBytes))
+	if len(m.paths) < m.files {
+		details = fmt.Sprintf("%d of %d files after pruning, %s", len(m.paths), m.files, FormatBytes(m.totalBytes))
+	}
+	node := PlanNode{
+		Operator: "MultiFileScan",
+		Details:  details,
+	}
+	for _, plan := range m.plans {
+		if plan.EstimatedRows < 0 || node.EstimatedRows < 0 {
+			node.EstimatedRows = -1
+		} else {
+			node.EstimatedRows += plan.EstimatedRows
+		}
+		if plan.EstimatedRowBytes < 0 || node.EstimatedRowBytes < 0 {
+			node.EstimatedRowBytes = -1
+		} else if plan.EstimatedRowBytes > node.EstimatedRowBytes {
+			node.EstimatedRowBytes = plan.EstimatedRowBytes
+		}
+	}
+	return node
+}
@@ -0,0 +1,390 @@
+package operators
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aryamaansaha/golap/storage"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ParallelSegmentBytes is the size of the byte ranges a CSV file is split
+// into for parallel scanning (see ScanOptions.Workers)
+const ParallelSegmentBytes = 4 << 20
+
+// csvExchange scans the rest of a CSV file (after the sampled rows) with a
+// pool of workers and hands the rows back in file order. The file is
+// split into ParallelSegmentBytes chunks; each worker reads a chunk,
+// counts its quotes and newlines, and gets the quote parity at the chunk's
+// start from the previous chunk's worker. A segment then runs from the
+// first newline outside quotes at or after its chunk's start to the first
+// one after the next chunk's start, so a quoted field spanning lines is
+// never split. Workers parse, convert and filter (pushed predicates) their
+// segment; at most twice as many segments as workers are held at once.
+// Blocks pruned by the zone map (see PruneBlocks) are left out: the bytes
+// between them are chunked separately, each range starting at a row.
+type csvExchange struct {
+	scan            *CSVScan
+	file            *os.File // Nil when reading mapped
+	mapped          []byte   // The whole file, when mapped (ScanOptions.Mmap)
+	start           int64    // First byte after the sampled rows
+	size            int64
+	chunks          int
+	chunkRanges     []chunkRange
+	results         []chan segmentResult // By segment, buffered
+	prefix          []chan chunkPrefix   // Parity and lines before each chunk
+	slots           chan struct{}        // Limits segments in flight
+	cancel          chan struct{}
+	comma           rune // The scan's reader settings, for the workers' readers
+	fieldsPerRecord int
+	ragged          RaggedRowPolicy
+	wg              sync.WaitGroup
+	read            atomic.Int64 // Bytes read by workers
+	buffers         sync.Pool    // *[]byte segment buffers, reused once parsed
+	rowSlices       sync.Pool    // *[]*types.Row, reused once returned
+
+	segment int          // Segment being returned
+	rows    []*types.Row // Its rows
+	pos     int          // Next row in rows
+	err     error        // Returned after rows, which precede it in the file
+	closed  bool
+}
+
+// chunkRange is a chunk's bytes and those of the range of unpruned
+// blocks it is part of
+type chunkRange struct {
+	start, end int64
+	rangeEnd   int64 // End of its range, a row boundary
+	first      bool  // It starts its range, at a row
+	lines      int   // Lines before a range's first chunk (but the file's first)
+}
+
+// chunkPrefix is what the chunks before one contain: whether an odd
+// number of quotes (so the chunk starts inside a quoted field), and how
+// many lines (for error messages)
+type chunkPrefix struct {
+	inQuotes bool
+	lines    int
+}
+
+// segmentResult is a parsed segment: its rows (or, when aggregating in
+// parallel, their groups), and the error that stopped it, if any, after
+// those rows
+type segmentResult struct {
+	rows   []*types.Row
+	groups *groupTable
+	err    error
+}
+
+// canScanInParallel reports whether the rest of the file can be split
+// into segments: a large, local, uncompressed UTF-8 file not resumed at
+// an offset
+func (s *CSVScan) canScanInParallel() bool {
+	if s.workers < 2 || s.gzipReader != nil || s.baseOffset > 0 || s.fileSize < 0 {
+		return false
+	}
+	if encoding, _ := ParseEncoding(s.encoding); encoding != EncodingUTF8 {
+		return false
+	}
+	return !storage.IsRemote(s.filePath) && s.fileSize-s.restOffset > ParallelSegmentBytes
+}
+
+// newCSVExchange starts the workers over [scan.restOffset, size)
+func newCSVExchange(scan *CSVScan) (*csvExchange, error) {
+	e := &csvExchange{
+		scan:            scan,
+		start:           scan.restOffset,
+		comma:           scan.delimiter,
+		fieldsPerRecord: scan.reader.fieldsPerRecord,
+		ragged:          scan.reader.ragged,
+		size:            scan.fileSize,
+		slots:           make(chan struct{}, 2*scan.workers),
+		cancel:          make(chan struct{}),
+	}
+	for _, kept := range scan.keptRanges(e.start, e.size) {
+		for start := kept.start; start < kept.end; start += ParallelSegmentBytes {
+			e.chunkRanges = append(e.chunkRanges, chunkRange{
+				start:    start,
+				end:      min(start+ParallelSegmentBytes, kept.end),
+				rangeEnd: kept.end,
+				first:    start == kept.start,
+				lines:    max(kept.line-1, 0),
+			})
+		}
+	}
+	e.chunks = len(e.chunkRanges)
+	e.results = make([]chan segmentResult, e.chunks)
+	e.prefix = make([]chan chunkPrefix, e.chunks+1)
+	for i := range e.results {
+		e.results[i] = make(chan segmentResult, 1)
+	}
+	for i := range e.prefix {
+		e.prefix[i] = make(chan chunkPrefix, 1)
+	}
+
+	if mapped, ok := scan.file.(*storage.MappedFile); ok {
+		e.mapped = mapped.Bytes()
+	} else {
+		file, err := os.Open(scan.filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open CSV file: %w", err)
+		}
+		e.file = file
+	}
+
+	// Lines before the first chunk, for error messages
+	head, err := e.readAt(nil, 0, e.start)
+	if err != nil {
+		e.closeFile()
+		return nil, err
+	}
+	e.read.Store(0) // The scan's own reader counted the head
+	e.prefix[0] <- chunkPrefix{lines: bytes.Count(head, []byte{'\n'})}
+
+	jobs := make(chan int)
+	e.wg.Add(1)
+	go func() { // Dispatch segments in order, as slots free up
+		defer e.wg.Done()
+		defer close(jobs)
+		for i := 0; i < e.chunks; i++ {
+			select {
+			case e.slots <- struct{}{}:
+			case <-e.cancel:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-e.cancel:
+				return
+			}
+		}
+	}()
+	for w := 0; w < scan.workers; w++ {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			for i := range jobs {
+				rows, err := e.scanSegment(i)
+				if partial := e.scan.partial; partial != nil {
+					groups := partial.aggregate(rows)
+					clear(rows)
+					rows = rows[:0]
+					e.rowSlices.Put(&rows)
+					e.results[i] <- segmentResult{groups: groups, err: err}
+					continue
+				}
+				e.results[i] <- segmentResult{rows: rows, err: err}
+			}
+		}()
+	}
+	return e, nil
+}
+
+// errExchangeClosed stops a worker whose scan was closed
+var errExchangeClosed = errors.New("scan closed")
+
+// scanSegment reads chunk i, passes the quote parity and line count on to
+// chunk i+1, and parses the segment starting in chunk i
+func (e *csvExchange) scanSegment(i int) ([]*types.Row, error) {
+	chunk := e.chunkRanges[i]
+	chunkStart, chunkEnd := chunk.start, chunk.end
+	var buffer []byte
+	if pooled, ok := e.buffers.Get().(*[]byte); ok {
+		buffer = *pooled
+	}
+	data, err := e.readAt(buffer, chunkStart, chunkEnd-chunkStart)
+	if err != nil {
+		e.prefix[i+1] <- chunkPrefix{} // Unblock the next chunk; this error is returned first
+		return nil, err
+	}
+
+	var before chunkPrefix
+	select {
+	case before = <-e.prefix[i]:
+	case <-e.cancel:
+		return nil, errExchangeClosed
+	}
+	if chunk.first && chunk.start > e.start {
+		before = chunkPrefix{lines: chunk.lines} // A range after pruned blocks
+	}
+	quotes := bytes.Count(data, []byte{'"'})
+	e.prefix[i+1] <- chunkPrefix{
+		inQuotes: before.inQuotes != (quotes%2 == 1),
+		lines:    before.lines + bytes.Count(data, []byte{'\n'}),
+	}
+
+	// The segment starts after the first newline outside quotes in this
+	// chunk (at its start for the first chunk) and ends after the first
+	// one past the chunk's end, which may need reading ahead
+	from := 0
+	if !chunk.first {
+		from = recordBoundary(data, before.inQuotes)
+		if from < 0 {
+			return nil, nil // A single record spans the whole chunk
+		}
+	}
+	inQuotes := before.inQuotes != (quotes%2 == 1)
+	if e.mapped != nil { // Slice the segment out of the mapping, uncopied
+		end := chunk.rangeEnd
+		if n := recordBoundary(e.mapped[chunkEnd:chunk.rangeEnd], inQuotes); n >= 0 {
+			end = chunkEnd + int64(n)
+		}
+		e.read.Add(end - chunkEnd)
+		data = e.mapped[chunkStart:end:end]
+		chunkEnd = chunk.rangeEnd // Nothing left to read ahead
+	}
+	for chunkEnd < chunk.rangeEnd {
+		n := len(data)
+		data, err = e.readAt(data, chunkEnd, min(64*1024, chunk.rangeEnd-chunkEnd))
+		if err != nil {
+			return nil, err
+		}
+		ahead := data[n:]
+		if end := recordBoundary(ahead, inQuotes); end >= 0 {
+			data = data[:n+end]
+			break
+		}
+		inQuotes = inQuotes != (bytes.Count(ahead, []byte{'"'})%2 == 1)
+		chunkEnd += int64(len(ahead))
+	}
+
+	lineBase := before.lines + bytes.Count(data[:from], []byte{'\n'})
+	rows, err := e.parse(data[from:], lineBase)
+	if e.mapped == nil {
+		// Rows copy the text they keep, so the next segment can reuse data
+		data = data[:0]
+		e.buffers.Put(&data)
+	}
+	return rows, err
+}
+
+// readAt appends n bytes of the file at off to buf, reusing its array. A
+// mapped file's bytes are instead returned as a slice of the mapping.
+func (e *csvExchange) readAt(buf []byte, off, n int64) ([]byte, error) {
+	if e.mapped != nil {
+		e.read.Add(n)
+		return e.mapped[off : off+n : off+n], nil
+	}
+	data := slices.Grow(buf, int(n))[:len(buf)+int(n)]
+	read, err := e.file.ReadAt(data[len(buf):], off)
+	e.read.Add(int64(read))
+	if err != nil && !(err == io.EOF && int64(read) == n) {
+		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	return data, nil
+}
+
+// recordBoundary returns the offset just past the first newline in data
+// that is outside quotes, given whether data starts inside a quoted
+// field; -1 if there is none
+func recordBoundary(data []byte, inQuotes bool) int {
+	for i, b := range data {
+		switch b {
+		case '"':
+			inQuotes = !inQuotes
+		case '\n':
+			if !inQuotes {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// parse reads the records of a segment, converting and filtering them as
+// CSVScan.Next does; lineBase is the number of lines before it
+func (e *csvExchange) parse(segment []byte, lineBase int) ([]*types.Row, error) {
+	s := e.scan
+	reader := newCSVBytesReader(segment, e.comma)
+	reader.fieldsPerRecord, reader.ragged = e.fieldsPerRecord, e.ragged
+	defer func() { s.raggedRows.Add(reader.raggedRows) }()
+
+	var rows []*types.Row
+	if pooled, ok := e.rowSlices.Get().(*[]*types.Row); ok {
+		rows = *pooled
+	}
+	row := types.GetRow(0) // Reused while pushed predicates reject records
+	for count := 0; ; count++ {
+		if count%1024 == 0 {
+			select {
+			case <-e.cancel:
+				return nil, errExchangeClosed
+			default:
+			}
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.StartLine += lineBase
+				parseErr.Line += lineBase
+			}
+			return rows, fmt.Errorf("error reading CSV row: %w", err)
+		}
+		resetValues(row, s.recordWidth(record))
+		passed, err := s.convertRecord(record, row, lineBase)
+		if err != nil {
+			return rows, err
+		}
+		if passed {
+			rows = append(rows, row)
+			row = types.GetRow(0)
+		}
+	}
+}
+
+// next returns the next row in file order, or (nil, nil) after the last
+func (e *csvExchange) next() (*types.Row, error) {
+	for e.pos >= len(e.rows) {
+		if e.err != nil {
+			return nil, e.err
+		}
+		if e.segment >= e.chunks {
+			return nil, nil
+		}
+		if err := e.scan.cancel.err(); err != nil {
+			return nil, err
+		}
+		result := <-e.results[e.segment]
+		<-e.slots
+		e.segment++
+		if e.rows != nil {
+			rows := e.rows[:0] // All returned, and cleared as they were
+			e.rowSlices.Put(&rows)
+		}
+		e.rows, e.pos, e.err = result.rows, 0, result.err
+	}
+	row := e.rows[e.pos]
+	e.rows[e.pos] = nil // Let returned rows be collected
+	e.pos++
+	return row, nil
+}
+
+// close stops the workers and waits for them
+func (e *csvExchange) close() {
+	if e.closed {
+		return
+	}
+	e.closed = true
+	close(e.cancel)
+	e.wg.Wait()
+	e.closeFile()
+}
+
+// closeFile closes the exchange's own file handle; a mapping belongs to
+// the scan, which unmaps it after the workers stop
+func (e *csvExchange) closeFile() {
+	if e.file != nil {
+		e.file.Close()
+	}
+}
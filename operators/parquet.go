@@ -0,0 +1,454 @@
+package operators
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Parquet files are written, not read: COPY and CREATE TABLE AS with a
+// .parquet target hand a query's rows to the tools that read Parquet.
+// Layout, per the Apache Parquet format:
+//
+//	PAR1 | column chunks of row group 1 | ... | footer | footer length (uint32 LE) | PAR1
+//
+// Every column is OPTIONAL: Int is INT64, Float DOUBLE and String a UTF8
+// BYTE_ARRAY. Each column chunk is a series of v1 data pages holding the
+// definition levels (RLE, 1 = present) then the present values, PLAIN
+// encoded and gzip-compressed. The footer (FileMetaData) and the page
+// headers are Thrift structs in the compact protocol; each chunk carries
+// its NULL count and min/max so readers can skip row groups.
+const parquetMagic = "PAR1"
+
+// ParquetRowGroupRows is the number of rows per Parquet row group
+const ParquetRowGroupRows = 65536
+
+// parquetPageRows is the number of rows per data page
+const parquetPageRows = 8192
+
+// Parquet enum values the writer uses
+const (
+	parquetInt64     = 2 // Type
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1 // FieldRepetitionType
+	parquetUTF8     = 0 // ConvertedType
+	parquetPlain    = 0 // Encoding
+	parquetRLE      = 3
+	parquetGzip     = 2 // CompressionCodec
+	parquetDataPage = 0 // PageType
+)
+
+// isParquetPath reports whether a path names a Parquet file
+func isParquetPath(filePath string) bool {
+	return strings.HasSuffix(strings.ToLower(filePath), ".parquet")
+}
+
+// parquetChunk is a written column chunk, for the footer
+type parquetChunk struct {
+	offset           int64 // Of its first page
+	values           int64 // Including NULLs
+	nulls            int64
+	uncompressedSize int64 // Of its pages, headers included
+	compressedSize   int64
+	min, max         []byte // PLAIN-encoded; nil if unknown
+}
+
+// parquetRowGroup is a written row group, for the footer
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// ParquetWriteOp streams its input into a Parquet file (COPY ... TO or
+// CREATE TABLE AS with a .parquet target), buffering one row group at a
+// time, through a fileWriter (see write.go).
+type ParquetWriteOp struct {
+	fileWriter
+}
+
+// NewParquetWriteOp creates a Parquet writer operator targeting the given
+// path
+func NewParquetWriteOp(input types.Operator, targetPath string) *ParquetWriteOp {
+	return &ParquetWriteOp{newFileWriter(input, targetPath, &parquetEncoder{}, false)}
+}
+
+// parquetEncoder writes a Parquet file: the magic, then each row group's
+// column chunks, then the footer
+type parquetEncoder struct {
+	out       *offsetWriter
+	schema    types.Schema
+	groups    []parquetRowGroup
+	rows      int64
+	columns   [][]interface{}
+	groupRows int
+}
+
+func (e *parquetEncoder) begin(out io.Writer, schema types.Schema) error {
+	e.out, e.schema = &offsetWriter{writer: out}, schema
+	e.columns = make([][]interface{}, len(schema.Columns))
+	if _, err := io.WriteString(e.out, parquetMagic); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func (e *parquetEncoder) write(row *types.Row) error {
+	for j, v := range row.Values {
+		if j >= len(e.columns) {
+			break
+		}
+		v, err := golapValue(v, e.schema.Types[j])
+		if err != nil {
+			return fmt.Errorf("row %d, column %s: %w", e.rows+int64(e.groupRows)+1, e.schema.Columns[j], err)
+		}
+		e.columns[j] = append(e.columns[j], v)
+	}
+	for j := len(row.Values); j < len(e.columns); j++ {
+		e.columns[j] = append(e.columns[j], nil)
+	}
+	e.groupRows++
+	if e.groupRows == ParquetRowGroupRows {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (e *parquetEncoder) flush() error {
+	group := parquetRowGroup{rows: int64(e.groupRows)}
+	for j, values := range e.columns {
+		chunk, err := writeParquetChunk(e.out, values)
+		if err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		group.chunks = append(group.chunks, chunk)
+		e.columns[j] = values[:0]
+	}
+	e.groups = append(e.groups, group)
+	e.rows += int64(e.groupRows)
+	e.groupRows = 0
+	return nil
+}
+
+func (e *parquetEncoder) end() error {
+	if e.groupRows > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	footer := encodeParquetFooter(e.schema, e.groups, e.rows)
+	tail := binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	tail = append(tail, parquetMagic...)
+	if _, err := e.out.Write(tail); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if err := e.out.flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// writeParquetChunk writes one column of a row group as data pages of up
+// to parquetPageRows values each
+func writeParquetChunk(out *offsetWriter, values []interface{}) (parquetChunk, error) {
+	chunk := parquetChunk{offset: out.offset, values: int64(len(values))}
+	var minValue, maxValue interface{}
+	hasNaN := false
+	var page, compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	for start := 0; start < len(values); start += parquetPageRows {
+		end := min(start+parquetPageRows, len(values))
+
+		// Definition levels, as RLE runs, then the present values
+		var levels []byte
+		for i := start; i < end; {
+			present := values[i] != nil
+			run := i + 1
+			for run < end && (values[run] != nil) == present {
+				run++
+			}
+			levels = binary.AppendUvarint(levels, uint64(run-i)<<1)
+			if present {
+				levels = append(levels, 1)
+			} else {
+				levels = append(levels, 0)
+			}
+			i = run
+		}
+		page.Reset()
+		page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		page.Write(levels)
+		for _, v := range values[start:end] {
+			if v == nil {
+				chunk.nulls++
+				continue
+			}
+			var buf [8]byte
+			switch v := v.(type) {
+			case int64:
+				binary.LittleEndian.PutUint64(buf[:], uint64(v))
+				page.Write(buf[:])
+			case float64:
+				binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+				page.Write(buf[:])
+				hasNaN = hasNaN || math.IsNaN(v)
+			case string:
+				binary.LittleEndian.PutUint32(buf[:4], uint32(len(v)))
+				page.Write(buf[:4])
+				page.WriteString(v)
+			}
+			if minValue == nil || compareValues(v, minValue) < 0 {
+				minValue = v
+			}
+			if maxValue == nil || compareValues(v, maxValue) > 0 {
+				maxValue = v
+			}
+		}
+
+		compressed.Reset()
+		gz.Reset(&compressed)
+		gz.Write(page.Bytes())
+		if err := gz.Close(); err != nil {
+			return chunk, err
+		}
+
+		var header thriftWriter
+		header.beginStruct()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.fieldStruct(5) // DataPageHeader
+		header.i32(1, int32(end-start))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		if _, err := out.Write(header.buf); err != nil {
+			return chunk, err
+		}
+		if _, err := out.Write(compressed.Bytes()); err != nil {
+			return chunk, err
+		}
+		chunk.uncompressedSize += int64(len(header.buf) + page.Len())
+		chunk.compressedSize += int64(len(header.buf) + compressed.Len())
+	}
+
+	if minValue != nil && !hasNaN {
+		chunk.min, chunk.max = parquetStat(minValue, true), parquetStat(maxValue, false)
+	}
+	return chunk, nil
+}
+
+// parquetStat PLAIN-encodes a min or max for the footer. A zero float
+// min is written as -0 and a max as +0, as the format asks, since the two
+// compare equal.
+func parquetStat(v interface{}, isMin bool) []byte {
+	switch v := v.(type) {
+	case int64:
+		return binary.LittleEndian.AppendUint64(nil, uint64(v))
+	case float64:
+		if v == 0 {
+			v = 0
+			if isMin {
+				v = math.Copysign(0, -1)
+			}
+		}
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v))
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+// parquetType returns a column type's Parquet physical type
+func parquetType(dt types.DataType) int32 {
+	switch dt {
+	case types.Int:
+		return parquetInt64
+	case types.Float:
+		return parquetDouble
+	default:
+		return parquetByteArray
+	}
+}
+
+// encodeParquetFooter encodes the FileMetaData: the schema (a root with
+// a child per column), the row groups' column chunks and, so readers trust
+// the min/max, each column's sort order
+func encodeParquetFooter(schema types.Schema, groups []parquetRowGroup, rows int64) []byte {
+	var t thriftWriter
+	t.beginStruct()
+	t.i32(1, 1) // version
+
+	t.fieldList(2, thriftStruct, len(schema.Columns)+1)
+	t.beginStruct()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(schema.Columns)))
+	t.endStruct()
+	for i, col := range schema.Columns {
+		t.beginStruct()
+		t.i32(1, parquetType(schema.Types[i]))
+		t.i32(3, parquetOptional)
+		t.binary(4, col)
+		if schema.Types[i] == types.String {
+			t.i32(6, parquetUTF8)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, rows)
+
+	t.fieldList(4, thriftStruct, len(groups))
+	for _, group := range groups {
+		t.beginStruct()
+		t.fieldList(1, thriftStruct, len(group.chunks))
+		var totalSize int64
+		for i, chunk := range group.chunks {
+			totalSize += chunk.uncompressedSize
+			t.beginStruct()
+			t.i64(2, chunk.offset)
+			t.fieldStruct(3) // ColumnMetaData
+			t.i32(1, parquetType(schema.Types[i]))
+			t.fieldList(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.fieldList(3, thriftBinary, 1)
+			t.listBinary(schema.Columns[i])
+			t.i32(4, parquetGzip)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset)
+			t.fieldStruct(12) // Statistics
+			t.i64(3, chunk.nulls)
+			if chunk.min != nil {
+				t.binary(5, string(chunk.max))
+				t.binary(6, string(chunk.min))
+			}
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, totalSize)
+		t.i64(3, group.rows)
+		t.endStruct()
+	}
+
+	t.binary(6, "golap")
+
+	t.fieldList(7, thriftStruct, len(schema.Columns))
+	for range schema.Columns {
+		t.beginStruct()
+		t.fieldStruct(1) // TYPE_ORDER
+		t.endStruct()
+		t.endStruct()
+	}
+	t.endStruct()
+	return t.buf
+}
+
+// Explain describes the output file
+func (w *ParquetWriteOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "ParquetWrite",
+		Details:           w.targetPath,
+		EstimatedRows:     1,
+		EstimatedRowBytes: -1,
+		Children:          []PlanNode{ExplainOperator(w.input)},
+	}
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Thrift structs in the compact protocol, which
+// Parquet uses for its metadata. Fields must be written in increasing id
+// order within a struct.
+type thriftWriter struct {
+	buf     []byte
+	lastIDs []int16 // Last field id of each open struct
+}
+
+// beginStruct opens a struct (one that's a list element; fieldStruct
+// opens a struct field)
+func (t *thriftWriter) beginStruct() {
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+// endStruct closes the innermost struct
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0) // Stop field
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+// field writes a field header: the id as a delta from the last when it
+// fits in four bits, else in full
+func (t *thriftWriter) field(id int16, fieldType byte) {
+	last := &t.lastIDs[len(t.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|fieldType)
+	} else {
+		t.buf = append(t.buf, fieldType)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// fieldStruct opens a struct field, closed by endStruct
+func (t *thriftWriter) fieldStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginStruct()
+}
+
+// fieldList writes a list field's header; its n elements follow
+func (t *thriftWriter) fieldList(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+// listI32 writes an i32 list element
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+// listBinary writes a binary list element (or a binary field's value)
+func (t *thriftWriter) listBinary(v string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
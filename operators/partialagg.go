@@ -0,0 +1,253 @@
+package operators
+
+import (
+	"fmt"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Partial aggregation: a parallel CSV scan's workers aggregate the rows of
+// their segments into groupTables, and HashAggregateOp merges the tables
+// in file order. A group's states then start from the first segment it
+// appears in, so groups come out in the order they would row by row.
+
+// mergeable reports whether partial states of the aggregate can be
+// combined exactly: all but APPROX_TOP_K, whose sketches only estimate
+func (a AggregateExpr) mergeable() bool {
+	return a.Type != types.ApproxTopK
+}
+
+// merge adds to a state the state of the same aggregate over later rows
+func (s *aggregateState) merge(other *aggregateState, agg AggregateExpr) {
+	if agg.Type == types.LatestBy {
+		s.count += other.count
+		// On ties the later rows' value wins, as in updateLatest
+		if other.hasData && (!s.hasData || compareValues(other.latestOrder, s.latestOrder) >= 0) {
+			s.latest, s.latestOrder, s.hasData = other.latest, other.latestOrder, true
+		}
+		return
+	}
+	s.count += other.count
+	s.sum += other.sum
+	sum := s.intSum + other.intSum
+	s.overflow = s.overflow || other.overflow ||
+		(other.intSum > 0 && sum < s.intSum) || (other.intSum < 0 && sum > s.intSum)
+	s.intSum = sum
+	s.hasData = s.hasData || other.hasData
+	if other.min != nil && (s.min == nil || compareCollated(other.min, s.min, agg.Collation) < 0) {
+		s.min = other.min
+	}
+	if other.max != nil && (s.max == nil || compareCollated(other.max, s.max, agg.Collation) > 0) {
+		s.max = other.max
+	}
+}
+
+// groupTable is one segment's GROUP BY groups, in the order they first
+// appear in it
+type groupTable struct {
+	groups map[string]*groupState
+	keys   []string
+	rows   int // Rows aggregated into the groups
+}
+
+// partialAggregation is what a parallel scan's workers aggregate by
+type partialAggregation struct {
+	schema         types.Schema
+	groupByIndices []int
+	collation      Collation
+	aggregates     []AggregateExpr
+	direct         []bool
+	needRow        bool
+}
+
+// newPartialAggregation aggregates rows of schema
+func newPartialAggregation(schema types.Schema, groupByIndices []int, aggregates []AggregateExpr, collation Collation) *partialAggregation {
+	direct, needRow := batchAggregates(aggregates, len(schema.Columns))
+	return &partialAggregation{
+		schema:         schema,
+		groupByIndices: groupByIndices,
+		collation:      collation,
+		aggregates:     aggregates,
+		direct:         direct,
+		needRow:        needRow,
+	}
+}
+
+// aggregate returns the groups of rows, releasing the rows. Safe to call
+// from several goroutines.
+func (p *partialAggregation) aggregate(rows []*types.Row) *groupTable {
+	table := &groupTable{groups: make(map[string]*groupState), rows: len(rows)}
+	var builder batchBuilder
+	var row types.Row
+	var key []byte
+	for start := 0; start < len(rows); start += types.BatchSize {
+		builder.reset(p.schema)
+		for _, r := range rows[start:min(start+types.BatchSize, len(rows))] {
+			builder.add(r.Values)
+			types.ReleaseRow(r)
+		}
+		batch := builder.finish()
+		for i := range batch.Length {
+			key = appendGroupKey(key[:0], p.groupByIndices, batch, i, p.collation)
+			group, exists := table.groups[string(key)]
+			if !exists {
+				group = newGroupState(p.groupByIndices, len(p.aggregates), batch, i)
+				table.groups[string(key)] = group
+				table.keys = append(table.keys, string(key))
+			}
+			if p.needRow {
+				batch.Row(i, &row)
+			}
+			group.update(p.aggregates, p.direct, batch, i, &row)
+		}
+	}
+	return table
+}
+
+// AggregateInParallel makes the scan's parallel workers aggregate their
+// segments by groupByIndices (text by collation), for a HashAggregateOp to
+// merge, instead of
+// returning rows. It must be called before the first row is read, and
+// reports whether the scan will: only if the file is scanned in parallel
+// and every aggregate is mergeable.
+func (s *CSVScan) AggregateInParallel(groupByIndices []int, aggregates []AggregateExpr, collation Collation) bool {
+	if s.sequential || s.exchange != nil || !s.canScanInParallel() {
+		return false
+	}
+	for _, agg := range aggregates {
+		if !agg.mergeable() {
+			return false
+		}
+	}
+	s.partial = newPartialAggregation(s.schema, groupByIndices, aggregates, collation)
+	return true
+}
+
+// nextPartial returns the next table of groups, in file order: the sampled
+// rows' (aggregated here, before the workers start), then each segment's.
+// Returns nil after the last. The table's rows count as the scan's.
+func (s *CSVScan) nextPartial() (*groupTable, error) {
+	start := s.startCall()
+	table, err := s.nextTable()
+	rows := 0
+	if table != nil {
+		rows = table.rows
+	}
+	s.endCall(start, rows)
+	return table, err
+}
+
+// nextTable is nextPartial, uncounted
+func (s *CSVScan) nextTable() (*groupTable, error) {
+	if s.exchange == nil && !s.sequential {
+		var rows []*types.Row
+		for s.sampleIndex < len(s.sample) {
+			record, err := s.nextRecord()
+			if err != nil {
+				return nil, err
+			}
+			row := types.GetRow(s.recordWidth(record))
+			passed, err := s.convertRecord(record, row, 0)
+			if err != nil {
+				return nil, err
+			}
+			if !passed {
+				types.ReleaseRow(row)
+				continue
+			}
+			rows = append(rows, row)
+		}
+		if err := s.startExchange(); err != nil {
+			return nil, err
+		}
+		return s.partial.aggregate(rows), nil
+	}
+	if s.exchange == nil {
+		return nil, nil
+	}
+	return s.exchange.nextPartial()
+}
+
+// nextPartial returns the next segment's groups, or nil after the last
+func (e *csvExchange) nextPartial() (*groupTable, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.segment >= e.chunks {
+		return nil, nil
+	}
+	if err := e.scan.cancel.err(); err != nil {
+		return nil, err
+	}
+	result := <-e.results[e.segment]
+	<-e.slots
+	e.segment++
+	e.err = result.err // Returned after the groups of the rows before it
+	return result.groups, nil
+}
+
+// The state of a spilled group is written as its key values, then for each
+// aggregate these fields (see appendGroupRecord). Its key is binary, so it
+// is built again from the key values rather than written
+const spilledStateFields = 9
+
+// appendGroupRecord appends a group's key values and states as the fields
+// of a spill record
+func appendGroupRecord(record []string, values []interface{}, group *groupState) ([]string, []interface{}) {
+	values = append(values[:0], group.keyValues...)
+	for i := range group.states {
+		state := &group.states[i]
+		hasData, overflow := int64(0), int64(0)
+		if state.hasData {
+			hasData = 1
+		}
+		if state.overflow {
+			overflow = 1
+		}
+		values = append(values, state.count, state.sum, state.intSum, overflow, state.min, state.max, hasData, state.latest, state.latestOrder)
+	}
+	return appendSpillRecord(record, values), values
+}
+
+// parseGroupRecord reads back a record written by appendGroupRecord
+// with its key built by collation
+func parseGroupRecord(record []string, values []interface{}, numKeys, numAggregates int, collation Collation) (string, *groupState, []interface{}, error) {
+	values, err := parseSpillRecord(values[:0], record)
+	if err != nil {
+		return "", nil, values, err
+	}
+	if len(values) != numKeys+numAggregates*spilledStateFields {
+		return "", nil, values, fmt.Errorf("corrupt temp file: bad group record")
+	}
+	group := &groupState{
+		keyValues: append([]interface{}(nil), values[:numKeys]...),
+		states:    make([]aggregateState, numAggregates),
+	}
+	var key []byte
+	for _, v := range group.keyValues {
+		key = appendGroupKeyValue(key, v, collation)
+	}
+	for i := range group.states {
+		fields := values[numKeys+i*spilledStateFields:]
+		count, ok1 := fields[0].(int64)
+		sum, ok2 := fields[1].(float64)
+		intSum, ok3 := fields[2].(int64)
+		overflow, ok4 := fields[3].(int64)
+		hasData, ok5 := fields[6].(int64)
+		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+			return "", nil, values, fmt.Errorf("corrupt temp file: bad group record")
+		}
+		group.states[i] = aggregateState{
+			count:       count,
+			sum:         sum,
+			intSum:      intSum,
+			overflow:    overflow == 1,
+			min:         fields[4],
+			max:         fields[5],
+			hasData:     hasData == 1,
+			latest:      fields[7],
+			latestOrder: fields[8],
+		}
+	}
+	return string(key), group, values, nil
+}
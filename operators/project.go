@@ -1,15 +1,27 @@
 package operators
 
 import (
+	"slices"
+	"strings"
+
 	"github.com/aryamaansaha/golap/types"
 )
 
 // ProjectOp projects (selects) specific columns from the input
 type ProjectOp struct {
+	execStats
+
 	input         types.Operator
 	columnIndices []int        // Indices of columns to project
+	exprs         []ValueExpr  // Computed output columns; replaces columnIndices
 	outputSchema  types.Schema // Schema of projected output
 	passthrough   bool         // If true, return input rows unchanged (SELECT *)
+
+	batches *batchReader   // Input batches, once NextBatch is used
+	batch   types.RowBatch // Output batch selecting input columns
+	nulls   types.AnyVector
+	builder batchBuilder // Output batch of computed columns
+	row     types.Row    // The batch row expressions are evaluated on
 }
 
 // NewProjectOp creates a new projection operator
@@ -47,6 +59,16 @@ func NewProjectOp(input types.Operator, columnIndices []int) *ProjectOp {
 	}
 }
 
+// NewExprProjectOp creates a projection computing each output column from
+// an expression (e.g. BUCKET(id, 8)); schema names and types the outputs
+func NewExprProjectOp(input types.Operator, exprs []ValueExpr, schema types.Schema) *ProjectOp {
+	return &ProjectOp{
+		input:        input,
+		exprs:        exprs,
+		outputSchema: schema,
+	}
+}
+
 // NewProjectOpByNames creates a projection operator using column names
 // If columnNames is nil or empty, operates in passthrough mode (SELECT *)
 func NewProjectOpByNames(input types.Operator, columnNames []string) *ProjectOp {
@@ -65,6 +87,14 @@ func NewProjectOpByNames(input types.Operator, columnNames []string) *ProjectOp
 
 // Next returns the next projected row
 func (p *ProjectOp) Next() (*types.Row, error) {
+	start := p.startCall()
+	row, err := p.next()
+	p.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (p *ProjectOp) next() (*types.Row, error) {
 	row, err := p.input.Next()
 	if err != nil || row == nil {
 		return row, err
@@ -75,17 +105,78 @@ func (p *ProjectOp) Next() (*types.Row, error) {
 		return row, nil
 	}
 
+	if p.exprs != nil {
+		projected := types.GetRow(len(p.exprs))
+		for i, expr := range p.exprs {
+			projected.Values[i] = expr(row)
+		}
+		types.ReleaseRow(row)
+		return projected, nil
+	}
+
 	// Build projected row with only selected columns
-	values := make([]interface{}, len(p.columnIndices))
+	projected := types.GetRow(len(p.columnIndices))
 	for i, idx := range p.columnIndices {
 		if idx >= 0 && idx < len(row.Values) {
-			values[i] = row.Values[idx]
-		} else {
-			values[i] = nil
+			projected.Values[i] = row.Values[idx]
 		}
 	}
+	types.ReleaseRow(row)
+	return projected, nil
+}
 
-	return &types.Row{Values: values}, nil
+// NextBatch returns the next projected batch. Selected columns are the
+// input's vectors, uncopied; computed columns are evaluated for the
+// selected rows only.
+func (p *ProjectOp) NextBatch() (*types.RowBatch, error) {
+	start := p.startCall()
+	batch, err := p.nextBatch()
+	p.endCall(start, batchRows(batch))
+	return batch, err
+}
+
+// nextBatch is NextBatch, uncounted
+func (p *ProjectOp) nextBatch() (*types.RowBatch, error) {
+	if p.batches == nil {
+		p.batches = newBatchReader(p.input)
+	}
+	batch, err := p.batches.next()
+	if err != nil || batch == nil || p.passthrough {
+		return batch, err
+	}
+
+	if p.exprs != nil {
+		p.builder.reset(p.outputSchema)
+		values := make([]interface{}, len(p.exprs))
+		for k := range batch.Rows() {
+			batch.Row(batch.Position(k), &p.row)
+			for i, expr := range p.exprs {
+				values[i] = expr(&p.row)
+			}
+			p.builder.add(values)
+		}
+		if out := p.builder.finish(); out != nil {
+			return out, nil
+		}
+		return &types.RowBatch{Columns: make([]types.Vector, len(p.exprs))}, nil // All rows filtered out
+	}
+
+	if p.batch.Columns == nil {
+		p.batch.Columns = make([]types.Vector, len(p.columnIndices))
+	}
+	for i, idx := range p.columnIndices {
+		if idx >= 0 && idx < len(batch.Columns) {
+			p.batch.Columns[i] = batch.Columns[idx]
+			continue
+		}
+		if len(p.nulls) < batch.Length {
+			p.nulls = make(types.AnyVector, max(batch.Length, types.BatchSize))
+		}
+		p.batch.Columns[i] = p.nulls[:batch.Length]
+	}
+	p.batch.Length = batch.Length
+	p.batch.Selection = batch.Selection
+	return &p.batch, nil
 }
 
 // Close releases resources
@@ -93,7 +184,50 @@ func (p *ProjectOp) Close() error {
 	return p.input.Close()
 }
 
+// SortOrder returns the input's order as far as its columns are kept: the
+// leading sort keys that are projected columns. Computed columns have no
+// known order.
+func (p *ProjectOp) SortOrder() []SortKey {
+	if p.passthrough {
+		return SortOrder(p.input)
+	}
+	if p.exprs != nil {
+		return nil
+	}
+	var order []SortKey
+	for _, key := range SortOrder(p.input) {
+		column := slices.Index(p.columnIndices, key.ColumnIndex)
+		if column < 0 {
+			break
+		}
+		order = append(order, SortKey{ColumnIndex: column, Desc: key.Desc})
+	}
+	return order
+}
+
+// Inputs returns the projected input
+func (p *ProjectOp) Inputs() []types.Operator {
+	return []types.Operator{p.input}
+}
+
 // Schema returns the projected schema
 func (p *ProjectOp) Schema() types.Schema {
 	return p.outputSchema
 }
+
+// Explain describes the projection; row width scales with the column count
+func (p *ProjectOp) Explain() PlanNode {
+	child := ExplainOperator(p.input)
+	rowBytes := child.EstimatedRowBytes
+	inputCols := len(p.input.Schema().Columns)
+	if rowBytes > 0 && inputCols > 0 {
+		rowBytes = rowBytes * int64(len(p.outputSchema.Columns)) / int64(inputCols)
+	}
+	return PlanNode{
+		Operator:          "Project",
+		Details:           strings.Join(p.outputSchema.Columns, ", "),
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: rowBytes,
+		Children:          []PlanNode{child},
+	}
+}
@@ -0,0 +1,67 @@
+package operators
+
+import (
+	"math"
+	"strings"
+)
+
+// ScanPredicate is a WHERE conjunct that reads a single column, which a
+// scan can evaluate as soon as that one column is converted, rejecting
+// the row before converting (or decoding) the others
+type ScanPredicate struct {
+	Column      int
+	Predicate   Predicate
+	Description string  // The conjunct's SQL, for EXPLAIN
+	Selectivity float64 // Estimated fraction of rows kept; < 0 if unknown
+}
+
+// PredicatePusher is implemented by scans that can apply predicates
+// themselves. Every row the scan returns afterwards satisfies all pushed
+// predicates, so the planner doesn't add a FilterOp for them.
+type PredicatePusher interface {
+	PushPredicates(predicates []ScanPredicate)
+}
+
+// scanPredicates holds the predicates pushed into a scan
+type scanPredicates struct {
+	predicates []ScanPredicate
+	columns    []bool // Columns some predicate reads, by index
+}
+
+// push adds predicates for a scan of width columns
+func (p *scanPredicates) push(predicates []ScanPredicate, width int) {
+	if p.columns == nil {
+		p.columns = make([]bool, width)
+	}
+	for _, pred := range predicates {
+		if pred.Column >= 0 && pred.Column < width {
+			p.columns[pred.Column] = true
+		}
+	}
+	p.predicates = append(p.predicates, predicates...)
+}
+
+// reads reports whether a pushed predicate reads the column
+func (p *scanPredicates) reads(column int) bool {
+	return column < len(p.columns) && p.columns[column]
+}
+
+// explain adds the pushed predicates to a scan's plan node and scales its
+// row estimate by their selectivities
+func (p *scanPredicates) explain(node PlanNode) PlanNode {
+	if len(p.predicates) == 0 {
+		return node
+	}
+	descriptions := make([]string, len(p.predicates))
+	for i, pred := range p.predicates {
+		descriptions[i] = pred.Description
+		if pred.Selectivity >= 0 && node.EstimatedRows > 0 {
+			node.EstimatedRows = int64(math.Ceil(float64(node.EstimatedRows) * pred.Selectivity))
+		}
+	}
+	if node.Details != "" {
+		node.Details += ", "
+	}
+	node.Details += "where " + strings.Join(descriptions, " AND ")
+	return node
+}
@@ -0,0 +1,70 @@
+package operators
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTempSpaceQuotaExceeded is returned when a query writes more temp data
+// (sort spill files) than its quota allows
+var ErrTempSpaceQuotaExceeded = errors.New("temp space quota exceeded")
+
+// TempSpaceQuota limits the total bytes of temp files one query may write
+// A single quota is shared by every spilling operator in the query
+type TempSpaceQuota struct {
+	limit int64 // <= 0 means unlimited
+	used  int64
+}
+
+// NewTempSpaceQuota creates a quota; limit <= 0 means unlimited
+func NewTempSpaceQuota(limit int64) *TempSpaceQuota {
+	return &TempSpaceQuota{limit: limit}
+}
+
+// Reserve accounts for n more bytes, failing once the limit is crossed
+func (q *TempSpaceQuota) Reserve(n int64) error {
+	if q == nil {
+		return nil
+	}
+	q.used += n
+	if q.limit > 0 && q.used > q.limit {
+		return fmt.Errorf("%w: wrote %s of %s allowed (raise the quota or add a LIMIT/WHERE)",
+			ErrTempSpaceQuotaExceeded, FormatBytes(q.used), FormatBytes(q.limit))
+	}
+	return nil
+}
+
+// Used returns the bytes accounted so far
+func (q *TempSpaceQuota) Used() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.used
+}
+
+// Limit returns the quota in bytes (<= 0 means unlimited)
+func (q *TempSpaceQuota) Limit() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.limit
+}
+
+// quotaWriter charges every write against a quota before passing it on,
+// so a runaway spill stops instead of filling the disk, and adds what it
+// writes to its operator's count
+type quotaWriter struct {
+	writer  io.Writer
+	quota   *TempSpaceQuota
+	written *int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if err := w.quota.Reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	*w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package operators
+
+import (
+	"fmt"
+
+	"github.com/aryamaansaha/golap/federation"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// RemoteScan streams every row of a table in a live database (Postgres,
+// MySQL) through a federation connector. Rows are read as the database
+// sends them, so memory stays flat however big the table is; WHERE is
+// applied locally, after the rows arrive.
+type RemoteScan struct {
+	execStats
+
+	rows     federation.Rows
+	source   string // Redacted DSN, for EXPLAIN and errors
+	table    string
+	schema   types.Schema
+	natural  []types.DataType // Types the connector converts values to
+	dbTypes  []string
+	declared []bool
+	strict   bool
+	count    int64 // Rows returned so far, for error messages
+	cancel   cancelCheck
+}
+
+// NewRemoteScan connects with the named connector and starts reading the
+// table; declared column types convert the values the database returns
+func NewRemoteScan(connector, dsn, table string, opts ScanOptions) (*RemoteScan, error) {
+	conn, err := federation.Lookup(connector)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Open(dsn, table)
+	if err != nil {
+		return nil, err
+	}
+	s := &RemoteScan{
+		rows:   rows,
+		source: conn.Redact(dsn),
+		table:  table,
+		strict: opts.Strict,
+		cancel: cancelCheck{ctx: opts.Context},
+	}
+	for _, col := range rows.Columns() {
+		dt := col.Type
+		declared, isDeclared := opts.ColumnTypes[col.Name]
+		if isDeclared {
+			dt = declared
+		}
+		s.schema.Columns = append(s.schema.Columns, col.Name)
+		s.schema.Types = append(s.schema.Types, dt)
+		s.natural = append(s.natural, col.Type)
+		s.dbTypes = append(s.dbTypes, col.DBType)
+		s.declared = append(s.declared, isDeclared)
+	}
+	return s, nil
+}
+
+// Next returns the next row from the database
+// Returns (nil, nil) after the last row
+func (s *RemoteScan) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *RemoteScan) next() (*types.Row, error) {
+	if err := s.cancel.check(); err != nil {
+		return nil, err
+	}
+	values, err := s.rows.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%s, table %s: %w", s.source, s.table, err)
+	}
+	if values == nil {
+		return nil, nil
+	}
+	for j, v := range values {
+		if s.schema.Types[j] != s.natural[j] && v != nil {
+			converted, ok := parseField(valueText(v), s.schema.Types[j])
+			if !ok && s.strict {
+				return nil, fmt.Errorf("%s, table %s row %d, column %d (%s): cannot parse %q as %s",
+					s.source, s.table, s.count+1, j+1, s.schema.Columns[j], valueText(v), s.schema.Types[j])
+			}
+			values[j] = converted
+		}
+	}
+	s.count++
+	return &types.Row{Values: values}, nil
+}
+
+// Close disconnects, abandoning any rows not yet read
+func (s *RemoteScan) Close() error {
+	if s.rows == nil {
+		return nil
+	}
+	err := s.rows.Close()
+	s.rows = nil
+	return err
+}
+
+// Inputs returns nil: the scan reads from a database
+func (s *RemoteScan) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the table's columns
+func (s *RemoteScan) Schema() types.Schema {
+	return s.schema
+}
+
+// TypeSource says whether a column's type was declared or comes from the
+// database's column type
+func (s *RemoteScan) TypeSource(column int) string {
+	if column >= 0 && column < len(s.declared) && s.declared[column] {
+		return "declared"
+	}
+	if column >= 0 && column < len(s.dbTypes) {
+		return "database " + s.dbTypes[column]
+	}
+	return "database"
+}
+
+// Explain describes the scan; the row count isn't known up front
+func (s *RemoteScan) Explain() PlanNode {
+	return PlanNode{
+		Operator:      "RemoteScan",
+		Details:       s.source + ", table " + s.table,
+		EstimatedRows: -1,
+	}
+}
@@ -0,0 +1,126 @@
+package operators
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// SampleOp returns a uniform random sample of its input's rows: it reads
+// the whole input, keeping a reservoir of size rows, then returns them in
+// input order. The same seed over the same input picks the same rows.
+type SampleOp struct {
+	execStats
+
+	input  types.Operator
+	size   int
+	seed   int64
+	sample []sampledRow // Filled by the first Next, then returned in order
+	next   int
+	filled bool
+}
+
+// sampledRow is a row kept by the reservoir, with its input position
+type sampledRow struct {
+	row      *types.Row
+	position int64
+	bytes    int64 // Encoded size, for the peak memory
+}
+
+// NewSampleOp creates a sample of size rows of input, picked by seed
+func NewSampleOp(input types.Operator, size int, seed int64) *SampleOp {
+	return &SampleOp{input: input, size: size, seed: seed}
+}
+
+// Next returns the next sampled row, reading the whole input first
+func (s *SampleOp) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.nextRow()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// nextRow is Next, uncounted
+func (s *SampleOp) nextRow() (*types.Row, error) {
+	if !s.filled {
+		if err := s.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if s.next == len(s.sample) {
+		return nil, nil
+	}
+	row := s.sample[s.next].row
+	s.sample[s.next].row = nil
+	s.next++
+	return row, nil
+}
+
+// fill reads the input through the reservoir (Algorithm R)
+func (s *SampleOp) fill() error {
+	s.filled = true
+	random := rand.New(rand.NewSource(s.seed))
+	var position, held int64
+	var scratch []byte
+	for {
+		row, err := s.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if len(s.sample) < s.size {
+			kept := sampledRow{row: row, position: position}
+			kept.bytes, scratch = encodedRowSize(row, scratch)
+			s.sample = append(s.sample, kept)
+			held += kept.bytes
+		} else if i := random.Int63n(position + 1); i < int64(s.size) {
+			types.ReleaseRow(s.sample[i].row)
+			held -= s.sample[i].bytes
+			kept := sampledRow{row: row, position: position}
+			kept.bytes, scratch = encodedRowSize(row, scratch)
+			s.sample[i] = kept
+			held += kept.bytes
+		} else {
+			types.ReleaseRow(row)
+		}
+		position++
+		s.holdMemory(held)
+	}
+	sort.Slice(s.sample, func(i, j int) bool { return s.sample[i].position < s.sample[j].position })
+	return nil
+}
+
+// Close releases the rows not returned and the input
+func (s *SampleOp) Close() error {
+	for i := s.next; i < len(s.sample); i++ {
+		types.ReleaseRow(s.sample[i].row)
+	}
+	s.sample = nil
+	return s.input.Close()
+}
+
+// Inputs returns the sampled input
+func (s *SampleOp) Inputs() []types.Operator {
+	return []types.Operator{s.input}
+}
+
+// Schema returns the schema (unchanged from input)
+func (s *SampleOp) Schema() types.Schema {
+	return s.input.Schema()
+}
+
+// Explain describes the sample
+func (s *SampleOp) Explain() PlanNode {
+	child := ExplainOperator(s.input)
+	return PlanNode{
+		Operator:          "Sample",
+		Details:           fmt.Sprintf("%d rows, seed %d", s.size, s.seed),
+		EstimatedRows:     minEstimate(int64(s.size), child.EstimatedRows),
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		Children:          []PlanNode{child},
+	}
+}
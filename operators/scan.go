@@ -1,58 +1,185 @@
 package operators
 
 import (
-	"encoding/csv"
+	"bufio"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 
+	"github.com/aryamaansaha/golap/storage"
 	"github.com/aryamaansaha/golap/types"
 )
 
+// DefaultSampleRows is how many data rows CSVScan reads to infer column types
+const DefaultSampleRows = 100
+
+// DefaultReadBufferSize is the read buffer used for sequential local reads
+// Larger than bufio's 4KB default to cut syscalls on big files
+const DefaultReadBufferSize = 256 * 1024
+
+// ScanOptions tunes how a CSVScan reads its file
+type ScanOptions struct {
+	BufferSize  int                       // Read buffer size in bytes (0 = DefaultReadBufferSize)
+	ColumnTypes map[string]types.DataType // Declared types by column name, overriding inference
+	Delimiter   rune                      // Field separator (0 = detect from the header line)
+	NoHeader    bool                      // The first line is data, not column names
+	ColumnNames []string                  // Names to use instead of the header; missing ones become colN
+	SampleRows  int                       // Rows read to infer column types (0 = the scan's default)
+	StartOffset int64                     // Byte offset of the first data row to return (0 = the start; CSV only)
+	NullValues  []string                  // Fields read as NULL in any column, e.g. NA or \N (empty numeric fields always are)
+	Strict      bool                      // Fail on fields that don't parse as their column's type, instead of reading 0
+	RaggedRows  RaggedRowPolicy           // Records with the wrong number of fields: fail (the default), skip or pad them (CSV only)
+	Encoding    string                    // Text encoding of the file: utf-8 (""), latin1 or windows-1252
+	Workers     int                       // Goroutines parsing a large local CSV file in parallel (0 or 1 = one)
+	Mmap        bool                      // Read local files through a memory mapping instead of read calls
+	Context     context.Context           // Stops the scan with its error once canceled (nil = never)
+}
+
+// TypeSourcer is implemented by scans that infer column types, so DESCRIBE
+// can show whether each type was declared or inferred
+type TypeSourcer interface {
+	TypeSource(column int) string
+}
+
+// delimiterCandidates are the separators auto-detection chooses between,
+// in order of preference when counts tie
+var delimiterCandidates = []rune{',', '\t', '|', ';'}
+
 // CSVScan is the storage layer operator that streams rows from a CSV file
 type CSVScan struct {
-	reader           *csv.Reader
-	file             *os.File
-	schema           types.Schema
-	firstRow         []string // buffered first data row (used for type inference, then returned)
-	firstRowReturned bool
+	execStats
+
+	reader        *csvReader
+	file          storage.File
+	gzipReader    *gzip.Reader // Non-nil for .gz files
+	counter       *countingReader
+	input         *bufio.Reader // Buffers counter, for seeking (UTF-8, uncompressed files)
+	filePath      string
+	delimiter     rune
+	fileSize      int64 // -1 if unknown
+	headerBytes   int64 // Approximate bytes of the header line
+	rowBytes      int64 // Approximate bytes per data row, averaged over the sample
+	schema        types.Schema
+	headerNamed   bool       // Columns are named by the file's header line
+	declared      []bool     // Columns whose type was declared rather than inferred
+	sample        [][]string // Buffered first data rows (used for type inference, then returned)
+	sampleOffsets []int64    // Byte offset of each sampled row
+	sampleLines   []int      // Line number of each sampled row, for errors
+	sampleIndex   int
+	sampleRecord  csvRecord // The sampled row being returned
+	baseOffset    int64     // StartOffset when resuming, else 0
+	readerOffset  int64     // File offset the reader started at (baseOffset, or past pruned blocks)
+	nullValues    map[string]bool
+	strict        bool
+	encoding      string
+	pushed        scanPredicates
+	restOffset    int64               // File offset of the first row after the sample
+	workers       int                 // Goroutines parsing the rest in parallel
+	sequential    bool                // Decided not to (or can't) scan in parallel
+	exchange      *csvExchange        // Non-nil while scanning in parallel
+	partial       *partialAggregation // Set when the workers aggregate instead of returning rows
+	builder       batchBuilder        // NextBatch's batch
+	scratch       types.Row           // Row the pushed predicates read, for a batch
+	cancel        cancelCheck
+	skips         []skipRange // Pruned blocks' bytes, in file order (see PruneBlocks)
+	skip          int         // Next of skips the reader may reach
+	blocks        int         // Blocks in the file's zone map, if pruned by them
+	prunedBlocks  int
+	raggedRows    atomic.Int64 // Skipped or padded by readers replaced and parallel workers
 }
 
 // NewCSVScan creates a new CSV scanner with automatic schema inference
-// It reads the header row and peeks at the first data row to infer column types
+// It reads the header row and samples the first data rows to infer column types
+// Files ending in .gz are decompressed on the fly
 func NewCSVScan(filePath string) (*CSVScan, error) {
-	file, err := os.Open(filePath)
+	return NewCSVScanWithOptions(filePath, ScanOptions{})
+}
+
+// NewCSVScanWithOptions creates a CSV scanner with custom I/O sizing
+func NewCSVScanWithOptions(filePath string, opts ScanOptions) (*CSVScan, error) {
+	file, counter, gzipReader, input, err := openScanInput(filePath, opts.BufferSize, opts.Encoding, opts.Mmap)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CSV file: %w", err)
 	}
 
-	reader := csv.NewReader(file)
+	delimiter := opts.Delimiter
+	if delimiter == 0 {
+		delimiter = detectDelimiter(input)
+	}
 
-	// Read header row
-	header, err := reader.Read()
-	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	reader := newCSVReader(input, delimiter)
+	reader.ragged = opts.RaggedRows
+
+	// Read header row (copied, since the next Read reuses the record)
+	var header []string
+	if !opts.NoHeader {
+		record, err := reader.Read()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		header = record.strings()
 	}
+	headerBytes := recordBytes(header)
 
-	// Read first data row to infer types
-	firstRow, err := reader.Read()
-	if err != nil && err != io.EOF {
-		file.Close()
-		return nil, fmt.Errorf("failed to read first data row: %w", err)
+	// Read the first data rows to infer types (copied, as with the header)
+	sampleRows := opts.SampleRows
+	if sampleRows <= 0 {
+		sampleRows = DefaultSampleRows
+	}
+	var sample [][]string
+	var sampleOffsets []int64
+	var sampleLines []int
+	var sampleBytes int64
+	for len(sample) < sampleRows {
+		offset := reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read data row %d: %w", len(sample)+1, err)
+		}
+		fields := record.strings()
+		sample = append(sample, fields)
+		sampleOffsets = append(sampleOffsets, offset)
+		sampleLines = append(sampleLines, record.line(0))
+		sampleBytes += recordBytes(fields)
 	}
 
-	// Infer types from first data row
-	colTypes := make([]types.DataType, len(header))
-	if firstRow != nil {
-		for i, val := range firstRow {
-			colTypes[i] = inferType(val)
+	if opts.NoHeader || len(opts.ColumnNames) > 0 {
+		width := len(header)
+		if opts.NoHeader && len(sample) > 0 {
+			width = len(sample[0])
 		}
-	} else {
-		// Empty CSV (no data rows), default all to String
-		for i := range colTypes {
-			colTypes[i] = types.String
+		header, err = columnNames(opts.ColumnNames, width)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	var nullValues map[string]bool
+	if len(opts.NullValues) > 0 {
+		nullValues = make(map[string]bool, len(opts.NullValues))
+		for _, v := range opts.NullValues {
+			nullValues[v] = true
+		}
+	}
+
+	// Declared types win over inference
+	colTypes := inferColumnTypes(sample, len(header), nullValues)
+	declared := make([]bool, len(header))
+	for i, col := range header {
+		if dt, ok := opts.ColumnTypes[col]; ok {
+			colTypes[i] = dt
+			declared[i] = true
 		}
 	}
 
@@ -61,13 +188,267 @@ func NewCSVScan(filePath string) (*CSVScan, error) {
 		Types:   colTypes,
 	}
 
-	return &CSVScan{
-		reader:           reader,
-		file:             file,
-		schema:           schema,
-		firstRow:         firstRow,
-		firstRowReturned: false,
-	}, nil
+	var rowBytes int64
+	if len(sample) > 0 {
+		rowBytes = sampleBytes / int64(len(sample))
+	}
+
+	scan := &CSVScan{
+		reader:        reader,
+		file:          file,
+		gzipReader:    gzipReader,
+		counter:       counter,
+		input:         input,
+		filePath:      filePath,
+		delimiter:     delimiter,
+		fileSize:      file.Size(),
+		headerBytes:   headerBytes,
+		rowBytes:      rowBytes,
+		schema:        schema,
+		headerNamed:   !opts.NoHeader && len(opts.ColumnNames) == 0,
+		declared:      declared,
+		sample:        sample,
+		sampleOffsets: sampleOffsets,
+		sampleLines:   sampleLines,
+		nullValues:    nullValues,
+		strict:        opts.Strict,
+		encoding:      opts.Encoding,
+		restOffset:    reader.InputOffset(),
+		workers:       opts.Workers,
+		cancel:        cancelCheck{ctx: opts.Context},
+	}
+	if opts.StartOffset > 0 {
+		if err := scan.resume(input, delimiter, opts.StartOffset); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return scan, nil
+}
+
+// resume skips to the data row starting at offset, after the header and
+// sample have been read from the start of the file for the schema
+func (s *CSVScan) resume(input *bufio.Reader, delimiter rune, offset int64) error {
+	if s.gzipReader != nil {
+		return fmt.Errorf("cannot resume a compressed file at an offset")
+	}
+	if encoding, _ := ParseEncoding(s.encoding); encoding != EncodingUTF8 {
+		return fmt.Errorf("cannot resume a %s file at an offset", encoding)
+	}
+	dataStart := s.reader.InputOffset()
+	if len(s.sampleOffsets) > 0 {
+		dataStart = s.sampleOffsets[0]
+	}
+	if offset < dataStart || (s.fileSize >= 0 && offset > s.fileSize) {
+		return fmt.Errorf("invalid resume offset %d for %s", offset, s.filePath)
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek CSV file: %w", err)
+	}
+	input.Reset(s.counter)
+	reader := newCSVReader(input, delimiter)
+	reader.fieldsPerRecord, reader.ragged = s.reader.fieldsPerRecord, s.reader.ragged
+	s.reader = reader // The sampled rows it counted aren't returned
+	s.baseOffset, s.readerOffset = offset, offset
+
+	// The sampled rows come before the offset; don't return them
+	for i := range s.sample {
+		s.sample[i] = nil
+	}
+	s.sampleIndex = len(s.sample)
+	return nil
+}
+
+// Offset returns the byte offset of the row the next call to Next will
+// return (the file size once every row has been read), for resuming a scan
+// later with ScanOptions.StartOffset. Not meaningful for .gz files.
+func (s *CSVScan) Offset() int64 {
+	if s.sampleIndex < len(s.sample) {
+		return s.sampleOffsets[s.sampleIndex]
+	}
+	return s.readerOffset + s.reader.InputOffset()
+}
+
+// inferColumnTypes picks, for each column, the narrowest type that fits
+// every sampled value: Int -> Float -> String. Empty values and NULL
+// tokens don't count; a column with no values in the sample is String.
+func inferColumnTypes(sample [][]string, width int, nullValues map[string]bool) []types.DataType {
+	colTypes := make([]types.DataType, width)
+	found := make([]bool, width)
+	for _, record := range sample {
+		for i, val := range record {
+			if i >= width || val == "" || nullValues[val] {
+				continue
+			}
+			if found[i] {
+				colTypes[i] = widenType(colTypes[i], inferType(val))
+			} else {
+				colTypes[i] = inferType(val)
+				found[i] = true
+			}
+		}
+	}
+	for i := range colTypes {
+		if !found[i] {
+			colTypes[i] = types.String
+		}
+	}
+	return colTypes
+}
+
+// widenType returns the narrowest type holding values of both a and b:
+// the same type if they match, Float for Int with Float, else String
+func widenType(a, b types.DataType) types.DataType {
+	switch {
+	case a == b:
+		return a
+	case a != types.String && b != types.String:
+		return types.Float
+	default:
+		return types.String
+	}
+}
+
+// columnNames returns names for a file with width columns: the given
+// names, then col<i> for any columns left unnamed
+func columnNames(names []string, width int) ([]string, error) {
+	if width == 0 {
+		width = len(names) // Empty file: take the names as given
+	}
+	if len(names) > width {
+		return nil, fmt.Errorf("%d column names given, but the file has %d columns", len(names), width)
+	}
+	columns := make([]string, width)
+	for i := range columns {
+		if i < len(names) {
+			columns[i] = names[i]
+		} else {
+			columns[i] = fmt.Sprintf("col%d", i)
+		}
+	}
+	return columns, nil
+}
+
+// NewFileScan opens the scan operator matching a file's extension:
+// .jsonl/.ndjson (optionally .gz) use JSONScan, .arrow/.feather/.arrows
+// ArrowScan, anything else CSVScan
+func NewFileScan(filePath string, opts ScanOptions) (types.Operator, error) {
+	if isJSONLinesPath(filePath) {
+		return NewJSONScanWithOptions(filePath, opts)
+	}
+	if isArrowPath(filePath) {
+		return NewArrowScanWithOptions(filePath, opts)
+	}
+	if isGolapPath(filePath) {
+		return NewGolapScanWithOptions(filePath, opts)
+	}
+	return NewCSVScanWithOptions(filePath, opts)
+}
+
+// openScanInput opens a local file or remote object (see storage.Open) for
+// buffered sequential reading, counting bytes read from disk, decompressing
+// on the fly when the name ends in .gz and converting text in another
+// encoding to UTF-8. With mmap, a local file is read from a memory mapping
+// (storage.Map) instead.
+func openScanInput(filePath string, bufferSize int, encoding string, mmap bool) (storage.File, *countingReader, *gzip.Reader, *bufio.Reader, error) {
+	decoded, err := ParseEncoding(encoding)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var file storage.File
+	if mmap && !storage.IsRemote(filePath) {
+		if mapped, err := storage.Map(filePath); err == nil {
+			file = mapped
+		}
+	}
+	if file == nil { // Not mapped (or can't be, e.g. an empty file)
+		if file, err = storage.Open(filePath); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = DefaultReadBufferSize
+	}
+
+	// Count bytes at the file level so compressed inputs report I/O done
+	counter := &countingReader{reader: file}
+	input := bufio.NewReaderSize(counter, bufferSize)
+	var gzipReader *gzip.Reader
+	if strings.HasSuffix(filePath, ".gz") {
+		gzipReader, err = gzip.NewReader(input)
+		if err != nil {
+			file.Close()
+			return nil, nil, nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		input = bufio.NewReaderSize(gzipReader, bufferSize)
+	}
+	if decoded != EncodingUTF8 {
+		text, _ := decodeInput(input, decoded) // Encoding checked above
+		input = bufio.NewReaderSize(text, bufferSize)
+	}
+	return file, counter, gzipReader, input, nil
+}
+
+// detectDelimiter guesses the field separator from the header line: the
+// candidate occurring most often outside quotes, defaulting to comma
+// The line is peeked, not consumed.
+func detectDelimiter(input *bufio.Reader) rune {
+	peekSize := input.Size()
+	if peekSize > 64*1024 {
+		peekSize = 64 * 1024
+	}
+	data, _ := input.Peek(peekSize) // Short reads near EOF still return data
+	if i := strings.IndexAny(string(data), "\r\n"); i >= 0 {
+		data = data[:i]
+	}
+
+	counts := make(map[rune]int, len(delimiterCandidates))
+	inQuotes := false
+	for _, r := range string(data) {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if !inQuotes {
+			counts[r]++
+		}
+	}
+
+	best := ','
+	for _, candidate := range delimiterCandidates {
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// ParseDelimiter parses a user-supplied field separator: a single
+// character, or one of the names tab, pipe, semicolon and comma ("\t" works
+// too). An empty string returns 0, meaning auto-detect.
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "tab", "\\t":
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	case "semicolon":
+		return ';', nil
+	case "comma":
+		return ',', nil
+	}
+
+	runes := []rune(s)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character: %q", s)
+	}
+	r := runes[0]
+	if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter: %q", s)
+	}
+	return r, nil
 }
 
 // inferType attempts to determine the data type of a string value
@@ -92,65 +473,478 @@ func inferType(val string) types.DataType {
 }
 
 // parseValue converts a string value to the appropriate Go type based on DataType
+// Empty numeric fields are NULL (nil); empty strings stay as ""
 func parseValue(val string, dt types.DataType) interface{} {
+	v, _ := parseField(val, dt)
+	return v
+}
+
+// parseField is parseValue, also reporting whether a numeric field parsed
+// (ok=false means it didn't, and the value is 0)
+func parseField(val string, dt types.DataType) (interface{}, bool) {
+	if val == "" && dt != types.String {
+		return nil, true
+	}
+
 	switch dt {
 	case types.Int:
 		if v, err := strconv.ParseInt(val, 10, 64); err == nil {
-			return v
+			return v, true
 		}
-		return int64(0) // Parse failure, return zero value
+		return int64(0), false // Parse failure, return zero value
 	case types.Float:
 		if v, err := strconv.ParseFloat(val, 64); err == nil {
-			return v
+			return v, true
 		}
-		return float64(0) // Parse failure, return zero value
+		return float64(0), false // Parse failure, return zero value
 	default:
-		return val
+		return val, true
+	}
+}
+
+// parseRecordField is parseField for field i of a record, parsing numbers
+// straight from its bytes so only text values allocate
+func parseRecordField(record *csvRecord, i int, dt types.DataType) (interface{}, bool) {
+	val := record.field(i)
+	if len(val) == 0 && dt != types.String {
+		return nil, true
+	}
+
+	switch dt {
+	case types.Int:
+		if v, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			return v, true
+		}
+		return int64(0), false
+	case types.Float:
+		if v, err := strconv.ParseFloat(string(val), 64); err == nil {
+			return v, true
+		}
+		return float64(0), false
+	default:
+		return record.str(i), true
 	}
 }
 
 // Next returns the next row from the CSV file
 // Returns (nil, nil) when the file is exhausted
 func (s *CSVScan) Next() (*types.Row, error) {
-	var record []string
-
-	// Return the buffered first row if not yet returned
-	if !s.firstRowReturned && s.firstRow != nil {
-		record = s.firstRow
-		s.firstRowReturned = true
-	} else {
-		var err error
-		record, err = s.reader.Read()
-		if err == io.EOF {
-			return nil, nil // End of file
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *CSVScan) next() (*types.Row, error) {
+	row := types.GetRow(0)
+	next, err := s.nextRow(row)
+	if next != row {
+		types.ReleaseRow(row) // Not used: the end, an error or a worker's row
+	}
+	return next, err
+}
+
+// NextBatch returns the next types.BatchSize rows (fewer at the end of
+// the file). Fields are parsed straight into the batch's typed vectors,
+// without boxing; rows from parallel workers are copied in.
+func (s *CSVScan) NextBatch() (*types.RowBatch, error) {
+	start := s.startCall()
+	batch, err := s.nextBatch()
+	s.endCall(start, batchRows(batch))
+	return batch, err
+}
+
+// nextBatch is NextBatch, uncounted
+func (s *CSVScan) nextBatch() (*types.RowBatch, error) {
+	s.builder.reset(s.schema)
+	for !s.builder.full() {
+		if err := s.startExchange(); err != nil {
+			return nil, err
+		}
+		if s.exchange != nil {
+			row, err := s.exchange.next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+			s.builder.add(row.Values)
+			continue
 		}
+
+		record, err := s.nextRecord()
 		if err != nil {
-			return nil, fmt.Errorf("error reading CSV row: %w", err)
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+		if len(s.pushed.predicates) > 0 {
+			resetValues(&s.scratch, s.recordWidth(record))
+			passed, err := s.filterRecord(record, &s.scratch, 0)
+			if err != nil {
+				return nil, err
+			}
+			if !passed {
+				continue
+			}
+		}
+		if err := s.appendRecord(record); err != nil {
+			return nil, err
 		}
 	}
+	return s.builder.finish(), nil
+}
 
-	// Parse values according to schema types
-	values := make([]interface{}, len(record))
-	for i, val := range record {
-		if i < len(s.schema.Types) {
-			values[i] = parseValue(val, s.schema.Types[i])
-		} else {
-			values[i] = val // Extra columns beyond schema treated as strings
+// nextRow returns the next row, or nil at the end of the file: a row from
+// the parallel workers, or else the next record converted into row, which
+// is reused while pushed predicates reject records
+func (s *CSVScan) nextRow(row *types.Row) (*types.Row, error) {
+	for {
+		if err := s.startExchange(); err != nil {
+			return nil, err
+		}
+		if s.exchange != nil {
+			return s.exchange.next()
+		}
+
+		record, err := s.nextRecord()
+		if err != nil || record == nil {
+			return nil, err
+		}
+		resetValues(row, s.recordWidth(record))
+		passed, err := s.convertRecord(record, row, 0)
+		if err != nil {
+			return nil, err
+		}
+		if passed {
+			return row, nil
+		}
+	}
+}
+
+// startExchange splits the rest of a large file among parallel workers
+// once the sampled rows have been returned, if the scan can
+func (s *CSVScan) startExchange() error {
+	if s.sampleIndex < len(s.sample) || s.sequential {
+		return nil
+	}
+	s.sequential = true
+	if !s.canScanInParallel() {
+		return nil
+	}
+	exchange, err := newCSVExchange(s)
+	if err != nil {
+		return err
+	}
+	s.exchange = exchange
+	return nil
+}
+
+// recordWidth returns how many values a record converts to: one per
+// column, NULL for the fields a padded record lacks (see RaggedRowsPad)
+func (s *CSVScan) recordWidth(record *csvRecord) int {
+	return max(record.len(), len(s.schema.Columns))
+}
+
+// resetValues sets row.Values to n NULLs, reusing its array
+func resetValues(row *types.Row, n int) {
+	if cap(row.Values) < n {
+		row.Values = make([]interface{}, n)
+		return
+	}
+	row.Values = row.Values[:n]
+	clear(row.Values)
+}
+
+// convertRecord converts a record's fields into row.Values (sized by
+// recordWidth) and reports whether the pushed predicates pass. The columns pushed
+// predicates read are converted first, so a rejected row's other fields
+// aren't parsed (unless -strict, which checks every field). Errors report
+// the record's lines offset by lineBase. Safe to call from several
+// goroutines.
+func (s *CSVScan) convertRecord(record *csvRecord, row *types.Row, lineBase int) (bool, error) {
+	passed, err := s.filterRecord(record, row, lineBase)
+	if err != nil || !passed {
+		return false, err
+	}
+	for i := range record.len() {
+		if s.pushed.reads(i) {
+			continue // Already converted
+		}
+		if err := s.convertField(record, i, row.Values, lineBase); err != nil {
+			return false, err
 		}
 	}
+	return true, nil
+}
 
-	return &types.Row{Values: values}, nil
+// filterRecord converts the columns pushed predicates read into row.Values
+// and reports whether the predicates pass. Under -strict, a rejected
+// record's other fields are checked too.
+func (s *CSVScan) filterRecord(record *csvRecord, row *types.Row, lineBase int) (bool, error) {
+	for _, pred := range s.pushed.predicates {
+		if pred.Column < record.len() {
+			if err := s.convertField(record, pred.Column, row.Values, lineBase); err != nil {
+				return false, err
+			}
+		}
+		if pred.Predicate(row) == types.True {
+			continue
+		}
+		for i := range record.len() {
+			if !s.strict {
+				break // -strict still checks every field of a rejected row
+			}
+			if err := s.convertField(record, i, row.Values, lineBase); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// appendRecord adds a record that passed the pushed predicates to the
+// batch: the columns they read from the scratch row they were converted
+// into, the rest parsed from the record
+func (s *CSVScan) appendRecord(record *csvRecord) error {
+	for i := range s.builder.batch.Columns {
+		switch {
+		case i >= record.len():
+			s.builder.addValue(i, nil)
+		case s.pushed.reads(i):
+			s.builder.addValue(i, s.scratch.Values[i])
+		default:
+			if err := s.appendField(record, i); err != nil {
+				return err
+			}
+		}
+	}
+	s.builder.batch.Length++
+	return nil
+}
+
+// appendField is convertField into column i of the batch, parsing numbers
+// into the typed vectors without boxing them
+func (s *CSVScan) appendField(record *csvRecord, i int) error {
+	val := record.field(i)
+	if s.nullValues[string(val)] {
+		s.builder.addValue(i, nil)
+		return nil
+	}
+	ok := true
+	switch vector := s.builder.batch.Columns[i].(type) {
+	case *types.Int64Vector:
+		if len(val) == 0 {
+			vector.AppendNull()
+			return nil
+		}
+		v, err := strconv.ParseInt(string(val), 10, 64)
+		ok = err == nil
+		if !ok {
+			v = 0
+		}
+		vector.Append(v)
+	case *types.Float64Vector:
+		if len(val) == 0 {
+			vector.AppendNull()
+			return nil
+		}
+		v, err := strconv.ParseFloat(string(val), 64)
+		ok = err == nil
+		if !ok {
+			v = 0
+		}
+		vector.Append(v)
+	case *types.StringVector:
+		vector.Append(val)
+	default:
+		var value interface{}
+		value, ok = parseRecordField(record, i, s.schema.Types[i])
+		s.builder.addValue(i, value)
+	}
+	if !ok && s.strict {
+		return s.fieldError(record, i, 0)
+	}
+	return nil
+}
+
+// nextRecord returns the next record, nil at the end of the file
+func (s *CSVScan) nextRecord() (*csvRecord, error) {
+	// Return the sampled rows first
+	if s.sampleIndex < len(s.sample) {
+		s.sampleRecord.setStrings(s.sample[s.sampleIndex], s.sampleLines[s.sampleIndex])
+		s.sample[s.sampleIndex] = nil // Let sampled rows be collected
+		s.sampleIndex++
+		return &s.sampleRecord, nil
+	}
+	if err := s.cancel.check(); err != nil {
+		return nil, err
+	}
+	if s.skip < len(s.skips) {
+		if err := s.skipBlocks(); err != nil {
+			return nil, err
+		}
+	}
+	record, err := s.reader.Read()
+	if err == io.EOF {
+		return nil, nil // End of file
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV row: %w", err)
+	}
+	return record, nil
+}
+
+// convertField parses field i of a record according to the schema into
+// values[i]; errors report its line offset by lineBase
+func (s *CSVScan) convertField(record *csvRecord, i int, values []interface{}, lineBase int) error {
+	if i >= len(s.schema.Types) {
+		values[i] = record.str(i) // Extra columns beyond schema treated as strings
+		return nil
+	}
+	val := record.field(i)
+	if s.nullValues[string(val)] {
+		values[i] = nil
+		return nil
+	}
+	value, ok := parseRecordField(record, i, s.schema.Types[i])
+	if !ok && s.strict {
+		return s.fieldError(record, i, lineBase)
+	}
+	values[i] = value
+	return nil
+}
+
+// fieldError reports that field i of a record doesn't parse as its
+// column's type, on its line offset by lineBase
+func (s *CSVScan) fieldError(record *csvRecord, i int, lineBase int) error {
+	return fmt.Errorf("%s line %d, column %d (%s): cannot parse %q as %s",
+		s.filePath, record.line(i)+lineBase, i+1, s.schema.Columns[i], record.field(i), s.schema.Types[i])
+}
+
+// PushPredicates makes the scan apply single-column predicates itself:
+// a row is rejected as soon as the columns they read are converted
+func (s *CSVScan) PushPredicates(predicates []ScanPredicate) {
+	s.pushed.push(predicates, len(s.schema.Columns))
 }
 
 // Close releases resources held by this operator
 func (s *CSVScan) Close() error {
+	if s.exchange != nil {
+		s.exchange.close()
+	}
+	if s.gzipReader != nil {
+		s.gzipReader.Close()
+	}
 	if s.file != nil {
 		return s.file.Close()
 	}
 	return nil
 }
 
+// Inputs returns nil: the scan reads a file
+func (s *CSVScan) Inputs() []types.Operator {
+	return nil
+}
+
 // Schema returns the schema of rows produced by this operator
 func (s *CSVScan) Schema() types.Schema {
 	return s.schema
 }
+
+// TypeSource describes how a column's type was chosen
+func (s *CSVScan) TypeSource(column int) string {
+	return typeSource(s.declared, column, len(s.sample))
+}
+
+// typeSource reports a column's type as declared, or inferred from the
+// given number of sampled rows
+func typeSource(declared []bool, column int, sampled int) string {
+	if column >= 0 && column < len(declared) && declared[column] {
+		return "declared"
+	}
+	if sampled == 1 {
+		return "inferred from 1 row"
+	}
+	return fmt.Sprintf("inferred from %d rows", sampled)
+}
+
+// Explain describes the scan, estimating row count from file size and the
+// average width of the sampled rows (unknown for compressed files)
+func (s *CSVScan) Explain() PlanNode {
+	rows := int64(-1)
+	if len(s.sample) == 0 {
+		rows = 0
+	} else if s.gzipReader == nil && s.fileSize >= 0 && s.rowBytes > 0 {
+		rows = (s.fileSize - s.headerBytes) / s.rowBytes
+		if s.baseOffset > 0 {
+			rows = (s.fileSize - s.baseOffset) / s.rowBytes
+		}
+		rows -= s.skippedBytes() / s.rowBytes
+		if rows < 1 {
+			rows = 1
+		}
+	}
+
+	details := s.filePath
+	if s.fileSize >= 0 {
+		details += ", " + FormatBytes(s.fileSize)
+	}
+	if s.prunedBlocks > 0 {
+		details += fmt.Sprintf(", %d of %d blocks", s.blocks-s.prunedBlocks, s.blocks)
+	}
+
+	if s.exchange != nil || (!s.sequential && s.canScanInParallel()) {
+		details += fmt.Sprintf(", %d workers", s.workers)
+	}
+
+	return s.pushed.explain(PlanNode{
+		Operator:          "CSVScan",
+		Details:           details,
+		EstimatedRows:     rows,
+		EstimatedRowBytes: s.rowBytes,
+	})
+}
+
+// recordBytes approximates the encoded size of a CSV record
+// (field bytes plus separators and newline; ignores quoting)
+func recordBytes(record []string) int64 {
+	if record == nil {
+		return 0
+	}
+	n := int64(len(record))
+	for _, field := range record {
+		n += int64(len(field))
+	}
+	return n
+}
+
+// RaggedRows returns how many records with the wrong number of fields the
+// scan has skipped or padded so far (see ScanOptions.RaggedRows)
+func (s *CSVScan) RaggedRows() int64 {
+	return s.raggedRows.Load() + s.reader.raggedRows
+}
+
+// BytesRead returns the number of bytes read from the underlying file so far
+func (s *CSVScan) BytesRead() int64 {
+	if s.exchange != nil {
+		return s.counter.count + s.exchange.read.Load()
+	}
+	return s.counter.count
+}
+
+// countingReader tracks how many bytes pass through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
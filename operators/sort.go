@@ -8,44 +8,139 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/aryamaansaha/golap/types"
 )
 
+// DefaultChunkSize is the rows per chunk of the deprecated row-counted
+// sort (NewSortOp, NewSortOpWithChunkSize)
 const DefaultChunkSize = 1000
 
-// SortOp performs external merge sort for ORDER BY
+// DefaultSortMemoryBytes is how many bytes of rows a sort holds in memory
+// before spilling a sorted run
+const DefaultSortMemoryBytes = 1 << 20
+
+// SortOptions tunes how a SortOp uses memory and temp space
+type SortOptions struct {
+	// MemoryBytes caps the rows held in memory per sorted run, measured as
+	// their spilled (CSV-encoded) size, so wide and narrow rows get the
+	// same budget (0 = DefaultSortMemoryBytes)
+	MemoryBytes int64
+	// ChunkSize, if set without MemoryBytes, counts each run in rows
+	//
+	// Deprecated: use MemoryBytes.
+	ChunkSize int
+	TempQuota *TempSpaceQuota // Optional per-query limit on spill bytes
+	Spill     SpillOptions    // Where sorted runs are written, and whether compressed
+	Collation Collation       // How text sorts (the zero value: byte by byte)
+}
+
+// SortKey is one column of a sort order
+type SortKey struct {
+	ColumnIndex int
+	Desc        bool
+}
+
+// Ordered is implemented by operators that know their rows come out
+// sorted, so the planner can skip work that sortedness makes unnecessary
+type Ordered interface {
+	// SortOrder returns the keys the rows are sorted by, most significant
+	// first; nil if unknown
+	SortOrder() []SortKey
+}
+
+// SortOrder returns the keys op's rows are sorted by, or nil if op doesn't
+// know
+func SortOrder(op types.Operator) []SortKey {
+	if ordered, ok := op.(Ordered); ok {
+		return ordered.SortOrder()
+	}
+	return nil
+}
+
+// GroupsAdjacent reports whether op's rows that agree on columns come out
+// next to each other: true when columns are (in any order) the first
+// columns op is sorted by
+func GroupsAdjacent(op types.Operator, columns []int) bool {
+	order := SortOrder(op)
+	wanted := make(map[int]bool, len(columns))
+	for _, col := range columns {
+		wanted[col] = true
+	}
+	if len(wanted) == 0 || len(wanted) > len(order) {
+		return false
+	}
+	for _, key := range order[:len(wanted)] {
+		if !wanted[key.ColumnIndex] {
+			return false
+		}
+	}
+	return true
+}
+
+// SortOp performs external merge sort for ORDER BY. The sort is stable:
+// rows with equal keys come out in their input order, so the same query
+// over the same input always returns the same rows in the same order.
 type SortOp struct {
-	input       types.Operator
-	columnIndex int  // Column to sort by
-	desc        bool // Descending order
-	chunkSize   int  // Number of rows per chunk
-	schema      types.Schema
+	execStats
+
+	input     types.Operator
+	keys      []SortKey // Sort columns, most significant first
+	chunkSize int       // Rows per chunk, if counted in rows
+	memory    int64     // Encoded bytes per chunk, if counted in bytes
+	tempQuota *TempSpaceQuota
+	spill     SpillOptions
+	collation Collation
+	schema    types.Schema
 
 	// State for merge phase
 	prepared  bool
 	tempFiles []string
+	record    []string // Reused to spill a row
 	readers   []*csv.Reader
 	files     []*os.File
 	mergeHeap *mergeHeap
 	exhausted bool
+	err       error // The first comparison of a number with text
 }
 
-// NewSortOp creates a new sort operator
+// NewSortOp creates a new sort operator with chunks of DefaultChunkSize rows
 func NewSortOp(input types.Operator, columnIndex int, desc bool) *SortOp {
 	return NewSortOpWithChunkSize(input, columnIndex, desc, DefaultChunkSize)
 }
 
 // NewSortOpWithChunkSize creates a sort operator with custom chunk size
 func NewSortOpWithChunkSize(input types.Operator, columnIndex int, desc bool, chunkSize int) *SortOp {
+	return NewSortOpWithOptions(input, columnIndex, desc, SortOptions{ChunkSize: chunkSize})
+}
+
+// NewSortOpWithOptions creates a sort operator with custom memory/spill settings
+func NewSortOpWithOptions(input types.Operator, columnIndex int, desc bool, opts SortOptions) *SortOp {
+	return NewMultiKeySortOp(input, []SortKey{{ColumnIndex: columnIndex, Desc: desc}}, opts)
+}
+
+// NewMultiKeySortOp creates a sort operator ordering by several columns
+func NewMultiKeySortOp(input types.Operator, keys []SortKey, opts SortOptions) *SortOp {
+	memory := opts.MemoryBytes
+	if memory <= 0 && opts.ChunkSize <= 0 {
+		memory = DefaultSortMemoryBytes
+	}
+	chunkSize := 0
+	if memory <= 0 {
+		chunkSize = opts.ChunkSize
+	}
 	return &SortOp{
-		input:       input,
-		columnIndex: columnIndex,
-		desc:        desc,
-		chunkSize:   chunkSize,
-		schema:      input.Schema(),
-		prepared:    false,
-		tempFiles:   []string{},
+		input:     input,
+		keys:      keys,
+		chunkSize: chunkSize,
+		memory:    memory,
+		tempQuota: opts.TempQuota,
+		spill:     opts.Spill,
+		collation: opts.Collation,
+		schema:    input.Schema(),
+		prepared:  false,
+		tempFiles: []string{},
 	}
 }
 
@@ -63,7 +158,9 @@ func (s *SortOp) prepare() error {
 	}
 
 	// Phase 1: Chunk and flush sorted runs to temp files
-	chunk := make([]*types.Row, 0, s.chunkSize)
+	var chunk []*types.Row
+	var chunkBytes int64
+	var scratch []byte
 
 	for {
 		row, err := s.input.Next()
@@ -76,11 +173,21 @@ func (s *SortOp) prepare() error {
 
 		chunk = append(chunk, row)
 
-		if len(chunk) >= s.chunkSize {
+		full := false
+		if s.memory > 0 {
+			var size int64
+			size, scratch = encodedRowSize(row, scratch)
+			chunkBytes += size
+			s.holdMemory(chunkBytes)
+			full = chunkBytes >= s.memory
+		} else {
+			full = len(chunk) >= s.chunkSize
+		}
+		if full {
 			if err := s.flushChunk(chunk); err != nil {
 				return err
 			}
-			chunk = make([]*types.Row, 0, s.chunkSize)
+			chunk, chunkBytes = nil, 0
 		}
 	}
 
@@ -102,43 +209,72 @@ func (s *SortOp) prepare() error {
 
 // flushChunk sorts a chunk in memory and writes it to a temp file
 func (s *SortOp) flushChunk(chunk []*types.Row) error {
-	// Sort chunk in memory
-	sort.Slice(chunk, func(i, j int) bool {
-		cmp := s.compareRows(chunk[i], chunk[j])
-		if s.desc {
-			return cmp > 0
-		}
-		return cmp < 0
+	// Sort chunk in memory, keeping equal rows in order
+	sort.SliceStable(chunk, func(i, j int) bool {
+		return s.compareRows(chunk[i], chunk[j]) < 0
 	})
+	if s.err != nil {
+		return s.err
+	}
 
 	// Create temp file
-	tempFile, err := os.CreateTemp("", "golap_sort_*.csv")
+	tempFile, err := s.spill.create("golap_sort_*.csv")
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return err
 	}
 	defer tempFile.Close()
+	// Track the file right away so Close removes it even if writing fails
+	s.tempFiles = append(s.tempFiles, tempFile.Name())
 
-	// Write sorted chunk to temp file
-	writer := csv.NewWriter(tempFile)
+	// Write sorted chunk to temp file, charging bytes to the query's quota
+	out := s.spill.writer(tempFile, s.tempQuota, &s.spilled)
+	writer := csv.NewWriter(out)
 	for _, row := range chunk {
-		record := s.rowToRecord(row)
-		if err := writer.Write(record); err != nil {
-			os.Remove(tempFile.Name())
+		s.record = appendSpillRecord(s.record[:0], row.Values)
+		if err := writer.Write(s.record); err != nil {
 			return fmt.Errorf("failed to write to temp file: %w", err)
 		}
 	}
 	writer.Flush()
 	if err := writer.Error(); err != nil {
-		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to flush temp file: %w", err)
+	}
+	if err := out.Close(); err != nil {
 		return fmt.Errorf("failed to flush temp file: %w", err)
 	}
 
-	s.tempFiles = append(s.tempFiles, tempFile.Name())
+	// The run is read back from the file, so the rows can be reused
+	for _, row := range chunk {
+		types.ReleaseRow(row)
+	}
 	return nil
 }
 
-// rowToRecord converts a Row to a CSV record (string slice)
-func (s *SortOp) rowToRecord(row *types.Row) []string {
+// encodedRowSize returns the bytes a row takes in a spilled CSV run
+// (ignoring quoting), formatting numbers into scratch to avoid allocating
+func encodedRowSize(row *types.Row, scratch []byte) (int64, []byte) {
+	size := int64(len(row.Values)) // Separators and the newline
+	for _, val := range row.Values {
+		switch v := val.(type) {
+		case string:
+			size += int64(len(v))
+		case int64:
+			scratch = strconv.AppendInt(scratch[:0], v, 10)
+			size += int64(len(scratch))
+		case float64:
+			scratch = strconv.AppendFloat(scratch[:0], v, 'f', -1, 64)
+			size += int64(len(scratch))
+		case nil:
+		default:
+			size += int64(len(fmt.Sprint(v)))
+		}
+	}
+	return size, scratch
+}
+
+// rowToRecord converts a Row to a CSV record (string slice) for output;
+// NULL is written as an empty field
+func rowToRecord(row *types.Row) []string {
 	record := make([]string, len(row.Values))
 	for i, val := range row.Values {
 		switch v := val.(type) {
@@ -148,6 +284,8 @@ func (s *SortOp) rowToRecord(row *types.Row) []string {
 			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
 		case string:
 			record[i] = v
+		case nil:
+			record[i] = "" // Read back as NULL by parseValue
 		default:
 			record[i] = fmt.Sprintf("%v", val)
 		}
@@ -155,17 +293,13 @@ func (s *SortOp) rowToRecord(row *types.Row) []string {
 	return record
 }
 
-// recordToRow converts a CSV record back to a Row
-func (s *SortOp) recordToRow(record []string) *types.Row {
-	values := make([]interface{}, len(record))
-	for i, val := range record {
-		if i < len(s.schema.Types) {
-			values[i] = parseValue(val, s.schema.Types[i])
-		} else {
-			values[i] = val
-		}
+// spilledRow reads back a row written by appendSpillRecord
+func spilledRow(record []string) (*types.Row, error) {
+	values, err := parseSpillRecord(make([]interface{}, 0, len(record)), record)
+	if err != nil {
+		return nil, err
 	}
-	return &types.Row{Values: values}
+	return &types.Row{Values: values}, nil
 }
 
 // setupMerge opens all temp files and initializes the merge heap
@@ -178,9 +312,8 @@ func (s *SortOp) setupMerge() error {
 	s.readers = make([]*csv.Reader, len(s.tempFiles))
 	s.files = make([]*os.File, len(s.tempFiles))
 	s.mergeHeap = &mergeHeap{
-		items:       make([]*heapItem, 0, len(s.tempFiles)),
-		columnIndex: s.columnIndex,
-		desc:        s.desc,
+		items:   make([]*heapItem, 0, len(s.tempFiles)),
+		compare: s.compareRows,
 	}
 	heap.Init(s.mergeHeap)
 
@@ -191,7 +324,7 @@ func (s *SortOp) setupMerge() error {
 			return fmt.Errorf("failed to open temp file for merge: %w", err)
 		}
 		s.files[i] = file
-		s.readers[i] = csv.NewReader(file)
+		s.readers[i] = csv.NewReader(s.spill.reader(file))
 
 		// Read first row from this file
 		record, err := s.readers[i].Read()
@@ -202,64 +335,49 @@ func (s *SortOp) setupMerge() error {
 			return fmt.Errorf("failed to read from temp file: %w", err)
 		}
 
-		row := s.recordToRow(record)
+		row, err := spilledRow(record)
+		if err != nil {
+			return err
+		}
 		heap.Push(s.mergeHeap, &heapItem{row: row, fileIndex: i})
 	}
 
-	return nil
+	return s.err
 }
 
-// compareRows compares two rows by the sort column
+// compareRows compares two rows by the sort keys, applying each key's
+// direction; negative means a sorts before b. The first number compared
+// with text is kept in s.err, to fail the sort with.
 func (s *SortOp) compareRows(a, b *types.Row) int {
-	if s.columnIndex < 0 || s.columnIndex >= len(a.Values) || s.columnIndex >= len(b.Values) {
-		return 0
-	}
-
-	aVal := a.Values[s.columnIndex]
-	bVal := b.Values[s.columnIndex]
-
-	// Compare based on type
-	switch av := aVal.(type) {
-	case int64:
-		bv, ok := bVal.(int64)
-		if !ok {
-			return 0
-		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
-		}
-		return 0
-	case float64:
-		bv, ok := bVal.(float64)
-		if !ok {
-			return 0
+	for _, key := range s.keys {
+		if key.ColumnIndex < 0 || key.ColumnIndex >= len(a.Values) || key.ColumnIndex >= len(b.Values) {
+			continue
 		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
+		aVal, bVal := a.Values[key.ColumnIndex], b.Values[key.ColumnIndex]
+		if s.err == nil && incomparable(aVal, bVal) {
+			s.err = fmt.Errorf("ORDER BY %s: %w", s.schema.Columns[key.ColumnIndex], incomparableError(aVal, bVal))
 		}
-		return 0
-	case string:
-		bv, ok := bVal.(string)
-		if !ok {
-			return 0
+		cmp := compareCollated(aVal, bVal, s.collation)
+		if key.Desc {
+			cmp = -cmp
 		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
+		if cmp != 0 {
+			return cmp
 		}
-		return 0
-	default:
-		return 0
 	}
+	return 0
 }
 
 // Next returns the next sorted row using K-way merge
 func (s *SortOp) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *SortOp) next() (*types.Row, error) {
 	if !s.prepared {
 		if err := s.prepare(); err != nil {
 			return nil, err
@@ -280,8 +398,14 @@ func (s *SortOp) Next() (*types.Row, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error reading during merge: %w", err)
 		}
-		newRow := s.recordToRow(record)
+		newRow, err := spilledRow(record)
+		if err != nil {
+			return nil, err
+		}
 		heap.Push(s.mergeHeap, &heapItem{row: newRow, fileIndex: item.fileIndex})
+		if s.err != nil {
+			return nil, s.err
+		}
 	}
 
 	return result, nil
@@ -309,11 +433,62 @@ func (s *SortOp) Close() error {
 	return nil
 }
 
+// SortOrder returns the sort keys
+func (s *SortOp) SortOrder() []SortKey {
+	return s.keys
+}
+
+// Inputs returns the sorted input
+func (s *SortOp) Inputs() []types.Operator {
+	return []types.Operator{s.input}
+}
+
 // Schema returns the schema (unchanged from input)
 func (s *SortOp) Schema() types.Schema {
 	return s.schema
 }
 
+// Explain describes the sort and predicts its spill: every input row is
+// written to a temp chunk file once before merging
+func (s *SortOp) Explain() PlanNode {
+	child := ExplainOperator(s.input)
+
+	keys := make([]string, len(s.keys))
+	for i, key := range s.keys {
+		column := "?"
+		if key.ColumnIndex >= 0 && key.ColumnIndex < len(s.schema.Columns) {
+			column = s.schema.Columns[key.ColumnIndex]
+		}
+		if key.Desc {
+			keys[i] = column + " DESC"
+		} else {
+			keys[i] = column + " ASC"
+		}
+	}
+
+	spill := int64(-1)
+	if child.EstimatedRows >= 0 && child.EstimatedRowBytes >= 0 {
+		spill = child.EstimatedRows * child.EstimatedRowBytes
+	}
+
+	chunk := fmt.Sprintf("memory=%s", FormatBytes(s.memory))
+	if s.memory <= 0 {
+		chunk = fmt.Sprintf("chunk=%d rows", s.chunkSize)
+	}
+	if !s.collation.IsBinary() {
+		chunk += ", collation=" + s.collation.String()
+	}
+
+	return PlanNode{
+		Operator:          "Sort",
+		Details:           strings.Join(keys, ", ") + ", " + chunk,
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: child.EstimatedRowBytes,
+		SpillBytes:        spill,
+		Children:          []PlanNode{child},
+	}
+}
+
 // heapItem represents an item in the merge heap
 type heapItem struct {
 	row       *types.Row
@@ -321,20 +496,20 @@ type heapItem struct {
 }
 
 // mergeHeap implements container/heap.Interface for K-way merge
+// Equal rows come from the earlier run first, as runs are consecutive
+// stretches of the input, which keeps the merge stable.
 type mergeHeap struct {
-	items       []*heapItem
-	columnIndex int
-	desc        bool
+	items   []*heapItem
+	compare func(a, b *types.Row) int
 }
 
 func (h *mergeHeap) Len() int { return len(h.items) }
 
 func (h *mergeHeap) Less(i, j int) bool {
-	cmp := h.compareRows(h.items[i].row, h.items[j].row)
-	if h.desc {
-		return cmp > 0
+	if cmp := h.compare(h.items[i].row, h.items[j].row); cmp != 0 {
+		return cmp < 0
 	}
-	return cmp < 0
+	return h.items[i].fileIndex < h.items[j].fileIndex
 }
 
 func (h *mergeHeap) Swap(i, j int) {
@@ -352,50 +527,3 @@ func (h *mergeHeap) Pop() interface{} {
 	h.items = old[0 : n-1]
 	return item
 }
-
-func (h *mergeHeap) compareRows(a, b *types.Row) int {
-	if h.columnIndex < 0 || h.columnIndex >= len(a.Values) || h.columnIndex >= len(b.Values) {
-		return 0
-	}
-
-	aVal := a.Values[h.columnIndex]
-	bVal := b.Values[h.columnIndex]
-
-	switch av := aVal.(type) {
-	case int64:
-		bv, ok := bVal.(int64)
-		if !ok {
-			return 0
-		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
-		}
-		return 0
-	case float64:
-		bv, ok := bVal.(float64)
-		if !ok {
-			return 0
-		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
-		}
-		return 0
-	case string:
-		bv, ok := bVal.(string)
-		if !ok {
-			return 0
-		}
-		if av < bv {
-			return -1
-		} else if av > bv {
-			return 1
-		}
-		return 0
-	default:
-		return 0
-	}
-}
@@ -0,0 +1,172 @@
+package operators
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// TempDirEnv names the environment variable giving the directory for spill
+// files when SpillOptions.Dir is empty (then os.TempDir, i.e. $TMPDIR)
+const TempDirEnv = "GOLAP_TEMP_DIR"
+
+// SpillCompression is how spilling operators compress their temp files
+type SpillCompression int
+
+const (
+	SpillUncompressed SpillCompression = iota
+	SpillLZ4                           // LZ4 blocks: fast, typically 2-4x smaller for CSV rows
+	SpillSnappy                        // Snappy framing format (klauspost/compress/s2): about as fast, often a little smaller
+)
+
+func (c SpillCompression) String() string {
+	switch c {
+	case SpillLZ4:
+		return "lz4"
+	case SpillSnappy:
+		return "snappy"
+	default:
+		return "none"
+	}
+}
+
+// ParseSpillCompression converts a compression name (none, lz4 or snappy;
+// any case) to a SpillCompression; "" means none
+func ParseSpillCompression(name string) (SpillCompression, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return SpillUncompressed, nil
+	case "lz4":
+		return SpillLZ4, nil
+	case "snappy":
+		return SpillSnappy, nil
+	default:
+		return 0, fmt.Errorf("unknown spill compression %q (use none, lz4 or snappy)", name)
+	}
+}
+
+// SpillOptions says where and how sorts, DISTINCT and GROUP BY write their
+// temp files. The quota counts the bytes written to disk, after compression.
+type SpillOptions struct {
+	Dir         string // Directory for temp files; "" = $GOLAP_TEMP_DIR, else os.TempDir()
+	Compression SpillCompression
+	Context     context.Context // Fails reads of temp files once canceled (nil = never)
+	Logger      *slog.Logger    // Gets a Debug record per temp file created (nil = none)
+}
+
+// dir returns the directory temp files are created in
+func (o SpillOptions) dir() string {
+	if o.Dir != "" {
+		return o.Dir
+	}
+	return os.Getenv(TempDirEnv) // "" makes os.CreateTemp use os.TempDir()
+}
+
+// create makes a new temp file, pattern as for os.CreateTemp
+func (o SpillOptions) create(pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(o.dir(), pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if o.Logger != nil {
+		o.Logger.Debug("spilling to temp file", "file", file.Name(), "compression", o.Compression.String())
+	}
+	return file, nil
+}
+
+// writer returns a writer onto a temp file that compresses as the options
+// say, charges what reaches the file to quota and adds it to written.
+// Close writes out what it buffers; it doesn't close the file.
+func (o SpillOptions) writer(file io.Writer, quota *TempSpaceQuota, written *int64) io.WriteCloser {
+	w := &quotaWriter{writer: file, quota: quota, written: written}
+	switch o.Compression {
+	case SpillLZ4:
+		return newLZ4Writer(w)
+	case SpillSnappy:
+		return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1))
+	}
+	return nopWriteCloser{w}
+}
+
+// reader returns a reader of a temp file written through writer
+func (o SpillOptions) reader(file io.Reader) io.Reader {
+	if o.Context != nil {
+		file = contextReader{ctx: o.Context, reader: file}
+	}
+	switch o.Compression {
+	case SpillLZ4:
+		return newLZ4Reader(file)
+	case SpillSnappy:
+		return s2.NewReader(file)
+	}
+	return file
+}
+
+// nopWriteCloser is a writer whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// spillPartition is one hash partition's temp file of a spilling DISTINCT
+// or GROUP BY: written by one pass, then read back by a later one
+type spillPartition struct {
+	path   string
+	level  int // Pass that will read it
+	file   *os.File
+	out    io.WriteCloser // Compresses and counts what writer writes to file
+	writer *csv.Writer
+	reader *csv.Reader
+}
+
+// newPartition creates a partition file for pass level to read, pattern as
+// for os.CreateTemp; what's written is counted as for writer
+func (o SpillOptions) newPartition(pattern string, level int, quota *TempSpaceQuota, written *int64) (*spillPartition, error) {
+	file, err := o.create(pattern)
+	if err != nil {
+		return nil, err
+	}
+	out := o.writer(file, quota, written)
+	return &spillPartition{
+		path:   file.Name(),
+		level:  level,
+		file:   file,
+		out:    out,
+		writer: csv.NewWriter(out),
+	}, nil
+}
+
+// finish writes out what the partition buffers and closes its file
+func (p *spillPartition) finish() error {
+	p.writer.Flush()
+	err := p.writer.Error()
+	if err == nil {
+		err = p.out.Close()
+	}
+	p.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to flush temp file: %w", err)
+	}
+	return nil
+}
+
+// open reopens the finished partition file for reading
+func (p *spillPartition) open(o SpillOptions) error {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to open temp file: %w", err)
+	}
+	p.file = file
+	p.reader = csv.NewReader(o.reader(file))
+	p.reader.ReuseRecord = true
+	return nil
+}
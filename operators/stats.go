@@ -0,0 +1,219 @@
+package operators
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// OperatorStats is what one operator did while a query ran, with its
+// inputs' stats as Children: the EXPLAIN ANALYZE tree, and what embedders
+// export as query metrics. Collect it with CollectStats once the rows have
+// been read (or the query stopped), before or after Close.
+type OperatorStats struct {
+	Operator        string          `json:"operator"` // As in EXPLAIN
+	Details         string          `json:"details,omitempty"`
+	EstimatedRows   int64           `json:"estimated_rows"`    // EXPLAIN's estimate; -1 = unknown
+	RowsIn          int64           `json:"rows_in"`           // Rows its inputs returned to it (0 for scans)
+	RowsOut         int64           `json:"rows_out"`          // Rows it returned (for a scan aggregating in parallel, rows its workers aggregated)
+	Time            time.Duration   `json:"time_ns"`           // In its Next/NextBatch calls, its inputs' included; 0 unless timed (see EnableTiming)
+	SpillBytes      int64           `json:"spill_bytes"`       // Temp file bytes written, after compression
+	BytesRead       int64           `json:"bytes_read"`        // Bytes a scan read from its files (0 for other operators)
+	RaggedRows      int64           `json:"ragged_rows"`       // Records with the wrong number of fields a CSV scan skipped or padded
+	PeakMemoryBytes int64           `json:"peak_memory_bytes"` // Most memory it held at once, by its own estimate (0 for streaming operators)
+	Children        []OperatorStats `json:"children,omitempty"`
+}
+
+// StatsReporter is implemented by operators that count their work as they
+// run: every operator in this package, through an embedded execStats
+type StatsReporter interface {
+	// Stats returns the operator's own counters (no Operator name or
+	// Children; CollectStats adds them)
+	Stats() OperatorStats
+	// Inputs returns the operators it reads, in plan order
+	Inputs() []types.Operator
+	// SetTiming turns timing of its Next calls on or off
+	SetTiming(on bool)
+}
+
+// CollectStats returns the stats of an operator tree. Operators that don't
+// report stats appear with their EXPLAIN name and -1 rows.
+func CollectStats(op types.Operator) OperatorStats {
+	plan := ExplainOperator(op)
+	reporter, ok := op.(StatsReporter)
+	if !ok {
+		return OperatorStats{Operator: plan.Operator, Details: plan.Details, EstimatedRows: plan.EstimatedRows, RowsIn: -1, RowsOut: -1, BytesRead: bytesRead(op), RaggedRows: raggedRows(op)}
+	}
+	stats := reporter.Stats()
+	stats.Operator, stats.Details, stats.EstimatedRows = plan.Operator, plan.Details, plan.EstimatedRows
+	stats.BytesRead, stats.RaggedRows = bytesRead(op), raggedRows(op)
+	for _, input := range reporter.Inputs() {
+		child := CollectStats(input)
+		stats.RowsIn += max(child.RowsOut, 0)
+		stats.Children = append(stats.Children, child)
+	}
+	return stats
+}
+
+// EnableTiming makes every operator of a tree time its Next calls, for
+// OperatorStats.Time. Call it before the first row is read. Timing costs
+// two clock reads per row per operator, so it's off by default.
+func EnableTiming(op types.Operator) {
+	reporter, ok := op.(StatsReporter)
+	if !ok {
+		return
+	}
+	reporter.SetTiming(true)
+	for _, input := range reporter.Inputs() {
+		EnableTiming(input)
+	}
+}
+
+// TotalSpillBytes sums the temp space written over the whole subtree
+func (s OperatorStats) TotalSpillBytes() int64 {
+	total := s.SpillBytes
+	for _, child := range s.Children {
+		total += child.TotalSpillBytes()
+	}
+	return total
+}
+
+// TotalBytesRead sums the bytes the subtree's scans read
+func (s OperatorStats) TotalBytesRead() int64 {
+	total := s.BytesRead
+	for _, child := range s.Children {
+		total += child.TotalBytesRead()
+	}
+	return total
+}
+
+// TotalRaggedRows sums the records with the wrong number of fields the
+// subtree's scans skipped or padded
+func (s OperatorStats) TotalRaggedRows() int64 {
+	total := s.RaggedRows
+	for _, child := range s.Children {
+		total += child.TotalRaggedRows()
+	}
+	return total
+}
+
+// Lines renders the subtree as indented text, one operator per line, as
+// PlanNode.Lines does with the actual counts after the estimates
+func (s OperatorStats) Lines() []string {
+	var lines []string
+	s.appendLines(&lines, 0)
+	return lines
+}
+
+func (s OperatorStats) appendLines(lines *[]string, depth int) {
+	var b strings.Builder
+	b.WriteString(strings.Repeat("  ", depth))
+	if depth > 0 {
+		b.WriteString("-> ")
+	}
+	b.WriteString(s.Operator)
+	if s.Details != "" {
+		b.WriteString(" (" + s.Details + ")")
+	}
+	for _, metric := range s.metrics() {
+		b.WriteString(" " + metric)
+	}
+	*lines = append(*lines, b.String())
+
+	for _, child := range s.Children {
+		child.appendLines(lines, depth+1)
+	}
+}
+
+// metrics are the estimate and actual counts shown after the operator's
+// name and details
+func (s OperatorStats) metrics() []string {
+	var metrics []string
+	if s.EstimatedRows >= 0 {
+		metrics = append(metrics, fmt.Sprintf("rows~%d", s.EstimatedRows))
+	}
+	if s.RowsOut >= 0 {
+		metrics = append(metrics, fmt.Sprintf("actual rows=%d", s.RowsOut))
+	}
+	if s.Time > 0 {
+		metrics = append(metrics, "time="+s.Time.Round(time.Microsecond).String())
+	}
+	if s.BytesRead > 0 {
+		metrics = append(metrics, "read="+FormatBytes(s.BytesRead))
+	}
+	if s.RaggedRows > 0 {
+		metrics = append(metrics, fmt.Sprintf("ragged rows=%d", s.RaggedRows))
+	}
+	if s.SpillBytes > 0 {
+		metrics = append(metrics, "spilled="+FormatBytes(s.SpillBytes))
+	}
+	if s.PeakMemoryBytes > 0 {
+		metrics = append(metrics, "peak memory~"+FormatBytes(s.PeakMemoryBytes))
+	}
+	return metrics
+}
+
+// execStats are an operator's counters. Operators embed it, which makes
+// them StatsReporters given an Inputs method, and wrap their Next and
+// NextBatch bodies in startCall/endCall.
+type execStats struct {
+	timed      bool
+	rowsOut    int64
+	elapsed    time.Duration
+	spilled    int64 // Temp file bytes written (see SpillOptions.writer)
+	peakMemory int64
+}
+
+// Stats returns the counters
+func (e *execStats) Stats() OperatorStats {
+	return OperatorStats{
+		RowsOut:         e.rowsOut,
+		Time:            e.elapsed,
+		SpillBytes:      e.spilled,
+		PeakMemoryBytes: e.peakMemory,
+	}
+}
+
+// SetTiming turns timing of Next calls on or off
+func (e *execStats) SetTiming(on bool) {
+	e.timed = on
+}
+
+// startCall returns the time a Next call starts, if timed
+func (e *execStats) startCall() time.Time {
+	if !e.timed {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// endCall counts the rows a Next call returned, and its time if timed
+func (e *execStats) endCall(start time.Time, rows int) {
+	e.rowsOut += int64(rows)
+	if e.timed {
+		e.elapsed += time.Since(start)
+	}
+}
+
+// holdMemory records that the operator holds bytes, for the peak
+func (e *execStats) holdMemory(bytes int64) {
+	e.peakMemory = max(e.peakMemory, bytes)
+}
+
+// rowCount is the rows a Next call returned: 1, or 0 at the end
+func rowCount(row *types.Row) int {
+	if row == nil {
+		return 0
+	}
+	return 1
+}
+
+// batchRows is the rows a NextBatch call returned
+func batchRows(batch *types.RowBatch) int {
+	if batch == nil {
+		return 0
+	}
+	return batch.Rows()
+}
@@ -0,0 +1,152 @@
+package operators
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// StreamAggregateOp performs aggregation with GROUP BY over input already
+// sorted on the GROUP BY columns (see GroupsAdjacent), so each group's rows
+// are adjacent. It holds only the current group and returns it as soon as
+// the key changes: constant memory, no spill, and the first group comes
+// out without reading the whole input. Groups come out in input order.
+type StreamAggregateOp struct {
+	execStats
+
+	input          types.Operator
+	groupByIndices []int
+	aggregates     []AggregateExpr
+	outputSchema   types.Schema
+
+	batches *batchReader
+	batch   *types.RowBatch // Batch being read
+	pos     int             // Next row of batch (its k-th, not its position)
+	done    bool            // The input is exhausted
+	direct  []bool
+	needRow bool
+	row     types.Row
+
+	group   *groupState // Current group, nil before the first row
+	key     []byte      // The current group's key
+	nextKey []byte      // Key of the row being read
+}
+
+// NewStreamAggregateOp creates a streaming GROUP BY; input must group
+// adjacently on groupByIndices
+func NewStreamAggregateOp(input types.Operator, groupByIndices []int, aggregates []AggregateExpr) *StreamAggregateOp {
+	direct, needRow := batchAggregates(aggregates, len(input.Schema().Columns))
+	return &StreamAggregateOp{
+		input:          input,
+		groupByIndices: groupByIndices,
+		aggregates:     aggregates,
+		outputSchema:   groupedSchema(input.Schema(), groupByIndices, aggregates),
+		batches:        newBatchReader(input),
+		direct:         direct,
+		needRow:        needRow,
+	}
+}
+
+// Next returns the next group's result, once a row of another group (or
+// the end of the input) shows it is complete
+func (s *StreamAggregateOp) Next() (*types.Row, error) {
+	start := s.startCall()
+	row, err := s.next()
+	s.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (s *StreamAggregateOp) next() (*types.Row, error) {
+	for {
+		if s.batch == nil || s.pos >= s.batch.Rows() {
+			if s.done {
+				return nil, nil
+			}
+			batch, err := s.batches.next()
+			if err != nil {
+				return nil, err
+			}
+			if batch == nil {
+				s.done = true
+				return s.finishGroup()
+			}
+			s.batch, s.pos = batch, 0
+			continue
+		}
+
+		i := s.batch.Position(s.pos)
+		s.nextKey = appendGroupKey(s.nextKey[:0], s.groupByIndices, s.batch, i, BinaryCollation)
+		if s.group != nil && !bytes.Equal(s.nextKey, s.key) {
+			// The row starts the next group; it's read on the next call
+			return s.finishGroup()
+		}
+		if s.group == nil {
+			s.group = newGroupState(s.groupByIndices, len(s.aggregates), s.batch, i)
+			s.key = append(s.key[:0], s.nextKey...)
+		}
+		if s.needRow {
+			s.batch.Row(i, &s.row)
+		}
+		s.group.update(s.aggregates, s.direct, s.batch, i, &s.row)
+		s.pos++
+	}
+}
+
+// finishGroup returns the current group's result and clears it, or nil if
+// there is none
+func (s *StreamAggregateOp) finishGroup() (*types.Row, error) {
+	if s.group == nil {
+		return nil, nil
+	}
+	values, err := s.group.result(s.aggregates, s.outputSchema)
+	s.group = nil
+	if err != nil {
+		return nil, err
+	}
+	return &types.Row{Values: values}, nil
+}
+
+// SortOrder returns the input's order of the GROUP BY columns, which lead
+// the output
+func (s *StreamAggregateOp) SortOrder() []SortKey {
+	var order []SortKey
+	for _, key := range SortOrder(s.input) {
+		column := slices.Index(s.groupByIndices, key.ColumnIndex)
+		if column < 0 {
+			break
+		}
+		order = append(order, SortKey{ColumnIndex: column, Desc: key.Desc})
+	}
+	return order
+}
+
+// Close releases resources
+func (s *StreamAggregateOp) Close() error {
+	return s.input.Close()
+}
+
+// Inputs returns the grouped input
+func (s *StreamAggregateOp) Inputs() []types.Operator {
+	return []types.Operator{s.input}
+}
+
+// Schema returns the output schema
+func (s *StreamAggregateOp) Schema() types.Schema {
+	return s.outputSchema
+}
+
+// Explain describes the streaming aggregate; it holds one group, so it
+// never spills
+func (s *StreamAggregateOp) Explain() PlanNode {
+	child := ExplainOperator(s.input)
+	return PlanNode{
+		Operator:          "StreamAggregate",
+		Details:           strings.Join(s.outputSchema.Columns, ", ") + ", input sorted",
+		EstimatedRows:     child.EstimatedRows,
+		EstimatedRowBytes: int64(len(s.outputSchema.Columns)) * aggregateRowBytes,
+		Children:          []PlanNode{child},
+	}
+}
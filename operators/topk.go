@@ -0,0 +1,144 @@
+package operators
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// topKCounterFactor sizes a space-saving sketch relative to k; more
+// counters tighten the error bound (count error <= rows / counters)
+const topKCounterFactor = 10
+
+// minTopKCounters keeps small k from producing a uselessly small sketch
+const minTopKCounters = 64
+
+// topKCounter tracks one candidate value in a space-saving sketch
+type topKCounter struct {
+	key   string      // Canonical form, for lookup
+	value interface{} // Original value, for output
+	count int64       // Upper bound on the value's true frequency
+	index int         // Position in the min-heap
+}
+
+// spaceSaving is the Metwally et al. space-saving sketch: a fixed number
+// of counters, where an unseen value evicts the smallest counter and
+// inherits its count. Frequent values are never evicted, so the top k are
+// found in one pass with bounded memory.
+type spaceSaving struct {
+	capacity int
+	counters map[string]*topKCounter
+	minHeap  topKHeap
+}
+
+func newSpaceSaving(k int) *spaceSaving {
+	capacity := k * topKCounterFactor
+	if capacity < minTopKCounters {
+		capacity = minTopKCounters
+	}
+	return &spaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*topKCounter, capacity),
+	}
+}
+
+// add counts one occurrence of value
+func (s *spaceSaving) add(value interface{}) {
+	key := topKKey(value)
+	if c, ok := s.counters[key]; ok {
+		c.count++
+		heap.Fix(&s.minHeap, c.index)
+		return
+	}
+
+	if len(s.counters) < s.capacity {
+		c := &topKCounter{key: key, value: value, count: 1}
+		s.counters[key] = c
+		heap.Push(&s.minHeap, c)
+		return
+	}
+
+	// Replace the least frequent candidate, inheriting its count
+	c := s.minHeap[0]
+	delete(s.counters, c.key)
+	c.key = key
+	c.value = value
+	c.count++
+	s.counters[key] = c
+	heap.Fix(&s.minHeap, 0)
+}
+
+// topKKey returns a lookup key that keeps 1 and "1" distinct
+func topKKey(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "s" + v
+	case int64:
+		return "i" + strconv.FormatInt(v, 10)
+	case float64:
+		return "f" + strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprintf("%T:%v", v, v)
+	}
+}
+
+// top returns up to k values, most frequent first
+func (s *spaceSaving) top(k int) []interface{} {
+	counters := make([]*topKCounter, len(s.minHeap))
+	copy(counters, s.minHeap)
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].count != counters[j].count {
+			return counters[i].count > counters[j].count
+		}
+		return counters[i].key < counters[j].key // Deterministic ties
+	})
+	if len(counters) > k {
+		counters = counters[:k]
+	}
+
+	values := make([]interface{}, len(counters))
+	for i, c := range counters {
+		values[i] = c.value
+	}
+	return values
+}
+
+// topKResult renders the sketch's top k values as a JSON array
+func topKResult(s *spaceSaving, k int) interface{} {
+	if s == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(s.top(k))
+	if err != nil {
+		return nil
+	}
+	return string(encoded)
+}
+
+// topKHeap is a min-heap of counters by count (container/heap.Interface)
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	c := x.(*topKCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	*h = old[:n-1]
+	return c
+}
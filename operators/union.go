@@ -0,0 +1,140 @@
+package operators
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// UnionOp returns every row of each input in turn (UNION ALL)
+// Inputs must have the same number of columns; names come from the first
+// input. A column whose type differs between inputs becomes Float if all
+// of its types are numeric, String otherwise, and values are converted.
+type UnionOp struct {
+	execStats
+
+	inputs  []types.Operator
+	current int
+	schema  types.Schema
+}
+
+// NewUnionOp creates a union of the inputs, in order
+func NewUnionOp(inputs ...types.Operator) (*UnionOp, error) {
+	first := inputs[0].Schema()
+	schema := types.Schema{
+		Columns: first.Columns,
+		Types:   append([]types.DataType(nil), first.Types...),
+	}
+
+	for _, input := range inputs[1:] {
+		other := input.Schema()
+		if len(other.Columns) != len(schema.Columns) {
+			return nil, fmt.Errorf("UNION inputs have different column counts: %d and %d",
+				len(schema.Columns), len(other.Columns))
+		}
+		for i, dt := range other.Types {
+			schema.Types[i] = widenType(schema.Types[i], dt)
+		}
+	}
+
+	return &UnionOp{inputs: inputs, schema: schema}, nil
+}
+
+// Next returns the next row of the current input, moving on when it ends
+func (u *UnionOp) Next() (*types.Row, error) {
+	start := u.startCall()
+	row, err := u.next()
+	u.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (u *UnionOp) next() (*types.Row, error) {
+	for u.current < len(u.inputs) {
+		row, err := u.inputs[u.current].Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			u.current++
+			continue
+		}
+
+		inputTypes := u.inputs[u.current].Schema().Types
+		converted := row
+		for i, dt := range u.schema.Types {
+			if i >= len(inputTypes) || inputTypes[i] == dt || i >= len(row.Values) {
+				continue
+			}
+			if converted == row {
+				// Copy before converting; upstream operators may reuse row buffers
+				converted = &types.Row{Values: append([]interface{}(nil), row.Values...)}
+			}
+			converted.Values[i] = convertValue(row.Values[i], dt)
+		}
+		return converted, nil
+	}
+	return nil, nil
+}
+
+// convertValue widens a value to Float or String; NULL stays NULL
+func convertValue(val interface{}, dt types.DataType) interface{} {
+	switch v := val.(type) {
+	case int64:
+		if dt == types.Float {
+			return float64(v)
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if dt == types.String {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return val
+}
+
+// Close closes every input
+func (u *UnionOp) Close() error {
+	var firstErr error
+	for _, input := range u.inputs {
+		if err := input.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Inputs returns the combined inputs, in order
+func (u *UnionOp) Inputs() []types.Operator {
+	return u.inputs
+}
+
+// Schema returns the combined schema
+func (u *UnionOp) Schema() types.Schema {
+	return u.schema
+}
+
+// Explain sums the inputs' row estimates
+func (u *UnionOp) Explain() PlanNode {
+	node := PlanNode{
+		Operator:          "Union",
+		EstimatedRows:     0,
+		EstimatedRowBytes: 0,
+	}
+	for _, input := range u.inputs {
+		child := ExplainOperator(input)
+		node.Children = append(node.Children, child)
+		if child.EstimatedRows < 0 || node.EstimatedRows < 0 {
+			node.EstimatedRows = -1
+		} else {
+			node.EstimatedRows += child.EstimatedRows
+		}
+		if child.EstimatedRowBytes < 0 || node.EstimatedRowBytes < 0 {
+			node.EstimatedRowBytes = -1
+		} else if child.EstimatedRowBytes > node.EstimatedRowBytes {
+			node.EstimatedRowBytes = child.EstimatedRowBytes
+		}
+	}
+	return node
+}
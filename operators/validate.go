@@ -0,0 +1,286 @@
+package operators
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// validateExamples is how many examples of each problem a report keeps
+const validateExamples = 5
+
+// CSVReport describes the quality of a CSV file, as found by ValidateCSV
+type CSVReport struct {
+	Path             string         `json:"path"`
+	Delimiter        string         `json:"delimiter"`
+	Encoding         string         `json:"encoding"`
+	Rows             int64          `json:"rows"` // Data records, including ragged and malformed ones
+	Columns          []ColumnReport `json:"columns"`
+	DuplicateHeaders []string       `json:"duplicate_headers,omitempty"`
+	EmptyHeaders     []int          `json:"empty_headers,omitempty"` // 1-based positions of unnamed columns
+	ByteOrderMark    bool           `json:"byte_order_mark"`         // The header starts with a UTF-8 BOM, which becomes part of the first name
+
+	RaggedRows        int64        `json:"ragged_rows"` // Records with more or fewer fields than the header
+	RaggedExamples    []CSVProblem `json:"ragged_examples,omitempty"`
+	MalformedRows     int64        `json:"malformed_rows"` // Records with broken quoting
+	MalformedExamples []CSVProblem `json:"malformed_examples,omitempty"`
+	InvalidUTF8Rows   int64        `json:"invalid_utf8_rows"` // Records that aren't valid UTF-8 (in a file read as UTF-8)
+	InvalidUTF8       []CSVProblem `json:"invalid_utf8_examples,omitempty"`
+}
+
+// ColumnReport describes one column of a validated CSV file. Values that
+// don't parse as the column's type are what -strict would reject.
+type ColumnReport struct {
+	Name            string       `json:"name"`
+	Type            string       `json:"type"`
+	Declared        bool         `json:"declared"` // The type was declared rather than inferred
+	Nulls           int64        `json:"nulls"`
+	NullFraction    float64      `json:"null_fraction"` // Of the well-formed rows
+	Invalid         int64        `json:"invalid"`
+	InvalidExamples []CSVProblem `json:"invalid_examples,omitempty"`
+}
+
+// CSVProblem is an example of a problem: where it is and what was found
+type CSVProblem struct {
+	Line   int    `json:"line"`
+	Detail string `json:"detail"`
+}
+
+// Clean reports whether the file has none of the problems a query could
+// trip over: ragged or malformed rows, values that don't parse, duplicate
+// or empty column names, or encoding problems. NULLs aren't problems.
+func (r *CSVReport) Clean() bool {
+	if r.RaggedRows > 0 || r.MalformedRows > 0 || r.InvalidUTF8Rows > 0 || r.ByteOrderMark ||
+		len(r.DuplicateHeaders) > 0 || len(r.EmptyHeaders) > 0 {
+		return false
+	}
+	for _, col := range r.Columns {
+		if col.Invalid > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// csvValidator gathers a CSVReport while reading a file's records
+type csvValidator struct {
+	report     *CSVReport
+	width      int
+	types      []types.DataType
+	nullValues map[string]bool
+	checkUTF8  bool
+	wellFormed int64
+}
+
+// ValidateCSV reads a whole CSV file the way CSVScan would, with the same
+// options, reporting what's wrong with it instead of stopping at the first
+// problem: records with the wrong number of fields or broken quoting,
+// values that don't parse as their column's type (inferred from the first
+// rows unless declared), duplicate or empty header names, bytes that
+// aren't UTF-8, and each column's NULLs. Only failing to read the file is
+// an error.
+func ValidateCSV(filePath string, opts ScanOptions) (*CSVReport, error) {
+	encoding, err := ParseEncoding(opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	file, _, gzipReader, input, err := openScanInput(filePath, opts.BufferSize, opts.Encoding, opts.Mmap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+	if gzipReader != nil {
+		defer gzipReader.Close()
+	}
+
+	delimiter := opts.Delimiter
+	if delimiter == 0 {
+		delimiter = detectDelimiter(input)
+	}
+	reader := newCSVReader(input, delimiter)
+	reader.fieldsPerRecord = -1 // Ragged records are reported, not errors
+	cancel := cancelCheck{ctx: opts.Context}
+
+	v := &csvValidator{
+		report:    &CSVReport{Path: filePath, Delimiter: string(delimiter), Encoding: encoding},
+		checkUTF8: encoding == EncodingUTF8,
+	}
+	if len(opts.NullValues) > 0 {
+		v.nullValues = make(map[string]bool, len(opts.NullValues))
+		for _, val := range opts.NullValues {
+			v.nullValues[val] = true
+		}
+	}
+
+	// read returns the next record, counting malformed ones and going on
+	read := func() (*csvRecord, error) {
+		for {
+			if err := cancel.check(); err != nil {
+				return nil, err
+			}
+			record, err := reader.Read()
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return record, err
+			}
+			v.report.Rows++
+			v.report.MalformedRows++
+			v.report.MalformedExamples = addProblem(v.report.MalformedExamples, parseErr.StartLine, parseErr.Err.Error())
+		}
+	}
+
+	var header []string
+	if !opts.NoHeader {
+		record, err := read()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		if record != nil {
+			v.checkEncoding(record)
+			header = record.strings()
+			v.report.ByteOrderMark = len(header) > 0 && strings.HasPrefix(header[0], "\ufeff")
+		}
+	}
+
+	// Read the first rows to infer types, as the scan does
+	sampleRows := opts.SampleRows
+	if sampleRows <= 0 {
+		sampleRows = DefaultSampleRows
+	}
+	var sample [][]string
+	var sampleLines []int
+	v.width = -1
+	if !opts.NoHeader {
+		v.width = len(header)
+	}
+	for len(sample) < sampleRows {
+		record, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV row: %w", err)
+		}
+		if v.width < 0 {
+			v.width = record.len()
+		}
+		if v.checkShape(record) {
+			sample = append(sample, record.strings())
+			sampleLines = append(sampleLines, record.line(0))
+		}
+	}
+	v.width = max(v.width, 0)
+
+	if opts.NoHeader || len(opts.ColumnNames) > 0 {
+		header, err = columnNames(opts.ColumnNames, v.width)
+		if err != nil {
+			return nil, err
+		}
+	}
+	v.types = inferColumnTypes(sample, len(header), v.nullValues)
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		_, declared := opts.ColumnTypes[name]
+		if declared {
+			v.types[i] = opts.ColumnTypes[name]
+		}
+		v.report.Columns = append(v.report.Columns, ColumnReport{Name: name, Type: v.types[i].String(), Declared: declared})
+		if name == "" {
+			v.report.EmptyHeaders = append(v.report.EmptyHeaders, i+1)
+		} else if seen[name] && !slices.Contains(v.report.DuplicateHeaders, name) {
+			v.report.DuplicateHeaders = append(v.report.DuplicateHeaders, name)
+		}
+		seen[name] = true
+	}
+
+	// Check the sampled rows' values, then the rest of the file's
+	var sampled csvRecord
+	for i, fields := range sample {
+		sampled.setStrings(fields, sampleLines[i])
+		v.checkValues(&sampled)
+	}
+	for {
+		record, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV row: %w", err)
+		}
+		if v.checkShape(record) {
+			v.checkValues(record)
+		}
+	}
+
+	for i := range v.report.Columns {
+		if v.wellFormed > 0 {
+			v.report.Columns[i].NullFraction = float64(v.report.Columns[i].Nulls) / float64(v.wellFormed)
+		}
+	}
+	return v.report, nil
+}
+
+// checkShape counts a data record, reporting it if its field count is
+// wrong, and returns whether its values can be checked
+func (v *csvValidator) checkShape(record *csvRecord) bool {
+	v.report.Rows++
+	v.checkEncoding(record)
+	if record.len() != v.width {
+		v.report.RaggedRows++
+		v.report.RaggedExamples = addProblem(v.report.RaggedExamples, record.line(0),
+			fmt.Sprintf("%d fields, expected %d", record.len(), v.width))
+		return false
+	}
+	return true
+}
+
+// checkEncoding reports a record holding bytes that aren't UTF-8
+func (v *csvValidator) checkEncoding(record *csvRecord) {
+	if !v.checkUTF8 {
+		return
+	}
+	for i := 0; i < record.len(); i++ {
+		if field := record.field(i); !utf8.Valid(field) {
+			v.report.InvalidUTF8Rows++
+			v.report.InvalidUTF8 = addProblem(v.report.InvalidUTF8, record.line(i),
+				fmt.Sprintf("field %d: %q", i+1, field))
+			return
+		}
+	}
+}
+
+// checkValues counts a well-formed record's NULLs and the values that
+// don't parse as their column's type
+func (v *csvValidator) checkValues(record *csvRecord) {
+	v.wellFormed++
+	for i, dt := range v.types {
+		col := &v.report.Columns[i]
+		field := record.field(i)
+		if v.nullValues[string(field)] {
+			col.Nulls++
+			continue
+		}
+		value, ok := parseRecordField(record, i, dt)
+		switch {
+		case !ok:
+			col.Invalid++
+			col.InvalidExamples = addProblem(col.InvalidExamples, record.line(i), fmt.Sprintf("%q is not %s", field, dt))
+		case value == nil:
+			col.Nulls++
+		}
+	}
+}
+
+// addProblem keeps an example, up to validateExamples of them
+func addProblem(examples []CSVProblem, line int, detail string) []CSVProblem {
+	if len(examples) >= validateExamples {
+		return examples
+	}
+	return append(examples, CSVProblem{Line: line, Detail: detail})
+}
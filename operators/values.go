@@ -0,0 +1,84 @@
+package operators
+
+import (
+	"github.com/aryamaansaha/golap/types"
+)
+
+// ValuesOp returns a fixed, in-memory set of rows
+// Used for statement results that aren't scans (DDL status, metadata listings)
+type ValuesOp struct {
+	execStats
+
+	schema  types.Schema
+	rows    []*types.Row
+	details string // Where the rows came from, for EXPLAIN
+	index   int
+}
+
+// NewValuesOp creates an operator over the given rows
+func NewValuesOp(schema types.Schema, rows []*types.Row) *ValuesOp {
+	return &ValuesOp{
+		schema: schema,
+		rows:   rows,
+	}
+}
+
+// NewValuesOpWithDetails creates an operator over rows computed at
+// planning, saying in EXPLAIN where they came from
+func NewValuesOpWithDetails(schema types.Schema, rows []*types.Row, details string) *ValuesOp {
+	op := NewValuesOp(schema, rows)
+	op.details = details
+	return op
+}
+
+// NewStatusOp creates a single-row, single-column result with a message
+func NewStatusOp(message string) *ValuesOp {
+	schema := types.Schema{
+		Columns: []string{"status"},
+		Types:   []types.DataType{types.String},
+	}
+	return NewValuesOp(schema, []*types.Row{{Values: []interface{}{message}}})
+}
+
+// Next returns the next row
+func (v *ValuesOp) Next() (*types.Row, error) {
+	start := v.startCall()
+	row, err := v.next()
+	v.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (v *ValuesOp) next() (*types.Row, error) {
+	if v.index >= len(v.rows) {
+		return nil, nil
+	}
+	row := v.rows[v.index]
+	v.index++
+	return row, nil
+}
+
+// Close releases resources
+func (v *ValuesOp) Close() error {
+	return nil
+}
+
+// Inputs returns nil: the rows are given
+func (v *ValuesOp) Inputs() []types.Operator {
+	return nil
+}
+
+// Schema returns the schema of the rows
+func (v *ValuesOp) Schema() types.Schema {
+	return v.schema
+}
+
+// Explain describes the in-memory rows
+func (v *ValuesOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "Values",
+		Details:           v.details,
+		EstimatedRows:     int64(len(v.rows)),
+		EstimatedRowBytes: -1,
+	}
+}
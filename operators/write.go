@@ -0,0 +1,197 @@
+package operators
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// fileWriter streams its input into a file (COPY ... TO / CREATE TABLE
+// AS), in the format its rowEncoder writes. Rows go to an AtomicFile,
+// renamed into place only once the input is fully consumed, so readers
+// never observe a partially written file.
+// Produces a single summary row. The writer operators embed it and add
+// their Explain.
+type fileWriter struct {
+	execStats
+
+	input      types.Operator
+	targetPath string
+	encoder    rowEncoder
+	gzip       bool // Compress the output
+	schema     types.Schema
+	done       bool
+	file       *AtomicFile // Set while an unfinished file needs cleanup
+}
+
+// rowEncoder writes rows in one file format. begin starts the file on out
+// for rows of schema, write adds a row, and end finishes the file; each
+// may buffer, but end leaves everything written to out.
+type rowEncoder interface {
+	begin(out io.Writer, schema types.Schema) error
+	write(row *types.Row) error
+	end() error
+}
+
+// newFileWriter returns a writer of input's rows to targetPath, gzipped if
+// compress is set
+func newFileWriter(input types.Operator, targetPath string, encoder rowEncoder, compress bool) fileWriter {
+	return fileWriter{
+		input:      input,
+		targetPath: targetPath,
+		encoder:    encoder,
+		gzip:       compress,
+		schema: types.Schema{
+			Columns: []string{"file", "rows_written"},
+			Types:   []types.DataType{types.String, types.Int},
+		},
+	}
+}
+
+// CSVWriteOp writes its input as a CSV file with a header. Targets ending
+// in .gz are gzip-compressed.
+type CSVWriteOp struct {
+	fileWriter
+}
+
+// NewFileWriteOp creates the writer operator matching a target's
+// extension: .golap files are written by GolapWriteOp, .parquet by
+// ParquetWriteOp, .jsonl/.ndjson (optionally .gz) by JSONWriteOp and
+// anything else as CSV
+func NewFileWriteOp(input types.Operator, targetPath string) types.Operator {
+	switch {
+	case isGolapPath(targetPath):
+		return NewGolapWriteOp(input, targetPath)
+	case isParquetPath(targetPath):
+		return NewParquetWriteOp(input, targetPath)
+	case isJSONLinesPath(targetPath):
+		return NewJSONWriteOp(input, targetPath)
+	}
+	return NewCSVWriteOp(input, targetPath)
+}
+
+// NewCSVWriteOp creates a writer operator targeting the given file path
+func NewCSVWriteOp(input types.Operator, targetPath string) *CSVWriteOp {
+	return &CSVWriteOp{newFileWriter(input, targetPath, &csvEncoder{}, strings.HasSuffix(targetPath, ".gz"))}
+}
+
+// Next writes all input rows and returns the summary row
+func (w *fileWriter) Next() (*types.Row, error) {
+	start := w.startCall()
+	row, err := w.next()
+	w.endCall(start, rowCount(row))
+	return row, err
+}
+
+// next is Next, uncounted
+func (w *fileWriter) next() (*types.Row, error) {
+	if w.done {
+		return nil, nil
+	}
+	w.done = true
+
+	rowCount, err := w.write()
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Row{Values: []interface{}{w.targetPath, rowCount}}, nil
+}
+
+// write streams input to an AtomicFile over the target
+func (w *fileWriter) write() (int64, error) {
+	file, err := CreateAtomicFile(w.targetPath, w.gzip)
+	if err != nil {
+		return 0, err
+	}
+	w.file = file
+
+	if err := w.encoder.begin(file, w.input.Schema()); err != nil {
+		return 0, err
+	}
+	var rowCount int64
+	for {
+		row, err := w.input.Next()
+		if err != nil {
+			return 0, err
+		}
+		if row == nil {
+			break
+		}
+		if err := w.encoder.write(row); err != nil {
+			return 0, err
+		}
+		rowCount++
+	}
+	if err := w.encoder.end(); err != nil {
+		return 0, err
+	}
+
+	w.file = nil // Commit removes the temp file if it fails
+	if err := file.Commit(); err != nil {
+		return 0, err
+	}
+	return rowCount, nil
+}
+
+// Close releases resources and removes any unfinished temp file
+func (w *fileWriter) Close() error {
+	if w.file != nil {
+		w.file.Discard()
+		w.file = nil
+	}
+	return w.input.Close()
+}
+
+// Inputs returns the query being written
+func (w *fileWriter) Inputs() []types.Operator {
+	return []types.Operator{w.input}
+}
+
+// Schema returns the summary schema (file, rows_written)
+func (w *fileWriter) Schema() types.Schema {
+	return w.schema
+}
+
+// Explain describes the output file
+func (w *CSVWriteOp) Explain() PlanNode {
+	return PlanNode{
+		Operator:          "CSVWrite",
+		Details:           w.targetPath,
+		EstimatedRows:     1,
+		EstimatedRowBytes: -1,
+		Children:          []PlanNode{ExplainOperator(w.input)},
+	}
+}
+
+// csvEncoder writes CSV: a header of the column names, then a record per
+// row
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) begin(out io.Writer, schema types.Schema) error {
+	e.writer = csv.NewWriter(out)
+	if err := e.writer.Write(schema.Columns); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return nil
+}
+
+func (e *csvEncoder) write(row *types.Row) error {
+	if err := e.writer.Write(rowToRecord(row)); err != nil {
+		return fmt.Errorf("failed to write row: %w", err)
+	}
+	return nil
+}
+
+func (e *csvEncoder) end() error {
+	e.writer.Flush()
+	if err := e.writer.Error(); err != nil {
+		return fmt.Errorf("failed to flush output: %w", err)
+	}
+	return nil
+}
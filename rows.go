@@ -0,0 +1,191 @@
+package golap
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/aryamaansaha/golap/types"
+)
+
+// Rows is the result of a query, read a row at a time as the query runs:
+// Next advances to each row and Scan (or Values) reads it. Values are
+// int64, float64, string or nil (NULL).
+type Rows struct {
+	op     types.Operator
+	schema types.Schema
+	row    *types.Row // The current row, nil before the first and after the last
+	err    error
+	closed bool
+}
+
+func newRows(op types.Operator) *Rows {
+	return &Rows{op: op, schema: op.Schema()}
+}
+
+// Columns returns the result's column names
+func (r *Rows) Columns() []string {
+	return append([]string(nil), r.schema.Columns...)
+}
+
+// ColumnTypes returns the result's column types
+func (r *Rows) ColumnTypes() []types.DataType {
+	return append([]types.DataType(nil), r.schema.Types...)
+}
+
+// Next advances to the next row, reporting whether there is one. After
+// the last row, or an error (see Err), the Rows are closed.
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	types.ReleaseRow(r.row)
+	r.row = nil
+	row, err := r.op.Next()
+	if err != nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+	if row == nil {
+		r.Close()
+		return false
+	}
+	r.row = row
+	return true
+}
+
+// Err returns the error that ended the rows early, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Values returns a copy of the current row's values
+func (r *Rows) Values() ([]any, error) {
+	if r.row == nil {
+		return nil, fmt.Errorf("no current row: call Next first")
+	}
+	return append([]any(nil), r.row.Values...), nil
+}
+
+// Scan copies the current row's values into dest, one per column. A
+// destination can be *any, *string, *int64, *int or *float64 for non-NULL
+// values, or a sql.Scanner (sql.NullString, sql.NullInt64, ...) for
+// columns that may be NULL. Numbers convert between int64 and float64
+// when no precision is lost, and text converts to numbers that parse.
+func (r *Rows) Scan(dest ...any) error {
+	if r.row == nil {
+		return fmt.Errorf("no current row: call Next first")
+	}
+	if len(dest) != len(r.row.Values) {
+		return fmt.Errorf("expected %d destinations, got %d", len(r.row.Values), len(dest))
+	}
+	for i, d := range dest {
+		if err := assign(d, r.row.Values[i]); err != nil {
+			return fmt.Errorf("column %s: %w", r.schema.Columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Close stops the query, removing its temp files. Next closes the Rows
+// after the last row, so calling Close again is harmless.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	types.ReleaseRow(r.row)
+	r.row = nil
+	err := r.op.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// assign stores a row value into a Scan destination
+func assign(dest, value any) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	if d, ok := dest.(*any); ok {
+		*d = value
+		return nil
+	}
+	if value == nil {
+		return fmt.Errorf("cannot scan NULL into %T (use *any or a sql.Null type)", dest)
+	}
+
+	switch d := dest.(type) {
+	case *string:
+		switch v := value.(type) {
+		case string:
+			*d = v
+		case int64:
+			*d = strconv.FormatInt(v, 10)
+		case float64:
+			*d = strconv.FormatFloat(v, 'g', -1, 64)
+		default:
+			*d = fmt.Sprint(v)
+		}
+		return nil
+	case *int64:
+		v, err := asInt(value)
+		if err != nil {
+			return err
+		}
+		*d = v
+		return nil
+	case *int:
+		v, err := asInt(value)
+		if err != nil {
+			return err
+		}
+		if int64(int(v)) != v {
+			return fmt.Errorf("%d overflows int", v)
+		}
+		*d = int(v)
+		return nil
+	case *float64:
+		switch v := value.(type) {
+		case float64:
+			*d = v
+		case int64:
+			*d = float64(v)
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("cannot scan %q into *float64", v)
+			}
+			*d = f
+		default:
+			return fmt.Errorf("cannot scan %T into *float64", value)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported Scan destination %T", dest)
+	}
+}
+
+// asInt converts a value to int64 without losing precision
+func asInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("cannot scan %v into an integer without losing precision", v)
+		}
+		return int64(v), nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot scan %q into an integer", v)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("cannot scan %T into an integer", value)
+	}
+}
@@ -131,7 +131,7 @@ def main():
     
     # Check binaries exist
     if not os.path.exists(golap_binary):
-        print(f"Error: {golap_binary} not found. Run 'go build -o golap .' first.")
+        print(f"Error: {golap_binary} not found. Run 'go build -o golap ./cmd/golap' first.")
         sys.exit(1)
     if not os.path.exists(naive_binary):
         print(f"Error: {naive_binary} not found. Run 'go build -o naive_loader ./cmd/naive_loader/' first.")
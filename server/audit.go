@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// AuditRecord is one line of an audit log: a statement sent to /query or
+// /jobs, who sent it and how it ended
+type AuditRecord struct {
+	Time       time.Time `json:"time"`                // When the request came in
+	Principal  string    `json:"principal,omitempty"` // ID of the caller; "" if unauthenticated
+	RemoteAddr string    `json:"remote_addr"`
+	Kind       string    `json:"kind"`              // query or job
+	Session    string    `json:"session,omitempty"` // Golap-Session, or the job's ID
+	SQL        string    `json:"sql"`
+	Status     string    `json:"status"` // ok, failed, or refused (a write, or not admitted)
+	Error      string    `json:"error,omitempty"`
+	WaitedMs   int64     `json:"waited_ms"`   // Queued for a slot
+	DurationMs int64     `json:"duration_ms"` // Planning and running, once admitted
+	Rows       int64     `json:"rows"`        // Sent, or written to a job's results
+	BytesRead  int64     `json:"bytes_read"`  // By the query's scans
+	Truncated  bool      `json:"truncated,omitempty"`
+}
+
+// AuditLog appends AuditRecords to a writer as JSON lines, one Write call
+// per record, so concurrent statements' records don't interleave
+type AuditLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // The file OpenAuditLog opened; nil for NewAuditLog
+}
+
+// NewAuditLog returns an audit log writing to w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// OpenAuditLog opens a file to append audit records to, creating it
+// (readable by its owner only) if it doesn't exist. Records already in it
+// are kept; the caller closes it.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLog{w: file, closer: file}, nil
+}
+
+// Record appends a record
+func (a *AuditLog) Record(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file OpenAuditLog opened
+func (a *AuditLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// auditRecord starts the audit record of a request's statement
+func auditRecord(r *http.Request, kind, sql string, received time.Time) AuditRecord {
+	return AuditRecord{Time: received, Principal: ownerOf(r), RemoteAddr: r.RemoteAddr, Kind: kind, SQL: sql}
+}
+
+// bytesRead returns the bytes a query's scans have read so far
+func bytesRead(op types.Operator) int64 {
+	return operators.CollectStats(op).TotalBytesRead()
+}
+
+// audit appends a record to Config.Audit, if set, with the status its
+// error implies unless it has one; a record that can't be written is
+// logged instead
+func (cfg Config) audit(rec AuditRecord, err error) {
+	if cfg.Audit == nil {
+		return
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if rec.Status == "" {
+		rec.Status = "ok"
+		if err != nil {
+			rec.Status = "failed"
+		}
+	}
+	if err := cfg.Audit.Record(rec); err != nil {
+		cfg.logger().Error("cannot write audit record", "sql", rec.SQL, "error", err)
+	}
+}
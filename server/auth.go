@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aryamaansaha/golap/engine"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnauthenticated is returned by an AuthnFunc for a request without
+// valid credentials; the middleware answers it with 401
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// errNoCredentials is why a request without the credentials an AuthnFunc
+// looks for is unauthenticated
+var errNoCredentials = errors.New("missing")
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	ID         string            // User or service identity, e.g. an SSO subject
+	Groups     []string          // Group or role memberships, for ACL checks
+	Attributes map[string]string // Anything else the AuthzFunc needs (tenant, claims, ...)
+}
+
+// AuthnFunc identifies the caller of a request. It returns
+// ErrUnauthenticated (or an error wrapping it) for missing or bad
+// credentials; any other error is treated as a failure of the identity
+// system itself.
+type AuthnFunc func(r *http.Request) (*Principal, error)
+
+// AuthzFunc decides whether a principal may read one FROM source of a
+// query: name is the name as written (view, table or path) and path the
+// file, glob or directory it resolves to ("" for a view). It is called once
+// per source, including the sources a view's definition reads.
+type AuthzFunc func(ctx context.Context, principal *Principal, name, path string) error
+
+// Hooks are the authentication and authorization callbacks of a server,
+// where embedders plug in their own SSO and ACL systems. A nil
+// Authenticate lets every request through anonymously; a nil Authorize
+// allows every source.
+type Hooks struct {
+	Authenticate AuthnFunc
+	Authorize    AuthzFunc
+
+	// Challenge is the WWW-Authenticate header of 401 answers, e.g.
+	// `Basic realm="golap"`; "" means Bearer
+	Challenge string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Middleware authenticates each request before passing it to next, with
+// the principal in the request context. Requests that fail authentication
+// get 401; errors from the identity system itself get 500.
+func (h Hooks) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Authenticate == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := h.Authenticate(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			challenge := h.Challenge
+			if challenge == "" {
+				challenge = "Bearer"
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("authentication failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if principal == nil {
+			principal = &Principal{}
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// QueryOptions returns opts with the Authorize hook bound to the
+// principal in ctx, so planning a query checks every source it reads, and
+// with ctx as the query's context, so it stops when the request is
+// canceled. Use it for each query run on behalf of a request.
+func (h Hooks) QueryOptions(ctx context.Context, opts engine.Options) engine.Options {
+	opts.Context = ctx
+	if h.Authorize == nil {
+		return opts
+	}
+	principal, _ := PrincipalFromContext(ctx)
+	opts.Authorize = func(name, path string) error {
+		if err := h.Authorize(ctx, principal, name, path); err != nil {
+			return fmt.Errorf("access to %s denied: %w", name, err)
+		}
+		return nil
+	}
+	return opts
+}
+
+// StaticTokens authenticates "Authorization: Bearer <token>" headers
+// against a fixed token -> principal ID map. It is the default for servers
+// without an external identity system.
+func StaticTokens(tokens map[string]string) AuthnFunc {
+	return func(r *http.Request) (*Principal, error) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("%w: %w bearer token", ErrUnauthenticated, errNoCredentials)
+		}
+		return matchToken(tokens, token)
+	}
+}
+
+// APIKeys authenticates API keys against a fixed key -> principal ID map,
+// sent as "X-API-Key: <key>" or, like StaticTokens, as a bearer token
+func APIKeys(keys map[string]string) AuthnFunc {
+	return func(r *http.Request) (*Principal, error) {
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key == "" {
+			key = bearer
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%w: %w API key", ErrUnauthenticated, errNoCredentials)
+		}
+		return matchToken(keys, key)
+	}
+}
+
+// matchToken returns the principal of a token, comparing it against every
+// one in constant time, so timing doesn't reveal how much of a guess
+// matched
+func matchToken(tokens map[string]string, token string) (*Principal, error) {
+	var id string
+	found := false
+	for candidate, principalID := range tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			id, found = principalID, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: invalid token", ErrUnauthenticated)
+	}
+	return &Principal{ID: id}, nil
+}
+
+// BasicAuth authenticates HTTP basic auth credentials against a fixed
+// user -> password map; the user name is the principal ID. A password may
+// be stored hashed instead of in the clear: as a bcrypt hash ($2a$, $2b$
+// or $2y$, e.g. from htpasswd -B) or an argon2id hash in the PHC format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$key). It fails for a malformed
+// hash, and for the unsalted "sha256:" form, which it no longer accepts.
+func BasicAuth(passwords map[string]string) (AuthnFunc, error) {
+	checks := make(map[string]func(password string) bool, len(passwords))
+	var decoy func(password string) bool
+	for user, stored := range passwords {
+		check, err := passwordCheck(stored)
+		if err != nil {
+			return nil, fmt.Errorf("password of %s: %w", user, err)
+		}
+		checks[user] = check
+		decoy = check
+	}
+	return func(r *http.Request) (*Principal, error) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, fmt.Errorf("%w: %w basic auth credentials", ErrUnauthenticated, errNoCredentials)
+		}
+		check, known := checks[user]
+		if !known {
+			// Check some user's password anyway, so an unknown user
+			// takes as long to turn away as a wrong password
+			if decoy != nil {
+				decoy(password)
+			}
+			return nil, fmt.Errorf("%w: invalid user name or password", ErrUnauthenticated)
+		}
+		if !check(password) {
+			return nil, fmt.Errorf("%w: invalid user name or password", ErrUnauthenticated)
+		}
+		return &Principal{ID: user}, nil
+	}, nil
+}
+
+// passwordCheck returns a function reporting whether a password matches
+// a stored one, hashed or not
+func passwordCheck(stored string) (func(password string) bool, error) {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		hash := []byte(stored)
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return func(password string) bool {
+			return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+		}, nil
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return argon2idCheck(stored)
+	case strings.HasPrefix(stored, "sha256:"):
+		return nil, fmt.Errorf("unsalted sha256: hashes are not supported; use bcrypt (htpasswd -nB USER) or argon2id")
+	}
+	// In the clear: compare digests, so the time taken doesn't depend on
+	// the password's length either
+	want := sha256.Sum256([]byte(stored))
+	return func(password string) bool {
+		given := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(given[:], want[:]) == 1
+	}, nil
+}
+
+// argon2idCheck parses $argon2id$v=19$m=MEMORY,t=TIME,p=THREADS$SALT$KEY,
+// with the salt and key in unpadded base64
+func argon2idCheck(stored string) (func(password string) bool, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid argon2id hash: expected $argon2id$v=19$m=...,t=...,p=...$salt$key")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("invalid argon2id hash: unsupported version %q", parts[2])
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id hash: bad parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id hash key")
+	}
+	return func(password string) bool {
+		given := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(given, key) == 1
+	}, nil
+}
+
+// FirstOf authenticates a request with the first of authns that
+// recognizes its credentials, e.g. API keys for services and basic auth
+// for people. If none does it fails with ErrUnauthenticated, saying why
+// the credentials sent were rejected; any other error fails it at once.
+func FirstOf(authns ...AuthnFunc) AuthnFunc {
+	return func(r *http.Request) (*Principal, error) {
+		var rejected error
+		for _, authn := range authns {
+			principal, err := authn(r)
+			if !errors.Is(err, ErrUnauthenticated) {
+				return principal, err
+			}
+			if rejected == nil || errors.Is(rejected, errNoCredentials) {
+				rejected = err
+			}
+		}
+		if rejected == nil {
+			rejected = fmt.Errorf("%w: %w credentials", ErrUnauthenticated, errNoCredentials)
+		}
+		return nil, rejected
+	}
+}
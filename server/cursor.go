@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// cursor is the decoded form of a /query continuation token
+type cursor struct {
+	Query  uint64 `json:"q"`           // Hash of the statement
+	Offset int64  `json:"o,omitempty"` // Rows earlier pages returned, skipped when the query reruns
+	Page   string `json:"p,omitempty"` // engine.PlanPage token: the scan resumes at a byte offset instead
+}
+
+// errBadCursor is the cause of a cursor that can't be resumed from
+var errBadCursor = errors.New("invalid cursor")
+
+// resumable is a /query plan and where it started, from which the cursor
+// for the rest of its rows follows
+type resumable struct {
+	start cursor
+	page  *engine.Page // Set if the plan is a page of a plain scan
+}
+
+// planResumable plans a /query statement, resuming where its cursor says.
+// A plain filter/project query over a CSV file with max_rows is planned as
+// an engine.Page, whose cursor resumes the scan at the byte offset the
+// page stopped at; any other query reruns and skips the rows earlier
+// pages returned, so it pages consistently only with an ORDER BY and
+// unchanged files. The server keeps no state between pages either way.
+func (cfg Config) planResumable(ctx context.Context, stmt statement) (op types.Operator, resume *resumable, cancel context.CancelFunc, err error) {
+	queryHash, _ := operators.HashValues(stmt.sql)
+	resume = &resumable{start: cursor{Query: queryHash}}
+	if stmt.cursor != "" {
+		if resume.start, err = decodeCursor(stmt.cursor); err != nil {
+			return nil, nil, nil, err
+		}
+		if resume.start.Query != queryHash {
+			return nil, nil, nil, fmt.Errorf("%w: it belongs to a different query", errBadCursor)
+		}
+	}
+
+	if stmt.maxRows > 0 && (resume.start.Page != "" || resume.start.Offset == 0) {
+		opts, cancel := cfg.queryOptions(ctx, stmt)
+		page, err := engine.PlanPage(stmt.sql, opts, resume.start.Page, int(stmt.maxRows))
+		if err == nil {
+			resume.page = page
+			return page, resume, cancel, nil
+		}
+		cancel()
+		if resume.start.Page != "" {
+			return nil, nil, nil, err
+		}
+		// Not a query that pages by byte offset: rerun it instead
+	}
+
+	op, cancel, err = cfg.plan(ctx, stmt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for skipped := int64(0); skipped < resume.start.Offset; skipped++ {
+		row, err := op.Next()
+		if err != nil || row == nil {
+			op.Close()
+			cancel()
+			if err == nil {
+				err = fmt.Errorf("%w: the query has fewer rows than it did", errBadCursor)
+			}
+			return nil, nil, nil, err
+		}
+		types.ReleaseRow(row)
+	}
+	return op, resume, cancel, nil
+}
+
+// next returns the cursor of the rows after the ones sent, or "" if there
+// are none; rows is how many were sent and truncated whether MaxRows cut
+// them off
+func (r *resumable) next(rows int64, truncated bool) (string, error) {
+	next := cursor{Query: r.start.Query}
+	if r.page != nil {
+		token, err := r.page.NextToken()
+		if err != nil || token == "" {
+			return "", err
+		}
+		next.Page = token
+	} else if truncated {
+		next.Offset = r.start.Offset + rows
+	} else {
+		return "", nil
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor parses a cursor made by next
+func decodeCursor(token string) (cursor, error) {
+	var decoded cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil || decoded.Offset < 0 {
+		return cursor{}, errBadCursor
+	}
+	return decoded, nil
+}
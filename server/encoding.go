@@ -0,0 +1,105 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentEncodings are the response encodings a server offers, preferred
+// first when a client accepts several equally
+var contentEncodings = []string{"zstd", "gzip"}
+
+// responseEncoding picks a response's Content-Encoding from its request's
+// Accept-Encoding: the supported one with the highest q-value, "" for
+// none. "*" stands for any encoding the header doesn't name.
+func responseEncoding(r *http.Request) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		weights[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range contentEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// encodedResponse compresses what a handler writes to w. Flushing it
+// flushes the compressor too, so streamed rows reach the client as they
+// are written.
+type encodedResponse struct {
+	http.ResponseWriter
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+// encodeResponse sets up a response's body to be compressed as its
+// request accepts, setting Content-Encoding; headers must still be
+// unsent. It returns the writer to write the body to and a function that
+// ends the compressed stream, to call before the handler returns. Without
+// an accepted encoding the body is written as it is.
+func encodeResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := responseEncoding(r)
+	if encoding == "" {
+		return w, func() error { return nil }
+	}
+	out := &encodedResponse{ResponseWriter: w}
+	switch encoding {
+	case "zstd":
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return w, func() error { return nil }
+		}
+		out.encoder = encoder
+	default:
+		out.encoder = gzip.NewWriter(w)
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Del("Content-Length")
+	return out, out.encoder.Close
+}
+
+func (out *encodedResponse) Write(p []byte) (int, error) {
+	return out.encoder.Write(p)
+}
+
+// FlushError sends what has been compressed so far, for
+// http.ResponseController
+func (out *encodedResponse) FlushError() error {
+	if err := out.encoder.Flush(); err != nil {
+		return err
+	}
+	return http.NewResponseController(out.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (out *encodedResponse) Unwrap() http.ResponseWriter {
+	return out.ResponseWriter
+}
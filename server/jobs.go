@@ -0,0 +1,555 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aryamaansaha/golap/operators"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DefaultJobTTL is how long a finished job's results are kept when
+// Config.JobTTL is 0
+const DefaultJobTTL = time.Hour
+
+const (
+	defaultPageRows = 1000                   // Rows per results page unless the request asks
+	maxPageRows     = 100000                 // Most rows a results page holds
+	jobIndexRows    = 1024                   // Rows between the offsets a job's results index
+	jobFlushEvery   = 200 * time.Millisecond // Longest a row waits before it can be fetched
+)
+
+// errJobCanceled is the cause of a job stopped by DELETE /jobs/{id} or by
+// the server closing
+var errJobCanceled = errors.New("job canceled")
+
+// job is a statement run in the background. Its rows are written as they
+// come to a temp file, one JSON array per line, which pages of results are
+// read from, so they can be fetched while it still runs.
+type job struct {
+	id            string
+	owner         string // ID of the principal who submitted it; "" if unauthenticated
+	sql           string
+	schema        types.Schema
+	estimatedRows int64 // The plan's estimate of its rows; -1 = unknown
+	submitted     time.Time
+	path          string // The results file
+	cancel        context.CancelCauseFunc
+	logger        *slog.Logger                     // Gets the job's outcome
+	audit         func(rec AuditRecord, err error) // Config.audit
+	record        AuditRecord                      // The audit record so far
+
+	mu        sync.Mutex
+	status    string  // queued, running, done or failed
+	err       error   // Why it failed
+	rows      int64   // Rows that can be fetched: written and flushed
+	size      int64   // Bytes of the file holding them
+	offsets   []int64 // Byte offset of every jobIndexRows-th row
+	truncated bool    // MaxRows cut it off
+	started   time.Time
+	finished  time.Time
+}
+
+// jobStatus is what GET /jobs/{id} returns
+type jobStatus struct {
+	ID            string      `json:"id"`
+	Status        string      `json:"status"` // queued, running, done or failed
+	SQL           string      `json:"sql"`
+	Columns       []jobColumn `json:"columns"`
+	Rows          int64       `json:"rows"`               // Produced so far, all fetchable
+	EstimatedRows int64       `json:"estimated_rows"`     // The plan's estimate; -1 = unknown
+	Progress      *float64    `json:"progress,omitempty"` // Rows / EstimatedRows, below 1 until done; absent without an estimate
+	Truncated     bool        `json:"truncated"`          // MaxRows cut the result off
+	Error         string      `json:"error,omitempty"`
+	Submitted     time.Time   `json:"submitted"`
+	Finished      *time.Time  `json:"finished,omitempty"`
+	ElapsedMs     int64       `json:"elapsed_ms"`
+}
+
+type jobColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// state returns the job's status as GET /jobs/{id} reports it
+func (j *job) state() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := jobStatus{
+		ID:            j.id,
+		Status:        j.status,
+		SQL:           j.sql,
+		Rows:          j.rows,
+		EstimatedRows: j.estimatedRows,
+		Truncated:     j.truncated,
+		Submitted:     j.submitted,
+	}
+	for i, name := range j.schema.Columns {
+		status.Columns = append(status.Columns, jobColumn{Name: name, Type: j.schema.Types[i].String()})
+	}
+	end := time.Now()
+	if !j.finished.IsZero() {
+		end = j.finished
+		status.Finished = &j.finished
+	}
+	status.ElapsedMs = end.Sub(j.submitted).Milliseconds()
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	if j.status == "done" {
+		progress := 1.0
+		status.Progress = &progress
+	} else if j.estimatedRows > 0 {
+		progress := min(float64(j.rows)/float64(j.estimatedRows), 0.99)
+		status.Progress = &progress
+	}
+	return status
+}
+
+// run reads the job's rows into its results file until the statement
+// ends, fails or is canceled, making them fetchable as it goes
+func (j *job) run(op types.Operator, file *os.File, maxRows int64) {
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	var buf bytes.Buffer
+	var rows, size int64
+	var offsets []int64
+	truncated := false
+	lastFlush := time.Now()
+	publish := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		j.mu.Lock()
+		j.rows, j.size, j.offsets = rows, size, offsets
+		j.mu.Unlock()
+		lastFlush = time.Now()
+		return nil
+	}
+
+	var err error
+	for {
+		var row *types.Row
+		if row, err = op.Next(); err != nil || row == nil {
+			break
+		}
+		if maxRows > 0 && rows == maxRows {
+			types.ReleaseRow(row)
+			truncated = true
+			break
+		}
+		if rows%jobIndexRows == 0 {
+			offsets = append(offsets, size)
+		}
+		buf.Reset()
+		if err = writeJSONArray(&buf, row.Values); err == nil {
+			_, err = w.Write(buf.Bytes())
+		}
+		types.ReleaseRow(row)
+		if err != nil {
+			break
+		}
+		rows++
+		size += int64(buf.Len())
+		if rows%jobIndexRows == 0 || time.Since(lastFlush) >= jobFlushEvery {
+			if err = publish(); err != nil {
+				break
+			}
+		}
+	}
+	if flushErr := publish(); err == nil {
+		err = flushErr
+	}
+	scanned := bytesRead(op)
+	if closeErr := op.Close(); err == nil {
+		err = closeErr
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status, j.err = "done", err
+	if err != nil {
+		j.status = "failed"
+	}
+	j.truncated = truncated
+	j.finished = time.Now()
+	if err != nil {
+		j.logger.Error("job failed", "job", j.id, "sql", j.sql, "rows", rows, "error", err)
+	} else {
+		j.logger.Info("job finished", "job", j.id, "sql", j.sql, "rows", rows, "truncated", truncated, "duration", j.finished.Sub(j.submitted))
+	}
+	rec := j.record
+	rec.WaitedMs = j.started.Sub(j.submitted).Milliseconds()
+	rec.DurationMs = j.finished.Sub(j.started).Milliseconds()
+	rec.Rows, rec.BytesRead, rec.Truncated = rows, scanned, truncated
+	j.audit(rec, err)
+}
+
+// fail ends a job that never ran
+func (j *job) fail(op types.Operator, file *os.File, err error) {
+	file.Close()
+	op.Close()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status, j.err = "failed", err
+	j.finished = time.Now()
+	j.logger.Error("job failed", "job", j.id, "sql", j.sql, "error", err)
+	rec := j.record
+	rec.WaitedMs = j.finished.Sub(j.submitted).Milliseconds()
+	if errors.Is(err, ErrQueueTimeout) {
+		rec.Status = "refused"
+	}
+	j.audit(rec, err)
+}
+
+// jobStore holds a server's jobs until they expire or are deleted
+type jobStore struct {
+	ttl     time.Duration
+	running sync.WaitGroup // Jobs' run goroutines
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	closed bool
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	if ttl <= 0 {
+		ttl = DefaultJobTTL
+	}
+	return &jobStore{ttl: ttl, jobs: make(map[string]*job)}
+}
+
+// start adds a job and runs it in the background once wait returns,
+// failing it if wait fails
+func (st *jobStore) start(j *job, op types.Operator, file *os.File, maxRows int64, wait func() error, done func()) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return errors.New("server is shutting down")
+	}
+	st.expire()
+	st.jobs[j.id] = j
+	st.running.Add(1)
+	go func() {
+		defer st.running.Done()
+		defer done()
+		if err := wait(); err != nil {
+			j.fail(op, file, err)
+			return
+		}
+		j.mu.Lock()
+		j.status, j.started = "running", time.Now()
+		j.mu.Unlock()
+		j.run(op, file, maxRows)
+	}()
+	return nil
+}
+
+// get returns a job if it exists and belongs to owner
+func (st *jobStore) get(id, owner string) (*job, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expire()
+	j, ok := st.jobs[id]
+	if !ok || j.owner != owner {
+		return nil, false
+	}
+	return j, true
+}
+
+// remove cancels a job if it still runs and deletes its results
+func (st *jobStore) remove(j *job) {
+	st.mu.Lock()
+	delete(st.jobs, j.id)
+	st.mu.Unlock()
+	j.cancel(errJobCanceled)
+	os.Remove(j.path)
+}
+
+// expire removes the jobs that finished more than the TTL ago; st.mu must
+// be held
+func (st *jobStore) expire() {
+	now := time.Now()
+	for id, j := range st.jobs {
+		j.mu.Lock()
+		expired := !j.finished.IsZero() && now.Sub(j.finished) > st.ttl
+		j.mu.Unlock()
+		if expired {
+			delete(st.jobs, id)
+			os.Remove(j.path)
+		}
+	}
+}
+
+// close cancels every running job, waits for them to stop and removes
+// all results
+func (st *jobStore) close() error {
+	st.mu.Lock()
+	st.closed = true
+	jobs := st.jobs
+	st.jobs = make(map[string]*job)
+	st.mu.Unlock()
+
+	for _, j := range jobs {
+		j.cancel(errJobCanceled)
+	}
+	st.running.Wait()
+	var errs []error
+	for _, j := range jobs {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serveSubmit plans a statement and starts it as a job, answering 202
+// with its status; it fails as /query does if the statement doesn't plan
+func (s *Server) serveSubmit(w http.ResponseWriter, r *http.Request) {
+	stmt, ok := s.cfg.parseStatement(w, r, "job")
+	if !ok {
+		return
+	}
+	if stmt.cursor != "" {
+		http.Error(w, "jobs don't take a cursor; page their results with offset", http.StatusBadRequest)
+		return
+	}
+	// The job outlives the request, but keeps its principal
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+	rec := auditRecord(r, "job", stmt.sql, stmt.received)
+	op, stop, err := s.cfg.plan(ctx, stmt)
+	if err != nil {
+		cancel(nil)
+		s.cfg.audit(rec, err)
+		http.Error(w, err.Error(), errorStatus(err, http.StatusBadRequest))
+		return
+	}
+	sess := &session{id: newJobID(), kind: "job", owner: ownerOf(r), sql: stmt.sql, cancel: cancel}
+	rec.Session = sess.id
+	if err := s.sessions.open(sess); err != nil {
+		op.Close()
+		stop()
+		cancel(nil)
+		s.refuse(w, sess, rec, err)
+		return
+	}
+	status := "running"
+	if !sess.running() {
+		status = "queued"
+	}
+	done := func() {
+		s.sessions.close(sess)
+		stop()
+		cancel(nil)
+	}
+
+	file, err := os.CreateTemp(s.cfg.tempDir(), "golap-job-*.jsonl")
+	if err != nil {
+		op.Close()
+		done()
+		err = fmt.Errorf("cannot store job results: %w", err)
+		s.cfg.audit(rec, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j := &job{
+		id:            sess.id,
+		owner:         ownerOf(r),
+		sql:           stmt.sql,
+		schema:        op.Schema(),
+		estimatedRows: operators.ExplainOperator(op).EstimatedRows,
+		submitted:     time.Now(),
+		path:          file.Name(),
+		cancel:        cancel,
+		status:        status,
+		logger:        s.cfg.logger(),
+		audit:         s.cfg.audit,
+		record:        rec,
+	}
+	wait := func() error { return s.sessions.wait(ctx, sess) }
+	if err := s.jobs.start(j, op, file, stmt.maxRows, wait, done); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		op.Close()
+		done()
+		s.cfg.audit(rec, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, j.state())
+}
+
+// serveJob reports a job's status and progress
+func (s *Server) serveJob(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookupJob(w, r); ok {
+		writeJSON(w, http.StatusOK, j.state())
+	}
+}
+
+// serveCancel stops a job if it still runs and deletes its results
+func (s *Server) serveCancel(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookupJob(w, r); ok {
+		s.jobs.remove(j)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveResults returns a page of a job's rows, ?offset=N&limit=M (the
+// first 1000 by default), in the formats and encodings /query streams. Rows can be
+// fetched while the job runs. Golap-Rows says how many the page holds and
+// Golap-Job-Status how the job stands; Golap-Next-Offset, where the next
+// page starts, is absent once the page holds the last row of a finished
+// job.
+func (s *Server) serveResults(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookupJob(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	format, err := responseFormat(r, params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := pageParam(params.Get("offset"), 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid offset: %v", err), http.StatusBadRequest)
+		return
+	}
+	limit, err := pageParam(params.Get("limit"), defaultPageRows)
+	if err != nil || limit == 0 {
+		http.Error(w, fmt.Sprintf("invalid limit: %s", params.Get("limit")), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxPageRows)
+
+	j.mu.Lock()
+	rows, size, offsets, status := j.rows, j.size, j.offsets, j.status
+	j.mu.Unlock()
+	file, err := os.Open(j.path)
+	if err != nil {
+		http.Error(w, "job not found", http.StatusNotFound) // Deleted meanwhile
+		return
+	}
+	defer file.Close()
+
+	start := min(offset, rows)
+	n := min(limit, rows-start)
+	var reader *bufio.Reader
+	if n > 0 {
+		indexed := offsets[start/jobIndexRows]
+		reader = bufio.NewReader(io.NewSectionReader(file, indexed, size-indexed))
+		for range start % jobIndexRows {
+			if _, err := reader.ReadBytes('\n'); err != nil {
+				http.Error(w, fmt.Sprintf("cannot read job results: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w, finish := encodeResponse(w, r)
+	defer finish()
+	out := newRowWriter(w, format, j.schema)
+	w.Header().Set("Content-Type", out.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Golap-Rows", strconv.FormatInt(n, 10))
+	w.Header().Set("Golap-Job-Status", status)
+	if start+n < rows || status == "running" || status == "queued" {
+		w.Header().Set("Golap-Next-Offset", strconv.FormatInt(start+n, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	for range n {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return // Can't change the status now; the page ends short
+		}
+		var values []interface{}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if decoder.Decode(&values) != nil || out.write(values) != nil {
+			return
+		}
+	}
+	out.flush()
+}
+
+// lookupJob returns the job a request names, answering 404 itself if
+// there is none (or it's another principal's)
+func (s *Server) lookupJob(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	j, ok := s.jobs.get(r.PathValue("id"), ownerOf(r))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+	}
+	return j, ok
+}
+
+// ownerOf returns the ID of the principal making a request, "" if the
+// server doesn't authenticate
+func ownerOf(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return principal.ID
+	}
+	return ""
+}
+
+// tempDir returns where job results are stored: with the spill files
+func (cfg Config) tempDir() string {
+	if cfg.Options.TempDir != "" {
+		return cfg.Options.TempDir
+	}
+	return os.Getenv(operators.TempDirEnv) // "" is the system temp directory
+}
+
+// pageParam parses a non-negative offset or limit, def if absent
+func pageParam(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%d is negative", n)
+	}
+	return n, err
+}
+
+func newJobID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// writeJSON answers a request with a JSON document
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONArray encodes a row as a JSON array and a newline
+func writeJSONArray(buf *bytes.Buffer, values []interface{}) error {
+	buf.WriteByte('[')
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSONValue(buf, v); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("]\n")
+	return nil
+}
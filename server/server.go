@@ -0,0 +1,532 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryamaansaha/golap/engine"
+	"github.com/aryamaansaha/golap/types"
+)
+
+// DefaultMaxQueryBytes is the largest request body a server reads when
+// Config.MaxQueryBytes is 0
+const DefaultMaxQueryBytes = 1 << 20
+
+// flushRows is how many rows a response streams between flushes
+const flushRows = 1000
+
+// ErrQueryTimeout is the cause of a query stopped by its time limit
+var ErrQueryTimeout = errors.New("query timed out")
+
+// Config configures a query server (see New)
+type Config struct {
+	// Options plans every query; Context and Authorize are set per request
+	Options engine.Options
+
+	// Hooks authenticate requests and authorize the sources queries read
+	Hooks Hooks
+
+	// MaxRows caps the rows one query returns; the rest are cut off and
+	// the response says so. 0 means no cap. A request may ask for fewer.
+	MaxRows int64
+
+	// Timeout stops a query that runs longer; 0 means no limit. A request
+	// may ask for less.
+	Timeout time.Duration
+
+	// MaxQueryBytes caps a request's body (0 uses DefaultMaxQueryBytes)
+	MaxQueryBytes int64
+
+	// JobTTL is how long a finished job's results are kept for fetching
+	// (0 uses DefaultJobTTL)
+	JobTTL time.Duration
+
+	// MaxConcurrentQueries caps the queries and jobs running at once;
+	// others wait for one to end. 0 means no cap.
+	MaxConcurrentQueries int
+
+	// MaxQueuedQueries caps the queries and jobs waiting to run; past it
+	// requests get 503. 0 means no cap.
+	MaxQueuedQueries int
+
+	// QueueTimeout is how long a query or job waits to run before it
+	// fails with ErrQueueTimeout (503 for a query); 0 waits as long as the
+	// request lasts, and a job until it is canceled
+	QueueTimeout time.Duration
+
+	// QueryMemory caps the memory each sort and GROUP BY of a query holds
+	// before spilling to temp files, lowering Options.SortMemoryBytes and
+	// AggregateMemoryBytes; 0 keeps them
+	QueryMemory int64
+
+	// AllowWrites lets requests run statements that write files or the
+	// catalog (COPY, CREATE, DROP, ANALYZE); without it only statements
+	// engine.ReadOnly accepts run
+	AllowWrites bool
+
+	// Audit, if set, gets a record of every statement sent to /query or
+	// /jobs once it ends: who sent it and when, how long it ran, its rows
+	// and bytes scanned, and why it failed or was refused
+	Audit *AuditLog
+}
+
+// queryRequest is a POST /query body sent as JSON; the same fields can
+// be query parameters of a request whose body is the SQL itself
+type queryRequest struct {
+	SQL     string `json:"sql"`
+	Format  string `json:"format"`   // jsonl (the default) or csv
+	MaxRows int64  `json:"max_rows"` // At most Config.MaxRows
+	Timeout string `json:"timeout"`  // At most Config.Timeout, e.g. 10s
+	Cursor  string `json:"cursor"`   // /query only: Golap-Next-Cursor of the previous page
+}
+
+// Server is a query server: an http.Handler answering queries sent as
+// HTTP requests, each statement planned and run with the server's Config
+type Server struct {
+	cfg      Config
+	handler  http.Handler
+	jobs     *jobStore
+	sessions *sessionStore
+}
+
+// New returns a query server. POST /query runs one statement, sent as the
+// request body (or as {"sql": ...} JSON), and streams its rows back as
+// JSON lines (one object per row, keyed by column) or, with format=csv or
+// "Accept: text/csv", as CSV with a header, compressed with zstd or gzip
+// if the request's Accept-Encoding allows (see encoding.go). Once rows are streaming the
+// status can't change, so trailers report how it ended: Golap-Rows (rows
+// sent), Golap-Truncated ("true" if MaxRows cut it off), Golap-Next-Cursor
+// (where the rest starts, see planResumable) and Golap-Error (why it
+// failed, if it did). Statements that fail before their first row
+// get an error status instead: 400 for ones that don't plan, 403 for
+// writes the server doesn't allow, 504 for timeouts and 500 for other
+// failures.
+//
+// Statements that run longer than a request may last run as jobs: POST
+// /jobs starts one in the background, GET /jobs/{id} reports its status
+// and progress, GET /jobs/{id}/results returns a page of its rows and
+// DELETE /jobs/{id} cancels it (see jobs.go). Close stops them.
+//
+// Queries and jobs are admitted as sessions (see sessions.go): at most
+// MaxConcurrentQueries run at once and the rest wait their turn, up to
+// MaxQueuedQueries of them for up to QueueTimeout each, after which
+// requests get 503. GET /sessions lists the caller's and DELETE
+// /sessions/{id} cancels one.
+func New(cfg Config) *Server {
+	cfg.Options = cfg.capMemory()
+	s := &Server{cfg: cfg, jobs: newJobStore(cfg.JobTTL), sessions: newSessionStore(cfg)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /query", s.serveQuery)
+	mux.HandleFunc("POST /jobs", s.serveSubmit)
+	mux.HandleFunc("GET /jobs/{id}", s.serveJob)
+	mux.HandleFunc("GET /jobs/{id}/results", s.serveResults)
+	mux.HandleFunc("DELETE /jobs/{id}", s.serveCancel)
+	mux.HandleFunc("GET /sessions", s.serveSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.serveCancelSession)
+	s.handler = cfg.Hooks.Middleware(mux)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close stops the jobs still running and removes every job's results;
+// requests for them get 404 from then on
+func (s *Server) Close() error {
+	return s.jobs.close()
+}
+
+// statement is a request's statement, checked and with its limits
+type statement struct {
+	sql      string
+	format   string
+	maxRows  int64
+	timeout  time.Duration
+	cursor   string
+	received time.Time
+}
+
+// parseStatement reads the statement of a /query or /jobs request (kind
+// query or job), answering the request itself (and returning false) if it
+// can't be run
+func (cfg Config) parseStatement(w http.ResponseWriter, r *http.Request, kind string) (statement, bool) {
+	received := time.Now()
+	req, err := cfg.parseRequest(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("query larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return statement{}, false
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return statement{}, false
+	}
+	statements, err := engine.SplitStatements(req.SQL)
+	if err == nil && len(statements) != 1 {
+		err = fmt.Errorf("expected one statement, got %d", len(statements))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return statement{}, false
+	}
+	if !cfg.AllowWrites && !engine.ReadOnly(statements[0]) {
+		err := errors.New("this server only runs statements that read (SELECT, EXPLAIN, DESCRIBE, SHOW)")
+		rec := auditRecord(r, kind, statements[0], received)
+		rec.Status = "refused"
+		cfg.audit(rec, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return statement{}, false
+	}
+	maxRows, timeout, err := cfg.limits(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return statement{}, false
+	}
+	return statement{sql: statements[0], format: req.Format, maxRows: maxRows, timeout: timeout, cursor: req.Cursor, received: received}, true
+}
+
+// plan plans a statement to run within ctx and its time limit; cancel
+// releases the limit's timer
+func (cfg Config) plan(ctx context.Context, stmt statement) (op types.Operator, cancel context.CancelFunc, err error) {
+	opts, cancel := cfg.queryOptions(ctx, stmt)
+	op, err = engine.ParseAndPlanWithOptions(stmt.sql, opts)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return op, cancel, nil
+}
+
+// queryOptions returns the options a statement plans with, its Context
+// ending at its time limit; cancel releases the limit's timer
+func (cfg Config) queryOptions(ctx context.Context, stmt statement) (opts engine.Options, cancel context.CancelFunc) {
+	cancel = func() {}
+	if stmt.timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, stmt.timeout, fmt.Errorf("%w after %s", ErrQueryTimeout, stmt.timeout))
+	}
+	return cfg.Hooks.QueryOptions(ctx, cfg.Options), cancel
+}
+
+// capMemory returns Options with the sort and GROUP BY memory lowered
+// to QueryMemory
+func (cfg Config) capMemory() engine.Options {
+	opts := cfg.Options
+	if cfg.QueryMemory <= 0 {
+		return opts
+	}
+	if opts.SortMemoryBytes <= 0 || opts.SortMemoryBytes > cfg.QueryMemory {
+		opts.SortMemoryBytes = cfg.QueryMemory
+	}
+	opts.SortChunkSize = 0 // Counts rows, which the cap can't bound
+	if opts.AggregateMemoryBytes <= 0 || opts.AggregateMemoryBytes > cfg.QueryMemory {
+		opts.AggregateMemoryBytes = cfg.QueryMemory
+	}
+	return opts
+}
+
+// logger returns where the server logs queries: Options.Logger, or nowhere
+func (cfg Config) logger() *slog.Logger {
+	if cfg.Options.Logger != nil {
+		return cfg.Options.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+func (s *Server) serveQuery(w http.ResponseWriter, r *http.Request) {
+	stmt, ok := s.cfg.parseStatement(w, r, "query")
+	if !ok {
+		return
+	}
+	ctx, cancelSession := context.WithCancelCause(r.Context())
+	defer cancelSession(nil)
+	sess := &session{id: newJobID(), kind: "query", owner: ownerOf(r), sql: stmt.sql, cancel: cancelSession}
+	w.Header().Set("Golap-Session", sess.id)
+	rec := auditRecord(r, "query", stmt.sql, stmt.received)
+	rec.Session = sess.id
+	if !s.admit(ctx, w, sess, rec) {
+		return
+	}
+	defer s.sessions.close(sess)
+
+	start := time.Now()
+	rec.WaitedMs = sess.status().WaitedMs
+	op, resume, cancel, err := s.cfg.planResumable(ctx, stmt)
+	if err != nil {
+		status := errorStatus(err, http.StatusBadRequest)
+		s.cfg.logger().Error("query failed", "sql", stmt.sql, "status", status, "error", err)
+		rec.DurationMs = time.Since(start).Milliseconds()
+		s.cfg.audit(rec, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer cancel()
+	defer op.Close()
+
+	// The first row decides the status: an error before it is the query's
+	row, err := op.Next()
+	if err != nil {
+		status := errorStatus(err, http.StatusInternalServerError)
+		s.cfg.logger().Error("query failed", "sql", stmt.sql, "status", status, "error", err)
+		rec.DurationMs, rec.BytesRead = time.Since(start).Milliseconds(), bytesRead(op)
+		s.cfg.audit(rec, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w, finish := encodeResponse(w, r)
+	out := newRowWriter(w, stmt.format, op.Schema())
+	w.Header().Set("Trailer", "Golap-Rows, Golap-Truncated, Golap-Next-Cursor, Golap-Error")
+	w.Header().Set("Content-Type", out.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+
+	var rows int64
+	truncated := false
+	for ; row != nil; row, err = op.Next() {
+		if stmt.maxRows > 0 && rows == stmt.maxRows {
+			types.ReleaseRow(row)
+			truncated = true
+			break
+		}
+		if err = out.write(row.Values); err != nil {
+			break
+		}
+		types.ReleaseRow(row)
+		rows++
+		if rows%flushRows == 0 {
+			out.flush()
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = out.flush()
+	}
+	if finishErr := finish(); err == nil {
+		err = finishErr
+	}
+	var next string
+	if err == nil {
+		next, err = resume.next(rows, truncated)
+		truncated = truncated || next != ""
+	}
+	w.Header().Set("Golap-Rows", strconv.FormatInt(rows, 10))
+	w.Header().Set("Golap-Truncated", strconv.FormatBool(truncated))
+	if next != "" {
+		w.Header().Set("Golap-Next-Cursor", next)
+	}
+	rec.DurationMs, rec.BytesRead = time.Since(start).Milliseconds(), bytesRead(op)
+	rec.Rows, rec.Truncated = rows, truncated
+	s.cfg.audit(rec, err)
+	if err != nil {
+		w.Header().Set("Golap-Error", err.Error())
+		s.cfg.logger().Error("query failed", "sql", stmt.sql, "rows", rows, "error", err)
+		return
+	}
+	s.cfg.logger().Info("query finished", "sql", stmt.sql, "rows", rows, "truncated", truncated, "duration", time.Since(start))
+}
+
+// parseRequest reads a request's statement and options: a JSON body, or
+// the SQL as the body with the options as query parameters
+func (cfg Config) parseRequest(w http.ResponseWriter, r *http.Request) (queryRequest, error) {
+	limit := cfg.MaxQueryBytes
+	if limit <= 0 {
+		limit = DefaultMaxQueryBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return queryRequest{}, err
+	}
+
+	var req queryRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			return queryRequest{}, fmt.Errorf("invalid request: %w", err)
+		}
+	} else {
+		params := r.URL.Query()
+		req.SQL = string(body)
+		req.Format = params.Get("format")
+		req.Timeout = params.Get("timeout")
+		req.Cursor = params.Get("cursor")
+		if maxRows := params.Get("max_rows"); maxRows != "" {
+			if req.MaxRows, err = strconv.ParseInt(maxRows, 10, 64); err != nil {
+				return queryRequest{}, fmt.Errorf("invalid max_rows: %s", maxRows)
+			}
+		}
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		return queryRequest{}, errors.New("no SQL statement in the request")
+	}
+
+	if req.Format, err = responseFormat(r, req.Format); err != nil {
+		return queryRequest{}, err
+	}
+	return req, nil
+}
+
+// responseFormat returns the format rows are sent in: the one asked for,
+// else CSV if the request accepts text/csv, else JSON lines
+func responseFormat(r *http.Request, format string) (string, error) {
+	if format == "" {
+		if accept, _, _ := mime.ParseMediaType(r.Header.Get("Accept")); accept == "text/csv" {
+			return "csv", nil
+		}
+	}
+	switch strings.ToLower(format) {
+	case "", "jsonl", "ndjson", "json":
+		return "jsonl", nil
+	case "csv":
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("unknown format %q (use jsonl or csv)", format)
+	}
+}
+
+// limits returns a request's row and time limits: what it asked for,
+// capped at the server's
+func (cfg Config) limits(req queryRequest) (int64, time.Duration, error) {
+	maxRows := cfg.MaxRows
+	if req.MaxRows < 0 {
+		return 0, 0, fmt.Errorf("invalid max_rows: %d", req.MaxRows)
+	}
+	if req.MaxRows > 0 && (maxRows == 0 || req.MaxRows < maxRows) {
+		maxRows = req.MaxRows
+	}
+	timeout := cfg.Timeout
+	if req.Timeout != "" {
+		asked, err := time.ParseDuration(req.Timeout)
+		if err != nil || asked <= 0 {
+			return 0, 0, fmt.Errorf("invalid timeout: %s", req.Timeout)
+		}
+		if timeout == 0 || asked < timeout {
+			timeout = asked
+		}
+	}
+	return maxRows, timeout, nil
+}
+
+// errorStatus returns the HTTP status for a query that failed with err
+// before its first row
+func errorStatus(err error, status int) int {
+	if errors.Is(err, ErrQueryTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, engine.ErrPathNotAllowed) {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+// rowWriter encodes rows in a response format
+type rowWriter struct {
+	contentType string
+	columns     [][]byte // JSON lines: each column's quoted name and ':'
+	json        *bytes.Buffer
+	w           io.Writer
+	csv         *csv.Writer
+	fields      []string
+}
+
+func newRowWriter(w io.Writer, format string, schema types.Schema) *rowWriter {
+	if format == "csv" {
+		out := &rowWriter{contentType: "text/csv; charset=utf-8", csv: csv.NewWriter(w), fields: make([]string, len(schema.Columns))}
+		out.csv.Write(schema.Columns)
+		return out
+	}
+	out := &rowWriter{contentType: "application/x-ndjson", json: &bytes.Buffer{}, w: w}
+	for _, col := range schema.Columns {
+		name, _ := json.Marshal(col)
+		out.columns = append(out.columns, append(name, ':'))
+	}
+	return out
+}
+
+// write encodes one row
+func (out *rowWriter) write(values []interface{}) error {
+	if out.csv != nil {
+		for i, v := range values {
+			out.fields[i] = formatCSV(v)
+		}
+		return out.csv.Write(out.fields)
+	}
+	buf := out.json
+	buf.Reset()
+	buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(out.columns[i])
+		if err := writeJSONValue(buf, v); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := out.w.Write(buf.Bytes())
+	return err
+}
+
+// flush writes out rows the encoder buffers
+func (out *rowWriter) flush() error {
+	if out.csv != nil {
+		out.csv.Flush()
+		return out.csv.Error()
+	}
+	return nil
+}
+
+// formatCSV formats a value as a CSV field; NULL is an empty field
+func formatCSV(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeJSONValue encodes a value as JSON; NaN and infinities, which JSON
+// can't represent, become null like NULL does
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			buf.WriteString("null")
+		} else {
+			buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
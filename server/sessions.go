@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrServerBusy is the cause of a query turned away because
+// Config.MaxQueuedQueries queries already wait to run
+var ErrServerBusy = errors.New("server busy: too many queries waiting to run")
+
+// ErrQueueTimeout is the cause of a query that waited Config.QueueTimeout
+// without starting
+var ErrQueueTimeout = errors.New("query waited too long to run")
+
+// errSessionCanceled is the cause of a query stopped by DELETE
+// /sessions/{id}
+var errSessionCanceled = errors.New("query canceled")
+
+// session is a /query request or job from the moment it is admitted
+// until it ends: queued while it waits for one of the
+// Config.MaxConcurrentQueries slots, then running
+type session struct {
+	id     string
+	kind   string // query or job
+	owner  string // ID of the principal who sent it; "" if unauthenticated
+	sql    string
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	state   string // queued or running
+	queued  time.Time
+	started time.Time
+}
+
+// sessionStatus is how GET /sessions lists a session
+type sessionStatus struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`  // query or job
+	State    string     `json:"state"` // queued or running
+	SQL      string     `json:"sql"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	WaitedMs int64      `json:"waited_ms"` // In the queue
+}
+
+// status returns the session as GET /sessions lists it
+func (sess *session) status() sessionStatus {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	status := sessionStatus{ID: sess.id, Kind: sess.kind, State: sess.state, SQL: sess.sql, Queued: sess.queued}
+	waitEnd := time.Now()
+	if !sess.started.IsZero() {
+		status.Started = &sess.started
+		waitEnd = sess.started
+	}
+	status.WaitedMs = waitEnd.Sub(sess.queued).Milliseconds()
+	return status
+}
+
+// running reports whether the session holds a slot
+func (sess *session) running() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.state == "running"
+}
+
+// start marks the session as holding a slot
+func (sess *session) start() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.state, sess.started = "running", time.Now()
+}
+
+// sessionStore admits queries: at most cap(slots) run at once, and at most
+// maxQueued wait for a slot, each for at most queueTimeout
+type sessionStore struct {
+	slots        chan struct{} // A token per running session; nil = no cap
+	maxQueued    int           // 0 = no cap
+	queueTimeout time.Duration // 0 = wait as long as the request does
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	waiting  int
+}
+
+func newSessionStore(cfg Config) *sessionStore {
+	ss := &sessionStore{
+		maxQueued:    cfg.MaxQueuedQueries,
+		queueTimeout: cfg.QueueTimeout,
+		sessions:     make(map[string]*session),
+	}
+	if cfg.MaxConcurrentQueries > 0 {
+		ss.slots = make(chan struct{}, cfg.MaxConcurrentQueries)
+	}
+	return ss
+}
+
+// open admits a session: it takes a free slot if none are waiting for
+// one, or else a place in the queue, failing with ErrServerBusy if the
+// queue is full. The caller must close it.
+func (ss *sessionStore) open(sess *session) error {
+	sess.queued = time.Now()
+	sess.state = "queued"
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	started := ss.slots == nil
+	if !started && ss.waiting == 0 {
+		select {
+		case ss.slots <- struct{}{}:
+			started = true
+		default:
+		}
+	}
+	if started {
+		sess.start()
+	} else {
+		// Waiters get slots in turn: blocked channel sends are served
+		// first come, first served
+		if ss.maxQueued > 0 && ss.waiting >= ss.maxQueued {
+			return ErrServerBusy
+		}
+		ss.waiting++
+	}
+	ss.sessions[sess.id] = sess
+	return nil
+}
+
+// wait blocks until a queued session gets a slot, failing with
+// ErrQueueTimeout after the queue timeout, or if ctx ends first
+func (ss *sessionStore) wait(ctx context.Context, sess *session) error {
+	if sess.running() {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if ss.queueTimeout > 0 {
+		timer := time.NewTimer(ss.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ss.slots <- struct{}{}:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timeout:
+		return ErrQueueTimeout
+	}
+	ss.mu.Lock()
+	ss.waiting--
+	ss.mu.Unlock()
+	sess.start()
+	return nil
+}
+
+// close frees a session's slot or place in the queue and forgets it
+func (ss *sessionStore) close(sess *session) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if _, ok := ss.sessions[sess.id]; !ok {
+		return
+	}
+	delete(ss.sessions, sess.id)
+	if !sess.running() {
+		ss.waiting--
+	} else if ss.slots != nil {
+		<-ss.slots
+	}
+}
+
+// list returns owner's sessions, oldest first
+func (ss *sessionStore) list(owner string) []sessionStatus {
+	ss.mu.Lock()
+	var sessions []*session
+	for _, sess := range ss.sessions {
+		if sess.owner == owner {
+			sessions = append(sessions, sess)
+		}
+	}
+	ss.mu.Unlock()
+	statuses := make([]sessionStatus, len(sessions))
+	for i, sess := range sessions {
+		statuses[i] = sess.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Queued.Before(statuses[j].Queued) })
+	return statuses
+}
+
+// get returns a session if it exists and belongs to owner
+func (ss *sessionStore) get(id, owner string) (*session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sess, ok := ss.sessions[id]
+	if !ok || sess.owner != owner {
+		return nil, false
+	}
+	return sess, true
+}
+
+// admit opens a session for a /query statement and waits within ctx for
+// it to run, answering the request itself (and returning false) if it
+// can't: 503 with Retry-After if the queue is full or the wait timed out.
+// The caller must close an admitted session.
+func (s *Server) admit(ctx context.Context, w http.ResponseWriter, sess *session, rec AuditRecord) bool {
+	if err := s.sessions.open(sess); err != nil {
+		s.refuse(w, sess, rec, err)
+		return false
+	}
+	if err := s.sessions.wait(ctx, sess); err != nil {
+		rec.WaitedMs = sess.status().WaitedMs
+		s.sessions.close(sess)
+		s.refuse(w, sess, rec, err)
+		return false
+	}
+	return true
+}
+
+// refuse answers a request whose statement wasn't admitted, auditing it
+// as rec
+func (s *Server) refuse(w http.ResponseWriter, sess *session, rec AuditRecord, err error) {
+	s.cfg.logger().Warn("query not admitted", "sql", sess.sql, "error", err)
+	rec.Status = "refused"
+	s.cfg.audit(rec, err)
+	if errors.Is(err, ErrServerBusy) || errors.Is(err, ErrQueueTimeout) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), errorStatus(err, http.StatusServiceUnavailable))
+}
+
+// serveSessions lists the caller's queries and jobs, queued or running
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := s.sessions.list(ownerOf(r))
+	if sessions == nil {
+		sessions = []sessionStatus{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// serveCancelSession stops one of the caller's queries or jobs, queued or
+// running; a canceled job keeps its rows so far until it expires
+func (s *Server) serveCancelSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.sessions.get(r.PathValue("id"), ownerOf(r))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	sess.cancel(errSessionCanceled)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// azureBackend reads az://container/blob objects from Azure Blob Storage
+// The account and credentials come from AZURE_STORAGE_CONNECTION_STRING,
+// or AZURE_STORAGE_ACCOUNT with AZURE_STORAGE_KEY (Shared Key) or
+// AZURE_STORAGE_SAS_TOKEN; without a key or SAS token, requests are
+// anonymous (public containers).
+type azureBackend struct{}
+
+// azureConfig is the account, its blob endpoint and credentials
+type azureConfig struct {
+	account  string
+	key      *blob.SharedKeyCredential // Account key, for Shared Key signing
+	sas      string                    // SAS token query string, without the leading ?
+	endpoint string                    // e.g. https://account.blob.core.windows.net
+}
+
+// loadAzureConfig reads the account settings from the environment; a
+// connection string's fields win over the separate variables
+func loadAzureConfig() (azureConfig, error) {
+	cfg := azureConfig{
+		account: os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		sas:     strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+	}
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	protocol, suffix := "https", "core.windows.net"
+	if conn := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); conn != "" {
+		for _, part := range strings.Split(conn, ";") {
+			name, value, _ := strings.Cut(part, "=")
+			switch strings.TrimSpace(name) {
+			case "AccountName":
+				cfg.account = value
+			case "AccountKey":
+				key = value
+			case "SharedAccessSignature":
+				cfg.sas = strings.TrimPrefix(value, "?")
+			case "BlobEndpoint":
+				cfg.endpoint = strings.TrimSuffix(value, "/")
+			case "DefaultEndpointsProtocol":
+				protocol = value
+			case "EndpointSuffix":
+				suffix = value
+			}
+		}
+	}
+	if cfg.account == "" {
+		return cfg, fmt.Errorf("set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING to read az:// paths")
+	}
+	if key != "" && cfg.sas == "" {
+		credential, err := blob.NewSharedKeyCredential(cfg.account, key)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid Azure storage account key: %w", err)
+		}
+		cfg.key = credential
+	}
+	if cfg.endpoint == "" {
+		cfg.endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, cfg.account, suffix)
+	}
+	return cfg, nil
+}
+
+// parseAzurePath splits az://container/blob
+func parseAzurePath(path string) (container, blob string, err error) {
+	rest := path[len("az://"):]
+	container, blob, _ = strings.Cut(rest, "/")
+	if container == "" || blob == "" {
+		return "", "", fmt.Errorf("invalid Azure path %q: expected az://container/blob", path)
+	}
+	return container, blob, nil
+}
+
+// newAzureClient returns a client for a blob, authorized by Shared Key, a
+// SAS token or neither
+func (cfg azureConfig) newAzureClient(path string) (*blob.Client, error) {
+	container, name, err := parseAzurePath(path)
+	if err != nil {
+		return nil, err
+	}
+	target := cfg.endpoint + "/" + container + "/" + encodeObjectPath(name)
+	if cfg.key != nil {
+		return blob.NewClientWithSharedKeyCredential(target, cfg.key, nil)
+	}
+	if cfg.sas != "" {
+		target += "?" + cfg.sas
+	}
+	return blob.NewClientWithNoCredential(target, nil)
+}
+
+// azureError describes a failed request like objectError does
+func azureError(path string, err error, cfg azureConfig) error {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return fmt.Errorf("%s: %w", path, redactSAS(err, cfg))
+	}
+	switch {
+	case respErr.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	case respErr.StatusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("%s changed while being read", path)
+	case respErr.ErrorCode != "":
+		return fmt.Errorf("%s: Azure %s", path, respErr.ErrorCode)
+	}
+	return fmt.Errorf("%s: Azure request failed: %d %s", path, respErr.StatusCode, http.StatusText(respErr.StatusCode))
+}
+
+// headAzure fetches a blob's size, modification time and ETag
+func headAzure(path string, client *blob.Client, cfg azureConfig) (Info, string, error) {
+	props, err := client.GetProperties(context.Background(), nil)
+	if err != nil {
+		return Info{}, "", azureError(path, err, cfg)
+	}
+	var info Info
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.ModTime = *props.LastModified
+	}
+	var etag string
+	if props.ETag != nil {
+		etag = string(*props.ETag)
+	}
+	return info, etag, nil
+}
+
+// redactSAS strips the SAS token from a request error, which quotes the URL
+func redactSAS(err error, cfg azureConfig) error {
+	if urlErr, ok := err.(*url.Error); ok && cfg.sas != "" {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// Stat returns a blob's size and modification time
+func (azureBackend) Stat(path string) (Info, error) {
+	cfg, err := loadAzureConfig()
+	if err != nil {
+		return Info{}, err
+	}
+	client, err := cfg.newAzureClient(path)
+	if err != nil {
+		return Info{}, err
+	}
+	info, _, err := headAzure(path, client, cfg)
+	return info, err
+}
+
+// Open opens a blob for reading; nothing is downloaded until Read. Every
+// range is pinned to the ETag seen at open.
+func (azureBackend) Open(path string) (File, error) {
+	cfg, err := loadAzureConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := cfg.newAzureClient(path)
+	if err != nil {
+		return nil, err
+	}
+	info, etag, err := headAzure(path, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newRangedFile(path, info.Size, func(start, end int64) (io.ReadCloser, error) {
+		options := &blob.DownloadStreamOptions{
+			Range: blob.HTTPRange{Offset: start, Count: end - start + 1},
+		}
+		if etag != "" {
+			match := azcore.ETag(etag)
+			options.AccessConditions = &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: &match},
+			}
+		}
+		resp, err := client.DownloadStream(context.Background(), options)
+		if err != nil {
+			return nil, azureError(path, err, cfg)
+		}
+		return resp.Body, nil
+	}), nil
+}
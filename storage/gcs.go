@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsReadScope is the OAuth scope requested for Google credentials
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsBackend reads gs://bucket/object objects through the Cloud Storage
+// XML API. Credentials come from GOOGLE_OAUTH_ACCESS_TOKEN (a ready
+// bearer token) or GOOGLE_APPLICATION_CREDENTIALS (a service account or
+// gcloud user key file); without either, requests are anonymous (public
+// buckets). STORAGE_EMULATOR_HOST points at an emulator instead.
+type gcsBackend struct{}
+
+// gcsTokens caches the token source for a key file, which refreshes its
+// token shortly before it expires
+var gcsTokens struct {
+	sync.Mutex
+	keyFile string
+	source  oauth2.TokenSource
+}
+
+// gcsEndpoint returns the base URL of the XML API
+func gcsEndpoint() string {
+	host := os.Getenv("STORAGE_EMULATOR_HOST")
+	if host == "" {
+		return "https://storage.googleapis.com"
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// parseGCSPath splits gs://bucket/object
+func parseGCSPath(path string) (bucket, object string, err error) {
+	rest := path[len("gs://"):]
+	bucket, object, _ = strings.Cut(rest, "/")
+	if bucket == "" || object == "" {
+		return "", "", fmt.Errorf("invalid GCS path %q: expected gs://bucket/object", path)
+	}
+	return bucket, object, nil
+}
+
+// newGCSRequest builds an authorized request for an object
+func newGCSRequest(method, path string, header http.Header) (*http.Request, error) {
+	bucket, object, err := parseGCSPath(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, gcsEndpoint()+"/"+bucket+"/"+encodeObjectPath(object), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	token, err := gcsAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// gcsAccessToken returns a bearer token, or "" for anonymous requests
+func gcsAccessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	keyFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if keyFile == "" || os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		return "", nil
+	}
+
+	gcsTokens.Lock()
+	defer gcsTokens.Unlock()
+	if gcsTokens.source == nil || gcsTokens.keyFile != keyFile {
+		source, err := gcsTokenSource(keyFile)
+		if err != nil {
+			return "", err
+		}
+		gcsTokens.keyFile, gcsTokens.source = keyFile, source
+	}
+	token, err := gcsTokens.source.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// gcsTokenSource reads a service account or gcloud user key file. Other
+// credential types can run programs or reach other services, so they are
+// refused.
+func gcsTokenSource(keyFile string) (oauth2.TokenSource, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Google credentials: %w", err)
+	}
+	var file struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials %s: %w", keyFile, err)
+	}
+	credType := google.CredentialsType(file.Type)
+	if credType != google.ServiceAccount && credType != google.AuthorizedUser {
+		return nil, fmt.Errorf("unsupported Google credentials type %q in %s", file.Type, keyFile)
+	}
+	creds, err := google.CredentialsFromJSONWithType(context.Background(), data, credType, gcsReadScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials %s: %w", keyFile, err)
+	}
+	return creds.TokenSource, nil
+}
+
+// headGCS fetches an object's size, modification time and generation
+func headGCS(path string) (Info, string, error) {
+	req, err := newGCSRequest(http.MethodHead, path, nil)
+	if err != nil {
+		return Info{}, "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Info{}, "", fmt.Errorf("%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, "", objectError("GCS", path, resp)
+	}
+	info := Info{Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, resp.Header.Get("X-Goog-Generation"), nil
+}
+
+// Stat returns an object's size and modification time
+func (gcsBackend) Stat(path string) (Info, error) {
+	info, _, err := headGCS(path)
+	return info, err
+}
+
+// Open opens an object for reading; nothing is downloaded until Read.
+// Every range is pinned to the generation seen at open.
+func (gcsBackend) Open(path string) (File, error) {
+	info, generation, err := headGCS(path)
+	if err != nil {
+		return nil, err
+	}
+	return newRangedFile(path, info.Size, func(start, end int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		header.Set("Range", rangeHeader(start, end))
+		if generation != "" {
+			header.Set("X-Goog-If-Generation-Match", generation)
+		}
+		req, err := newGCSRequest(http.MethodGet, path, header)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return rangeBody("GCS", path, resp)
+	}), nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// httpBackend reads http:// and https:// URLs. Open streams the response
+// body into the scan as it downloads. With a cache directory set
+// (SetHTTPCacheDir), a completed download is kept there and later opens
+// revalidate it with If-Modified-Since / If-None-Match, reading the local
+// copy when the server answers 304 Not Modified.
+type httpBackend struct{}
+
+var httpCache struct {
+	sync.Mutex
+	dir string
+}
+
+// SetHTTPCacheDir sets the directory where downloaded URLs are cached; ""
+// (the default) disables caching
+func SetHTTPCacheDir(dir string) {
+	httpCache.Lock()
+	defer httpCache.Unlock()
+	httpCache.dir = dir
+}
+
+func httpCacheDir() string {
+	httpCache.Lock()
+	defer httpCache.Unlock()
+	return httpCache.dir
+}
+
+// httpCacheEntry is the validator metadata stored beside a cached body
+type httpCacheEntry struct {
+	URL          string `json:"url"`
+	LastModified string `json:"last_modified,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+// httpCachePaths returns where a URL's body and metadata are cached
+func httpCachePaths(dir, url string) (data, meta string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:16])
+	return filepath.Join(dir, name+".data"), filepath.Join(dir, name+".json")
+}
+
+// Stat returns a URL's size (-1 if the server doesn't say) and
+// modification time, from a HEAD request
+func (httpBackend) Stat(url string) (Info, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, objectError("HTTP", url, resp)
+	}
+	info := Info{Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+// Open starts downloading a URL, or opens its cached copy if the server
+// says it hasn't changed
+func (httpBackend) Open(url string) (File, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := httpCacheDir()
+	var dataPath, metaPath string
+	if dir != "" {
+		dataPath, metaPath = httpCachePaths(dir, url)
+		if entry, ok := loadHTTPCacheEntry(metaPath, dataPath); ok {
+			if entry.LastModified != "" {
+				req.Header.Set("If-Modified-Since", entry.LastModified)
+			}
+			if entry.ETag != "" {
+				req.Header.Set("If-None-Match", entry.ETag)
+			}
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return Open(dataPath)
+	case http.StatusOK:
+	default:
+		defer resp.Body.Close()
+		return nil, objectError("HTTP", url, resp)
+	}
+
+	f := &httpFile{
+		url:          url,
+		body:         resp.Body,
+		size:         resp.ContentLength,
+		ranges:       resp.Header.Get("Accept-Ranges") == "bytes" && !resp.Uncompressed,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	if dir != "" && (f.etag != "" || f.lastModified != "") {
+		// Without a validator the copy could never be revalidated
+		f.startCache(dir, dataPath, metaPath)
+	}
+	return f, nil
+}
+
+// loadHTTPCacheEntry reads a cached URL's metadata, if its body is there too
+func loadHTTPCacheEntry(metaPath, dataPath string) (httpCacheEntry, bool) {
+	var entry httpCacheEntry
+	data, err := os.ReadFile(metaPath)
+	if err != nil || json.Unmarshal(data, &entry) != nil {
+		return entry, false
+	}
+	if _, err := os.Stat(dataPath); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+// httpFile streams a URL's response body. Seeking elsewhere than the
+// current position re-requests from there with a Range header, pinned to
+// the first response's ETag or Last-Modified, if the server accepts ranges.
+type httpFile struct {
+	url          string
+	body         io.ReadCloser
+	pos          int64
+	size         int64 // -1 if unknown
+	ranges       bool  // The server accepts byte ranges
+	etag         string
+	lastModified string
+
+	// Cache being written while the body streams; nil if not caching or
+	// abandoned after a seek
+	cacheFile *os.File
+	dataPath  string
+	metaPath  string
+}
+
+// startCache begins copying the body into a temp file in the cache directory
+func (f *httpFile) startCache(dir, dataPath, metaPath string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return // Caching is best effort
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(dataPath)+".*.part")
+	if err != nil {
+		return
+	}
+	f.cacheFile, f.dataPath, f.metaPath = tmp, dataPath, metaPath
+}
+
+// Read returns the next bytes of the body, copying them to the cache
+func (f *httpFile) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	if f.cacheFile != nil && n > 0 {
+		if _, werr := f.cacheFile.Write(p[:n]); werr != nil {
+			f.abandonCache()
+		}
+	}
+	if err == io.EOF {
+		f.finishCache()
+		return n, io.EOF
+	}
+	if err != nil {
+		f.abandonCache()
+		return n, fmt.Errorf("%s: %w", f.url, err)
+	}
+	return n, nil
+}
+
+// finishCache moves a complete download into place with its validators
+func (f *httpFile) finishCache() {
+	if f.cacheFile == nil {
+		return
+	}
+	tmp := f.cacheFile.Name()
+	f.cacheFile.Close()
+	f.cacheFile = nil
+	meta, err := json.Marshal(httpCacheEntry{URL: f.url, LastModified: f.lastModified, ETag: f.etag})
+	if err == nil {
+		err = os.Rename(tmp, f.dataPath)
+	}
+	if err == nil {
+		err = os.WriteFile(f.metaPath, meta, 0644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// abandonCache discards a partial download, e.g. when a LIMIT stops early
+func (f *httpFile) abandonCache() {
+	if f.cacheFile == nil {
+		return
+	}
+	f.cacheFile.Close()
+	os.Remove(f.cacheFile.Name())
+	f.cacheFile = nil
+}
+
+// Seek moves the read position; anywhere but the current position needs
+// a new ranged request
+func (f *httpFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		if f.size < 0 {
+			return 0, fmt.Errorf("%s: size unknown, cannot seek from the end", f.url)
+		}
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%s: negative seek offset", f.url)
+	}
+	if offset == f.pos {
+		return offset, nil
+	}
+	if !f.ranges {
+		return 0, fmt.Errorf("%s: the server doesn't accept range requests, so the download can't skip ahead", f.url)
+	}
+
+	f.abandonCache()
+	f.body.Close()
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	if f.etag != "" {
+		req.Header.Set("If-Match", f.etag)
+	} else if f.lastModified != "" {
+		req.Header.Set("If-Unmodified-Since", f.lastModified)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	body, err := rangeBody("HTTP", f.url, resp)
+	if err != nil {
+		f.body = http.NoBody
+		return 0, err
+	}
+	f.body, f.pos = body, offset
+	return offset, nil
+}
+
+// Size returns the Content-Length of the response, or -1
+func (f *httpFile) Size() int64 {
+	return f.size
+}
+
+// Close stops the download; a partial cache copy is discarded
+func (f *httpFile) Close() error {
+	f.abandonCache()
+	return f.body.Close()
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MappedFile is a local file mapped read-only into memory (see Map). It
+// reads like any File, and Bytes gives the whole file without copying.
+// The mapping is only valid until Close; a file truncated by another
+// process while mapped can crash the program, as with any mmap reader.
+type MappedFile struct {
+	*bytes.Reader
+	data  []byte
+	unmap func() error
+}
+
+// Map maps a local file into memory. An empty file, a remote path or a
+// platform without mmap gives an error; callers fall back to Open.
+func Map(path string) (*MappedFile, error) {
+	if IsRemote(path) {
+		return nil, fmt.Errorf("cannot map remote object %s", path)
+	}
+	return mapFile(path)
+}
+
+// Bytes returns the mapped file's contents, valid until Close
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// Size returns the file's size when it was mapped
+func (m *MappedFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+// Close unmaps the file
+func (m *MappedFile) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.unmap = nil
+	m.data = nil
+	m.Reader.Reset(nil)
+	return err
+}
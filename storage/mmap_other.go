@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// mapFile reports that this platform has no mmap
+func mapFile(path string) (*MappedFile, error) {
+	return nil, errors.New("memory-mapped files are not supported on this platform")
+}
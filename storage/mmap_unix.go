@@ -0,0 +1,37 @@
+//go:build unix
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps a whole file read-only; the descriptor is closed once the
+// mapping exists
+func mapFile(path string) (*MappedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size <= 0 || int64(int(size)) != size || !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("cannot map %s", path)
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", path, err)
+	}
+	return &MappedFile{
+		Reader: bytes.NewReader(data),
+		data:   data,
+		unmap:  func() error { return syscall.Munmap(data) },
+	}, nil
+}
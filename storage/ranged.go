@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Ranged GETs of a remote object start at minChunkSize bytes and double
+// with each sequential read up to maxChunkSize. A scan that stops early
+// (LIMIT) or skips ahead (paging) only downloads the ranges it reads, and
+// a full scan still makes few requests.
+const (
+	minChunkSize = 1024 * 1024
+	maxChunkSize = 16 * 1024 * 1024
+)
+
+// rangeFunc sends a GET for bytes [start, end] of an object, pinned to the
+// version seen at open, and returns the body of a 206 response
+type rangeFunc func(start, end int64) (io.ReadCloser, error)
+
+// rangedFile reads a remote object with ranged GETs of growing size. Every
+// backend pins its ranges to the object version seen at open (an ETag or
+// generation), so an object replaced while being read fails instead of
+// mixing two versions.
+type rangedFile struct {
+	path  string
+	size  int64
+	get   rangeFunc
+	pos   int64         // Offset of the next byte Read returns
+	body  io.ReadCloser // Current range's response body; nil between ranges
+	chunk int64         // Size of the next range
+}
+
+func newRangedFile(path string, size int64, get rangeFunc) *rangedFile {
+	return &rangedFile{path: path, size: size, get: get, chunk: minChunkSize}
+}
+
+// rangeHeader formats an HTTP byte range
+func rangeHeader(start, end int64) string {
+	return "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)
+}
+
+// rangeBody checks a ranged GET's response: the body of a 206, or an
+// error for an object changed since open (412) or any other failure
+func rangeBody(service, path string, resp *http.Response) (io.ReadCloser, error) {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusPreconditionFailed:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s changed while being read", path)
+	default:
+		defer resp.Body.Close()
+		return nil, objectError(service, path, resp)
+	}
+}
+
+// encodeObjectPath percent-encodes an object name for a URL path, keeping
+// the slashes (S3's and Azure's signatures encode each segment once)
+func encodeObjectPath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectError turns a failed response from a storage service into an
+// error, using the XML error body (<Error><Code>, <Message>) most of them
+// send, or the x-ms-error-code header of a bodiless Azure HEAD; 404
+// matches fs.ErrNotExist
+func objectError(service, path string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("%s: %s %s: %s", path, service, body.Code, strings.TrimSpace(body.Message))
+	}
+	if code := resp.Header.Get("X-Ms-Error-Code"); code != "" {
+		return fmt.Errorf("%s: %s %s", path, service, code)
+	}
+	return fmt.Errorf("%s: %s request failed: %s", path, service, resp.Status)
+}
+
+// Read returns the object's bytes, fetching the next range when the
+// current one is used up
+func (f *rangedFile) Read(p []byte) (int, error) {
+	for {
+		if f.pos >= f.size {
+			return 0, io.EOF
+		}
+		if f.body == nil {
+			if err := f.fetch(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := f.body.Read(p)
+		f.pos += int64(n)
+		if err == io.EOF {
+			f.body.Close()
+			f.body = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", f.path, err)
+		}
+		return n, nil
+	}
+}
+
+// fetch starts a ranged GET at the current position
+func (f *rangedFile) fetch() error {
+	end := f.pos + f.chunk - 1
+	if end >= f.size {
+		end = f.size - 1
+	}
+	if f.chunk < maxChunkSize {
+		f.chunk *= 2
+	}
+	body, err := f.get(f.pos, end)
+	if err != nil {
+		return err
+	}
+	f.body = body
+	return nil
+}
+
+// Seek moves the read position; the next Read fetches a new range there
+func (f *rangedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%s: negative seek offset", f.path)
+	}
+	if offset != f.pos {
+		if f.body != nil {
+			f.body.Close()
+			f.body = nil
+		}
+		f.chunk = minChunkSize
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Size returns the object's size
+func (f *rangedFile) Size() int64 {
+	return f.size
+}
+
+// Close stops any download in progress
+func (f *rangedFile) Close() error {
+	if f.body != nil {
+		err := f.body.Close()
+		f.body = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// unsignedPayload skips hashing request bodies; GET and HEAD have none
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Config is the region, credentials and endpoint, read from the standard
+// AWS environment variables
+type s3Config struct {
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	endpoint     string // Custom endpoint (MinIO, LocalStack, ...); path-style addressing
+}
+
+// loadS3Config reads AWS_REGION (or AWS_DEFAULT_REGION, else us-east-1),
+// AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN (requests
+// are unsigned without them, for public buckets) and AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL
+func loadS3Config() s3Config {
+	cfg := s3Config{
+		region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:     strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+	}
+	if cfg.region == "" {
+		cfg.region = "us-east-1"
+	}
+	return cfg
+}
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseS3Path splits s3://bucket/key
+func parseS3Path(path string) (bucket, key string, err error) {
+	rest := path[len("s3://"):]
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 path %q: expected s3://bucket/key", path)
+	}
+	return bucket, key, nil
+}
+
+// objectURL returns the URL of an object: virtual-hosted style on AWS
+// (path-style for bucket names with dots, which break TLS wildcards), and
+// path-style on a custom endpoint
+func (cfg s3Config) objectURL(bucket, key string) string {
+	encodedKey := encodeObjectPath(key)
+	switch {
+	case cfg.endpoint != "":
+		return fmt.Sprintf("%s/%s/%s", cfg.endpoint, bucket, encodedKey)
+	case strings.Contains(bucket, "."):
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", cfg.region, bucket, encodedKey)
+	default:
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, cfg.region, encodedKey)
+	}
+}
+
+// newS3Request builds a signed request for an object
+func (cfg s3Config) newS3Request(method, bucket, key string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, cfg.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if cfg.accessKey != "" {
+		if err := signS3Request(req, cfg, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// s3Signer signs S3 requests. Object keys are already escaped in the URL
+// and S3 wants them escaped only once.
+var s3Signer = v4.NewSigner(func(options *v4.SignerOptions) {
+	options.DisableURIPathEscaping = true
+})
+
+// signS3Request adds AWS Signature Version 4 headers to a request with no
+// body, signing the host, x-amz-* and any Range or If-Match header
+func signS3Request(req *http.Request, cfg s3Config, now time.Time) error {
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	credentials := aws.Credentials{
+		AccessKeyID:     cfg.accessKey,
+		SecretAccessKey: cfg.secretKey,
+		SessionToken:    cfg.sessionToken,
+	}
+	return s3Signer.SignHTTP(context.Background(), credentials, req, req.Header.Get("X-Amz-Content-Sha256"), "s3", cfg.region, now)
+}
+
+// headS3 fetches an object's size, modification time and ETag
+func headS3(path string, cfg s3Config) (Info, string, error) {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return Info{}, "", err
+	}
+	req, err := cfg.newS3Request(http.MethodHead, bucket, key, nil)
+	if err != nil {
+		return Info{}, "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Info{}, "", fmt.Errorf("%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, "", objectError("S3", path, resp)
+	}
+
+	info := Info{Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, resp.Header.Get("ETag"), nil
+}
+
+// s3Backend reads s3://bucket/key objects
+type s3Backend struct{}
+
+// Stat returns an object's size and modification time
+func (s3Backend) Stat(path string) (Info, error) {
+	info, _, err := headS3(path, loadS3Config())
+	return info, err
+}
+
+// Open opens an object for reading; nothing is downloaded until Read.
+// Every range is pinned to the ETag seen at open.
+func (s3Backend) Open(path string) (File, error) {
+	cfg := loadS3Config()
+	info, etag, err := headS3(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key, _ := parseS3Path(path) // Checked by headS3
+	return newRangedFile(path, info.Size, func(start, end int64) (io.ReadCloser, error) {
+		header := http.Header{}
+		header.Set("Range", rangeHeader(start, end))
+		if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		req, err := cfg.newS3Request(http.MethodGet, bucket, key, header)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return rangeBody("S3", path, resp)
+	}), nil
+}
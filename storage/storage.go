@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File is an open data file, local or remote, read sequentially and
+// repositioned with Seek (e.g. to resume a paged scan)
+type File interface {
+	io.ReadSeekCloser
+	Size() int64 // -1 if unknown
+}
+
+// Info describes a file without opening it
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend reads the objects of one URL scheme (s3://, gs://, ...). Open
+// and Stat get the full path, scheme included, and report a missing object
+// with an error matching fs.ErrNotExist.
+type Backend interface {
+	Open(path string) (File, error)
+	Stat(path string) (Info, error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{
+		"s3":    s3Backend{},
+		"gs":    gcsBackend{},
+		"az":    azureBackend{},
+		"http":  httpBackend{},
+		"https": httpBackend{},
+	}
+)
+
+// Register adds or replaces the backend for paths starting scheme://
+func Register(scheme string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[strings.ToLower(scheme)] = backend
+}
+
+// backendFor returns the backend for a path's scheme; ok is false for a
+// local path. A path with an unknown scheme is an error rather than a
+// local file name.
+func backendFor(path string) (backend Backend, ok bool, err error) {
+	scheme, _, found := strings.Cut(path, "://")
+	if !found || strings.ContainsAny(scheme, `/\`) {
+		return nil, false, nil
+	}
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	backend, ok = backends[strings.ToLower(scheme)]
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported storage scheme %s:// in %s", scheme, path)
+	}
+	return backend, true, nil
+}
+
+// IsRemote reports whether a path names an object in remote storage
+// (s3://bucket/key, gs://bucket/key, az://container/blob, https://...)
+// rather than a local file
+func IsRemote(path string) bool {
+	_, ok, err := backendFor(path)
+	return ok || err != nil
+}
+
+// CheckScheme returns an error for a path with a scheme no backend reads
+func CheckScheme(path string) error {
+	_, _, err := backendFor(path)
+	return err
+}
+
+// Open opens a local file or remote object for reading
+func Open(path string) (File, error) {
+	backend, remote, err := backendFor(path)
+	if err != nil {
+		return nil, err
+	}
+	if remote {
+		return backend.Open(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return &localFile{File: file, size: size}, nil
+}
+
+// Stat returns the size and modification time of a local file or remote
+// object. A missing one gives an error matching fs.ErrNotExist.
+func Stat(path string) (Info, error) {
+	backend, remote, err := backendFor(path)
+	if err != nil {
+		return Info{}, err
+	}
+	if remote {
+		return backend.Stat(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// ReadFile reads a whole (small) file, such as a metadata sidecar
+// A missing one gives an error matching fs.ErrNotExist.
+func ReadFile(path string) ([]byte, error) {
+	if !IsRemote(path) {
+		return os.ReadFile(path)
+	}
+	file, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// localFile is an *os.File with its size captured at open
+type localFile struct {
+	*os.File
+	size int64
+}
+
+// Size returns the file's size when it was opened
+func (f *localFile) Size() int64 {
+	return f.size
+}
@@ -0,0 +1,242 @@
+package types
+
+// BatchSize is the most rows a NextBatch call returns
+const BatchSize = 1024
+
+// Vector is one column of a RowBatch
+type Vector interface {
+	// Len returns the number of values, including unselected rows
+	Len() int
+
+	// Value returns the value at position i (nil for NULL)
+	Value(i int) interface{}
+}
+
+// AnyVector is a Vector of boxed values, holding a column of any type
+type AnyVector []interface{}
+
+// Len returns the number of values
+func (v AnyVector) Len() int {
+	return len(v)
+}
+
+// Value returns the value at position i
+func (v AnyVector) Value(i int) interface{} {
+	return v[i]
+}
+
+// Append adds a value
+func (v *AnyVector) Append(value interface{}) {
+	*v = append(*v, value)
+}
+
+// Int64Vector is a column of Int values in one contiguous slice, with
+// NULLs marked in a parallel slice (their Values entry is 0)
+type Int64Vector struct {
+	Values []int64
+	Nulls  []bool
+}
+
+// Len returns the number of values
+func (v *Int64Vector) Len() int {
+	return len(v.Values)
+}
+
+// Value returns the value at position i, boxed
+func (v *Int64Vector) Value(i int) interface{} {
+	if v.Nulls[i] {
+		return nil
+	}
+	return v.Values[i]
+}
+
+// Append adds a value
+func (v *Int64Vector) Append(value int64) {
+	v.Values = append(v.Values, value)
+	v.Nulls = append(v.Nulls, false)
+}
+
+// AppendNull adds a NULL
+func (v *Int64Vector) AppendNull() {
+	v.Values = append(v.Values, 0)
+	v.Nulls = append(v.Nulls, true)
+}
+
+// Reset empties the vector, keeping its arrays
+func (v *Int64Vector) Reset() {
+	v.Values = v.Values[:0]
+	v.Nulls = v.Nulls[:0]
+}
+
+// Float64Vector is a column of Float values; see Int64Vector
+type Float64Vector struct {
+	Values []float64
+	Nulls  []bool
+}
+
+// Len returns the number of values
+func (v *Float64Vector) Len() int {
+	return len(v.Values)
+}
+
+// Value returns the value at position i, boxed
+func (v *Float64Vector) Value(i int) interface{} {
+	if v.Nulls[i] {
+		return nil
+	}
+	return v.Values[i]
+}
+
+// Append adds a value
+func (v *Float64Vector) Append(value float64) {
+	v.Values = append(v.Values, value)
+	v.Nulls = append(v.Nulls, false)
+}
+
+// AppendNull adds a NULL
+func (v *Float64Vector) AppendNull() {
+	v.Values = append(v.Values, 0)
+	v.Nulls = append(v.Nulls, true)
+}
+
+// Reset empties the vector, keeping its arrays
+func (v *Float64Vector) Reset() {
+	v.Values = v.Values[:0]
+	v.Nulls = v.Nulls[:0]
+}
+
+// StringVector is a column of String values stored back to back in Data:
+// value i is Data[Offsets[i]:Offsets[i+1]]. Filling it copies bytes
+// without allocating a string per value.
+type StringVector struct {
+	Data    []byte
+	Offsets []int // Len()+1 entries once a value is added
+	Nulls   []bool
+
+	text    string // Data as a string, made by the first Value call
+	hasText bool
+}
+
+// Len returns the number of values
+func (v *StringVector) Len() int {
+	return len(v.Nulls)
+}
+
+// Bytes returns value i's bytes (empty for NULL), valid until Reset
+func (v *StringVector) Bytes(i int) []byte {
+	return v.Data[v.Offsets[i]:v.Offsets[i+1]]
+}
+
+// Value returns the value at position i as a string. The first call
+// copies Data into one string that the values returned share, so reading
+// a whole column costs one allocation (plus boxing).
+func (v *StringVector) Value(i int) interface{} {
+	if v.Nulls[i] {
+		return nil
+	}
+	if !v.hasText {
+		v.text, v.hasText = string(v.Data), true
+	}
+	return v.text[v.Offsets[i]:v.Offsets[i+1]]
+}
+
+// Append adds a value copied from b
+func (v *StringVector) Append(b []byte) {
+	v.start()
+	v.Data = append(v.Data, b...)
+	v.Offsets = append(v.Offsets, len(v.Data))
+	v.Nulls = append(v.Nulls, false)
+}
+
+// AppendString adds a value
+func (v *StringVector) AppendString(s string) {
+	v.start()
+	v.Data = append(v.Data, s...)
+	v.Offsets = append(v.Offsets, len(v.Data))
+	v.Nulls = append(v.Nulls, false)
+}
+
+// AppendNull adds a NULL
+func (v *StringVector) AppendNull() {
+	v.start()
+	v.Offsets = append(v.Offsets, len(v.Data))
+	v.Nulls = append(v.Nulls, true)
+}
+
+// start adds the first offset and drops the cached text before a value
+// is added
+func (v *StringVector) start() {
+	if len(v.Offsets) == 0 {
+		v.Offsets = append(v.Offsets, 0)
+	}
+	v.text, v.hasText = "", false
+}
+
+// Reset empties the vector, keeping its arrays
+func (v *StringVector) Reset() {
+	v.Data = v.Data[:0]
+	v.Offsets = v.Offsets[:0]
+	v.Nulls = v.Nulls[:0]
+	v.text, v.hasText = "", false
+}
+
+// RowBatch is up to BatchSize rows stored column by column. A filter
+// drops rows by listing the ones it keeps in Selection rather than moving
+// values. A batch belongs to the operator that returned it and is only
+// valid until that operator's next NextBatch call.
+type RowBatch struct {
+	Columns   []Vector // One per schema column, each Length long
+	Length    int      // Rows in each column
+	Selection []int    // Positions of the rows in the batch, ascending; nil means all Length rows
+}
+
+// Rows returns the number of rows in the batch (the selected ones)
+func (b *RowBatch) Rows() int {
+	if b.Selection != nil {
+		return len(b.Selection)
+	}
+	return b.Length
+}
+
+// Position returns the column position of the batch's k-th row
+func (b *RowBatch) Position(k int) int {
+	if b.Selection != nil {
+		return b.Selection[k]
+	}
+	return k
+}
+
+// Row copies the values at column position i into row, reusing its
+// Values slice
+func (b *RowBatch) Row(i int, row *Row) {
+	if cap(row.Values) < len(b.Columns) {
+		row.Values = make([]interface{}, len(b.Columns))
+	}
+	row.Values = row.Values[:len(b.Columns)]
+	for j, column := range b.Columns {
+		row.Values[j] = column.Value(i)
+	}
+}
+
+// RowColumns is Row for only the given columns, for an expression that
+// reads no others. Row's other values are left as they are (NULL in a
+// fresh row).
+func (b *RowBatch) RowColumns(i int, row *Row, columns []int) {
+	if len(row.Values) != len(b.Columns) {
+		row.Values = make([]interface{}, len(b.Columns))
+	}
+	for _, j := range columns {
+		row.Values[j] = b.Columns[j].Value(i)
+	}
+}
+
+// BatchOperator is implemented by operators that can also return their
+// rows a batch at a time, saving a call and a row allocation per row. A
+// consumer uses either Next or NextBatch on an operator, never both.
+type BatchOperator interface {
+	Operator
+
+	// NextBatch returns the next batch, or (nil, nil) when exhausted. A
+	// batch may hold no rows (e.g. all filtered out) before the end.
+	NextBatch() (*RowBatch, error)
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Rows are recycled through a pool so that a steady stream of them (a scan
+// feeding a filter, an aggregate or the output) stops allocating a Row and
+// a values slice per row. A producer takes rows with GetRow; whichever
+// operator ends up owning a row and is done with it (e.g. a filter
+// dropping it, or the output after printing it) hands it back with
+// ReleaseRow. Rows not made by GetRow are never reused, so operators that
+// return rows they keep (such as ValuesOp) are unaffected.
+var rowPool = sync.Pool{
+	New: func() interface{} {
+		rowPoolStats.news.Add(1)
+		return &Row{pooled: true}
+	},
+}
+
+var rowPoolStats struct {
+	gets atomic.Int64
+	news atomic.Int64
+}
+
+// GetRow returns a row of n NULL values, reusing a released row when there
+// is one. The caller owns it until it is returned or released.
+func GetRow(n int) *Row {
+	rowPoolStats.gets.Add(1)
+	row := rowPool.Get().(*Row)
+	if cap(row.Values) < n {
+		row.Values = make([]interface{}, n)
+	} else {
+		row.Values = row.Values[:n]
+	}
+	return row
+}
+
+// ReleaseRow hands a row from GetRow back for reuse; other rows are left
+// alone. The caller must own the row and not use it (or its Values)
+// afterwards.
+func ReleaseRow(row *Row) {
+	if row == nil || !row.pooled {
+		return
+	}
+	clear(row.Values[:cap(row.Values)]) // Don't keep the values alive, and hand out NULLs
+	row.Values = row.Values[:0]
+	rowPool.Put(row)
+}
+
+// RowPoolStats reports how many rows GetRow has returned since the process
+// started, and how many of those had to be allocated rather than reused
+func RowPoolStats() (gets, allocated int64) {
+	return rowPoolStats.gets.Load(), rowPoolStats.news.Load()
+}
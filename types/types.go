@@ -1,6 +1,11 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
 
 // DataType represents the type of a column value
 type DataType int
@@ -24,6 +29,20 @@ func (dt DataType) String() string {
 	}
 }
 
+// ParseDataType converts a type name (Int, Float, String; any case) to a DataType
+func ParseDataType(name string) (DataType, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "int", "integer", "bigint":
+		return Int, nil
+	case "float", "double", "real":
+		return Float, nil
+	case "string", "text", "varchar":
+		return String, nil
+	default:
+		return 0, fmt.Errorf("unknown data type: %s", name)
+	}
+}
+
 // Schema describes the structure of a row
 type Schema struct {
 	Columns []string   // Column names
@@ -40,9 +59,116 @@ func (s Schema) ColumnIndex(name string) int {
 	return -1
 }
 
+// ColumnIndexRelaxed resolves a column name as ResolveColumn does with
+// NormalizedColumnNames. Returns -1 if not found or ambiguous.
+//
+// Deprecated: use ResolveColumn, which reports ambiguous names.
+func (s Schema) ColumnIndexRelaxed(name string) int {
+	idx, err := s.ResolveColumn(name, NormalizedColumnNames)
+	if err != nil {
+		return -1
+	}
+	return idx
+}
+
+// ColumnNameMode is how column references are matched to a schema's names
+type ColumnNameMode int
+
+const (
+	ExactColumnNames           ColumnNameMode = iota // Byte for byte
+	CaseInsensitiveColumnNames                       // Ignoring case
+	NormalizedColumnNames                            // Ignoring case and surrounding whitespace, with runs of spaces, _ and - alike
+)
+
+func (m ColumnNameMode) String() string {
+	switch m {
+	case CaseInsensitiveColumnNames:
+		return "case-insensitive"
+	case NormalizedColumnNames:
+		return "normalized"
+	default:
+		return "exact"
+	}
+}
+
+// ParseColumnNameMode converts a mode name (exact, case-insensitive or
+// normalized; any case) to a ColumnNameMode; "" means exact
+func ParseColumnNameMode(name string) (ColumnNameMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "exact":
+		return ExactColumnNames, nil
+	case "case-insensitive", "ci":
+		return CaseInsensitiveColumnNames, nil
+	case "normalized", "normalize":
+		return NormalizedColumnNames, nil
+	default:
+		return 0, fmt.Errorf("unknown column name mode %q (use exact, case-insensitive or normalized)", name)
+	}
+}
+
+// normalizeColumnName folds a name's case, trims it and turns each run of
+// spaces, underscores and hyphens inside it into one underscore, so
+// "Order ID", "order_id" and " order-id " are alike
+func normalizeColumnName(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == '-' || unicode.IsSpace(r)
+	})
+	return strings.Join(fields, "_")
+}
+
+// AmbiguousColumnError is returned by ResolveColumn when a name matches
+// several columns and none exactly
+type AmbiguousColumnError struct {
+	Name    string
+	Matches []string // The columns it matches, in schema order
+}
+
+func (e *AmbiguousColumnError) Error() string {
+	quoted := make([]string, len(e.Matches))
+	for i, match := range e.Matches {
+		quoted[i] = strconv.Quote(match)
+	}
+	return fmt.Sprintf("ambiguous column name %s: matches %s", e.Name, strings.Join(quoted, ", "))
+}
+
+// ResolveColumn returns the index of the column a name refers to under a
+// mode, or -1 if none. An exact match always wins; otherwise a name that
+// matches more than one column fails with an *AmbiguousColumnError.
+func (s Schema) ResolveColumn(name string, mode ColumnNameMode) (int, error) {
+	if idx := s.ColumnIndex(name); idx >= 0 || mode == ExactColumnNames {
+		return idx, nil
+	}
+	matches := func(col string) bool { return strings.EqualFold(col, name) }
+	if mode == NormalizedColumnNames {
+		target := normalizeColumnName(name)
+		matches = func(col string) bool { return normalizeColumnName(col) == target }
+	}
+	found := -1
+	var ambiguous []string
+	for i, col := range s.Columns {
+		if !matches(col) {
+			continue
+		}
+		if found >= 0 {
+			if ambiguous == nil {
+				ambiguous = []string{s.Columns[found]}
+			}
+			ambiguous = append(ambiguous, col)
+			continue
+		}
+		found = i
+	}
+	if ambiguous != nil {
+		return -1, &AmbiguousColumnError{Name: name, Matches: ambiguous}
+	}
+	return found, nil
+}
+
 // Row represents a single row of data
 type Row struct {
 	Values []interface{}
+
+	pooled bool // Came from GetRow, so ReleaseRow may reuse it
 }
 
 // GetInt returns the integer value at the given index
@@ -128,6 +254,82 @@ func (c Comparator) String() string {
 	}
 }
 
+// Negate returns the comparator that matches exactly the non-NULL values
+// this comparator rejects (e.g. < becomes >=)
+func (c Comparator) Negate() Comparator {
+	switch c {
+	case Eq:
+		return Neq
+	case Lt:
+		return Gte
+	case Gt:
+		return Lte
+	case Lte:
+		return Gt
+	case Gte:
+		return Lt
+	case Neq:
+		return Eq
+	default:
+		return c
+	}
+}
+
+// Truth is a SQL three-valued logic value
+// Comparisons involving NULL evaluate to Unknown rather than False
+type Truth int
+
+const (
+	False Truth = iota
+	True
+	Unknown
+)
+
+func (t Truth) String() string {
+	switch t {
+	case False:
+		return "FALSE"
+	case True:
+		return "TRUE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// And combines two truth values: False wins, then Unknown
+func (t Truth) And(other Truth) Truth {
+	if t == False || other == False {
+		return False
+	}
+	if t == Unknown || other == Unknown {
+		return Unknown
+	}
+	return True
+}
+
+// Or combines two truth values: True wins, then Unknown
+func (t Truth) Or(other Truth) Truth {
+	if t == True || other == True {
+		return True
+	}
+	if t == Unknown || other == Unknown {
+		return Unknown
+	}
+	return False
+}
+
+// Not negates a truth value; NOT Unknown is still Unknown
+func (t Truth) Not() Truth {
+	switch t {
+	case True:
+		return False
+	case False:
+		return True
+	default:
+		return Unknown
+	}
+}
+
 // AggregateType defines aggregation functions
 type AggregateType int
 
@@ -137,6 +339,8 @@ const (
 	Min
 	Max
 	Avg
+	LatestBy   // Value from the row with the greatest ordering value
+	ApproxTopK // Most frequent values, from a bounded-memory sketch
 )
 
 func (a AggregateType) String() string {
@@ -151,8 +355,39 @@ func (a AggregateType) String() string {
 		return "MAX"
 	case Avg:
 		return "AVG"
+	case LatestBy:
+		return "LATEST_BY"
+	case ApproxTopK:
+		return "APPROX_TOP_K"
 	default:
 		return "?"
 	}
 }
 
+// ArithmeticOp defines arithmetic operators for scalar expressions
+type ArithmeticOp int
+
+const (
+	Add ArithmeticOp = iota
+	Sub
+	Mul
+	Div
+	Mod
+)
+
+func (a ArithmeticOp) String() string {
+	switch a {
+	case Add:
+		return "+"
+	case Sub:
+		return "-"
+	case Mul:
+		return "*"
+	case Div:
+		return "/"
+	case Mod:
+		return "%"
+	default:
+		return "?"
+	}
+}